@@ -0,0 +1,51 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gae
+
+import (
+	"time"
+
+	"go.chromium.org/gae/service/datastore"
+
+	"golang.org/x/net/context"
+)
+
+// Detach returns a Context which has all of the services, filters and other
+// values installed in c, but none of its deadline or cancellation, and which
+// is outside of any transaction that c is in.
+//
+// It's intended for goroutines which outlive the request that started them:
+// using c directly would cancel their work when the request finishes, and, if
+// c is transactional, make their writes part of a transaction which may have
+// already committed or been rolled back.
+//
+// Note that on the classic App Engine runtime (impl/prod), service calls made
+// after the request has finished fail regardless.
+func Detach(c context.Context) context.Context {
+	if datastore.Raw(c) != nil {
+		c = datastore.WithoutTransaction(c)
+	}
+	return detachedContext{c}
+}
+
+// detachedContext is a Context with the values of the embedded Context, but
+// which is never cancelled.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
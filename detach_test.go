@@ -0,0 +1,76 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gae
+
+import (
+	"errors"
+	"testing"
+
+	"go.chromium.org/gae/impl/memory"
+	"go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/info"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDetach(t *testing.T) {
+	t.Parallel()
+
+	Convey("Detach", t, func() {
+		c, cancel := context.WithCancel(memory.Use(context.Background()))
+		c, err := info.Namespace(c, "ns")
+		So(err, ShouldBeNil)
+		datastore.GetTestable(c).Consistent(true)
+
+		type Thing struct {
+			ID int64 `gae:"$id"`
+		}
+
+		Convey("keeps services, but not cancellation", func() {
+			d := Detach(c)
+			cancel()
+			So(c.Err(), ShouldEqual, context.Canceled)
+			So(d.Err(), ShouldBeNil)
+			So(d.Done(), ShouldBeNil)
+
+			So(info.GetNamespace(d), ShouldEqual, "ns")
+			So(datastore.Put(d, &Thing{ID: 1}), ShouldBeNil)
+			So(datastore.Get(d, &Thing{ID: 1}), ShouldBeNil)
+		})
+
+		Convey("leaves transactions", func() {
+			var d context.Context
+			err := datastore.RunInTransaction(c, func(c context.Context) error {
+				d = Detach(c)
+				So(datastore.CurrentTransaction(d), ShouldBeNil)
+				So(datastore.Put(d, &Thing{ID: 2}), ShouldBeNil)
+				return errors.New("rollback")
+			}, nil)
+			So(err, ShouldNotBeNil)
+
+			// The write made with the detached context wasn't rolled back.
+			So(datastore.Get(c, &Thing{ID: 2}), ShouldBeNil)
+			So(datastore.Put(d, &Thing{ID: 3}), ShouldBeNil)
+		})
+
+		Convey("works without a datastore", func() {
+			d := Detach(context.Background())
+			So(d.Err(), ShouldBeNil)
+			So(Installed(d), ShouldBeEmpty)
+		})
+	})
+}
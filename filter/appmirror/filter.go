@@ -0,0 +1,190 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package appmirror implements a datastore filter which rewrites the app IDs
+// of key-valued properties, for mirroring entities between apps (or Cloud
+// projects).
+//
+// Entities copied from another app, e.g. from a production app into
+// a staging one, still refer to the entities of their original app in their
+// key-valued properties. When they're written through the filter installed by
+// FilterRDS, those keys are rewritten according to its mapping of app IDs, so
+// that they refer to the mirrored entities instead. Key values in query
+// filters are rewritten the same way, so queries for the original keys find
+// the mirrored entities.
+//
+// The rewrite is one-way: entities which are read are returned as they're
+// stored. The keys of the entities themselves are never rewritten, since they
+// must be keys of the app the Context targets (see datastore.WithAppID).
+package appmirror
+
+import (
+	ds "go.chromium.org/gae/service/datastore"
+
+	"golang.org/x/net/context"
+)
+
+type mirrorRDS struct {
+	ds.RawInterface
+
+	mapping map[string]string
+}
+
+// mapProperty returns p with its app ID mapped if it's a key of a mapped app,
+// and whether it was mapped.
+func (m *mirrorRDS) mapProperty(p ds.Property) (ds.Property, bool) {
+	if p.Type() != ds.PTKey {
+		return p, false
+	}
+	k := p.Value().(*ds.Key)
+	to, ok := m.mapping[k.AppID()]
+	if !ok || to == k.AppID() {
+		return p, false
+	}
+	ret := ds.Property{}
+	// errs can't happen, since it's a *Key.
+	_ = ret.SetValue(k.WithAppID(to), p.IndexSetting())
+	return ret, true
+}
+
+// mapPropertyMap returns pm with its key values mapped. pm itself is never
+// modified, and is returned as-is if none of its values are mapped.
+func (m *mirrorRDS) mapPropertyMap(pm ds.PropertyMap) ds.PropertyMap {
+	ret, copied := pm, false
+	for name, pdata := range pm {
+		var mapped ds.PropertyData
+		switch t := pdata.(type) {
+		case ds.Property:
+			if p, ok := m.mapProperty(t); ok {
+				mapped = p
+			}
+		case ds.PropertySlice:
+			var slice ds.PropertySlice
+			for i := range t {
+				if p, ok := m.mapProperty(t[i]); ok {
+					if slice == nil {
+						slice = append(ds.PropertySlice(nil), t...)
+					}
+					slice[i] = p
+				}
+			}
+			if slice != nil {
+				mapped = slice
+			}
+		}
+		if mapped == nil {
+			continue
+		}
+		if !copied {
+			ret, copied = make(ds.PropertyMap, len(pm)), true
+			for k, v := range pm {
+				ret[k] = v
+			}
+		}
+		ret[name] = mapped
+	}
+	return ret
+}
+
+// mapQuery returns fq with the key values of its filters mapped, or fq itself
+// if none of them are mapped.
+func (m *mirrorRDS) mapQuery(fq *ds.FinalizedQuery) (*ds.FinalizedQuery, error) {
+	mapped := false
+	mapValue := func(p ds.Property) interface{} {
+		p, ok := m.mapProperty(p)
+		mapped = mapped || ok
+		return p.Value()
+	}
+
+	q := fq.Original().ClearFilters()
+	for field, vals := range fq.EqFilters() {
+		if field == "__ancestor__" {
+			continue
+		}
+		args := make([]interface{}, len(vals))
+		for i, v := range vals {
+			args[i] = mapValue(v)
+		}
+		q = q.Eq(field, args...)
+	}
+	switch field, op, val := fq.IneqFilterLow(); op {
+	case ">":
+		q = q.Gt(field, mapValue(val))
+	case ">=":
+		q = q.Gte(field, mapValue(val))
+	}
+	switch field, op, val := fq.IneqFilterHigh(); op {
+	case "<":
+		q = q.Lt(field, mapValue(val))
+	case "<=":
+		q = q.Lte(field, mapValue(val))
+	}
+
+	if !mapped {
+		return fq, nil
+	}
+	return q.Finalize()
+}
+
+func (m *mirrorRDS) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.NewKeyCB) error {
+	mapped := make([]ds.PropertyMap, len(vals))
+	for i, pm := range vals {
+		mapped[i] = m.mapPropertyMap(pm)
+	}
+	return m.RawInterface.PutMulti(keys, mapped, cb)
+}
+
+func (m *mirrorRDS) Mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	mapped := make([]ds.RawMutation, len(muts))
+	for i, mut := range muts {
+		mapped[i] = mut
+		if mut.Value != nil {
+			mapped[i].Value = m.mapPropertyMap(mut.Value)
+		}
+	}
+	return m.RawInterface.Mutate(mapped, cb)
+}
+
+func (m *mirrorRDS) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	fq, err := m.mapQuery(fq)
+	if err != nil {
+		return err
+	}
+	return m.RawInterface.Run(fq, cb)
+}
+
+func (m *mirrorRDS) Count(fq *ds.FinalizedQuery) (int64, error) {
+	fq, err := m.mapQuery(fq)
+	if err != nil {
+		return 0, err
+	}
+	return m.RawInterface.Count(fq)
+}
+
+func (m *mirrorRDS) Aggregate(fq *ds.FinalizedQuery, aggs []*ds.Aggregation) (ds.AggregationResult, error) {
+	fq, err := m.mapQuery(fq)
+	if err != nil {
+		return nil, err
+	}
+	return m.RawInterface.Aggregate(fq, aggs)
+}
+
+// FilterRDS installs a datastore filter in c which rewrites the keys in
+// property values and query filters whose app ID is a key of mapping to keys
+// of the corresponding app.
+func FilterRDS(c context.Context, mapping map[string]string) context.Context {
+	return ds.AddRawFilters(c, func(ic context.Context, inner ds.RawInterface) ds.RawInterface {
+		return &mirrorRDS{inner, mapping}
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appmirror
+
+import (
+	"testing"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type doc struct {
+	ID     int64   `gae:"$id"`
+	Author *ds.Key `gae:",noindex"`
+	Refs   []*ds.Key
+	Name   string
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test app ID rewriting filter", t, func() {
+		raw := memory.Use(context.Background())
+		ds.GetTestable(raw).Consistent(true)
+		c := FilterRDS(raw, map[string]string{"s~prod": "dev~app"})
+
+		prod := ds.MkKeyContext("s~prod", "")
+		local := ds.GetKeyContext(raw)
+
+		d := &doc{
+			ID:     11,
+			Author: prod.MakeKey("User", 1),
+			Refs:   []*ds.Key{prod.MakeKey("Doc", 2), ds.MkKeyContext("s~other", "").MakeKey("Doc", 3)},
+			Name:   "a",
+		}
+		So(ds.Put(c, d), ShouldBeNil)
+
+		Convey("rewrites keys in written values", func() {
+			got := &doc{ID: 11}
+			So(ds.Get(raw, got), ShouldBeNil)
+			So(got.Author, ShouldResemble, local.MakeKey("User", 1))
+			So(got.Refs, ShouldResemble, []*ds.Key{
+				local.MakeKey("Doc", 2),
+				ds.MkKeyContext("s~other", "").MakeKey("Doc", 3),
+			})
+			So(got.Name, ShouldEqual, "a")
+
+			// The written entity isn't modified.
+			So(d.Author, ShouldResemble, prod.MakeKey("User", 1))
+		})
+
+		Convey("rewrites keys in mutations", func() {
+			d.ID = 12
+			So(ds.Mutate(c, ds.NewInsert(d)), ShouldBeNil)
+			got := &doc{ID: 12}
+			So(ds.Get(raw, got), ShouldBeNil)
+			So(got.Refs[0], ShouldResemble, local.MakeKey("Doc", 2))
+		})
+
+		Convey("rewrites keys in query filters", func() {
+			q := ds.NewQuery("doc").Eq("Refs", prod.MakeKey("Doc", 2))
+			n, err := ds.Count(c, q)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+
+			fq, err := q.Finalize()
+			So(err, ShouldBeNil)
+			mapped, err := (&mirrorRDS{mapping: map[string]string{"s~prod": "dev~app"}}).mapQuery(fq)
+			So(err, ShouldBeNil)
+			So(mapped.EqFilters()["Refs"][0].Value(), ShouldResemble, local.MakeKey("Doc", 2))
+
+			var docs []*doc
+			So(ds.GetAll(c, ds.NewQuery("doc").Gte("Refs", prod.MakeKey("Doc", 2)), &docs), ShouldBeNil)
+			So(docs, ShouldHaveLength, 1)
+
+			// Queries without keys are left alone.
+			docs = nil
+			So(ds.GetAll(c, ds.NewQuery("doc").Eq("Name", "a"), &docs), ShouldBeNil)
+			So(docs, ShouldHaveLength, 1)
+		})
+	})
+}
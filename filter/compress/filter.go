@@ -0,0 +1,133 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package compress implements a memcache filter which transparently compresses
+// large values.
+//
+// Values of at least the threshold size are compressed with "compress/zlib"
+// when they are written, as long as that makes them smaller, and are marked
+// with the CompressedFlag item flag. Marked values are decompressed when they
+// are read, and the flag is removed, so callers (including filter/dscache)
+// see the values and flags that they wrote.
+//
+// Values written without the filter are read as-is, so it can be installed in
+// an application which already has data in memcache. However, values written
+// with the filter can only be read correctly with it installed.
+package compress
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+
+	mc "go.chromium.org/gae/service/memcache"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultThreshold is the threshold used by FilterMC when threshold is 0.
+const DefaultThreshold = 1024
+
+// CompressedFlag is the memcache item flag which marks compressed values.
+//
+// Callers must not use this flag themselves.
+const CompressedFlag uint32 = 1 << 31
+
+type compressMC struct {
+	mc.RawInterface
+
+	threshold int
+}
+
+// compress returns a copy of itm with its value compressed, or itm itself if
+// it's too small to be compressed or doesn't get any smaller.
+func (m *compressMC) compress(itm mc.Item) mc.Item {
+	if itm == nil || len(itm.Value()) < m.threshold {
+		return itm
+	}
+
+	buf := bytes.Buffer{}
+	w := zlib.NewWriter(&buf)
+	// errs can't happen, since we're using a byte buffer.
+	_, _ = w.Write(itm.Value())
+	_ = w.Close()
+	if buf.Len() >= len(itm.Value()) {
+		return itm
+	}
+
+	ret := m.RawInterface.NewItem(itm.Key())
+	ret.SetAll(itm)
+	return ret.SetValue(buf.Bytes()).SetFlags(itm.Flags() | CompressedFlag)
+}
+
+func (m *compressMC) compressAll(items []mc.Item) []mc.Item {
+	ret := make([]mc.Item, len(items))
+	for i, itm := range items {
+		ret[i] = m.compress(itm)
+	}
+	return ret
+}
+
+// decompress decompresses the value of itm in place, if it's compressed.
+func decompress(itm mc.Item) error {
+	if itm.Flags()&CompressedFlag == 0 {
+		return nil
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(itm.Value()))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	itm.SetValue(data).SetFlags(itm.Flags() &^ CompressedFlag)
+	return nil
+}
+
+func (m *compressMC) AddMulti(items []mc.Item, cb mc.RawCB) error {
+	return m.RawInterface.AddMulti(m.compressAll(items), cb)
+}
+
+func (m *compressMC) SetMulti(items []mc.Item, cb mc.RawCB) error {
+	return m.RawInterface.SetMulti(m.compressAll(items), cb)
+}
+
+func (m *compressMC) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
+	return m.RawInterface.CompareAndSwapMulti(m.compressAll(items), cb)
+}
+
+func (m *compressMC) GetMulti(keys []string, cb mc.RawItemCB) error {
+	return m.RawInterface.GetMulti(keys, func(itm mc.Item, err error) {
+		if err == nil {
+			if err = decompress(itm); err != nil {
+				itm = nil
+			}
+		}
+		cb(itm, err)
+	})
+}
+
+// FilterMC installs a memcache filter in c which compresses values of at least
+// threshold bytes. If threshold is 0, DefaultThreshold is used.
+func FilterMC(c context.Context, threshold int) context.Context {
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	return mc.AddRawFilters(c, func(ic context.Context, inner mc.RawInterface) mc.RawInterface {
+		return &compressMC{inner, threshold}
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"go.chromium.org/gae/impl/memory"
+	mc "go.chromium.org/gae/service/memcache"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompress(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test memcache compression filter", t, func() {
+		raw := memory.Use(context.Background())
+		c := FilterMC(raw, 100)
+
+		big := bytes.Repeat([]byte("compressible "), 100)
+
+		Convey("compresses large values", func() {
+			itm := mc.NewItem(c, "big").SetValue(big).SetFlags(3)
+			So(mc.Set(c, itm), ShouldBeNil)
+			So(itm.Value(), ShouldResemble, big)
+			So(itm.Flags(), ShouldEqual, 3)
+
+			rawItm, err := mc.GetKey(raw, "big")
+			So(err, ShouldBeNil)
+			So(len(rawItm.Value()), ShouldBeLessThan, len(big))
+			So(rawItm.Flags(), ShouldEqual, 3|CompressedFlag)
+
+			got, err := mc.GetKey(c, "big")
+			So(err, ShouldBeNil)
+			So(got.Value(), ShouldResemble, big)
+			So(got.Flags(), ShouldEqual, 3)
+
+			Convey("and supports CompareAndSwap", func() {
+				got.SetValue(append(got.Value(), big...))
+				So(mc.CompareAndSwap(c, got), ShouldBeNil)
+
+				got, err := mc.GetKey(c, "big")
+				So(err, ShouldBeNil)
+				So(len(got.Value()), ShouldEqual, 2*len(big))
+
+				So(mc.CompareAndSwap(c, itm), ShouldEqual, mc.ErrCASConflict)
+			})
+		})
+
+		Convey("leaves small and incompressible values alone", func() {
+			So(mc.Set(c, mc.NewItem(c, "small").SetValue([]byte("small"))), ShouldBeNil)
+
+			random := make([]byte, 200)
+			rand.New(rand.NewSource(0)).Read(random)
+			So(mc.Add(c, mc.NewItem(c, "random").SetValue(random)), ShouldBeNil)
+
+			for _, key := range []string{"small", "random"} {
+				rawItm, err := mc.GetKey(raw, key)
+				So(err, ShouldBeNil)
+				So(rawItm.Flags(), ShouldEqual, 0)
+			}
+		})
+
+		Convey("reads uncompressed values written without the filter", func() {
+			So(mc.Set(raw, mc.NewItem(raw, "old").SetValue(big)), ShouldBeNil)
+			got, err := mc.GetKey(c, "old")
+			So(err, ShouldBeNil)
+			So(got.Value(), ShouldResemble, big)
+		})
+
+		Convey("fails to read corrupt values", func() {
+			So(mc.Set(raw, mc.NewItem(raw, "bad").SetValue([]byte("nope")).SetFlags(CompressedFlag)), ShouldBeNil)
+			_, err := mc.GetKey(c, "bad")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -15,6 +15,8 @@
 package count
 
 import (
+	"time"
+
 	"golang.org/x/net/context"
 
 	mc "go.chromium.org/gae/service/memcache"
@@ -29,6 +31,7 @@ type MCCounter struct {
 	DeleteMulti         Entry
 	CompareAndSwapMulti Entry
 	Increment           Entry
+	Touch               Entry
 	Flush               Entry
 	Stats               Entry
 }
@@ -73,6 +76,10 @@ func (m *mcCounter) Increment(key string, delta int64, initialValue *uint64) (ne
 	return ret, m.c.Increment.up(err)
 }
 
+func (m *mcCounter) Touch(key string, expiration time.Duration) error {
+	return m.c.Touch.up(m.mc.Touch(key, expiration))
+}
+
 func (m *mcCounter) Stats() (*mc.Statistics, error) {
 	ret, err := m.mc.Stats()
 	return ret, m.c.Stats.up(err)
@@ -27,6 +27,7 @@ type ModuleCounter struct {
 	SetNumInstances Entry
 	Versions        Entry
 	DefaultVersion  Entry
+	Hostname        Entry
 	Start           Entry
 	Stop            Entry
 }
@@ -63,6 +64,11 @@ func (m *modCounter) DefaultVersion(mod string) (string, error) {
 	return ret, m.c.DefaultVersion.up(err)
 }
 
+func (m *modCounter) Hostname(mod, ver, inst string) (string, error) {
+	ret, err := m.mod.Hostname(mod, ver, inst)
+	return ret, m.c.Hostname.up(err)
+}
+
 func (m *modCounter) Start(mod, ver string) error {
 	return m.c.Start.up(m.mod.Start(mod, ver))
 }
@@ -71,6 +77,10 @@ func (m *modCounter) Stop(mod, ver string) error {
 	return m.c.Stop.up(m.mod.Stop(mod, ver))
 }
 
+func (m *modCounter) GetTestable() module.Testable {
+	return m.mod.GetTestable()
+}
+
 // FilterModule installs a counter Module filter in the context.
 func FilterModule(c context.Context) (context.Context, *ModuleCounter) {
 	state := &ModuleCounter{}
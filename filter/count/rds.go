@@ -30,6 +30,9 @@ type DSCounter struct {
 	DeleteMulti      Entry
 	GetMulti         Entry
 	PutMulti         Entry
+	Mutate           Entry
+	ReserveIDRange   Entry
+	Aggregate        Entry
 }
 
 type dsCounter struct {
@@ -74,6 +77,19 @@ func (r *dsCounter) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.NewKey
 	return r.c.PutMulti.upFilterStop(r.ds.PutMulti(keys, vals, cb))
 }
 
+func (r *dsCounter) Mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	return r.c.Mutate.upFilterStop(r.ds.Mutate(muts, cb))
+}
+
+func (r *dsCounter) ReserveIDRange(key *ds.Key, start, end int64) error {
+	return r.c.ReserveIDRange.up(r.ds.ReserveIDRange(key, start, end))
+}
+
+func (r *dsCounter) Aggregate(q *ds.FinalizedQuery, aggs []*ds.Aggregation) (ds.AggregationResult, error) {
+	res, err := r.ds.Aggregate(q, aggs)
+	return res, r.c.Aggregate.up(err)
+}
+
 func (r *dsCounter) CurrentTransaction() ds.Transaction {
 	return r.ds.CurrentTransaction()
 }
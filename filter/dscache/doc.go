@@ -135,7 +135,8 @@
 //   - It does NOT provide in-memory ("per-request") caching.
 //   - It's INtolerant of some memcache failures, but in exchange will not return
 //     inconsistent results. See DANGER ZONE for details.
-//   - Queries do not interact with the cache at all.
+//   - Queries do not interact with the cache at all. GetAll can fetch the
+//     results of a query through the cache with datastore.WithKeysThenValues.
 //   - Negative lookups (e.g. ErrNoSuchEntity) are cached.
 //
 // DANGER ZONE
@@ -46,7 +46,19 @@ func (d *dsCache) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.NewKeyCB
 	})
 }
 
+func (d *dsCache) Mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	return d.mutation(mutationKeys(muts), func() error {
+		return d.RawInterface.Mutate(muts, cb)
+	})
+}
+
 func (d *dsCache) GetMulti(keys []*ds.Key, metas ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	// Reads at a read time see past versions of the entities, which aren't
+	// cached.
+	if !ds.GetReadTime(d.c).IsZero() {
+		return d.RawInterface.GetMulti(keys, metas, cb)
+	}
+
 	lockItems, nonce := d.mkRandLockItems(keys, metas)
 	if len(lockItems) == 0 {
 		return d.RawInterface.GetMulti(keys, metas, cb)
@@ -79,6 +91,10 @@ func (d *dsCache) GetMulti(keys []*ds.Key, metas ds.MultiMetaGetter, cb ds.GetMu
 			// false: lock entity in memcache forever
 			shouldSave := true
 			if err == nil {
+				if ds.PropertyMapsReusable(d.c) {
+					// pm is passed to cb after this returns, so it must be copied.
+					pm, _ = pm.Save(true)
+				}
 				p.decoded[i] = pm
 				if toSave != nil {
 					data = encodeItemValue(pm)
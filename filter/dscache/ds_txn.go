@@ -38,5 +38,10 @@ func (d *dsTxnCache) PutMulti(keys []*ds.Key, metas []ds.PropertyMap, cb ds.NewK
 	return d.RawInterface.PutMulti(keys, metas, cb)
 }
 
+func (d *dsTxnCache) Mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	d.state.add(d.sc, mutationKeys(muts))
+	return d.RawInterface.Mutate(muts, cb)
+}
+
 // TODO(riannucci): on GetAll, Load from memcache and invalidate entries if the
 // memcache version doesn't match the datastore version.
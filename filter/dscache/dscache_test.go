@@ -160,6 +160,23 @@ func TestDSCache(t *testing.T) {
 				})
 			})
 
+			Convey("GetAll can fetch query results through the cache", func() {
+				ds.GetTestable(c).Consistent(true)
+				So(ds.Put(c, &object{ID: 1, Value: "hi"}), ShouldBeNil)
+				key := ds.NewKey(c, "object", "", 1, nil)
+
+				var objs []object
+				So(ds.GetAll(c, ds.NewQuery("object"), &objs), ShouldBeNil)
+				_, err := mc.GetKey(c, MakeMemcacheKey(0, key))
+				So(err, ShouldEqual, mc.ErrCacheMiss)
+
+				objs = nil
+				So(ds.GetAll(ds.WithKeysThenValues(c, true), ds.NewQuery("object"), &objs), ShouldBeNil)
+				So(objs, ShouldResemble, []object{{ID: 1, Value: "hi", BigData: []byte{}}})
+				_, err = mc.GetKey(c, MakeMemcacheKey(0, key))
+				So(err, ShouldBeNil)
+			})
+
 			Convey("compression works", func() {
 				o := object{ID: 2, Value: `¯\_(ツ)_/¯`}
 				data := make([]byte, 4000)
@@ -415,6 +432,19 @@ func TestDSCache(t *testing.T) {
 					So(sc.numShards(ds.KeyForObj(c, &shardObj{ID: 9001})), ShouldEqual, MaxShards)
 				})
 
+				Convey("keys in different databases have different memcache keys", func() {
+					kc := ds.GetKeyContext(c)
+					def := kc.MakeKey("object", 1)
+					kc.Database = "a"
+					a := kc.MakeKey("object", 1)
+					kc.Database = "b"
+					b := kc.MakeKey("object", 1)
+
+					So(HashKey(a), ShouldNotEqual, HashKey(b))
+					So(HashKey(a), ShouldNotEqual, HashKey(def))
+					So(MakeMemcacheKey(0, a), ShouldNotEqual, MakeMemcacheKey(0, b))
+				})
+
 				Convey("CompressionType.String", func() {
 					So(NoCompression.String(), ShouldEqual, "NoCompression")
 					So(ZlibCompression.String(), ShouldEqual, "ZlibCompression")
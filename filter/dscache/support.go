@@ -161,3 +161,12 @@ func (s *supportContext) generateNonce() []byte {
 	_, _ = s.mr.Read(nonce) // This Read will always return len(nonce), nil.
 	return nonce
 }
+
+// mutationKeys returns the keys written or deleted by muts.
+func mutationKeys(muts []ds.RawMutation) []*ds.Key {
+	ret := make([]*ds.Key, len(muts))
+	for i, m := range muts {
+		ret[i] = m.Key
+	}
+	return ret
+}
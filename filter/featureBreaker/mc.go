@@ -15,6 +15,8 @@
 package featureBreaker
 
 import (
+	"time"
+
 	"golang.org/x/net/context"
 
 	mc "go.chromium.org/gae/service/memcache"
@@ -62,6 +64,10 @@ func (m *mcState) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
 	return m.run(m.c, func() error { return m.RawInterface.CompareAndSwapMulti(items, cb) })
 }
 
+func (m *mcState) Touch(key string, expiration time.Duration) error {
+	return m.run(m.c, func() error { return m.RawInterface.Touch(key, expiration) })
+}
+
 func (m *mcState) Flush() error {
 	return m.run(m.c, m.RawInterface.Flush)
 }
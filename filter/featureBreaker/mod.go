@@ -65,6 +65,14 @@ func (m *modState) DefaultVersion(mod string) (ret string, err error) {
 	return
 }
 
+func (m *modState) Hostname(mod, ver, inst string) (ret string, err error) {
+	err = m.run(m.c, func() (err error) {
+		ret, err = m.RawInterface.Hostname(mod, ver, inst)
+		return
+	})
+	return
+}
+
 func (m *modState) Start(mod, ver string) error {
 	return m.run(m.c, func() (err error) {
 		return m.RawInterface.Start(mod, ver)
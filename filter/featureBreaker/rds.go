@@ -31,6 +31,9 @@ var DatastoreFeatures = []string{
 	"DeleteMulti",
 	"GetMulti",
 	"PutMulti",
+	"Mutate",
+	"ReserveIDRange",
+	"Aggregate",
 }
 
 type dsState struct {
@@ -49,6 +52,20 @@ func (r *dsState) AllocateIDs(keys []*ds.Key, cb ds.NewKeyCB) error {
 	})
 }
 
+func (r *dsState) ReserveIDRange(key *ds.Key, start, end int64) error {
+	return r.run(r.c, func() error {
+		return r.rds.ReserveIDRange(key, start, end)
+	})
+}
+
+func (r *dsState) Aggregate(q *ds.FinalizedQuery, aggs []*ds.Aggregation) (res ds.AggregationResult, err error) {
+	err = r.run(r.c, func() (err error) {
+		res, err = r.rds.Aggregate(q, aggs)
+		return
+	})
+	return
+}
+
 func (r *dsState) DecodeCursor(s string) (ds.Cursor, error) {
 	curs := ds.Cursor(nil)
 	err := r.run(r.c, func() (err error) {
@@ -138,6 +155,15 @@ func (r *dsState) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.NewKeyCB
 	})
 }
 
+func (r *dsState) Mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	if len(muts) == 0 {
+		return nil
+	}
+	return r.run(r.c, func() error {
+		return r.rds.Mutate(muts, cb)
+	})
+}
+
 func (r *dsState) WithoutTransaction() context.Context {
 	return r.rds.WithoutTransaction()
 }
@@ -0,0 +1,182 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nsflush implements a memcache filter which allows flushing the
+// memcache of a single namespace.
+//
+// memcache.Flush clears the memcache of the whole application. With this
+// filter installed, every key is prefixed with the generation number of the
+// current namespace, which is itself stored in memcache. Flush increments the
+// generation number, so all of the keys written before it are no longer
+// visible, and are eventually evicted.
+//
+// If the generation number is evicted, it's recreated from the current time,
+// so that it's very unlikely to match a previous generation.
+package nsflush
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	mc "go.chromium.org/gae/service/memcache"
+
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// GenerationKey is the memcache key of the generation number of each
+// namespace. It's not prefixed by the filter when it's incremented, so that
+// Flush can increment it through the filter.
+const GenerationKey = "gae:nsflush:generation"
+
+type nsflushMC struct {
+	mc.RawInterface
+
+	c context.Context
+
+	// prefix is the key prefix of the current generation, loaded lazily.
+	prefix string
+}
+
+// getPrefix returns the key prefix of the current generation.
+func (m *nsflushMC) getPrefix() (string, error) {
+	if m.prefix == "" {
+		gen, err := generation(m.c, m.RawInterface, 0)
+		if err != nil {
+			return "", err
+		}
+		m.prefix = strconv.FormatUint(gen, 10) + ":"
+	}
+	return m.prefix, nil
+}
+
+// generation adds delta to the generation number of the namespace of inner,
+// and returns it.
+func generation(c context.Context, inner mc.RawInterface, delta int64) (uint64, error) {
+	initial := uint64(clock.Now(c).UnixNano() / int64(time.Microsecond))
+	return inner.Increment(GenerationKey, delta, &initial)
+}
+
+func (m *nsflushMC) keys(keys []string) ([]string, error) {
+	prefix, err := m.getPrefix()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, len(keys))
+	for i, k := range keys {
+		ret[i] = prefix + k
+	}
+	return ret, nil
+}
+
+func (m *nsflushMC) items(items []mc.Item) ([]mc.Item, error) {
+	prefix, err := m.getPrefix()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]mc.Item, len(items))
+	for i, itm := range items {
+		if itm != nil {
+			ret[i] = m.RawInterface.NewItem(prefix + itm.Key())
+			ret[i].SetAll(itm)
+		}
+	}
+	return ret, nil
+}
+
+func (m *nsflushMC) AddMulti(items []mc.Item, cb mc.RawCB) error {
+	items, err := m.items(items)
+	if err != nil {
+		return err
+	}
+	return m.RawInterface.AddMulti(items, cb)
+}
+
+func (m *nsflushMC) SetMulti(items []mc.Item, cb mc.RawCB) error {
+	items, err := m.items(items)
+	if err != nil {
+		return err
+	}
+	return m.RawInterface.SetMulti(items, cb)
+}
+
+func (m *nsflushMC) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
+	items, err := m.items(items)
+	if err != nil {
+		return err
+	}
+	return m.RawInterface.CompareAndSwapMulti(items, cb)
+}
+
+func (m *nsflushMC) GetMulti(keys []string, cb mc.RawItemCB) error {
+	keys, err := m.keys(keys)
+	if err != nil {
+		return err
+	}
+	return m.RawInterface.GetMulti(keys, func(itm mc.Item, err error) {
+		if itm != nil {
+			itm.SetKey(strings.TrimPrefix(itm.Key(), m.prefix))
+		}
+		cb(itm, err)
+	})
+}
+
+func (m *nsflushMC) DeleteMulti(keys []string, cb mc.RawCB) error {
+	keys, err := m.keys(keys)
+	if err != nil {
+		return err
+	}
+	return m.RawInterface.DeleteMulti(keys, cb)
+}
+
+func (m *nsflushMC) Increment(key string, delta int64, initialValue *uint64) (uint64, error) {
+	if key == GenerationKey {
+		m.prefix = ""
+		return m.RawInterface.Increment(key, delta, initialValue)
+	}
+	prefix, err := m.getPrefix()
+	if err != nil {
+		return 0, err
+	}
+	return m.RawInterface.Increment(prefix+key, delta, initialValue)
+}
+
+func (m *nsflushMC) Touch(key string, expiration time.Duration) error {
+	prefix, err := m.getPrefix()
+	if err != nil {
+		return err
+	}
+	return m.RawInterface.Touch(prefix+key, expiration)
+}
+
+// FilterMC installs the namespace flushing memcache filter in c.
+func FilterMC(c context.Context) context.Context {
+	return mc.AddRawFilters(c, func(ic context.Context, inner mc.RawInterface) mc.RawInterface {
+		return &nsflushMC{RawInterface: inner, c: ic}
+	})
+}
+
+// Flush makes all of the memcache items of the current namespace in c, which
+// were written through the filter, invisible through it.
+//
+// Unlike memcache.Flush, items of other namespaces are unaffected.
+func Flush(c context.Context) error {
+	if _, err := generation(c, mc.Raw(c), 1); err != nil {
+		return errors.Annotate(err, "failed to increment the generation").Err()
+	}
+	return nil
+}
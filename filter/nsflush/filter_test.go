@@ -0,0 +1,90 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nsflush
+
+import (
+	"testing"
+
+	"go.chromium.org/gae/filter/count"
+	"go.chromium.org/gae/impl/memory"
+	"go.chromium.org/gae/service/info"
+	mc "go.chromium.org/gae/service/memcache"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNSFlush(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test namespace flushing memcache filter", t, func() {
+		c := FilterMC(memory.Use(context.Background()))
+		other := info.MustNamespace(c, "other")
+
+		for _, ic := range []context.Context{c, other} {
+			So(mc.Set(ic, mc.NewItem(ic, "key").SetValue([]byte("value"))), ShouldBeNil)
+		}
+
+		itm, err := mc.GetKey(c, "key")
+		So(err, ShouldBeNil)
+		So(itm.Key(), ShouldEqual, "key")
+		So(itm.Value(), ShouldResemble, []byte("value"))
+
+		Convey("flushes a single namespace", func() {
+			So(Flush(c), ShouldBeNil)
+
+			_, err := mc.GetKey(c, "key")
+			So(err, ShouldEqual, mc.ErrCacheMiss)
+			_, err = mc.GetKey(other, "key")
+			So(err, ShouldBeNil)
+
+			So(mc.Add(c, mc.NewItem(c, "key").SetValue([]byte("new"))), ShouldBeNil)
+			itm, err := mc.GetKey(c, "key")
+			So(err, ShouldBeNil)
+			So(itm.Value(), ShouldResemble, []byte("new"))
+		})
+
+		Convey("supports CompareAndSwap, Increment and Delete", func() {
+			itm.SetValue([]byte("swapped"))
+			So(mc.CompareAndSwap(c, itm), ShouldBeNil)
+			itm, err := mc.GetKey(c, "key")
+			So(err, ShouldBeNil)
+			So(itm.Value(), ShouldResemble, []byte("swapped"))
+
+			v, err := mc.Increment(c, "counter", 1, 10)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 11)
+
+			So(mc.Delete(c, "key"), ShouldBeNil)
+			So(Flush(c), ShouldBeNil)
+			_, err = mc.IncrementExisting(c, "counter", 1)
+			So(err, ShouldEqual, mc.ErrCacheMiss)
+		})
+
+		Convey("hides the generation", func() {
+			_, err := mc.GetKey(c, GenerationKey)
+			So(err, ShouldEqual, mc.ErrCacheMiss)
+		})
+
+		Convey("works under other filters", func() {
+			cc, cnt := count.FilterMC(c)
+			So(Flush(cc), ShouldBeNil)
+			So(cnt.Increment.Successes(), ShouldEqual, 1)
+			_, err := mc.GetKey(cc, "key")
+			So(err, ShouldEqual, mc.ErrCacheMiss)
+		})
+	})
+}
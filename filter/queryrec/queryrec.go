@@ -0,0 +1,132 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package queryrec implements a datastore filter that records every query
+// executed through it, so that tests can assert on the queries which some
+// code makes.
+//
+// This is intended to catch changes which silently add (possibly expensive)
+// queries. A test can either compare the recorded queries to a list of GQL
+// strings, or to a golden file checked in next to it:
+//
+//	c, rec := queryrec.FilterRDS(memory.Use(context.Background()))
+//	doStuff(c)
+//	if err := rec.Golden("testdata/do_stuff.queries", *update); err != nil {
+//	  t.Fatal(err)
+//	}
+package queryrec
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	ds "go.chromium.org/gae/service/datastore"
+)
+
+// Op is the datastore operation which executed a query.
+type Op string
+
+// The operations which execute queries.
+const (
+	Run       Op = "Run"
+	Count     Op = "Count"
+	Aggregate Op = "Aggregate"
+)
+
+// Entry is a single recorded query.
+type Entry struct {
+	Op    Op
+	Query *ds.FinalizedQuery
+}
+
+// String returns the Op and the GQL form of the query, e.g.
+// "Run: SELECT * FROM `Post` ORDER BY `__key__` LIMIT 10".
+func (e Entry) String() string {
+	return fmt.Sprintf("%s: %s", e.Op, e.Query.GQL())
+}
+
+// Recorder holds the queries recorded by the filter installed by FilterRDS.
+// It's safe for concurrent use.
+type Recorder struct {
+	lock    sync.Mutex
+	entries []Entry
+}
+
+func (r *Recorder) record(op Op, q *ds.FinalizedQuery) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries = append(r.entries, Entry{op, q})
+}
+
+// Entries returns the recorded queries, in the order that they were executed.
+// Queries are recorded whether or not they succeeded.
+func (r *Recorder) Entries() []Entry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Entry(nil), r.entries...)
+}
+
+// GQL returns the String of each recorded Entry, in order.
+func (r *Recorder) GQL() []string {
+	ents := r.Entries()
+	ret := make([]string, len(ents))
+	for i, e := range ents {
+		ret[i] = e.String()
+	}
+	return ret
+}
+
+// Reset forgets all of the recorded queries.
+func (r *Recorder) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries = nil
+}
+
+// String returns the recorded queries, one Entry per line.
+func (r *Recorder) String() string {
+	buf := bytes.Buffer{}
+	for _, s := range r.GQL() {
+		fmt.Fprintln(&buf, s)
+	}
+	return buf.String()
+}
+
+// Golden compares the recorded queries (as returned by String) to the contents
+// of the golden file at path, and returns an error describing the difference
+// if they don't match.
+//
+// If update is true, the golden file is instead (re)written with the recorded
+// queries. Tests usually wire this to a command line flag.
+func (r *Recorder) Golden(path string, update bool) error {
+	got := r.String()
+	if update {
+		return ioutil.WriteFile(path, []byte(got), 0644)
+	}
+
+	want, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		want = nil
+	case err != nil:
+		return err
+	}
+	if got != string(want) {
+		return fmt.Errorf("queryrec: queries don't match golden file %q:\nwant:\n%sgot:\n%s", path, want, got)
+	}
+	return nil
+}
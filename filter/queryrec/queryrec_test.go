@@ -0,0 +1,82 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryrec
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestQueryRecorder(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test query recorder filter", t, func() {
+		c, rec := FilterRDS(memory.Use(context.Background()))
+		ds.GetTestable(c).Consistent(true)
+
+		So(ds.Put(c, ds.PropertyMap{
+			"$key": ds.MkPropertyNI(ds.MakeKey(c, "Post", 1)),
+			"Tag":  ds.MkProperty("a"),
+		}), ShouldBeNil)
+
+		So(ds.Run(c, ds.NewQuery("Post").Eq("Tag", "a").Limit(10), func(*ds.Key) {}), ShouldBeNil)
+		_, err := ds.Count(c, ds.NewQuery("Post").Ancestor(ds.MakeKey(c, "Post", 1)))
+		So(err, ShouldBeNil)
+		So(ds.RunInTransaction(c, func(c context.Context) error {
+			return ds.Run(c, ds.NewQuery("Post").Ancestor(ds.MakeKey(c, "Post", 1)), func(ds.PropertyMap) {})
+		}, nil), ShouldBeNil)
+
+		Convey("records queries", func() {
+			So(rec.GQL(), ShouldResemble, []string{
+				"Run: SELECT __key__ FROM `Post` WHERE `Tag` = \"a\" ORDER BY `__key__` LIMIT 10",
+				"Count: SELECT * FROM `Post` WHERE __key__ HAS ANCESTOR KEY(DATASET(\"dev~app\"), \"Post\", 1) ORDER BY `__key__`",
+				"Run: SELECT * FROM `Post` WHERE __key__ HAS ANCESTOR KEY(DATASET(\"dev~app\"), \"Post\", 1) ORDER BY `__key__`",
+			})
+			So(rec.Entries()[1].Op, ShouldEqual, Count)
+
+			rec.Reset()
+			So(rec.Entries(), ShouldBeEmpty)
+			So(rec.String(), ShouldEqual, "")
+		})
+
+		Convey("compares to golden files", func() {
+			dir, err := ioutil.TempDir("", "gae-queryrec")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "golden.queries")
+
+			So(rec.Golden(path, false), ShouldErrLike, "queries don't match golden file")
+			So(rec.Golden(path, true), ShouldBeNil)
+			So(rec.Golden(path, false), ShouldBeNil)
+
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, rec.String())
+
+			So(ds.Run(c, ds.NewQuery("Post"), func(*ds.Key) {}), ShouldBeNil)
+			So(rec.Golden(path, false), ShouldErrLike, "Run: SELECT __key__ FROM `Post` ORDER BY `__key__`\n")
+		})
+	})
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryrec
+
+import (
+	"golang.org/x/net/context"
+
+	ds "go.chromium.org/gae/service/datastore"
+)
+
+type queryRecorder struct {
+	ds.RawInterface
+
+	r *Recorder
+}
+
+var _ ds.RawInterface = (*queryRecorder)(nil)
+
+func (q *queryRecorder) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	q.r.record(Run, fq)
+	return q.RawInterface.Run(fq, cb)
+}
+
+func (q *queryRecorder) Count(fq *ds.FinalizedQuery) (int64, error) {
+	q.r.record(Count, fq)
+	return q.RawInterface.Count(fq)
+}
+
+func (q *queryRecorder) Aggregate(fq *ds.FinalizedQuery, aggs []*ds.Aggregation) (ds.AggregationResult, error) {
+	q.r.record(Aggregate, fq)
+	return q.RawInterface.Aggregate(fq, aggs)
+}
+
+// FilterRDS installs a query recording datastore filter in the context. The
+// returned Recorder holds the queries executed through it, including those run
+// in transactions.
+func FilterRDS(c context.Context) (context.Context, *Recorder) {
+	r := &Recorder{}
+	return ds.AddRawFilters(c, func(ic context.Context, rds ds.RawInterface) ds.RawInterface {
+		return &queryRecorder{rds, r}
+	}), r
+}
@@ -89,6 +89,13 @@ func (r *readOnlyDatastore) AllocateIDs(keys []*ds.Key, cb ds.NewKeyCB) error {
 	})
 }
 
+func (r *readOnlyDatastore) ReserveIDRange(key *ds.Key, start, end int64) error {
+	if r.isRO == nil || r.isRO(key) {
+		return ErrReadOnly
+	}
+	return r.RawInterface.ReserveIDRange(key, start, end)
+}
+
 func (r *readOnlyDatastore) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
 	impl := func(mutable []int, cb perKeyCB) error {
 		mutableKeys := make([]*ds.Key, len(mutable))
@@ -119,6 +126,36 @@ func (r *readOnlyDatastore) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb d
 	})
 }
 
+func (r *readOnlyDatastore) Mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	// Mutations are applied all together or not at all, so if any of them is
+	// read-only, none of them can be applied.
+	denied := false
+	if r.isRO == nil {
+		denied = true
+	} else {
+		for _, m := range muts {
+			if r.isRO(m.Key) {
+				denied = true
+				break
+			}
+		}
+	}
+	if !denied {
+		return r.RawInterface.Mutate(muts, cb)
+	}
+
+	for i, m := range muts {
+		var err error
+		if r.isRO == nil || r.isRO(m.Key) {
+			err = ErrReadOnly
+		}
+		if err := cb(i, m.Key, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Predicate is a user-supplied function that examines a key and returns true if
 // it should be treated as read-only.
 type Predicate func(*ds.Key) (isReadOnly bool)
@@ -47,6 +47,10 @@ func (d *dsTxnBuf) AllocateIDs(keys []*ds.Key, cb ds.NewKeyCB) error {
 	return d.state.parentDS.AllocateIDs(keys, cb)
 }
 
+func (d *dsTxnBuf) ReserveIDRange(key *ds.Key, start, end int64) error {
+	return d.state.parentDS.ReserveIDRange(key, start, end)
+}
+
 func (d *dsTxnBuf) GetMulti(keys []*ds.Key, metas ds.MultiMetaGetter, cb ds.GetMultiCB) error {
 	return d.state.getMulti(keys, metas, cb, d.haveLock)
 }
@@ -59,6 +63,11 @@ func (d *dsTxnBuf) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
 	return d.state.deleteMulti(keys, cb, d.haveLock)
 }
 
+func (d *dsTxnBuf) Mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	// The buffered transaction makes this atomic.
+	return ds.ApplyMutations(d, muts, cb)
+}
+
 func (d *dsTxnBuf) Count(fq *ds.FinalizedQuery) (count int64, err error) {
 	// Unfortunately there's no fast-path here. We literally have to run the
 	// query and count. Fortunately we can optimize to count keys if it's not
@@ -76,6 +85,11 @@ func (d *dsTxnBuf) Count(fq *ds.FinalizedQuery) (count int64, err error) {
 	return
 }
 
+func (d *dsTxnBuf) Aggregate(fq *ds.FinalizedQuery, aggs []*ds.Aggregation) (ds.AggregationResult, error) {
+	// Like Count, this has to run the query to merge in the buffered entities.
+	return ds.AggregateByScan(d, fq, aggs)
+}
+
 func (d *dsTxnBuf) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
 	if start, end := fq.Bounds(); start != nil || end != nil {
 		return errors.New("txnBuf filter does not support query cursors")
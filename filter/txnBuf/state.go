@@ -130,6 +130,19 @@ type txnBufState struct {
 	writeCountBudget int
 }
 
+var _ datastore.CommitInfoTransaction = (*txnBufState)(nil)
+
+// CommitInfo implements datastore.CommitInfoTransaction.
+//
+// A buffered transaction is committed as part of its parent transaction, so
+// this reports the parent's CommitInfo (if it has any).
+func (t *txnBufState) CommitInfo() *datastore.CommitInfo {
+	if p, ok := t.parentDS.CurrentTransaction().(datastore.CommitInfoTransaction); ok {
+		return p.CommitInfo()
+	}
+	return nil
+}
+
 func withTxnBuf(ctx context.Context, cb func(context.Context) error, opts *datastore.TransactionOptions) error {
 	parentState, _ := ctx.Value(&dsTxnBufParent).(*txnBufState)
 	roots := stringset.New(0)
@@ -256,6 +256,44 @@ func TestTransactionBuffers(t *testing.T) {
 				So(3, fooShouldHave(c), 10, 20, 30, 40)
 			})
 
+			Convey("mutations see buffered writes", func() {
+				So(ds.RunInTransaction(c, func(c context.Context) error {
+					So(100, fooSetTo(c), 1)
+					So(ds.Mutate(c,
+						ds.NewInsert(&Foo{ID: 100}),
+						ds.NewUpdate(&Foo{ID: 101}),
+					), ShouldResemble, errors.NewMultiError(ds.ErrEntityExists, ds.ErrNoSuchEntity))
+
+					So(ds.Mutate(c,
+						ds.NewUpdate(&Foo{ID: 100, Value: []int64{2}}),
+						ds.NewDelete(&Foo{ID: 3}),
+					), ShouldBeNil)
+					So(100, fooShouldHave(c), 2)
+					So(3, fooShouldHave(c))
+					return nil
+				}, &ds.TransactionOptions{XG: true}), ShouldBeNil)
+
+				So(100, fooShouldHave(c), 2)
+				So(3, fooShouldHave(c))
+			})
+
+			Convey("commit info comes from the outermost transaction", func() {
+				var inner *ds.CommitInfo
+				outer, err := ds.RunInTransactionWithInfo(c, func(c context.Context) error {
+					var err error
+					inner, err = ds.RunInTransactionWithInfo(c, func(c context.Context) error {
+						So(1, fooSetTo(c), 2)
+						return nil
+					}, nil)
+					So(err, ShouldBeNil)
+					So(inner, ShouldBeNil)
+					return nil
+				}, nil)
+				So(err, ShouldBeNil)
+				So(outer, ShouldNotBeNil)
+				So(outer.ID, ShouldNotEqual, "")
+			})
+
 			Convey("can allocate IDs from an inner transaction", func() {
 				nums := []int64{4, 8, 15, 16, 23, 42}
 				k := (*ds.Key)(nil)
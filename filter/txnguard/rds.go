@@ -0,0 +1,151 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package txnguard
+
+import (
+	"golang.org/x/net/context"
+
+	ds "go.chromium.org/gae/service/datastore"
+)
+
+type guard struct {
+	ds.RawInterface
+
+	o  Options
+	st *txnState
+}
+
+var _ ds.RawInterface = (*guard)(nil)
+
+func (g *guard) AllocateIDs(keys []*ds.Key, cb ds.NewKeyCB) error {
+	exit, err := g.st.enter(g)
+	if err != nil {
+		return err
+	}
+	defer exit()
+	return g.RawInterface.AllocateIDs(keys, cb)
+}
+
+func (g *guard) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	exit, err := g.st.enter(g)
+	if err != nil {
+		return err
+	}
+
+	err = g.RawInterface.Run(q, func(k *ds.Key, pm ds.PropertyMap, gc ds.CursorCB) error {
+		// Let cb make calls in this transaction, while the query is paused.
+		exit()
+		err := cb(k, pm, gc)
+
+		var enterErr error
+		if exit, enterErr = g.st.enter(g); enterErr != nil {
+			exit = func() {}
+			if err == nil {
+				err = enterErr
+			}
+		}
+		return err
+	})
+	exit()
+	return err
+}
+
+func (g *guard) Count(q *ds.FinalizedQuery) (int64, error) {
+	exit, err := g.st.enter(g)
+	if err != nil {
+		return 0, err
+	}
+	defer exit()
+	return g.RawInterface.Count(q)
+}
+
+func (g *guard) Aggregate(q *ds.FinalizedQuery, aggs []*ds.Aggregation) (ds.AggregationResult, error) {
+	exit, err := g.st.enter(g)
+	if err != nil {
+		return nil, err
+	}
+	defer exit()
+	return g.RawInterface.Aggregate(q, aggs)
+}
+
+func (g *guard) GetMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	exit, err := g.st.enter(g)
+	if err != nil {
+		return err
+	}
+	defer exit()
+	return g.RawInterface.GetMulti(keys, meta, cb)
+}
+
+func (g *guard) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.NewKeyCB) error {
+	exit, err := g.st.enter(g)
+	if err != nil {
+		return err
+	}
+	defer exit()
+	return g.RawInterface.PutMulti(keys, vals, cb)
+}
+
+func (g *guard) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+	exit, err := g.st.enter(g)
+	if err != nil {
+		return err
+	}
+	defer exit()
+	return g.RawInterface.DeleteMulti(keys, cb)
+}
+
+func (g *guard) Mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	exit, err := g.st.enter(g)
+	if err != nil {
+		return err
+	}
+	defer exit()
+	return g.RawInterface.Mutate(muts, cb)
+}
+
+func (g *guard) RunInTransaction(f func(context.Context) error, opts *ds.TransactionOptions) error {
+	if g.st != nil {
+		if g.st.isFinished() {
+			return ErrUsedAfterTransaction
+		}
+		if !g.o.AllowNested {
+			return ErrNestedTransaction
+		}
+	}
+
+	return g.RawInterface.RunInTransaction(func(c context.Context) error {
+		// Each attempt is a separate transaction.
+		st := &txnState{}
+		defer st.finish()
+		return f(context.WithValue(c, &txnStateKey, st))
+	}, opts)
+}
+
+func (g *guard) WithoutTransaction() context.Context {
+	return context.WithValue(g.RawInterface.WithoutTransaction(), &txnStateKey, (*txnState)(nil))
+}
+
+// FilterRDS installs a transaction misuse guard datastore filter in the
+// context. If o is nil, the default Options are used.
+func FilterRDS(c context.Context, o *Options) context.Context {
+	if o == nil {
+		o = &Options{}
+	}
+	opts := *o
+	return ds.AddRawFilters(c, func(ic context.Context, rds ds.RawInterface) ds.RawInterface {
+		return &guard{rds, opts, getTxnState(ic)}
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package txnguard implements a datastore filter that detects common misuses
+// of transactions, which otherwise show up as corrupt state or obscure errors
+// from the datastore implementation:
+//
+//   - using a transactional Context after its transaction has finished, e.g.
+//     from a goroutine started in the transaction;
+//   - using a transactional Context from several goroutines at once;
+//   - starting a transaction within a transaction.
+//
+// Misuses are reported as errors from the datastore call which made them. The
+// filter is intended for tests and development servers, in a "strict mode":
+//
+//	c = txnguard.FilterRDS(memory.Use(c), nil)
+//
+// Concurrent use is detected as overlapping calls, so it's detected reliably
+// only if the calls are slow enough to overlap. Calls made from the callback
+// of a query on the same transaction are allowed, as are the parallel batches
+// a single Get, Put or Delete is split into (see datastore.WithBatching).
+package txnguard
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrUsedAfterTransaction is returned when a transactional Context is used
+	// after its transaction has finished.
+	ErrUsedAfterTransaction = errors.New("txnguard: transactional context used after its transaction finished")
+
+	// ErrConcurrentUse is returned when a transactional Context is used while
+	// another call on the same transaction is in progress.
+	ErrConcurrentUse = errors.New("txnguard: transactional context used concurrently")
+
+	// ErrNestedTransaction is returned when a transaction is started within
+	// a transaction, unless Options.AllowNested is set.
+	ErrNestedTransaction = errors.New("txnguard: nested transactions are not supported")
+)
+
+// Options are the options for FilterRDS.
+type Options struct {
+	// AllowNested allows RunInTransaction to be called within a transaction,
+	// for datastore implementations (or filters, like txnBuf) that support
+	// nested transactions.
+	AllowNested bool
+}
+
+var txnStateKey = "gae:txnguard:txnState"
+
+// txnState is the state of a single attempt of a transaction.
+type txnState struct {
+	sync.Mutex
+
+	finished bool
+
+	// owner is the guard making the calls in progress, and calls is their
+	// number. A single top-level datastore call uses a single guard, possibly
+	// from several goroutines when it's split into batches.
+	owner *guard
+	calls int
+}
+
+func getTxnState(c context.Context) *txnState {
+	st, _ := c.Value(&txnStateKey).(*txnState)
+	return st
+}
+
+// enter checks that g can make a call in this transaction, and marks it as in
+// progress until the returned function is called.
+//
+// A nil *txnState is outside of any transaction, and allows everything.
+func (st *txnState) enter(g *guard) (exit func(), err error) {
+	if st == nil {
+		return func() {}, nil
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	switch {
+	case st.finished:
+		return nil, ErrUsedAfterTransaction
+	case st.calls > 0 && st.owner != g:
+		return nil, ErrConcurrentUse
+	}
+	st.owner = g
+	st.calls++
+	return func() {
+		st.Lock()
+		defer st.Unlock()
+		st.calls--
+	}, nil
+}
+
+func (st *txnState) isFinished() bool {
+	st.Lock()
+	defer st.Unlock()
+	return st.finished
+}
+
+func (st *txnState) finish() {
+	st.Lock()
+	defer st.Unlock()
+	st.finished = true
+}
@@ -0,0 +1,163 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package txnguard
+
+import (
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// blockingRDS blocks GetMulti of "Slow" entities until release is closed.
+type blockingRDS struct {
+	ds.RawInterface
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingRDS) GetMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	if keys[0].Kind() == "Slow" {
+		close(b.started)
+		<-b.release
+	}
+	return b.RawInterface.GetMulti(keys, meta, cb)
+}
+
+// latentRDS makes GetMulti slow, so that concurrent calls overlap.
+type latentRDS struct {
+	ds.RawInterface
+}
+
+func (l *latentRDS) GetMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	time.Sleep(5 * time.Millisecond)
+	return l.RawInterface.GetMulti(keys, meta, cb)
+}
+
+func TestTxnGuard(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test transaction guard filter", t, func() {
+		c := memory.Use(context.Background())
+		started, release := make(chan struct{}), make(chan struct{})
+		c = ds.AddRawFilters(c, func(_ context.Context, rds ds.RawInterface) ds.RawInterface {
+			return &blockingRDS{rds, started, release}
+		})
+		c = FilterRDS(c, nil)
+
+		pm := func(id int64) ds.PropertyMap {
+			return ds.PropertyMap{
+				"$key":  ds.MkPropertyNI(ds.MakeKey(c, "Thing", id)),
+				"Value": ds.MkProperty(id),
+			}
+		}
+		So(ds.Put(c, pm(1)), ShouldBeNil)
+
+		Convey("allows correct use", func() {
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				So(ds.Get(c, pm(1)), ShouldBeNil)
+				return ds.Put(c, pm(2))
+			}, &ds.TransactionOptions{XG: true}), ShouldBeNil)
+		})
+
+		Convey("allows calls from the callback of a query", func() {
+			ds.GetTestable(c).CatchupIndexes()
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				q := ds.NewQuery("Thing").Ancestor(ds.MakeKey(c, "Thing", 1))
+				return ds.Run(c, q, func(k *ds.Key) error {
+					return ds.Get(c, ds.PropertyMap{"$key": ds.MkPropertyNI(k)})
+				})
+			}, nil), ShouldBeNil)
+		})
+
+		Convey("detects use after the transaction", func() {
+			var tc context.Context
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				tc = c
+				return nil
+			}, nil), ShouldBeNil)
+
+			So(ds.Put(tc, pm(2)), ShouldEqual, ErrUsedAfterTransaction)
+			So(ds.Get(tc, pm(1)), ShouldEqual, ErrUsedAfterTransaction)
+			So(ds.RunInTransaction(tc, func(context.Context) error { return nil }, nil),
+				ShouldEqual, ErrUsedAfterTransaction)
+
+			Convey("but not without the transaction", func() {
+				So(ds.Get(ds.WithoutTransaction(tc), pm(1)), ShouldBeNil)
+			})
+		})
+
+		Convey("detects concurrent use", func() {
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				done := make(chan error)
+				go func() {
+					done <- ds.Get(c, ds.PropertyMap{"$key": ds.MkPropertyNI(ds.MakeKey(c, "Slow", 1))})
+				}()
+				<-started
+				So(ds.Get(c, pm(1)), ShouldEqual, ErrConcurrentUse)
+				close(release)
+				So(<-done, ShouldEqual, ds.ErrNoSuchEntity)
+
+				// Once the other call is done, it's fine again.
+				return ds.Get(c, pm(1))
+			}, &ds.TransactionOptions{XG: true}), ShouldBeNil)
+		})
+
+		Convey("allows the parallel batches of a single call", func() {
+			c := ds.AddRawFilters(memory.Use(context.Background()), func(_ context.Context, rds ds.RawInterface) ds.RawInterface {
+				return &latentRDS{rds}
+			})
+			c = FilterRDS(c, nil)
+			c = ds.WithBatchOptions(c, ds.BatchOptions{MaxGetSize: 1})
+
+			pms := make([]ds.PropertyMap, 5)
+			for i := range pms {
+				pms[i] = ds.PropertyMap{"$key": ds.MkPropertyNI(ds.MakeKey(c, "Thing", i+1))}
+			}
+			So(ds.Put(c, pms), ShouldBeNil)
+
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				return ds.Get(c, pms)
+			}, &ds.TransactionOptions{XG: true}), ShouldBeNil)
+		})
+
+		Convey("detects nested transactions", func() {
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				return ds.RunInTransaction(c, func(context.Context) error { return nil }, nil)
+			}, nil), ShouldEqual, ErrNestedTransaction)
+		})
+
+		Convey("allows nested transactions with AllowNested", func() {
+			called := false
+			c := FilterRDS(memory.Use(context.Background()), &Options{AllowNested: true})
+			ds.RunInTransaction(c, func(c context.Context) error {
+				// The memory datastore doesn't support them itself.
+				err := ds.RunInTransaction(c, func(context.Context) error {
+					called = true
+					return nil
+				}, nil)
+				So(err, ShouldNotEqual, ErrNestedTransaction)
+				return nil
+			}, nil)
+			So(called, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,27 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"go.chromium.org/gae/service/capability"
+)
+
+// capabilityService is a capability.RawInterface which reports every
+// capability as enabled. Cloud services don't expose their availability.
+type capabilityService struct{}
+
+func (capabilityService) Enabled(api, name string) bool { return true }
+
+func (capabilityService) GetTestable() capability.Testable { return nil }
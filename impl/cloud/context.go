@@ -19,10 +19,17 @@ import (
 	"time"
 
 	"go.chromium.org/gae/impl/dummy"
+	"go.chromium.org/gae/service/capability"
 	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/image"
+	"go.chromium.org/gae/service/logs"
 	"go.chromium.org/gae/service/mail"
 	mc "go.chromium.org/gae/service/memcache"
 	"go.chromium.org/gae/service/module"
+	rt "go.chromium.org/gae/service/runtime"
+	"go.chromium.org/gae/service/search"
+	"go.chromium.org/gae/service/socket"
+	"go.chromium.org/gae/service/storage"
 	"go.chromium.org/gae/service/taskqueue"
 	"go.chromium.org/gae/service/user"
 
@@ -31,6 +38,7 @@ import (
 	"cloud.google.com/go/datastore"
 	cloudLogging "cloud.google.com/go/logging"
 	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gomodule/redigo/redis"
 
 	"golang.org/x/net/context"
 )
@@ -103,10 +111,33 @@ type Config struct {
 	// be installed.
 	DS *datastore.Client
 
+	// DSDatabases are cloud datastore clients for named Firestore in Datastore
+	// mode databases, keyed on database ID. They are used by Contexts which
+	// target a database with datastore.WithDatabase. Requires DS.
+	DSDatabases map[string]*datastore.Client
+
+	// DSProjects are cloud datastore clients for the default databases of other
+	// Cloud projects, keyed on project ID. They are used by Contexts which
+	// target a project with datastore.WithAppID. Requires DS.
+	DSProjects map[string]*datastore.Client
+
 	// MC is the memcache service client. If populated, the memcache service will
 	// be installed.
 	MC *memcache.Client
 
+	// Redis is a Redis connection pool. If populated, and MC is not, a Redis
+	// backed memcache service will be installed.
+	Redis *redis.Pool
+
+	// Tasks, if not nil, configures the Cloud Tasks backed taskqueue service,
+	// which will be installed.
+	Tasks *TasksConfig
+
+	// Mail, if not nil, is the mail service implementation to install. There is
+	// no Cloud mail API, so this lets users plug in their own sender (e.g.
+	// "impl/smtp", or a third-party API). If nil, a dummy stub is installed.
+	Mail mail.RawInterface
+
 	// RequestLogger, if not nil, will be used by ScopedRequest to log
 	// request-level logs.
 	//
@@ -202,10 +233,29 @@ func (cfg *Config) Use(c context.Context, req *Request) context.Context {
 	}
 	c = rs.with(c)
 
+	// Mail is only supported if the user supplies an implementation.
+	if cfg.Mail != nil {
+		c = mail.Set(c, cfg.Mail)
+	} else {
+		c = mail.Set(c, dummy.Mail())
+	}
+
+	// Cloud services don't report their capabilities, so assume they're all
+	// available.
+	c = capability.Set(c, capabilityService{})
+
+	// Outbound sockets are regular network connections.
+	c = socket.SetFactory(c, func(ic context.Context) socket.RawInterface {
+		return &socketService{ic}
+	})
+
 	// Dummy services that we don't support.
-	c = mail.Set(c, dummy.Mail())
+	c = image.Set(c, dummy.Image())
+	c = logs.Set(c, dummy.Logs())
 	c = module.Set(c, dummy.Module())
-	c = taskqueue.SetRaw(c, dummy.TaskQueue())
+	c = rt.Set(c, dummy.Runtime())
+	c = search.Set(c, dummy.Search())
+	c = storage.Set(c, dummy.Storage())
 	c = user.Set(c, dummy.User())
 
 	// Install the logging service, if fields are sufficiently configured.
@@ -241,20 +291,39 @@ func (cfg *Config) Use(c context.Context, req *Request) context.Context {
 	// datastore service
 	if cfg.DS != nil {
 		cds := cloudDatastore{
-			client: cfg.DS,
+			client:    cfg.DS,
+			databases: cfg.DSDatabases,
+			projects:  cfg.DSProjects,
 		}
 		c = cds.use(c)
 	} else {
 		c = ds.SetRaw(c, dummy.Datastore())
 	}
 
+	// taskqueue service
+	if cfg.Tasks != nil {
+		ctq := cloudTaskQueue{
+			cfg:       cfg.Tasks,
+			projectID: cfg.ProjectID,
+		}
+		c = ctq.use(c)
+	} else {
+		c = taskqueue.SetRaw(c, dummy.TaskQueue())
+	}
+
 	// memcache service
-	if cfg.MC != nil {
+	switch {
+	case cfg.MC != nil:
 		mc := memcacheClient{
 			client: cfg.MC,
 		}
 		c = mc.use(c)
-	} else {
+	case cfg.Redis != nil:
+		rc := redisClient{
+			pool: cfg.Redis,
+		}
+		c = rc.use(c)
+	default:
 		c = mc.SetRaw(c, dummy.Memcache())
 	}
 
@@ -24,28 +24,97 @@ import (
 
 	"go.chromium.org/gae/impl/prod/constraints"
 	ds "go.chromium.org/gae/service/datastore"
+	infoS "go.chromium.org/gae/service/info"
 
 	"cloud.google.com/go/datastore"
 	"google.golang.org/api/iterator"
+	pb "google.golang.org/genproto/googleapis/datastore/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"golang.org/x/net/context"
 )
 
+// UseDatastore installs a datastore service backed by the Cloud Datastore
+// client into c, leaving any other services in c untouched. It's intended for
+// services running outside of App Engine (e.g. on GKE, GCE or Cloud Run) which
+// only need the datastore, and don't want to build a full Config.
+//
+// The datastore service derives keys from the app ID and namespace reported
+// by the "info" service. If c doesn't have an "info" service installed, one
+// which reports projectID as the app ID is installed as well.
+func UseDatastore(c context.Context, projectID string, client *datastore.Client) context.Context {
+	if infoS.Raw(c) == nil {
+		c = useInfo(c, &serviceInstanceGlobalInfo{
+			Config:  &Config{ProjectID: projectID},
+			Request: &Request{},
+		})
+	}
+	cds := cloudDatastore{
+		client: client,
+	}
+	return cds.use(c)
+}
+
 type cloudDatastore struct {
 	client *datastore.Client
+
+	// databases are the clients for named (non-default) databases, keyed on
+	// database ID. See ds.WithDatabase.
+	databases map[string]*datastore.Client
+
+	// projects are the clients for the default databases of other projects,
+	// keyed on project ID. See ds.WithAppID.
+	projects map[string]*datastore.Client
 }
 
 func (cds *cloudDatastore) use(c context.Context) context.Context {
 	return ds.SetRawFactory(c, func(ic context.Context) ds.RawInterface {
-		return &boundDatastore{
+		kc := ds.GetKeyContext(ic)
+		project := ds.GetAppID(ic)
+		if project == infoS.Raw(ic).FullyQualifiedAppID() {
+			project = ""
+		}
+		bt := datastoreTransaction(ic)
+		bds := &boundDatastore{
 			Context:        ic,
 			cloudDatastore: cds,
-			transaction:    datastoreTransaction(ic),
-			kc:             ds.GetKeyContext(ic),
+			transaction:    bt.tx,
+			project:        project,
+			kc:             kc,
 		}
+		if bt.tx != nil && (bt.project != project || bt.database != kc.Database) {
+			bds.err = fmt.Errorf("cannot use project %q database %q in a transaction on project %q database %q",
+				project, kc.Database, bt.project, bt.database)
+		} else {
+			bds.client, bds.err = cds.clientFor(project, kc.Database)
+		}
+		return bds
 	})
 }
 
+// clientFor returns the client for the database with the given ID in the
+// given project, where the empty project is the current one. It returns an
+// error if there is no client for that database.
+func (cds *cloudDatastore) clientFor(project, database string) (*datastore.Client, error) {
+	if project != "" {
+		if database != "" {
+			return nil, fmt.Errorf("named databases of other projects are not supported, got %q in project %q", database, project)
+		}
+		if client := cds.projects[project]; client != nil {
+			return client, nil
+		}
+		return nil, fmt.Errorf("no datastore client for project %q", project)
+	}
+	if database == "" {
+		return cds.client, nil
+	}
+	if client := cds.databases[database]; client != nil {
+		return client, nil
+	}
+	return nil, fmt.Errorf("no datastore client for database %q", database)
+}
+
 // boundDatastore is a bound instance of the cloudDatastore installed in the
 // Context.
 type boundDatastore struct {
@@ -55,11 +124,24 @@ type boundDatastore struct {
 	// one is set.
 	*cloudDatastore
 
+	// client is the client for the database in kc.
+	client *datastore.Client
+	// err, if not nil, is why the Context can't be used with this datastore,
+	// e.g. because it selects a database without a client. It's returned by
+	// every operation.
+	err error
+
 	transaction *datastore.Transaction
-	kc          ds.KeyContext
+	// project is the ID of the foreign project targeted with ds.WithAppID, or
+	// empty for the current project.
+	project string
+	kc      ds.KeyContext
 }
 
 func (bds *boundDatastore) AllocateIDs(keys []*ds.Key, cb ds.NewKeyCB) error {
+	if bds.err != nil {
+		return bds.err
+	}
 	nativeKeys, err := bds.client.AllocateIDs(bds, bds.gaeKeysToNative(keys...))
 	if err != nil {
 		return normalizeError(err)
@@ -72,7 +154,15 @@ func (bds *boundDatastore) AllocateIDs(keys []*ds.Key, cb ds.NewKeyCB) error {
 	return nil
 }
 
+func (bds *boundDatastore) ReserveIDRange(key *ds.Key, start, end int64) error {
+	// The cloud datastore SDK does not expose an API to reserve IDs.
+	return errors.New("reserving ID ranges is not supported by the cloud datastore")
+}
+
 func (bds *boundDatastore) RunInTransaction(fn func(context.Context) error, opts *ds.TransactionOptions) error {
+	if bds.err != nil {
+		return bds.err
+	}
 	if bds.transaction != nil {
 		return errors.New("nested transactions are not supported")
 	}
@@ -85,14 +175,19 @@ func (bds *boundDatastore) RunInTransaction(fn func(context.Context) error, opts
 		}
 	}
 
+	var txOpts []datastore.TransactionOption
+	if opts != nil && !opts.ReadTime.IsZero() {
+		txOpts = append(txOpts, datastore.ReadOnly, datastore.WithReadTime(opts.ReadTime))
+	}
+
 	attempts := 3
 	if opts != nil && opts.Attempts > 0 {
 		attempts = opts.Attempts
 	}
 	for i := 0; i < attempts; i++ {
 		_, err := bds.client.RunInTransaction(bds, func(tx *datastore.Transaction) error {
-			return fn(withDatastoreTransaction(bds, tx))
-		})
+			return fn(withDatastoreTransaction(bds, &boundTransaction{tx, bds.project, bds.kc.Database}))
+		}, txOpts...)
 		if err = normalizeError(err); err != ds.ErrConcurrentTransaction {
 			return err
 		}
@@ -105,15 +200,37 @@ func (bds *boundDatastore) DecodeCursor(s string) (ds.Cursor, error) {
 	return cursor, normalizeError(err)
 }
 
+// readClient returns the client for reads outside of transactions, which read
+// at the read time of the Context, if any (see ds.WithReadTime).
+func (bds *boundDatastore) readClient() *datastore.Client {
+	if readTime := ds.GetReadTime(bds); !readTime.IsZero() {
+		return bds.client.WithReadOptions(datastore.ReadTime(readTime))
+	}
+	return bds.client
+}
+
 func (bds *boundDatastore) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
-	it := bds.client.Run(bds, bds.prepareNativeQuery(q))
+	if bds.err != nil {
+		return bds.err
+	}
+	it := bds.readClient().Run(bds, bds.prepareNativeQuery(q))
 	cursorFn := func() (ds.Cursor, error) {
 		return it.Cursor()
 	}
 
+	// The SDK loads the entities of a query one at a time, so the PropertyMap
+	// can be reused (see ds.PropertyMapsReusable). GetMulti loads them all at
+	// once.
+	reusePM := ds.PropertyMapsReusable(bds)
+	var npls *nativePropertyLoadSaver
 	for {
-		var npls *nativePropertyLoadSaver
-		if !q.KeysOnly() {
+		switch {
+		case q.KeysOnly():
+		case reusePM && npls != nil:
+			for k := range npls.pmap {
+				delete(npls.pmap, k)
+			}
+		default:
 			npls = bds.mkNPLS(nil)
 		}
 		nativeKey, err := it.Next(npls)
@@ -138,13 +255,58 @@ func (bds *boundDatastore) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
 }
 
 func (bds *boundDatastore) Count(q *ds.FinalizedQuery) (int64, error) {
-	v, err := bds.client.Count(bds, bds.prepareNativeQuery(q))
+	if bds.err != nil {
+		return 0, bds.err
+	}
+	v, err := bds.readClient().Count(bds, bds.prepareNativeQuery(q))
 	if err != nil {
 		return -1, normalizeError(err)
 	}
 	return int64(v), nil
 }
 
+func (bds *boundDatastore) Aggregate(q *ds.FinalizedQuery, aggs []*ds.Aggregation) (ds.AggregationResult, error) {
+	if bds.err != nil {
+		return nil, bds.err
+	}
+	aq := bds.prepareNativeQuery(q).NewAggregationQuery()
+	for _, a := range aggs {
+		switch a.Op() {
+		case ds.AggregationCount:
+			aq = aq.WithCount(a.Alias())
+		case ds.AggregationSum:
+			aq = aq.WithSum(a.Property(), a.Alias())
+		case ds.AggregationAvg:
+			aq = aq.WithAvg(a.Property(), a.Alias())
+		default:
+			return nil, fmt.Errorf("unknown aggregation %s", a)
+		}
+	}
+
+	res, err := bds.readClient().RunAggregationQuery(bds, aq)
+	if err != nil {
+		return nil, normalizeError(err)
+	}
+	ret := make(ds.AggregationResult, len(aggs))
+	for _, a := range aggs {
+		v, ok := res[a.Alias()].(*pb.Value)
+		if !ok {
+			return nil, fmt.Errorf("unexpected result %T for aggregation %s", res[a.Alias()], a)
+		}
+		switch vt := v.GetValueType().(type) {
+		case *pb.Value_IntegerValue:
+			ret[a.Alias()] = vt.IntegerValue
+		case *pb.Value_DoubleValue:
+			ret[a.Alias()] = vt.DoubleValue
+		case *pb.Value_NullValue:
+			ret[a.Alias()] = nil
+		default:
+			return nil, fmt.Errorf("unexpected value %T for aggregation %s", vt, a)
+		}
+	}
+	return ret, nil
+}
+
 func fixMultiError(err error) error {
 	if err == nil {
 		return nil
@@ -178,6 +340,9 @@ func idxCallbacker(err error, amt int, cb func(idx int, err error) error) error
 }
 
 func (bds *boundDatastore) GetMulti(keys []*ds.Key, _meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	if bds.err != nil {
+		return bds.err
+	}
 	nativeKeys := bds.gaeKeysToNative(keys...)
 	nativePLS := make([]*nativePropertyLoadSaver, len(nativeKeys))
 	for i := range nativePLS {
@@ -190,7 +355,7 @@ func (bds *boundDatastore) GetMulti(keys []*ds.Key, _meta ds.MultiMetaGetter, cb
 		err = bds.transaction.GetMulti(nativeKeys, nativePLS)
 	} else {
 		// Non-transactional GetMulti.
-		err = bds.client.GetMulti(bds, nativeKeys, nativePLS)
+		err = bds.readClient().GetMulti(bds, nativeKeys, nativePLS)
 	}
 
 	return idxCallbacker(err, len(nativePLS), func(idx int, err error) error {
@@ -199,6 +364,9 @@ func (bds *boundDatastore) GetMulti(keys []*ds.Key, _meta ds.MultiMetaGetter, cb
 }
 
 func (bds *boundDatastore) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.NewKeyCB) error {
+	if bds.err != nil {
+		return bds.err
+	}
 	nativeKeys := bds.gaeKeysToNative(keys...)
 	nativePLS := make([]*nativePropertyLoadSaver, len(vals))
 	for i := range nativePLS {
@@ -209,35 +377,9 @@ func (bds *boundDatastore) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds
 	if bds.transaction != nil {
 		// Transactional PutMulti.
 		//
-		// In order to simulate the presence of mid-transaction key allocation, we
-		// will identify any incomplete keys and allocate IDs for them. This is
-		// potentially wasteful in the event of failed or retried transactions, but
-		// it is required to maintain API compatibility with the datastore
-		// interface.
-		var incompleteKeys []*datastore.Key
-		var incompleteKeyMap map[int]int
-		for i, k := range nativeKeys {
-			if k.Incomplete() {
-				if incompleteKeyMap == nil {
-					// Optimization: if there are any incomplete keys, allocate room for
-					// the full range.
-					incompleteKeyMap = make(map[int]int, len(nativeKeys)-i)
-					incompleteKeys = make([]*datastore.Key, 0, len(nativeKeys)-i)
-				}
-				incompleteKeyMap[len(incompleteKeys)] = i
-				incompleteKeys = append(incompleteKeys, k)
-			}
-		}
-		if len(incompleteKeys) > 0 {
-			idKeys, err := bds.client.AllocateIDs(bds, incompleteKeys)
-			if err != nil {
-				return err
-			}
-			for i, idKey := range idKeys {
-				nativeKeys[incompleteKeyMap[i]] = idKey
-			}
+		if err := bds.allocateIncompleteKeys(nativeKeys); err != nil {
+			return err
 		}
-
 		_, err = bds.transaction.PutMulti(nativeKeys, nativePLS)
 	} else {
 		// Non-transactional PutMulti.
@@ -252,7 +394,44 @@ func (bds *boundDatastore) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds
 	})
 }
 
+// allocateIncompleteKeys allocates IDs for any incomplete keys in nativeKeys,
+// replacing them in place.
+//
+// This is used in transactions to simulate the presence of mid-transaction key
+// allocation. This is potentially wasteful in the event of failed or retried
+// transactions, but it is required to maintain API compatibility with the
+// datastore interface.
+func (bds *boundDatastore) allocateIncompleteKeys(nativeKeys []*datastore.Key) error {
+	var incompleteKeys []*datastore.Key
+	var incompleteKeyMap map[int]int
+	for i, k := range nativeKeys {
+		if k.Incomplete() {
+			if incompleteKeyMap == nil {
+				// Optimization: if there are any incomplete keys, allocate room for
+				// the full range.
+				incompleteKeyMap = make(map[int]int, len(nativeKeys)-i)
+				incompleteKeys = make([]*datastore.Key, 0, len(nativeKeys)-i)
+			}
+			incompleteKeyMap[len(incompleteKeys)] = i
+			incompleteKeys = append(incompleteKeys, k)
+		}
+	}
+	if len(incompleteKeys) > 0 {
+		idKeys, err := bds.client.AllocateIDs(bds, incompleteKeys)
+		if err != nil {
+			return err
+		}
+		for i, idKey := range idKeys {
+			nativeKeys[incompleteKeyMap[i]] = idKey
+		}
+	}
+	return nil
+}
+
 func (bds *boundDatastore) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+	if bds.err != nil {
+		return bds.err
+	}
 	nativeKeys := bds.gaeKeysToNative(keys...)
 
 	var err error
@@ -269,8 +448,81 @@ func (bds *boundDatastore) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) erro
 	})
 }
 
+func (bds *boundDatastore) Mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	if bds.err != nil {
+		return bds.err
+	}
+	keys := make([]*ds.Key, len(muts))
+	for i, m := range muts {
+		keys[i] = m.Key
+	}
+	nativeKeys := bds.gaeKeysToNative(keys...)
+	if bds.transaction != nil {
+		// See PutMulti.
+		if err := bds.allocateIncompleteKeys(nativeKeys); err != nil {
+			return err
+		}
+	}
+
+	nativeMuts := make([]*datastore.Mutation, len(muts))
+	for i, m := range muts {
+		switch k := nativeKeys[i]; m.Op {
+		case ds.MutationUpsert:
+			nativeMuts[i] = datastore.NewUpsert(k, bds.mkNPLS(m.Value))
+		case ds.MutationInsert:
+			nativeMuts[i] = datastore.NewInsert(k, bds.mkNPLS(m.Value))
+		case ds.MutationUpdate:
+			nativeMuts[i] = datastore.NewUpdate(k, bds.mkNPLS(m.Value))
+		case ds.MutationDelete:
+			nativeMuts[i] = datastore.NewDelete(k)
+		default:
+			return fmt.Errorf("unknown mutation op %s", m.Op)
+		}
+	}
+
+	var err error
+	if bds.transaction != nil {
+		// Transactional Mutate.
+		_, err = bds.transaction.Mutate(nativeMuts...)
+	} else {
+		// Non-transactional Mutate.
+		nativeKeys, err = bds.client.Mutate(bds, nativeMuts...)
+	}
+
+	return idxCallbacker(mutateError(err, muts), len(muts), func(idx int, err error) error {
+		if err == nil {
+			return cb(idx, bds.nativeKeysToGAE(nativeKeys[idx])[0], nil)
+		}
+		return cb(idx, nil, err)
+	})
+}
+
+// mutateError attributes a failed precondition in a Mutate commit to the
+// mutations which could have caused it. The commit doesn't say which one it
+// was, so, e.g., every insert is failed with ErrEntityExists.
+func mutateError(err error, muts []ds.RawMutation) error {
+	var op ds.MutationOp
+	var opErr error
+	switch status.Code(err) {
+	case codes.AlreadyExists:
+		op, opErr = ds.MutationInsert, ds.ErrEntityExists
+	case codes.NotFound:
+		op, opErr = ds.MutationUpdate, ds.ErrNoSuchEntity
+	default:
+		return err
+	}
+
+	me := make(errors.MultiError, len(muts))
+	for i, m := range muts {
+		if m.Op == op {
+			me[i] = opErr
+		}
+	}
+	return me
+}
+
 func (bds *boundDatastore) WithoutTransaction() context.Context {
-	return withDatastoreTransaction(bds, nil)
+	return withDatastoreTransaction(bds, &boundTransaction{})
 }
 
 func (bds *boundDatastore) CurrentTransaction() ds.Transaction {
@@ -280,7 +532,13 @@ func (bds *boundDatastore) CurrentTransaction() ds.Transaction {
 	return bds.transaction
 }
 
-func (bds *boundDatastore) Constraints() ds.Constraints { return constraints.DS() }
+func (bds *boundDatastore) Constraints() ds.Constraints {
+	c := constraints.DS()
+	c.MaxEntityGroupsPerTransaction = 0
+	c.NoCrossGroupTransactions = true
+	c.NoReserveIDRange = true
+	return c
+}
 
 func (bds *boundDatastore) GetTestable() ds.Testable { return nil }
 
@@ -384,6 +642,10 @@ func (bds *boundDatastore) gaePropertyToNative(name string, pdata ds.PropertyDat
 		case ds.PTKey:
 			return bds.gaeKeysToNative(prop.Value().(*ds.Key))[0], nil
 
+		case ds.PTGeoPoint:
+			gp := prop.Value().(ds.GeoPoint)
+			return datastore.GeoPoint{Lat: gp.Lat, Lng: gp.Lng}, nil
+
 		default:
 			return nil, fmt.Errorf("unsupported property type: %v", pt)
 		}
@@ -442,6 +704,9 @@ func (bds *boundDatastore) nativePropertyToGAE(nativeProp datastore.Property) (
 		case *datastore.Key:
 			nv = bds.nativeKeysToGAE(nvt)[0]
 
+		case datastore.GeoPoint:
+			nv = ds.GeoPoint{Lat: nvt.Lat, Lng: nvt.Lng}
+
 		default:
 			return fmt.Errorf("unsupported datastore.Value type for %q: %T", name, nvt)
 		}
@@ -544,6 +809,10 @@ func (npls *nativePropertyLoadSaver) Load(props []datastore.Property) error {
 		npls.pmap = make(ds.PropertyMap, len(props))
 	}
 
+	props, err := flattenEntities(props)
+	if err != nil {
+		return err
+	}
 	for _, nativeProp := range props {
 		name, pdata, err := npls.bds.nativePropertyToGAE(nativeProp)
 		if err != nil {
@@ -578,17 +847,91 @@ func (npls *nativePropertyLoadSaver) Save() ([]datastore.Property, error) {
 	return props, nil
 }
 
+// flattenEntities flattens the nested entities in props into properties with
+// dotted names, the way the App Engine SDK stores nested structs: e.g. the
+// "City" property of an "Address" entity becomes an "Address.City" property.
+// The properties of entities in a multi-valued property become multi-valued.
+func flattenEntities(props []datastore.Property) ([]datastore.Property, error) {
+	var ret []datastore.Property
+	for _, p := range props {
+		switch v := p.Value.(type) {
+		case *datastore.Entity:
+			subs, err := flattenEntities(v.Properties)
+			if err != nil {
+				return nil, err
+			}
+			for _, sub := range subs {
+				sub.Name = p.Name + "." + sub.Name
+				ret = append(ret, sub)
+			}
+
+		case []interface{}:
+			ents := 0
+			for _, nv := range v {
+				if _, ok := nv.(*datastore.Entity); ok {
+					ents++
+				}
+			}
+			if ents == 0 {
+				ret = append(ret, p)
+				continue
+			}
+			if ents != len(v) {
+				return nil, fmt.Errorf("mixed entity and non-entity values for %q", p.Name)
+			}
+
+			// Index in ret of the multi-valued property for each name.
+			idx := map[string]int{}
+			for _, nv := range v {
+				subs, err := flattenEntities(nv.(*datastore.Entity).Properties)
+				if err != nil {
+					return nil, err
+				}
+				for _, sub := range subs {
+					name := p.Name + "." + sub.Name
+					i, ok := idx[name]
+					if !ok {
+						i = len(ret)
+						idx[name] = i
+						ret = append(ret, datastore.Property{Name: name, Value: []interface{}{}, NoIndex: true})
+					}
+					vals := ret[i].Value.([]interface{})
+					if subVals, ok := sub.Value.([]interface{}); ok {
+						vals = append(vals, subVals...)
+					} else {
+						vals = append(vals, sub.Value)
+					}
+					ret[i].Value = vals
+					ret[i].NoIndex = ret[i].NoIndex && sub.NoIndex
+				}
+			}
+
+		default:
+			ret = append(ret, p)
+		}
+	}
+	return ret, nil
+}
+
 var datastoreTransactionKey = "*datastore.Transaction"
 
-func withDatastoreTransaction(c context.Context, tx *datastore.Transaction) context.Context {
-	return context.WithValue(c, &datastoreTransactionKey, tx)
+// boundTransaction is a transaction along with the IDs of the project and the
+// database that it was started on.
+type boundTransaction struct {
+	tx       *datastore.Transaction
+	project  string
+	database string
+}
+
+func withDatastoreTransaction(c context.Context, bt *boundTransaction) context.Context {
+	return context.WithValue(c, &datastoreTransactionKey, bt)
 }
 
-func datastoreTransaction(c context.Context) *datastore.Transaction {
-	if tx, ok := c.Value(&datastoreTransactionKey).(*datastore.Transaction); ok {
-		return tx
+func datastoreTransaction(c context.Context) *boundTransaction {
+	if bt, ok := c.Value(&datastoreTransactionKey).(*boundTransaction); ok {
+		return bt
 	}
-	return nil
+	return &boundTransaction{}
 }
 
 func clonePropertyMap(pmap ds.PropertyMap) ds.PropertyMap {
@@ -0,0 +1,166 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	ds "go.chromium.org/gae/service/datastore"
+
+	"cloud.google.com/go/datastore"
+)
+
+// These functions convert between the types of this package's datastore
+// service and those of the Cloud Datastore client
+// ("cloud.google.com/go/datastore"), so that code written against the Cloud
+// client can be used alongside code written against this package, e.g. while
+// migrating from one to the other.
+//
+// Nested entities are flattened into properties with dotted names, the way
+// the App Engine SDK stores nested structs (e.g. "Address.City"). They aren't
+// unflattened when converting back to the Cloud types.
+
+// KeyFromCloud converts a Cloud Datastore Key to a Key. The Cloud Key has no
+// app ID or database, so they're taken from kc. Its namespace is kept.
+func KeyFromCloud(kc ds.KeyContext, k *datastore.Key) *ds.Key {
+	if k == nil {
+		return nil
+	}
+	return (&boundDatastore{kc: kc}).nativeKeysToGAE(k)[0]
+}
+
+// KeyToCloud converts k to a Cloud Datastore Key. The app ID and database of
+// k are dropped.
+func KeyToCloud(k *ds.Key) *datastore.Key {
+	if k == nil {
+		return nil
+	}
+	return (&boundDatastore{}).gaeKeysToNative(k)[0]
+}
+
+// PropertyMapFromCloud converts Cloud Datastore Properties, as passed to a
+// Cloud PropertyLoadSaver's Load method, to a PropertyMap. Keys are converted
+// with KeyFromCloud.
+func PropertyMapFromCloud(kc ds.KeyContext, props []datastore.Property) (ds.PropertyMap, error) {
+	npls := (&boundDatastore{kc: kc}).mkNPLS(nil)
+	if err := npls.Load(props); err != nil {
+		return nil, err
+	}
+	if npls.pmap == nil {
+		npls.pmap = ds.PropertyMap{}
+	}
+	return npls.pmap, nil
+}
+
+// PropertyMapToCloud converts pm to Cloud Datastore Properties, as returned
+// by a Cloud PropertyLoadSaver's Save method. Meta properties aren't
+// converted.
+func PropertyMapToCloud(pm ds.PropertyMap) ([]datastore.Property, error) {
+	return (&boundDatastore{}).mkNPLS(pm).Save()
+}
+
+// EntityFromCloud converts a Cloud Datastore Entity to a PropertyMap. The key
+// of e, if any, is converted to the "$key" meta property.
+func EntityFromCloud(kc ds.KeyContext, e *datastore.Entity) (ds.PropertyMap, error) {
+	pm, err := PropertyMapFromCloud(kc, e.Properties)
+	if err != nil {
+		return nil, err
+	}
+	if e.Key != nil {
+		pm.SetMeta("key", KeyFromCloud(kc, e.Key))
+	}
+	return pm, nil
+}
+
+// EntityToCloud converts pm to a Cloud Datastore Entity, with the key in pm's
+// "$key" meta property, if any.
+func EntityToCloud(pm ds.PropertyMap) (*datastore.Entity, error) {
+	props, err := PropertyMapToCloud(pm)
+	if err != nil {
+		return nil, err
+	}
+	ret := &datastore.Entity{Properties: props}
+	if k, ok := ds.GetMetaDefault(pm, "key", nil).(*ds.Key); ok {
+		ret.Key = KeyToCloud(k)
+	}
+	return ret, nil
+}
+
+// NativePLS adapts a Cloud Datastore PropertyLoadSaver (e.g. of a model
+// written against the Cloud client) to a PropertyLoadSaver of this package's
+// datastore, so that it can be used directly with Get, Put and friends:
+//
+//	err := ds.Get(c, cloud.NewNativePLS(ds.GetKeyContext(c), key, &model))
+//
+// The Cloud PropertyLoadSaver has no meta properties, so the NativePLS holds
+// them, including the "$key" of the entity.
+type NativePLS struct {
+	kc   ds.KeyContext
+	pls  datastore.PropertyLoadSaver
+	meta ds.PropertyMap
+}
+
+var (
+	_ ds.PropertyLoadSaver = (*NativePLS)(nil)
+	_ ds.MetaGetterSetter  = (*NativePLS)(nil)
+)
+
+// NewNativePLS returns a NativePLS for pls, with the key key (which may be nil
+// and set later with SetMeta). Keys saved by pls are converted with
+// KeyFromCloud, using kc.
+func NewNativePLS(kc ds.KeyContext, key *ds.Key, pls datastore.PropertyLoadSaver) *NativePLS {
+	ret := &NativePLS{kc: kc, pls: pls, meta: ds.PropertyMap{}}
+	if key != nil {
+		ret.meta.SetMeta("key", key)
+	}
+	return ret
+}
+
+// Load implements ds.PropertyLoadSaver.
+func (n *NativePLS) Load(pm ds.PropertyMap) error {
+	props, err := PropertyMapToCloud(pm)
+	if err != nil {
+		return err
+	}
+	for name, pdata := range pm.GetAllMeta() {
+		n.meta[name] = pdata
+	}
+	return n.pls.Load(props)
+}
+
+// Save implements ds.PropertyLoadSaver.
+func (n *NativePLS) Save(withMeta bool) (ds.PropertyMap, error) {
+	props, err := n.pls.Save()
+	if err != nil {
+		return nil, err
+	}
+	pm, err := PropertyMapFromCloud(n.kc, props)
+	if err != nil {
+		return nil, err
+	}
+	if withMeta {
+		for name, pdata := range n.meta {
+			pm[name] = pdata
+		}
+	}
+	return pm, nil
+}
+
+// GetMeta implements ds.MetaGetter.
+func (n *NativePLS) GetMeta(key string) (interface{}, bool) { return n.meta.GetMeta(key) }
+
+// GetAllMeta implements ds.MetaGetterSetter.
+func (n *NativePLS) GetAllMeta() ds.PropertyMap { return n.meta.GetAllMeta() }
+
+// SetMeta implements ds.MetaGetterSetter.
+func (n *NativePLS) SetMeta(key string, val interface{}) bool { return n.meta.SetMeta(key, val) }
@@ -0,0 +1,106 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"testing"
+
+	ds "go.chromium.org/gae/service/datastore"
+
+	"cloud.google.com/go/datastore"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestDatastoreConvert(t *testing.T) {
+	t.Parallel()
+
+	Convey(`Converting to and from Cloud Datastore types`, t, func() {
+		kc := ds.MkKeyContext("app", "ns")
+
+		Convey(`Keys`, func() {
+			k := kc.MakeKey("Parent", "p", "Child", 1)
+			nk := KeyToCloud(k)
+			So(nk.Kind, ShouldEqual, "Child")
+			So(nk.ID, ShouldEqual, 1)
+			So(nk.Namespace, ShouldEqual, "ns")
+			So(nk.Parent.Name, ShouldEqual, "p")
+			So(KeyFromCloud(kc, nk), ShouldResemble, k)
+
+			So(KeyToCloud(nil), ShouldBeNil)
+			So(KeyFromCloud(kc, nil), ShouldBeNil)
+		})
+
+		Convey(`Entities`, func() {
+			pm := ds.PropertyMap{
+				"$key": ds.MkPropertyNI(kc.MakeKey("Foo", 1)),
+				"Geo":  ds.MkProperty(ds.GeoPoint{Lat: 1, Lng: 2}),
+				"Tags": ds.PropertySlice{ds.MkProperty("a"), ds.MkProperty("b")},
+			}
+			e, err := EntityToCloud(pm)
+			So(err, ShouldBeNil)
+			So(e.Key.ID, ShouldEqual, 1)
+			So(len(e.Properties), ShouldEqual, 2)
+
+			back, err := EntityFromCloud(kc, e)
+			So(err, ShouldBeNil)
+			So(back, ShouldResemble, pm)
+		})
+
+		Convey(`Nested entities are flattened`, func() {
+			addr := func(city string) *datastore.Entity {
+				return &datastore.Entity{Properties: []datastore.Property{
+					{Name: "City", Value: city},
+					{Name: "Zip", Value: int64(1), NoIndex: true},
+				}}
+			}
+			pm, err := PropertyMapFromCloud(kc, []datastore.Property{
+				{Name: "Home", Value: addr("here")},
+				{Name: "Past", Value: []interface{}{addr("a"), addr("b")}},
+			})
+			So(err, ShouldBeNil)
+			So(pm, ShouldResemble, ds.PropertyMap{
+				"Home.City": ds.MkProperty("here"),
+				"Home.Zip":  ds.MkPropertyNI(1),
+				"Past.City": ds.PropertySlice{ds.MkProperty("a"), ds.MkProperty("b")},
+				"Past.Zip":  ds.PropertySlice{ds.MkPropertyNI(1), ds.MkPropertyNI(1)},
+			})
+
+			_, err = PropertyMapFromCloud(kc, []datastore.Property{
+				{Name: "Past", Value: []interface{}{addr("a"), "b"}},
+			})
+			So(err, ShouldErrLike, `mixed entity and non-entity values for "Past"`)
+		})
+
+		Convey(`NativePLS`, func() {
+			k := kc.MakeKey("Foo", 1)
+
+			var pl datastore.PropertyList
+			pls := NewNativePLS(kc, k, &pl)
+			So(ds.GetMetaDefault(pls, "key", nil), ShouldResemble, k)
+
+			So(pls.Load(ds.PropertyMap{"Val": ds.MkProperty(1)}), ShouldBeNil)
+			So(pl, ShouldResemble, datastore.PropertyList{{Name: "Val", Value: int64(1)}})
+
+			pm, err := pls.Save(true)
+			So(err, ShouldBeNil)
+			So(pm, ShouldResemble, ds.PropertyMap{
+				"$key": ds.MkPropertyNI(k),
+				"Val":  ds.MkProperty(1),
+			})
+		})
+	})
+}
@@ -30,6 +30,7 @@ import (
 	"golang.org/x/net/context"
 
 	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
 )
 
 func mkProperties(index bool, forceMulti bool, vals ...interface{}) ds.PropertyData {
@@ -102,6 +103,18 @@ func TestDatastore(t *testing.T) {
 		testTime := ds.RoundTime(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
 		_ = testTime
 
+		Convey(`Can be installed on its own with UseDatastore`, func() {
+			c := UseDatastore(context.Background(), "luci-gae-test", client)
+			So(info.AppID(c), ShouldEqual, "luci-gae-test")
+
+			pmap := ds.PropertyMap{"$kind": mkp("UseDatastore"), "$id": mkp(1), "Value": mkp("hi")}
+			So(ds.Put(c, pmap), ShouldBeNil)
+
+			got := ds.PropertyMap{"$kind": mkp("UseDatastore"), "$id": mkp(1)}
+			So(ds.Get(c, got), ShouldBeNil)
+			So(got.Slice("Value")[0].Value(), ShouldEqual, "hi")
+		})
+
 		cfg := Config{ProjectID: "luci-gae-test", DS: client}
 		c = cfg.Use(c, nil)
 
@@ -295,6 +308,26 @@ func TestDatastore(t *testing.T) {
 					})
 				})
 
+				Convey(`Can query for structs, reusing the PropertyMap.`, func() {
+					type Test struct {
+						ID     string `gae:"$id"`
+						FooBar bool
+					}
+					var results []*Test
+					So(ds.GetAll(c, q.Eq("FooBar", true), &results), ShouldBeNil)
+
+					So(results, ShouldResemble, []*Test{
+						{ID: "bar", FooBar: true},
+						{ID: "foo", FooBar: true},
+					})
+
+					var ids []string
+					So(ds.Run(c, q, func(pm ds.PropertyMap) {
+						ids = append(ids, pm.Slice("$key")[0].Value().(*ds.Key).StringID())
+					}), ShouldBeNil)
+					So(ids, ShouldResemble, []string{"bar", "baz", "quux", "quuz", "foo", "qux"})
+				})
+
 				Convey(`Can transactionally get and put.`, func() {
 					err := ds.RunInTransaction(c, func(c context.Context) error {
 						pmap := ds.PropertyMap{"$kind": mkp("Test"), "$id": mkp("qux")}
@@ -350,3 +383,20 @@ func TestDatastore(t *testing.T) {
 		})
 	})
 }
+
+func TestDatastoreWithoutClient(t *testing.T) {
+	t.Parallel()
+
+	Convey("Using a database without a client fails the operations", t, func() {
+		c := UseDatastore(context.Background(), "project", nil)
+		c = ds.WithDatabase(c, "other")
+
+		pm := ds.PropertyMap{"$key": ds.MkPropertyNI(ds.MakeKey(c, "Kind", 1))}
+		So(ds.Get(c, pm), ShouldErrLike, `no datastore client for database "other"`)
+		So(ds.Put(c, pm), ShouldErrLike, `no datastore client for database "other"`)
+		_, err := ds.Count(c, ds.NewQuery("Kind"))
+		So(err, ShouldErrLike, `no datastore client for database "other"`)
+		So(ds.RunInTransaction(c, func(context.Context) error { return nil }, nil),
+			ShouldErrLike, `no datastore client for database "other"`)
+	})
+}
@@ -79,19 +79,79 @@ type Flex struct {
 // that are instantiated.
 func (f *Flex) Configure(c context.Context, opts ...option.ClientOption) (cfg *Config, err error) {
 	// If running on GCE, assume we are on Flex and use the metadata server and
-	// environ to get environment information. When running locally (e.g. during
-	// development), we extract the email from the Default Application Credentials
-	// and provide some fake defaults for non-essential parts of the config.
+	// environ to get environment information.
+	return configureGAE(c, &gaeEnvironment{
+		onGAE:          metadata.OnGCE(),
+		projectEnv:     "GCLOUD_PROJECT",
+		cache:          f.Cache,
+		requestLogName: f.RequestLogName,
+		debugLogName:   f.DebugLogName,
+	}, opts...)
+}
+
+// Request probes Request parameters from a AppEngine Flex Environment HTTP
+// request.
+func (*Flex) Request(c context.Context, req *http.Request) *Request {
+	return probeRequest(c, req)
+}
+
+// probeRequest probes Request parameters from an HTTP request received by
+// a server on Google Cloud.
+func probeRequest(c context.Context, req *http.Request) *Request {
+	r := Request{
+		TraceID:     getCloudTraceContext(req),
+		HTTPRequest: req,
+	}
+
+	// See if a local address is embedded in the Context. This will be the case
+	// when a Context is associated with the HTTP server.
+	localAddr, ok := c.Value(http.LocalAddrContextKey).(net.Addr)
+	if ok {
+		r.LocalAddr = localAddr.String()
+	}
+
+	return &r
+}
+
+// gaeEnvironment describes the App Engine environment that configureGAE
+// configures for.
+type gaeEnvironment struct {
+	// onGAE is true if running in the environment, rather than locally.
+	onGAE bool
+	// projectEnv is the environment variable with the project ID.
+	projectEnv string
+	// projectID, if not nil, returns the project ID when running in the
+	// environment and projectEnv is not set.
+	projectID func() (string, error)
+
+	cache          *lru.Cache
+	requestLogName string
+	debugLogName   string
+}
+
+// configureGAE constructs a Config for env, which is shared by Flex and
+// Standard.
+//
+// When running in env, the metadata server and environ are used to get
+// environment information. When running locally (e.g. during development), we
+// extract the email from the Default Application Credentials and provide some
+// fake defaults for non-essential parts of the config.
+func configureGAE(c context.Context, env *gaeEnvironment, opts ...option.ClientOption) (cfg *Config, err error) {
 	cfg = &Config{
-		// On Flex, STDERR gets logged independently by the Flex container to. This
+		// On App Engine, STDERR gets logged independently by the runtime too. This
 		// adds another path to collect logs when the Stackdriver logging client is
 		// unable to send them.
 		LogToSTDERR: true,
 	}
-	if metadata.OnGCE() {
+	if env.onGAE {
 		if cfg.ServiceAccountName, err = getMetadata("instance/service-accounts/default/email"); err != nil {
 			return nil, err
 		}
+		if env.projectID != nil && os.Getenv(env.projectEnv) == "" {
+			if cfg.ProjectID, err = env.projectID(); err != nil {
+				return nil, errors.Annotate(err, "failed to get project ID").Err()
+			}
+		}
 	} else {
 		ts, err := google.DefaultTokenSource(c, iamAPI.CloudPlatformScope)
 		if err != nil {
@@ -107,19 +167,13 @@ func (f *Flex) Configure(c context.Context, opts ...option.ClientOption) (cfg *C
 		cfg.InstanceID = "local"
 	}
 
-	err = getEnv(map[string]*string{
-		"GCLOUD_PROJECT": &cfg.ProjectID,
-		"GAE_SERVICE":    &cfg.ServiceName,
-		"GAE_VERSION":    &cfg.VersionName,
-		"GAE_INSTANCE":   &cfg.InstanceID,
-	})
-	if err != nil {
+	if err = env.readEnv(cfg); err != nil {
 		return nil, err
 	}
 
 	gsp := GoogleServiceProvider{
 		ServiceAccount: cfg.ServiceAccountName,
-		Cache:          f.Cache,
+		Cache:          env.cache,
 	}
 	if gsp.Cache == nil {
 		gsp.Cache = lru.New(defaultGoogleServicesCacheSize)
@@ -143,53 +197,57 @@ func (f *Flex) Configure(c context.Context, opts ...option.ClientOption) (cfg *C
 
 	// Cloud Logging logger, only when running for real.
 	if !cfg.IsDev {
-		// TODO(vadimsh): Strictly speaking we should close the client when the
-		// process stops, to gracefully flush all pending logs.
-		client, err := cloudLogging.NewClient(c, cfg.ProjectID, opts...)
-		if err != nil {
-			return nil, errors.Annotate(err, "could not create logger client").Err()
-		}
-
-		valueOrDefault := func(v string, def string) string {
-			if v != "" {
-				return v
-			}
-			return def
-		}
-		requestLogName := valueOrDefault(f.RequestLogName, DefaultFlexRequestLogName)
-		debugLogName := valueOrDefault(f.DebugLogName, DefaultFlexDebugLogName)
-
-		resource := mrpb.MonitoredResource{
-			Labels: map[string]string{
-				"module_id":  cfg.ServiceName,
-				"project_id": cfg.ProjectID,
-				"version_id": cfg.VersionName,
-			},
-			Type: "gae_app",
+		if err = configureGAELoggers(c, cfg, env.requestLogName, env.debugLogName, opts...); err != nil {
+			return nil, err
 		}
-		cfg.RequestLogger = client.Logger(requestLogName, cloudLogging.CommonResource(&resource))
-		cfg.DebugLogger = client.Logger(debugLogName, cloudLogging.CommonResource(&resource))
 	}
 
 	return
 }
 
-// Request probes Request parameters from a AppEngine Flex Environment HTTP
-// request.
-func (*Flex) Request(c context.Context, req *http.Request) *Request {
-	r := Request{
-		TraceID:     getCloudTraceContext(req),
-		HTTPRequest: req,
+// readEnv fills in the project and the service, version and instance names of
+// cfg from the environment variables, keeping the values already in cfg for
+// the ones which aren't set.
+func (env *gaeEnvironment) readEnv(cfg *Config) error {
+	return getEnv(map[string]*string{
+		env.projectEnv: &cfg.ProjectID,
+		"GAE_SERVICE":  &cfg.ServiceName,
+		"GAE_VERSION":  &cfg.VersionName,
+		"GAE_INSTANCE": &cfg.InstanceID,
+	})
+}
+
+// configureGAELoggers populates cfg's RequestLogger and DebugLogger with Cloud
+// Logging loggers for the "gae_app" resource described by cfg. Empty log
+// names are replaced with the Flex defaults.
+func configureGAELoggers(c context.Context, cfg *Config, requestLogName, debugLogName string, opts ...option.ClientOption) error {
+	// TODO(vadimsh): Strictly speaking we should close the client when the
+	// process stops, to gracefully flush all pending logs.
+	client, err := cloudLogging.NewClient(c, cfg.ProjectID, opts...)
+	if err != nil {
+		return errors.Annotate(err, "could not create logger client").Err()
 	}
 
-	// See if a local address is embedded in the Context. This will be the case
-	// when a Context is associated with the HTTP server.
-	localAddr, ok := c.Value(http.LocalAddrContextKey).(net.Addr)
-	if ok {
-		r.LocalAddr = localAddr.String()
+	valueOrDefault := func(v string, def string) string {
+		if v != "" {
+			return v
+		}
+		return def
 	}
+	requestLogName = valueOrDefault(requestLogName, DefaultFlexRequestLogName)
+	debugLogName = valueOrDefault(debugLogName, DefaultFlexDebugLogName)
 
-	return &r
+	resource := mrpb.MonitoredResource{
+		Labels: map[string]string{
+			"module_id":  cfg.ServiceName,
+			"project_id": cfg.ProjectID,
+			"version_id": cfg.VersionName,
+		},
+		Type: "gae_app",
+	}
+	cfg.RequestLogger = client.Logger(requestLogName, cloudLogging.CommonResource(&resource))
+	cfg.DebugLogger = client.Logger(debugLogName, cloudLogging.CommonResource(&resource))
+	return nil
 }
 
 func getEnv(kv map[string]*string) error {
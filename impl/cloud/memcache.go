@@ -42,6 +42,20 @@ const (
 	keyHashSizeThreshold = 250
 )
 
+// UseMemcache installs a memcache service backed by the memcached client into
+// c, leaving any other services in c untouched. It's intended for services
+// running outside of App Engine which want to use a memcached cluster (e.g.
+// for "filter/dscache") without building a full Config.
+//
+// Entries are namespaced with the namespace reported by the "info" service,
+// which must be installed in c.
+func UseMemcache(c context.Context, client *memcache.Client) context.Context {
+	mc := memcacheClient{
+		client: client,
+	}
+	return mc.use(c)
+}
+
 // memcacheClient is a "service/memcache" implementation built on top of a
 // "memcached" client connection.
 //
@@ -280,6 +294,10 @@ func (bmc *boundMemcacheClient) Increment(key string, delta int64, initialValue
 	}
 }
 
+func (bmc *boundMemcacheClient) Touch(key string, expiration time.Duration) error {
+	return bmc.translateErr(bmc.client.Touch(bmc.makeKey(key), int32(expiration.Seconds())))
+}
+
 func (bmc *boundMemcacheClient) Flush() error {
 	// Unfortunately there's not really a good way to flush just a single
 	// namespace, so Flush will flush all memcache.
@@ -0,0 +1,347 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+
+	"go.chromium.org/gae/service/info"
+	mc "go.chromium.org/gae/service/memcache"
+
+	"github.com/gomodule/redigo/redis"
+	"golang.org/x/net/context"
+)
+
+// redisFlagsSize is the size of the item flags header which prefixes each
+// value stored in Redis.
+const redisFlagsSize = 4
+
+// UseRedis installs a memcache service backed by the Redis connection pool into
+// c, leaving any other services in c untouched. It's like UseMemcache, but for
+// Redis (e.g. Cloud Memorystore for Redis).
+func UseRedis(c context.Context, pool *redis.Pool) context.Context {
+	rc := redisClient{
+		pool: pool,
+	}
+	return rc.use(c)
+}
+
+// redisClient is a "service/memcache" implementation built on top of a Redis
+// connection pool.
+//
+// Like memcacheClient, entries are differentiated by namespace by prepending
+// "memcacheKeyPrefix:SHA256(namespace):" to each key. Each value is stored
+// prefixed with its item's flags.
+//
+// Redis has no compare-and-swap IDs, so CompareAndSwapMulti compares against
+// the stored value that the item was retrieved with, using WATCH to make the
+// comparison and the update atomic.
+type redisClient struct {
+	pool *redis.Pool
+}
+
+func (rc *redisClient) use(c context.Context) context.Context {
+	return mc.SetRawFactory(c, func(ic context.Context) mc.RawInterface {
+		return &boundRedisClient{
+			redisClient: rc,
+			keyPrefix:   bindMemcacheClient(nil, info.GetNamespace(ic)).keyPrefix,
+		}
+	})
+}
+
+type redisItem struct {
+	key        string
+	value      []byte
+	flags      uint32
+	expiration time.Duration
+
+	// casValue is the raw stored value that this item was retrieved with, or
+	// nil if it wasn't retrieved.
+	casValue []byte
+}
+
+func (it *redisItem) Key() string               { return it.key }
+func (it *redisItem) Value() []byte             { return it.value }
+func (it *redisItem) Flags() uint32             { return it.flags }
+func (it *redisItem) Expiration() time.Duration { return it.expiration }
+
+func (it *redisItem) SetKey(v string) mc.Item {
+	it.key = v
+	return it
+}
+
+func (it *redisItem) SetValue(v []byte) mc.Item {
+	it.value = v
+	return it
+}
+
+func (it *redisItem) SetFlags(v uint32) mc.Item {
+	it.flags = v
+	return it
+}
+
+func (it *redisItem) SetExpiration(v time.Duration) mc.Item {
+	it.expiration = v
+	return it
+}
+
+func (it *redisItem) SetAll(other mc.Item) {
+	origKey := it.key
+
+	var oi redisItem
+	if other != nil {
+		oi = *(other.(*redisItem))
+	}
+	*it = oi
+	it.key = origKey
+}
+
+// encode returns the raw value to store for it.
+func (it *redisItem) encode() []byte {
+	ret := make([]byte, redisFlagsSize+len(it.value))
+	binary.BigEndian.PutUint32(ret, it.flags)
+	copy(ret[redisFlagsSize:], it.value)
+	return ret
+}
+
+// decodeRedisItem decodes the raw stored value for key.
+func decodeRedisItem(key string, raw []byte) (*redisItem, error) {
+	if len(raw) < redisFlagsSize {
+		return nil, errors.New("corrupt memcache value in Redis")
+	}
+	return &redisItem{
+		key:      key,
+		value:    raw[redisFlagsSize:],
+		flags:    binary.BigEndian.Uint32(raw),
+		casValue: raw,
+	}, nil
+}
+
+type boundRedisClient struct {
+	*redisClient
+	keyPrefix string
+}
+
+// makeKey constructs the actual key used with Redis. See
+// boundMemcacheClient.makeKey.
+func (brc *boundRedisClient) makeKey(base string) string {
+	if len(base) > keyHashSizeThreshold {
+		base = hashBytes([]byte(base))
+	}
+	return brc.keyPrefix + base
+}
+
+func (brc *boundRedisClient) NewItem(key string) mc.Item { return &redisItem{key: key} }
+
+// setArgs returns the arguments of a Redis SET command which stores itm.
+func (brc *boundRedisClient) setArgs(itm *redisItem, extra ...interface{}) []interface{} {
+	args := []interface{}{brc.makeKey(itm.key), itm.encode()}
+	if itm.expiration > 0 {
+		args = append(args, "PX", int64(itm.expiration/time.Millisecond))
+	}
+	return append(args, extra...)
+}
+
+func (brc *boundRedisClient) AddMulti(items []mc.Item, cb mc.RawCB) error {
+	conn := brc.pool.Get()
+	defer conn.Close()
+
+	for _, itm := range items {
+		switch _, err := redis.String(conn.Do("SET", brc.setArgs(itm.(*redisItem), "NX")...)); err {
+		case nil:
+			cb(nil)
+		case redis.ErrNil:
+			cb(mc.ErrNotStored)
+		default:
+			cb(err)
+		}
+	}
+	return nil
+}
+
+func (brc *boundRedisClient) SetMulti(items []mc.Item, cb mc.RawCB) error {
+	conn := brc.pool.Get()
+	defer conn.Close()
+
+	for _, itm := range items {
+		_, err := conn.Do("SET", brc.setArgs(itm.(*redisItem))...)
+		cb(err)
+	}
+	return nil
+}
+
+func (brc *boundRedisClient) GetMulti(keys []string, cb mc.RawItemCB) error {
+	conn := brc.pool.Get()
+	defer conn.Close()
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = brc.makeKey(key)
+	}
+	vals, err := redis.ByteSlices(conn.Do("MGET", args...))
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		if vals[i] == nil {
+			cb(nil, mc.ErrCacheMiss)
+			continue
+		}
+		cb(decodeRedisItem(key, vals[i]))
+	}
+	return nil
+}
+
+func (brc *boundRedisClient) DeleteMulti(keys []string, cb mc.RawCB) error {
+	conn := brc.pool.Get()
+	defer conn.Close()
+
+	for _, key := range keys {
+		switch n, err := redis.Int(conn.Do("DEL", brc.makeKey(key))); {
+		case err != nil:
+			cb(err)
+		case n == 0:
+			cb(mc.ErrCacheMiss)
+		default:
+			cb(nil)
+		}
+	}
+	return nil
+}
+
+func (brc *boundRedisClient) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
+	for _, itm := range items {
+		ri := itm.(*redisItem)
+		cb(brc.update(ri.key, func(cur []byte) ([]interface{}, error) {
+			switch {
+			case cur == nil:
+				return nil, mc.ErrNotStored
+			case !bytes.Equal(cur, ri.casValue):
+				return nil, mc.ErrCASConflict
+			}
+			return brc.setArgs(ri), nil
+		}))
+	}
+	return nil
+}
+
+func (brc *boundRedisClient) Increment(key string, delta int64, initialValue *uint64) (newValue uint64, err error) {
+	err = brc.update(key, func(cur []byte) ([]interface{}, error) {
+		itm := &redisItem{key: key}
+		if cur == nil {
+			if initialValue == nil {
+				return nil, mc.ErrCacheMiss
+			}
+			newValue = *initialValue
+		} else {
+			var err error
+			if itm, err = decodeRedisItem(key, cur); err != nil {
+				return nil, err
+			}
+			if newValue, err = strconv.ParseUint(string(itm.value), 10, 64); err != nil {
+				return nil, errors.New("cannot increment a non-numeric value")
+			}
+		}
+
+		// Overflow wraps around (to zero), and underflow is capped at 0.
+		if delta < 0 {
+			if udelta := uint64(-delta); udelta >= newValue {
+				newValue = 0
+			} else {
+				newValue -= udelta
+			}
+		} else {
+			newValue += uint64(delta)
+		}
+		itm.value = []byte(strconv.FormatUint(newValue, 10))
+
+		// Preserve the existing item's expiration.
+		return append(brc.setArgs(itm), "KEEPTTL"), nil
+	})
+	return
+}
+
+// update atomically updates the item stored at key. fn is called with the
+// current raw stored value (nil if there is none), and returns the arguments
+// to a SET command which updates it.
+//
+// If the item is changed concurrently, update retries.
+func (brc *boundRedisClient) update(key string, fn func(cur []byte) ([]interface{}, error)) error {
+	conn := brc.pool.Get()
+	defer conn.Close()
+
+	nativeKey := brc.makeKey(key)
+	for {
+		if _, err := conn.Do("WATCH", nativeKey); err != nil {
+			return err
+		}
+		cur, err := redis.Bytes(conn.Do("GET", nativeKey))
+		if err != nil && err != redis.ErrNil {
+			return err
+		}
+
+		args, err := fn(cur)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
+
+		if err := conn.Send("MULTI"); err != nil {
+			return err
+		}
+		if err := conn.Send("SET", args...); err != nil {
+			return err
+		}
+		switch _, err := redis.Values(conn.Do("EXEC")); err {
+		case nil:
+			return nil
+		case redis.ErrNil:
+			// The WATCHed key changed. Try again.
+		default:
+			return err
+		}
+	}
+}
+
+func (brc *boundRedisClient) Touch(key string, expiration time.Duration) error {
+	return brc.update(key, func(cur []byte) ([]interface{}, error) {
+		if cur == nil {
+			return nil, mc.ErrCacheMiss
+		}
+		itm, err := decodeRedisItem(key, cur)
+		if err != nil {
+			return nil, err
+		}
+		itm.expiration = expiration
+		return brc.setArgs(itm), nil
+	})
+}
+
+func (brc *boundRedisClient) Flush() error {
+	conn := brc.pool.Get()
+	defer conn.Close()
+
+	// Like memcached, there's no way to flush just a single namespace, so Flush
+	// will flush the whole database.
+	_, err := conn.Do("FLUSHDB")
+	return err
+}
+
+func (brc *boundRedisClient) Stats() (*mc.Statistics, error) { return nil, mc.ErrNoStats }
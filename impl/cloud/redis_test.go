@@ -0,0 +1,109 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/service/info"
+	mc "go.chromium.org/gae/service/memcache"
+
+	"github.com/gomodule/redigo/redis"
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var redisServer = flag.String("test.redis-server", "",
+	"[<addr>]:<port> of Redis service to test against. THIS WILL FLUSH THE DATABASE.")
+
+// TestRedis tests the Redis memcache implementation against a live Redis
+// instance. Like TestMemcache, the test assumes ownership of the instance and
+// will flush it, so DO NOT connect this to a production Redis!
+//
+// Starting a local Redis server (on default port 6379) can be done with:
+//
+//	$ redis-server
+//
+// Testing against this service can be done using the flag:
+// "-test.redis-server localhost:6379"
+func TestRedis(t *testing.T) {
+	t.Parallel()
+
+	if *redisServer == "" {
+		t.Logf("No Redis server detected (-test.redis-server). Skipping test suite.")
+		return
+	}
+
+	Convey(fmt.Sprintf(`A Redis memcache instance bound to %q`, *redisServer), t, func() {
+		pool := &redis.Pool{
+			Dial: func() (redis.Conn, error) { return redis.Dial("tcp", *redisServer) },
+		}
+		defer pool.Close()
+
+		cfg := Config{Redis: pool}
+		c := cfg.Use(context.Background(), nil)
+		So(mc.Flush(c), ShouldBeNil)
+
+		Convey(`Can Add, Get and Delete items.`, func() {
+			itm := mc.NewItem(c, "foo").SetValue([]byte("bar")).SetFlags(42).SetExpiration(time.Hour)
+			So(mc.Add(c, itm), ShouldBeNil)
+			So(mc.Add(c, itm), ShouldEqual, mc.ErrNotStored)
+
+			got, err := mc.GetKey(c, "foo")
+			So(err, ShouldBeNil)
+			So(got.Value(), ShouldResemble, []byte("bar"))
+			So(got.Flags(), ShouldEqual, 42)
+
+			Convey(`Which are separated by namespace.`, func() {
+				nc := info.MustNamespace(c, "other")
+				_, err := mc.GetKey(nc, "foo")
+				So(err, ShouldEqual, mc.ErrCacheMiss)
+			})
+
+			So(mc.Delete(c, "foo"), ShouldBeNil)
+			So(mc.Delete(c, "foo"), ShouldEqual, mc.ErrCacheMiss)
+		})
+
+		Convey(`Can CompareAndSwap items.`, func() {
+			So(mc.Set(c, mc.NewItem(c, "foo").SetValue([]byte("bar"))), ShouldBeNil)
+
+			a, err := mc.GetKey(c, "foo")
+			So(err, ShouldBeNil)
+			b, err := mc.GetKey(c, "foo")
+			So(err, ShouldBeNil)
+
+			So(mc.CompareAndSwap(c, a.SetValue([]byte("a"))), ShouldBeNil)
+			So(mc.CompareAndSwap(c, b.SetValue([]byte("b"))), ShouldEqual, mc.ErrCASConflict)
+		})
+
+		Convey(`Can Increment items.`, func() {
+			v, err := mc.IncrementExisting(c, "num", 1)
+			So(err, ShouldEqual, mc.ErrCacheMiss)
+			So(v, ShouldEqual, 0)
+
+			v, err = mc.Increment(c, "num", -3, 10)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 7)
+
+			v, err = mc.Increment(c, "num", -10, 10)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 0)
+		})
+	})
+}
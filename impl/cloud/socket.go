@@ -0,0 +1,49 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"net"
+	"time"
+
+	"go.chromium.org/gae/service/socket"
+
+	"golang.org/x/net/context"
+)
+
+// socketService is a socket.RawInterface which dials directly, using the net
+// package.
+type socketService struct {
+	c context.Context
+}
+
+func (s *socketService) Dial(network, addr string, timeout time.Duration) (net.Conn, error) {
+	d := net.Dialer{Timeout: timeout}
+	return d.DialContext(s.c, network, addr)
+}
+
+func (s *socketService) LookupIP(host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(s.c, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+func (s *socketService) GetTestable() socket.Testable { return nil }
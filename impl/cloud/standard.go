@@ -0,0 +1,88 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"net/http"
+	"os"
+
+	"go.chromium.org/luci/common/data/caching/lru"
+
+	"cloud.google.com/go/compute/metadata"
+	"google.golang.org/api/option"
+
+	"golang.org/x/net/context"
+)
+
+// Standard defines the second-generation Google AppEngine Standard Environment
+// platform (the Go 1.11+ runtimes), where the classic AppEngine APIs used by
+// "impl/prod" are unavailable.
+//
+// Services are backed by Cloud APIs, as they are on Flex. The "info" service
+// is populated from the runtime's environment variables and metadata server.
+// The mail service may be provided with Config.Mail; the remaining services
+// which have no Cloud equivalent are "impl/dummy" stubs.
+type Standard struct {
+	// Cache is the process-global LRU cache instance that services can use to
+	// cache data.
+	//
+	// If Cache is nil, a default cache will be used.
+	Cache *lru.Cache
+
+	// RequestLogName is the name of the per-request log entry that is generated
+	// on request completion.
+	//
+	// If empty, RequestLogName will default to DefaultFlexRequestLogName.
+	RequestLogName string
+
+	// DebugLogName is the log name that will be used for debug logger entries.
+	//
+	// If empty, DebugLogName will default to DefaultFlexDebugLogName.
+	DebugLogName string
+}
+
+// Configure constructs a Config based on the current Standard environment.
+//
+// Configure will instantiate some cloud clients. It is the responsibility of
+// the client to close those instances when finished.
+//
+// opts is the optional set of client options to pass to cloud platform clients
+// that are instantiated.
+func (s *Standard) Configure(c context.Context, opts ...option.ClientOption) (cfg *Config, err error) {
+	return configureGAE(c, s.environment(), opts...)
+}
+
+// environment describes the Standard environment. Unlike Flex:
+//
+//   - The runtime sets GAE_ENV to "standard". Anything else is a local
+//     development execution.
+//   - The project is in GOOGLE_CLOUD_PROJECT, or failing that, in the
+//     metadata server.
+func (s *Standard) environment() *gaeEnvironment {
+	return &gaeEnvironment{
+		onGAE:          os.Getenv("GAE_ENV") == "standard",
+		projectEnv:     "GOOGLE_CLOUD_PROJECT",
+		projectID:      metadata.ProjectID,
+		cache:          s.Cache,
+		requestLogName: s.RequestLogName,
+		debugLogName:   s.DebugLogName,
+	}
+}
+
+// Request probes Request parameters from a AppEngine Standard Environment HTTP
+// request.
+func (*Standard) Request(c context.Context, req *http.Request) *Request {
+	return probeRequest(c, req)
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestStandard(t *testing.T) {
+	Convey("Standard", t, func() {
+		setenv := func(key, value string) {
+			old, ok := os.LookupEnv(key)
+			if value == "" {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, value)
+			}
+			Reset(func() {
+				if ok {
+					os.Setenv(key, old)
+				} else {
+					os.Unsetenv(key)
+				}
+			})
+		}
+		for _, k := range []string{"GAE_ENV", "GCLOUD_PROJECT", "GOOGLE_CLOUD_PROJECT", "GAE_SERVICE", "GAE_VERSION", "GAE_INSTANCE"} {
+			setenv(k, "")
+		}
+
+		s := &Standard{RequestLogName: "request", DebugLogName: "debug"}
+
+		Convey("runs locally unless GAE_ENV is standard", func() {
+			So(s.environment().onGAE, ShouldBeFalse)
+
+			setenv("GAE_ENV", "localdev")
+			So(s.environment().onGAE, ShouldBeFalse)
+
+			setenv("GAE_ENV", "standard")
+			env := s.environment()
+			So(env.onGAE, ShouldBeTrue)
+			So(env.projectID, ShouldNotBeNil)
+			So(env.requestLogName, ShouldEqual, "request")
+			So(env.debugLogName, ShouldEqual, "debug")
+		})
+
+		Convey("reads the project from GOOGLE_CLOUD_PROJECT", func() {
+			setenv("GOOGLE_CLOUD_PROJECT", "project")
+			setenv("GAE_SERVICE", "service")
+			setenv("GAE_VERSION", "version")
+			setenv("GAE_INSTANCE", "instance")
+
+			cfg := &Config{}
+			So(s.environment().readEnv(cfg), ShouldBeNil)
+			So(cfg.ProjectID, ShouldEqual, "project")
+			So(cfg.ServiceName, ShouldEqual, "service")
+			So(cfg.VersionName, ShouldEqual, "version")
+			So(cfg.InstanceID, ShouldEqual, "instance")
+		})
+
+		Convey("doesn't read the project from Flex's GCLOUD_PROJECT", func() {
+			setenv("GCLOUD_PROJECT", "project")
+
+			cfg := &Config{ServiceName: "local", VersionName: "tainted-local", InstanceID: "local"}
+			So(s.environment().readEnv(cfg), ShouldErrLike, `"GOOGLE_CLOUD_PROJECT"`)
+		})
+
+		Convey("keeps the project from the metadata server", func() {
+			cfg := &Config{ProjectID: "from-metadata", ServiceName: "local", VersionName: "tainted-local", InstanceID: "local"}
+			So(s.environment().readEnv(cfg), ShouldBeNil)
+			So(cfg.ProjectID, ShouldEqual, "from-metadata")
+		})
+	})
+}
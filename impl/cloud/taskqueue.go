@@ -0,0 +1,249 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/errors"
+
+	"go.chromium.org/gae/impl/prod/constraints"
+	tq "go.chromium.org/gae/service/taskqueue"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	"github.com/golang/protobuf/ptypes"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"golang.org/x/net/context"
+)
+
+// TasksConfig configures the Cloud Tasks backed taskqueue service.
+//
+// Tasks are created as HTTP target tasks. A task's Path is resolved against
+// TargetHost, unless the task has a "Host" header, in which case that host is
+// used instead (mirroring the classic service's routing).
+//
+// Some classic taskqueue features have no Cloud Tasks equivalent, and using
+// them returns an error:
+//   - pull queues ("PULL" tasks, Lease, LeaseByTag and ModifyLease);
+//   - per-task RetryOptions (retries are configured on the queue);
+//   - task Tags;
+//   - Stats;
+//   - transactional task enqueueing.
+type TasksConfig struct {
+	// Client is the Cloud Tasks client.
+	Client *cloudtasks.Client
+
+	// Location is the Cloud location (e.g. "us-central1") of the queues.
+	Location string
+
+	// TargetHost is the host (e.g. "my-service.example.com") that task paths
+	// are relative to. Tasks are always dispatched over HTTPS.
+	TargetHost string
+}
+
+type cloudTaskQueue struct {
+	cfg       *TasksConfig
+	projectID string
+}
+
+func (ctq *cloudTaskQueue) use(c context.Context) context.Context {
+	return tq.SetRawFactory(c, func(ic context.Context) tq.RawInterface {
+		return &boundTaskQueue{ic, ctq}
+	})
+}
+
+// boundTaskQueue is a bound instance of the cloudTaskQueue installed in the
+// Context.
+type boundTaskQueue struct {
+	context.Context
+
+	*cloudTaskQueue
+}
+
+var _ tq.RawInterface = (*boundTaskQueue)(nil)
+
+func (t *boundTaskQueue) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTaskCB) error {
+	if datastoreTransaction(t).tx != nil {
+		return errors.New("transactional tasks are not supported by Cloud Tasks")
+	}
+
+	queueName = normalizeQueueName(queueName)
+	for _, task := range tasks {
+		req, err := t.createTaskRequest(task, queueName)
+		if err != nil {
+			cb(nil, err)
+			continue
+		}
+
+		created, err := t.cfg.Client.CreateTask(t, req)
+		if err != nil {
+			cb(nil, normalizeTasksError(err))
+			continue
+		}
+
+		ret := task.Duplicate()
+		if ret.Path == "" {
+			ret.Path = "/_ah/queue/" + queueName
+		}
+		if ret.Method == "" {
+			ret.Method = "POST"
+		}
+		ret.Name = created.Name[strings.LastIndex(created.Name, "/")+1:]
+		if st, err := ptypes.Timestamp(created.ScheduleTime); err == nil {
+			ret.ETA, ret.Delay = st, 0
+		}
+		cb(ret, nil)
+	}
+	return nil
+}
+
+func (t *boundTaskQueue) DeleteMulti(tasks []*tq.Task, queueName string, cb tq.RawCB) error {
+	queueName = normalizeQueueName(queueName)
+	for i, task := range tasks {
+		if task.Name == "" {
+			cb(i, errors.New("cannot delete an unnamed task"))
+			continue
+		}
+		err := t.cfg.Client.DeleteTask(t, &taskspb.DeleteTaskRequest{
+			Name: t.taskName(queueName, task.Name),
+		})
+		cb(i, normalizeTasksError(err))
+	}
+	return nil
+}
+
+func (t *boundTaskQueue) Lease(maxTasks int, queueName string, leaseTime time.Duration) ([]*tq.Task, error) {
+	return nil, errors.New("pull queues are not supported by Cloud Tasks")
+}
+
+func (t *boundTaskQueue) LeaseByTag(maxTasks int, queueName string, leaseTime time.Duration, tag string) ([]*tq.Task, error) {
+	return nil, errors.New("pull queues are not supported by Cloud Tasks")
+}
+
+func (t *boundTaskQueue) ModifyLease(task *tq.Task, queueName string, leaseTime time.Duration) error {
+	return errors.New("pull queues are not supported by Cloud Tasks")
+}
+
+func (t *boundTaskQueue) Purge(queueName string) error {
+	_, err := t.cfg.Client.PurgeQueue(t, &taskspb.PurgeQueueRequest{
+		Name: t.queuePath(normalizeQueueName(queueName)),
+	})
+	return normalizeTasksError(err)
+}
+
+func (t *boundTaskQueue) Stats(queueNames []string, cb tq.RawStatsCB) error {
+	return errors.New("queue statistics are not supported by Cloud Tasks")
+}
+
+func (t *boundTaskQueue) Constraints() tq.Constraints { return constraints.TQ() }
+
+func (t *boundTaskQueue) GetTestable() tq.Testable { return nil }
+
+func (t *boundTaskQueue) createTaskRequest(task *tq.Task, queueName string) (*taskspb.CreateTaskRequest, error) {
+	switch {
+	case task.Method == "PULL":
+		return nil, errors.New("pull queues are not supported by Cloud Tasks")
+	case task.RetryOptions != nil:
+		return nil, errors.New("per-task retry options are not supported by Cloud Tasks")
+	case task.Tag != "":
+		return nil, errors.New("task tags are not supported by Cloud Tasks")
+	case task.Delay != 0 && !task.ETA.IsZero():
+		return nil, errors.New("at most one of ETA or Delay may be specified")
+	}
+
+	method := taskspb.HttpMethod_POST
+	if task.Method != "" {
+		m, ok := taskspb.HttpMethod_value[task.Method]
+		if !ok {
+			return nil, fmt.Errorf("unsupported task method %q", task.Method)
+		}
+		method = taskspb.HttpMethod(m)
+	}
+
+	path := task.Path
+	if path == "" {
+		path = "/_ah/queue/" + queueName
+	}
+	host := t.cfg.TargetHost
+	headers := make(map[string]string, len(task.Header))
+	for k, vs := range task.Header {
+		if len(vs) == 0 {
+			continue
+		}
+		if k == "Host" {
+			host = vs[0]
+			continue
+		}
+		headers[k] = strings.Join(vs, ", ")
+	}
+
+	req := &taskspb.CreateTaskRequest{
+		Parent: t.queuePath(queueName),
+		Task: &taskspb.Task{
+			MessageType: &taskspb.Task_HttpRequest{
+				HttpRequest: &taskspb.HttpRequest{
+					Url:        fmt.Sprintf("https://%s%s", host, path),
+					HttpMethod: method,
+					Headers:    headers,
+					Body:       task.Payload,
+				},
+			},
+		},
+	}
+	if task.Name != "" {
+		req.Task.Name = t.taskName(queueName, task.Name)
+	}
+
+	eta := task.ETA
+	if task.Delay != 0 {
+		eta = clock.Now(t).Add(task.Delay)
+	}
+	if !eta.IsZero() {
+		st, err := ptypes.TimestampProto(eta)
+		if err != nil {
+			return nil, err
+		}
+		req.Task.ScheduleTime = st
+	}
+	return req, nil
+}
+
+func (t *boundTaskQueue) queuePath(queueName string) string {
+	return fmt.Sprintf("projects/%s/locations/%s/queues/%s", t.projectID, t.cfg.Location, queueName)
+}
+
+func (t *boundTaskQueue) taskName(queueName, name string) string {
+	return fmt.Sprintf("%s/tasks/%s", t.queuePath(queueName), name)
+}
+
+func normalizeQueueName(queueName string) string {
+	if queueName == "" {
+		return "default"
+	}
+	return queueName
+}
+
+func normalizeTasksError(err error) error {
+	if status.Code(err) == codes.AlreadyExists {
+		return tq.ErrTaskAlreadyAdded
+	}
+	return err
+}
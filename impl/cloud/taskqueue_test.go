@@ -0,0 +1,101 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	tq "go.chromium.org/gae/service/taskqueue"
+	"go.chromium.org/luci/common/clock/testclock"
+
+	"github.com/golang/protobuf/ptypes"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestTaskQueue(t *testing.T) {
+	t.Parallel()
+
+	Convey(`A Cloud Tasks taskqueue`, t, func() {
+		c, _ := testclock.UseTime(context.Background(), testclock.TestTimeUTC)
+		tqi := &boundTaskQueue{c, &cloudTaskQueue{
+			cfg: &TasksConfig{
+				Location:   "us-central1",
+				TargetHost: "example.com",
+			},
+			projectID: "project-id",
+		}}
+
+		Convey(`Maps a task to an HTTP target task.`, func() {
+			h := http.Header{}
+			h.Set("Content-Type", "text/plain")
+			req, err := tqi.createTaskRequest(&tq.Task{
+				Path:    "/work",
+				Payload: []byte("hi"),
+				Header:  h,
+				Method:  "PUT",
+				Name:    "task",
+				Delay:   time.Minute,
+			}, "q")
+			So(err, ShouldBeNil)
+
+			So(req.Parent, ShouldEqual, "projects/project-id/locations/us-central1/queues/q")
+			So(req.Task.Name, ShouldEqual, "projects/project-id/locations/us-central1/queues/q/tasks/task")
+			So(req.Task.GetHttpRequest(), ShouldResemble, &taskspb.HttpRequest{
+				Url:        "https://example.com/work",
+				HttpMethod: taskspb.HttpMethod_PUT,
+				Headers:    map[string]string{"Content-Type": "text/plain"},
+				Body:       []byte("hi"),
+			})
+			eta, err := ptypes.Timestamp(req.Task.ScheduleTime)
+			So(err, ShouldBeNil)
+			So(eta, ShouldResemble, testclock.TestTimeUTC.Add(time.Minute))
+		})
+
+		Convey(`Uses defaults and the Host header.`, func() {
+			h := http.Header{}
+			h.Set("Host", "other.example.com")
+			req, err := tqi.createTaskRequest(&tq.Task{Header: h}, "default")
+			So(err, ShouldBeNil)
+
+			So(req.Task.Name, ShouldEqual, "")
+			So(req.Task.ScheduleTime, ShouldBeNil)
+			So(req.Task.GetHttpRequest().Url, ShouldEqual, "https://other.example.com/_ah/queue/default")
+			So(req.Task.GetHttpRequest().HttpMethod, ShouldEqual, taskspb.HttpMethod_POST)
+			So(req.Task.GetHttpRequest().Headers, ShouldBeEmpty)
+		})
+
+		Convey(`Rejects unsupported tasks.`, func() {
+			for _, task := range []*tq.Task{
+				{Method: "PULL"},
+				{Tag: "tag"},
+				{RetryOptions: &tq.RetryOptions{RetryLimit: 1}},
+				{Delay: time.Second, ETA: testclock.TestTimeUTC},
+				{Method: "BREW"},
+			} {
+				_, err := tqi.createTaskRequest(task, "q")
+				So(err, ShouldNotBeNil)
+			}
+			_, err := tqi.Lease(1, "q", time.Minute)
+			So(err, ShouldErrLike, "not supported")
+		})
+	})
+}
@@ -16,11 +16,18 @@
 // Interfaces.
 //
 // In particular, this includes:
+//   * capability.Interface
 //   * datastore.Interface
+//   * image.Interface
 //   * memcache.Interface
 //   * taskqueue.Interface
 //   * info.Interface
+//   * logs.Interface
 //   * module.Interface
+//   * runtime.Interface
+//   * search.Interface
+//   * socket.Interface
+//   * storage.Interface
 //
 // These dummy implementations panic with an appropriate error message when
 // any of their methods are called. The message looks something like:
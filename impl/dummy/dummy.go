@@ -16,15 +16,25 @@ package dummy
 
 import (
 	"fmt"
+	"io"
+	"net"
+	"net/url"
 	"runtime"
 	"strings"
 	"time"
 
+	"go.chromium.org/gae/service/capability"
 	"go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/image"
 	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/logs"
 	"go.chromium.org/gae/service/mail"
 	"go.chromium.org/gae/service/memcache"
 	"go.chromium.org/gae/service/module"
+	rtS "go.chromium.org/gae/service/runtime"
+	"go.chromium.org/gae/service/search"
+	"go.chromium.org/gae/service/socket"
+	"go.chromium.org/gae/service/storage"
 	"go.chromium.org/gae/service/taskqueue"
 	"go.chromium.org/gae/service/user"
 
@@ -57,16 +67,30 @@ func ni() error {
 			parts := strings.Split(n, ".")
 			if len(parts) > 2 {
 				switch parts[len(parts)-2] {
+				case "cp":
+					iface = "Capability"
 				case "ds":
 					iface = "Datastore"
+				case "im":
+					iface = "Image"
 				case "i":
 					iface = "Info"
+				case "lg":
+					iface = "Logs"
 				case "m":
 					iface = "Mail"
 				case "mc":
 					iface = "Memcache"
 				case "mod":
 					iface = "Module"
+				case "rt":
+					iface = "Runtime"
+				case "se":
+					iface = "Search"
+				case "so":
+					iface = "Socket"
+				case "st":
+					iface = "Storage"
 				case "tq":
 					iface = "TaskQueue"
 				case "u":
@@ -80,11 +104,26 @@ func ni() error {
 	return fmt.Errorf(niFmtStr, iface, funcName)
 }
 
+/////////////////////////////////// cp ////////////////////////////////////
+
+type cp struct{}
+
+func (cp) Enabled(api, name string) bool    { panic(ni()) }
+func (cp) GetTestable() capability.Testable { return nil }
+
+var dummyCapabilityInst = cp{}
+
+// Capability returns a dummy capability.RawInterface implementation suitable
+// for embedding. Every method panics with a message containing the name of the
+// method which was unimplemented.
+func Capability() capability.RawInterface { return dummyCapabilityInst }
+
 /////////////////////////////////// ds ////////////////////////////////////
 
 type ds struct{}
 
 func (ds) AllocateIDs([]*datastore.Key, datastore.NewKeyCB) error { panic(ni()) }
+func (ds) ReserveIDRange(*datastore.Key, int64, int64) error      { panic(ni()) }
 func (ds) PutMulti([]*datastore.Key, []datastore.PropertyMap, datastore.NewKeyCB) error {
 	panic(ni())
 }
@@ -92,9 +131,13 @@ func (ds) GetMulti([]*datastore.Key, datastore.MultiMetaGetter, datastore.GetMul
 	panic(ni())
 }
 func (ds) DeleteMulti([]*datastore.Key, datastore.DeleteMultiCB) error { panic(ni()) }
+func (ds) Mutate([]datastore.RawMutation, datastore.NewKeyCB) error    { panic(ni()) }
 func (ds) DecodeCursor(string) (datastore.Cursor, error)               { panic(ni()) }
 func (ds) Count(*datastore.FinalizedQuery) (int64, error)              { panic(ni()) }
 func (ds) Run(*datastore.FinalizedQuery, datastore.RawRunCB) error     { panic(ni()) }
+func (ds) Aggregate(*datastore.FinalizedQuery, []*datastore.Aggregation) (datastore.AggregationResult, error) {
+	panic(ni())
+}
 func (ds) RunInTransaction(func(context.Context) error, *datastore.TransactionOptions) error {
 	panic(ni())
 }
@@ -122,6 +165,7 @@ func (mc) GetMulti([]string, memcache.RawItemCB) error               { panic(ni(
 func (mc) DeleteMulti([]string, memcache.RawCB) error                { panic(ni()) }
 func (mc) CompareAndSwapMulti([]memcache.Item, memcache.RawCB) error { panic(ni()) }
 func (mc) Increment(string, int64, *uint64) (uint64, error)          { panic(ni()) }
+func (mc) Touch(string, time.Duration) error                         { panic(ni()) }
 func (mc) Flush() error                                              { panic(ni()) }
 func (mc) Stats() (*memcache.Statistics, error)                      { panic(ni()) }
 
@@ -153,6 +197,22 @@ var dummyTQInst = tq{}
 // method which was unimplemented.
 func TaskQueue() taskqueue.RawInterface { return dummyTQInst }
 
+/////////////////////////////////// im ////////////////////////////////////
+
+type im struct{}
+
+func (im) GetServingURL(bucket, name string, opts *image.ServingURLOptions) (*url.URL, error) {
+	panic(ni())
+}
+func (im) DeleteServingURL(bucket, name string) error { panic(ni()) }
+
+var dummyImageInst = im{}
+
+// Image returns a dummy image.RawInterface implementation suitable for
+// embedding. Every method panics with a message containing the name of the
+// method which was unimplemented.
+func Image() image.RawInterface { return dummyImageInst }
+
 /////////////////////////////////// i ////////////////////////////////////
 
 type i struct{}
@@ -219,6 +279,20 @@ var dummyUserInst = u{}
 // was unimplemented.
 func User() user.RawInterface { return dummyUserInst }
 
+/////////////////////////////////// lg ////////////////////////////////////
+
+type lg struct{}
+
+func (lg) Run(q *logs.Query, cb logs.RunCB) error { panic(ni()) }
+func (lg) GetTestable() logs.Testable             { return nil }
+
+var dummyLogsInst = lg{}
+
+// Logs returns a dummy logs.RawInterface implementation suitable for
+// embedding. Every method panics with a message containing the name of the
+// method which was unimplemented.
+func Logs() logs.RawInterface { return dummyLogsInst }
+
 ////////////////////////////////////// m ///////////////////////////////////////
 
 type m struct{}
@@ -245,8 +319,12 @@ func (mod) SetNumInstances(module, version string, instances int) error {
 }
 func (mod) Versions(module string) ([]string, error)     { panic(ni()) }
 func (mod) DefaultVersion(module string) (string, error) { panic(ni()) }
-func (mod) Start(module, version string) error           { panic(ni()) }
-func (mod) Stop(module, version string) error            { panic(ni()) }
+func (mod) Hostname(module, version, instance string) (string, error) {
+	panic(ni())
+}
+func (mod) Start(module, version string) error { panic(ni()) }
+func (mod) Stop(module, version string) error  { panic(ni()) }
+func (mod) GetTestable() module.Testable       { return nil }
 
 var dummyModuleInst = mod{}
 
@@ -254,3 +332,71 @@ var dummyModuleInst = mod{}
 // embedding. Every method panics with a message containing the name of the
 // method which was unimplemented.
 func Module() module.RawInterface { return dummyModuleInst }
+
+/////////////////////////////////// rt ////////////////////////////////////
+
+type rt struct{}
+
+func (rt) Stats() (*rtS.Stats, error) { panic(ni()) }
+func (rt) GetTestable() rtS.Testable  { return nil }
+
+var dummyRuntimeInst = rt{}
+
+// Runtime returns a dummy runtime.RawInterface implementation suitable for
+// embedding. Every method panics with a message containing the name of the
+// method which was unimplemented.
+func Runtime() rtS.RawInterface { return dummyRuntimeInst }
+
+/////////////////////////////////// se ////////////////////////////////////
+
+type se struct{}
+
+func (se) Put(index, id string, doc []search.Field) (string, error) { panic(ni()) }
+func (se) Get(index, id string) ([]search.Field, error)             { panic(ni()) }
+func (se) Delete(index, id string) error                            { panic(ni()) }
+func (se) Search(index, query string, opts *search.SearchOptions, cb search.SearchCB) error {
+	panic(ni())
+}
+
+var dummySearchInst = se{}
+
+// Search returns a dummy search.RawInterface implementation suitable for
+// embedding. Every method panics with a message containing the name of the
+// method which was unimplemented.
+func Search() search.RawInterface { return dummySearchInst }
+
+/////////////////////////////////// so ////////////////////////////////////
+
+type so struct{}
+
+func (so) Dial(network, addr string, timeout time.Duration) (net.Conn, error) { panic(ni()) }
+func (so) LookupIP(host string) ([]net.IP, error)                             { panic(ni()) }
+func (so) GetTestable() socket.Testable                                       { return nil }
+
+var dummySocketInst = so{}
+
+// Socket returns a dummy socket.RawInterface implementation suitable for
+// embedding. Every method panics with a message containing the name of the
+// method which was unimplemented.
+func Socket() socket.RawInterface { return dummySocketInst }
+
+/////////////////////////////////// st ////////////////////////////////////
+
+type st struct{}
+
+func (st) NewReader(bucket, name string) (io.ReadCloser, error) { panic(ni()) }
+func (st) NewWriter(bucket, name string, opts *storage.WriterOptions) (io.WriteCloser, error) {
+	panic(ni())
+}
+func (st) Stat(bucket, name string) (*storage.ObjectAttrs, error) { panic(ni()) }
+func (st) Delete(bucket, name string) error                       { panic(ni()) }
+func (st) List(bucket, prefix string, cb storage.ListCB) error    { panic(ni()) }
+func (st) DefaultBucket() (string, error)                         { panic(ni()) }
+func (st) GetTestable() storage.Testable                          { return nil }
+
+var dummyStorageInst = st{}
+
+// Storage returns a dummy storage.RawInterface implementation suitable for
+// embedding. Every method panics with a message containing the name of the
+// method which was unimplemented.
+func Storage() storage.RawInterface { return dummyStorageInst }
@@ -18,11 +18,18 @@ import (
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
+	cpS "go.chromium.org/gae/service/capability"
 	dsS "go.chromium.org/gae/service/datastore"
+	imS "go.chromium.org/gae/service/image"
 	infoS "go.chromium.org/gae/service/info"
+	logsS "go.chromium.org/gae/service/logs"
 	mailS "go.chromium.org/gae/service/mail"
 	mcS "go.chromium.org/gae/service/memcache"
 	modS "go.chromium.org/gae/service/module"
+	rtS "go.chromium.org/gae/service/runtime"
+	seS "go.chromium.org/gae/service/search"
+	soS "go.chromium.org/gae/service/socket"
+	stS "go.chromium.org/gae/service/storage"
 	tqS "go.chromium.org/gae/service/taskqueue"
 	userS "go.chromium.org/gae/service/user"
 	"golang.org/x/net/context"
@@ -121,5 +128,68 @@ func TestContextAccess(t *testing.T) {
 				modS.List(c)
 			}, ShouldPanicWith, "dummy: method Module.List is not implemented")
 		})
+
+		Convey("Capability", func() {
+			c = cpS.Set(c, Capability())
+			So(cpS.Raw(c), ShouldNotBeNil)
+			So(func() {
+				defer p()
+				_ = cpS.Enabled(c, cpS.DatastoreAPI, cpS.Write)
+			}, ShouldPanicWith, "dummy: method Capability.Enabled is not implemented")
+		})
+
+		Convey("Image", func() {
+			c = imS.Set(c, Image())
+			So(imS.Raw(c), ShouldNotBeNil)
+			So(func() {
+				defer p()
+				_ = imS.DeleteServingURL(c, "bucket", "name")
+			}, ShouldPanicWith, "dummy: method Image.DeleteServingURL is not implemented")
+		})
+
+		Convey("Logs", func() {
+			c = logsS.Set(c, Logs())
+			So(logsS.Raw(c), ShouldNotBeNil)
+			So(func() {
+				defer p()
+				_ = logsS.Run(c, nil, nil)
+			}, ShouldPanicWith, "dummy: method Logs.Run is not implemented")
+		})
+
+		Convey("Runtime", func() {
+			c = rtS.Set(c, Runtime())
+			So(rtS.Raw(c), ShouldNotBeNil)
+			So(func() {
+				defer p()
+				_, _ = rtS.GetStats(c)
+			}, ShouldPanicWith, "dummy: method Runtime.Stats is not implemented")
+		})
+
+		Convey("Search", func() {
+			c = seS.Set(c, Search())
+			So(seS.Raw(c), ShouldNotBeNil)
+			So(func() {
+				defer p()
+				_, _ = seS.Get(c, "index", "id")
+			}, ShouldPanicWith, "dummy: method Search.Get is not implemented")
+		})
+
+		Convey("Socket", func() {
+			c = soS.Set(c, Socket())
+			So(soS.Raw(c), ShouldNotBeNil)
+			So(func() {
+				defer p()
+				_, _ = soS.LookupIP(c, "example.com")
+			}, ShouldPanicWith, "dummy: method Socket.LookupIP is not implemented")
+		})
+
+		Convey("Storage", func() {
+			c = stS.Set(c, Storage())
+			So(stS.Raw(c), ShouldNotBeNil)
+			So(func() {
+				defer p()
+				_, _ = stS.DefaultBucket(c)
+			}, ShouldPanicWith, "dummy: method Storage.DefaultBucket is not implemented")
+		})
 	})
 }
@@ -0,0 +1,87 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sync"
+
+	"go.chromium.org/gae/service/capability"
+	"go.chromium.org/luci/common/data/stringset"
+
+	"golang.org/x/net/context"
+)
+
+type capabilityData struct {
+	sync.RWMutex
+
+	// disabled maps API names to their disabled capabilities.
+	disabled map[string]stringset.Set
+}
+
+// capabilityImpl is a contextual pointer to the current capabilityData.
+type capabilityImpl struct {
+	data *capabilityData
+}
+
+var _ capability.RawInterface = (*capabilityImpl)(nil)
+
+// useCapability adds a capability.RawInterface implementation to context,
+// accessible by capability.Raw(c) or the exported capability methods.
+//
+// All capabilities are enabled until disabled with the Testable.
+func useCapability(c context.Context) context.Context {
+	data := &capabilityData{
+		disabled: map[string]stringset.Set{},
+	}
+	return capability.SetFactory(c, func(ic context.Context) capability.RawInterface {
+		return &capabilityImpl{data}
+	})
+}
+
+func (ci *capabilityImpl) Enabled(api, name string) bool {
+	ci.data.RLock()
+	defer ci.data.RUnlock()
+
+	disabled := ci.data.disabled[api]
+	switch {
+	case disabled == nil:
+		return true
+	case name == capability.All:
+		return disabled.Len() == 0
+	}
+	return !disabled.Has(capability.All) && !disabled.Has(name)
+}
+
+func (ci *capabilityImpl) GetTestable() capability.Testable { return ci }
+
+func (ci *capabilityImpl) SetEnabled(api, name string, enabled bool) {
+	ci.data.Lock()
+	defer ci.data.Unlock()
+
+	disabled := ci.data.disabled[api]
+	switch {
+	case !enabled:
+		if disabled == nil {
+			disabled = stringset.New(1)
+			ci.data.disabled[api] = disabled
+		}
+		disabled.Add(name)
+	case disabled == nil:
+	case name == capability.All:
+		delete(ci.data.disabled, api)
+	default:
+		disabled.Del(name)
+	}
+}
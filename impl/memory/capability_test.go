@@ -0,0 +1,62 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+
+	"go.chromium.org/gae/service/capability"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCapability(t *testing.T) {
+	t.Parallel()
+
+	Convey("capability", t, func() {
+		c := Use(context.Background())
+		tc := capability.GetTestable(c)
+
+		ds := capability.DatastoreAPI
+		So(capability.Enabled(c, ds, capability.Write), ShouldBeTrue)
+		So(capability.Enabled(c, ds, capability.All), ShouldBeTrue)
+
+		Convey("can disable a capability", func() {
+			tc.SetEnabled(ds, capability.Write, false)
+			So(capability.Enabled(c, ds, capability.Write), ShouldBeFalse)
+			So(capability.Enabled(c, ds, capability.All), ShouldBeFalse)
+			So(capability.Enabled(c, ds, "read"), ShouldBeTrue)
+			So(capability.Enabled(c, capability.MemcacheAPI, capability.Write), ShouldBeTrue)
+
+			tc.SetEnabled(ds, capability.Write, true)
+			So(capability.Enabled(c, ds, capability.Write), ShouldBeTrue)
+			So(capability.Enabled(c, ds, capability.All), ShouldBeTrue)
+		})
+
+		Convey("can disable a whole API", func() {
+			tc.SetEnabled(ds, capability.All, false)
+			So(capability.Enabled(c, ds, capability.Write), ShouldBeFalse)
+			So(capability.Enabled(c, ds, "read"), ShouldBeFalse)
+
+			tc.SetEnabled(ds, "read", true)
+			So(capability.Enabled(c, ds, "read"), ShouldBeFalse)
+
+			tc.SetEnabled(ds, capability.All, true)
+			So(capability.Enabled(c, ds, "read"), ShouldBeTrue)
+		})
+	})
+}
@@ -17,8 +17,11 @@ package memory
 import (
 	"errors"
 	"strings"
+	"time"
 
 	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/clock/testclock"
 	"go.chromium.org/luci/common/logging/memlogger"
 
 	"golang.org/x/net/context"
@@ -154,15 +157,23 @@ func UseInfo(c context.Context, aid string) context.Context {
 	return useGI(useGID(c, func(mod *globalInfoData) {
 		mod.appID = aid
 		mod.fqAppID = fqAppID
+		mod.requestID = genRequestID(c, fqAppID)
 	}))
 }
 
 // UseWithAppID adds implementations for the following gae services to the
 // context:
+//   * go.chromium.org/gae/service/capability
 //   * go.chromium.org/gae/service/datastore
+//   * go.chromium.org/gae/service/image
 //   * go.chromium.org/gae/service/info
+//   * go.chromium.org/gae/service/logs
 //   * go.chromium.org/gae/service/mail
 //   * go.chromium.org/gae/service/memcache
+//   * go.chromium.org/gae/service/runtime
+//   * go.chromium.org/gae/service/search
+//   * go.chromium.org/gae/service/socket
+//   * go.chromium.org/gae/service/storage
 //   * go.chromium.org/gae/service/taskqueue
 //   * go.chromium.org/gae/service/user
 //   * go.chromium.org/luci/common/logger (using memlogger)
@@ -180,7 +191,19 @@ func UseInfo(c context.Context, aid string) context.Context {
 func UseWithAppID(c context.Context, aid string) context.Context {
 	c = memlogger.Use(c)
 	c = UseInfo(c, aid) // Panics if UseWithAppID is called twice.
-	return useMod(useMail(useUser(useTQ(useRDS(useMC(c))))))
+	return useRuntime(useSocket(useCapability(useLogs(useImage(useSearch(useStorage(useMod(useMail(useUser(useTQ(useRDS(useMC(c)))))))))))))
+}
+
+// UseWithTestClock installs a testclock.TestClock set to now in c, and then
+// calls Use. Installing the clock first means that every service, including
+// the ones which note the time when they're set up, agrees on the time.
+//
+// Time dependent behavior, like the 'created' and 'updated' fields of
+// datastore structs, TTL policies, memcache expirations and task queue ETAs,
+// can then be tested by advancing the returned clock.
+func UseWithTestClock(c context.Context, now time.Time) (context.Context, testclock.TestClock) {
+	c, tc := testclock.UseTime(c, now)
+	return Use(c), tc
 }
 
 func cur(c context.Context) (memContext, bool) {
@@ -214,6 +237,12 @@ func (d *dsImpl) RunInTransaction(f func(context.Context) error, o *ds.Transacti
 	if d.data.getDisableSpecialEntities() {
 		return errors.New("special entities are disabled. no transactions for you")
 	}
+	d.expire()
+	if o != nil && !o.ReadTime.IsZero() {
+		if _, err := d.data.snapshotAt(o.ReadTime, clock.Now(d).UTC()); err != nil {
+			return err
+		}
+	}
 
 	// Keep in separate function for defers.
 	loopBody := func(applyForReal bool) error {
@@ -233,11 +262,17 @@ func (d *dsImpl) RunInTransaction(f func(context.Context) error, o *ds.Transacti
 			return ds.ErrConcurrentTransaction
 		}
 
+		// Don't commit if the transaction was cancelled while it was running.
+		if err := d.Err(); err != nil {
+			return err
+		}
+
 		commitOp := curMC.beginCommit(d, txnMC)
 		if commitOp == nil {
 			return ds.ErrConcurrentTransaction
 		}
 		commitOp.submit()
+		d.recordHistory()
 		return nil
 	}
 
@@ -247,6 +282,9 @@ func (d *dsImpl) RunInTransaction(f func(context.Context) error, o *ds.Transacti
 		attempts = o.Attempts
 	}
 	for attempt := 0; attempt < attempts; attempt++ {
+		if err := d.Err(); err != nil {
+			return err
+		}
 		if err := loopBody(attempt >= d.data.txnFakeRetry); err != ds.ErrConcurrentTransaction {
 			return err
 		}
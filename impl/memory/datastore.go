@@ -22,6 +22,7 @@ import (
 
 	ds "go.chromium.org/gae/service/datastore"
 	"go.chromium.org/gae/service/info"
+	"go.chromium.org/luci/common/clock"
 )
 
 //////////////////////////////////// public ////////////////////////////////////
@@ -31,15 +32,33 @@ import (
 func useRDS(c context.Context) context.Context {
 	return ds.SetRawFactory(c, func(ic context.Context) ds.RawInterface {
 		kc := ds.GetKeyContext(ic)
+		if kc.Database != "" {
+			return ds.NewErrorRaw(ic, fmt.Errorf("memory datastore only supports the default database, not %q", kc.Database))
+		}
 		memCtx, isTxn := cur(ic)
 		dsd := memCtx.Get(memContextDSIdx)
 		if isTxn {
+			if err := checkAppID(ic, dsd.(*txnDataStoreData).parent.aid); err != nil {
+				return ds.NewErrorRaw(ic, err)
+			}
 			return &txnDsImpl{ic, dsd.(*txnDataStoreData), kc}
 		}
+		if err := checkAppID(ic, dsd.(*dataStoreData).aid); err != nil {
+			return ds.NewErrorRaw(ic, err)
+		}
 		return &dsImpl{ic, dsd.(*dataStoreData), kc}
 	})
 }
 
+// checkAppID returns an error if c targets an app other than aid with
+// ds.WithAppID, since the memory datastore only holds the data of its own app.
+func checkAppID(c context.Context, aid string) error {
+	if appID := ds.GetAppID(c); appID != "" && appID != aid {
+		return fmt.Errorf("memory datastore of app %q can't be used for app %q", aid, appID)
+	}
+	return nil
+}
+
 // NewDatastore creates a new standalone memory implementation of the datastore,
 // suitable for embedding for doing in-memory data organization.
 //
@@ -79,21 +98,53 @@ type dsImpl struct {
 var _ ds.RawInterface = (*dsImpl)(nil)
 
 func (d *dsImpl) AllocateIDs(keys []*ds.Key, cb ds.NewKeyCB) error {
+	if err := d.Err(); err != nil {
+		return err
+	}
 	return d.data.allocateIDs(keys, cb)
 }
 
+func (d *dsImpl) ReserveIDRange(key *ds.Key, start, end int64) error {
+	if err := d.Err(); err != nil {
+		return err
+	}
+	return d.data.reserveIDRange(key, start, end)
+}
+
 func (d *dsImpl) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.NewKeyCB) error {
-	d.data.putMulti(keys, vals, cb, false)
-	return nil
+	if err := d.Err(); err != nil {
+		return err
+	}
+	defer d.recordHistory()
+	return d.data.putMulti(keys, vals, cancelableNewKeyCB(d, cb), false)
 }
 
-func (d *dsImpl) GetMulti(keys []*ds.Key, _meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
-	return d.data.getMulti(keys, cb)
+func (d *dsImpl) GetMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	if err := d.Err(); err != nil {
+		return err
+	}
+	d.expire()
+	snap, err := d.readSnapshot()
+	if err != nil {
+		return err
+	}
+	return d.data.getMulti(keys, meta, cancelableGetMultiCB(d, cb), snap, ds.PropertyMapsReusable(d))
 }
 
 func (d *dsImpl) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
-	d.data.delMulti(keys, cb, false)
-	return nil
+	if err := d.Err(); err != nil {
+		return err
+	}
+	defer d.recordHistory()
+	return d.data.delMulti(keys, cancelableDeleteMultiCB(d, cb), false)
+}
+
+func (d *dsImpl) Mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	if err := d.Err(); err != nil {
+		return err
+	}
+	defer d.recordHistory()
+	return d.data.mutate(muts, cancelableNewKeyCB(d, cb))
 }
 
 func (d *dsImpl) DecodeCursor(s string) (ds.Cursor, error) {
@@ -101,25 +152,92 @@ func (d *dsImpl) DecodeCursor(s string) (ds.Cursor, error) {
 }
 
 func (d *dsImpl) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
-	idx, head := d.data.getQuerySnaps(!fq.EventuallyConsistent())
-	err := executeQuery(fq, d.kc, false, idx, head, cb)
+	if err := d.Err(); err != nil {
+		return err
+	}
+	d.expire()
+	cb, done := d.data.costs.wrapRunCB(fq, cancelableRunCB(d, cb))
+	defer done()
+
+	idx, head, err := d.querySnaps(fq)
+	if err != nil {
+		return err
+	}
+	reusePM := ds.PropertyMapsReusable(d)
+	err = executeQuery(fq, d.kc, false, idx, head, &d.data.queryLog, reusePM, cb)
 	if d.data.maybeAutoIndex(err) {
-		idx, head = d.data.getQuerySnaps(!fq.EventuallyConsistent())
-		err = executeQuery(fq, d.kc, false, idx, head, cb)
+		if idx, head, err = d.querySnaps(fq); err != nil {
+			return err
+		}
+		err = executeQuery(fq, d.kc, false, idx, head, &d.data.queryLog, reusePM, cb)
 	}
 	return err
 }
 
 func (d *dsImpl) Count(fq *ds.FinalizedQuery) (ret int64, err error) {
-	idx, head := d.data.getQuerySnaps(!fq.EventuallyConsistent())
-	ret, err = countQuery(fq, d.kc, false, idx, head)
+	if err := d.Err(); err != nil {
+		return 0, err
+	}
+	d.expire()
+	defer func() { d.data.costs.countQuery(ret) }()
+
+	idx, head, err := d.querySnaps(fq)
+	if err != nil {
+		return 0, err
+	}
+	ret, err = countQuery(fq, d.kc, false, idx, head, &d.data.queryLog)
 	if d.data.maybeAutoIndex(err) {
-		idx, head := d.data.getQuerySnaps(!fq.EventuallyConsistent())
-		ret, err = countQuery(fq, d.kc, false, idx, head)
+		if idx, head, err = d.querySnaps(fq); err != nil {
+			return 0, err
+		}
+		ret, err = countQuery(fq, d.kc, false, idx, head, &d.data.queryLog)
 	}
 	return
 }
 
+// expire deletes the entities which have expired as of now, see
+// dataStoreData.expire.
+func (d *dsImpl) expire() {
+	now := clock.Now(d).UTC()
+	if d.data.expire(now) {
+		d.data.recordHistory(now)
+	}
+}
+
+// recordHistory records the state of the datastore after a write, if history
+// is enabled.
+func (d *dsImpl) recordHistory() {
+	d.data.recordHistory(clock.Now(d).UTC())
+}
+
+// readSnapshot returns the state of the datastore as of the read time of the
+// Context (see ds.WithReadTime), or nil to read the current state.
+func (d *dsImpl) readSnapshot() (memStore, error) {
+	readTime := ds.GetReadTime(d)
+	if readTime.IsZero() {
+		return nil, nil
+	}
+	return d.data.snapshotAt(readTime, clock.Now(d).UTC())
+}
+
+// querySnaps returns the index and entity snapshots fq should run against.
+func (d *dsImpl) querySnaps(fq *ds.FinalizedQuery) (idx, head memStore, err error) {
+	snap, err := d.readSnapshot()
+	switch {
+	case err != nil:
+		return nil, nil, err
+	case snap != nil:
+		// Past states are always consistent.
+		return d.data.maskIndexes(snap), snap, nil
+	}
+	idx, head = d.data.getQuerySnaps(!fq.EventuallyConsistent())
+	return idx, head, nil
+}
+
+func (d *dsImpl) Aggregate(fq *ds.FinalizedQuery, aggs []*ds.Aggregation) (ds.AggregationResult, error) {
+	return ds.AggregateByScan(d, fq, aggs)
+}
+
 func (d *dsImpl) WithoutTransaction() context.Context {
 	// Already not in a Transaction.
 	return d
@@ -136,11 +254,36 @@ func (d *dsImpl) AddIndexes(idxs ...*ds.IndexDefinition) {
 		if !i.Compound() {
 			panic(fmt.Errorf("Attempted to add non-compound index: %s", i))
 		}
+		if err := i.Validate(); err != nil {
+			panic(fmt.Errorf("Attempted to add invalid index: %s", err))
+		}
 	}
 
 	d.data.addIndexes(idxs)
 }
 
+func (d *dsImpl) HideIndexes(idxs ...*ds.IndexDefinition) {
+	d.setIndexesHidden(idxs, true)
+}
+
+func (d *dsImpl) UnhideIndexes(idxs ...*ds.IndexDefinition) {
+	d.setIndexesHidden(idxs, false)
+}
+
+func (d *dsImpl) setIndexesHidden(idxs []*ds.IndexDefinition, hidden bool) {
+	if len(idxs) == 0 {
+		return
+	}
+
+	for _, i := range idxs {
+		if !i.Compound() {
+			panic(fmt.Errorf("Attempted to hide non-compound index: %s", i))
+		}
+	}
+
+	d.data.setIndexesHidden(idxs, hidden)
+}
+
 func (d *dsImpl) Constraints() ds.Constraints { return d.data.getConstraints() }
 
 func (d *dsImpl) TakeIndexSnapshot() ds.TestingSnapshot {
@@ -163,6 +306,10 @@ func (d *dsImpl) Consistent(always bool) {
 	d.data.setConsistent(always)
 }
 
+func (d *dsImpl) KeepHistory(keep bool) {
+	d.data.setKeepHistory(keep, clock.Now(d).UTC())
+}
+
 func (d *dsImpl) AutoIndex(enable bool) {
 	d.data.setAutoIndex(enable)
 }
@@ -179,6 +326,19 @@ func (d *dsImpl) SetConstraints(c *ds.Constraints) error {
 	return nil
 }
 
+func (d *dsImpl) SetIDGenerator(gen ds.IDGenerator) {
+	d.data.setIDGenerator(gen)
+}
+
+func (d *dsImpl) Freeze() { d.data.setFrozen(true) }
+func (d *dsImpl) Thaw()   { d.data.setFrozen(false) }
+
+func (d *dsImpl) Costs() ds.Costs { return d.data.costs.get() }
+func (d *dsImpl) ResetCosts()     { d.data.costs.reset() }
+
+func (d *dsImpl) QueryLog() []ds.QueryLogEntry { return d.data.queryLog.get() }
+func (d *dsImpl) ResetQueryLog()               { d.data.queryLog.reset() }
+
 func (d *dsImpl) GetTestable() ds.Testable { return d }
 
 ////////////////////////////////// txnDsImpl ///////////////////////////////////
@@ -193,25 +353,53 @@ type txnDsImpl struct {
 var _ ds.RawInterface = (*txnDsImpl)(nil)
 
 func (d *txnDsImpl) AllocateIDs(keys []*ds.Key, cb ds.NewKeyCB) error {
+	if err := d.Err(); err != nil {
+		return err
+	}
 	return d.data.parent.allocateIDs(keys, cb)
 }
 
+func (d *txnDsImpl) ReserveIDRange(key *ds.Key, start, end int64) error {
+	if err := d.Err(); err != nil {
+		return err
+	}
+	return d.data.parent.reserveIDRange(key, start, end)
+}
+
 func (d *txnDsImpl) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.NewKeyCB) error {
+	if err := d.Err(); err != nil {
+		return err
+	}
 	return d.data.run(func() error {
-		d.data.putMulti(keys, vals, cb)
-		return nil
+		return d.data.putMulti(keys, vals, cancelableNewKeyCB(d, cb))
 	})
 }
 
-func (d *txnDsImpl) GetMulti(keys []*ds.Key, _meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+func (d *txnDsImpl) GetMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	if err := d.Err(); err != nil {
+		return err
+	}
 	return d.data.run(func() error {
-		return d.data.getMulti(keys, cb)
+		return d.data.getMulti(keys, meta, cancelableGetMultiCB(d, cb), ds.PropertyMapsReusable(d))
 	})
 }
 
 func (d *txnDsImpl) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+	if err := d.Err(); err != nil {
+		return err
+	}
 	return d.data.run(func() error {
-		return d.data.delMulti(keys, cb)
+		return d.data.delMulti(keys, cancelableDeleteMultiCB(d, cb))
+	})
+}
+
+func (d *txnDsImpl) Mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	if err := d.Err(); err != nil {
+		return err
+	}
+	return d.data.run(func() error {
+		// The transaction makes this atomic.
+		return ds.ApplyMutations(d, muts, cancelableNewKeyCB(d, cb))
 	})
 }
 
@@ -227,11 +415,28 @@ func (d *txnDsImpl) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
 	// It's possible that if you have full-consistency and also auto index enabled
 	// that this would make sense... but at that point you should probably just
 	// add the index up front.
-	return executeQuery(q, d.kc, true, d.data.snap, d.data.snap, cb)
+	if err := d.Err(); err != nil {
+		return err
+	}
+	cb, done := d.data.parent.costs.wrapRunCB(q, cancelableRunCB(d, cb))
+	defer done()
+
+	idx := d.data.parent.maskIndexes(d.data.snap)
+	return executeQuery(q, d.kc, true, idx, d.data.snap, &d.data.parent.queryLog, ds.PropertyMapsReusable(d), cb)
 }
 
 func (d *txnDsImpl) Count(fq *ds.FinalizedQuery) (ret int64, err error) {
-	return countQuery(fq, d.kc, true, d.data.snap, d.data.snap)
+	if err := d.Err(); err != nil {
+		return 0, err
+	}
+	defer func() { d.data.parent.costs.countQuery(ret) }()
+
+	idx := d.data.parent.maskIndexes(d.data.snap)
+	return countQuery(fq, d.kc, true, idx, d.data.snap, &d.data.parent.queryLog)
+}
+
+func (d *txnDsImpl) Aggregate(fq *ds.FinalizedQuery, aggs []*ds.Aggregation) (ds.AggregationResult, error) {
+	return ds.AggregateByScan(d, fq, aggs)
 }
 
 func (*txnDsImpl) RunInTransaction(func(c context.Context) error, *ds.TransactionOptions) error {
@@ -249,3 +454,51 @@ func (d *txnDsImpl) CurrentTransaction() ds.Transaction {
 func (d *txnDsImpl) Constraints() ds.Constraints { return d.data.parent.getConstraints() }
 
 func (d *txnDsImpl) GetTestable() ds.Testable { return nil }
+
+///////////////////////////////// cancellation /////////////////////////////////
+
+// cancelableNewKeyCB wraps cb so that a multi operation stops, returning
+// c.Err(), as soon as c is cancelled after processing an entity.
+func cancelableNewKeyCB(c context.Context, cb ds.NewKeyCB) ds.NewKeyCB {
+	return func(idx int, key *ds.Key, err error) error {
+		if cb != nil {
+			if err := cb(idx, key, err); err != nil {
+				return err
+			}
+		}
+		return c.Err()
+	}
+}
+
+// cancelableGetMultiCB is like cancelableNewKeyCB, but for GetMulti.
+func cancelableGetMultiCB(c context.Context, cb ds.GetMultiCB) ds.GetMultiCB {
+	return func(idx int, val ds.PropertyMap, err error) error {
+		if err := cb(idx, val, err); err != nil {
+			return err
+		}
+		return c.Err()
+	}
+}
+
+// cancelableDeleteMultiCB is like cancelableNewKeyCB, but for DeleteMulti.
+func cancelableDeleteMultiCB(c context.Context, cb ds.DeleteMultiCB) ds.DeleteMultiCB {
+	return func(idx int, err error) error {
+		if cb != nil {
+			if err := cb(idx, err); err != nil {
+				return err
+			}
+		}
+		return c.Err()
+	}
+}
+
+// cancelableRunCB wraps cb so that a query stops, returning c.Err(), as soon
+// as c is cancelled.
+func cancelableRunCB(c context.Context, cb ds.RawRunCB) ds.RawRunCB {
+	return func(key *ds.Key, val ds.PropertyMap, getCursor ds.CursorCB) error {
+		if err := c.Err(); err != nil {
+			return err
+		}
+		return cb(key, val, getCursor)
+	}
+}
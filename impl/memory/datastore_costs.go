@@ -0,0 +1,81 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sync/atomic"
+
+	ds "go.chromium.org/gae/service/datastore"
+)
+
+// costCounter tallies simulated datastore operations. It's safe for
+// concurrent use.
+type costCounter struct {
+	entityReads  int64
+	entityWrites int64
+	indexWrites  int64
+	smallOps     int64
+}
+
+func (c *costCounter) read(n int) { atomic.AddInt64(&c.entityReads, int64(n)) }
+
+func (c *costCounter) write(indexRows int) {
+	atomic.AddInt64(&c.entityWrites, 1)
+	atomic.AddInt64(&c.indexWrites, int64(indexRows))
+}
+
+// query accounts for a query which returned n results.
+func (c *costCounter) query(fq *ds.FinalizedQuery, n int64) {
+	atomic.AddInt64(&c.entityReads, 1)
+	if fq.KeysOnly() || len(fq.Project()) > 0 {
+		atomic.AddInt64(&c.smallOps, n)
+	} else {
+		atomic.AddInt64(&c.entityReads, n)
+	}
+}
+
+// countQuery accounts for a Count which counted n results. Counts are
+// executed as keys-only queries.
+func (c *costCounter) countQuery(n int64) {
+	atomic.AddInt64(&c.entityReads, 1)
+	atomic.AddInt64(&c.smallOps, n)
+}
+
+// wrapRunCB returns a RawRunCB which accounts for each result of fq before
+// passing it to cb. done must be called once the query is finished.
+func (c *costCounter) wrapRunCB(fq *ds.FinalizedQuery, cb ds.RawRunCB) (wrapped ds.RawRunCB, done func()) {
+	n := int64(0)
+	wrapped = func(k *ds.Key, pm ds.PropertyMap, gc ds.CursorCB) error {
+		n++
+		return cb(k, pm, gc)
+	}
+	return wrapped, func() { c.query(fq, n) }
+}
+
+func (c *costCounter) get() ds.Costs {
+	return ds.Costs{
+		EntityReads:  atomic.LoadInt64(&c.entityReads),
+		EntityWrites: atomic.LoadInt64(&c.entityWrites),
+		IndexWrites:  atomic.LoadInt64(&c.indexWrites),
+		SmallOps:     atomic.LoadInt64(&c.smallOps),
+	}
+}
+
+func (c *costCounter) reset() {
+	atomic.StoreInt64(&c.entityReads, 0)
+	atomic.StoreInt64(&c.entityWrites, 0)
+	atomic.StoreInt64(&c.indexWrites, 0)
+	atomic.StoreInt64(&c.smallOps, 0)
+}
@@ -17,17 +17,26 @@ package memory
 import (
 	"bytes"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	prodConstraints "go.chromium.org/gae/impl/prod/constraints"
 	ds "go.chromium.org/gae/service/datastore"
 	"go.chromium.org/gae/service/datastore/serialize"
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/data/stringset"
 	"go.chromium.org/luci/common/errors"
 
 	"golang.org/x/net/context"
 )
 
+// ErrFrozen is returned by all mutating datastore operations while the memory
+// datastore is frozen. See Testable.Freeze.
+var ErrFrozen = errors.New("datastore is frozen: writes are not allowed")
+
 //////////////////////////////// dataStoreData /////////////////////////////////
 
 type dataStoreData struct {
@@ -64,6 +73,49 @@ type dataStoreData struct {
 	// constraints is the fake datastore constraints. By default, this will match
 	// the Constraints of the "impl/prod" datastore.
 	constraints ds.Constraints
+
+	// idGen, if not nil, is used to generate IDs for incomplete keys instead of
+	// the sequential per-group counters.
+	idGen ds.IDGenerator
+	// generatedIDs is the set of encoded keys whose IDs were produced by idGen.
+	// It's used to avoid handing out the same ID twice.
+	generatedIDs stringset.Set
+	// reservedIDs maps an ID counter (see reservedIDsKey) to the ID ranges
+	// reserved with ReserveIDRange. It's only consulted when idGen is installed; the
+	// sequential counters are simply advanced past reserved ranges.
+	reservedIDs map[string][]idRange
+
+	// frozen, if true, causes all mutations to fail with ErrFrozen.
+	frozen bool
+
+	// costs tallies the operations performed against this datastore.
+	costs costCounter
+	// queryLog records the queries executed against this datastore.
+	queryLog queryLog
+
+	// hiddenIdxs is the set of compound indexes (see hiddenIdxKey) which queries
+	// will pretend don't exist. See Testable.HideIndexes.
+	hiddenIdxs stringset.Set
+
+	// lastVersion is the last entity version handed out. See README.md for the
+	// "vers:" table.
+	lastVersion int64
+
+	// lastTxnID is the last transaction ID handed out. Use atomic.*Int64 to
+	// access.
+	lastTxnID int64
+
+	// keepHistory, if true, makes the datastore record its past states in
+	// history, for reads at a read time. See Testable.KeepHistory.
+	keepHistory bool
+	// history is the past states of the datastore, oldest first.
+	history []historySnap
+
+	// expiredAt is the time of the last check for expired entities, and
+	// expiredPolicies is the number of TTL policies which were registered then.
+	// See expire.
+	expiredAt       time.Time
+	expiredPolicies int
 }
 
 var (
@@ -103,6 +155,36 @@ func (d *dataStoreData) addIndexes(idxs []*ds.IndexDefinition) {
 	addIndexes(d.head, d.aid, idxs)
 }
 
+func (d *dataStoreData) setIndexesHidden(idxs []*ds.IndexDefinition, hidden bool) {
+	d.rwlock.Lock()
+	defer d.rwlock.Unlock()
+	if d.hiddenIdxs == nil {
+		d.hiddenIdxs = stringset.New(len(idxs))
+	}
+	for _, idx := range idxs {
+		if hidden {
+			d.hiddenIdxs.Add(hiddenIdxKey(idx))
+		} else {
+			d.hiddenIdxs.Del(hiddenIdxKey(idx))
+		}
+	}
+}
+
+// maskIndexes hides the compound indexes in hiddenIdxs from the query planner
+// when it uses idx.
+func (d *dataStoreData) maskIndexes(idx memStore) memStore {
+	d.rwlock.RLock()
+	defer d.rwlock.RUnlock()
+	return d.maskIndexesLocked(idx)
+}
+
+func (d *dataStoreData) maskIndexesLocked(idx memStore) memStore {
+	if d.hiddenIdxs.Len() == 0 {
+		return idx
+	}
+	return &hiddenIdxStore{idx, d.hiddenIdxs.Dup()}
+}
+
 func (d *dataStoreData) setAutoIndex(enable bool) {
 	d.rwlock.Lock()
 	defer d.rwlock.Unlock()
@@ -145,7 +227,7 @@ func (d *dataStoreData) getQuerySnaps(consistent bool) (idx, head memStore) {
 	if d.snap == nil {
 		// we're 'always consistent'
 		snap := d.head.Snapshot()
-		return snap, snap
+		return d.maskIndexesLocked(snap), snap
 	}
 
 	head = d.head.Snapshot()
@@ -154,7 +236,7 @@ func (d *dataStoreData) getQuerySnaps(consistent bool) (idx, head memStore) {
 	} else {
 		idx = d.snap
 	}
-	return
+	return d.maskIndexesLocked(idx), head
 }
 
 func (d *dataStoreData) takeSnapshot() memStore {
@@ -193,7 +275,15 @@ func (d *dataStoreData) namespaces() []string {
 func (d *dataStoreData) getConstraints() ds.Constraints {
 	d.rwlock.RLock()
 	defer d.rwlock.RUnlock()
-	return d.constraints
+
+	c := d.constraints
+	if c.MaxEntityGroupsPerTransaction <= 0 {
+		c.MaxEntityGroupsPerTransaction = xgEGLimit
+	}
+	// These aren't emulated limits, but what the memory datastore can do.
+	c.NoInQueries = true
+	c.NoDistinctCursors = true
+	return c
 }
 
 func (d *dataStoreData) setConstraints(c ds.Constraints) {
@@ -202,6 +292,28 @@ func (d *dataStoreData) setConstraints(c ds.Constraints) {
 	d.constraints = c
 }
 
+func (d *dataStoreData) setIDGenerator(gen ds.IDGenerator) {
+	d.rwlock.Lock()
+	defer d.rwlock.Unlock()
+	d.idGen = gen
+	d.generatedIDs = nil
+	if gen != nil {
+		d.generatedIDs = stringset.New(0)
+	}
+}
+
+func (d *dataStoreData) setFrozen(frozen bool) {
+	d.rwlock.Lock()
+	defer d.rwlock.Unlock()
+	d.frozen = frozen
+}
+
+func (d *dataStoreData) isFrozen() bool {
+	d.rwlock.RLock()
+	defer d.rwlock.RUnlock()
+	return d.frozen
+}
+
 /////////////////////////// indexes(dataStoreData) ////////////////////////////
 
 func groupMetaKey(key *ds.Key) []byte {
@@ -216,6 +328,14 @@ func rootIDsKey(kind string) []byte {
 	return keyBytes(ds.MkKeyContext("", "").NewKey("__entity_root_ids__", kind, 0, nil))
 }
 
+// idsKey returns the key of the counter used to allocate IDs for incomplete.
+func idsKey(incomplete *ds.Key) []byte {
+	if incomplete.Parent() == nil {
+		return rootIDsKey(incomplete.Kind())
+	}
+	return groupIDsKey(incomplete)
+}
+
 func curVersion(ents memCollection, key []byte) int64 {
 	if ents != nil {
 		if v := ents.Get(key); v != nil {
@@ -244,6 +364,16 @@ func incrementLocked(ents memCollection, key []byte, amt int) int64 {
 	return ret
 }
 
+// setVersionLocked records a new version for the entity with encoded key kb
+// in namespace ns, and returns it.
+func (d *dataStoreData) setVersionLocked(ns string, kb []byte) int64 {
+	d.lastVersion++
+	d.head.GetOrCreateCollection("vers:"+ns).Set(kb, serialize.ToBytes(ds.PropertyMap{
+		"__version__": ds.MkPropertyNI(d.lastVersion),
+	}))
+	return d.lastVersion
+}
+
 func (d *dataStoreData) allocateIDs(keys []*ds.Key, cb ds.NewKeyCB) error {
 	// Map keys by entity type.
 	entityMap := make(map[string][]int)
@@ -263,16 +393,17 @@ func (d *dataStoreData) allocateIDs(keys []*ds.Key, cb ds.NewKeyCB) error {
 
 			ents := d.head.GetOrCreateCollection("ents:" + baseKey.Namespace())
 
-			// Allocate IDs. The only possible error is when disableSpecialEntities is
-			// true, in which case we will return a full method error instead of
-			// individual callback errors.
-			start, err := d.allocateIDsLocked(ents, baseKey, len(idxs))
+			// Allocate IDs. The only possible errors are when disableSpecialEntities
+			// is true, when the datastore is frozen, or when the installed ID
+			// generator misbehaves, in which case we will return a full method error
+			// instead of individual callback errors.
+			ids, err := d.allocateIDsLocked(ents, baseKey, len(idxs))
 			if err != nil {
 				return err
 			}
 
 			for i, idx := range idxs {
-				keys[idx] = baseKey.WithID("", start+int64(i))
+				keys[idx] = baseKey.WithID("", ids[i])
 			}
 		}
 		return nil
@@ -288,27 +419,110 @@ func (d *dataStoreData) allocateIDs(keys []*ds.Key, cb ds.NewKeyCB) error {
 	return nil
 }
 
-func (d *dataStoreData) allocateIDsLocked(ents memCollection, incomplete *ds.Key, n int) (int64, error) {
+func (d *dataStoreData) allocateIDsLocked(ents memCollection, incomplete *ds.Key, n int) ([]int64, error) {
+	if d.frozen {
+		return nil, ErrFrozen
+	}
 	if d.disableSpecialEntities {
-		return 0, errors.New("disableSpecialEntities is true so allocateIDs is disabled")
+		return nil, errors.New("disableSpecialEntities is true so allocateIDs is disabled")
+	}
+	if d.idGen != nil {
+		return d.generateIDsLocked(ents, incomplete, n)
 	}
 
-	idKey := []byte(nil)
-	if incomplete.Parent() == nil {
-		idKey = rootIDsKey(incomplete.Kind())
-	} else {
-		idKey = groupIDsKey(incomplete)
+	start := incrementLocked(ents, idsKey(incomplete), n)
+
+	ret := make([]int64, n)
+	for i := range ret {
+		ret[i] = start + int64(i)
 	}
-	return incrementLocked(ents, idKey, n), nil
+	return ret, nil
+}
+
+// idRange is an inclusive range of reserved IDs.
+type idRange struct {
+	start, end int64
+}
+
+func (d *dataStoreData) reserveIDRange(key *ds.Key, start, end int64) error {
+	d.rwlock.Lock()
+	defer d.rwlock.Unlock()
+
+	if d.frozen {
+		return ErrFrozen
+	}
+	if d.disableSpecialEntities {
+		return errors.New("disableSpecialEntities is true so reserveIDRange is disabled")
+	}
+
+	ents := d.head.GetOrCreateCollection("ents:" + key.Namespace())
+	idKey := idsKey(key)
+	if curVersion(ents, idKey) < end {
+		ents.Set(idKey, serialize.ToBytes(ds.PropertyMap{
+			"__version__": ds.MkPropertyNI(end),
+		}))
+	}
+
+	if d.reservedIDs == nil {
+		d.reservedIDs = map[string][]idRange{}
+	}
+	rk := reservedIDsKey(key)
+	d.reservedIDs[rk] = append(d.reservedIDs[rk], idRange{start, end})
+	return nil
+}
+
+// reservedIDsKey returns the reservedIDs key for the ID counter of incomplete.
+func reservedIDsKey(incomplete *ds.Key) string {
+	return incomplete.Namespace() + "\x00" + string(idsKey(incomplete))
+}
+
+// isReservedLocked returns true if id was reserved for incomplete with
+// reserveIDRange.
+func (d *dataStoreData) isReservedLocked(incomplete *ds.Key, id int64) bool {
+	for _, r := range d.reservedIDs[reservedIDsKey(incomplete)] {
+		if id >= r.start && id <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// maxGeneratedIDCollisions is the number of consecutive colliding IDs that
+// generateIDsLocked will tolerate from an IDGenerator before giving up.
+const maxGeneratedIDCollisions = 1000
+
+// generateIDsLocked produces n IDs for incomplete using the installed
+// IDGenerator, skipping IDs which are already in use.
+func (d *dataStoreData) generateIDsLocked(ents memCollection, incomplete *ds.Key, n int) ([]int64, error) {
+	ret := make([]int64, 0, n)
+	collisions := 0
+	for len(ret) < n {
+		id := d.idGen(incomplete)
+		if id <= 0 {
+			return nil, fmt.Errorf("ID generator returned non-positive ID %d for %s", id, incomplete)
+		}
+
+		kb := keyBytes(incomplete.WithID("", id))
+		if d.isReservedLocked(incomplete, id) || ents.Get(kb) != nil || !d.generatedIDs.Add(string(kb)) {
+			if collisions++; collisions > maxGeneratedIDCollisions {
+				return nil, fmt.Errorf("ID generator produced %d colliding IDs in a row for %s",
+					collisions, incomplete)
+			}
+			continue
+		}
+		collisions = 0
+		ret = append(ret, id)
+	}
+	return ret, nil
 }
 
 func (d *dataStoreData) fixKeyLocked(ents memCollection, key *ds.Key) (*ds.Key, error) {
 	if key.IsIncomplete() {
-		id, err := d.allocateIDsLocked(ents, key, 1)
+		ids, err := d.allocateIDsLocked(ents, key, 1)
 		if err != nil {
 			return key, err
 		}
-		key = key.KeyContext().NewKey(key.Kind(), "", id, key.Parent())
+		key = key.KeyContext().NewKey(key.Kind(), "", ids[0], key.Parent())
 	}
 	return key, nil
 }
@@ -336,6 +550,10 @@ func (d *dataStoreData) putMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.Ne
 				defer d.rwlock.Unlock()
 			}
 
+			if d.frozen {
+				return k, ErrFrozen
+			}
+
 			ents := d.head.GetOrCreateCollection("ents:" + ns)
 
 			ret, err = d.fixKeyLocked(ents, k)
@@ -354,7 +572,8 @@ func (d *dataStoreData) putMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.Ne
 				}
 			}
 			ents.Set(keyBytes(ret), dataBytes)
-			updateIndexes(d.head, ret, oldPM, pmap)
+			d.costs.write(updateIndexes(d.head, ret, oldPM, pmap))
+			vals[i].SetMeta(ds.VersionMeta, d.setVersionLocked(ns, keyBytes(ret)))
 			return
 		}()
 		if cb != nil {
@@ -366,29 +585,56 @@ func (d *dataStoreData) putMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.Ne
 	return nil
 }
 
-func getMultiInner(keys []*ds.Key, cb ds.GetMultiCB, ents memCollection) {
-	if ents == nil {
-		for i := range keys {
-			cb(i, nil, ds.ErrNoSuchEntity)
-		}
-		return
+// getMultiInner reads keys from snap. If reusePM is true, a single
+// PropertyMap is reused for all of the entities (see ds.PropertyMapsReusable).
+func getMultiInner(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB, snap memStore, reusePM bool) error {
+	ns := keys[0].Namespace()
+	ents := snap.GetCollection("ents:" + ns)
+	vers := snap.GetCollection("vers:" + ns)
+	var reused ds.PropertyMap
+	if reusePM {
+		reused = ds.PropertyMap{}
 	}
-
 	for i, k := range keys {
-		pdata := ents.Get(keyBytes(k))
+		var pdata []byte
+		if ents != nil {
+			pdata = ents.Get(keyBytes(k))
+		}
+
+		var err error
 		if pdata == nil {
-			cb(i, nil, ds.ErrNoSuchEntity)
+			err = cb(i, nil, ds.ErrNoSuchEntity)
 		} else {
-			pm, err := rpm(pdata)
-			cb(i, pm, err)
+			var pm ds.PropertyMap
+			var rerr error
+			if reused != nil {
+				if rerr = rpmInto(pdata, reused); rerr == nil {
+					pm = reused
+				}
+			} else {
+				pm, rerr = rpm(pdata)
+			}
+			if _, ok := meta.GetSingle(i).GetMeta(ds.VersionMeta); ok && rerr == nil {
+				if v := curVersion(vers, keyBytes(k)); v != 0 {
+					pm.SetMeta(ds.VersionMeta, v)
+				}
+			}
+			err = cb(i, pm, rerr)
+		}
+		if err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-func (d *dataStoreData) getMulti(keys []*ds.Key, cb ds.GetMultiCB) error {
-	ents := d.takeSnapshot().GetCollection("ents:" + keys[0].Namespace())
-	getMultiInner(keys, cb, ents)
-	return nil
+// getMulti reads keys from snap, or from the current state if snap is nil.
+func (d *dataStoreData) getMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB, snap memStore, reusePM bool) error {
+	d.costs.read(len(keys))
+	if snap == nil {
+		snap = d.takeSnapshot()
+	}
+	return getMultiInner(keys, meta, cb, snap, reusePM)
 }
 
 func (d *dataStoreData) delMulti(keys []*ds.Key, cb ds.DeleteMultiCB, lockedAlready bool) error {
@@ -412,19 +658,28 @@ func (d *dataStoreData) delMulti(keys []*ds.Key, cb ds.DeleteMultiCB, lockedAlre
 					defer d.rwlock.Unlock()
 				}
 
+				if d.frozen {
+					return ErrFrozen
+				}
+
 				ents := d.head.GetOrCreateCollection("ents:" + ns)
 
 				if !d.disableSpecialEntities {
 					incrementLocked(ents, groupMetaKey(k), 1)
 				}
+				indexRows := 0
 				if old := ents.Get(kb); old != nil {
 					oldPM, err := rpm(old)
 					if err != nil {
 						return err
 					}
 					ents.Delete(kb)
-					updateIndexes(d.head, k, oldPM, nil)
+					indexRows = updateIndexes(d.head, k, oldPM, nil)
+					if vers := d.head.GetCollection("vers:" + ns); vers != nil {
+						vers.Delete(kb)
+					}
 				}
+				d.costs.write(indexRows)
 				return nil
 			}()
 			if cb != nil {
@@ -443,6 +698,70 @@ func (d *dataStoreData) delMulti(keys []*ds.Key, cb ds.DeleteMultiCB, lockedAlre
 	return nil
 }
 
+// mutate checks the preconditions of all of muts and then applies them, under
+// a single lock so that they're atomic.
+func (d *dataStoreData) mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	keys := make([]*ds.Key, len(muts))
+	errs := make([]error, len(muts))
+	func() {
+		d.rwlock.Lock()
+		defer d.rwlock.Unlock()
+
+		for i, m := range muts {
+			keys[i] = m.Key
+		}
+		if d.frozen {
+			for i := range errs {
+				errs[i] = ErrFrozen
+			}
+			return
+		}
+
+		// Check each mutation against the state left by the ones before it.
+		ents := d.head.GetOrCreateCollection("ents:" + muts[0].Key.Namespace())
+		exists := map[string]bool{}
+		failed := false
+		for i, m := range muts {
+			if m.Key.IsIncomplete() {
+				continue
+			}
+			kb := string(keyBytes(m.Key))
+			e, ok := exists[kb]
+			if !ok {
+				e = ents.Get([]byte(kb)) != nil
+			}
+			switch {
+			case m.Op == ds.MutationInsert && e:
+				errs[i], failed = ds.ErrEntityExists, true
+			case m.Op == ds.MutationUpdate && !e:
+				errs[i], failed = ds.ErrNoSuchEntity, true
+			}
+			exists[kb] = m.Op != ds.MutationDelete
+		}
+		if failed {
+			return
+		}
+
+		for i, m := range muts {
+			if m.Op == ds.MutationDelete {
+				errs[i] = d.delMulti([]*ds.Key{m.Key}, nil, true)
+				continue
+			}
+			errs[i] = d.putMulti([]*ds.Key{m.Key}, []ds.PropertyMap{m.Value}, func(_ int, k *ds.Key, err error) error {
+				keys[i] = k
+				return err
+			}, true)
+		}
+	}()
+
+	for i := range muts {
+		if err := cb(i, keys[i], errs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *dataStoreData) beginCommit(c context.Context, obj memContextObj) txnCommitOp {
 	// TODO(riannucci): implement with Flush/FlushRevert for persistence.
 
@@ -483,6 +802,7 @@ func (d *dataStoreData) beginCommit(c context.Context, obj memContextObj) txnCom
 	return &txnCommitCallback{
 		unlock: unlock,
 		apply: func() {
+			txn.txn.commitInfo = &ds.CommitInfo{ID: txn.txn.id, Time: clock.Now(c).UTC()}
 			for _, muts := range txn.muts {
 				if len(muts) == 0 { // read-only
 					continue
@@ -509,8 +829,9 @@ func (d *dataStoreData) mkTxn(o *ds.TransactionOptions) memContextObj {
 		parent: d,
 		txn: &transactionImpl{
 			isXG: o != nil && o.XG,
+			id:   strconv.FormatInt(atomic.AddInt64(&d.lastTxnID, 1), 10),
 		},
-		snap: d.takeSnapshot(),
+		snap: d.txnSnapshot(o),
 		muts: map[string][]txnMutation{},
 	}
 }
@@ -539,6 +860,9 @@ type txnDataStoreData struct {
 
 var _ memContextObj = (*txnDataStoreData)(nil)
 
+// xgEGLimit is the number of entity groups a cross-group transaction can
+// operate on when the constraints don't set MaxEntityGroupsPerTransaction. As
+// in production, there's always a limit.
 const xgEGLimit = 25
 
 func (td *txnDataStoreData) endTxn() {
@@ -578,6 +902,10 @@ func (td *txnDataStoreData) run(f func() error) error {
 // Returns an error if this key causes the transaction to cross too many entity
 // groups.
 func (td *txnDataStoreData) writeMutation(getOnly bool, key *ds.Key, data ds.PropertyMap) error {
+	if !getOnly && td.parent.isFrozen() {
+		return ErrFrozen
+	}
+
 	rk := string(keyBytes(key.Root()))
 
 	td.lock.Lock()
@@ -586,7 +914,7 @@ func (td *txnDataStoreData) writeMutation(getOnly bool, key *ds.Key, data ds.Pro
 	if _, ok := td.muts[rk]; !ok {
 		limit := 1
 		if td.txn.isXG {
-			limit = xgEGLimit
+			limit = td.parent.getConstraints().MaxEntityGroupsPerTransaction
 		}
 		if len(td.muts)+1 > limit {
 			msg := "cross-group transaction need to be explicitly specified (xg=True)"
@@ -604,35 +932,39 @@ func (td *txnDataStoreData) writeMutation(getOnly bool, key *ds.Key, data ds.Pro
 	return nil
 }
 
-func (td *txnDataStoreData) putMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.NewKeyCB) {
+func (td *txnDataStoreData) putMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.NewKeyCB) error {
 	for i, k := range keys {
 		k, err := td.parent.fixKey(k)
 		if err == nil {
 			err = td.writeMutation(false, k, vals[i])
 		}
 		if cb != nil {
-			cb(i, k, err)
+			if err := cb(i, k, err); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
-func (td *txnDataStoreData) getMulti(keys []*ds.Key, cb ds.GetMultiCB) error {
+func (td *txnDataStoreData) getMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB, reusePM bool) error {
 	for _, key := range keys {
 		err := td.writeMutation(true, key, nil)
 		if err != nil {
 			return err
 		}
 	}
-	ents := td.snap.GetCollection("ents:" + keys[0].Namespace())
-	getMultiInner(keys, cb, ents)
-	return nil
+	td.parent.costs.read(len(keys))
+	return getMultiInner(keys, meta, cb, td.snap, reusePM)
 }
 
 func (td *txnDataStoreData) delMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
 	for i, k := range keys {
 		err := td.writeMutation(false, k, nil)
 		if cb != nil {
-			cb(i, err)
+			if err := cb(i, err); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -647,6 +979,12 @@ func rpm(data []byte) (ds.PropertyMap, error) {
 		serialize.WithContext, ds.MkKeyContext("", ""))
 }
 
+// rpmInto is like rpm, but reads into pm.
+func rpmInto(data []byte, pm ds.PropertyMap) error {
+	return serialize.ReadPropertyMapInto(bytes.NewBuffer(data),
+		serialize.WithContext, ds.MkKeyContext("", ""), pm)
+}
+
 func namespaces(store memStore) []string {
 	var namespaces []string
 	for _, c := range store.GetCollectionNames() {
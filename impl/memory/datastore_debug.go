@@ -0,0 +1,245 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/datastore/serialize"
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/luci/common/data/stringset"
+
+	"golang.org/x/net/context"
+)
+
+// defaultDebugLimit is the number of entities returned by the "/entities"
+// debug endpoint when no limit is specified.
+const defaultDebugLimit = 100
+
+// DatastoreDebugHandler returns an http.Handler which serves JSON views of the
+// contents of the memory datastore in c, which must have been set up with Use.
+// It's intended to be mounted in local development servers, e.g.:
+//
+//	http.Handle("/_debug/datastore/",
+//	  http.StripPrefix("/_debug/datastore", memory.DatastoreDebugHandler(c)))
+//
+// It serves (all GET):
+//
+//	/kinds?ns=NS                                   the kinds in namespace NS
+//	/entities?kind=K&ns=NS&limit=N&cursor=C        entities of kind K
+//	/entity?key=KEY                                the entity with encoded KEY
+//	/indexes                                       the compound indexes
+//
+// The "/entities" response includes a "cursor" field when there may be more
+// results, which can be passed back to get the next page.
+//
+// This handler has no access control of its own, and must not be exposed
+// outside of local development.
+func DatastoreDebugHandler(c context.Context) http.Handler {
+	h := &debugHandler{c, c.Value(&memContextKey).(memContext).Get(memContextDSIdx).(*dataStoreData)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/kinds", h.kinds)
+	mux.HandleFunc("/entities", h.entities)
+	mux.HandleFunc("/entity", h.entity)
+	mux.HandleFunc("/indexes", h.indexes)
+	return mux
+}
+
+type debugHandler struct {
+	c    context.Context
+	data *dataStoreData
+}
+
+type debugEntity struct {
+	Key        string                          `json:"key"`
+	Path       string                          `json:"path"`
+	Properties map[string][]debugPropertyValue `json:"properties"`
+}
+
+type debugPropertyValue struct {
+	Type    string      `json:"type"`
+	Value   interface{} `json:"value"`
+	NoIndex bool        `json:"noindex,omitempty"`
+}
+
+type debugIndexColumn struct {
+	Property   string `json:"property"`
+	Descending bool   `json:"descending,omitempty"`
+}
+
+type debugIndex struct {
+	Kind     string             `json:"kind"`
+	Ancestor bool               `json:"ancestor,omitempty"`
+	Columns  []debugIndexColumn `json:"columns"`
+}
+
+func (h *debugHandler) kinds(w http.ResponseWriter, r *http.Request) {
+	ns := r.FormValue("ns")
+	kinds := stringset.New(0)
+	if ents := h.data.takeSnapshot().GetCollection("ents:" + ns); ents != nil {
+		kctx := ds.MkKeyContext(h.data.aid, ns)
+		ents.ForEachItem(func(ik, _ []byte) bool {
+			prop, err := serialize.ReadProperty(bytes.NewBuffer(ik), serialize.WithoutContext, kctx)
+			memoryCorruption(err)
+			// Skip special kinds, like "__entity_group__".
+			if kind := prop.Value().(*ds.Key).Kind(); !strings.HasPrefix(kind, "__") {
+				kinds.Add(kind)
+			}
+			return true
+		})
+	}
+
+	ret := kinds.ToSlice()
+	sort.Strings(ret)
+	writeDebugJSON(w, map[string]interface{}{"namespace": ns, "kinds": ret})
+}
+
+func (h *debugHandler) entities(w http.ResponseWriter, r *http.Request) {
+	kind := r.FormValue("kind")
+	if kind == "" {
+		http.Error(w, "missing kind", http.StatusBadRequest)
+		return
+	}
+	limit := defaultDebugLimit
+	if l := r.FormValue("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			http.Error(w, fmt.Sprintf("bad limit %q", l), http.StatusBadRequest)
+			return
+		}
+	}
+
+	c, err := info.Namespace(h.c, r.FormValue("ns"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := ds.NewQuery(kind).Limit(int32(limit))
+	if cur := r.FormValue("cursor"); cur != "" {
+		cursor, err := ds.DecodeCursor(c, cur)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad cursor: %s", err), http.StatusBadRequest)
+			return
+		}
+		q = q.Start(cursor)
+	}
+
+	ents := []*debugEntity{}
+	next := ""
+	err = ds.Run(c, q, func(pm ds.PropertyMap, getCursor ds.CursorCB) error {
+		ents = append(ents, newDebugEntity(pm))
+		if len(ents) == limit {
+			cursor, err := getCursor()
+			if err != nil {
+				return err
+			}
+			next = cursor.String()
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ret := map[string]interface{}{"entities": ents}
+	if next != "" {
+		ret["cursor"] = next
+	}
+	writeDebugJSON(w, ret)
+}
+
+func (h *debugHandler) entity(w http.ResponseWriter, r *http.Request) {
+	key, err := ds.NewKeyEncoded(r.FormValue("key"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad key: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	c, err := info.Namespace(h.c, key.Namespace())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pm := ds.PropertyMap{}
+	ds.PopulateKey(pm, key)
+	switch err := ds.Get(c, pm); err {
+	case nil:
+		writeDebugJSON(w, newDebugEntity(pm))
+	case ds.ErrNoSuchEntity:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *debugHandler) indexes(w http.ResponseWriter, r *http.Request) {
+	ret := []*debugIndex{}
+	walkCompIdxs(h.data.takeSnapshot(), nil, func(idx *ds.IndexDefinition) bool {
+		di := &debugIndex{Kind: idx.Kind, Ancestor: idx.Ancestor, Columns: make([]debugIndexColumn, len(idx.SortBy))}
+		for i, col := range idx.SortBy {
+			di.Columns[i] = debugIndexColumn{col.Property, col.Descending}
+		}
+		ret = append(ret, di)
+		return true
+	})
+	writeDebugJSON(w, map[string]interface{}{"indexes": ret})
+}
+
+func newDebugEntity(pm ds.PropertyMap) *debugEntity {
+	key := ds.GetMetaDefault(pm, "key", nil).(*ds.Key)
+	props, _ := pm.Save(false)
+	ret := &debugEntity{
+		Key:        key.Encode(),
+		Path:       key.String(),
+		Properties: make(map[string][]debugPropertyValue, len(props)),
+	}
+	for name := range props {
+		vals := props.Slice(name)
+		dvs := make([]debugPropertyValue, len(vals))
+		for i := range vals {
+			p := &vals[i]
+			dvs[i] = debugPropertyValue{
+				Type:    p.Type().String(),
+				Value:   p.Value(),
+				NoIndex: p.IndexSetting() == ds.NoIndex,
+			}
+			if k, ok := dvs[i].Value.(*ds.Key); ok {
+				dvs[i].Value = k.Encode()
+			}
+		}
+		ret.Properties[name] = dvs
+	}
+	return ret
+}
+
+func writeDebugJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/info"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDatastoreDebugHandler(t *testing.T) {
+	t.Parallel()
+
+	Convey("DatastoreDebugHandler", t, func() {
+		c := Use(context.Background())
+		ds.GetTestable(c).Consistent(true)
+
+		So(ds.Put(c,
+			ds.PropertyMap{"$kind": ds.MkProperty("Foo"), "$id": ds.MkProperty(1), "Val": ds.MkProperty(10)},
+			ds.PropertyMap{"$kind": ds.MkProperty("Foo"), "$id": ds.MkProperty(2), "Val": ds.MkProperty(20)},
+			ds.PropertyMap{"$kind": ds.MkProperty("Bar"), "$id": ds.MkProperty(1), "Ref": ds.MkProperty(ds.MakeKey(c, "Foo", 1))},
+		), ShouldBeNil)
+		So(ds.Put(info.MustNamespace(c, "other"),
+			ds.PropertyMap{"$kind": ds.MkProperty("Baz"), "$id": ds.MkProperty(1)},
+		), ShouldBeNil)
+		ds.GetTestable(c).AddIndexes(ds.NewIndex("Foo").Asc("Val").Desc("Name").MustBuild())
+
+		h := DatastoreDebugHandler(c)
+		get := func(path string, out interface{}) int {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+			if rec.Code == http.StatusOK {
+				So(json.Unmarshal(rec.Body.Bytes(), out), ShouldBeNil)
+			}
+			return rec.Code
+		}
+
+		Convey("lists kinds", func() {
+			var resp struct{ Kinds []string }
+			So(get("/kinds", &resp), ShouldEqual, http.StatusOK)
+			So(resp.Kinds, ShouldResemble, []string{"Bar", "Foo"})
+
+			So(get("/kinds?ns=other", &resp), ShouldEqual, http.StatusOK)
+			So(resp.Kinds, ShouldResemble, []string{"Baz"})
+		})
+
+		Convey("lists entities", func() {
+			var resp struct {
+				Entities []*debugEntity
+				Cursor   string
+			}
+			So(get("/entities?kind=Foo&limit=1", &resp), ShouldEqual, http.StatusOK)
+			So(len(resp.Entities), ShouldEqual, 1)
+			So(resp.Entities[0].Path, ShouldEqual, "dev~app::/Foo,1")
+			So(resp.Entities[0].Properties["Val"][0].Type, ShouldEqual, "PTInt")
+			So(resp.Cursor, ShouldNotEqual, "")
+
+			cursor := resp.Cursor
+			resp.Entities, resp.Cursor = nil, ""
+			So(get("/entities?kind=Foo&cursor="+url.QueryEscape(cursor), &resp), ShouldEqual, http.StatusOK)
+			So(len(resp.Entities), ShouldEqual, 1)
+			So(resp.Entities[0].Path, ShouldEqual, "dev~app::/Foo,2")
+			So(resp.Cursor, ShouldEqual, "")
+
+			So(get("/entities", &resp), ShouldEqual, http.StatusBadRequest)
+			So(get("/entities?kind=Foo&limit=-1", &resp), ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("gets a single entity", func() {
+			var ent debugEntity
+			key := ds.MakeKey(c, "Bar", 1).Encode()
+			So(get("/entity?key="+key, &ent), ShouldEqual, http.StatusOK)
+			So(ent.Key, ShouldEqual, key)
+			So(ent.Properties["Ref"][0].Value, ShouldEqual, ds.MakeKey(c, "Foo", 1).Encode())
+
+			So(get("/entity?key="+ds.MakeKey(c, "Bar", 2).Encode(), &ent), ShouldEqual, http.StatusNotFound)
+			So(get("/entity?key=bogus", &ent), ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("lists indexes", func() {
+			var resp struct{ Indexes []*debugIndex }
+			So(get("/indexes", &resp), ShouldEqual, http.StatusOK)
+			So(resp.Indexes, ShouldResemble, []*debugIndex{{
+				Kind:    "Foo",
+				Columns: []debugIndexColumn{{Property: "Val"}, {Property: "Name", Descending: true}, {Property: "__key__"}},
+			}})
+		})
+	})
+}
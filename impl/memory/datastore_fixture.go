@@ -0,0 +1,301 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
+)
+
+// FixtureKeys maps the refs of the entities in a fixture to their keys.
+type FixtureKeys map[string]*ds.Key
+
+// fixture is the top level of a fixture file.
+type fixture struct {
+	Namespace string          `yaml:"namespace"`
+	Entities  []fixtureEntity `yaml:"entities"`
+}
+
+type fixtureEntity struct {
+	Ref        string                 `yaml:"ref"`
+	Namespace  *string                `yaml:"namespace"`
+	Parent     string                 `yaml:"parent"`
+	Key        []interface{}          `yaml:"key"`
+	Properties map[string]interface{} `yaml:"properties"`
+}
+
+// LoadFixture puts the entities described by the YAML (or JSON) fixture data
+// into the datastore in c, and returns the keys of the entities which have a
+// ref. It's intended to replace long runs of Put calls in test setup code.
+//
+// A fixture looks like:
+//
+//	namespace: ns            # the default namespace of the entities
+//	entities:
+//	- ref: alice             # a name for the entity, used by "parent" and {ref}
+//	  key: [User, alice]     # kind, id pairs; the last id may be omitted
+//	  properties:
+//	    Name: Alice
+//	    Age: 31
+//	- parent: alice          # the ref of the parent entity
+//	  namespace: ""          # overrides the default namespace
+//	  key: [Post]
+//	  properties:
+//	    Author: {ref: alice}
+//	    Tags: [a, b]
+//	    Score: {value: 1.5, noindex: true}
+//	    Created: {type: PTTime, value: "2017-01-02T03:04:05Z"}
+//
+// Integer ids in keys become int IDs, and other ids string IDs. Entities
+// whose key has no final id get an allocated one.
+//
+// Property values are inferred from YAML scalars (int, float, string, bool or
+// null), and lists make multi-valued properties. {ref: R} is the key of the
+// entity with ref R, which must appear earlier in the fixture. Other types
+// are given with {type: T, value: V}, where T and V are in the format of
+// Property's JSON encoding (e.g. a PTTime value is an RFC 3339 string).
+// {noindex: true} makes a property unindexed.
+//
+// All of the entities are put once they've been parsed, so nothing is put if
+// the fixture is invalid.
+func LoadFixture(c context.Context, data []byte) (FixtureKeys, error) {
+	var f fixture
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, errors.Annotate(err, "failed to parse fixture").Err()
+	}
+
+	refs := FixtureKeys{}
+	byNS := map[string][]ds.PropertyMap{}
+	for i, e := range f.Entities {
+		ns := f.Namespace
+		if e.Namespace != nil {
+			ns = *e.Namespace
+		}
+		nc, err := info.Namespace(c, ns)
+		if err != nil {
+			return nil, err
+		}
+
+		pm, err := e.propertyMap(nc, refs)
+		if err != nil {
+			name := e.Ref
+			if name == "" {
+				name = fmt.Sprintf("#%d", i)
+			}
+			return nil, errors.Annotate(err, "fixture entity %s", name).Err()
+		}
+		byNS[ns] = append(byNS[ns], pm)
+	}
+
+	namespaces := make([]string, 0, len(byNS))
+	for ns := range byNS {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		nc, err := info.Namespace(c, ns)
+		if err != nil {
+			return nil, err
+		}
+		if err := ds.Put(nc, byNS[ns]); err != nil {
+			return nil, errors.Annotate(err, "failed to put fixture entities").Err()
+		}
+	}
+	return refs, nil
+}
+
+// LoadFixtureFile is like LoadFixture, but reads the fixture from the file at
+// path.
+func LoadFixtureFile(c context.Context, path string) (FixtureKeys, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read fixture %q", path).Err()
+	}
+	return LoadFixture(c, data)
+}
+
+// propertyMap returns the PropertyMap of e, with its $key. If e has a ref, its
+// key is added to refs.
+func (e *fixtureEntity) propertyMap(c context.Context, refs FixtureKeys) (ds.PropertyMap, error) {
+	key, err := e.key(c, refs)
+	if err != nil {
+		return nil, err
+	}
+	if e.Ref != "" {
+		if _, ok := refs[e.Ref]; ok {
+			return nil, fmt.Errorf("duplicate ref %q", e.Ref)
+		}
+		refs[e.Ref] = key
+	}
+
+	pm := make(ds.PropertyMap, len(e.Properties)+1)
+	for name, v := range e.Properties {
+		if list, ok := v.([]interface{}); ok {
+			ps := make(ds.PropertySlice, len(list))
+			for i, v := range list {
+				if ps[i], err = fixtureProperty(v, refs); err != nil {
+					return nil, errors.Annotate(err, "property %q", name).Err()
+				}
+			}
+			pm[name] = ps
+			continue
+		}
+
+		p, err := fixtureProperty(v, refs)
+		if err != nil {
+			return nil, errors.Annotate(err, "property %q", name).Err()
+		}
+		pm[name] = p
+	}
+	ds.PopulateKey(pm, key)
+	return pm, nil
+}
+
+// key returns the key of e, allocating its ID if it has none.
+func (e *fixtureEntity) key(c context.Context, refs FixtureKeys) (*ds.Key, error) {
+	if len(e.Key) == 0 {
+		return nil, fmt.Errorf("no key")
+	}
+
+	var parent *ds.Key
+	if e.Parent != "" {
+		if parent = refs[e.Parent]; parent == nil {
+			return nil, fmt.Errorf("unknown parent ref %q", e.Parent)
+		}
+	}
+
+	kc := ds.GetKeyContext(c)
+	if parent != nil && parent.Namespace() != kc.Namespace {
+		return nil, fmt.Errorf("parent %s is in another namespace", parent)
+	}
+	key := parent
+	for i := 0; i < len(e.Key); i += 2 {
+		kind, ok := e.Key[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("bad kind %v in key", e.Key[i])
+		}
+		if i+1 == len(e.Key) {
+			key = kc.NewKey(kind, "", 0, key)
+			break
+		}
+		switch id := e.Key[i+1].(type) {
+		case int:
+			key = kc.NewKey(kind, "", int64(id), key)
+		case string:
+			key = kc.NewKey(kind, id, 0, key)
+		default:
+			return nil, fmt.Errorf("bad id %v in key", id)
+		}
+	}
+
+	if key.IsIncomplete() {
+		keys := []*ds.Key{key}
+		if err := ds.AllocateIDs(c, keys); err != nil {
+			return nil, errors.Annotate(err, "failed to allocate an ID").Err()
+		}
+		key = keys[0]
+	}
+	return key, nil
+}
+
+// fixtureProperty returns the Property described by the YAML value v.
+func fixtureProperty(v interface{}, refs FixtureKeys) (ds.Property, error) {
+	var p ds.Property
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		err := p.SetValue(fixtureScalar(v), ds.ShouldIndex)
+		return p, err
+	}
+
+	is := ds.ShouldIndex
+	if noIndex, _ := m["noindex"].(bool); noIndex {
+		is = ds.NoIndex
+	}
+
+	if ref, ok := m["ref"]; ok {
+		key := refs[fmt.Sprint(ref)]
+		if key == nil {
+			return p, fmt.Errorf("unknown ref %q", ref)
+		}
+		err := p.SetValue(key, is)
+		return p, err
+	}
+
+	typ, ok := m["type"]
+	if !ok {
+		err := p.SetValue(fixtureScalar(m["value"]), is)
+		return p, err
+	}
+
+	// Reuse Property's JSON decoding for explicitly typed values.
+	value, err := json.Marshal(jsonCompatible(m["value"]))
+	if err != nil {
+		return p, err
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"type":    typ,
+		"value":   json.RawMessage(value),
+		"noindex": is == ds.NoIndex,
+	})
+	if err != nil {
+		return p, err
+	}
+	err = p.UnmarshalJSON(data)
+	return p, err
+}
+
+// fixtureScalar converts the YAML scalar v to a type accepted by
+// Property.SetValue.
+func fixtureScalar(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case time.Time:
+		return v.UTC()
+	}
+	return v
+}
+
+// jsonCompatible converts the YAML mappings in v to map[string]interface{},
+// so they can be marshaled to JSON.
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for k, v := range v {
+			ret[fmt.Sprint(k)] = jsonCompatible(v)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, v := range v {
+			ret[i] = jsonCompatible(v)
+		}
+		return ret
+	}
+	return v
+}
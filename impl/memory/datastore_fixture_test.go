@@ -0,0 +1,128 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/info"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+const testFixture = `
+namespace: ns
+entities:
+- ref: alice
+  key: [User, alice]
+  properties:
+    Name: Alice
+    Age: 31
+- ref: post
+  parent: alice
+  key: [Post]
+  properties:
+    Author: {ref: alice}
+    Tags: [a, b]
+    Score: {value: 1.5, noindex: true}
+    Created: {type: PTTime, value: "2017-01-02T03:04:05Z"}
+    Where: {type: PTGeoPoint, value: {lat: 1, lng: 2}}
+- key: [Config, 1]
+  namespace: ""
+  properties:
+    Enabled: true
+    Gone: null
+`
+
+func TestLoadFixture(t *testing.T) {
+	t.Parallel()
+
+	Convey("LoadFixture", t, func() {
+		c := Use(context.Background())
+		ds.GetTestable(c).Consistent(true)
+
+		nc, err := info.Namespace(c, "ns")
+		So(err, ShouldBeNil)
+		kc := ds.GetKeyContext(nc)
+
+		Convey("loads entities", func() {
+			refs, err := LoadFixture(c, []byte(testFixture))
+			So(err, ShouldBeNil)
+			So(refs["alice"], ShouldResemble, kc.MakeKey("User", "alice"))
+			So(refs["post"].Parent(), ShouldResemble, refs["alice"])
+			So(refs["post"].IsIncomplete(), ShouldBeFalse)
+
+			user := ds.PropertyMap{"$key": ds.MkPropertyNI(refs["alice"])}
+			So(ds.Get(nc, user), ShouldBeNil)
+			So(user, ShouldResemble, ds.PropertyMap{
+				"$key": ds.MkPropertyNI(refs["alice"]),
+				"Name": ds.MkProperty("Alice"),
+				"Age":  ds.MkProperty(31),
+			})
+
+			post := ds.PropertyMap{"$key": ds.MkPropertyNI(refs["post"])}
+			So(ds.Get(nc, post), ShouldBeNil)
+			So(post, ShouldResemble, ds.PropertyMap{
+				"$key":    ds.MkPropertyNI(refs["post"]),
+				"Author":  ds.MkProperty(refs["alice"]),
+				"Tags":    ds.PropertySlice{ds.MkProperty("a"), ds.MkProperty("b")},
+				"Score":   ds.MkPropertyNI(1.5),
+				"Created": ds.MkProperty(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)),
+				"Where":   ds.MkProperty(ds.GeoPoint{Lat: 1, Lng: 2}),
+			})
+
+			cfg := ds.PropertyMap{}
+			ds.PopulateKey(cfg, ds.GetKeyContext(c).MakeKey("Config", 1))
+			So(ds.Get(c, cfg), ShouldBeNil)
+			So(cfg["Enabled"], ShouldResemble, ds.MkProperty(true))
+			So(cfg["Gone"], ShouldResemble, ds.MkProperty(nil))
+		})
+
+		Convey("puts nothing if the fixture is invalid", func() {
+			_, err := LoadFixture(c, []byte(`
+entities:
+- ref: a
+  key: [A, 1]
+- key: [B, 1]
+  properties:
+    Ref: {ref: nope}
+`))
+			So(err, ShouldErrLike, `fixture entity #1: property "Ref": unknown ref "nope"`)
+
+			n, err := ds.Count(c, ds.NewQuery("A"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+		})
+
+		Convey("rejects bad fixtures", func() {
+			load := func(s string) error {
+				_, err := LoadFixture(c, []byte(s))
+				return err
+			}
+			So(load("entities: {"), ShouldErrLike, "failed to parse fixture")
+			So(load("entities: [{properties: {A: 1}}]"), ShouldErrLike, "no key")
+			So(load("entities: [{key: [1, 2]}]"), ShouldErrLike, "bad kind 1")
+			So(load("entities: [{key: [A, 1.5]}]"), ShouldErrLike, "bad id 1.5")
+			So(load("entities: [{key: [A, 1], parent: nope}]"), ShouldErrLike, `unknown parent ref "nope"`)
+			So(load("entities: [{ref: a, key: [A, 1]}, {ref: a, key: [A, 2]}]"), ShouldErrLike, `duplicate ref "a"`)
+			So(load("entities: [{key: [A, 1], properties: {T: {type: PTNope, value: 1}}}]"), ShouldErrLike, "bad property type")
+		})
+	})
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+)
+
+// historyRetention is how long past states of the datastore are kept for reads
+// at a read time, like the version retention period of Cloud Datastore.
+const historyRetention = time.Hour
+
+// historySnap is the state of the datastore since a point in time.
+type historySnap struct {
+	since time.Time
+	snap  memStore
+}
+
+func (d *dataStoreData) setKeepHistory(keep bool, now time.Time) {
+	d.rwlock.Lock()
+	defer d.rwlock.Unlock()
+	d.keepHistory = keep
+	d.history = nil
+	if keep {
+		d.history = []historySnap{{now, d.head.Snapshot()}}
+	}
+}
+
+// recordHistory records the current state of the datastore as of now, if
+// history is enabled. It's called after every write.
+func (d *dataStoreData) recordHistory(now time.Time) {
+	d.rwlock.Lock()
+	defer d.rwlock.Unlock()
+	if !d.keepHistory {
+		return
+	}
+	d.history = append(d.history, historySnap{now, d.head.Snapshot()})
+
+	// Drop the states which were replaced before the retention period.
+	cutoff := now.Add(-historyRetention)
+	i := 0
+	for i+1 < len(d.history) && !d.history[i+1].since.After(cutoff) {
+		i++
+	}
+	d.history = d.history[i:]
+}
+
+// historyAt returns the state of the datastore at readTime.
+func (d *dataStoreData) historyAt(readTime time.Time) (memStore, error) {
+	d.rwlock.RLock()
+	defer d.rwlock.RUnlock()
+	if !d.keepHistory {
+		return nil, fmt.Errorf("datastore: reads at a read time require history, see Testable.KeepHistory")
+	}
+	i := sort.Search(len(d.history), func(i int) bool { return d.history[i].since.After(readTime) })
+	if i == 0 {
+		return nil, fmt.Errorf("datastore: no history at read time %s", readTime)
+	}
+	return d.history[i-1].snap, nil
+}
+
+// snapshotAt returns the state of the datastore at readTime, which must be
+// within the retention period before now.
+func (d *dataStoreData) snapshotAt(readTime, now time.Time) (memStore, error) {
+	switch {
+	case readTime.After(now):
+		return nil, fmt.Errorf("datastore: read time %s is in the future", readTime)
+	case readTime.Before(now.Add(-historyRetention)):
+		return nil, fmt.Errorf("datastore: read time %s is older than %s", readTime, historyRetention)
+	}
+	return d.historyAt(readTime)
+}
+
+// txnSnapshot returns the snapshot a transaction with options o reads from.
+// The ReadTime of o must have been checked with snapshotAt.
+func (d *dataStoreData) txnSnapshot(o *ds.TransactionOptions) memStore {
+	if o != nil && !o.ReadTime.IsZero() {
+		if snap, err := d.historyAt(o.ReadTime); err == nil {
+			return snap
+		}
+	}
+	return d.takeSnapshot()
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"math/rand"
+	"sync"
+
+	ds "go.chromium.org/gae/service/datastore"
+)
+
+// maxScatteredID is the (exclusive) upper bound of IDs produced by
+// ScatteredIDs. Production scattered IDs fit into 53 bits so that they can be
+// represented exactly by a float64 (e.g. in JavaScript).
+const maxScatteredID = int64(1) << 52
+
+// SequentialIDs returns a ds.IDGenerator which hands out sequential IDs
+// starting at start, shared across all Kinds and entity groups.
+//
+// This is useful for golden tests, which need IDs that are stable and easy to
+// read. Install it with ds.Testable.SetIDGenerator.
+func SequentialIDs(start int64) ds.IDGenerator {
+	if start <= 0 {
+		start = 1
+	}
+	var lock sync.Mutex
+	next := start
+	return func(*ds.Key) int64 {
+		lock.Lock()
+		defer lock.Unlock()
+		ret := next
+		next++
+		return ret
+	}
+}
+
+// ScatteredIDs returns a ds.IDGenerator which hands out IDs spread over the
+// same range as production's scattered ID allocation policy.
+//
+// The sequence of IDs is fully determined by seed, so tests which need
+// realistic-looking IDs can still be reproducible. Install it with
+// ds.Testable.SetIDGenerator.
+func ScatteredIDs(seed int64) ds.IDGenerator {
+	var lock sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+	return func(*ds.Key) int64 {
+		lock.Lock()
+		defer lock.Unlock()
+		return r.Int63n(maxScatteredID-1) + 1
+	}
+}
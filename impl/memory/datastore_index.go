@@ -16,40 +16,41 @@ package memory
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"fmt"
-	"sort"
+	"strings"
 
 	ds "go.chromium.org/gae/service/datastore"
 	"go.chromium.org/gae/service/datastore/serialize"
+	"go.chromium.org/luci/common/data/stringset"
 )
 
-type qIndexSlice []*ds.IndexDefinition
-
-func (s qIndexSlice) Len() int           { return len(s) }
-func (s qIndexSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
-func (s qIndexSlice) Less(i, j int) bool { return s[i].Less(s[j]) }
-
-func defaultIndexes(kind string, pmap ds.PropertyMap) []*ds.IndexDefinition {
-	ret := make(qIndexSlice, 0, 2*len(pmap)+1)
-	ret = append(ret, &ds.IndexDefinition{Kind: kind})
-	for name := range pmap {
-		pvals := pmap.Slice(name)
-		needsIndex := false
-		for _, v := range pvals {
-			if v.IndexSetting() == ds.ShouldIndex {
-				needsIndex = true
-				break
-			}
-		}
-		if !needsIndex {
-			continue
-		}
-		ret = append(ret, &ds.IndexDefinition{Kind: kind, SortBy: []ds.IndexColumn{{Property: name}}})
-		ret = append(ret, &ds.IndexDefinition{Kind: kind, SortBy: []ds.IndexColumn{{Property: name, Descending: true}}})
+// scatterRate controls the fraction of entities (1 in scatterRate) which get
+// a __scatter__ property. This approximates production, where about 0.8% of
+// entities have one.
+const scatterRate = 128
+
+// withScatter returns pm with the hidden __scatter__ property added, if k is
+// one of the keys which should have one. Otherwise it returns pm unchanged.
+//
+// Which keys get a __scatter__ property, and its value, are derived from
+// a hash of the key, so they're deterministic and uniformly distributed. The
+// property only ever appears in the indexes; it's never stored with the
+// entity.
+func withScatter(k *ds.Key, pm ds.PropertyMap) ds.PropertyMap {
+	if strings.HasPrefix(k.Kind(), "__") {
+		return pm
 	}
-	if serializationDeterministic {
-		sort.Sort(ret)
+	h := sha1.Sum(serialize.ToBytes(k))
+	if h[0]%scatterRate != 0 {
+		return pm
 	}
+
+	ret := make(ds.PropertyMap, len(pm)+1)
+	for name, v := range pm {
+		ret[name] = v
+	}
+	ret["__scatter__"] = ds.MkProperty(h[1:3])
 	return ret
 }
 
@@ -63,8 +64,9 @@ func indexEntriesWithBuiltins(k *ds.Key, pm ds.PropertyMap, complexIdxs []*ds.In
 	if pm == nil {
 		return newMemStore()
 	}
+	pm = withScatter(k, pm)
 	sip = serialize.PropertyMapPartially(k, pm)
-	return indexEntries(k, sip, append(defaultIndexes(k.Kind(), pm), complexIdxs...))
+	return indexEntries(k, sip, append(ds.BuiltinIndexes(k.Kind(), pm), complexIdxs...))
 }
 
 // indexRowGen contains enough information to generate all of the index rows which
@@ -201,7 +203,92 @@ func walkCompIdxs(store memStore, endsWith *ds.IndexDefinition, cb func(*ds.Inde
 	}
 }
 
-func mergeIndexes(ns string, store, oldIdx, newIdx memStore) {
+// hiddenIdxKey returns the key under which the compound index idx is stored in
+// the "idx" collection.
+func hiddenIdxKey(idx *ds.IndexDefinition) string {
+	return string(serialize.ToBytes(*idx.Normalize().PrepForIdxTable()))
+}
+
+// hiddenIdxStore wraps a read-only index memStore, making a set of compound
+// indexes appear as if they were never defined.
+//
+// The index rows themselves are left untouched; it's only the query planner's
+// view of which compound indexes exist that's altered.
+type hiddenIdxStore struct {
+	memStore
+
+	// hidden is the set of hiddenIdxKey's to hide.
+	hidden stringset.Set
+}
+
+func (s *hiddenIdxStore) GetCollection(name string) memCollection {
+	coll := s.memStore.GetCollection(name)
+	if coll == nil {
+		return nil
+	}
+	if name == "idx" {
+		return &hiddenIdxCollection{coll, s.hidden}
+	}
+	if rest, ok := trimPrefix(name, "idx:"); ok {
+		// "idx:<namespace>:<index>"; namespaces may not contain ':'.
+		if i := strings.IndexByte(rest, ':'); i >= 0 && s.hidden.Has(rest[i+1:]) {
+			return nil
+		}
+	}
+	return coll
+}
+
+func (s *hiddenIdxStore) Snapshot() memStore { return s }
+
+// hiddenIdxCollection is the "idx" collection of a hiddenIdxStore.
+type hiddenIdxCollection struct {
+	memCollection
+
+	hidden stringset.Set
+}
+
+func (c *hiddenIdxCollection) Get(k []byte) []byte {
+	if c.hidden.Has(string(k)) {
+		return nil
+	}
+	return c.memCollection.Get(k)
+}
+
+func (c *hiddenIdxCollection) MinItem() *storeEntry {
+	return c.Iterator(nil).Next()
+}
+
+func (c *hiddenIdxCollection) Iterator(pivot []byte) memIterator {
+	return &hiddenIdxIterator{c.memCollection.Iterator(pivot), c.hidden}
+}
+
+func (c *hiddenIdxCollection) ForEachItem(fn memVisitor) {
+	c.memCollection.ForEachItem(func(k, v []byte) bool {
+		if c.hidden.Has(string(k)) {
+			return true
+		}
+		return fn(k, v)
+	})
+}
+
+type hiddenIdxIterator struct {
+	base   memIterator
+	hidden stringset.Set
+}
+
+func (it *hiddenIdxIterator) Next() *storeEntry {
+	for {
+		ent := it.base.Next()
+		if ent == nil || !it.hidden.Has(string(ent.key)) {
+			return ent
+		}
+	}
+}
+
+// mergeIndexes applies the difference between the index rows in oldIdx and
+// newIdx to store. It returns the number of index rows which were added or
+// removed.
+func mergeIndexes(ns string, store, oldIdx, newIdx memStore) (changed int) {
 	prefixBuf := []byte("idx:" + ns + ":")
 	origPrefixBufLen := len(prefixBuf)
 
@@ -221,11 +308,13 @@ func mergeIndexes(ns string, store, oldIdx, newIdx memStore) {
 		case ov == nil && nv != nil: // all additions
 			newColl.ForEachItem(func(k, _ []byte) bool {
 				coll.Set(k, []byte{})
+				changed++
 				return true
 			})
 		case ov != nil && nv == nil: // all deletions
 			oldColl.ForEachItem(func(k, _ []byte) bool {
 				coll.Delete(k)
+				changed++
 				return true
 			})
 		case ov != nil && nv != nil: // merge
@@ -235,6 +324,9 @@ func mergeIndexes(ns string, store, oldIdx, newIdx memStore) {
 				} else {
 					coll.Set(k, []byte{})
 				}
+				if ov == nil || nv == nil {
+					changed++
+				}
 			})
 		default:
 			impossible(fmt.Errorf("both values from memStoreCollide were nil?"))
@@ -242,6 +334,7 @@ func mergeIndexes(ns string, store, oldIdx, newIdx memStore) {
 		// TODO(riannucci): remove entries from idxColl and remove index collections
 		// when there are no index entries for that index any more.
 	})
+	return
 }
 
 func addIndexes(store memStore, aid string, compIdx []*ds.IndexDefinition) {
@@ -264,7 +357,7 @@ func addIndexes(store memStore, aid string, compIdx []*ds.IndexDefinition) {
 
 				k := prop.Value().(*ds.Key)
 
-				sip := serialize.PropertyMapPartially(k, pm)
+				sip := serialize.PropertyMapPartially(k, withScatter(k, pm))
 
 				mergeIndexes(ns, store,
 					newMemStore(),
@@ -276,11 +369,11 @@ func addIndexes(store memStore, aid string, compIdx []*ds.IndexDefinition) {
 }
 
 // updateIndexes updates the indexes in store to accommodate a change in entity
-// value.
+// value. It returns the number of index rows which were written or deleted.
 //
 // oldEnt is the previous entity value, and newEnt is the new entity value. If
 // newEnt is nil, that signifies deletion.
-func updateIndexes(store memStore, key *ds.Key, oldEnt, newEnt ds.PropertyMap) {
+func updateIndexes(store memStore, key *ds.Key, oldEnt, newEnt ds.PropertyMap) int {
 	// load all current complex query index definitions.
 	var compIdx []*ds.IndexDefinition
 	walkCompIdxs(store.Snapshot(), nil, func(i *ds.IndexDefinition) bool {
@@ -288,7 +381,7 @@ func updateIndexes(store memStore, key *ds.Key, oldEnt, newEnt ds.PropertyMap) {
 		return true
 	})
 
-	mergeIndexes(key.Namespace(), store,
+	return mergeIndexes(key.Namespace(), store,
 		indexEntriesWithBuiltins(key, oldEnt, compIdx),
 		indexEntriesWithBuiltins(key, newEnt, compIdx))
 }
@@ -271,7 +271,7 @@ func getRelevantIndexes(q *reducedQuery, s memStore) (indexDefinitionSortableSli
 		props.Add(col.Property)
 	}
 	for _, prop := range props.ToSlice() {
-		if strings.HasPrefix(prop, "__") && strings.HasSuffix(prop, "__") {
+		if prop != "__scatter__" && strings.HasPrefix(prop, "__") && strings.HasSuffix(prop, "__") {
 			continue
 		}
 		if idxs.maybeAddDefinition(q, s, missingTerms, &ds.IndexDefinition{
@@ -175,7 +175,7 @@ func TestIndexRowGen(t *testing.T) {
 				mvals := serialize.PropertyMapPartially(fakeKey, tc.pmap)
 				idxs := []*ds.IndexDefinition(nil)
 				if tc.withBuiltin {
-					idxs = append(defaultIndexes("coolKind", tc.pmap), tc.idxs...)
+					idxs = append(ds.BuiltinIndexes("coolKind", tc.pmap), tc.idxs...)
 				} else {
 					idxs = tc.idxs
 				}
@@ -207,14 +207,14 @@ func TestIndexRowGen(t *testing.T) {
 	Convey("default indexes", t, func() {
 		Convey("nil collated", func() {
 			Convey("defaultIndexes (nil)", func() {
-				idxs := defaultIndexes("knd", ds.PropertyMap(nil))
+				idxs := ds.BuiltinIndexes("knd", ds.PropertyMap(nil))
 				So(len(idxs), ShouldEqual, 1)
 				So(idxs[0].String(), ShouldEqual, "B:knd")
 			})
 
 			Convey("indexEntries", func() {
 				sip := serialize.PropertyMapPartially(fakeKey, nil)
-				s := indexEntries(fakeKey, sip, defaultIndexes("knd", ds.PropertyMap(nil)))
+				s := indexEntries(fakeKey, sip, ds.BuiltinIndexes("knd", ds.PropertyMap(nil)))
 				So(countItems(s.Snapshot().GetCollection("idx")), ShouldEqual, 1)
 				itm := s.GetCollection("idx").MinItem()
 				So(itm.key, ShouldResemble, cat(indx("knd").PrepForIdxTable()))
@@ -227,7 +227,7 @@ func TestIndexRowGen(t *testing.T) {
 					"nerd": prop(103.7),
 					"spaz": propNI(false),
 				}
-				idxs := defaultIndexes("knd", pm)
+				idxs := ds.BuiltinIndexes("knd", pm)
 				So(len(idxs), ShouldEqual, 5)
 				So(idxs[0].String(), ShouldEqual, "B:knd")
 				So(idxs[1].String(), ShouldEqual, "B:knd/nerd")
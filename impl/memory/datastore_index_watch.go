@@ -0,0 +1,150 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/data/stringset"
+	"go.chromium.org/luci/common/errors"
+	"go.chromium.org/luci/common/logging"
+
+	"golang.org/x/net/context"
+)
+
+// IndexesAddedCB is called by WatchIndexYAML with the compound indexes that it
+// has just added to the datastore.
+type IndexesAddedCB func(added []*ds.IndexDefinition)
+
+// WatchIndexYAML adds all of the compound indexes defined in the index YAML
+// file at path to the memory datastore in c. It then polls the file every
+// interval, and adds any newly defined indexes to the datastore as they
+// appear, so that a long-running process (e.g. a local development server)
+// picks them up without restarting.
+//
+// If cb is not nil, it will be called with each batch of newly added indexes,
+// including the initial one. Each batch is also logged to c.
+//
+// Indexes which are removed from the file remain in the datastore. Errors
+// reading or parsing the file while polling are logged and otherwise ignored,
+// so a half-written file doesn't stop the watcher.
+//
+// The returned stop function stops polling and waits for the watcher to exit.
+// Polling also stops when c is cancelled.
+func WatchIndexYAML(c context.Context, path string, interval time.Duration, cb IndexesAddedCB) (stop func(), err error) {
+	w := &indexWatcher{
+		c:     c,
+		path:  path,
+		cb:    cb,
+		known: stringset.New(0),
+	}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+
+	stopC := make(chan struct{})
+	doneC := make(chan struct{})
+	go func() {
+		defer close(doneC)
+		for {
+			select {
+			case <-stopC:
+				return
+			case <-c.Done():
+				return
+			case <-clock.After(c, interval):
+			}
+
+			if err := w.reload(); err != nil {
+				logging.WithError(err).Warningf(c, "memory: failed to reload index YAML %q", path)
+			}
+		}
+	}()
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() { close(stopC) })
+		<-doneC
+	}, nil
+}
+
+// indexWatcher adds the compound indexes from an index YAML file to
+// a datastore.
+type indexWatcher struct {
+	c    context.Context
+	path string
+	cb   IndexesAddedCB
+
+	// modTime and size are from the last time the file was loaded, and are used
+	// to skip parsing the file if it hasn't changed.
+	modTime time.Time
+	size    int64
+
+	// known is the set of (normalized) indexes which have already been added.
+	known stringset.Set
+}
+
+// reload reads the index YAML file if it has changed, and adds any indexes
+// which haven't been seen before.
+func (w *indexWatcher) reload() error {
+	st, err := os.Stat(w.path)
+	if err != nil {
+		return errors.Annotate(err, "failed to stat index YAML").Err()
+	}
+	if st.ModTime().Equal(w.modTime) && st.Size() == w.size {
+		return nil
+	}
+
+	f, err := os.Open(w.path)
+	if err != nil {
+		return errors.Annotate(err, "failed to open index YAML").Err()
+	}
+	defer f.Close()
+
+	idxs, err := ds.ParseIndexYAML(f)
+	if err != nil {
+		return errors.Annotate(err, "failed to parse index YAML").Err()
+	}
+	w.modTime, w.size = st.ModTime(), st.Size()
+
+	var added []*ds.IndexDefinition
+	for _, idx := range idxs {
+		if !idx.Compound() {
+			logging.Warningf(w.c, "memory: ignoring non-compound index %s in %q", idx, w.path)
+			continue
+		}
+		if err := idx.Validate(); err != nil {
+			logging.WithError(err).Warningf(w.c, "memory: ignoring invalid index %s in %q", idx, w.path)
+			continue
+		}
+		if w.known.Add(idx.Normalize().String()) {
+			added = append(added, idx)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	ds.GetTestable(w.c).AddIndexes(added...)
+	logging.Infof(w.c, "memory: added %d index(es) from %q", len(added), w.path)
+	if w.cb != nil {
+		w.cb(added)
+	}
+	return nil
+}
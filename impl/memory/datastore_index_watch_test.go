@@ -0,0 +1,132 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/luci/common/data/stringset"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+const watchYAML1 = `
+indexes:
+- kind: Foo
+  properties:
+  - name: Val
+  - name: Name
+    direction: desc
+`
+
+const watchYAML2 = watchYAML1 + `
+- kind: Foo
+  ancestor: yes
+  properties:
+  - name: Name
+  - name: Val
+`
+
+func TestWatchIndexYAML(t *testing.T) {
+	t.Parallel()
+
+	Convey("WatchIndexYAML", t, func() {
+		c := Use(context.Background())
+		ds.GetTestable(c).Consistent(true)
+
+		dir, err := ioutil.TempDir("", "gae-memory-index-watch")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "index.yaml")
+		So(ioutil.WriteFile(path, []byte(watchYAML1), 0644), ShouldBeNil)
+
+		q := ds.NewQuery("Foo").Eq("Val", 1).Order("-Name")
+		runQuery := func() error {
+			return ds.Run(c, q, func(*ds.Key) {})
+		}
+		So(runQuery(), ShouldHaveSameTypeAs, &ErrMissingIndex{})
+
+		Convey("adds the indexes in the file", func() {
+			var added []*ds.IndexDefinition
+			stop, err := WatchIndexYAML(c, path, time.Hour, func(idxs []*ds.IndexDefinition) {
+				added = append(added, idxs...)
+			})
+			So(err, ShouldBeNil)
+			stop()
+			stop() // stop is idempotent
+
+			So(len(added), ShouldEqual, 1)
+			So(runQuery(), ShouldBeNil)
+		})
+
+		Convey("fails if the file is missing", func() {
+			_, err := WatchIndexYAML(c, filepath.Join(dir, "nope.yaml"), time.Hour, nil)
+			So(err, ShouldErrLike, "failed to stat")
+		})
+
+		Convey("picks up new indexes on reload", func() {
+			var added []*ds.IndexDefinition
+			w := &indexWatcher{c: c, path: path, known: stringset.New(0), cb: func(idxs []*ds.IndexDefinition) {
+				added = idxs
+			}}
+			So(w.reload(), ShouldBeNil)
+			So(len(added), ShouldEqual, 1)
+
+			added = nil
+			So(w.reload(), ShouldBeNil)
+			So(added, ShouldBeNil)
+
+			So(ioutil.WriteFile(path, []byte(watchYAML2), 0644), ShouldBeNil)
+			So(w.reload(), ShouldBeNil)
+			So(added, ShouldResemble, []*ds.IndexDefinition{{
+				Kind:     "Foo",
+				Ancestor: true,
+				SortBy:   []ds.IndexColumn{{Property: "Name"}, {Property: "Val"}},
+			}})
+
+			Convey("and skips invalid indexes", func() {
+				bad := watchYAML2 + `
+- kind: Foo
+  properties:
+  - name: __key__
+  - name: Val
+- kind: Bar
+  properties:
+  - name: Val
+  - name: Name
+`
+				So(ioutil.WriteFile(path, []byte(bad), 0644), ShouldBeNil)
+				So(w.reload(), ShouldBeNil)
+				So(added, ShouldResemble, []*ds.IndexDefinition{{
+					Kind:   "Bar",
+					SortBy: []ds.IndexColumn{{Property: "Val"}, {Property: "Name"}},
+				}})
+			})
+
+			Convey("and ignores broken files", func() {
+				So(ioutil.WriteFile(path, []byte("indexes: {{{"), 0644), ShouldBeNil)
+				So(w.reload(), ShouldErrLike, "failed to parse")
+			})
+		})
+	})
+}
@@ -46,9 +46,12 @@ type projectionStrategy struct {
 
 	project  []projectionLookup
 	distinct stringset.Set
+
+	// pm, if not nil, is reused for every result (see ds.PropertyMapsReusable).
+	pm ds.PropertyMap
 }
 
-func newProjectionStrategy(fq *ds.FinalizedQuery, rq *reducedQuery, cb ds.RawRunCB) queryStrategy {
+func newProjectionStrategy(fq *ds.FinalizedQuery, rq *reducedQuery, cb ds.RawRunCB, reusePM bool) queryStrategy {
 	proj := fq.Project()
 
 	projectionLookups := make([]projectionLookup, len(proj))
@@ -68,6 +71,9 @@ func newProjectionStrategy(fq *ds.FinalizedQuery, rq *reducedQuery, cb ds.RawRun
 	if fq.Distinct() {
 		ret.distinct = stringset.New(0)
 	}
+	if reusePM {
+		ret.pm = make(ds.PropertyMap, len(proj))
+	}
 	return ret
 }
 
@@ -76,7 +82,12 @@ func (s *projectionStrategy) handle(rawData [][]byte, decodedProps []ds.Property
 	if s.distinct != nil {
 		projectedRaw = make([][]byte, len(decodedProps))
 	}
-	pmap := make(ds.PropertyMap, len(s.project))
+	// A reused pmap always gets the same properties, so there's nothing to
+	// clear.
+	pmap := s.pm
+	if pmap == nil {
+		pmap = make(ds.PropertyMap, len(s.project))
+	}
 	for i, p := range s.project {
 		if s.distinct != nil {
 			projectedRaw[i] = rawData[p.suffixIndex]
@@ -110,14 +121,21 @@ type normalStrategy struct {
 	kc    ds.KeyContext
 	head  memCollection
 	dedup stringset.Set
+
+	// pm, if not nil, is reused for every result (see ds.PropertyMapsReusable).
+	pm ds.PropertyMap
 }
 
-func newNormalStrategy(kc ds.KeyContext, cb ds.RawRunCB, head memStore) queryStrategy {
+func newNormalStrategy(kc ds.KeyContext, cb ds.RawRunCB, head memStore, reusePM bool) queryStrategy {
 	coll := head.GetCollection("ents:" + kc.Namespace)
 	if coll == nil {
 		return nil
 	}
-	return &normalStrategy{cb, kc, coll, stringset.New(0)}
+	ret := &normalStrategy{cb: cb, kc: kc, head: coll, dedup: stringset.New(0)}
+	if reusePM {
+		ret.pm = ds.PropertyMap{}
+	}
+	return ret
 }
 
 func (s *normalStrategy) handle(rawData [][]byte, _ []ds.Property, key *ds.Key, gc func() (ds.Cursor, error)) error {
@@ -131,20 +149,26 @@ func (s *normalStrategy) handle(rawData [][]byte, _ []ds.Property, key *ds.Key,
 		// entity doesn't exist at head
 		return nil
 	}
+	if s.pm != nil {
+		err := serialize.ReadPropertyMapInto(bytes.NewBuffer(rawEnt), serialize.WithoutContext, s.kc, s.pm)
+		memoryCorruption(err)
+		return s.cb(key, s.pm, gc)
+	}
+
 	pm, err := serialize.ReadPropertyMap(bytes.NewBuffer(rawEnt), serialize.WithoutContext, s.kc)
 	memoryCorruption(err)
 
 	return s.cb(key, pm, gc)
 }
 
-func pickQueryStrategy(fq *ds.FinalizedQuery, rq *reducedQuery, cb ds.RawRunCB, head memStore) queryStrategy {
+func pickQueryStrategy(fq *ds.FinalizedQuery, rq *reducedQuery, cb ds.RawRunCB, head memStore, reusePM bool) queryStrategy {
 	if fq.KeysOnly() {
 		return &keysOnlyStrategy{cb, stringset.New(0)}
 	}
 	if len(fq.Project()) > 0 {
-		return newProjectionStrategy(fq, rq, cb)
+		return newProjectionStrategy(fq, rq, cb, reusePM)
 	}
-	return newNormalStrategy(rq.kc, cb, head)
+	return newNormalStrategy(rq.kc, cb, head, reusePM)
 }
 
 func parseSuffix(aid, ns string, suffixFormat []ds.IndexColumn, suffix []byte, count int) (raw [][]byte, decoded []ds.Property) {
@@ -175,14 +199,14 @@ func parseSuffix(aid, ns string, suffixFormat []ds.IndexColumn, suffix []byte, c
 	return
 }
 
-func countQuery(fq *ds.FinalizedQuery, kc ds.KeyContext, isTxn bool, idx, head memStore) (ret int64, err error) {
+func countQuery(fq *ds.FinalizedQuery, kc ds.KeyContext, isTxn bool, idx, head memStore, ql *queryLog) (ret int64, err error) {
 	if len(fq.Project()) == 0 && !fq.KeysOnly() {
 		fq, err = fq.Original().KeysOnly(true).Finalize()
 		if err != nil {
 			return
 		}
 	}
-	err = executeQuery(fq, kc, isTxn, idx, head, func(_ *ds.Key, _ ds.PropertyMap, _ ds.CursorCB) error {
+	err = executeQuery(fq, kc, isTxn, idx, head, ql, false, func(_ *ds.Key, _ ds.PropertyMap, _ ds.CursorCB) error {
 		ret++
 		return nil
 	})
@@ -235,9 +259,17 @@ func executeNamespaceQuery(fq *ds.FinalizedQuery, kc ds.KeyContext, head memStor
 	return nil
 }
 
-func executeQuery(fq *ds.FinalizedQuery, kc ds.KeyContext, isTxn bool, idx, head memStore, cb ds.RawRunCB) error {
+// executeQuery runs fq, invoking cb for each result.
+//
+// If ql is not nil, the query and the indexes used to satisfy it will be
+// recorded there.
+//
+// If reusePM is true, a single PropertyMap is reused for all of the results
+// (see ds.PropertyMapsReusable).
+func executeQuery(fq *ds.FinalizedQuery, kc ds.KeyContext, isTxn bool, idx, head memStore, ql *queryLog, reusePM bool, cb ds.RawRunCB) error {
 	rq, err := reduce(fq, kc, isTxn)
 	if err == ds.ErrNullQuery {
+		ql.record(fq, nil)
 		return nil
 	}
 	if err != nil {
@@ -245,18 +277,21 @@ func executeQuery(fq *ds.FinalizedQuery, kc ds.KeyContext, isTxn bool, idx, head
 	}
 
 	if rq.kind == "__namespace__" {
+		ql.record(fq, nil)
 		return executeNamespaceQuery(fq, kc, head, cb)
 	}
 
 	idxs, err := getIndexes(rq, idx)
 	if err == ds.ErrNullQuery {
+		ql.record(fq, nil)
 		return nil
 	}
 	if err != nil {
 		return err
 	}
+	ql.record(fq, idxs)
 
-	strategy := pickQueryStrategy(fq, rq, cb, head)
+	strategy := pickQueryStrategy(fq, rq, cb, head, reusePM)
 	if strategy == nil {
 		// e.g. the normalStrategy found that there were NO entities in the current
 		// namespace.
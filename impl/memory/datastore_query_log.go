@@ -0,0 +1,94 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/datastore/serialize"
+	"go.chromium.org/luci/common/data/stringset"
+)
+
+// queryLog records the queries executed against a dataStoreData, along with
+// the indexes used to satisfy them. It's safe for concurrent use, and a nil
+// *queryLog records nothing.
+type queryLog struct {
+	lock    sync.Mutex
+	entries []ds.QueryLogEntry
+}
+
+// record adds an entry for fq, which was satisfied by iterating over idxs.
+func (l *queryLog) record(fq *ds.FinalizedQuery, idxs []*iterDefinition) {
+	if l == nil {
+		return
+	}
+
+	ent := ds.QueryLogEntry{Query: fq}
+	seen := stringset.New(len(idxs))
+	for _, def := range idxs {
+		name := def.c.Name()
+		if !seen.Add(name) {
+			continue
+		}
+		ent.Indexes = append(ent.Indexes, indexDefinitionForCollection(name))
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries = append(l.entries, ent)
+}
+
+func (l *queryLog) get() []ds.QueryLogEntry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]ds.QueryLogEntry(nil), l.entries...)
+}
+
+func (l *queryLog) reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries = nil
+}
+
+// indexDefinitionForCollection returns the IndexDefinition whose rows are
+// stored in the named collection.
+//
+// Index collections are named "idx:<namespace>:<index>". The "ents:<namespace>"
+// collection (used by kindless queries) maps to the IndexDefinition with no
+// Kind.
+func indexDefinitionForCollection(name string) *ds.IndexDefinition {
+	rest, ok := trimPrefix(name, "idx:")
+	if !ok {
+		return &ds.IndexDefinition{}
+	}
+	// namespaces may not contain ':'.
+	rest = rest[strings.IndexByte(rest, ':')+1:]
+
+	id, err := serialize.ReadIndexDefinition(bytes.NewBufferString(rest))
+	memoryCorruption(err)
+
+	ret := id.Flip()
+	if last := len(ret.SortBy) - 1; last >= 0 && ret.SortBy[last] == (ds.IndexColumn{Property: "__key__"}) {
+		// this removes the __key__ column, since it's implicit.
+		ret.SortBy = ret.SortBy[:last]
+	}
+	if len(ret.SortBy) == 0 {
+		ret.SortBy = nil
+	}
+	return ret
+}
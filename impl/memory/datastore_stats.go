@@ -0,0 +1,244 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/datastore/serialize"
+	"go.chromium.org/gae/service/datastore/stats"
+	"go.chromium.org/luci/common/clock"
+
+	"golang.org/x/net/context"
+)
+
+// GenerateStats synthesizes the datastore statistics entities (see the
+// service/datastore/stats package) from the current contents of the memory
+// datastore in c, which must have been set up with Use. Any previously
+// generated statistics are replaced.
+//
+// The sizes are estimates: entity sizes are computed with EstimateSize, each
+// entity has one row in the built-in kind index, and each indexed value has two
+// rows (ascending and descending) in the built-in property indexes. Composite
+// indexes are not accounted for.
+func GenerateStats(c context.Context) error {
+	data := c.Value(&memContextKey).(memContext).Get(memContextDSIdx).(*dataStoreData)
+	now := clock.Now(c).UTC()
+
+	snap := data.takeSnapshot()
+	global := newStatsScope()
+	perNS := map[string]*statsScope{}
+	staleKeys := map[string][]*ds.Key{}
+	for _, ns := range namespaces(snap) {
+		kctx := ds.MkKeyContext(data.aid, ns)
+		scope := newStatsScope()
+		perNS[ns] = scope
+
+		snap.GetCollection("ents:" + ns).ForEachItem(func(k, v []byte) bool {
+			prop, err := serialize.ReadProperty(bytes.NewBuffer(k), serialize.WithoutContext, kctx)
+			memoryCorruption(err)
+			key := prop.Value().(*ds.Key)
+			if strings.HasPrefix(key.Kind(), "__Stat_") {
+				staleKeys[ns] = append(staleKeys[ns], key)
+			}
+			if strings.HasPrefix(key.Kind(), "__") {
+				return true
+			}
+
+			pm, err := rpm(v)
+			memoryCorruption(err)
+			global.addEntity(key, pm)
+			scope.addEntity(key, pm)
+			return true
+		})
+	}
+
+	for _, keys := range staleKeys {
+		if err := data.delMulti(keys, func(_ int, err error) error { return err }, false); err != nil {
+			return err
+		}
+	}
+
+	put := func(objs []interface{}) error {
+		if len(objs) == 0 {
+			return nil
+		}
+		keys := make([]*ds.Key, len(objs))
+		vals := make([]ds.PropertyMap, len(objs))
+		for i, obj := range objs {
+			pm, err := ds.GetPLS(obj).Save(true)
+			if err != nil {
+				return err
+			}
+			keys[i] = ds.GetMetaDefault(pm, "key", nil).(*ds.Key)
+			vals[i], _ = pm.Save(false)
+		}
+		return data.putMulti(keys, vals, func(_ int, _ *ds.Key, err error) error { return err }, false)
+	}
+
+	defaultKC := ds.MkKeyContext(data.aid, "")
+	globalObjs := global.stats(defaultKC, stats.AllNamespaces, now)
+	for ns, scope := range perNS {
+		nsStat := &stats.Namespace{
+			Usage:            scope.total.Usage,
+			CompositeUsage:   scope.total.CompositeUsage,
+			SubjectNamespace: ns,
+		}
+		nsStat.Timestamp = now
+		if ns == "" {
+			nsStat.Key = defaultKC.NewKey(stats.KindName(stats.AllNamespaces, stats.NamespaceStat), "", 1, nil)
+		} else {
+			nsStat.Key = defaultKC.NewKey(stats.KindName(stats.AllNamespaces, stats.NamespaceStat), ns, 0, nil)
+		}
+		globalObjs = append(globalObjs, nsStat)
+
+		if err := put(scope.stats(ds.MkKeyContext(data.aid, ns), stats.CurrentNamespace, now)); err != nil {
+			return err
+		}
+	}
+	return put(globalObjs)
+}
+
+// statsScope accumulates the statistics of a stats.Scope.
+type statsScope struct {
+	total     stats.Total
+	kinds     map[string]*stats.Kind
+	types     map[string]*stats.PropertyType
+	typeKinds map[[2]string]*stats.PropertyTypeKind
+	nameKinds map[[2]string]*stats.PropertyNameKind
+	props     map[[3]string]*stats.PropertyTypePropertyNameKind
+}
+
+func newStatsScope() *statsScope {
+	return &statsScope{
+		kinds:     map[string]*stats.Kind{},
+		types:     map[string]*stats.PropertyType{},
+		typeKinds: map[[2]string]*stats.PropertyTypeKind{},
+		nameKinds: map[[2]string]*stats.PropertyNameKind{},
+		props:     map[[3]string]*stats.PropertyTypePropertyNameKind{},
+	}
+}
+
+func addUsage(u *stats.Usage, count, entityBytes, indexBytes, indexCount int64) {
+	u.Count += count
+	u.EntityBytes += entityBytes
+	u.BuiltinIndexBytes += indexBytes
+	u.BuiltinIndexCount += indexCount
+	u.Bytes += entityBytes + indexBytes
+}
+
+func (s *statsScope) addEntity(key *ds.Key, pm ds.PropertyMap) {
+	kind := key.Kind()
+	keySize := key.EstimateSize()
+
+	// The kind index has one row per entity.
+	entityBytes := keySize
+	indexBytes, indexCount := keySize, int64(1)
+
+	for name := range pm {
+		if strings.HasPrefix(name, "$") {
+			continue
+		}
+		for _, v := range pm.Slice(name) {
+			valBytes := int64(len(name)) + v.EstimateSize()
+			valIndexBytes, valIndexCount := int64(0), int64(0)
+			if v.IndexSetting() == ds.ShouldIndex {
+				valIndexBytes, valIndexCount = 2*(keySize+valBytes), 2
+			}
+			entityBytes += valBytes
+			indexBytes += valIndexBytes
+			indexCount += valIndexCount
+
+			typ := stats.TypeName(&v)
+			usage := func(u *stats.Usage) { addUsage(u, 1, valBytes, valIndexBytes, valIndexCount) }
+
+			t := s.types[typ]
+			if t == nil {
+				t = &stats.PropertyType{PropertyType: typ}
+				s.types[typ] = t
+			}
+			usage(&t.Usage)
+
+			tk := s.typeKinds[[2]string{typ, kind}]
+			if tk == nil {
+				tk = &stats.PropertyTypeKind{PropertyType: typ, KindName: kind}
+				s.typeKinds[[2]string{typ, kind}] = tk
+			}
+			usage(&tk.Usage)
+
+			nk := s.nameKinds[[2]string{name, kind}]
+			if nk == nil {
+				nk = &stats.PropertyNameKind{PropertyName: name, KindName: kind}
+				s.nameKinds[[2]string{name, kind}] = nk
+			}
+			usage(&nk.Usage)
+
+			p := s.props[[3]string{typ, name, kind}]
+			if p == nil {
+				p = &stats.PropertyTypePropertyNameKind{PropertyType: typ, PropertyName: name, KindName: kind}
+				s.props[[3]string{typ, name, kind}] = p
+			}
+			usage(&p.Usage)
+		}
+	}
+
+	k := s.kinds[kind]
+	if k == nil {
+		k = &stats.Kind{KindName: kind}
+		s.kinds[kind] = k
+	}
+	addUsage(&k.Usage, 1, entityBytes, indexBytes, indexCount)
+	addUsage(&s.total.Usage, 1, entityBytes, indexBytes, indexCount)
+}
+
+// stats returns the statistics entities of s, with keys in kc.
+func (s *statsScope) stats(kc ds.KeyContext, scope stats.Scope, now time.Time) []interface{} {
+	mkKey := func(stat, id string) *ds.Key {
+		return kc.NewKey(stats.KindName(scope, stat), id, 0, nil)
+	}
+
+	s.total.Key = mkKey(stats.TotalStat, stats.TotalKey)
+	s.total.Timestamp = now
+	ret := []interface{}{&s.total}
+	for kind, k := range s.kinds {
+		k.Key = mkKey(stats.KindStat, kind)
+		k.Timestamp = now
+		ret = append(ret, k)
+	}
+	for typ, t := range s.types {
+		t.Key = mkKey(stats.PropertyTypeStat, typ)
+		t.Timestamp = now
+		ret = append(ret, t)
+	}
+	for id, tk := range s.typeKinds {
+		tk.Key = mkKey(stats.PropertyTypeKindStat, id[0]+"_"+id[1])
+		tk.Timestamp = now
+		ret = append(ret, tk)
+	}
+	for id, nk := range s.nameKinds {
+		nk.Key = mkKey(stats.PropertyNameKindStat, id[0]+"_"+id[1])
+		nk.Timestamp = now
+		ret = append(ret, nk)
+	}
+	for id, p := range s.props {
+		p.Key = mkKey(stats.PropertyTypePropertyNameKindStat, id[0]+"_"+id[1]+"_"+id[2])
+		p.Timestamp = now
+		ret = append(ret, p)
+	}
+	return ret
+}
@@ -15,7 +15,6 @@
 package memory
 
 import (
-	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -25,6 +24,9 @@ import (
 	ds "go.chromium.org/gae/service/datastore"
 	"go.chromium.org/gae/service/datastore/serialize"
 	infoS "go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/tuning"
+	"go.chromium.org/luci/common/clock/testclock"
+	"go.chromium.org/luci/common/errors"
 
 	"golang.org/x/net/context"
 
@@ -518,6 +520,26 @@ func TestDatastoreSingleReadWriter(t *testing.T) {
 						So(calls, ShouldEqual, 3)
 					})
 
+					Convey("tuned TransactionAttempts", func() {
+						tst.SetTransactionRetryCount(100) // more than 5
+						c := tuning.Set(c, &tuning.Options{Datastore: tuning.Datastore{TransactionAttempts: 5}})
+						calls := 0
+						So(ds.RunInTransaction(c, func(c context.Context) error {
+							calls++
+							return nil
+						}, &ds.TransactionOptions{XG: true}), ShouldEqual, ds.ErrConcurrentTransaction)
+						So(calls, ShouldEqual, 5)
+
+						Convey("are overridden by TransactionOptions", func() {
+							calls = 0
+							So(ds.RunInTransaction(c, func(c context.Context) error {
+								calls++
+								return nil
+							}, &ds.TransactionOptions{Attempts: 2}), ShouldEqual, ds.ErrConcurrentTransaction)
+							So(calls, ShouldEqual, 2)
+						})
+					})
+
 					Convey("non-default TransactionOptions ", func() {
 						tst.SetTransactionRetryCount(100) // more than 20
 						calls := 0
@@ -606,6 +628,1087 @@ func TestDatastoreSingleReadWriter(t *testing.T) {
 			So(count, ShouldEqual, 1) // normally this would include __entity_group__
 		})
 
+		Convey("Testable.SetIDGenerator", func() {
+			Convey("SequentialIDs", func() {
+				ds.GetTestable(c).SetIDGenerator(SequentialIDs(100))
+
+				f := &Foo{Val: 1}
+				So(ds.Put(c, f), ShouldBeNil)
+				So(f.ID, ShouldEqual, 100)
+
+				keys := ds.NewIncompleteKeys(c, 2, "Bar", nil)
+				So(ds.AllocateIDs(c, keys), ShouldBeNil)
+				So(keys[0].IntID(), ShouldEqual, 101)
+				So(keys[1].IntID(), ShouldEqual, 102)
+			})
+
+			Convey("ScatteredIDs are deterministic", func() {
+				ids := func() []int64 {
+					c := Use(context.Background())
+					ds.GetTestable(c).SetIDGenerator(ScatteredIDs(42))
+					keys := ds.NewIncompleteKeys(c, 10, "Foo", nil)
+					So(ds.AllocateIDs(c, keys), ShouldBeNil)
+					ret := make([]int64, len(keys))
+					for i, k := range keys {
+						ret[i] = k.IntID()
+					}
+					return ret
+				}
+				first := ids()
+				So(first, ShouldResemble, ids())
+				So(first[1], ShouldNotEqual, first[0]+1)
+			})
+
+			Convey("colliding IDs are skipped", func() {
+				So(ds.Put(c, &Foo{ID: 1}), ShouldBeNil)
+
+				ds.GetTestable(c).SetIDGenerator(SequentialIDs(1))
+				f := &Foo{}
+				So(ds.Put(c, f), ShouldBeNil)
+				So(f.ID, ShouldEqual, 2)
+			})
+
+			Convey("custom generators", func() {
+				ds.GetTestable(c).SetIDGenerator(func(k *ds.Key) int64 {
+					if k.Kind() == "Foo" {
+						return 1337
+					}
+					return 0
+				})
+
+				f := &Foo{}
+				So(ds.Put(c, f), ShouldBeNil)
+				So(f.ID, ShouldEqual, 1337)
+
+				So(ds.Put(c, &Foo{}), ShouldErrLike, "colliding IDs")
+				So(ds.AllocateIDs(c, ds.NewIncompleteKeys(c, 1, "Bar", nil)), ShouldErrLike, "non-positive ID")
+			})
+
+			Convey("nil restores the default", func() {
+				ds.GetTestable(c).SetIDGenerator(SequentialIDs(100))
+				ds.GetTestable(c).SetIDGenerator(nil)
+
+				f := &Foo{}
+				So(ds.Put(c, f), ShouldBeNil)
+				So(f.ID, ShouldEqual, 1)
+			})
+		})
+
+		Convey("Testable.Freeze", func() {
+			So(ds.Put(c, &Foo{ID: 1, Val: 1}), ShouldBeNil)
+			ds.GetTestable(c).CatchupIndexes()
+			ds.GetTestable(c).Freeze()
+
+			Convey("reads work", func() {
+				f := &Foo{ID: 1}
+				So(ds.Get(c, f), ShouldBeNil)
+				So(f.Val, ShouldEqual, 1)
+
+				count, err := ds.Count(c, ds.NewQuery("Foo"))
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 1)
+			})
+
+			Convey("writes fail", func() {
+				So(ds.Put(c, &Foo{ID: 1, Val: 2}), ShouldErrLike, ErrFrozen)
+				So(ds.Put(c, &Foo{}), ShouldErrLike, ErrFrozen)
+				So(ds.Delete(c, ds.NewKey(c, "Foo", "", 1, nil)), ShouldErrLike, ErrFrozen)
+				So(ds.AllocateIDs(c, ds.NewIncompleteKeys(c, 1, "Foo", nil)), ShouldErrLike, ErrFrozen)
+
+				So(ds.RunInTransaction(c, func(c context.Context) error {
+					f := &Foo{ID: 1}
+					So(ds.Get(c, f), ShouldBeNil)
+					return ds.Put(c, f)
+				}, nil), ShouldErrLike, ErrFrozen)
+
+				f := &Foo{ID: 1}
+				So(ds.Get(c, f), ShouldBeNil)
+				So(f.Val, ShouldEqual, 1)
+			})
+
+			Convey("Thaw allows writes again", func() {
+				ds.GetTestable(c).Thaw()
+				So(ds.Put(c, &Foo{ID: 1, Val: 2}), ShouldBeNil)
+			})
+		})
+
+		Convey("Testable.Costs", func() {
+			t := ds.GetTestable(c)
+			t.Consistent(true)
+			So(t.Costs(), ShouldResemble, ds.Costs{})
+
+			// Kind row + Val, Name and Key asc/desc rows. Multi is empty.
+			So(ds.Put(c, &Foo{ID: 1, Val: 1}), ShouldBeNil)
+			So(t.Costs(), ShouldResemble, ds.Costs{EntityWrites: 1, IndexWrites: 7})
+
+			Convey("updates only count changed index rows", func() {
+				t.ResetCosts()
+				So(ds.Put(c, &Foo{ID: 1, Val: 2}), ShouldBeNil)
+				So(t.Costs(), ShouldResemble, ds.Costs{EntityWrites: 1, IndexWrites: 4})
+			})
+
+			Convey("reads and queries", func() {
+				So(ds.Put(c, &Foo{ID: 2, Val: 2}), ShouldBeNil)
+				t.ResetCosts()
+
+				So(ds.Get(c, []*Foo{{ID: 1}, {ID: 2}}), ShouldBeNil)
+				So(t.Costs(), ShouldResemble, ds.Costs{EntityReads: 2})
+
+				t.ResetCosts()
+				var foos []*Foo
+				So(ds.GetAll(c, ds.NewQuery("Foo"), &foos), ShouldBeNil)
+				So(t.Costs(), ShouldResemble, ds.Costs{EntityReads: 3})
+
+				t.ResetCosts()
+				var keys []*ds.Key
+				So(ds.GetAll(c, ds.NewQuery("Foo").KeysOnly(true), &keys), ShouldBeNil)
+				So(t.Costs(), ShouldResemble, ds.Costs{EntityReads: 1, SmallOps: 2})
+
+				t.ResetCosts()
+				_, err := ds.Count(c, ds.NewQuery("Foo"))
+				So(err, ShouldBeNil)
+				So(t.Costs(), ShouldResemble, ds.Costs{EntityReads: 1, SmallOps: 2})
+			})
+
+			Convey("deletes", func() {
+				t.ResetCosts()
+				So(ds.Delete(c, ds.NewKey(c, "Foo", "", 1, nil)), ShouldBeNil)
+				So(t.Costs(), ShouldResemble, ds.Costs{EntityWrites: 1, IndexWrites: 7})
+			})
+
+			Convey("transactions count writes on commit", func() {
+				t.ResetCosts()
+				So(ds.RunInTransaction(c, func(c context.Context) error {
+					f := &Foo{ID: 1}
+					So(ds.Get(c, f), ShouldBeNil)
+					f.Val = 2
+					So(ds.Put(c, f), ShouldBeNil)
+					So(t.Costs(), ShouldResemble, ds.Costs{EntityReads: 1})
+					return nil
+				}, nil), ShouldBeNil)
+				So(t.Costs(), ShouldResemble, ds.Costs{EntityReads: 1, EntityWrites: 1, IndexWrites: 4})
+			})
+		})
+
+		Convey("Testable.QueryLog", func() {
+			idx := &ds.IndexDefinition{
+				Kind: "Foo",
+				SortBy: []ds.IndexColumn{
+					{Property: "Val"},
+					{Property: "Name", Descending: true},
+				},
+			}
+			t := ds.GetTestable(c)
+			t.AddIndexes(idx)
+			t.Consistent(true)
+			So(ds.Put(c, &Foo{ID: 1, Val: 1, Name: "a"}), ShouldBeNil)
+
+			run := func(q *ds.Query) {
+				So(ds.Run(c, q, func(*ds.Key) {}), ShouldBeNil)
+			}
+			run(ds.NewQuery("Foo"))
+			run(ds.NewQuery("Foo").Eq("Val", 1))
+			run(ds.NewQuery("Foo").Eq("Val", 1).Order("-Name"))
+			run(ds.NewQuery(""))
+
+			log := t.QueryLog()
+			So(len(log), ShouldEqual, 4)
+			So(log[0].Indexes, ShouldResemble, []*ds.IndexDefinition{{Kind: "Foo"}})
+			So(log[1].Indexes, ShouldResemble, []*ds.IndexDefinition{
+				{Kind: "Foo", SortBy: []ds.IndexColumn{{Property: "Val"}}},
+			})
+			So(log[2].Indexes, ShouldResemble, []*ds.IndexDefinition{idx})
+			So(log[3].Indexes, ShouldResemble, []*ds.IndexDefinition{{}})
+
+			So(log[2].Query.GQL(), ShouldEqual,
+				"SELECT __key__ FROM `Foo` WHERE `Val` = 1 ORDER BY `Name` DESC, `__key__`")
+
+			t.ResetQueryLog()
+			So(t.QueryLog(), ShouldBeEmpty)
+		})
+
+		Convey("Testable.HideIndexes", func() {
+			idx := &ds.IndexDefinition{
+				Kind: "Foo",
+				SortBy: []ds.IndexColumn{
+					{Property: "Val"},
+					{Property: "Name", Descending: true},
+				},
+			}
+			t := ds.GetTestable(c)
+			t.AddIndexes(idx)
+			t.Consistent(true)
+			So(ds.Put(c, &Foo{ID: 1, Val: 1, Name: "a"}), ShouldBeNil)
+			So(ds.Put(c, &Foo{ID: 2, Val: 1, Name: "b"}), ShouldBeNil)
+
+			q := ds.NewQuery("Foo").Eq("Val", 1).Order("-Name")
+			getIDs := func(c context.Context) ([]int64, error) {
+				var foos []*Foo
+				if err := ds.GetAll(c, q, &foos); err != nil {
+					return nil, err
+				}
+				ids := make([]int64, len(foos))
+				for i, f := range foos {
+					ids[i] = f.ID
+				}
+				return ids, nil
+			}
+
+			ids, err := getIDs(c)
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, []int64{2, 1})
+
+			t.HideIndexes(idx)
+
+			_, err = getIDs(c)
+			So(err, ShouldHaveSameTypeAs, &ErrMissingIndex{})
+
+			Convey("hidden indexes are still maintained", func() {
+				So(ds.Put(c, &Foo{ID: 3, Val: 1, Name: "c"}), ShouldBeNil)
+				t.UnhideIndexes(idx)
+
+				ids, err := getIDs(c)
+				So(err, ShouldBeNil)
+				So(ids, ShouldResemble, []int64{3, 2, 1})
+			})
+
+			Convey("builtin indexes can't be hidden", func() {
+				So(func() { t.HideIndexes(&ds.IndexDefinition{Kind: "Foo"}) },
+					ShouldPanicLike, "non-compound")
+			})
+		})
+
+		Convey("__scatter__", func() {
+			ds.GetTestable(c).Consistent(true)
+			foos := make([]*Foo, 1000)
+			for i := range foos {
+				foos[i] = &Foo{ID: int64(i + 1)}
+			}
+			So(ds.Put(c, foos), ShouldBeNil)
+
+			var keys []*ds.Key
+			So(ds.GetAll(c, ds.NewQuery("Foo").Order("__scatter__").KeysOnly(true), &keys), ShouldBeNil)
+			So(len(keys), ShouldBeGreaterThan, 0)
+			So(len(keys), ShouldBeLessThan, 50)
+
+			Convey("is deterministic", func() {
+				var again []*ds.Key
+				So(ds.GetAll(c, ds.NewQuery("Foo").Order("__scatter__").KeysOnly(true), &again), ShouldBeNil)
+				So(again, ShouldResemble, keys)
+			})
+
+			Convey("can be filtered on", func() {
+				var lo, hi []*ds.Key
+				q := ds.NewQuery("Foo").Order("__scatter__").KeysOnly(true)
+				So(ds.GetAll(c, q.Lt("__scatter__", []byte{0x80}), &lo), ShouldBeNil)
+				So(ds.GetAll(c, q.Gte("__scatter__", []byte{0x80}), &hi), ShouldBeNil)
+				So(append(lo, hi...), ShouldResemble, keys)
+			})
+
+			Convey("is not stored with the entity", func() {
+				pm := ds.PropertyMap{}
+				So(ds.PopulateKey(pm, keys[0]), ShouldBeTrue)
+				So(ds.Get(c, pm), ShouldBeNil)
+				_, has := pm["__scatter__"]
+				So(has, ShouldBeFalse)
+			})
+
+			Convey("is removed from the index on delete", func() {
+				So(ds.Delete(c, keys[0]), ShouldBeNil)
+				var after []*ds.Key
+				So(ds.GetAll(c, ds.NewQuery("Foo").Order("__scatter__").KeysOnly(true), &after), ShouldBeNil)
+				So(len(after), ShouldEqual, len(keys)-1)
+			})
+		})
+
+		Convey("DeleteAll", func() {
+			ds.GetTestable(c).Consistent(true)
+			foos := make([]*Foo, 25)
+			for i := range foos {
+				foos[i] = &Foo{ID: int64(i + 1), Val: i % 2}
+			}
+			So(ds.Put(c, foos), ShouldBeNil)
+			count := func() int64 {
+				n, err := ds.Count(c, ds.NewQuery("Foo"))
+				So(err, ShouldBeNil)
+				return n
+			}
+
+			Convey("deletes everything matched", func() {
+				n, err := ds.DeleteAll(c, ds.NewQuery("Foo").Eq("Val", 1))
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 12)
+				So(count(), ShouldEqual, 13)
+			})
+
+			Convey("in parallel batches, with progress", func() {
+				var progress []int
+				opts := ds.DeleteAllOptions{
+					BatchSize:   10,
+					Concurrency: 3,
+					Progress:    func(n int) { progress = append(progress, n) },
+				}
+				n, err := opts.DeleteAll(c, ds.NewQuery("Foo"))
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 25)
+				So(len(progress), ShouldEqual, 3)
+				So(progress[2], ShouldEqual, 25)
+				So(count(), ShouldEqual, 0)
+			})
+
+			Convey("reports partial failures", func() {
+				bad := ds.KeyForObj(c, foos[3])
+				fc := ds.AddRawFilters(c, func(_ context.Context, raw ds.RawInterface) ds.RawInterface {
+					return &deleteFailer{raw, bad}
+				})
+				n, err := (&ds.DeleteAllOptions{BatchSize: 10}).DeleteAll(fc, ds.NewQuery("Foo"))
+				So(n, ShouldEqual, 24)
+				So(err, ShouldHaveSameTypeAs, &ds.DeleteAllError{})
+				de := err.(*ds.DeleteAllError)
+				So(de.Deleted, ShouldEqual, 24)
+				So(de.Failed, ShouldResemble, []*ds.Key{bad})
+				So(err, ShouldErrLike, "failed to delete 1 entities (deleted 24): boom")
+				So(count(), ShouldEqual, 1)
+			})
+
+			Convey("reports failures from parallel parts of a batch", func() {
+				bad := []*ds.Key{ds.KeyForObj(c, foos[3]), ds.KeyForObj(c, foos[6])}
+				fc := c
+				for _, k := range bad {
+					k := k
+					fc = ds.AddRawFilters(fc, func(_ context.Context, raw ds.RawInterface) ds.RawInterface {
+						return &deleteFailer{raw, k}
+					})
+				}
+				fc = ds.WithBatchOptions(fc, ds.BatchOptions{MaxDeleteSize: 2})
+				n, err := (&ds.DeleteAllOptions{BatchSize: 10}).DeleteAll(fc, ds.NewQuery("Foo"))
+				So(n, ShouldEqual, 23)
+				So(err.(*ds.DeleteAllError).Failed, ShouldResemble, bad)
+				So(count(), ShouldEqual, 2)
+			})
+		})
+
+		Convey("UpdateAll", func() {
+			ds.GetTestable(c).Consistent(true)
+			foos := make([]*Foo, 25)
+			for i := range foos {
+				foos[i] = &Foo{ID: int64(i + 1), Val: i % 2}
+			}
+			So(ds.Put(c, foos), ShouldBeNil)
+
+			// bump increments Val of the odd entities.
+			bump := func(c context.Context, pm ds.PropertyMap) (bool, error) {
+				val := pm.Slice("Val")[0].Value().(int64)
+				if val%2 == 0 {
+					return false, nil
+				}
+				pm["Val"] = ds.MkProperty(val + 1)
+				return true, nil
+			}
+			sum := func() (ret int) {
+				So(ds.Get(c, foos), ShouldBeNil)
+				for _, f := range foos {
+					ret += f.Val
+				}
+				return
+			}
+
+			Convey("updates changed entities", func() {
+				n, err := ds.UpdateAll(c, ds.NewQuery("Foo"), bump)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 12)
+				So(sum(), ShouldEqual, 24)
+			})
+
+			for _, txn := range []bool{false, true} {
+				txn := txn
+				Convey(fmt.Sprintf("checkpoints and resumes (transactional: %v)", txn), func() {
+					var cursors []ds.Cursor
+					opts := ds.UpdateAllOptions{
+						BatchSize:     10,
+						Transactional: txn,
+						Checkpoint: func(c context.Context, cur ds.Cursor) error {
+							cursors = append(cursors, cur)
+							if len(cursors) == 2 {
+								return errors.New("stop")
+							}
+							return nil
+						},
+					}
+					n, err := opts.UpdateAll(c, ds.NewQuery("Foo"), bump)
+					So(err, ShouldErrLike, "stop")
+					So(n, ShouldEqual, 10)
+					So(sum(), ShouldEqual, 22)
+
+					n, err = opts.UpdateAll(c, ds.NewQuery("Foo").Start(cursors[1]), bump)
+					So(err, ShouldBeNil)
+					So(n, ShouldEqual, 2)
+					So(cursors[2:], ShouldResemble, []ds.Cursor{nil})
+					So(sum(), ShouldEqual, 24)
+				})
+			}
+
+			Convey("does nothing in dry-run mode", func() {
+				opts := ds.UpdateAllOptions{DryRun: true, Transactional: true}
+				n, err := opts.UpdateAll(c, ds.NewQuery("Foo"), bump)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 12)
+				So(sum(), ShouldEqual, 12)
+			})
+
+			Convey("stops on callback errors", func() {
+				n, err := ds.UpdateAll(c, ds.NewQuery("Foo"), func(context.Context, ds.PropertyMap) (bool, error) {
+					return false, errors.New("boom")
+				})
+				So(err, ShouldErrLike, "boom")
+				So(n, ShouldEqual, 0)
+			})
+
+			Convey("rejects partial entities", func() {
+				_, err := ds.UpdateAll(c, ds.NewQuery("Foo").Project("Val"), bump)
+				So(err, ShouldErrLike, "projection query")
+				_, err = ds.UpdateAll(c, ds.NewQuery("Foo").KeysOnly(true), bump)
+				So(err, ShouldErrLike, "keys-only query")
+				So(sum(), ShouldEqual, 12)
+
+				opts := ds.UpdateAllOptions{Transactional: true}
+				n, err := opts.UpdateAll(c, ds.NewQuery("Foo").KeysOnly(true), bump)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 12)
+				So(sum(), ShouldEqual, 24)
+			})
+		})
+
+		Convey("ProcessAll", func() {
+			ds.GetTestable(c).Consistent(true)
+			foos := make([]*Foo, 25)
+			for i := range foos {
+				foos[i] = &Foo{ID: int64(i + 1), Val: i}
+			}
+			So(ds.Put(c, foos), ShouldBeNil)
+
+			var lock sync.Mutex
+			sum := int64(0)
+			add := func(c context.Context, pm ds.PropertyMap) error {
+				lock.Lock()
+				defer lock.Unlock()
+				sum += pm.Slice("Val")[0].Value().(int64)
+				return nil
+			}
+
+			Convey("processes everything matched", func() {
+				opts := ds.ProcessAllOptions{BatchSize: 4, Workers: 3}
+				n, err := opts.ProcessAll(c, ds.NewQuery("Foo"), add)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 25)
+				So(sum, ShouldEqual, 300)
+			})
+
+			Convey("can skip fetching the entities", func() {
+				opts := ds.ProcessAllOptions{KeysOnly: true}
+				n, err := opts.ProcessAll(c, ds.NewQuery("Foo"), func(c context.Context, pm ds.PropertyMap) error {
+					if _, ok := pm["Val"]; ok {
+						return errors.New("fetched")
+					}
+					return nil
+				})
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 25)
+			})
+
+			Convey("aggregates errors", func() {
+				failOdd := func(c context.Context, pm ds.PropertyMap) error {
+					if pm.Slice("Val")[0].Value().(int64)%2 == 1 {
+						return errors.New("odd")
+					}
+					return nil
+				}
+
+				opts := ds.ProcessAllOptions{BatchSize: 5, ContinueOnError: true}
+				n, err := opts.ProcessAll(c, ds.NewQuery("Foo"), failOdd)
+				So(n, ShouldEqual, 13)
+				So(err, ShouldErrLike, "odd")
+				So(err.(errors.MultiError), ShouldHaveLength, 12)
+
+				Convey("and stops on the first one by default", func() {
+					opts := ds.ProcessAllOptions{BatchSize: 5, Workers: 1}
+					n, err := opts.ProcessAll(c, ds.NewQuery("Foo"), failOdd)
+					So(n, ShouldEqual, 1)
+					So(err, ShouldErrLike, "failed to process")
+					So(err.(errors.MultiError), ShouldHaveLength, 1)
+				})
+			})
+
+			Convey("stops when canceled", func() {
+				cc, cancel := context.WithCancel(c)
+				defer cancel()
+				_, err := ds.ProcessAll(cc, ds.NewQuery("Foo"), func(context.Context, ds.PropertyMap) error {
+					cancel()
+					return nil
+				})
+				So(err, ShouldEqual, context.Canceled)
+			})
+		})
+
+		Convey("Writer", func() {
+			ds.GetTestable(c).Consistent(true)
+			count := func() int64 {
+				n, err := ds.Count(c, ds.NewQuery("Foo"))
+				So(err, ShouldBeNil)
+				return n
+			}
+
+			Convey("flushes when full", func() {
+				w := ds.NewWriter(c, &ds.WriterOptions{FlushSize: 3})
+				for i := 1; i <= 7; i++ {
+					So(w.Put(&Foo{ID: int64(i)}), ShouldBeNil)
+				}
+				So(w.Pending(), ShouldEqual, 1)
+				So(count(), ShouldEqual, 6)
+
+				So(w.Close(), ShouldBeNil)
+				So(count(), ShouldEqual, 7)
+				So(w.Put(&Foo{ID: 8}), ShouldEqual, ds.ErrWriterClosed)
+			})
+
+			Convey("keeps the last write of each key", func() {
+				So(ds.Put(c, &Foo{ID: 1}), ShouldBeNil)
+				w := ds.NewWriter(c, nil)
+				So(w.Put(&Foo{ID: 1, Val: 1}, &Foo{ID: 2, Val: 1}), ShouldBeNil)
+				So(w.Delete(ds.KeyForObj(c, &Foo{ID: 1})), ShouldBeNil)
+				So(w.Put(&Foo{ID: 2, Val: 2}), ShouldBeNil)
+				So(w.Pending(), ShouldEqual, 2)
+				So(w.Flush(), ShouldBeNil)
+
+				foo := &Foo{ID: 2}
+				So(ds.Get(c, foo), ShouldBeNil)
+				So(foo.Val, ShouldEqual, 2)
+				So(ds.Get(c, &Foo{ID: 1}), ShouldEqual, ds.ErrNoSuchEntity)
+			})
+
+			Convey("reports failed writes", func() {
+				So(ds.Put(c, &Foo{ID: 1}, &Foo{ID: 2}), ShouldBeNil)
+				bad := ds.KeyForObj(c, &Foo{ID: 2})
+				fc := ds.AddRawFilters(c, func(_ context.Context, raw ds.RawInterface) ds.RawInterface {
+					return &deleteFailer{raw, bad}
+				})
+
+				w := ds.NewWriter(fc, nil)
+				So(w.Delete(ds.KeyForObj(c, &Foo{ID: 1}), bad), ShouldBeNil)
+				err := w.Flush()
+				So(err, ShouldResemble, errors.MultiError{
+					&ds.WriteError{Op: ds.MutationDelete, Key: bad, Err: errors.New("boom")},
+				})
+				So(err, ShouldErrLike, "failed to delete")
+				So(count(), ShouldEqual, 1)
+				So(w.Close(), ShouldBeNil)
+			})
+
+			Convey("flushes in the background", func() {
+				w := ds.NewWriter(c, &ds.WriterOptions{FlushInterval: time.Millisecond})
+				defer w.Close()
+				So(w.Put(&Foo{ID: 1}), ShouldBeNil)
+				for i := 0; i < 1000 && w.Pending() > 0; i++ {
+					time.Sleep(time.Millisecond)
+				}
+				So(w.Pending(), ShouldEqual, 0)
+				So(count(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("Reused PropertyMaps", func() {
+			foos := make([]*Foo, 5)
+			for i := range foos {
+				foos[i] = &Foo{ID: int64(i + 1), Val: i, Name: fmt.Sprintf("foo%d", i)}
+				if i%2 == 0 {
+					foos[i].Multi = []string{"a", "b"}
+				}
+			}
+			So(ds.Put(c, foos), ShouldBeNil)
+			ds.GetTestable(c).Consistent(true)
+			q := ds.NewQuery("Foo")
+
+			Convey("don't leak properties between entities", func() {
+				var all []*Foo
+				So(ds.GetAll(c, q, &all), ShouldBeNil)
+				So(all, ShouldResemble, foos)
+
+				var run []*Foo
+				So(ds.Run(c, q, func(f *Foo) { run = append(run, f) }), ShouldBeNil)
+				So(run, ShouldResemble, foos)
+
+				got := make([]*Foo, len(foos))
+				for i := range got {
+					got[i] = &Foo{ID: int64(i + 1)}
+				}
+				So(ds.Get(c, got), ShouldBeNil)
+				So(got, ShouldResemble, foos)
+
+				var proj []*Foo
+				So(ds.GetAll(c, q.Project("Val"), &proj), ShouldBeNil)
+				So(len(proj), ShouldEqual, len(foos))
+				for i, f := range proj {
+					So(f.Val, ShouldEqual, i)
+				}
+			})
+
+			Convey("aren't reused when they escape", func() {
+				var pms []ds.PropertyMap
+				So(ds.Run(c, q, func(pm ds.PropertyMap) { pms = append(pms, pm) }), ShouldBeNil)
+				So(len(pms), ShouldEqual, len(foos))
+				for i, pm := range pms {
+					So(pm.Slice("Val")[0].Value(), ShouldEqual, int64(i))
+				}
+
+				fq, err := q.Finalize()
+				So(err, ShouldBeNil)
+				pms = nil
+				So(ds.Raw(c).Run(fq, func(_ *ds.Key, pm ds.PropertyMap, _ ds.CursorCB) error {
+					pms = append(pms, pm)
+					return nil
+				}), ShouldBeNil)
+				for i, pm := range pms {
+					So(pm.Slice("Val")[0].Value(), ShouldEqual, int64(i))
+				}
+			})
+		})
+
+		Convey("GetOrInsert", func() {
+			initCalls := 0
+			initFoo := func(f *Foo) func() error {
+				return func() error {
+					initCalls++
+					f.Name = "new"
+					return nil
+				}
+			}
+
+			Convey("inserts missing entities", func() {
+				f := &Foo{ID: 1}
+				created, err := ds.GetOrInsert(c, f, initFoo(f))
+				So(err, ShouldBeNil)
+				So(created, ShouldBeTrue)
+				So(initCalls, ShouldEqual, 1)
+
+				got := &Foo{ID: 1}
+				So(ds.Get(c, got), ShouldBeNil)
+				So(got.Name, ShouldEqual, "new")
+			})
+
+			Convey("gets existing entities", func() {
+				So(ds.Put(c, &Foo{ID: 1, Name: "old"}), ShouldBeNil)
+				f := &Foo{ID: 1}
+				created, err := ds.GetOrInsert(c, f, initFoo(f))
+				So(err, ShouldBeNil)
+				So(created, ShouldBeFalse)
+				So(initCalls, ShouldEqual, 0)
+				So(f.Name, ShouldEqual, "old")
+			})
+
+			Convey("uses the current transaction", func() {
+				f := &Foo{ID: 1}
+				var created bool
+				err := ds.RunInTransaction(c, func(c context.Context) (err error) {
+					created, err = ds.GetOrInsert(c, f, initFoo(f))
+					return
+				}, nil)
+				So(err, ShouldBeNil)
+				So(created, ShouldBeTrue)
+				So(ds.Get(c, &Foo{ID: 1}), ShouldBeNil)
+			})
+
+			Convey("doesn't Put if init fails", func() {
+				created, err := ds.GetOrInsert(c, &Foo{ID: 1}, func() error { return errors.New("nope") })
+				So(err, ShouldErrLike, "nope")
+				So(created, ShouldBeFalse)
+				So(ds.Get(c, &Foo{ID: 1}), ShouldEqual, ds.ErrNoSuchEntity)
+			})
+
+			Convey("requires a complete key", func() {
+				_, err := ds.GetOrInsert(c, &Foo{}, func() error { return nil })
+				So(err, ShouldErrLike, "requires a complete key")
+			})
+		})
+
+		Convey("Mutate", func() {
+			So(ds.Put(c, &Foo{ID: 11, Val: 1}, &Foo{ID: 12, Val: 2}), ShouldBeNil)
+
+			Convey("applies all mutations in one go", func() {
+				newFoo := &Foo{Val: 10}
+				So(ds.Mutate(c,
+					ds.NewInsert(&Foo{ID: 13, Val: 3}),
+					ds.NewInsert(newFoo),
+					ds.NewUpdate(&Foo{ID: 11, Val: 100}),
+					ds.NewUpsert(&Foo{ID: 14, Val: 4}),
+					ds.NewDelete(&Foo{ID: 12}),
+				), ShouldBeNil)
+				So(newFoo.ID, ShouldNotEqual, 0)
+
+				foos := []*Foo{{ID: 11}, {ID: 12}, {ID: 13}, {ID: 14}, {ID: newFoo.ID}}
+				So(ds.Get(c, foos), ShouldResemble, errors.MultiError{nil, ds.ErrNoSuchEntity, nil, nil, nil})
+				So(foos[0].Val, ShouldEqual, 100)
+				So(foos[4].Val, ShouldEqual, 10)
+			})
+
+			Convey("applies nothing if a precondition fails", func() {
+				err := ds.Mutate(c,
+					ds.NewDelete(&Foo{ID: 11}),
+					ds.NewInsert(&Foo{ID: 12, Val: 20}),
+					ds.NewUpdate(&Foo{ID: 13, Val: 30}),
+				)
+				So(err, ShouldResemble, errors.MultiError{nil, ds.ErrEntityExists, ds.ErrNoSuchEntity})
+				So(ds.Get(c, &Foo{ID: 11}), ShouldBeNil)
+			})
+
+			Convey("rejects updates of special keys", func() {
+				err := ds.Mutate(c, ds.NewUpdate(ds.PropertyMap{
+					"$key": ds.MkPropertyNI(ds.MakeKey(c, "__entity_group__", 1)),
+				}))
+				So(err, ShouldErrLike, "is not valid")
+			})
+
+			Convey("checks each mutation against the ones before it", func() {
+				So(ds.Mutate(c,
+					ds.NewDelete(&Foo{ID: 11}),
+					ds.NewInsert(&Foo{ID: 11, Val: 11}),
+					ds.NewInsert(&Foo{ID: 13, Val: 3}),
+					ds.NewUpdate(&Foo{ID: 13, Val: 33}),
+				), ShouldBeNil)
+				foos := []*Foo{{ID: 11}, {ID: 13}}
+				So(ds.Get(c, foos), ShouldBeNil)
+				So(foos[0].Val, ShouldEqual, 11)
+				So(foos[1].Val, ShouldEqual, 33)
+			})
+
+			Convey("works in transactions", func() {
+				err := ds.RunInTransaction(c, func(c context.Context) error {
+					return ds.Mutate(c,
+						ds.NewUpdate(&Foo{ID: 11, Val: 100}),
+						ds.NewInsert(&Foo{ID: 12, Val: 20}),
+					)
+				}, &ds.TransactionOptions{XG: true})
+				So(err, ShouldResemble, errors.MultiError{nil, ds.ErrEntityExists})
+
+				So(ds.RunInTransaction(c, func(c context.Context) error {
+					return ds.Mutate(c,
+						ds.NewUpdate(&Foo{ID: 11, Val: 100}),
+						ds.NewDelete(&Foo{ID: 12}),
+					)
+				}, &ds.TransactionOptions{XG: true}), ShouldBeNil)
+				foo := &Foo{ID: 11}
+				So(ds.Get(c, foo), ShouldBeNil)
+				So(foo.Val, ShouldEqual, 100)
+				So(ds.Get(c, &Foo{ID: 12}), ShouldEqual, ds.ErrNoSuchEntity)
+			})
+		})
+
+		Convey("Insert and Update", func() {
+			So(ds.Insert(c, &Foo{ID: 1, Val: 1}), ShouldBeNil)
+			So(ds.Insert(c, &Foo{ID: 1, Val: 2}), ShouldEqual, ds.ErrEntityExists)
+
+			So(ds.Update(c, &Foo{ID: 1, Val: 3}), ShouldBeNil)
+			So(ds.Update(c, []*Foo{{ID: 1, Val: 4}, {ID: 2}}), ShouldResemble,
+				errors.MultiError{nil, ds.ErrNoSuchEntity})
+
+			foo := &Foo{ID: 1}
+			So(ds.Get(c, foo), ShouldBeNil)
+			So(foo.Val, ShouldEqual, 3)
+			So(ds.Get(c, &Foo{ID: 2}), ShouldEqual, ds.ErrNoSuchEntity)
+		})
+
+		Convey("Entity versions", func() {
+			type Versioned struct {
+				ID      int64 `gae:"$id"`
+				Version int64 `gae:"$version"`
+				Val     int
+			}
+
+			v := &Versioned{ID: 1}
+			So(ds.Put(c, v), ShouldBeNil)
+			So(v.Version, ShouldBeGreaterThan, 0)
+			first := v.Version
+
+			got := &Versioned{ID: 1}
+			So(ds.Get(c, got), ShouldBeNil)
+			So(got.Version, ShouldEqual, first)
+
+			Convey("increase on every write", func() {
+				So(ds.Put(c, got), ShouldBeNil)
+				So(got.Version, ShouldBeGreaterThan, first)
+
+				So(ds.Mutate(c, ds.NewUpdate(v)), ShouldBeNil)
+				So(v.Version, ShouldBeGreaterThan, got.Version)
+
+				So(ds.RunInTransaction(c, func(c context.Context) error {
+					return ds.Put(c, &Versioned{ID: 1, Val: 1})
+				}, nil), ShouldBeNil)
+				So(ds.Get(c, got), ShouldBeNil)
+				So(got.Version, ShouldBeGreaterThan, v.Version)
+			})
+
+			Convey("are only reported to PropertyMaps which ask", func() {
+				pm := ds.PropertyMap{}
+				ds.PopulateKey(pm, ds.KeyForObj(c, v))
+				So(ds.Get(c, pm), ShouldBeNil)
+				_, ok := pm.GetMeta(ds.VersionMeta)
+				So(ok, ShouldBeFalse)
+
+				pm.SetMeta(ds.VersionMeta, int64(0))
+				So(ds.Get(c, pm), ShouldBeNil)
+				So(ds.GetMetaDefault(pm, ds.VersionMeta, nil), ShouldEqual, first)
+			})
+		})
+
+		Convey("Transaction commit info", func() {
+			ci, err := ds.RunInTransactionWithInfo(c, func(c context.Context) error {
+				return ds.Put(c, &Foo{ID: 1})
+			}, nil)
+			So(err, ShouldBeNil)
+			So(ci, ShouldNotBeNil)
+			So(ci.ID, ShouldNotEqual, "")
+			So(ci.Time.IsZero(), ShouldBeFalse)
+
+			Convey("is unique per transaction", func() {
+				ci2, err := ds.RunInTransactionWithInfo(c, func(c context.Context) error {
+					return nil
+				}, nil)
+				So(err, ShouldBeNil)
+				So(ci2.ID, ShouldNotEqual, ci.ID)
+				So(ci2.Time.Before(ci.Time), ShouldBeFalse)
+			})
+
+			Convey("is nil if the transaction fails", func() {
+				ci, err := ds.RunInTransactionWithInfo(c, func(c context.Context) error {
+					return errors.New("nope")
+				}, nil)
+				So(err, ShouldErrLike, "nope")
+				So(ci, ShouldBeNil)
+			})
+		})
+
+		Convey("ReserveIDRange", func() {
+			So(ds.ReserveIDRange(c, ds.MakeKey(c, "Foo", 1), 1, 10), ShouldBeNil)
+
+			f := &Foo{}
+			So(ds.Put(c, f), ShouldBeNil)
+			So(f.ID, ShouldEqual, 11)
+
+			Convey("never moves the counter backwards", func() {
+				So(ds.ReserveIDRange(c, ds.MakeKey(c, "Foo", 1), 3, 5), ShouldBeNil)
+				keys := ds.NewIncompleteKeys(c, 1, "Foo", nil)
+				So(ds.AllocateIDs(c, keys), ShouldBeNil)
+				So(keys[0].IntID(), ShouldEqual, 12)
+			})
+
+			Convey("is tracked per kind and parent", func() {
+				keys := ds.NewIncompleteKeys(c, 1, "Bar", nil)
+				So(ds.AllocateIDs(c, keys), ShouldBeNil)
+				So(keys[0].IntID(), ShouldEqual, 1)
+
+				par := ds.MakeKey(c, "Parent", 1)
+				So(ds.ReserveIDRange(c, ds.MakeKey(c, "Parent", 1, "Foo", 0), 1, 100), ShouldBeNil)
+				keys = ds.NewIncompleteKeys(c, 1, "Foo", par)
+				So(ds.AllocateIDs(c, keys), ShouldBeNil)
+				So(keys[0].IntID(), ShouldEqual, 101)
+			})
+
+			Convey("is respected by ID generators", func() {
+				ds.GetTestable(c).SetIDGenerator(SequentialIDs(1))
+				So(ds.ReserveIDRange(c, ds.MakeKey(c, "Foo", 1), 20, 30), ShouldBeNil)
+				keys := ds.NewIncompleteKeys(c, 20, "Foo", nil)
+				So(ds.AllocateIDs(c, keys), ShouldBeNil)
+				for _, k := range keys {
+					So(k.IntID(), ShouldNotBeBetweenOrEqual, 20, 30)
+				}
+			})
+
+			Convey("rejects bad ranges", func() {
+				So(ds.ReserveIDRange(c, ds.MakeKey(c, "Foo", 1), 0, 10), ShouldErrLike, "invalid ID range")
+				So(ds.ReserveIDRange(c, ds.MakeKey(c, "Foo", 1), 10, 9), ShouldErrLike, "invalid ID range")
+			})
+		})
+
+		Convey("Aggregation queries", func() {
+			So(ds.Put(c, []*Foo{{ID: 1, Val: 10}, {ID: 2, Val: 20}, {ID: 3, Val: 30, Name: "x"}}), ShouldBeNil)
+			ds.GetTestable(c).CatchupIndexes()
+
+			res, err := ds.RunAggregation(c, ds.NewQuery("Foo").Aggregate(
+				ds.CountAs("n"), ds.SumOf("Val"), ds.AvgOf("Val").As("avg")))
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, ds.AggregationResult{"n": int64(3), "Val": int64(60), "avg": 20.0})
+
+			res, err = ds.RunAggregation(c, ds.NewQuery("Foo").Eq("Name", "").Aggregate(ds.CountAs("n")))
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, ds.AggregationResult{"n": int64(2)})
+
+			Convey("in a transaction", func() {
+				So(ds.RunInTransaction(c, func(c context.Context) error {
+					res, err := ds.RunAggregation(c, ds.NewQuery("Foo").Ancestor(ds.MakeKey(c, "Foo", 1)).Aggregate(
+						ds.SumOf("Val")))
+					So(err, ShouldBeNil)
+					So(res, ShouldResemble, ds.AggregationResult{"Val": int64(10)})
+					return nil
+				}, nil), ShouldBeNil)
+			})
+		})
+
+		Convey("TTL policies", func() {
+			type Session struct {
+				ID      int64     `gae:"$id"`
+				Expires time.Time `gae:",ttl"`
+			}
+			c, tc := testclock.UseTime(c, testclock.TestTimeUTC)
+			ds.GetTestable(c).Consistent(true)
+
+			So(ds.Put(c, []*Session{
+				{ID: 11, Expires: testclock.TestTimeUTC.Add(time.Minute)},
+				{ID: 12, Expires: testclock.TestTimeUTC.Add(time.Hour)},
+			}), ShouldBeNil)
+			So(ds.Put(c, ds.PropertyMap{
+				"$key": ds.MkPropertyNI(ds.NewKey(c, "Session", "", 13, nil)),
+			}), ShouldBeNil)
+			So(ds.Get(c, &Session{ID: 11}), ShouldBeNil)
+
+			// Expired entities are only deleted once the clock moves.
+			So(ds.Put(c, &Session{ID: 14, Expires: testclock.TestTimeUTC.Add(-time.Second)}), ShouldBeNil)
+			So(ds.Get(c, &Session{ID: 14}), ShouldBeNil)
+
+			tc.Add(time.Minute)
+			So(ds.Get(c, &Session{ID: 11}), ShouldEqual, ds.ErrNoSuchEntity)
+			So(ds.Get(c, &Session{ID: 14}), ShouldEqual, ds.ErrNoSuchEntity)
+			So(ds.Get(c, &Session{ID: 12}), ShouldBeNil)
+
+			var ids []int64
+			So(ds.Run(c, ds.NewQuery("Session"), func(s *Session) {
+				ids = append(ids, s.ID)
+			}), ShouldBeNil)
+			So(ids, ShouldResemble, []int64{12, 13})
+
+			tc.Add(time.Hour)
+			n, err := ds.Count(c, ds.NewQuery("Session").Lte("Expires", testclock.TestTimeUTC.Add(time.Hour)))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+
+			// Entities without an expiration time are kept forever.
+			ex, err := ds.Exists(c, ds.NewKey(c, "Session", "", 13, nil))
+			So(err, ShouldBeNil)
+			So(ex.All(), ShouldBeTrue)
+		})
+
+		Convey("Read times", func() {
+			c, tc := testclock.UseTime(c, testclock.TestTimeUTC)
+			ds.GetTestable(c).Consistent(true)
+
+			So(ds.Get(ds.WithReadTime(c, testclock.TestTimeUTC), &Foo{ID: 11}), ShouldErrLike, "require history")
+
+			ds.GetTestable(c).KeepHistory(true)
+			tc.Add(time.Second)
+			So(ds.Put(c, &Foo{ID: 11, Val: 1}), ShouldBeNil)
+			before := tc.Now()
+			tc.Add(time.Second)
+			So(ds.Put(c, &Foo{ID: 11, Val: 2}, &Foo{ID: 12, Val: 2}), ShouldBeNil)
+
+			rc := ds.WithReadTime(c, before)
+			foo := &Foo{ID: 11}
+			So(ds.Get(rc, foo), ShouldBeNil)
+			So(foo.Val, ShouldEqual, 1)
+			So(ds.Get(rc, &Foo{ID: 12}), ShouldEqual, ds.ErrNoSuchEntity)
+			So(ds.Get(c, foo), ShouldBeNil)
+			So(foo.Val, ShouldEqual, 2)
+
+			n, err := ds.Count(rc, ds.NewQuery("Foo"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			n, err = ds.Count(ds.WithReadTime(c, testclock.TestTimeUTC), ds.NewQuery("Foo"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+
+			Convey("in read only transactions", func() {
+				opts := &ds.TransactionOptions{ReadOnly: true, ReadTime: before}
+				So(ds.RunInTransaction(c, func(c context.Context) error {
+					foo := &Foo{ID: 11}
+					So(ds.Get(c, foo), ShouldBeNil)
+					So(foo.Val, ShouldEqual, 1)
+					return nil
+				}, opts), ShouldBeNil)
+
+				So(ds.RunInTransaction(rc, func(c context.Context) error {
+					return ds.Get(c, &Foo{ID: 11})
+				}, nil), ShouldErrLike, "can't be used in transactions")
+			})
+
+			Convey("only within the retention period", func() {
+				So(ds.Get(ds.WithReadTime(c, tc.Now().Add(time.Second)), foo), ShouldErrLike, "in the future")
+
+				tc.Add(2 * time.Hour)
+				So(ds.Get(rc, foo), ShouldErrLike, "older than")
+				So(ds.Get(ds.WithReadTime(c, tc.Now().Add(-time.Minute)), foo), ShouldBeNil)
+				So(foo.Val, ShouldEqual, 2)
+			})
+		})
+
+		Convey("Foreign app IDs are rejected", func() {
+			oc := ds.WithAppID(c, "other")
+			So(ds.Put(oc, &Foo{ID: 1}), ShouldErrLike, `can't be used for app "other"`)
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				return ds.Get(ds.WithAppID(c, "other"), &Foo{ID: 1})
+			}, nil), ShouldErrLike, `can't be used for app "other"`)
+			So(ds.Put(ds.WithAppID(c, "dev~app"), &Foo{ID: 1}), ShouldBeNil)
+		})
+
+		Convey("Named databases are rejected", func() {
+			dc := ds.WithDatabase(c, "other")
+			So(ds.Put(dc, &Foo{ID: 1}), ShouldErrLike, `only supports the default database, not "other"`)
+			So(ds.Get(dc, &Foo{ID: 1}), ShouldErrLike, `not "other"`)
+			So(ds.RunInTransaction(dc, func(context.Context) error { return nil }, nil), ShouldErrLike, `not "other"`)
+
+			So(ds.Put(ds.WithDatabase(c, ds.DefaultDatabase), &Foo{ID: 1}), ShouldBeNil)
+		})
+
+		Convey("Operations on a cancelled context fail", func() {
+			ds.GetTestable(c).Consistent(true)
+			So(ds.Put(c, &Foo{ID: 1}, &Foo{ID: 2}, &Foo{ID: 3}), ShouldBeNil)
+
+			cc, cancel := context.WithCancel(c)
+			cancel()
+
+			So(ds.Put(cc, &Foo{ID: 4}), ShouldEqual, context.Canceled)
+			So(ds.Get(cc, &Foo{ID: 1}), ShouldEqual, context.Canceled)
+			So(ds.Delete(cc, ds.KeyForObj(c, &Foo{ID: 1})), ShouldEqual, context.Canceled)
+			_, err := ds.Count(cc, ds.NewQuery("Foo"))
+			So(err, ShouldEqual, context.Canceled)
+			So(ds.RunInTransaction(cc, func(context.Context) error { return nil }, nil),
+				ShouldEqual, context.Canceled)
+
+			So(ds.Get(c, &Foo{ID: 1}), ShouldBeNil)
+			So(ds.Get(c, &Foo{ID: 4}), ShouldEqual, ds.ErrNoSuchEntity)
+
+			Convey("between query results", func() {
+				cc, cancel := context.WithCancel(c)
+				count := 0
+				err := ds.Run(cc, ds.NewQuery("Foo"), func(*Foo) {
+					count++
+					cancel()
+				})
+				So(err, ShouldEqual, context.Canceled)
+				So(count, ShouldEqual, 1)
+			})
+
+			Convey("between entities", func() {
+				cc, cancel := context.WithCancel(c)
+				var got int
+				err := ds.Raw(cc).GetMulti(
+					[]*ds.Key{ds.MakeKey(c, "Foo", 1), ds.MakeKey(c, "Foo", 2)}, nil,
+					func(int, ds.PropertyMap, error) error {
+						got++
+						cancel()
+						return nil
+					})
+				So(err, ShouldEqual, context.Canceled)
+				So(got, ShouldEqual, 1)
+			})
+		})
+
 		Convey("Datastore namespace interaction", func() {
 			run := func(rc context.Context, txn bool) (putErr, getErr, queryErr, countErr error) {
 				var foo Foo
@@ -740,6 +1843,128 @@ func TestDefaultTimeField(t *testing.T) {
 	})
 }
 
+func TestConstraints(t *testing.T) {
+	t.Parallel()
+
+	Convey("Constraints", t, func() {
+		c := Use(context.Background())
+		So(ds.GetConstraints(c), ShouldResemble, ds.Constraints{
+			MaxGetSize:                    1000,
+			MaxPutSize:                    500,
+			MaxDeleteSize:                 500,
+			MaxEntityGroupsPerTransaction: 25,
+			NoInQueries:                   true,
+			NoDistinctCursors:             true,
+		})
+
+		putGroups := func(n int64) error {
+			return ds.RunInTransaction(c, func(c context.Context) error {
+				for i := int64(1); i <= n; i++ {
+					if err := ds.Put(c, &Foo{ID: i}); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, &ds.TransactionOptions{XG: true})
+		}
+
+		Convey("limit the entity groups of XG transactions", func() {
+			So(ds.GetTestable(c).SetConstraints(&ds.Constraints{MaxEntityGroupsPerTransaction: 2}), ShouldBeNil)
+			So(ds.GetConstraints(c).MaxEntityGroupsPerTransaction, ShouldEqual, 2)
+			So(putGroups(2), ShouldBeNil)
+			So(putGroups(3), ShouldErrLike, "too many entity groups")
+		})
+
+		Convey("can be removed, except for the XG limit", func() {
+			So(ds.GetTestable(c).SetConstraints(nil), ShouldBeNil)
+			So(ds.GetConstraints(c), ShouldResemble, ds.Constraints{
+				MaxEntityGroupsPerTransaction: 25,
+				NoInQueries:                   true,
+				NoDistinctCursors:             true,
+			})
+			So(putGroups(25), ShouldBeNil)
+			So(putGroups(26), ShouldErrLike, "too many entity groups")
+
+			So(ds.GetTestable(c).SetConstraints(&ds.Constraints{MaxPutSize: 1}), ShouldBeNil)
+			So(ds.GetConstraints(c).MaxEntityGroupsPerTransaction, ShouldEqual, 25)
+			So(putGroups(26), ShouldErrLike, "too many entity groups")
+		})
+	})
+}
+
+func TestAutoNowFields(t *testing.T) {
+	t.Parallel()
+
+	Convey("created and updated fields are set from the clock", t, func() {
+		type Model struct {
+			ID      int64     `gae:"$id"`
+			Created time.Time `gae:",created"`
+			Updated time.Time `gae:",updated"`
+		}
+		c, tc := UseWithTestClock(context.Background(), testclock.TestTimeUTC)
+		created := testclock.TestTimeUTC.Round(time.Microsecond)
+
+		m := &Model{ID: 1}
+		So(ds.Put(c, m), ShouldBeNil)
+		So(m.Created, ShouldResemble, created)
+		So(m.Updated, ShouldResemble, created)
+
+		tc.Add(time.Minute)
+		So(ds.Put(c, []Model{*m}), ShouldBeNil)
+		So(ds.Get(c, m), ShouldBeNil)
+		So(m.Created, ShouldResemble, created)
+		So(m.Updated, ShouldResemble, created.Add(time.Minute))
+
+		tc.Add(time.Minute)
+		So(ds.Mutate(c, ds.NewUpsert(m), ds.NewInsert(&Model{ID: 2})), ShouldBeNil)
+		So(m.Updated, ShouldResemble, created.Add(2*time.Minute))
+
+		m = &Model{ID: 2}
+		So(ds.Get(c, m), ShouldBeNil)
+		So(m.Created, ShouldResemble, created.Add(2*time.Minute))
+
+		tc.Add(time.Minute)
+		w := ds.NewWriter(c, nil)
+		So(w.Put(&Model{ID: 3}), ShouldBeNil)
+		So(w.Close(), ShouldBeNil)
+		m = &Model{ID: 3}
+		So(ds.Get(c, m), ShouldBeNil)
+		So(m.Created, ShouldResemble, created.Add(3*time.Minute))
+		So(m.Updated, ShouldResemble, created.Add(3*time.Minute))
+	})
+}
+
+func TestTuningDeadline(t *testing.T) {
+	t.Parallel()
+
+	Convey("A tuned Deadline applies to each datastore call", t, func() {
+		var deadlines []time.Time
+		built := 0
+		c := ds.AddRawFilters(Use(context.Background()), func(ic context.Context, raw ds.RawInterface) ds.RawInterface {
+			built++
+			if d, ok := ic.Deadline(); ok {
+				deadlines = append(deadlines, d)
+			}
+			return raw
+		})
+		c = tuning.Set(c, &tuning.Options{Datastore: tuning.Datastore{Deadline: time.Minute}})
+
+		start := time.Now()
+		So(ds.RunInTransaction(c, func(c context.Context) error {
+			return ds.Put(c, &Foo{ID: 1})
+		}, nil), ShouldBeNil)
+		So(ds.Get(c, &Foo{ID: 1}), ShouldBeNil)
+
+		// RunInTransaction itself has no deadline, but the calls in it do. The
+		// filters are applied once per call.
+		So(deadlines, ShouldHaveLength, 2)
+		So(built, ShouldEqual, 3)
+		for _, d := range deadlines {
+			So(d, ShouldHappenOnOrBetween, start.Add(time.Minute), time.Now().Add(time.Minute))
+		}
+	})
+}
+
 func TestNewDatastore(t *testing.T) {
 	t.Parallel()
 
@@ -879,3 +2104,70 @@ func TestConcurrentTxn(t *testing.T) {
 		}
 	})
 }
+
+// deleteFailer is a datastore filter which fails to delete the entity with
+// key bad.
+type deleteFailer struct {
+	ds.RawInterface
+
+	bad *ds.Key
+}
+
+func (d *deleteFailer) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+	var good []*ds.Key
+	var goodIdx []int
+	for i, k := range keys {
+		if k.Equal(d.bad) {
+			if err := cb(i, errors.New("boom")); err != nil {
+				return err
+			}
+		} else {
+			good = append(good, k)
+			goodIdx = append(goodIdx, i)
+		}
+	}
+	return d.RawInterface.DeleteMulti(good, func(idx int, err error) error {
+		return cb(goodIdx[idx], err)
+	})
+}
+
+// fooPLS is a Foo which is loaded by a custom PropertyLoadSaver, which doesn't
+// let queries reuse their PropertyMaps.
+type fooPLS Foo
+
+func (f *fooPLS) Load(pm ds.PropertyMap) error { return ds.GetPLS((*Foo)(f)).Load(pm) }
+
+func (f *fooPLS) Save(withMeta bool) (ds.PropertyMap, error) {
+	return ds.GetPLS((*Foo)(f)).Save(withMeta)
+}
+
+func BenchmarkQueryPropertyMaps(b *testing.B) {
+	c := Use(context.Background())
+	ds.GetTestable(c).Consistent(true)
+	foos := make([]*Foo, 100)
+	for i := range foos {
+		foos[i] = &Foo{ID: int64(i + 1), Val: i, Name: "foo", Multi: []string{"a", "b"}}
+	}
+	if err := ds.Put(c, foos); err != nil {
+		b.Fatal(err)
+	}
+	q := ds.NewQuery("Foo")
+
+	b.Run("reused", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := ds.Run(c, q, func(f *Foo) {}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := ds.Run(c, q, func(f *fooPLS) {}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/datastore/serialize"
+)
+
+// expire deletes the entities which have expired as of now according to the
+// TTL policy of their kind (see ds.RegisterTTLPolicy).
+//
+// Like the real datastore's, these deletions are not writes of the
+// application: they don't count towards the costs, and happen even when the
+// datastore is frozen. They happen as the clock advances: entities are only
+// looked at if now is past the last check, or new policies were registered
+// since, and then only the entities of the kinds with a policy.
+//
+// Returns true if any entities were deleted.
+func (d *dataStoreData) expire(now time.Time) (deleted bool) {
+	policies := ds.TTLPolicies()
+	if len(policies) == 0 {
+		return false
+	}
+
+	d.rwlock.RLock()
+	fresh := !now.After(d.expiredAt) && len(policies) == d.expiredPolicies
+	d.rwlock.RUnlock()
+	if fresh {
+		return false
+	}
+
+	d.rwlock.Lock()
+	defer d.rwlock.Unlock()
+	d.expiredAt, d.expiredPolicies = now, len(policies)
+
+	for _, ns := range namespaces(d.head) {
+		ents := d.head.GetCollection("ents:" + ns)
+		if ents == nil {
+			continue
+		}
+		kctx := ds.MkKeyContext(d.aid, ns)
+
+		type expiredEnt struct {
+			key *ds.Key
+			pm  ds.PropertyMap
+		}
+		var expired []expiredEnt
+		for _, p := range policies {
+			// The rows of the built-in kind index are the keys of the entities.
+			kindIdx := (&ds.IndexDefinition{Kind: p.Kind}).PrepForIdxTable()
+			idx := d.head.GetCollection(fmt.Sprintf("idx:%s:%s", ns, serialize.ToBytes(*kindIdx)))
+			if idx == nil {
+				continue
+			}
+			idx.ForEachItem(func(k, _ []byte) bool {
+				v := ents.Get(k)
+				if v == nil {
+					return true
+				}
+				pm, err := rpm(v)
+				memoryCorruption(err)
+				if p.Expired(pm, now) {
+					prop, err := serialize.ReadProperty(bytes.NewBuffer(k), serialize.WithoutContext, kctx)
+					memoryCorruption(err)
+					expired = append(expired, expiredEnt{prop.Value().(*ds.Key), pm})
+				}
+				return true
+			})
+		}
+
+		for _, e := range expired {
+			kb := keyBytes(e.key)
+			if !d.disableSpecialEntities {
+				incrementLocked(ents, groupMetaKey(e.key), 1)
+			}
+			ents.Delete(kb)
+			updateIndexes(d.head, e.key, e.pm, nil)
+			if vers := d.head.GetCollection("vers:" + ns); vers != nil {
+				vers.Delete(kb)
+			}
+			deleted = true
+		}
+	}
+	return
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"net/url"
+	"sync"
+
+	"go.chromium.org/gae/service/image"
+	"go.chromium.org/gae/service/storage"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// imageServingHost is the host of the serving URLs returned by the memory
+// image service.
+const imageServingHost = "localhost:8080"
+
+type imageData struct {
+	sync.Mutex
+
+	// serving is the set of "bucket/name" images which have serving URLs.
+	serving map[string]struct{}
+}
+
+// imageImpl is a contextual pointer to the current imageData.
+type imageImpl struct {
+	c    context.Context
+	data *imageData
+}
+
+var _ image.RawInterface = (*imageImpl)(nil)
+
+// useImage adds an image.RawInterface implementation to context, accessible
+// by image.Raw(c) or the exported image methods.
+//
+// Images must exist in the context's storage service. Serving URLs are
+// deterministic, of the form "http://localhost:8080/_ah/img/<bucket>/<name>",
+// followed by the size and crop options (see image.SizedURL). Nothing actually
+// serves them.
+func useImage(c context.Context) context.Context {
+	data := &imageData{
+		serving: map[string]struct{}{},
+	}
+	return image.SetFactory(c, func(ic context.Context) image.RawInterface {
+		return &imageImpl{ic, data}
+	})
+}
+
+func (i *imageImpl) GetServingURL(bucket, name string, opts *image.ServingURLOptions) (*url.URL, error) {
+	if _, err := storage.Stat(i.c, bucket, name); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &image.ServingURLOptions{}
+	}
+
+	i.data.Lock()
+	i.data.serving[bucket+"/"+name] = struct{}{}
+	i.data.Unlock()
+
+	u := &url.URL{
+		Scheme: "http",
+		Host:   imageServingHost,
+		Path:   "/_ah/img/" + bucket + "/" + name,
+	}
+	if opts.Secure {
+		u.Scheme = "https"
+	}
+	return image.SizedURL(u, opts.Size, opts.Crop), nil
+}
+
+func (i *imageImpl) DeleteServingURL(bucket, name string) error {
+	i.data.Lock()
+	defer i.data.Unlock()
+
+	if _, ok := i.data.serving[bucket+"/"+name]; !ok {
+		return errors.Reason("image %s/%s has no serving URL", bucket, name).Err()
+	}
+	delete(i.data.serving, bucket+"/"+name)
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+
+	"go.chromium.org/gae/service/image"
+	"go.chromium.org/gae/service/storage"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestImage(t *testing.T) {
+	t.Parallel()
+
+	Convey("image", t, func() {
+		c := Use(context.Background())
+
+		w, err := storage.NewWriter(c, "app.appspot.com", "cat.png", nil)
+		So(err, ShouldBeNil)
+		So(w.Close(), ShouldBeNil)
+
+		Convey("returns deterministic serving URLs", func() {
+			u, err := image.GetServingURL(c, "app.appspot.com", "cat.png", nil)
+			So(err, ShouldBeNil)
+			So(u.String(), ShouldEqual, "http://localhost:8080/_ah/img/app.appspot.com/cat.png")
+
+			u, err = image.GetServingURL(c, "app.appspot.com", "cat.png", &image.ServingURLOptions{
+				Secure: true,
+				Size:   32,
+				Crop:   true,
+			})
+			So(err, ShouldBeNil)
+			So(u.String(), ShouldEqual, "https://localhost:8080/_ah/img/app.appspot.com/cat.png=s32-c")
+		})
+
+		Convey("validates options", func() {
+			_, err := image.GetServingURL(c, "app.appspot.com", "cat.png", &image.ServingURLOptions{Crop: true})
+			So(err, ShouldErrLike, "crop requires a size")
+		})
+
+		Convey("requires the image to exist", func() {
+			_, err := image.GetServingURL(c, "app.appspot.com", "dog.png", nil)
+			So(err, ShouldEqual, storage.ErrObjectNotExist)
+		})
+
+		Convey("deletes serving URLs", func() {
+			So(image.DeleteServingURL(c, "app.appspot.com", "cat.png"), ShouldErrLike, "has no serving URL")
+
+			_, err := image.GetServingURL(c, "app.appspot.com", "cat.png", nil)
+			So(err, ShouldBeNil)
+			So(image.DeleteServingURL(c, "app.appspot.com", "cat.png"), ShouldBeNil)
+			So(image.DeleteServingURL(c, "app.appspot.com", "cat.png"), ShouldErrLike, "has no serving URL")
+		})
+	})
+}
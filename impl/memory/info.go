@@ -16,10 +16,13 @@ package memory
 
 import (
 	"fmt"
+	"strings"
+	"sync/atomic"
 
 	"go.chromium.org/gae/impl/dummy"
 	"go.chromium.org/gae/service/info"
 	"go.chromium.org/gae/service/info/support"
+	"go.chromium.org/luci/common/clock"
 
 	"golang.org/x/net/context"
 )
@@ -29,16 +32,39 @@ var giContextKey = "holds a *globalInfoData"
 var defaultGlobalInfoData = globalInfoData{
 	// versionID returns X.Y where Y is autogenerated by appengine, and X is
 	// whatever's in app.yaml.
-	versionID: "testVersionID.1",
-	requestID: "test-request-id",
+	versionID:      "testVersionID.1",
+	moduleName:     "default",
+	instanceID:     "test-instance-id",
+	serviceAccount: "gae_service_account@example.com",
 }
 
 type globalInfoData struct {
-	appID     string
-	fqAppID   string
-	namespace string
-	versionID string
-	requestID string
+	appID      string
+	fqAppID    string
+	namespace  string
+	versionID  string
+	requestID  string
+	moduleName string
+	instanceID string
+
+	// defaultVersionHostname, if empty, is derived from appID.
+	defaultVersionHostname string
+	serviceAccount         string
+
+	// modules, if not nil, is the set of modules that ModuleHostname will
+	// accept.
+	modules []info.Module
+}
+
+// module returns the module named name from gid.modules, or nil if there is
+// no such module.
+func (gid *globalInfoData) module(name string) *info.Module {
+	for i := range gid.modules {
+		if gid.modules[i].Name == name {
+			return &gid.modules[i]
+		}
+	}
+	return nil
 }
 
 func curGID(c context.Context) *globalInfoData {
@@ -59,6 +85,31 @@ func useGID(c context.Context, f func(mod *globalInfoData)) context.Context {
 	return context.WithValue(c, &giContextKey, &clone)
 }
 
+// requestCounter makes the request IDs generated by genRequestID unique within
+// the process.
+var requestCounter uint64
+
+// genRequestID generates a new request ID for the app fqAppID. It's shaped like
+// a production request ID: the hex-encoded request time in seconds, followed
+// by an opaque unique part and the hex-encoded app ID.
+func genRequestID(c context.Context, fqAppID string) string {
+	return fmt.Sprintf("%08x00ff%016x0001%x",
+		clock.Now(c).Unix(), atomic.AddUint64(&requestCounter, 1), fqAppID)
+}
+
+// BeginRequest returns a context derived from c which represents a new request
+// to the app, with a freshly generated request ID. Contexts returned by Use
+// already have their own request ID; BeginRequest is intended for local
+// development servers which derive a context per incoming request from a single
+// base context.
+//
+// The request ID can still be overridden with info's Testable.SetRequestID.
+func BeginRequest(c context.Context) context.Context {
+	return useGID(c, func(mod *globalInfoData) {
+		mod.requestID = genRequestID(c, mod.fqAppID)
+	})
+}
+
 // useGI adds a gae.GlobalInfo context, accessible
 // by gae.GetGI(c)
 func useGI(c context.Context) context.Context {
@@ -96,6 +147,9 @@ func (gi *giImpl) FullyQualifiedAppID() string {
 }
 
 func (gi *giImpl) DefaultVersionHostname() string {
+	if gi.defaultVersionHostname != "" {
+		return gi.defaultVersionHostname
+	}
 	return fmt.Sprintf("%s.example.com", gi.appID)
 }
 
@@ -104,7 +158,55 @@ func (gi *giImpl) IsDevAppServer() bool {
 }
 
 func (gi *giImpl) ServiceAccount() (string, error) {
-	return "gae_service_account@example.com", nil
+	return gi.serviceAccount, nil
+}
+
+func (gi *giImpl) ModuleName() string {
+	return gi.moduleName
+}
+
+func (gi *giImpl) InstanceID() string {
+	return gi.instanceID
+}
+
+// ModuleHostname returns a hostname of the form
+// "[instance.]version.module.<DefaultVersionHostname>".
+//
+// As in production, an empty module means the current module, and an empty
+// version means the current version for the current module, or the default
+// version for any other module.
+func (gi *giImpl) ModuleHostname(module, version, instance string) (string, error) {
+	if module == "" {
+		module = gi.moduleName
+	}
+	mod := gi.module(module)
+	if version == "" {
+		switch {
+		case module == gi.moduleName || mod == nil || len(mod.Versions) == 0:
+			// The major version, without the deployment-generated minor version.
+			version = strings.SplitN(gi.versionID, ".", 2)[0]
+		default:
+			version = mod.Versions[0]
+		}
+	}
+
+	if gi.modules != nil {
+		switch {
+		case mod == nil:
+			return "", fmt.Errorf("memory: unknown module %q", module)
+		case !stringInSlice(version, mod.Versions):
+			return "", fmt.Errorf("memory: unknown version %q of module %q", version, module)
+		case instance != "" && len(mod.Instances) > 0 && !stringInSlice(instance, mod.Instances):
+			return "", fmt.Errorf("memory: unknown instance %q of module %q", instance, module)
+		}
+	}
+
+	parts := make([]string, 0, 4)
+	if instance != "" {
+		parts = append(parts, instance)
+	}
+	parts = append(parts, version, module, gi.DefaultVersionHostname())
+	return strings.Join(parts, "."), nil
 }
 
 func (gi *giImpl) VersionID() string {
@@ -130,3 +232,47 @@ func (gi *giImpl) SetRequestID(v string) context.Context {
 		mod.requestID = v
 	})
 }
+
+func (gi *giImpl) SetModuleName(v string) context.Context {
+	return useGID(gi.c, func(mod *globalInfoData) {
+		mod.moduleName = v
+	})
+}
+
+func (gi *giImpl) SetInstanceID(v string) context.Context {
+	return useGID(gi.c, func(mod *globalInfoData) {
+		mod.instanceID = v
+	})
+}
+
+func (gi *giImpl) SetDefaultVersionHostname(v string) context.Context {
+	return useGID(gi.c, func(mod *globalInfoData) {
+		mod.defaultVersionHostname = v
+	})
+}
+
+func (gi *giImpl) SetServiceAccount(v string) context.Context {
+	return useGID(gi.c, func(mod *globalInfoData) {
+		mod.serviceAccount = v
+	})
+}
+
+func (gi *giImpl) SetModules(modules ...info.Module) context.Context {
+	return useGID(gi.c, func(mod *globalInfoData) {
+		mod.modules = make([]info.Module, len(modules))
+		for i, m := range modules {
+			m.Versions = append([]string(nil), m.Versions...)
+			m.Instances = append([]string(nil), m.Instances...)
+			mod.modules[i] = m
+		}
+	})
+}
+
+func stringInSlice(s string, slice []string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
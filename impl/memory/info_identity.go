@@ -0,0 +1,110 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/luci/common/clock"
+)
+
+// testSigningKeyName is the key name of the memory info service's signing key.
+const testSigningKeyName = "memory_test_key"
+
+// testAccessTokenLifetime is the lifetime of the access tokens returned by the
+// memory info service.
+const testAccessTokenLifetime = time.Hour
+
+// testSigningKey is the key used by the memory info service to sign bytes, and
+// its self-signed certificate. It's generated once per process, on first use.
+var testSigningKey struct {
+	once sync.Once
+	key  *rsa.PrivateKey
+	cert info.Certificate
+	err  error
+}
+
+func getTestSigningKey() (*rsa.PrivateKey, info.Certificate, error) {
+	k := &testSigningKey
+	k.once.Do(func() {
+		if k.key, k.err = rsa.GenerateKey(rand.Reader, 2048); k.err != nil {
+			return
+		}
+
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: testSigningKeyName},
+			NotBefore:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:     time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.key.PublicKey, k.key)
+		if err != nil {
+			k.err = err
+			return
+		}
+		k.cert = info.Certificate{
+			KeyName: testSigningKeyName,
+			Data:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		}
+	})
+	return k.key, k.cert, k.err
+}
+
+// AccessToken returns a fake access token, which encodes the service account
+// and the (sorted) scopes, and expires an hour from now.
+func (gi *giImpl) AccessToken(scopes ...string) (token string, expiry time.Time, err error) {
+	sorted := append([]string(nil), scopes...)
+	sort.Strings(sorted)
+	token = fmt.Sprintf("memory_token:%s:%s", gi.serviceAccount, strings.Join(sorted, " "))
+	return token, clock.Now(gi.c).Add(testAccessTokenLifetime).UTC(), nil
+}
+
+// PublicCertificates returns the self-signed certificate of the test key used
+// by SignBytes.
+func (gi *giImpl) PublicCertificates() ([]info.Certificate, error) {
+	_, cert, err := getTestSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	return []info.Certificate{cert}, nil
+}
+
+// SignBytes signs bytes with RSA-SHA256, as in production, using a test key
+// generated for the process.
+func (gi *giImpl) SignBytes(bytes []byte) (keyName string, signature []byte, err error) {
+	key, _, err := getTestSigningKey()
+	if err != nil {
+		return "", nil, err
+	}
+	digest := sha256.Sum256(bytes)
+	if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+		return "", nil, err
+	}
+	return testSigningKeyName, signature, nil
+}
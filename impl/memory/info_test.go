@@ -15,12 +15,19 @@
 package memory
 
 import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"testing"
+	"time"
 
 	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/module"
+	"go.chromium.org/luci/common/clock/testclock"
 	"golang.org/x/net/context"
 
 	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
 )
 
 func TestMustNamespace(t *testing.T) {
@@ -30,7 +37,7 @@ func TestMustNamespace(t *testing.T) {
 		// Default value.
 		So(info.AppID(c), ShouldEqual, "app-id")
 		So(info.FullyQualifiedAppID(c), ShouldEqual, "dev~app-id")
-		So(info.RequestID(c), ShouldEqual, "test-request-id")
+		So(info.RequestID(c), ShouldNotEqual, "")
 		sa, err := info.ServiceAccount(c)
 		So(err, ShouldBeNil)
 		So(sa, ShouldEqual, "gae_service_account@example.com")
@@ -43,4 +50,113 @@ func TestMustNamespace(t *testing.T) {
 		c = info.MustNamespace(c, "valid_namespace_name")
 		So(info.RequestID(c), ShouldEqual, "override")
 	})
+	Convey("Request IDs", t, func() {
+		c, _ := testclock.UseTime(context.Background(), time.Unix(1500000000, 0))
+		c = UseWithAppID(c, "dev~app-id")
+
+		id := info.RequestID(c)
+		So(id, ShouldStartWith, "59682f0000ff")
+		So(id, ShouldEndWith, hex.EncodeToString([]byte("dev~app-id")))
+
+		Convey("are unique per request", func() {
+			So(info.RequestID(UseWithAppID(context.Background(), "dev~app-id")), ShouldNotEqual, id)
+
+			req := BeginRequest(c)
+			So(info.RequestID(req), ShouldNotEqual, id)
+			So(info.RequestID(BeginRequest(c)), ShouldNotEqual, info.RequestID(req))
+		})
+
+		Convey("are kept by derived contexts", func() {
+			So(info.RequestID(info.MustNamespace(c, "ns")), ShouldEqual, id)
+		})
+	})
+
+	Convey("Testable can describe the app's topology", t, func() {
+		c := UseWithAppID(context.Background(), "dev~app-id")
+
+		// Defaults.
+		So(info.ModuleName(c), ShouldEqual, "default")
+		So(info.InstanceID(c), ShouldEqual, "test-instance-id")
+		So(info.DefaultVersionHostname(c), ShouldEqual, "app-id.example.com")
+		host, err := info.ModuleHostname(c, "", "", "")
+		So(err, ShouldBeNil)
+		So(host, ShouldEqual, "testVersionID.default.app-id.example.com")
+
+		tst := info.GetTestable(c)
+		c = tst.SetModuleName("backend")
+		c = info.GetTestable(c).SetInstanceID("inst-1")
+		c = info.GetTestable(c).SetDefaultVersionHostname("app-id.appspot.com")
+		c = info.GetTestable(c).SetServiceAccount("robot@example.com")
+		c = info.GetTestable(c).SetModules(
+			info.Module{Name: "default", Versions: []string{"v1", "v2"}},
+			info.Module{Name: "backend", Versions: []string{"testVersionID"}, Instances: []string{"inst-1", "inst-2"}},
+		)
+
+		So(info.ModuleName(c), ShouldEqual, "backend")
+		So(info.InstanceID(c), ShouldEqual, "inst-1")
+		sa, err := info.ServiceAccount(c)
+		So(err, ShouldBeNil)
+		So(sa, ShouldEqual, "robot@example.com")
+
+		host, err = info.ModuleHostname(c, "", "", "inst-2")
+		So(err, ShouldBeNil)
+		So(host, ShouldEqual, "inst-2.testVersionID.backend.app-id.appspot.com")
+
+		host, err = info.ModuleHostname(c, "default", "", "")
+		So(err, ShouldBeNil)
+		So(host, ShouldEqual, "v1.default.app-id.appspot.com")
+
+		_, err = info.ModuleHostname(c, "nope", "", "")
+		So(err, ShouldErrLike, `unknown module "nope"`)
+		_, err = info.ModuleHostname(c, "default", "v3", "")
+		So(err, ShouldErrLike, `unknown version "v3"`)
+		_, err = info.ModuleHostname(c, "", "", "inst-3")
+		So(err, ShouldErrLike, `unknown instance "inst-3"`)
+
+		Convey("and the module service reflects it", func() {
+			mods, err := module.List(c)
+			So(err, ShouldBeNil)
+			So(mods, ShouldResemble, []string{"default", "backend"})
+
+			vers, err := module.Versions(c, "default")
+			So(err, ShouldBeNil)
+			So(vers, ShouldResemble, []string{"v1", "v2"})
+
+			def, err := module.DefaultVersion(c, "default")
+			So(err, ShouldBeNil)
+			So(def, ShouldEqual, "v1")
+
+			n, err := module.NumInstances(c, "backend", "testVersionID")
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+		})
+	})
+	Convey("App identity", t, func() {
+		c, _ := testclock.UseTime(context.Background(), testclock.TestTimeUTC)
+		c = UseWithAppID(c, "dev~app-id")
+
+		Convey("signs bytes verifiably", func() {
+			keyName, sig, err := info.SignBytes(c, []byte("hello"))
+			So(err, ShouldBeNil)
+
+			certs, err := info.PublicCertificates(c)
+			So(err, ShouldBeNil)
+			So(len(certs), ShouldEqual, 1)
+			So(certs[0].KeyName, ShouldEqual, keyName)
+
+			block, _ := pem.Decode(certs[0].Data)
+			So(block, ShouldNotBeNil)
+			cert, err := x509.ParseCertificate(block.Bytes)
+			So(err, ShouldBeNil)
+			So(cert.CheckSignature(x509.SHA256WithRSA, []byte("hello"), sig), ShouldBeNil)
+			So(cert.CheckSignature(x509.SHA256WithRSA, []byte("bye"), sig), ShouldNotBeNil)
+		})
+
+		Convey("returns access tokens", func() {
+			tok, exp, err := info.AccessToken(c, "scope2", "scope1")
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "memory_token:gae_service_account@example.com:scope1 scope2")
+			So(exp, ShouldResemble, testclock.TestTimeUTC.Add(time.Hour))
+		})
+	})
 }
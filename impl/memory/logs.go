@@ -0,0 +1,209 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/logs"
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/data/stringset"
+	"go.chromium.org/luci/common/logging"
+
+	"golang.org/x/net/context"
+)
+
+type logsData struct {
+	sync.Mutex
+
+	// records are the request log records, in the order they were created.
+	records []*logs.Record
+	// byRequest indexes records by request ID.
+	byRequest map[string]*logs.Record
+}
+
+// logsImpl is a contextual pointer to the current logsData.
+type logsImpl struct {
+	data *logsData
+}
+
+var _ logs.RawInterface = (*logsImpl)(nil)
+
+// useLogs adds a logs.RawInterface implementation to context, accessible by
+// logs.Raw(c) or the exported logs methods.
+//
+// Records are added with the Testable's AddRecords, or by logging through
+// a context returned by its CaptureLogs.
+func useLogs(c context.Context) context.Context {
+	data := &logsData{
+		byRequest: map[string]*logs.Record{},
+	}
+	return logs.SetFactory(c, func(ic context.Context) logs.RawInterface {
+		return &logsImpl{data}
+	})
+}
+
+// addAppLog adds an application log line to the record of the request in c.
+func (d *logsData) addAppLog(c context.Context, l logging.Level, msg string) {
+	now := clock.Now(c).UTC()
+	reqID := info.RequestID(c)
+
+	d.Lock()
+	defer d.Unlock()
+
+	rec := d.byRequest[reqID]
+	if rec == nil {
+		rec = &logs.Record{
+			AppID:     info.AppID(c),
+			ModuleID:  info.ModuleName(c),
+			VersionID: strings.SplitN(info.VersionID(c), ".", 2)[0],
+			RequestID: reqID,
+			StartTime: now,
+		}
+		d.addRecordLocked(rec)
+	}
+	rec.EndTime = now
+	rec.Latency = rec.EndTime.Sub(rec.StartTime)
+	rec.AppLogs = append(rec.AppLogs, logs.AppLog{Time: now, Level: l, Message: msg})
+}
+
+func (d *logsData) addRecordLocked(rec *logs.Record) {
+	d.records = append(d.records, rec)
+	if rec.RequestID != "" {
+		d.byRequest[rec.RequestID] = rec
+	}
+}
+
+func (l *logsImpl) Run(q *logs.Query, cb logs.RunCB) error {
+	// Copy the matching records, so that cb may log.
+	l.data.Lock()
+	var recs []*logs.Record
+	for _, rec := range l.data.records {
+		if matchLogQuery(q, rec) {
+			recs = append(recs, copyLogRecord(rec, q.AppLogs))
+		}
+	}
+	l.data.Unlock()
+
+	// Most recently completed first.
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].EndTime.After(recs[j].EndTime)
+	})
+	for _, rec := range recs {
+		if err := cb(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *logsImpl) GetTestable() logs.Testable { return l }
+
+func (l *logsImpl) CaptureLogs(c context.Context) context.Context {
+	base := logging.GetFactory(c)
+	return logging.SetFactory(c, func(ic context.Context) logging.Logger {
+		ret := &logsLogger{c: ic, data: l.data}
+		if base != nil {
+			ret.base = base(ic)
+		}
+		return ret
+	})
+}
+
+func (l *logsImpl) AddRecords(recs ...*logs.Record) {
+	l.data.Lock()
+	defer l.data.Unlock()
+	for _, rec := range recs {
+		l.data.addRecordLocked(copyLogRecord(rec, true))
+	}
+}
+
+func matchLogQuery(q *logs.Query, rec *logs.Record) bool {
+	switch {
+	case !q.StartTime.IsZero() && rec.EndTime.Before(q.StartTime):
+		return false
+	case !q.EndTime.IsZero() && !rec.EndTime.Before(q.EndTime):
+		return false
+	case len(q.RequestIDs) > 0 && !stringset.NewFromSlice(q.RequestIDs...).Has(rec.RequestID):
+		return false
+	}
+
+	if len(q.Versions) > 0 {
+		version := rec.VersionID
+		if rec.ModuleID != "" && rec.ModuleID != "default" {
+			version = fmt.Sprintf("%s:%s", rec.ModuleID, version)
+		}
+		if !stringset.NewFromSlice(q.Versions...).Has(version) {
+			return false
+		}
+	}
+
+	if q.ApplyMinLevel {
+		for _, al := range rec.AppLogs {
+			if al.Level >= q.MinLevel {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// copyLogRecord returns a copy of rec, including its application logs only if
+// appLogs is true.
+func copyLogRecord(rec *logs.Record, appLogs bool) *logs.Record {
+	ret := *rec
+	ret.AppLogs = nil
+	if appLogs && len(rec.AppLogs) > 0 {
+		ret.AppLogs = make([]logs.AppLog, len(rec.AppLogs))
+		copy(ret.AppLogs, rec.AppLogs)
+	}
+	return &ret
+}
+
+// logsLogger is a logging.Logger which adds each message to the logsData
+// before passing it on to the base logger, if any.
+type logsLogger struct {
+	c    context.Context
+	base logging.Logger
+	data *logsData
+}
+
+func (l *logsLogger) Debugf(format string, args ...interface{}) {
+	l.LogCall(logging.Debug, 1, format, args)
+}
+
+func (l *logsLogger) Infof(format string, args ...interface{}) {
+	l.LogCall(logging.Info, 1, format, args)
+}
+
+func (l *logsLogger) Warningf(format string, args ...interface{}) {
+	l.LogCall(logging.Warning, 1, format, args)
+}
+
+func (l *logsLogger) Errorf(format string, args ...interface{}) {
+	l.LogCall(logging.Error, 1, format, args)
+}
+
+func (l *logsLogger) LogCall(lvl logging.Level, calldepth int, format string, args []interface{}) {
+	l.data.addAppLog(l.c, lvl, fmt.Sprintf(format, args...))
+	if l.base != nil {
+		l.base.LogCall(lvl, calldepth+1, format, args)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/logs"
+	"go.chromium.org/luci/common/clock/testclock"
+	"go.chromium.org/luci/common/logging"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogs(t *testing.T) {
+	t.Parallel()
+
+	Convey("logs", t, func() {
+		c, tc := testclock.UseTime(context.Background(), testclock.TestTimeUTC)
+		c = Use(c)
+		tl := logs.GetTestable(c)
+
+		run := func(q *logs.Query) []*logs.Record {
+			var ret []*logs.Record
+			So(logs.Run(c, q, func(rec *logs.Record) error {
+				ret = append(ret, rec)
+				return nil
+			}), ShouldBeNil)
+			return ret
+		}
+		ids := func(recs []*logs.Record) []string {
+			ret := make([]string, len(recs))
+			for i, rec := range recs {
+				ret[i] = rec.RequestID
+			}
+			return ret
+		}
+
+		So(run(nil), ShouldBeEmpty)
+
+		Convey("captures logs", func() {
+			lc := tl.CaptureLogs(c)
+			lc = info.GetTestable(lc).SetRequestID("req1")
+			logging.Infof(lc, "hello %s", "world")
+			tc.Add(time.Second)
+			logging.Errorf(lc, "oops")
+
+			recs := run(&logs.Query{AppLogs: true})
+			So(len(recs), ShouldEqual, 1)
+			rec := recs[0]
+			So(rec.RequestID, ShouldEqual, "req1")
+			So(rec.AppID, ShouldEqual, "app")
+			So(rec.VersionID, ShouldEqual, "testVersionID")
+			So(rec.StartTime, ShouldResemble, testclock.TestTimeUTC)
+			So(rec.Latency, ShouldEqual, time.Second)
+			So(rec.AppLogs, ShouldResemble, []logs.AppLog{
+				{Time: testclock.TestTimeUTC, Level: logging.Info, Message: "hello world"},
+				{Time: testclock.TestTimeUTC.Add(time.Second), Level: logging.Error, Message: "oops"},
+			})
+
+			So(run(nil)[0].AppLogs, ShouldBeNil)
+		})
+
+		Convey("filters records", func() {
+			t0 := testclock.TestTimeUTC
+			tl.AddRecords(
+				&logs.Record{RequestID: "a", VersionID: "v1", EndTime: t0,
+					AppLogs: []logs.AppLog{{Level: logging.Debug}}},
+				&logs.Record{RequestID: "b", VersionID: "v2", EndTime: t0.Add(time.Minute),
+					AppLogs: []logs.AppLog{{Level: logging.Warning}}},
+				&logs.Record{RequestID: "c", ModuleID: "backend", VersionID: "v1", EndTime: t0.Add(2 * time.Minute)},
+			)
+
+			So(ids(run(nil)), ShouldResemble, []string{"c", "b", "a"})
+			So(ids(run(&logs.Query{StartTime: t0.Add(time.Minute)})), ShouldResemble, []string{"c", "b"})
+			So(ids(run(&logs.Query{EndTime: t0.Add(time.Minute)})), ShouldResemble, []string{"a"})
+			So(ids(run(&logs.Query{Versions: []string{"v1"}})), ShouldResemble, []string{"a"})
+			So(ids(run(&logs.Query{Versions: []string{"backend:v1", "v2"}})), ShouldResemble, []string{"c", "b"})
+			So(ids(run(&logs.Query{RequestIDs: []string{"a", "c"}})), ShouldResemble, []string{"c", "a"})
+			So(ids(run(&logs.Query{ApplyMinLevel: true, MinLevel: logging.Info})), ShouldResemble, []string{"b"})
+
+			count := 0
+			So(logs.Run(c, nil, func(*logs.Record) error {
+				count++
+				return logs.Stop
+			}), ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+	})
+}
@@ -195,7 +195,7 @@ func (m *memcacheImpl) NewItem(key string) mc.Item {
 	return &mcItem{key: key}
 }
 
-func doCBs(items []mc.Item, cb mc.RawCB, inner func(mc.Item) error) {
+func doCBs(c context.Context, items []mc.Item, cb mc.RawCB, inner func(mc.Item) error) error {
 	// This weird construction is so that we:
 	//   - don't take the lock for the entire multi operation, since it could imply
 	//     false atomicity.
@@ -204,16 +204,20 @@ func doCBs(items []mc.Item, cb mc.RawCB, inner func(mc.Item) error) {
 	//     implementation (like a recursive deadlock)
 	errs := make([]error, len(items))
 	for i, itm := range items {
+		if err := c.Err(); err != nil {
+			return err
+		}
 		errs[i] = inner(itm)
 	}
 	for _, e := range errs {
 		cb(e)
 	}
+	return nil
 }
 
 func (m *memcacheImpl) AddMulti(items []mc.Item, cb mc.RawCB) error {
 	now := clock.Now(m.ctx)
-	doCBs(items, cb, func(itm mc.Item) error {
+	return doCBs(m.ctx, items, cb, func(itm mc.Item) error {
 		m.data.lock.Lock()
 		defer m.data.lock.Unlock()
 		if !m.data.hasItemLocked(now, itm.Key()) {
@@ -222,12 +226,11 @@ func (m *memcacheImpl) AddMulti(items []mc.Item, cb mc.RawCB) error {
 		}
 		return mc.ErrNotStored
 	})
-	return nil
 }
 
 func (m *memcacheImpl) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
 	now := clock.Now(m.ctx)
-	doCBs(items, cb, func(itm mc.Item) error {
+	return doCBs(m.ctx, items, cb, func(itm mc.Item) error {
 		m.data.lock.Lock()
 		defer m.data.lock.Unlock()
 
@@ -246,18 +249,16 @@ func (m *memcacheImpl) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
 		}
 		return mc.ErrNotStored
 	})
-	return nil
 }
 
 func (m *memcacheImpl) SetMulti(items []mc.Item, cb mc.RawCB) error {
 	now := clock.Now(m.ctx)
-	doCBs(items, cb, func(itm mc.Item) error {
+	return doCBs(m.ctx, items, cb, func(itm mc.Item) error {
 		m.data.lock.Lock()
 		defer m.data.lock.Unlock()
 		m.data.setItemLocked(now, itm)
 		return nil
 	})
-	return nil
 }
 
 func (m *memcacheImpl) GetMulti(keys []string, cb mc.RawItemCB) error {
@@ -267,6 +268,9 @@ func (m *memcacheImpl) GetMulti(keys []string, cb mc.RawItemCB) error {
 	errs := make([]error, len(keys))
 
 	for i, k := range keys {
+		if err := m.ctx.Err(); err != nil {
+			return err
+		}
 		itms[i], errs[i] = func() (mc.Item, error) {
 			m.data.lock.Lock()
 			defer m.data.lock.Unlock()
@@ -291,6 +295,9 @@ func (m *memcacheImpl) DeleteMulti(keys []string, cb mc.RawCB) error {
 	errs := make([]error, len(keys))
 
 	for i, k := range keys {
+		if err := m.ctx.Err(); err != nil {
+			return err
+		}
 		errs[i] = func() error {
 			m.data.lock.Lock()
 			defer m.data.lock.Unlock()
@@ -353,6 +360,26 @@ func (m *memcacheImpl) Increment(key string, delta int64, initialValue *uint64)
 	return cur, nil
 }
 
+func (m *memcacheImpl) Touch(key string, expiration time.Duration) error {
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	now := clock.Now(m.ctx)
+
+	m.data.lock.Lock()
+	defer m.data.lock.Unlock()
+
+	item, err := m.data.retrieveLocked(now, key)
+	if err != nil {
+		return err
+	}
+	item.expiration = time.Time{}
+	if expiration != 0 {
+		item.expiration = now.Add(expiration).Truncate(time.Second)
+	}
+	return nil
+}
+
 func (m *memcacheImpl) Stats() (*mc.Statistics, error) {
 	m.data.lock.Lock()
 	defer m.data.lock.Unlock()
@@ -15,12 +15,17 @@
 package memory
 
 import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
 	"go.chromium.org/gae/service/info"
 	mc "go.chromium.org/gae/service/memcache"
+	"go.chromium.org/gae/service/tuning"
 
+	"go.chromium.org/luci/common/clock"
 	"go.chromium.org/luci/common/clock/testclock"
 	. "go.chromium.org/luci/common/testing/assertions"
 
@@ -253,5 +258,280 @@ func TestMemcache(t *testing.T) {
 				So(got.Value(), ShouldResemble, []byte("heya"))
 			})
 		})
+
+		Convey("Touch", func() {
+			So(mc.Set(c, mc.NewItem(c, "t").SetValue([]byte("v")).SetExpiration(2*time.Second)), ShouldBeNil)
+			So(mc.Touch(c, "nope", time.Second), ShouldEqual, mc.ErrCacheMiss)
+
+			tc.Add(time.Second)
+			So(mc.Touch(c, "t", 5*time.Second), ShouldBeNil)
+			tc.Add(3 * time.Second)
+			itm, err := mc.GetKey(c, "t")
+			So(err, ShouldBeNil)
+			So(itm.Value(), ShouldResemble, []byte("v"))
+
+			Convey("can remove the expiration", func() {
+				So(mc.Touch(c, "t", 0), ShouldBeNil)
+				tc.Add(time.Hour)
+				_, err := mc.GetKey(c, "t")
+				So(err, ShouldBeNil)
+			})
+
+			Convey("can't revive expired items", func() {
+				tc.Add(5 * time.Second)
+				So(mc.Touch(c, "t", time.Hour), ShouldEqual, mc.ErrCacheMiss)
+			})
+		})
+
+		Convey("stores objects", func() {
+			type thing struct {
+				Name  string
+				Count int
+			}
+
+			So(mc.SetObject(c, "obj", &thing{"hi", 3}, 0), ShouldBeNil)
+			got := thing{}
+			So(mc.GetObject(c, "obj", &got), ShouldBeNil)
+			So(got, ShouldResemble, thing{"hi", 3})
+
+			So(mc.AddObject(c, "obj", &thing{"bye", 4}, 0), ShouldEqual, mc.ErrNotStored)
+			So(mc.GetObject(c, "nope", &got), ShouldEqual, mc.ErrCacheMiss)
+
+			Convey("with a codec, prefix and version", func() {
+				oc := &mc.ObjectCache{Codec: mc.JSON, Prefix: "thing:", Version: 2}
+				So(oc.Key("obj"), ShouldEqual, "thing:v2:obj")
+
+				So(oc.SetObject(c, "obj", &thing{"json", 5}, time.Second), ShouldBeNil)
+				itm, err := mc.GetKey(c, "thing:v2:obj")
+				So(err, ShouldBeNil)
+				So(string(itm.Value()), ShouldEqual, `{"Name":"json","Count":5}`)
+
+				So(oc.GetObject(c, "obj", &got), ShouldBeNil)
+				So(got, ShouldResemble, thing{"json", 5})
+
+				oc3 := &mc.ObjectCache{Codec: mc.JSON, Prefix: "thing:", Version: 3}
+				So(oc3.GetObject(c, "obj", &got), ShouldEqual, mc.ErrCacheMiss)
+
+				tc.Add(2 * time.Second)
+				So(oc.GetObject(c, "obj", &got), ShouldEqual, mc.ErrCacheMiss)
+				So(oc.DeleteObject(c, "obj"), ShouldEqual, mc.ErrCacheMiss)
+			})
+
+			Convey("and fails to decode into the wrong type", func() {
+				bad := 0
+				So(mc.GetObject(c, "obj", &bad), ShouldNotBeNil)
+			})
+		})
+
+		Convey("mutates values", func() {
+			appendX := func(cur []byte) ([]byte, error) {
+				return append(cur, 'x'), nil
+			}
+			So(mc.Mutate(c, "m", appendX), ShouldBeNil)
+			So(mc.Mutate(c, "m", appendX), ShouldBeNil)
+			itm, err := mc.GetKey(c, "m")
+			So(err, ShouldBeNil)
+			So(string(itm.Value()), ShouldEqual, "xx")
+
+			Convey("with an expiration", func() {
+				m := &mc.Mutator{Expiration: time.Second}
+				So(m.Mutate(c, "m", appendX), ShouldBeNil)
+				tc.Add(2 * time.Second)
+				_, err := mc.GetKey(c, "m")
+				So(err, ShouldEqual, mc.ErrCacheMiss)
+			})
+
+			Convey("retrying on conflicts", func() {
+				calls := 0
+				err := mc.Mutate(c, "m", func(cur []byte) ([]byte, error) {
+					calls++
+					if calls < 3 {
+						So(mc.Set(c, mc.NewItem(c, "m").SetValue([]byte("other"))), ShouldBeNil)
+					}
+					return append(cur, 'y'), nil
+				})
+				So(err, ShouldBeNil)
+				So(calls, ShouldEqual, 3)
+				itm, err := mc.GetKey(c, "m")
+				So(err, ShouldBeNil)
+				So(string(itm.Value()), ShouldEqual, "othery")
+			})
+
+			Convey("giving up after too many conflicts", func() {
+				m := &mc.Mutator{Attempts: 2}
+				err := m.Mutate(c, "new", func(cur []byte) ([]byte, error) {
+					So(mc.Set(c, mc.NewItem(c, "new").SetValue([]byte("other"))), ShouldBeNil)
+					return []byte("mine"), nil
+				})
+				So(err, ShouldResemble, &mc.ErrMutateConflict{Key: "new", Attempts: 2})
+				So(err, ShouldErrLike, "in 2 attempt(s)")
+			})
+
+			Convey("with tuned attempts", func() {
+				c := tuning.Set(c, &tuning.Options{Memcache: tuning.Memcache{MutateAttempts: 4}})
+				err := mc.Mutate(c, "new", func(cur []byte) ([]byte, error) {
+					So(mc.Set(c, mc.NewItem(c, "new").SetValue([]byte("other"))), ShouldBeNil)
+					return []byte("mine"), nil
+				})
+				So(err, ShouldResemble, &mc.ErrMutateConflict{Key: "new", Attempts: 4})
+			})
+
+			Convey("stopping on callback errors", func() {
+				err := mc.Mutate(c, "m", func([]byte) ([]byte, error) {
+					return nil, errors.New("nope")
+				})
+				So(err, ShouldErrLike, "nope")
+				itm, err := mc.GetKey(c, "m")
+				So(err, ShouldBeNil)
+				So(string(itm.Value()), ShouldEqual, "xx")
+			})
+		})
+
+		Convey("applies tuned deadlines to each call", func() {
+			var deadlines []time.Time
+			built := 0
+			c := mc.AddRawFilters(c, func(ic context.Context, raw mc.RawInterface) mc.RawInterface {
+				built++
+				if d, ok := ic.Deadline(); ok {
+					deadlines = append(deadlines, d)
+				}
+				return raw
+			})
+			c = tuning.Set(c, &tuning.Options{Memcache: tuning.Memcache{Deadline: time.Minute}})
+
+			start := time.Now()
+			So(mc.Set(c, mc.NewItem(c, "k")), ShouldBeNil)
+			_, err := mc.GetKey(c, "k")
+			So(err, ShouldBeNil)
+
+			// NewItem (called by NewItem and GetKey) has no deadline. The filters
+			// are applied once per RawInterface call.
+			So(deadlines, ShouldHaveLength, 2)
+			So(built, ShouldEqual, 4)
+			for _, d := range deadlines {
+				So(d, ShouldHappenOnOrBetween, start.Add(time.Minute), time.Now().Add(time.Minute))
+			}
+		})
+
+		Convey("GetOrSet", func() {
+			tc.SetTimerCallback(func(d time.Duration, _ clock.Timer) { tc.Add(d) })
+
+			calls := 0
+			compute := func() ([]byte, error) {
+				calls++
+				return []byte(fmt.Sprintf("value %d", calls)), nil
+			}
+
+			val, err := mc.GetOrSet(c, "k", time.Minute, compute)
+			So(err, ShouldBeNil)
+			So(string(val), ShouldEqual, "value 1")
+
+			val, err = mc.GetOrSet(c, "k", time.Minute, compute)
+			So(err, ShouldBeNil)
+			So(string(val), ShouldEqual, "value 1")
+			So(calls, ShouldEqual, 1)
+
+			_, err = mc.GetKey(c, "gae:lock:k")
+			So(err, ShouldEqual, mc.ErrCacheMiss)
+
+			Convey("doesn't cache errors", func() {
+				_, err := mc.GetOrSet(c, "bad", 0, func() ([]byte, error) {
+					return nil, errors.New("boom")
+				})
+				So(err, ShouldErrLike, "boom")
+				_, err = mc.GetKey(c, "bad")
+				So(err, ShouldEqual, mc.ErrCacheMiss)
+				_, err = mc.GetKey(c, "gae:lock:bad")
+				So(err, ShouldEqual, mc.ErrCacheMiss)
+			})
+
+			Convey("while someone else holds the lock", func() {
+				l := &mc.Loader{Expiration: time.Minute, StaleExpiration: time.Hour}
+				val, err := l.GetOrSet(c, "s", compute)
+				So(err, ShouldBeNil)
+				So(string(val), ShouldEqual, "value 2")
+
+				tc.Add(2 * time.Minute)
+				So(mc.Add(c, mc.NewItem(c, "gae:lock:s").SetExpiration(time.Minute)), ShouldBeNil)
+
+				Convey("serves the stale value", func() {
+					val, err := l.GetOrSet(c, "s", compute)
+					So(err, ShouldBeNil)
+					So(string(val), ShouldEqual, "value 2")
+					So(calls, ShouldEqual, 2)
+				})
+
+				Convey("waits, then computes the value itself", func() {
+					start := clock.Now(c)
+					val, err := mc.GetOrSet(c, "s", time.Minute, compute)
+					So(err, ShouldBeNil)
+					So(string(val), ShouldEqual, "value 3")
+					So(clock.Now(c).Sub(start), ShouldBeGreaterThanOrEqualTo, mc.DefaultLoaderWait)
+				})
+			})
+		})
+
+		Convey("stores large values in shards", func() {
+			sc := &mc.ShardedCache{ShardSize: 10}
+
+			So(sc.SetLarge(c, "small", []byte("tiny"), 0), ShouldBeNil)
+			val, err := sc.GetLarge(c, "small")
+			So(err, ShouldBeNil)
+			So(string(val), ShouldEqual, "tiny")
+
+			big := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+			So(sc.SetLarge(c, "big", big, time.Minute), ShouldBeNil)
+			val, err = sc.GetLarge(c, "big")
+			So(err, ShouldBeNil)
+			So(val, ShouldResemble, big)
+
+			stats, err := mc.Stats(c)
+			So(err, ShouldBeNil)
+			So(stats.Items, ShouldEqual, 6) // small, big and 4 shards
+
+			_, err = sc.GetLarge(c, "nope")
+			So(err, ShouldEqual, mc.ErrCacheMiss)
+
+			Convey("which notices missing shards", func() {
+				So(mc.Flush(c), ShouldBeNil)
+				So(sc.SetLarge(c, "big", big, 0), ShouldBeNil)
+				hash := sha256.Sum256(big)
+				shard2 := fmt.Sprintf("gae:shard:big:%x:2", hash[:8])
+				So(mc.Delete(c, shard2), ShouldBeNil)
+				_, err := sc.GetLarge(c, "big")
+				So(err, ShouldEqual, mc.ErrCacheMiss)
+
+				Convey("and corrupt shards", func() {
+					So(mc.Set(c, mc.NewItem(c, shard2).SetValue([]byte("garbage"))), ShouldBeNil)
+					_, err := sc.GetLarge(c, "big")
+					So(err, ShouldEqual, mc.ErrCorruptShards)
+				})
+			})
+
+			Convey("which can be deleted", func() {
+				So(sc.DeleteLarge(c, "big"), ShouldBeNil)
+				So(sc.DeleteLarge(c, "small"), ShouldBeNil)
+				stats, err := mc.Stats(c)
+				So(err, ShouldBeNil)
+				So(stats.Items, ShouldEqual, 0)
+
+				So(sc.DeleteLarge(c, "big"), ShouldEqual, mc.ErrCacheMiss)
+			})
+		})
+
+		Convey("Operations on a cancelled context fail", func() {
+			So(mc.Set(c, mc.NewItem(c, "foo").SetValue([]byte("heya"))), ShouldBeNil)
+
+			cc, cancel := context.WithCancel(c)
+			cancel()
+
+			So(mc.Set(cc, mc.NewItem(cc, "bar")), ShouldEqual, context.Canceled)
+			_, err := mc.GetKey(cc, "foo")
+			So(err, ShouldEqual, context.Canceled)
+			So(mc.Delete(cc, "foo"), ShouldEqual, context.Canceled)
+
+			_, err = mc.GetKey(c, "foo")
+			So(err, ShouldBeNil)
+		})
 	})
 }
@@ -15,6 +15,10 @@
 package memory
 
 import (
+	"fmt"
+	"sync"
+
+	"go.chromium.org/gae/service/info"
 	"go.chromium.org/gae/service/module"
 	"golang.org/x/net/context"
 )
@@ -27,43 +31,142 @@ type moduleVersion struct {
 	module, version string
 }
 
-type modImpl struct {
+type modData struct {
+	sync.Mutex
+
 	numInstances map[moduleVersion]int
+
+	// calls are the lifecycle calls recorded for the Testable.
+	calls []module.Call
+	// errors are the errors set with the Testable, by method name.
+	errors map[string]error
+}
+
+// record records a lifecycle call, and returns the error set for its method,
+// if any.
+func (d *modData) record(call module.Call) error {
+	d.Lock()
+	defer d.Unlock()
+	d.calls = append(d.calls, call)
+	return d.errors[call.Method]
+}
+
+type modImpl struct {
+	c    context.Context
+	data *modData
+
+	// gid is used to look up modules defined with info's Testable.SetModules.
+	gid *globalInfoData
 }
 
 // useMod adds a Module interface to the context
 func useMod(c context.Context) context.Context {
-	modMap := map[moduleVersion]int{}
+	data := &modData{
+		numInstances: map[moduleVersion]int{},
+		errors:       map[string]error{},
+	}
 	return module.SetFactory(c, func(ic context.Context) module.RawInterface {
-		return &modImpl{modMap}
+		return &modImpl{ic, data, curGID(ic)}
 	})
 }
 
 var _ = module.RawInterface((*modImpl)(nil))
 
 func (mod *modImpl) List() ([]string, error) {
+	if mod.gid.modules != nil {
+		ret := make([]string, len(mod.gid.modules))
+		for i, m := range mod.gid.modules {
+			ret[i] = m.Name
+		}
+		return ret, nil
+	}
 	return []string{"testModule1", "testModule2"}, nil
 }
 
 func (mod *modImpl) NumInstances(module, version string) (int, error) {
-	if ret, ok := mod.numInstances[moduleVersion{module, version}]; ok {
+	mod.data.Lock()
+	ret, ok := mod.data.numInstances[moduleVersion{module, version}]
+	mod.data.Unlock()
+	if ok {
 		return ret, nil
 	}
+	if m := mod.gid.module(module); m != nil && len(m.Instances) > 0 {
+		return len(m.Instances), nil
+	}
 	return 1, nil
 }
 
-func (mod *modImpl) SetNumInstances(module, version string, instances int) error {
-	mod.numInstances[moduleVersion{module, version}] = instances
+func (mod *modImpl) SetNumInstances(modName, version string, instances int) error {
+	if err := mod.data.record(module.Call{
+		Method:    "SetNumInstances",
+		Module:    modName,
+		Version:   version,
+		Instances: instances,
+	}); err != nil {
+		return err
+	}
+
+	mod.data.Lock()
+	defer mod.data.Unlock()
+	mod.data.numInstances[moduleVersion{modName, version}] = instances
 	return nil
 }
 
 func (mod *modImpl) Versions(module string) ([]string, error) {
+	if mod.gid.modules != nil {
+		m := mod.gid.module(module)
+		if m == nil {
+			return nil, fmt.Errorf("memory: unknown module %q", module)
+		}
+		return append([]string(nil), m.Versions...), nil
+	}
 	return []string{"testVersion1", "testVersion2"}, nil
 }
 
 func (mod *modImpl) DefaultVersion(module string) (string, error) {
+	if mod.gid.modules != nil {
+		m := mod.gid.module(module)
+		if m == nil || len(m.Versions) == 0 {
+			return "", fmt.Errorf("memory: unknown module %q", module)
+		}
+		return m.Versions[0], nil
+	}
 	return "testVersion1", nil
 }
 
-func (mod *modImpl) Start(module, version string) error { return nil }
-func (mod *modImpl) Stop(module, version string) error  { return nil }
+// Hostname returns the same hostnames as info's ModuleHostname.
+func (mod *modImpl) Hostname(modName, version, instance string) (string, error) {
+	return info.ModuleHostname(mod.c, modName, version, instance)
+}
+
+func (mod *modImpl) Start(modName, version string) error {
+	return mod.data.record(module.Call{Method: "Start", Module: modName, Version: version})
+}
+
+func (mod *modImpl) Stop(modName, version string) error {
+	return mod.data.record(module.Call{Method: "Stop", Module: modName, Version: version})
+}
+
+func (mod *modImpl) GetTestable() module.Testable { return mod }
+
+func (mod *modImpl) Calls() []module.Call {
+	mod.data.Lock()
+	defer mod.data.Unlock()
+	return append([]module.Call(nil), mod.data.calls...)
+}
+
+func (mod *modImpl) ResetCalls() {
+	mod.data.Lock()
+	defer mod.data.Unlock()
+	mod.data.calls = nil
+}
+
+func (mod *modImpl) SetError(method string, err error) {
+	mod.data.Lock()
+	defer mod.data.Unlock()
+	if err == nil {
+		delete(mod.data.errors, method)
+	} else {
+		mod.data.errors[method] = err
+	}
+}
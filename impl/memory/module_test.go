@@ -15,12 +15,14 @@
 package memory
 
 import (
+	"errors"
 	"testing"
 
 	"go.chromium.org/gae/service/module"
 	"golang.org/x/net/context"
 
 	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
 )
 
 func TestModule(t *testing.T) {
@@ -40,4 +42,46 @@ func TestModule(t *testing.T) {
 		So(i, ShouldEqual, 1)
 		So(err, ShouldBeNil)
 	})
+	Convey("Hostname", t, func() {
+		c := UseWithAppID(context.Background(), "dev~app-id")
+
+		host, err := module.Hostname(c, "", "", "")
+		So(err, ShouldBeNil)
+		So(host, ShouldEqual, "testVersionID.default.app-id.example.com")
+
+		host, err = module.Hostname(c, "backend", "v2", "0")
+		So(err, ShouldBeNil)
+		So(host, ShouldEqual, "0.v2.backend.app-id.example.com")
+	})
+
+	Convey("Testable records lifecycle calls", t, func() {
+		c := Use(context.Background())
+		tm := module.GetTestable(c)
+
+		So(module.Start(c, "worker", "v1"), ShouldBeNil)
+		So(module.SetNumInstances(c, "worker", "v1", 3), ShouldBeNil)
+		So(module.Stop(c, "worker", "v1"), ShouldBeNil)
+		So(tm.Calls(), ShouldResemble, []module.Call{
+			{Method: "Start", Module: "worker", Version: "v1"},
+			{Method: "SetNumInstances", Module: "worker", Version: "v1", Instances: 3},
+			{Method: "Stop", Module: "worker", Version: "v1"},
+		})
+
+		tm.ResetCalls()
+		So(tm.Calls(), ShouldBeEmpty)
+
+		Convey("and returns configured errors", func() {
+			tm.SetError("SetNumInstances", errors.New("quota exceeded"))
+			So(module.SetNumInstances(c, "worker", "v1", 5), ShouldErrLike, "quota exceeded")
+			So(module.Start(c, "worker", "v1"), ShouldBeNil)
+
+			n, err := module.NumInstances(c, "worker", "v1")
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+			So(len(tm.Calls()), ShouldEqual, 2)
+
+			tm.SetError("SetNumInstances", nil)
+			So(module.SetNumInstances(c, "worker", "v1", 5), ShouldBeNil)
+		})
+	})
 }
@@ -0,0 +1,89 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	rt "go.chromium.org/gae/service/runtime"
+	"go.chromium.org/luci/common/clock"
+
+	"golang.org/x/net/context"
+)
+
+type runtimeData struct {
+	sync.RWMutex
+
+	// started is when the (fake) instance started.
+	started time.Time
+	// stats, if not nil, is the Stats set with the Testable.
+	stats *rt.Stats
+}
+
+// runtimeImpl is a contextual pointer to the current runtimeData.
+type runtimeImpl struct {
+	c    context.Context
+	data *runtimeData
+}
+
+var _ rt.RawInterface = (*runtimeImpl)(nil)
+
+// useRuntime adds a runtime.RawInterface implementation to context, accessible
+// by runtime.Raw(c) or the exported runtime methods.
+//
+// Unless overridden with the Testable, Stats reports the Go heap usage of the
+// process as its RAM usage, no CPU usage, and the time since useRuntime was
+// called as its uptime.
+func useRuntime(c context.Context) context.Context {
+	data := &runtimeData{
+		started: clock.Now(c),
+	}
+	return rt.SetFactory(c, func(ic context.Context) rt.RawInterface {
+		return &runtimeImpl{ic, data}
+	})
+}
+
+func (r *runtimeImpl) Stats() (*rt.Stats, error) {
+	r.data.RLock()
+	defer r.data.RUnlock()
+
+	if r.data.stats != nil {
+		ret := *r.data.stats
+		return &ret, nil
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	heapMB := float64(ms.HeapAlloc) / (1 << 20)
+	return &rt.Stats{
+		RAM:    rt.RAMStats{Current: heapMB, Average1M: heapMB, Average10M: heapMB},
+		Uptime: clock.Now(r.c).Sub(r.data.started),
+	}, nil
+}
+
+func (r *runtimeImpl) GetTestable() rt.Testable { return r }
+
+func (r *runtimeImpl) SetStats(st *rt.Stats) {
+	r.data.Lock()
+	defer r.data.Unlock()
+
+	r.data.stats = nil
+	if st != nil {
+		stats := *st
+		r.data.stats = &stats
+	}
+}
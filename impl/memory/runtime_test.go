@@ -0,0 +1,60 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	rt "go.chromium.org/gae/service/runtime"
+	"go.chromium.org/luci/common/clock/testclock"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRuntime(t *testing.T) {
+	t.Parallel()
+
+	Convey("runtime", t, func() {
+		c, tc := testclock.UseTime(context.Background(), testclock.TestTimeUTC)
+		c = Use(c)
+		tc.Add(time.Minute)
+
+		Convey("reports live stats", func() {
+			st, err := rt.GetStats(c)
+			So(err, ShouldBeNil)
+			So(st.RAM.Current, ShouldBeGreaterThan, 0)
+			So(st.CPU, ShouldResemble, rt.CPUStats{})
+			So(st.Uptime, ShouldEqual, time.Minute)
+		})
+
+		Convey("reports stats set with the Testable", func() {
+			set := &rt.Stats{RAM: rt.RAMStats{Current: 512}, Uptime: time.Hour}
+			rt.GetTestable(c).SetStats(set)
+			set.RAM.Current = 1
+
+			st, err := rt.GetStats(c)
+			So(err, ShouldBeNil)
+			So(st, ShouldResemble, &rt.Stats{RAM: rt.RAMStats{Current: 512}, Uptime: time.Hour})
+
+			rt.GetTestable(c).SetStats(nil)
+			st, err = rt.GetStats(c)
+			So(err, ShouldBeNil)
+			So(st.Uptime, ShouldEqual, time.Minute)
+		})
+	})
+}
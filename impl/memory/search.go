@@ -0,0 +1,374 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/search"
+
+	"golang.org/x/net/context"
+)
+
+// searchDateFormat is the format of dates in search queries. Dates are
+// compared as strings in this format.
+const searchDateFormat = "2006-01-02"
+
+var htmlTagRE = regexp.MustCompile(`<[^>]*>`)
+
+type searchDoc struct {
+	fields []search.Field
+
+	// seq is the order in which the document was put, and is used as its rank.
+	seq int64
+}
+
+type searchData struct {
+	sync.RWMutex
+
+	// indexes maps "namespace\x00index" to the documents in that index, by ID.
+	indexes map[string]map[string]*searchDoc
+	seq     int64
+}
+
+// searchImpl is a contextual pointer to the current searchData.
+type searchImpl struct {
+	data *searchData
+	ns   string
+}
+
+var _ search.RawInterface = (*searchImpl)(nil)
+
+// useSearch adds a search.RawInterface implementation to context, accessible
+// by search.Raw(c) or the exported search methods.
+//
+// It supports the portable query syntax described in the search package
+// documentation.
+func useSearch(c context.Context) context.Context {
+	data := &searchData{
+		indexes: map[string]map[string]*searchDoc{},
+	}
+	return search.SetFactory(c, func(ic context.Context) search.RawInterface {
+		return &searchImpl{data, info.GetNamespace(ic)}
+	})
+}
+
+func (s *searchImpl) indexKey(index string) string {
+	return s.ns + "\x00" + index
+}
+
+func (s *searchImpl) Put(index, id string, doc []search.Field) (string, error) {
+	if index == "" {
+		return "", errors.New("search: index name must not be empty")
+	}
+	for _, f := range doc {
+		if err := checkSearchField(f); err != nil {
+			return "", err
+		}
+	}
+
+	s.data.Lock()
+	defer s.data.Unlock()
+
+	s.data.seq++
+	if id == "" {
+		id = fmt.Sprintf("doc%d", s.data.seq)
+	}
+	docs := s.data.indexes[s.indexKey(index)]
+	if docs == nil {
+		docs = map[string]*searchDoc{}
+		s.data.indexes[s.indexKey(index)] = docs
+	}
+	docs[id] = &searchDoc{copySearchFields(doc), s.data.seq}
+	return id, nil
+}
+
+func (s *searchImpl) Get(index, id string) ([]search.Field, error) {
+	s.data.RLock()
+	defer s.data.RUnlock()
+
+	doc := s.data.indexes[s.indexKey(index)][id]
+	if doc == nil {
+		return nil, search.ErrNoSuchDocument
+	}
+	return copySearchFields(doc.fields), nil
+}
+
+func (s *searchImpl) Delete(index, id string) error {
+	s.data.Lock()
+	defer s.data.Unlock()
+
+	docs := s.data.indexes[s.indexKey(index)]
+	if docs[id] == nil {
+		return search.ErrNoSuchDocument
+	}
+	delete(docs, id)
+	return nil
+}
+
+func (s *searchImpl) Search(index, query string, opts *search.SearchOptions, cb search.SearchCB) error {
+	terms, err := parseSearchQuery(query)
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = &search.SearchOptions{}
+	}
+
+	// Collect the matching documents, so that cb may use the service.
+	type result struct {
+		id  string
+		doc *searchDoc
+	}
+	var results []result
+	s.data.RLock()
+	for id, doc := range s.data.indexes[s.indexKey(index)] {
+		if matchSearchTerms(terms, doc.fields) {
+			results = append(results, result{id, doc})
+		}
+	}
+	s.data.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i].doc, results[j].doc
+		for _, expr := range opts.Sort {
+			cmp := compareSearchValues(firstSearchValue(a.fields, expr.Field), firstSearchValue(b.fields, expr.Field))
+			if cmp == 0 {
+				continue
+			}
+			if expr.Reverse {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return a.seq > b.seq
+	})
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+
+	for _, r := range results {
+		var doc []search.Field
+		if !opts.IDsOnly {
+			doc = copySearchFields(r.doc.fields)
+		}
+		if err := cb(r.id, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchTerm is a single term of a parsed query.
+type searchTerm struct {
+	not   bool
+	field string // empty for bare words
+	op    string // ":" for bare words
+	value string
+}
+
+// parseSearchQuery parses query into its terms, all of which must match.
+func parseSearchQuery(query string) ([]searchTerm, error) {
+	var terms []searchTerm
+	not := false
+	for _, tok := range strings.Fields(query) {
+		if tok == "NOT" {
+			if not {
+				return nil, fmt.Errorf("search: bad query %q: repeated NOT", query)
+			}
+			not = true
+			continue
+		}
+
+		t := searchTerm{not: not, op: ":", value: tok}
+		not = false
+		if i := strings.IndexAny(tok, ":=<>"); i >= 0 {
+			t.field, t.op, t.value = tok[:i], tok[i:i+1], tok[i+1:]
+			if (t.op == "<" || t.op == ">") && strings.HasPrefix(t.value, "=") {
+				t.op, t.value = t.op+"=", t.value[1:]
+			}
+			if t.field == "" || t.value == "" {
+				return nil, fmt.Errorf("search: bad query term %q", tok)
+			}
+		}
+		t.value = strings.ToLower(t.value)
+		terms = append(terms, t)
+	}
+	if not {
+		return nil, fmt.Errorf("search: bad query %q: trailing NOT", query)
+	}
+	return terms, nil
+}
+
+func matchSearchTerms(terms []searchTerm, fields []search.Field) bool {
+	for _, t := range terms {
+		if matchSearchTerm(t, fields) == t.not {
+			return false
+		}
+	}
+	return true
+}
+
+func matchSearchTerm(t searchTerm, fields []search.Field) bool {
+	for _, f := range fields {
+		if t.field != "" && f.Name != t.field {
+			continue
+		}
+
+		switch v := f.Value.(type) {
+		case string:
+			if t.op == ":" && containsSearchToken(v, t.value) {
+				return true
+			}
+		case search.HTML:
+			if t.op == ":" && containsSearchToken(html.UnescapeString(htmlTagRE.ReplaceAllString(string(v), " ")), t.value) {
+				return true
+			}
+		case search.Atom:
+			if t.op == ":" && strings.ToLower(string(v)) == t.value {
+				return true
+			}
+		case float64:
+			if t.field == "" {
+				continue
+			}
+			n, err := strconv.ParseFloat(t.value, 64)
+			if err == nil && compareSearchOp(t.op, compareSearchValues(v, n)) {
+				return true
+			}
+		case time.Time:
+			if t.field != "" && compareSearchOp(t.op, strings.Compare(v.UTC().Format(searchDateFormat), t.value)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func compareSearchOp(op string, cmp int) bool {
+	switch op {
+	case ":", "=":
+		return cmp == 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func containsSearchToken(text, token string) bool {
+	tokens := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, tok := range tokens {
+		if tok == token {
+			return true
+		}
+	}
+	return false
+}
+
+// firstSearchValue returns the value of the first field called name, or nil if
+// there's no such field.
+func firstSearchValue(fields []search.Field, name string) interface{} {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+// compareSearchValues compares two field values. Missing (nil) values, and
+// values of different types, sort after all others.
+func compareSearchValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			switch {
+			case a.Before(b):
+				return -1
+			case a.After(b):
+				return 1
+			}
+			return 0
+		}
+	case nil:
+		if b == nil {
+			return 0
+		}
+		return 1
+	default:
+		if b, ok := searchValueString(b); ok {
+			as, _ := searchValueString(a)
+			return strings.Compare(as, b)
+		}
+	}
+	return -1
+}
+
+func searchValueString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case search.Atom:
+		return string(v), true
+	case search.HTML:
+		return string(v), true
+	}
+	return "", false
+}
+
+func checkSearchField(f search.Field) error {
+	if f.Name == "" {
+		return errors.New("search: field name must not be empty")
+	}
+	switch f.Value.(type) {
+	case string, search.Atom, search.HTML, float64, time.Time:
+		return nil
+	}
+	return fmt.Errorf("search: field %q has unsupported type %T", f.Name, f.Value)
+}
+
+func copySearchFields(fields []search.Field) []search.Field {
+	ret := make([]search.Field, len(fields))
+	copy(ret, fields)
+	return ret
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/search"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	Convey("search", t, func() {
+		c := Use(context.Background())
+
+		date := func(s string) time.Time {
+			t, err := time.Parse("2006-01-02", s)
+			So(err, ShouldBeNil)
+			return t
+		}
+		put := func(id string, fields ...search.Field) {
+			_, err := search.Put(c, "books", id, fields)
+			So(err, ShouldBeNil)
+		}
+		put("moby", search.Field{Name: "Title", Value: "Moby Dick"}, search.Field{Name: "Author", Value: search.Atom("Herman Melville")},
+			search.Field{Name: "Pages", Value: 635.0}, search.Field{Name: "Published", Value: date("1851-10-18")})
+		put("hamlet", search.Field{Name: "Title", Value: "Hamlet"}, search.Field{Name: "Author", Value: search.Atom("William Shakespeare")},
+			search.Field{Name: "Pages", Value: 104.0}, search.Field{Name: "Published", Value: date("1603-01-01")},
+			search.Field{Name: "Blurb", Value: search.HTML("<b>To be</b>, or not to be")})
+		put("whale", search.Field{Name: "Title", Value: "The Whale"}, search.Field{Name: "Author", Value: search.Atom("Herman Melville")},
+			search.Field{Name: "Pages", Value: 635.0}, search.Field{Name: "Published", Value: date("1851-10-18")})
+
+		run := func(query string, opts *search.SearchOptions) []string {
+			ids := []string{}
+			So(search.Search(c, "books", query, opts, func(id string, _ []search.Field) error {
+				ids = append(ids, id)
+				return nil
+			}), ShouldBeNil)
+			return ids
+		}
+
+		Convey("can get and delete documents", func() {
+			doc, err := search.Get(c, "books", "hamlet")
+			So(err, ShouldBeNil)
+			So(doc[0], ShouldResemble, search.Field{Name: "Title", Value: "Hamlet"})
+
+			So(search.Delete(c, "books", "hamlet"), ShouldBeNil)
+			_, err = search.Get(c, "books", "hamlet")
+			So(err, ShouldEqual, search.ErrNoSuchDocument)
+			So(search.Delete(c, "books", "hamlet"), ShouldEqual, search.ErrNoSuchDocument)
+		})
+
+		Convey("generates IDs", func() {
+			id, err := search.Put(c, "books", "", []search.Field{{Name: "Title", Value: "Emma"}})
+			So(err, ShouldBeNil)
+			So(id, ShouldNotEqual, "")
+			So(run("emma", nil), ShouldResemble, []string{id})
+		})
+
+		Convey("rejects bad fields", func() {
+			_, err := search.Put(c, "books", "x", []search.Field{{Name: "Pages", Value: 10}})
+			So(err, ShouldErrLike, "unsupported type int")
+			_, err = search.Put(c, "books", "x", []search.Field{{Name: "", Value: "hi"}})
+			So(err, ShouldErrLike, "name must not be empty")
+		})
+
+		Convey("matches terms", func() {
+			So(run("", nil), ShouldResemble, []string{"whale", "hamlet", "moby"})
+			So(run("dick", nil), ShouldResemble, []string{"moby"})
+			So(run("Title:whale", nil), ShouldResemble, []string{"whale"})
+			So(run("Author:whale", nil), ShouldResemble, []string{})
+			So(run("Blurb:be", nil), ShouldResemble, []string{"hamlet"})
+			So(run("Blurb:b", nil), ShouldResemble, []string{})
+			So(run(`Author:"herman`, nil), ShouldResemble, []string{})
+			So(run("herman", nil), ShouldResemble, []string{})
+			So(run("NOT Title:hamlet", nil), ShouldResemble, []string{"whale", "moby"})
+		})
+
+		Convey("matches ranges", func() {
+			So(run("Pages>200", nil), ShouldResemble, []string{"whale", "moby"})
+			So(run("Pages<=104", nil), ShouldResemble, []string{"hamlet"})
+			So(run("Pages=635 Title:moby", nil), ShouldResemble, []string{"moby"})
+			So(run("Published<1700-01-01", nil), ShouldResemble, []string{"hamlet"})
+			So(run("Published>=1851-10-18", nil), ShouldResemble, []string{"whale", "moby"})
+			So(run("Published=1851-10-18", nil), ShouldResemble, []string{"whale", "moby"})
+		})
+
+		Convey("rejects bad queries", func() {
+			So(search.Search(c, "books", "Pages>", nil, nil), ShouldErrLike, "bad query term")
+			So(search.Search(c, "books", "hamlet NOT", nil, nil), ShouldErrLike, "trailing NOT")
+		})
+
+		Convey("honors options", func() {
+			So(run("", &search.SearchOptions{Limit: 2}), ShouldResemble, []string{"whale", "hamlet"})
+			So(run("", &search.SearchOptions{Sort: []search.SortExpression{{Field: "Pages"}}}),
+				ShouldResemble, []string{"hamlet", "whale", "moby"})
+			So(run("", &search.SearchOptions{Sort: []search.SortExpression{{Field: "Title", Reverse: true}}}),
+				ShouldResemble, []string{"whale", "moby", "hamlet"})
+
+			So(search.Search(c, "books", "", &search.SearchOptions{IDsOnly: true}, func(_ string, doc []search.Field) error {
+				So(doc, ShouldBeNil)
+				return search.Stop
+			}), ShouldBeNil)
+		})
+
+		Convey("is namespaced", func() {
+			nc := info.MustNamespace(c, "other")
+			_, err := search.Get(nc, "books", "hamlet")
+			So(err, ShouldEqual, search.ErrNoSuchDocument)
+		})
+	})
+}
@@ -0,0 +1,150 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.chromium.org/gae/service/socket"
+
+	"golang.org/x/net/context"
+)
+
+type socketData struct {
+	sync.RWMutex
+
+	// peers maps "network/addr" to the peer at that address.
+	peers map[string]socket.PeerFunc
+	// hosts maps hostnames to their IP addresses.
+	hosts map[string][]net.IP
+}
+
+// socketImpl is a contextual pointer to the current socketData.
+type socketImpl struct {
+	c    context.Context
+	data *socketData
+}
+
+var _ socket.RawInterface = (*socketImpl)(nil)
+
+// useSocket adds a socket.RawInterface implementation to context, accessible
+// by socket.Raw(c) or the exported socket methods.
+//
+// Connections are made to fake peers added with the Testable, over in-memory
+// pipes. No real network connections are made.
+func useSocket(c context.Context) context.Context {
+	data := &socketData{
+		peers: map[string]socket.PeerFunc{},
+		hosts: map[string][]net.IP{},
+	}
+	return socket.SetFactory(c, func(ic context.Context) socket.RawInterface {
+		return &socketImpl{ic, data}
+	})
+}
+
+// socketNetwork returns the base network ("tcp" or "udp") of network.
+func socketNetwork(network string) (string, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return "tcp", nil
+	case "udp", "udp4", "udp6":
+		return "udp", nil
+	}
+	return "", fmt.Errorf("socket: unsupported network %q", network)
+}
+
+func (s *socketImpl) Dial(network, addr string, timeout time.Duration) (net.Conn, error) {
+	if err := s.c.Err(); err != nil {
+		return nil, err
+	}
+	base, err := socketNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.data.RLock()
+	peer := s.data.peers[base+"/"+addr]
+	if peer == nil {
+		for _, ip := range s.data.hosts[host] {
+			if peer = s.data.peers[base+"/"+net.JoinHostPort(ip.String(), port)]; peer != nil {
+				break
+			}
+		}
+	}
+	s.data.RUnlock()
+
+	if peer == nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("connection refused")}
+	}
+	client, server := net.Pipe()
+	go peer(server)
+	return client, nil
+}
+
+func (s *socketImpl) LookupIP(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	s.data.RLock()
+	defer s.data.RUnlock()
+	ips := s.data.hosts[host]
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+	return append([]net.IP(nil), ips...), nil
+}
+
+func (s *socketImpl) GetTestable() socket.Testable { return s }
+
+func (s *socketImpl) AddPeer(network, addr string, cb socket.PeerFunc) {
+	base, err := socketNetwork(network)
+	if err != nil {
+		panic(err)
+	}
+
+	s.data.Lock()
+	defer s.data.Unlock()
+	s.data.peers[base+"/"+addr] = cb
+}
+
+func (s *socketImpl) RemovePeer(network, addr string) {
+	base, err := socketNetwork(network)
+	if err != nil {
+		panic(err)
+	}
+
+	s.data.Lock()
+	defer s.data.Unlock()
+	delete(s.data.peers, base+"/"+addr)
+}
+
+func (s *socketImpl) SetHost(host string, ips ...net.IP) {
+	s.data.Lock()
+	defer s.data.Unlock()
+	if len(ips) == 0 {
+		delete(s.data.hosts, host)
+		return
+	}
+	s.data.hosts[host] = append([]net.IP(nil), ips...)
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"go.chromium.org/gae/service/socket"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestSocket(t *testing.T) {
+	t.Parallel()
+
+	Convey("socket", t, func() {
+		c := Use(context.Background())
+		ts := socket.GetTestable(c)
+
+		// echo replies to each line it receives with the same line.
+		echo := func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if _, err := conn.Write([]byte(line)); err != nil {
+					return
+				}
+			}
+		}
+		roundTrip := func(conn net.Conn, line string) string {
+			_, err := conn.Write([]byte(line + "\n"))
+			So(err, ShouldBeNil)
+			reply, err := bufio.NewReader(conn).ReadString('\n')
+			So(err, ShouldBeNil)
+			return reply[:len(reply)-1]
+		}
+
+		Convey("connects to scripted peers", func() {
+			ts.AddPeer("tcp", "10.0.0.1:7", echo)
+
+			conn, err := socket.Dial(c, "tcp", "10.0.0.1:7")
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			So(roundTrip(conn, "hello"), ShouldEqual, "hello")
+
+			_, err = socket.Dial(c, "tcp", "10.0.0.1:8")
+			So(err, ShouldErrLike, "connection refused")
+			_, err = socket.Dial(c, "udp", "10.0.0.1:7")
+			So(err, ShouldErrLike, "connection refused")
+			_, err = socket.Dial(c, "unix", "/tmp/sock")
+			So(err, ShouldErrLike, "unsupported network")
+
+			ts.RemovePeer("tcp4", "10.0.0.1:7")
+			_, err = socket.Dial(c, "tcp", "10.0.0.1:7")
+			So(err, ShouldErrLike, "connection refused")
+		})
+
+		Convey("resolves hosts", func() {
+			_, err := socket.LookupIP(c, "example.com")
+			So(err, ShouldErrLike, "no such host")
+
+			ip := net.ParseIP("10.0.0.2")
+			ts.SetHost("example.com", ip)
+			ips, err := socket.LookupIP(c, "example.com")
+			So(err, ShouldBeNil)
+			So(ips, ShouldResemble, []net.IP{ip})
+
+			ips, err = socket.LookupIP(c, "10.0.0.3")
+			So(err, ShouldBeNil)
+			So(ips[0].String(), ShouldEqual, "10.0.0.3")
+
+			ts.AddPeer("tcp", "10.0.0.2:80", echo)
+			conn, err := socket.Dial(c, "tcp", "example.com:80")
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			So(roundTrip(conn, "hi"), ShouldEqual, "hi")
+
+			ts.SetHost("example.com")
+			_, err = socket.Dial(c, "tcp", "example.com:80")
+			So(err, ShouldErrLike, "connection refused")
+		})
+	})
+}
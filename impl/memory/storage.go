@@ -0,0 +1,271 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/storage"
+	"go.chromium.org/luci/common/clock"
+
+	"golang.org/x/net/context"
+)
+
+type storedObject struct {
+	attrs   storage.ObjectAttrs
+	content []byte
+}
+
+type storageData struct {
+	sync.RWMutex
+	defaultBucket string
+	buckets       map[string]map[string]*storedObject
+}
+
+// storageImpl is a contextual pointer to the current storageData.
+type storageImpl struct {
+	c    context.Context
+	data *storageData
+}
+
+var _ storage.RawInterface = (*storageImpl)(nil)
+
+// useStorage adds a storage.RawInterface implementation to context, accessible
+// by storage.Raw(c) or the exported storage methods.
+//
+// The app's default bucket, "<appid>.appspot.com", exists from the start.
+func useStorage(c context.Context) context.Context {
+	data := &storageData{
+		buckets: map[string]map[string]*storedObject{},
+	}
+	data.setDefaultBucket(fmt.Sprintf("%s.appspot.com", info.AppID(c)))
+
+	return storage.SetFactory(c, func(ic context.Context) storage.RawInterface {
+		return &storageImpl{ic, data}
+	})
+}
+
+func (d *storageData) setDefaultBucket(name string) {
+	d.defaultBucket = name
+	if d.buckets[name] == nil {
+		d.buckets[name] = map[string]*storedObject{}
+	}
+}
+
+// getObject returns the named object. d must be at least read-locked.
+func (d *storageData) getObject(bucket, name string) (*storedObject, error) {
+	objs := d.buckets[bucket]
+	if objs == nil {
+		return nil, storage.ErrBucketNotExist
+	}
+	obj := objs[name]
+	if obj == nil {
+		return nil, storage.ErrObjectNotExist
+	}
+	return obj, nil
+}
+
+func (s *storageImpl) NewReader(bucket, name string) (io.ReadCloser, error) {
+	s.data.RLock()
+	defer s.data.RUnlock()
+
+	obj, err := s.data.getObject(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(obj.content)), nil
+}
+
+func (s *storageImpl) NewWriter(bucket, name string, opts *storage.WriterOptions) (io.WriteCloser, error) {
+	if name == "" {
+		return nil, errors.New("storage: object name must not be empty")
+	}
+
+	s.data.RLock()
+	defer s.data.RUnlock()
+	if s.data.buckets[bucket] == nil {
+		return nil, storage.ErrBucketNotExist
+	}
+
+	w := &storageWriter{s: s, bucket: bucket, name: name}
+	if opts != nil {
+		w.opts = *opts
+	}
+	return w, nil
+}
+
+func (s *storageImpl) Stat(bucket, name string) (*storage.ObjectAttrs, error) {
+	s.data.RLock()
+	defer s.data.RUnlock()
+
+	obj, err := s.data.getObject(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	return copyObjectAttrs(&obj.attrs), nil
+}
+
+func (s *storageImpl) Delete(bucket, name string) error {
+	s.data.Lock()
+	defer s.data.Unlock()
+
+	if _, err := s.data.getObject(bucket, name); err != nil {
+		return err
+	}
+	delete(s.data.buckets[bucket], name)
+	return nil
+}
+
+func (s *storageImpl) List(bucket, prefix string, cb storage.ListCB) error {
+	// Snapshot the matching objects, so that cb may use the service.
+	s.data.RLock()
+	objs := s.data.buckets[bucket]
+	if objs == nil {
+		s.data.RUnlock()
+		return storage.ErrBucketNotExist
+	}
+	attrs := make([]*storage.ObjectAttrs, 0, len(objs))
+	for name, obj := range objs {
+		if strings.HasPrefix(name, prefix) {
+			attrs = append(attrs, copyObjectAttrs(&obj.attrs))
+		}
+	}
+	s.data.RUnlock()
+
+	sort.Sort(objectAttrsByName(attrs))
+	for _, a := range attrs {
+		if err := s.c.Err(); err != nil {
+			return err
+		}
+		if err := cb(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *storageImpl) DefaultBucket() (string, error) {
+	s.data.RLock()
+	defer s.data.RUnlock()
+	return s.data.defaultBucket, nil
+}
+
+func (s *storageImpl) GetTestable() storage.Testable { return s }
+
+func (s *storageImpl) CreateBucket(name string) {
+	s.data.Lock()
+	defer s.data.Unlock()
+	if s.data.buckets[name] == nil {
+		s.data.buckets[name] = map[string]*storedObject{}
+	}
+}
+
+func (s *storageImpl) SetDefaultBucket(name string) {
+	s.data.Lock()
+	defer s.data.Unlock()
+	s.data.setDefaultBucket(name)
+}
+
+// storageWriter buffers an object's content, and stores it on Close.
+type storageWriter struct {
+	s      *storageImpl
+	bucket string
+	name   string
+	opts   storage.WriterOptions
+
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *storageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("storage: write to closed writer")
+	}
+	return w.buf.Write(p)
+}
+
+func (w *storageWriter) Close() error {
+	if w.closed {
+		return errors.New("storage: writer already closed")
+	}
+	w.closed = true
+
+	content := w.buf.Bytes()
+	sum := md5.Sum(content)
+	now := clock.Now(w.s.c).UTC()
+
+	obj := &storedObject{
+		attrs: storage.ObjectAttrs{
+			Bucket:      w.bucket,
+			Name:        w.name,
+			ContentType: w.opts.ContentType,
+			Size:        int64(len(content)),
+			MD5:         sum[:],
+			Created:     now,
+			Updated:     now,
+			Metadata:    copyMetadata(w.opts.Metadata),
+		},
+		content: content,
+	}
+	if obj.attrs.ContentType == "" {
+		obj.attrs.ContentType = http.DetectContentType(content)
+	}
+
+	w.s.data.Lock()
+	defer w.s.data.Unlock()
+	objs := w.s.data.buckets[w.bucket]
+	if objs == nil {
+		return storage.ErrBucketNotExist
+	}
+	if prev := objs[w.name]; prev != nil {
+		obj.attrs.Created = prev.attrs.Created
+	}
+	objs[w.name] = obj
+	return nil
+}
+
+type objectAttrsByName []*storage.ObjectAttrs
+
+func (s objectAttrsByName) Len() int           { return len(s) }
+func (s objectAttrsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s objectAttrsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func copyObjectAttrs(a *storage.ObjectAttrs) *storage.ObjectAttrs {
+	ret := *a
+	ret.MD5 = append([]byte(nil), a.MD5...)
+	ret.Metadata = copyMetadata(a.Metadata)
+	return &ret
+}
+
+func copyMetadata(md map[string]string) map[string]string {
+	if md == nil {
+		return nil
+	}
+	ret := make(map[string]string, len(md))
+	for k, v := range md {
+		ret[k] = v
+	}
+	return ret
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/service/storage"
+	"go.chromium.org/luci/common/clock/testclock"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestStorage(t *testing.T) {
+	t.Parallel()
+
+	Convey("storage", t, func() {
+		c, tc := testclock.UseTime(context.Background(), testclock.TestTimeUTC)
+		c = Use(c)
+
+		bucket, err := storage.DefaultBucket(c)
+		So(err, ShouldBeNil)
+		So(bucket, ShouldEqual, "app.appspot.com")
+
+		write := func(name, content string, opts *storage.WriterOptions) error {
+			w, err := storage.NewWriter(c, bucket, name, opts)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write([]byte(content)); err != nil {
+				return err
+			}
+			return w.Close()
+		}
+		read := func(name string) string {
+			r, err := storage.NewReader(c, bucket, name)
+			So(err, ShouldBeNil)
+			defer r.Close()
+			data, err := ioutil.ReadAll(r)
+			So(err, ShouldBeNil)
+			return string(data)
+		}
+
+		Convey("can write, read and stat objects", func() {
+			So(write("dir/a.txt", "hello", &storage.WriterOptions{
+				ContentType: "text/plain",
+				Metadata:    map[string]string{"k": "v"},
+			}), ShouldBeNil)
+
+			So(read("dir/a.txt"), ShouldEqual, "hello")
+
+			attrs, err := storage.Stat(c, bucket, "dir/a.txt")
+			So(err, ShouldBeNil)
+			So(attrs.Bucket, ShouldEqual, bucket)
+			So(attrs.ContentType, ShouldEqual, "text/plain")
+			So(attrs.Size, ShouldEqual, 5)
+			So(attrs.Metadata, ShouldResemble, map[string]string{"k": "v"})
+			So(attrs.Created, ShouldResemble, testclock.TestTimeUTC)
+
+			Convey("and overwrite them", func() {
+				tc.Add(time.Minute)
+				So(write("dir/a.txt", "<html></html>", nil), ShouldBeNil)
+				So(read("dir/a.txt"), ShouldEqual, "<html></html>")
+
+				attrs, err := storage.Stat(c, bucket, "dir/a.txt")
+				So(err, ShouldBeNil)
+				So(attrs.ContentType, ShouldEqual, "text/html; charset=utf-8")
+				So(attrs.Created, ShouldResemble, testclock.TestTimeUTC)
+				So(attrs.Updated, ShouldResemble, testclock.TestTimeUTC.Add(time.Minute))
+			})
+
+			Convey("and delete them", func() {
+				So(storage.Delete(c, bucket, "dir/a.txt"), ShouldBeNil)
+				So(storage.Delete(c, bucket, "dir/a.txt"), ShouldEqual, storage.ErrObjectNotExist)
+				_, err := storage.NewReader(c, bucket, "dir/a.txt")
+				So(err, ShouldEqual, storage.ErrObjectNotExist)
+			})
+		})
+
+		Convey("objects are only visible once closed", func() {
+			w, err := storage.NewWriter(c, bucket, "a", nil)
+			So(err, ShouldBeNil)
+			_, err = storage.Stat(c, bucket, "a")
+			So(err, ShouldEqual, storage.ErrObjectNotExist)
+			So(w.Close(), ShouldBeNil)
+			So(w.Close(), ShouldErrLike, "already closed")
+			_, err = storage.Stat(c, bucket, "a")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("can list objects", func() {
+			for _, name := range []string{"b/2", "a", "b/1", "c"} {
+				So(write(name, name, nil), ShouldBeNil)
+			}
+
+			list := func(prefix string, limit int) (names []string) {
+				So(storage.List(c, bucket, prefix, func(attrs *storage.ObjectAttrs) error {
+					names = append(names, attrs.Name)
+					if len(names) == limit {
+						return storage.Stop
+					}
+					return nil
+				}), ShouldBeNil)
+				return
+			}
+
+			So(list("", 0), ShouldResemble, []string{"a", "b/1", "b/2", "c"})
+			So(list("b/", 0), ShouldResemble, []string{"b/1", "b/2"})
+			So(list("", 2), ShouldResemble, []string{"a", "b/1"})
+		})
+
+		Convey("buckets must exist", func() {
+			_, err := storage.NewWriter(c, "other", "a", nil)
+			So(err, ShouldEqual, storage.ErrBucketNotExist)
+			So(storage.List(c, "other", "", nil), ShouldEqual, storage.ErrBucketNotExist)
+
+			storage.GetTestable(c).CreateBucket("other")
+			_, err = storage.NewWriter(c, "other", "a", nil)
+			So(err, ShouldBeNil)
+
+			storage.GetTestable(c).SetDefaultBucket("new")
+			bucket, err := storage.DefaultBucket(c)
+			So(err, ShouldBeNil)
+			So(bucket, ShouldEqual, "new")
+			So(storage.List(c, "new", "", nil), ShouldBeNil)
+		})
+	})
+}
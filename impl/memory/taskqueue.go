@@ -70,6 +70,9 @@ func (t *taskqueueImpl) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTa
 	}
 
 	for _, task := range tasks {
+		if err := t.ctx.Err(); err != nil {
+			return err
+		}
 		name := task.Name
 		if name == "" {
 			name = q.genTaskName()
@@ -86,6 +89,8 @@ func (t *taskqueueImpl) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTa
 }
 
 func (t *taskqueueImpl) DeleteMulti(tasks []*tq.Task, queueName string, cb tq.RawCB) error {
+	now := clock.Now(t.ctx)
+
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
@@ -95,7 +100,10 @@ func (t *taskqueueImpl) DeleteMulti(tasks []*tq.Task, queueName string, cb tq.Ra
 	}
 
 	for i, task := range tasks {
-		if err := q.deleteTask(task); err != nil {
+		if err := t.ctx.Err(); err != nil {
+			return err
+		}
+		if err := q.deleteTask(now, task); err != nil {
 			cb(i, err)
 		}
 	}
@@ -146,6 +154,8 @@ func (t *taskqueueImpl) Purge(queueName string) error {
 }
 
 func (t *taskqueueImpl) Stats(queueNames []string, cb tq.RawStatsCB) error {
+	now := clock.Now(t.ctx)
+
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
@@ -154,7 +164,7 @@ func (t *taskqueueImpl) Stats(queueNames []string, cb tq.RawStatsCB) error {
 		if err != nil {
 			cb(nil, err)
 		} else {
-			cb(q.getStats(), nil)
+			cb(q.getStats(now), nil)
 		}
 	}
 
@@ -174,6 +184,15 @@ func (t *taskqueueImpl) GetTestable() tq.Testable { return &taskQueueTestable{t.
 
 /////////////////////////////// taskqueueTxnImpl ///////////////////////////////
 
+// maxTxnTasks is the maximum number of tasks which may be added in a single
+// transaction.
+//
+// Transactional tasks are actually implemented 'for real' as Actions which
+// ride on the datastore. The current datastore implementation only allows
+// a maximum of 5 Actions per transaction, and more than that result in a
+// BAD_REQUEST.
+const maxTxnTasks = 5
+
 type taskqueueTxnImpl struct {
 	*txnTaskQueueData
 
@@ -183,16 +202,16 @@ type taskqueueTxnImpl struct {
 
 var _ tq.RawInterface = (*taskqueueTxnImpl)(nil)
 
-func (t *taskqueueTxnImpl) addLocked(task *tq.Task, taskName, queueName string) (*tq.Task, error) {
+func (t *taskqueueTxnImpl) numTasksLocked() int {
 	numTasks := 0
 	for _, vs := range t.anony {
 		numTasks += len(vs)
 	}
-	if numTasks+1 > 5 {
-		// transactional tasks are actually implemented 'for real' as Actions which
-		// ride on the datastore. The current datastore implementation only allows
-		// a maximum of 5 Actions per transaction, and more than that result in a
-		// BAD_REQUEST.
+	return numTasks
+}
+
+func (t *taskqueueTxnImpl) addLocked(task *tq.Task, taskName, queueName string) (*tq.Task, error) {
+	if t.numTasksLocked()+1 > maxTxnTasks {
 		return nil, errBadRequest
 	}
 
@@ -230,7 +249,15 @@ func (t *taskqueueTxnImpl) AddMulti(tasks []*tq.Task, queueName string, cb tq.Ra
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
+	// Like bad tasks, a batch which would exceed the limit is rejected entirely.
+	if t.numTasksLocked()+len(tasks) > maxTxnTasks {
+		return errBadRequest
+	}
+
 	for i, task := range tasks {
+		if err := t.ctx.Err(); err != nil {
+			return err
+		}
 		cb(t.addLocked(task, names[i], queueName))
 	}
 
@@ -30,6 +30,7 @@ import (
 	prodConstraints "go.chromium.org/gae/impl/prod/constraints"
 	ds "go.chromium.org/gae/service/datastore"
 	tq "go.chromium.org/gae/service/taskqueue"
+	"go.chromium.org/luci/common/data/stringset"
 )
 
 var (
@@ -59,6 +60,9 @@ type sortedQueue struct {
 
 	sorted       taskIndex             // sorted by (ETA, name)
 	sortedPerTag map[string]*taskIndex // tag => tasks sorted by (ETA, name)
+
+	leased   stringset.Set // names of the pull tasks which have been leased
+	executed []time.Time   // deletion times of tasks, for Executed1Minute
 }
 
 func newSortedQueue(name string, isPullQueue bool) *sortedQueue {
@@ -74,6 +78,7 @@ func newSortedQueue(name string, isPullQueue bool) *sortedQueue {
 		tasks:         map[string]*tq.Task{},
 		archived:      map[string]*tq.Task{},
 		sortedPerTag:  map[string]*taskIndex{},
+		leased:        stringset.New(0),
 	}
 }
 
@@ -115,7 +120,7 @@ func (q *sortedQueue) addTask(task *tq.Task) error {
 	return nil
 }
 
-func (q *sortedQueue) deleteTask(task *tq.Task) error {
+func (q *sortedQueue) deleteTask(now time.Time, task *tq.Task) error {
 	if _, ok := q.archived[task.Name]; ok {
 		return errTombstonedTask
 	}
@@ -126,6 +131,8 @@ func (q *sortedQueue) deleteTask(task *tq.Task) error {
 	t := q.tasks[task.Name]
 	q.archived[task.Name] = t
 	delete(q.tasks, task.Name)
+	q.leased.Del(task.Name)
+	q.executed = append(q.executed, now)
 
 	if q.isPullQueue {
 		q.sorted.remove(t)
@@ -187,6 +194,7 @@ func (q *sortedQueue) leaseTasks(now time.Time, maxTasks int, leaseTime time.Dur
 	newETA := now.Add(time.Duration(leaseSec) * time.Second)
 	for _, t := range tasks {
 		t.ETA = newETA
+		q.leased.Add(t.Name)
 		q.sorted.add(t)
 		q.sortedPerTag[t.Tag].add(t)
 	}
@@ -247,9 +255,14 @@ func (q *sortedQueue) purge() {
 	q.archived = map[string]*tq.Task{}
 	q.sorted = taskIndex{}
 	q.sortedPerTag = map[string]*taskIndex{}
+	q.leased = stringset.New(0)
+	q.executed = nil
 }
 
-func (q *sortedQueue) getStats() *tq.Statistics {
+// getStats returns the statistics of the queue. Deleted tasks count as
+// executed, and leased pull tasks whose lease hasn't expired count as in
+// flight.
+func (q *sortedQueue) getStats(now time.Time) *tq.Statistics {
 	s := tq.Statistics{
 		Tasks: len(q.tasks),
 	}
@@ -259,7 +272,19 @@ func (q *sortedQueue) getStats() *tq.Statistics {
 		} else if t.ETA.Before(s.OldestETA) {
 			s.OldestETA = t.ETA
 		}
+		if q.leased.Has(t.Name) && t.ETA.After(now) {
+			s.InFlight++
+		}
+	}
+
+	// Forget executions which are more than a minute old.
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(q.executed) && q.executed[i].Before(cutoff) {
+		i++
 	}
+	q.executed = q.executed[i:]
+	s.Executed1Minute = len(q.executed)
 	return &s
 }
 
@@ -338,6 +363,10 @@ type taskQueueData struct {
 	lock        sync.Mutex
 	queues      map[string]*sortedQueue
 	constraints tq.Constraints
+
+	// committed is all of the tasks which were added by committed transactions,
+	// in commit order.
+	committed tq.AnonymousQueueData
 }
 
 var _ memContextObj = (*taskQueueData)(nil)
@@ -368,6 +397,10 @@ func (t *taskQueueData) beginCommit(c context.Context, txnCtxObj memContextObj)
 					if err != nil {
 						panic(err)
 					}
+					if t.committed == nil {
+						t.committed = tq.AnonymousQueueData{}
+					}
+					t.committed[qn] = append(t.committed[qn], tsk.Duplicate())
 				}
 			}
 			txn.anony = nil
@@ -384,6 +417,13 @@ func (t *taskQueueData) mkTxn(*ds.TransactionOptions) memContextObj {
 
 func (t *taskQueueData) getTransactionTasks(ns string) tq.AnonymousQueueData { return nil }
 
+func (t *taskQueueData) getCommittedTransactionTasks(ns string) tq.AnonymousQueueData {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return filterAnonymousTasks(t.committed, ns)
+}
+
 func (t *taskQueueData) createQueue(queueName string) {
 	t.createQueueInternal(queueName, false)
 }
@@ -441,6 +481,7 @@ func (t *taskQueueData) resetTasks() {
 	for _, q := range t.queues {
 		q.purge()
 	}
+	t.committed = nil
 }
 
 func (t *taskQueueData) getQueueLocked(queueName string) (*sortedQueue, error) {
@@ -507,6 +548,12 @@ func (t *txnTaskQueueData) endTxn() {
 		panic("cannot end transaction twice")
 	}
 	atomic.StoreInt32(&t.closed, 1)
+
+	// If the transaction was committed, its tasks have already been moved to
+	// the parent. Otherwise it was rolled back, and its tasks are discarded.
+	t.lock.Lock()
+	t.anony = nil
+	t.lock.Unlock()
 }
 
 func (t *txnTaskQueueData) resetTasks() {
@@ -523,17 +570,11 @@ func (t *txnTaskQueueData) getTransactionTasks(ns string) tq.AnonymousQueueData
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
-	ret := make(tq.AnonymousQueueData, len(t.anony))
-	for k, vs := range t.anony {
-		ret[k] = make([]*tq.Task, len(vs))
-		for i, v := range vs {
-			if taskNamespace(v) == ns {
-				ret[k][i] = v.Duplicate()
-			}
-		}
-	}
+	return filterAnonymousTasks(t.anony, ns)
+}
 
-	return ret
+func (t *txnTaskQueueData) getCommittedTransactionTasks(ns string) tq.AnonymousQueueData {
+	return t.parent.getCommittedTransactionTasks(ns)
 }
 
 func (t *txnTaskQueueData) getTombstonedTasks(ns string) tq.QueueData {
@@ -561,6 +602,7 @@ type taskQueueTestable struct {
 		getTombstonedTasks(ns string) tq.QueueData
 		getScheduledTasks(ns string) tq.QueueData
 		getTransactionTasks(ns string) tq.AnonymousQueueData
+		getCommittedTransactionTasks(ns string) tq.AnonymousQueueData
 		createQueue(queueName string)
 		createPullQueue(queueName string)
 	}
@@ -573,8 +615,28 @@ func (t *taskQueueTestable) GetTombstonedTasks() tq.QueueData {
 func (t *taskQueueTestable) GetScheduledTasks() tq.QueueData {
 	return t.data.getScheduledTasks(t.ns)
 }
+func (t *taskQueueTestable) FindScheduledTasks(q tq.TaskQuery) []*tq.Task {
+	return q.Find(t.GetScheduledTasks())
+}
 func (t *taskQueueTestable) GetTransactionTasks() tq.AnonymousQueueData {
 	return t.data.getTransactionTasks(t.ns)
 }
+func (t *taskQueueTestable) GetCommittedTransactionTasks() tq.AnonymousQueueData {
+	return t.data.getCommittedTransactionTasks(t.ns)
+}
 func (t *taskQueueTestable) CreateQueue(queueName string)     { t.data.createQueue(queueName) }
 func (t *taskQueueTestable) CreatePullQueue(queueName string) { t.data.createPullQueue(queueName) }
+
+// filterAnonymousTasks returns copies of the tasks in data which belong to
+// namespace ns. Queues with no such tasks are omitted.
+func filterAnonymousTasks(data tq.AnonymousQueueData, ns string) tq.AnonymousQueueData {
+	ret := make(tq.AnonymousQueueData, len(data))
+	for k, vs := range data {
+		for _, v := range vs {
+			if taskNamespace(v) == ns {
+				ret[k] = append(ret[k], v.Duplicate())
+			}
+		}
+	}
+	return ret
+}
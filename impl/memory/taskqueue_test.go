@@ -15,20 +15,28 @@
 package memory
 
 import (
+	"bytes"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	ds "go.chromium.org/gae/service/datastore"
 	"go.chromium.org/gae/service/info"
 	tq "go.chromium.org/gae/service/taskqueue"
+	"go.chromium.org/gae/service/tuning"
 
 	"go.chromium.org/luci/common/clock"
 	"go.chromium.org/luci/common/clock/testclock"
 	"go.chromium.org/luci/common/data/rand/mathrand"
+	"go.chromium.org/luci/common/errors"
 
+	"github.com/golang/protobuf/ptypes/wrappers"
 	"golang.org/x/net/context"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -432,12 +440,106 @@ func TestTaskQueue(t *testing.T) {
 				So(tqt.GetTransactionTasks()["default"], ShouldBeNil)
 			})
 
+			Convey("a batch over the limit is rejected entirely", func() {
+				So(ds.RunInTransaction(c, func(c context.Context) error {
+					So(tq.Add(c, "", &tq.Task{Path: "/a"}, &tq.Task{Path: "/b"}), ShouldBeNil)
+
+					tasks := make([]*tq.Task, 4)
+					for i := range tasks {
+						tasks[i] = &tq.Task{Path: "/c"}
+					}
+					So(tq.Add(c, "", tasks...), ShouldErrLike, "BAD_REQUEST")
+					So(len(tq.GetTestable(c).GetTransactionTasks()["default"]), ShouldEqual, 2)
+					return nil
+				}, nil), ShouldBeNil)
+			})
+
+			Convey("tracks committed and pending tasks separately", func() {
+				So(tqt.GetCommittedTransactionTasks(), ShouldBeEmpty)
+
+				var txnCtx context.Context
+				So(ds.RunInTransaction(c, func(c context.Context) error {
+					txnCtx = c
+					So(tq.Add(c, "", &tq.Task{Path: "/committed"}), ShouldBeNil)
+					So(tq.GetTestable(c).GetCommittedTransactionTasks(), ShouldBeEmpty)
+					return nil
+				}, nil), ShouldBeNil)
+
+				So(ds.RunInTransaction(c, func(c context.Context) error {
+					So(tq.Add(c, "", &tq.Task{Path: "/rolledback"}), ShouldBeNil)
+					return fmt.Errorf("nooooo")
+				}, nil), ShouldErrLike, "nooooo")
+
+				committed := tqt.GetCommittedTransactionTasks()["default"]
+				So(len(committed), ShouldEqual, 1)
+				So(committed[0].Path, ShouldEqual, "/committed")
+				So(tqt.GetScheduledTasks()["default"][committed[0].Name], ShouldResemble, committed[0])
+
+				// A closed transaction has no pending tasks, committed or not.
+				So(tq.GetTestable(txnCtx).GetTransactionTasks(), ShouldBeEmpty)
+
+				Convey("in their own namespace", func() {
+					nsCtx := info.MustNamespace(c, "other")
+					So(tq.GetTestable(nsCtx).GetCommittedTransactionTasks(), ShouldBeEmpty)
+				})
+
+				Convey("until they're reset", func() {
+					tqt.ResetTasks()
+					So(tqt.GetCommittedTransactionTasks(), ShouldBeEmpty)
+				})
+			})
+
 		})
 
 		Convey("Pull queues", func() {
 			tqt.CreatePullQueue("pull")
 			tqt.CreateQueue("push")
 
+			Convey("Stats", func() {
+				for i := 0; i < 3; i++ {
+					So(tq.Add(c, "pull", &tq.Task{Method: "PULL"}), ShouldBeNil)
+				}
+				tasks, err := tq.Lease(c, 2, "pull", time.Minute)
+				So(err, ShouldBeNil)
+				So(len(tasks), ShouldEqual, 2)
+
+				stats, err := tq.Stats(c, "pull")
+				So(err, ShouldBeNil)
+				So(stats[0].Tasks, ShouldEqual, 3)
+				So(stats[0].InFlight, ShouldEqual, 2)
+				So(stats[0].Executed1Minute, ShouldEqual, 0)
+
+				So(tq.Delete(c, "pull", tasks[0]), ShouldBeNil)
+				tc.Add(30 * time.Second)
+				So(tq.Delete(c, "pull", tasks[1]), ShouldBeNil)
+
+				stats, err = tq.Stats(c, "pull")
+				So(err, ShouldBeNil)
+				So(stats[0].Tasks, ShouldEqual, 1)
+				So(stats[0].InFlight, ShouldEqual, 0)
+				So(stats[0].Executed1Minute, ShouldEqual, 2)
+
+				tc.Add(45 * time.Second)
+				stats, err = tq.Stats(c, "pull")
+				So(err, ShouldBeNil)
+				So(stats[0].Executed1Minute, ShouldEqual, 1)
+
+				Convey("expired leases aren't in flight", func() {
+					tasks, err := tq.Lease(c, 1, "pull", time.Minute)
+					So(err, ShouldBeNil)
+					So(len(tasks), ShouldEqual, 1)
+					stats, err := tq.Stats(c, "pull")
+					So(err, ShouldBeNil)
+					So(stats[0].InFlight, ShouldEqual, 1)
+
+					tc.Add(2 * time.Minute)
+					stats, err = tq.Stats(c, "pull")
+					So(err, ShouldBeNil)
+					So(stats[0].InFlight, ShouldEqual, 0)
+					So(stats[0].Executed1Minute, ShouldEqual, 0)
+				})
+			})
+
 			Convey("One task scenarios", func() {
 				Convey("enqueue, lease, delete", func() {
 					// Enqueue.
@@ -976,5 +1078,248 @@ func TestTaskQueue(t *testing.T) {
 				}
 			})
 		})
+
+		Convey("Batching", func() {
+			var lock sync.Mutex
+			sizes := []int{}
+			c := tq.AddRawFilters(c, func(ic context.Context, raw tq.RawInterface) tq.RawInterface {
+				return &addSizeRecorder{raw, func(n int) {
+					lock.Lock()
+					defer lock.Unlock()
+					sizes = append(sizes, n)
+				}}
+			})
+			c = tq.WithBatchOptions(c, tq.BatchOptions{MaxAddSize: 10, Concurrency: 2})
+
+			So(tq.Add(c, "", &tq.Task{Name: "dup"}), ShouldBeNil)
+			sizes = sizes[:0]
+
+			tasks := make([]*tq.Task, 25)
+			for i := range tasks {
+				tasks[i] = &tq.Task{Path: "/batch"}
+			}
+			tasks[17].Name = "dup"
+
+			err := tq.Add(c, "", tasks...)
+			So(err, ShouldHaveSameTypeAs, errors.MultiError{})
+			for i, e := range err.(errors.MultiError) {
+				if i == 17 {
+					So(e, ShouldEqual, tq.ErrTaskAlreadyAdded)
+				} else {
+					So(e, ShouldBeNil)
+					So(tasks[i].Name, ShouldNotEqual, "")
+				}
+			}
+			sort.Ints(sizes)
+			So(sizes, ShouldResemble, []int{5, 10, 10})
+			So(len(tqt.GetScheduledTasks()["default"]), ShouldEqual, 25)
+		})
+
+		Convey("Tuned batching", func() {
+			var lock sync.Mutex
+			sizes := []int{}
+			c := tq.AddRawFilters(c, func(ic context.Context, raw tq.RawInterface) tq.RawInterface {
+				return &addSizeRecorder{raw, func(n int) {
+					lock.Lock()
+					defer lock.Unlock()
+					sizes = append(sizes, n)
+				}}
+			})
+			c = tuning.Set(c, &tuning.Options{TaskQueue: tuning.TaskQueue{MaxAddSize: 10}})
+
+			tasks := make([]*tq.Task, 25)
+			for i := range tasks {
+				tasks[i] = &tq.Task{Path: "/batch"}
+			}
+			So(tq.Add(c, "", tasks...), ShouldBeNil)
+			sort.Ints(sizes)
+			So(sizes, ShouldResemble, []int{5, 10, 10})
+
+			Convey("is overridden by batch options", func() {
+				sizes = sizes[:0]
+				c := tq.WithBatchOptions(c, tq.BatchOptions{MaxAddSize: 20})
+				for i := range tasks {
+					tasks[i] = &tq.Task{Path: "/batch"}
+				}
+				So(tq.Add(c, "", tasks...), ShouldBeNil)
+				sort.Ints(sizes)
+				So(sizes, ShouldResemble, []int{5, 20})
+			})
+		})
+
+		Convey("Deduplication", func() {
+			So(tq.DedupName("some key"), ShouldEqual, tq.DedupName("some key"))
+			So(tq.DedupName("some key"), ShouldNotEqual, tq.DedupName("other key"))
+			So(tq.DedupName(strings.Repeat("long key ", 100)), ShouldHaveLength, 70)
+
+			So(tq.AddDeduped(c, "", "key", &tq.Task{Path: "/dedup"}, nil), ShouldBeNil)
+			So(tq.AddDeduped(c, "", "key", &tq.Task{Path: "/dedup"}, nil), ShouldBeNil)
+			So(tq.AddDeduped(c, "", "other", &tq.Task{Path: "/dedup"}, nil), ShouldBeNil)
+			So(len(tqt.GetScheduledTasks()["default"]), ShouldEqual, 2)
+
+			Convey("with tombstones", func() {
+				ds.GetTestable(c).Consistent(true)
+				opts := &tq.DedupOptions{Tombstones: true, TombstoneExpiration: time.Hour}
+
+				task := &tq.Task{Path: "/dedup"}
+				So(tq.AddDeduped(c, "", "tomb", task, opts), ShouldBeNil)
+
+				// Tombstones outlive the task queue's memory of the task.
+				tqt.ResetTasks()
+				So(tq.AddDeduped(c, "", "tomb", &tq.Task{Path: "/dedup"}, opts), ShouldBeNil)
+				So(len(tqt.GetScheduledTasks()["default"]), ShouldEqual, 0)
+
+				tc.Add(2 * time.Hour)
+				So(tq.AddDeduped(c, "", "tomb", &tq.Task{Path: "/dedup"}, opts), ShouldBeNil)
+				So(tqt.GetScheduledTasks()["default"], ShouldContainKey, task.Name)
+			})
+		})
+
+		Convey("Payload helpers", func() {
+			toRequest := func(t *tq.Task) *http.Request {
+				r := httptest.NewRequest(t.Method, t.Path, bytes.NewReader(t.Payload))
+				r.Header = t.Header
+				return r
+			}
+			scheduled := func() *tq.Task {
+				for _, t := range tqt.GetScheduledTasks()["default"] {
+					return t
+				}
+				return nil
+			}
+
+			Convey("JSON", func() {
+				type payload struct {
+					Name  string
+					Count int
+				}
+				t, err := tq.NewJSONTask("/json", &payload{"hi", 3})
+				So(err, ShouldBeNil)
+				So(t.Header.Get("Content-Type"), ShouldEqual, tq.JSONContentType)
+				So(tq.Add(c, "", t), ShouldBeNil)
+
+				got := payload{}
+				So(tq.DecodeJSONPayload(toRequest(scheduled()), &got), ShouldBeNil)
+				So(got, ShouldResemble, payload{"hi", 3})
+
+				So(tq.DecodeProtoPayload(toRequest(scheduled()), &wrappers.StringValue{}), ShouldErrLike, "expected Content-Type")
+			})
+
+			Convey("proto", func() {
+				t, err := tq.NewProtoTask("/proto", &wrappers.StringValue{Value: "hi"})
+				So(err, ShouldBeNil)
+				So(tq.Add(c, "", t), ShouldBeNil)
+
+				got := &wrappers.StringValue{}
+				So(tq.DecodeProtoPayload(toRequest(scheduled()), got), ShouldBeNil)
+				So(got.Value, ShouldEqual, "hi")
+			})
+
+			Convey("compression", func() {
+				big := strings.Repeat("compressible ", 10000)
+
+				t, err := tq.NewJSONTask("/json", big)
+				So(err, ShouldBeNil)
+				So(t.Header.Get(tq.PayloadEncodingHeader), ShouldEqual, "gzip")
+				So(len(t.Payload), ShouldBeLessThan, tq.MaxPushPayloadSize)
+
+				got := ""
+				So(tq.DecodeJSONPayload(toRequest(t), &got), ShouldBeNil)
+				So(got, ShouldEqual, big)
+
+				Convey("in Add", func() {
+					cc := tq.WithPayloadCompression(c, 100)
+					small := &tq.Task{Path: "/small", Payload: []byte("small")}
+					large := &tq.Task{Path: "/large", Payload: []byte(big[:1000])}
+					pull := &tq.Task{Method: "PULL", Payload: []byte(big[:1000])}
+					tqt.CreatePullQueue("pull")
+					So(tq.Add(cc, "", small, large), ShouldBeNil)
+					So(tq.Add(cc, "pull", pull), ShouldBeNil)
+
+					So(small.Header.Get(tq.PayloadEncodingHeader), ShouldEqual, "")
+					So(pull.Header.Get(tq.PayloadEncodingHeader), ShouldEqual, "")
+					So(large.Header.Get(tq.PayloadEncodingHeader), ShouldEqual, "gzip")
+
+					payload, err := tqt.GetScheduledTasks()["default"][large.Name].DecodedPayload()
+					So(err, ShouldBeNil)
+					So(string(payload), ShouldEqual, big[:1000])
+
+					payload, err = tq.ReadPayload(toRequest(large))
+					So(err, ShouldBeNil)
+					So(string(payload), ShouldEqual, big[:1000])
+				})
+			})
+
+			Convey("size validation", func() {
+				payload := make([]byte, tq.MaxPushPayloadSize)
+				rand.New(rand.NewSource(0)).Read(payload)
+				_, err := tq.NewJSONTask("/json", payload)
+				So(err, ShouldErrLike, "larger than the limit")
+
+				t := &tq.Task{Method: "PULL", Payload: make([]byte, tq.MaxPushPayloadSize+1)}
+				So(t.ValidatePayloadSize(), ShouldBeNil)
+				t.Payload = make([]byte, tq.MaxPullPayloadSize+1)
+				So(t.ValidatePayloadSize(), ShouldErrLike, "larger than the limit")
+			})
+		})
+
+		Convey("FindScheduledTasks", func() {
+			tqt.CreateQueue("other")
+
+			a := &tq.Task{Path: "/a/1", Payload: []byte("apple"), Delay: time.Minute}
+			b := &tq.Task{Path: "/a/2", Header: http.Header{"X-Kind": []string{"fruit"}}}
+			d := &tq.Task{Path: "/b/1", Method: "PUT", Delay: time.Hour}
+			So(tq.Add(c, "", a, b), ShouldBeNil)
+			So(tq.Add(c, "other", d), ShouldBeNil)
+
+			paths := func(q tq.TaskQuery) []string {
+				var ret []string
+				for _, t := range tqt.FindScheduledTasks(q) {
+					ret = append(ret, t.Path)
+				}
+				return ret
+			}
+
+			So(paths(tq.TaskQuery{}), ShouldResemble, []string{"/a/2", "/a/1", "/b/1"})
+			So(paths(tq.TaskQuery{Queue: "other"}), ShouldResemble, []string{"/b/1"})
+			So(paths(tq.TaskQuery{PathPrefix: "/a/"}), ShouldResemble, []string{"/a/2", "/a/1"})
+			So(paths(tq.TaskQuery{Method: "PUT"}), ShouldResemble, []string{"/b/1"})
+			So(paths(tq.TaskQuery{Header: map[string]string{"x-kind": "fruit"}}), ShouldResemble, []string{"/a/2"})
+			So(paths(tq.TaskQuery{ETAFrom: now.Add(time.Second), ETATo: now.Add(time.Hour)}), ShouldResemble, []string{"/a/1"})
+			So(paths(tq.TaskQuery{Payload: func(p []byte) bool {
+				return bytes.HasPrefix(p, []byte("app"))
+			}}), ShouldResemble, []string{"/a/1"})
+			So(paths(tq.TaskQuery{Queue: "nope"}), ShouldBeEmpty)
+
+			Convey("decodes compressed payloads", func() {
+				e := &tq.Task{Path: "/c", Payload: bytes.Repeat([]byte("x"), 100)}
+				e.CompressPayload(10)
+				So(tq.Add(c, "", e), ShouldBeNil)
+				So(paths(tq.TaskQuery{Payload: func(p []byte) bool {
+					return bytes.Equal(p, bytes.Repeat([]byte("x"), 100))
+				}}), ShouldResemble, []string{"/c"})
+			})
+		})
+
+		Convey("Operations on a cancelled context fail", func() {
+			cc, cancel := context.WithCancel(c)
+			cancel()
+
+			So(tq.Add(cc, "", &tq.Task{Path: "/hello/world"}), ShouldErrLike, context.Canceled)
+			So(len(tqt.GetScheduledTasks()["default"]), ShouldEqual, 0)
+		})
 	})
 }
+
+// addSizeRecorder is a taskqueue filter which records the size of every
+// AddMulti batch.
+type addSizeRecorder struct {
+	tq.RawInterface
+
+	record func(int)
+}
+
+func (r *addSizeRecorder) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTaskCB) error {
+	r.record(len(tasks))
+	return r.RawInterface.AddMulti(tasks, queueName, cb)
+}
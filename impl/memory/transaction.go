@@ -28,8 +28,17 @@ type transactionImpl struct {
 	// boolean 0 or 1, use atomic.*Int32 to access.
 	closed int32
 	isXG   bool
+
+	// id is the synthesized transaction ID.
+	id string
+	// commitInfo is set when the transaction is committed.
+	commitInfo *ds.CommitInfo
 }
 
+var _ ds.CommitInfoTransaction = (*transactionImpl)(nil)
+
+func (ti *transactionImpl) CommitInfo() *ds.CommitInfo { return ti.commitInfo }
+
 func (ti *transactionImpl) close() error {
 	if !atomic.CompareAndSwapInt32(&ti.closed, 0, 1) {
 		return errors.New("transaction is already closed")
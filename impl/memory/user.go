@@ -26,16 +26,48 @@ import (
 	"golang.org/x/net/context"
 
 	"go.chromium.org/gae/service/user"
+	"go.chromium.org/luci/common/data/stringset"
 )
 
+type oauthToken struct {
+	user   user.User
+	scopes stringset.Set
+}
+
+func (t *oauthToken) hasAnyScope(scopes []string) bool {
+	for _, s := range scopes {
+		if t.scopes.Has(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// userOverrideKey holds a *userOverride, installed by Testable.WithUser.
+var userOverrideKey = "holds a *userOverride"
+
+// userOverride replaces the logged in user of data in a context.
+type userOverride struct {
+	data *userData
+	user *user.User
+}
+
 type userData struct {
 	sync.RWMutex
 	user *user.User
+
+	// tokens are the fake OAuth tokens added with the Testable.
+	tokens map[string]*oauthToken
+	// token is the OAuth token of the current request, if any.
+	token string
 }
 
 // userImpl is a contextual pointer to the current userData.
 type userImpl struct {
 	data *userData
+
+	// override, if not nil, replaces data.user.
+	override *userOverride
 }
 
 var _ user.RawInterface = (*userImpl)(nil)
@@ -43,29 +75,53 @@ var _ user.RawInterface = (*userImpl)(nil)
 // useUser adds a user.RawInterface implementation to context, accessible
 // by user.Raw(c) or the exported user methods.
 func useUser(c context.Context) context.Context {
-	data := &userData{}
+	data := &userData{
+		tokens: map[string]*oauthToken{},
+	}
 
 	return user.SetFactory(c, func(ic context.Context) user.RawInterface {
-		return &userImpl{data}
+		ret := &userImpl{data: data}
+		if o, ok := ic.Value(&userOverrideKey).(*userOverride); ok && o.data == data {
+			ret.override = o
+		}
+		return ret
 	})
 }
 
+// userLocked returns the logged in user. u.data must be locked.
+func (u *userImpl) userLocked() *user.User {
+	if u.override != nil {
+		return u.override.user
+	}
+	return u.data.user
+}
+
 func (u *userImpl) Current() *user.User {
 	u.data.RLock()
 	defer u.data.RUnlock()
-	if u.data.user != nil && u.data.user.ClientID == "" {
-		ret := *u.data.user
+	if usr := u.userLocked(); usr != nil && usr.ClientID == "" {
+		ret := *usr
 		return &ret
 	}
 	return nil
 }
 
 func (u *userImpl) CurrentOAuth(scopes ...string) (*user.User, error) {
-	// TODO(riannucci): something more clever in the Testable interface here?
 	u.data.RLock()
 	defer u.data.RUnlock()
-	if u.data.user != nil && u.data.user.ClientID != "" {
-		ret := *u.data.user
+	if u.data.token != "" {
+		tok := u.data.tokens[u.data.token]
+		if tok == nil {
+			return nil, user.ErrOAuthInvalidToken
+		}
+		if len(scopes) > 0 && !tok.hasAnyScope(scopes) {
+			return nil, user.ErrOAuthInvalidScope
+		}
+		ret := tok.user
+		return &ret, nil
+	}
+	if usr := u.userLocked(); usr != nil && usr.ClientID != "" {
+		ret := *usr
 		return &ret, nil
 	}
 	return nil, nil
@@ -74,7 +130,8 @@ func (u *userImpl) CurrentOAuth(scopes ...string) (*user.User, error) {
 func (u *userImpl) IsAdmin() bool {
 	u.data.RLock()
 	defer u.data.RUnlock()
-	return u.data.user != nil && u.data.user.Admin
+	usr := u.userLocked()
+	return usr != nil && usr.Admin
 }
 
 func (u *userImpl) LoginURL(dest string) (string, error) {
@@ -102,6 +159,19 @@ func (u *userImpl) SetUser(user *user.User) {
 }
 
 func (u *userImpl) Login(email, clientID string, admin bool) {
+	u.SetUser(mkUser(email, clientID, admin))
+}
+
+func (u *userImpl) LoginFederated(email, identity, provider string, admin bool) {
+	usr := mkUser(email, "", admin)
+	usr.FederatedIdentity = identity
+	usr.FederatedProvider = provider
+	u.SetUser(usr)
+}
+
+// mkUser returns a new User with values derived from email, clientID and admin.
+// It panics if email is not a valid email address.
+func mkUser(email, clientID string, admin bool) *user.User {
 	adr, err := mail.ParseAddress(email)
 	if err != nil {
 		panic(err)
@@ -115,16 +185,77 @@ func (u *userImpl) Login(email, clientID string, admin bool) {
 
 	id := sha256.Sum256([]byte("ID:" + email))
 
-	u.SetUser(&user.User{
+	return &user.User{
 		Email:      email,
 		AuthDomain: parts[1],
 		Admin:      admin,
 
 		ID:       fmt.Sprint(binary.LittleEndian.Uint64(id[:])),
 		ClientID: clientID,
-	})
+	}
 }
 
 func (u *userImpl) Logout() {
 	u.SetUser(nil)
 }
+
+func (u *userImpl) SetAdmin(admin bool) {
+	u.data.Lock()
+	defer u.data.Unlock()
+	if u.data.user != nil {
+		usr := *u.data.user
+		usr.Admin = admin
+		u.data.user = &usr
+	}
+}
+
+func (u *userImpl) CompleteLogin(loginURL, email string, admin bool) (string, error) {
+	dest, err := fakeRedirect(loginURL, "/_ah/login")
+	if err != nil {
+		return "", err
+	}
+	u.Login(email, "", admin)
+	return dest, nil
+}
+
+func (u *userImpl) CompleteLogout(logoutURL string) (string, error) {
+	dest, err := fakeRedirect(logoutURL, "/_ah/logout")
+	if err != nil {
+		return "", err
+	}
+	u.Logout()
+	return dest, nil
+}
+
+func (u *userImpl) WithUser(c context.Context, usr *user.User) context.Context {
+	if usr != nil {
+		cpy := *usr
+		usr = &cpy
+	}
+	return context.WithValue(c, &userOverrideKey, &userOverride{u.data, usr})
+}
+
+// fakeRedirect returns the redirect destination of a fake login or logout URL
+// with the given path.
+func fakeRedirect(rawURL, path string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Path != path {
+		return "", fmt.Errorf("%q is not a %s URL", rawURL, path)
+	}
+	return parsed.Query().Get("redirect"), nil
+}
+
+func (u *userImpl) AddOAuthToken(token string, usr *user.User, scopes ...string) {
+	u.data.Lock()
+	defer u.data.Unlock()
+	u.data.tokens[token] = &oauthToken{*usr, stringset.NewFromSlice(scopes...)}
+}
+
+func (u *userImpl) SetOAuthToken(token string) {
+	u.data.Lock()
+	defer u.data.Unlock()
+	u.data.token = token
+}
@@ -97,6 +97,110 @@ func TestUser(t *testing.T) {
 			})
 		})
 
+		Convey("can authenticate with OAuth tokens", func() {
+			tu := user.GetTestable(c)
+			tu.AddOAuthToken("tok", &user.User{Email: "bot@example.com", ClientID: "client1"}, "scope1", "scope2")
+			tu.Login("hello@world.com", "", false)
+			tu.SetOAuthToken("tok")
+
+			usr, err := user.CurrentOAuth(c)
+			So(err, ShouldBeNil)
+			So(usr, ShouldResemble, &user.User{Email: "bot@example.com", ClientID: "client1"})
+			So(user.Current(c).Email, ShouldEqual, "hello@world.com")
+
+			usr, err = user.CurrentOAuth(c, "scope3", "scope2")
+			So(err, ShouldBeNil)
+			So(usr.Email, ShouldEqual, "bot@example.com")
+
+			_, err = user.CurrentOAuth(c, "scope3")
+			So(err, ShouldEqual, user.ErrOAuthInvalidScope)
+
+			Convey("and validate the client ID", func() {
+				usr, err := user.CurrentOAuthFromClients(c, []string{"client0", "client1"}, "scope1")
+				So(err, ShouldBeNil)
+				So(usr.Email, ShouldEqual, "bot@example.com")
+
+				_, err = user.CurrentOAuthFromClients(c, []string{"client0"}, "scope1")
+				So(err, ShouldEqual, user.ErrOAuthClientNotAllowed)
+			})
+
+			Convey("and reject unknown tokens", func() {
+				tu.SetOAuthToken("bad")
+				_, err := user.CurrentOAuth(c)
+				So(err, ShouldEqual, user.ErrOAuthInvalidToken)
+
+				tu.SetOAuthToken("")
+				usr, err := user.CurrentOAuth(c)
+				So(err, ShouldBeNil)
+				So(usr, ShouldBeNil)
+			})
+		})
+
+		Convey("can login (federated)", func() {
+			tu := user.GetTestable(c)
+			tu.LoginFederated("hello@world.com", "https://id.example.com/hello", "id.example.com", false)
+			So(user.Current(c), ShouldResemble, &user.User{
+				Email:             "hello@world.com",
+				AuthDomain:        "world.com",
+				ID:                "14628837901535854097",
+				FederatedIdentity: "https://id.example.com/hello",
+				FederatedProvider: "id.example.com",
+			})
+
+			Convey("and change the admin flag", func() {
+				tu.SetAdmin(true)
+				So(user.IsAdmin(c), ShouldBeTrue)
+				So(user.Current(c).FederatedProvider, ShouldEqual, "id.example.com")
+
+				tu.SetAdmin(false)
+				So(user.IsAdmin(c), ShouldBeFalse)
+			})
+		})
+
+		Convey("can simulate the login flow", func() {
+			tu := user.GetTestable(c)
+
+			loginURL, err := user.LoginURL(c, "/after/login")
+			So(err, ShouldBeNil)
+			dest, err := tu.CompleteLogin(loginURL, "hello@world.com", true)
+			So(err, ShouldBeNil)
+			So(dest, ShouldEqual, "/after/login")
+			So(user.Current(c).Email, ShouldEqual, "hello@world.com")
+			So(user.IsAdmin(c), ShouldBeTrue)
+
+			_, err = tu.CompleteLogin("https://fakeapp.example.com/nope", "hello@world.com", false)
+			So(err, ShouldErrLike, "is not a /_ah/login URL")
+
+			logoutURL, err := user.LogoutURL(c, "/after/logout")
+			So(err, ShouldBeNil)
+			_, err = tu.CompleteLogout(loginURL)
+			So(err, ShouldErrLike, "is not a /_ah/logout URL")
+			So(user.Current(c), ShouldNotBeNil)
+
+			dest, err = tu.CompleteLogout(logoutURL)
+			So(err, ShouldBeNil)
+			So(dest, ShouldEqual, "/after/logout")
+			So(user.Current(c), ShouldBeNil)
+		})
+
+		Convey("can switch users per context", func() {
+			tu := user.GetTestable(c)
+			tu.Login("hello@world.com", "", false)
+
+			adminC := tu.WithUser(c, &user.User{Email: "admin@world.com", Admin: true})
+			anonC := tu.WithUser(c, nil)
+
+			So(user.Current(adminC).Email, ShouldEqual, "admin@world.com")
+			So(user.IsAdmin(adminC), ShouldBeTrue)
+			So(user.Current(anonC), ShouldBeNil)
+			So(user.IsAdmin(anonC), ShouldBeFalse)
+
+			So(user.Current(c).Email, ShouldEqual, "hello@world.com")
+			tu.Logout()
+			So(user.Current(c), ShouldBeNil)
+			So(user.Current(adminC).Email, ShouldEqual, "admin@world.com")
+		})
+
 		Convey("panics on bad email", func() {
 			So(func() {
 				user.GetTestable(c).Login("bademail", "", false)
@@ -0,0 +1,41 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prod
+
+import (
+	"go.chromium.org/gae/service/capability"
+
+	"golang.org/x/net/context"
+	aeCapability "google.golang.org/appengine/capability"
+)
+
+// useCapability adds a capability service implementation to context,
+// accessible by "go.chromium.org/gae/service/capability".Raw(c) or the
+// exported capability service methods.
+func useCapability(c context.Context) context.Context {
+	return capability.SetFactory(c, func(ci context.Context) capability.RawInterface {
+		return capabilityImpl{getAEContext(ci)}
+	})
+}
+
+type capabilityImpl struct {
+	aeCtx context.Context
+}
+
+func (ci capabilityImpl) Enabled(api, name string) bool {
+	return aeCapability.Enabled(ci.aeCtx, api, name)
+}
+
+func (ci capabilityImpl) GetTestable() capability.Testable { return nil }
@@ -26,6 +26,10 @@ func DS() datastore.Constraints {
 		MaxGetSize:    1000,
 		MaxPutSize:    500,
 		MaxDeleteSize: 500,
+
+		MaxEntityGroupsPerTransaction: 25,
+
+		NoInQueries: true,
 	}
 }
 
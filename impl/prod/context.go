@@ -58,7 +58,7 @@ func setupAECtx(c, aeCtx context.Context) context.Context {
 		ctx:      aeCtx,
 		noTxnCtx: aeCtx,
 	})
-	return useModule(useMail(useUser(useURLFetch(useRDS(useMC(useTQ(useGI(useLogging(c)))))))))
+	return useRuntime(useSocket(useCapability(useLogs(useImage(useSearch(useStorage(useModule(useMail(useUser(useURLFetch(useRDS(useMC(useTQ(useGI(useLogging(c))))))))))))))))
 }
 
 // Use adds production implementations for all the gae services to the
@@ -67,11 +67,18 @@ func setupAECtx(c, aeCtx context.Context) context.Context {
 //
 // The services added are:
 //   - github.com/luci-go/common/logging
+//   - go.chromium.org/gae/service/capability
 //   - go.chromium.org/gae/service/datastore
+//   - go.chromium.org/gae/service/image
 //   - go.chromium.org/gae/service/info
+//   - go.chromium.org/gae/service/logs
 //   - go.chromium.org/gae/service/mail
 //   - go.chromium.org/gae/service/memcache
 //   - go.chromium.org/gae/service/module
+//   - go.chromium.org/gae/service/runtime
+//   - go.chromium.org/gae/service/search
+//   - go.chromium.org/gae/service/socket
+//   - go.chromium.org/gae/service/storage
 //   - go.chromium.org/gae/service/taskqueue
 //   - go.chromium.org/gae/service/urlfetch
 //   - go.chromium.org/gae/service/user
@@ -120,28 +127,9 @@ func UseRemote(inOutCtx *context.Context, host string, client *http.Client) (err
 		aeCtx := getAEContext(*inOutCtx)
 
 		if strings.HasPrefix(host, "localhost") {
-			transp := http.DefaultTransport
-			if aeCtx != nil {
-				transp = urlfetch.Get(*inOutCtx)
-			}
-
-			client = &http.Client{Transport: transp}
-			client.Jar, err = cookiejar.New(nil)
-			if err != nil {
+			if client, err = devServerClient(*inOutCtx, host); err != nil {
 				return
 			}
-			u := fmt.Sprintf("http://%s/_ah/login?%s", host, url.Values{
-				"email":  {"admin@example.com"},
-				"admin":  {"True"},
-				"action": {"Login"},
-			}.Encode())
-
-			var rsp *http.Response
-			rsp, err = client.Get(u)
-			if err != nil {
-				return
-			}
-			defer rsp.Body.Close()
 		} else {
 			if aeCtx == nil {
 				aeCtx = context.Background()
@@ -161,6 +149,33 @@ func UseRemote(inOutCtx *context.Context, host string, client *http.Client) (err
 	return nil
 }
 
+// devServerClient returns an *http.Client which is logged in to the local
+// development server at host as the admin user "admin@example.com".
+func devServerClient(c context.Context, host string) (*http.Client, error) {
+	transp := http.DefaultTransport
+	if getAEContext(c) != nil {
+		transp = urlfetch.Get(c)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: transp, Jar: jar}
+	u := fmt.Sprintf("http://%s/_ah/login?%s", host, url.Values{
+		"email":  {"admin@example.com"},
+		"admin":  {"True"},
+		"action": {"Login"},
+	}.Encode())
+
+	rsp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	rsp.Body.Close()
+	return client, nil
+}
+
 // prodState is the current production state.
 type prodState struct {
 	// ctx is the current derived GAE context.
@@ -176,7 +191,7 @@ type prodState struct {
 }
 
 func getProdState(c context.Context) prodState {
-	if v := c.Value(&prodStateKey).(*prodState); v != nil {
+	if v, _ := c.Value(&prodStateKey).(*prodState); v != nil {
 		return *v
 	}
 	return prodState{}
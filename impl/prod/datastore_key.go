@@ -15,6 +15,8 @@
 package prod
 
 import (
+	"fmt"
+
 	ds "go.chromium.org/gae/service/datastore"
 	"go.chromium.org/luci/common/errors"
 	"golang.org/x/net/context"
@@ -55,6 +57,10 @@ func dsF2R(aeCtx context.Context, k *ds.Key) (*datastore.Key, error) {
 		return nil, nil
 	}
 
+	if db := k.Database(); db != "" {
+		return nil, fmt.Errorf("the App Engine datastore can't be used for database %q", db)
+	}
+
 	// drop aid.
 	_, ns, toks := k.Split()
 	err := error(nil)
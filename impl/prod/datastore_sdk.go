@@ -0,0 +1,125 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prod
+
+import (
+	ds "go.chromium.org/gae/service/datastore"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// These functions convert between the types of this package's datastore
+// service and those of the official SDK ("google.golang.org/appengine/datastore"),
+// so that code written against the SDK can be used alongside code written
+// against this package, e.g. while migrating from one to the other.
+
+// KeyFromSDK converts an SDK Key to a Key.
+func KeyFromSDK(k *datastore.Key) *ds.Key {
+	return dsR2F(k)
+}
+
+// KeyToSDK converts k to an SDK Key. c must have been set up with Use or
+// UseRemote.
+//
+// The SDK always uses the current app ID, so the app ID of k is dropped.
+func KeyToSDK(c context.Context, k *ds.Key) (*datastore.Key, error) {
+	return dsF2R(getAEContext(c), k)
+}
+
+// PropertyMapFromSDK converts SDK Properties, as passed to an SDK
+// PropertyLoadSaver's Load method, to a PropertyMap.
+func PropertyMapFromSDK(props []datastore.Property) (ds.PropertyMap, error) {
+	tf := typeFilter{}
+	if err := tf.Load(props); err != nil {
+		return nil, err
+	}
+	return tf.pm, nil
+}
+
+// PropertyMapToSDK converts pm to SDK Properties, as returned by an SDK
+// PropertyLoadSaver's Save method. Meta properties aren't converted. c must
+// have been set up with Use or UseRemote.
+func PropertyMapToSDK(c context.Context, pm ds.PropertyMap) ([]datastore.Property, error) {
+	tf := typeFilter{getAEContext(c), pm}
+	return tf.Save()
+}
+
+// SDKPLS adapts an SDK PropertyLoadSaver (e.g. of a model written against the
+// SDK) to a PropertyLoadSaver of this package's datastore, so that it can be
+// used directly with Get, Put and friends:
+//
+//	err := ds.Get(c, prod.NewSDKPLS(c, key, &model))
+//
+// The SDK PropertyLoadSaver has no meta properties, so the SDKPLS holds them,
+// including the "$key" of the entity.
+type SDKPLS struct {
+	c    context.Context
+	pls  datastore.PropertyLoadSaver
+	meta ds.PropertyMap
+}
+
+var (
+	_ ds.PropertyLoadSaver = (*SDKPLS)(nil)
+	_ ds.MetaGetterSetter  = (*SDKPLS)(nil)
+)
+
+// NewSDKPLS returns an SDKPLS for pls, with the key key (which may be nil and
+// set later with SetMeta). c must have been set up with Use or UseRemote.
+func NewSDKPLS(c context.Context, key *ds.Key, pls datastore.PropertyLoadSaver) *SDKPLS {
+	ret := &SDKPLS{c: c, pls: pls, meta: ds.PropertyMap{}}
+	if key != nil {
+		ret.meta.SetMeta("key", key)
+	}
+	return ret
+}
+
+// Load implements ds.PropertyLoadSaver.
+func (s *SDKPLS) Load(pm ds.PropertyMap) error {
+	props, err := PropertyMapToSDK(s.c, pm)
+	if err != nil {
+		return err
+	}
+	for name, pdata := range pm.GetAllMeta() {
+		s.meta[name] = pdata
+	}
+	return s.pls.Load(props)
+}
+
+// Save implements ds.PropertyLoadSaver.
+func (s *SDKPLS) Save(withMeta bool) (ds.PropertyMap, error) {
+	props, err := s.pls.Save()
+	if err != nil {
+		return nil, err
+	}
+	pm, err := PropertyMapFromSDK(props)
+	if err != nil {
+		return nil, err
+	}
+	if withMeta {
+		for name, pdata := range s.meta {
+			pm[name] = pdata
+		}
+	}
+	return pm, nil
+}
+
+// GetMeta implements ds.MetaGetter.
+func (s *SDKPLS) GetMeta(key string) (interface{}, bool) { return s.meta.GetMeta(key) }
+
+// GetAllMeta implements ds.MetaGetterSetter.
+func (s *SDKPLS) GetAllMeta() ds.PropertyMap { return s.meta.GetAllMeta() }
+
+// SetMeta implements ds.MetaGetterSetter.
+func (s *SDKPLS) SetMeta(key string, val interface{}) bool { return s.meta.SetMeta(key, val) }
@@ -0,0 +1,91 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prod
+
+import (
+	"os"
+
+	"go.chromium.org/gae/impl/cloud"
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/luci/common/errors"
+
+	"cloud.google.com/go/datastore"
+	"golang.org/x/net/context"
+)
+
+// EmulatorHostEnv is the environment variable which UseEmulator reads the
+// development server's host from when one isn't supplied.
+const EmulatorHostEnv = "GAE_API_EMULATOR_HOST"
+
+// DatastoreEmulatorHostEnv and DatastoreProjectIDEnv are the environment
+// variables of the Cloud Datastore emulator's host and project, as set by
+// "gcloud beta emulators datastore env-init".
+const (
+	DatastoreEmulatorHostEnv = "DATASTORE_EMULATOR_HOST"
+	DatastoreProjectIDEnv    = "DATASTORE_PROJECT_ID"
+)
+
+// UseEmulator is like Use, except that all services are backed by the local
+// development server (dev_appserver.py) at host (e.g. "localhost:8080"), via
+// its Remote API endpoint. This lets integration tests exercise the production
+// implementations without a real project.
+//
+// If host is empty, it is read from the EmulatorHostEnv environment variable,
+// and an error is returned if that isn't set either.
+//
+// If the DatastoreEmulatorHostEnv environment variable is set, the datastore
+// is instead the Cloud Datastore emulator there, used through "impl/cloud",
+// in the project from DatastoreProjectIDEnv (or the development server's app
+// ID if that isn't set).
+//
+// Unlike UseRemote, the returned Context is always logged in to host as the
+// development server's admin user, whatever host's name is.
+func UseEmulator(c context.Context, host string) (context.Context, error) {
+	if host == "" {
+		if host = os.Getenv(EmulatorHostEnv); host == "" {
+			return nil, errors.Reason("no emulator host supplied, and %s is not set", EmulatorHostEnv).Err()
+		}
+	}
+	client, err := devServerClient(c, host)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to log in to emulator at %q", host).Err()
+	}
+	if err := UseRemote(&c, host, client); err != nil {
+		return nil, errors.Annotate(err, "failed to connect to emulator at %q", host).Err()
+	}
+
+	if dsHost, project := datastoreEmulator(info.TrimmedAppID(c)); dsHost != "" {
+		// The client connects to DatastoreEmulatorHostEnv by itself.
+		dsClient, err := datastore.NewClient(c, project)
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to connect to datastore emulator at %q", dsHost).Err()
+		}
+		c = cloud.UseDatastore(c, project, dsClient)
+	}
+	return c, nil
+}
+
+// datastoreEmulator returns the host of the Cloud Datastore emulator and the
+// project to use it with, which defaults to appID, from the environment. host
+// is empty if DatastoreEmulatorHostEnv isn't set.
+func datastoreEmulator(appID string) (host, project string) {
+	if host = os.Getenv(DatastoreEmulatorHostEnv); host == "" {
+		return "", ""
+	}
+	if project = os.Getenv(DatastoreProjectIDEnv); project == "" {
+		project = appID
+	}
+	return
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prod
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDatastoreEmulator(t *testing.T) {
+	Convey("datastoreEmulator", t, func() {
+		setenv := func(key, value string) {
+			old, ok := os.LookupEnv(key)
+			if value == "" {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, value)
+			}
+			Reset(func() {
+				if ok {
+					os.Setenv(key, old)
+				} else {
+					os.Unsetenv(key)
+				}
+			})
+		}
+		setenv(DatastoreEmulatorHostEnv, "")
+		setenv(DatastoreProjectIDEnv, "")
+
+		Convey("is not used by default", func() {
+			host, project := datastoreEmulator("app")
+			So(host, ShouldEqual, "")
+			So(project, ShouldEqual, "")
+		})
+
+		Convey("honors DATASTORE_EMULATOR_HOST", func() {
+			setenv(DatastoreEmulatorHostEnv, "localhost:8081")
+
+			host, project := datastoreEmulator("app")
+			So(host, ShouldEqual, "localhost:8081")
+			So(project, ShouldEqual, "app")
+
+			Convey("and DATASTORE_PROJECT_ID", func() {
+				setenv(DatastoreProjectIDEnv, "emulated")
+
+				_, project := datastoreEmulator("app")
+				So(project, ShouldEqual, "emulated")
+			})
+		})
+	})
+}
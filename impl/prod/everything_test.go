@@ -29,8 +29,10 @@ import (
 
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine/datastore"
 
 	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
 )
 
 var (
@@ -89,6 +91,11 @@ func TestBasicDatastore(t *testing.T) {
 			So(ds.MakeKey(ctx, "Hello", "world").Namespace(), ShouldEqual, "wat")
 		})
 
+		Convey("Named databases are rejected", func() {
+			dc := ds.WithDatabase(ctx, "other")
+			So(ds.Put(dc, &TestStruct{ValueI: []int64{1}}), ShouldErrLike, `can't be used for database "other"`)
+		})
+
 		Convey("Can get non-transactional context", func() {
 			ctx, err := info.Namespace(ctx, "foo")
 			So(err, ShouldBeNil)
@@ -291,6 +298,61 @@ func TestBasicDatastore(t *testing.T) {
 			So(ent["Time"], ShouldResemble, pm["Time"])
 		})
 
+		Convey("Can convert to and from the SDK", func() {
+			k := ds.MakeKey(ctx, "Parent", 1, "Child", "x")
+
+			Convey("keys", func() {
+				sk, err := KeyToSDK(ctx, k)
+				So(err, ShouldBeNil)
+				So(sk.Kind(), ShouldEqual, "Child")
+				So(sk.StringID(), ShouldEqual, "x")
+				So(sk.Parent().IntID(), ShouldEqual, 1)
+				So(KeyFromSDK(sk), ShouldResemble, k)
+
+				sk, err = KeyToSDK(ctx, nil)
+				So(err, ShouldBeNil)
+				So(sk, ShouldBeNil)
+			})
+
+			Convey("property maps", func() {
+				pm := ds.PropertyMap{
+					"$key":  mpNI(k),
+					"Int":   mp(10),
+					"Str":   mpNI("hi"),
+					"Key":   mp(k),
+					"Multi": ds.PropertySlice{mp(true), mpNI(false)},
+				}
+				props, err := PropertyMapToSDK(ctx, pm)
+				So(err, ShouldBeNil)
+				So(len(props), ShouldEqual, 5)
+
+				back, err := PropertyMapFromSDK(props)
+				So(err, ShouldBeNil)
+				delete(pm, "$key")
+				So(back, ShouldResemble, pm)
+			})
+
+			Convey("SDKPLS", func() {
+				So(ds.Put(ctx, NewSDKPLS(ctx, k, &datastore.PropertyList{
+					{Name: "Val", Value: int64(3)},
+					{Name: "Tags", Value: "a", Multiple: true},
+					{Name: "Tags", Value: "b", Multiple: true},
+				})), ShouldBeNil)
+
+				pm := ds.PropertyMap{"$key": mpNI(k)}
+				So(ds.Get(ctx, &pm), ShouldBeNil)
+				So(pm.Slice("Val"), ShouldResemble, ds.PropertySlice{mp(3)})
+				So(pm.Slice("Tags"), ShouldResemble, ds.PropertySlice{mp("a"), mp("b")})
+
+				var pl datastore.PropertyList
+				spls := NewSDKPLS(ctx, nil, &pl)
+				So(spls.SetMeta("key", k), ShouldBeTrue)
+				So(ds.Get(ctx, spls), ShouldBeNil)
+				So(len(pl), ShouldEqual, 3)
+				So(ds.GetMetaDefault(spls, "key", nil), ShouldResemble, k)
+			})
+		})
+
 		Convey("memcache: Set (nil) is the same as Set ([]byte{})", func() {
 			So(mc.Set(ctx, mc.NewItem(ctx, "bob")), ShouldBeNil) // normally would panic because Value is nil
 
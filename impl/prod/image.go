@@ -0,0 +1,69 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prod
+
+import (
+	"fmt"
+	"net/url"
+
+	"go.chromium.org/gae/service/image"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/blobstore"
+	aeImage "google.golang.org/appengine/image"
+)
+
+// useImage adds an image service implementation to context, accessible
+// by "go.chromium.org/gae/service/image".Raw(c) or the exported image
+// service methods.
+func useImage(c context.Context) context.Context {
+	return image.SetFactory(c, func(ci context.Context) image.RawInterface {
+		return imageImpl{getAEContext(ci)}
+	})
+}
+
+type imageImpl struct {
+	aeCtx context.Context
+}
+
+// blobKey returns the blob key of the Cloud Storage object name in bucket.
+func (i imageImpl) blobKey(bucket, name string) (appengine.BlobKey, error) {
+	return blobstore.BlobKeyForFile(i.aeCtx, fmt.Sprintf("/gs/%s/%s", bucket, name))
+}
+
+func (i imageImpl) GetServingURL(bucket, name string, opts *image.ServingURLOptions) (*url.URL, error) {
+	key, err := i.blobKey(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	var aeOpts *aeImage.ServingURLOptions
+	if opts != nil {
+		aeOpts = &aeImage.ServingURLOptions{
+			Secure: opts.Secure,
+			Size:   opts.Size,
+			Crop:   opts.Crop,
+		}
+	}
+	return aeImage.ServingURL(i.aeCtx, key, aeOpts)
+}
+
+func (i imageImpl) DeleteServingURL(bucket, name string) error {
+	key, err := i.blobKey(bucket, name)
+	if err != nil {
+		return err
+	}
+	return aeImage.DeleteServingURL(i.aeCtx, key)
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prod
+
+import (
+	"go.chromium.org/gae/service/logs"
+	"go.chromium.org/luci/common/logging"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+)
+
+// useLogs adds a logs service implementation to context, accessible
+// by "go.chromium.org/gae/service/logs".Raw(c) or the exported logs
+// service methods.
+func useLogs(c context.Context) context.Context {
+	return logs.SetFactory(c, func(ci context.Context) logs.RawInterface {
+		return logsImpl{getAEContext(ci)}
+	})
+}
+
+type logsImpl struct {
+	aeCtx context.Context
+}
+
+func (l logsImpl) Run(q *logs.Query, cb logs.RunCB) error {
+	aeQ := &log.Query{
+		StartTime:     q.StartTime,
+		EndTime:       q.EndTime,
+		AppLogs:       q.AppLogs,
+		ApplyMinLevel: q.ApplyMinLevel,
+		MinLevel:      toAELogLevel(q.MinLevel),
+		Versions:      q.Versions,
+		RequestIDs:    q.RequestIDs,
+	}
+
+	for res := aeQ.Run(l.aeCtx); ; {
+		rec, err := res.Next()
+		switch err {
+		case nil:
+		case log.Done:
+			return nil
+		default:
+			return err
+		}
+		if err := cb(fromAERecord(rec)); err != nil {
+			return err
+		}
+	}
+}
+
+func (l logsImpl) GetTestable() logs.Testable { return nil }
+
+func fromAERecord(rec *log.Record) *logs.Record {
+	ret := &logs.Record{
+		AppID:     rec.AppID,
+		ModuleID:  rec.ModuleID,
+		VersionID: rec.VersionID,
+		RequestID: string(rec.RequestID),
+		StartTime: rec.StartTime,
+		EndTime:   rec.EndTime,
+		Latency:   rec.Latency,
+		Method:    rec.Method,
+		Resource:  rec.Resource,
+		Status:    rec.Status,
+		IP:        rec.IP,
+		UserAgent: rec.UserAgent,
+		Host:      rec.Host,
+	}
+	if len(rec.AppLogs) > 0 {
+		ret.AppLogs = make([]logs.AppLog, len(rec.AppLogs))
+		for i, al := range rec.AppLogs {
+			ret.AppLogs[i] = logs.AppLog{Time: al.Time, Level: fromAELogLevel(al.Level), Message: al.Message}
+		}
+	}
+	return ret
+}
+
+// AppEngine log levels, as used by the Logs API.
+const (
+	aeLogLevelDebug = iota
+	aeLogLevelInfo
+	aeLogLevelWarning
+	aeLogLevelError
+	aeLogLevelCritical
+)
+
+func toAELogLevel(l logging.Level) int {
+	switch l {
+	case logging.Debug:
+		return aeLogLevelDebug
+	case logging.Info:
+		return aeLogLevelInfo
+	case logging.Warning:
+		return aeLogLevelWarning
+	default:
+		return aeLogLevelError
+	}
+}
+
+func fromAELogLevel(l int) logging.Level {
+	switch l {
+	case aeLogLevelDebug:
+		return logging.Debug
+	case aeLogLevelInfo:
+		return logging.Info
+	case aeLogLevelWarning:
+		return logging.Warning
+	default:
+		// logging has no level above Error, so aeLogLevelCritical maps to it too.
+		return logging.Error
+	}
+}
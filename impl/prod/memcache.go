@@ -164,6 +164,26 @@ func (m mcImpl) Increment(key string, delta int64, initialValue *uint64) (uint64
 	return memcache.Increment(m.aeCtx, key, delta, *initialValue)
 }
 
+// Touch is emulated with a Get / CompareAndSwap loop, since the App Engine
+// memcache API can't change the expiration of an item by itself.
+func (m mcImpl) Touch(key string, expiration time.Duration) error {
+	for {
+		itm, err := memcache.Get(m.aeCtx, key)
+		if err != nil {
+			return err
+		}
+		itm.Expiration = expiration
+		switch err := memcache.CompareAndSwap(m.aeCtx, itm); err {
+		case memcache.ErrCASConflict:
+			// The item was changed since we read it. Try again.
+		case memcache.ErrNotStored:
+			return memcache.ErrCacheMiss
+		default:
+			return err
+		}
+	}
+}
+
 func (m mcImpl) Flush() error {
 	return memcache.Flush(m.aeCtx)
 }
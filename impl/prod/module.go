@@ -17,6 +17,7 @@ package prod
 import (
 	"go.chromium.org/gae/service/module"
 	"golang.org/x/net/context"
+	"google.golang.org/appengine"
 	aeModule "google.golang.org/appengine/module"
 )
 
@@ -51,6 +52,10 @@ func (m modImpl) DefaultVersion(module string) (string, error) {
 	return aeModule.DefaultVersion(m.aeCtx, module)
 }
 
+func (m modImpl) Hostname(module, version, instance string) (string, error) {
+	return appengine.ModuleHostname(m.aeCtx, module, version, instance)
+}
+
 func (m modImpl) Start(module, version string) error {
 	return aeModule.Start(m.aeCtx, module, version)
 }
@@ -58,3 +63,5 @@ func (m modImpl) Start(module, version string) error {
 func (m modImpl) Stop(module, version string) error {
 	return aeModule.Stop(m.aeCtx, module, version)
 }
+
+func (m modImpl) GetTestable() module.Testable { return nil }
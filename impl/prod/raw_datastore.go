@@ -15,6 +15,8 @@
 package prod
 
 import (
+	"fmt"
+
 	"go.chromium.org/gae/impl/prod/constraints"
 	ds "go.chromium.org/gae/service/datastore"
 
@@ -29,15 +31,26 @@ import (
 // by gae.GetDS(c)
 func useRDS(c context.Context) context.Context {
 	return ds.SetRawFactory(c, func(ci context.Context) ds.RawInterface {
-		rds := rdsImpl{
-			userCtx: ci,
-			ps:      getProdState(ci),
+		// The App Engine datastore API can only reach the app's own datastore.
+		if appID := ds.GetAppID(ci); appID != "" && appID != getProbeCache(ci).fqaid {
+			return ds.NewErrorRaw(ci, fmt.Errorf("the App Engine datastore can't be used for app %q", appID))
+		}
+		if db := ds.GetDatabase(ci); db != "" {
+			return ds.NewErrorRaw(ci, fmt.Errorf("the App Engine datastore can't be used for database %q", db))
 		}
-		rds.aeCtx = rds.ps.context(ci)
-		return &rds
+		return newRDS(ci)
 	})
 }
 
+func newRDS(c context.Context) *rdsImpl {
+	rds := rdsImpl{
+		userCtx: c,
+		ps:      getProdState(c),
+	}
+	rds.aeCtx = rds.ps.context(c)
+	return &rds
+}
+
 ////////// Datastore
 
 type rdsImpl struct {
@@ -130,6 +143,20 @@ func (d *rdsImpl) AllocateIDs(keys []*ds.Key, cb ds.NewKeyCB) error {
 	return nil
 }
 
+func (d *rdsImpl) ReserveIDRange(key *ds.Key, start, end int64) error {
+	par, err := dsF2R(d.aeCtx, key.Parent())
+	if err != nil {
+		return err
+	}
+	err = datastore.AllocateIDRange(d.aeCtx, key.Kind(), par, start, end)
+	if _, ok := err.(*datastore.KeyRangeCollisionError); ok {
+		// Entities in the range already exist, which is expected when reserving
+		// the IDs of imported entities. The range is reserved regardless.
+		return nil
+	}
+	return err
+}
+
 func (d *rdsImpl) DeleteMulti(ks []*ds.Key, cb ds.DeleteMultiCB) error {
 	keys, err := dsMF2R(d.aeCtx, ks)
 	if err == nil {
@@ -140,7 +167,19 @@ func (d *rdsImpl) DeleteMulti(ks []*ds.Key, cb ds.DeleteMultiCB) error {
 	})
 }
 
+// checkReadTime returns an error if reads have a read time, since the App
+// Engine datastore API can only read the current state.
+func (d *rdsImpl) checkReadTime() error {
+	if !ds.GetReadTime(d.userCtx).IsZero() {
+		return errors.New("read times are not supported by the App Engine datastore")
+	}
+	return nil
+}
+
 func (d *rdsImpl) GetMulti(keys []*ds.Key, _meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	if err := d.checkReadTime(); err != nil {
+		return err
+	}
 	vals := make([]datastore.PropertyLoadSaver, len(keys))
 	rkeys, err := dsMF2R(d.aeCtx, keys)
 	if err == nil {
@@ -175,6 +214,44 @@ func (d *rdsImpl) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.NewKeyCB
 	})
 }
 
+// errMutationFailed aborts the transaction used by Mutate when one of the
+// mutations fails.
+var errMutationFailed = errors.New("mutation failed")
+
+func (d *rdsImpl) Mutate(muts []ds.RawMutation, cb ds.NewKeyCB) error {
+	// The App Engine datastore API has no mutations of its own, so apply them
+	// with Get, Put and Delete in a transaction. It's a single XG transaction,
+	// so it fails past its entity group limit, as documented by ds.Mutate.
+	if d.ps.inTxn {
+		return ds.ApplyMutations(d, muts, cb)
+	}
+
+	keys := make([]*ds.Key, len(muts))
+	errs := make([]error, len(muts))
+	err := d.RunInTransaction(func(c context.Context) error {
+		failed := false
+		err := ds.ApplyMutations(newRDS(c), muts, func(idx int, key *ds.Key, err error) error {
+			keys[idx], errs[idx] = key, err
+			failed = failed || err != nil
+			return nil
+		})
+		if err == nil && failed {
+			err = errMutationFailed
+		}
+		return err
+	}, &ds.TransactionOptions{XG: true})
+	if err != nil && err != errMutationFailed {
+		return err
+	}
+
+	for i := range muts {
+		if err := cb(i, keys[i], errs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *rdsImpl) fixQuery(fq *ds.FinalizedQuery) (*datastore.Query, error) {
 	ret := datastore.NewQuery(fq.Kind())
 
@@ -260,6 +337,9 @@ func (d *rdsImpl) DecodeCursor(s string) (ds.Cursor, error) {
 }
 
 func (d *rdsImpl) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	if err := d.checkReadTime(); err != nil {
+		return err
+	}
 	q, err := d.fixQuery(fq)
 	if err != nil {
 		return err
@@ -270,8 +350,15 @@ func (d *rdsImpl) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
 	cfunc := func() (ds.Cursor, error) {
 		return t.Cursor()
 	}
+	// The SDK loads the entities of a query one at a time, so the PropertyMap
+	// can be reused (see ds.PropertyMapsReusable). GetMulti loads them all at
+	// once.
+	reusePM := ds.PropertyMapsReusable(d.userCtx)
 	tf := typeFilter{}
 	for {
+		if !reusePM {
+			tf.pm = nil
+		}
 		k, err := t.Next(&tf)
 		if err == datastore.Done {
 			return nil
@@ -286,6 +373,9 @@ func (d *rdsImpl) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
 }
 
 func (d *rdsImpl) Count(fq *ds.FinalizedQuery) (int64, error) {
+	if err := d.checkReadTime(); err != nil {
+		return 0, err
+	}
 	q, err := d.fixQuery(fq)
 	if err != nil {
 		return 0, err
@@ -294,8 +384,23 @@ func (d *rdsImpl) Count(fq *ds.FinalizedQuery) (int64, error) {
 	return int64(ret), err
 }
 
+func (d *rdsImpl) Aggregate(fq *ds.FinalizedQuery, aggs []*ds.Aggregation) (ds.AggregationResult, error) {
+	// The App Engine datastore API doesn't support aggregation queries.
+	return ds.AggregateByScan(d, fq, aggs)
+}
+
 func (d *rdsImpl) RunInTransaction(f func(c context.Context) error, opts *ds.TransactionOptions) error {
-	ropts := (*datastore.TransactionOptions)(opts)
+	var ropts *datastore.TransactionOptions
+	if opts != nil {
+		if !opts.ReadTime.IsZero() {
+			return errors.New("read times are not supported by the App Engine datastore")
+		}
+		ropts = &datastore.TransactionOptions{
+			XG:       opts.XG,
+			Attempts: opts.Attempts,
+			ReadOnly: opts.ReadOnly,
+		}
+	}
 	return datastore.RunInTransaction(d.aeCtx, func(c context.Context) error {
 		// Derive a prodState with this transaction Context.
 		ps := d.ps
@@ -328,7 +433,11 @@ func (d *rdsImpl) CurrentTransaction() ds.Transaction {
 	return nil
 }
 
-func (d *rdsImpl) Constraints() ds.Constraints { return constraints.DS() }
+func (d *rdsImpl) Constraints() ds.Constraints {
+	c := constraints.DS()
+	c.NoReadTime = true
+	return c
+}
 
 func (d *rdsImpl) GetTestable() ds.Testable {
 	return nil
@@ -166,7 +166,14 @@ func dsF2RProp(ctx context.Context, in ds.Property) (datastore.Property, error)
 }
 
 func (tf *typeFilter) Load(props []datastore.Property) error {
-	tf.pm = make(ds.PropertyMap, len(props))
+	if tf.pm == nil {
+		tf.pm = make(ds.PropertyMap, len(props))
+	} else {
+		// Reused for many entities (see rdsImpl.Run).
+		for k := range tf.pm {
+			delete(tf.pm, k)
+		}
+	}
 	for _, p := range props {
 		prop, err := dsR2FProp(p)
 		if err != nil {
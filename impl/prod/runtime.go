@@ -0,0 +1,62 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prod
+
+import (
+	"time"
+
+	rt "go.chromium.org/gae/service/runtime"
+
+	"golang.org/x/net/context"
+	aeRuntime "google.golang.org/appengine/runtime"
+)
+
+// instanceStarted approximates when the instance started, for Stats' Uptime.
+var instanceStarted = time.Now()
+
+// useRuntime adds a runtime service implementation to context, accessible
+// by "go.chromium.org/gae/service/runtime".Raw(c) or the exported runtime
+// service methods.
+func useRuntime(c context.Context) context.Context {
+	return rt.SetFactory(c, func(ci context.Context) rt.RawInterface {
+		return runtimeImpl{getAEContext(ci)}
+	})
+}
+
+type runtimeImpl struct {
+	aeCtx context.Context
+}
+
+func (r runtimeImpl) Stats() (*rt.Stats, error) {
+	st, err := aeRuntime.Stats(r.aeCtx)
+	if err != nil {
+		return nil, err
+	}
+	return &rt.Stats{
+		CPU: rt.CPUStats{
+			Total:   st.CPU.Total,
+			Rate1M:  st.CPU.Rate1M,
+			Rate10M: st.CPU.Rate10M,
+		},
+		RAM: rt.RAMStats{
+			Current:    st.RAM.Current,
+			Average1M:  st.RAM.Average1M,
+			Average10M: st.RAM.Average10M,
+		},
+		Uptime: time.Since(instanceStarted),
+	}, nil
+}
+
+func (r runtimeImpl) GetTestable() rt.Testable { return nil }
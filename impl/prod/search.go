@@ -0,0 +1,148 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prod
+
+import (
+	"go.chromium.org/gae/service/search"
+
+	"golang.org/x/net/context"
+	aeSearch "google.golang.org/appengine/search"
+)
+
+// useSearch adds a search service implementation to context, accessible
+// by "go.chromium.org/gae/service/search".Raw(c) or the exported search
+// service methods.
+func useSearch(c context.Context) context.Context {
+	return search.SetFactory(c, func(ci context.Context) search.RawInterface {
+		return searchImpl{getAEContext(ci)}
+	})
+}
+
+type searchImpl struct {
+	aeCtx context.Context
+}
+
+func (s searchImpl) Put(index, id string, doc []search.Field) (string, error) {
+	idx, err := aeSearch.Open(index)
+	if err != nil {
+		return "", err
+	}
+	src := fromSearchFields(doc)
+	return idx.Put(s.aeCtx, id, &src)
+}
+
+func (s searchImpl) Get(index, id string) ([]search.Field, error) {
+	idx, err := aeSearch.Open(index)
+	if err != nil {
+		return nil, err
+	}
+	var dst aeSearch.FieldList
+	if err := idx.Get(s.aeCtx, id, &dst); err != nil {
+		return nil, fixSearchError(err)
+	}
+	return toSearchFields(dst), nil
+}
+
+func (s searchImpl) Delete(index, id string) error {
+	idx, err := aeSearch.Open(index)
+	if err != nil {
+		return err
+	}
+	return fixSearchError(idx.Delete(s.aeCtx, id))
+}
+
+func (s searchImpl) Search(index, query string, opts *search.SearchOptions, cb search.SearchCB) error {
+	idx, err := aeSearch.Open(index)
+	if err != nil {
+		return err
+	}
+
+	var aeOpts *aeSearch.SearchOptions
+	if opts != nil {
+		aeOpts = &aeSearch.SearchOptions{
+			Limit:   opts.Limit,
+			IDsOnly: opts.IDsOnly,
+		}
+		if len(opts.Sort) > 0 {
+			aeOpts.Sort = &aeSearch.SortOptions{
+				Expressions: make([]aeSearch.SortExpression, len(opts.Sort)),
+			}
+			for i, e := range opts.Sort {
+				// The AppEngine API sorts in descending order by default.
+				aeOpts.Sort.Expressions[i] = aeSearch.SortExpression{Expr: e.Field, Reverse: !e.Reverse}
+			}
+		}
+	}
+
+	for it := idx.Search(s.aeCtx, query, aeOpts); ; {
+		// dst is left empty for IDsOnly searches.
+		var dst aeSearch.FieldList
+		id, err := it.Next(&dst)
+		switch err {
+		case nil:
+		case aeSearch.Done:
+			return nil
+		default:
+			return err
+		}
+
+		var doc []search.Field
+		if dst != nil {
+			doc = toSearchFields(dst)
+		}
+		if err := cb(id, doc); err != nil {
+			return err
+		}
+	}
+}
+
+func fromSearchFields(doc []search.Field) aeSearch.FieldList {
+	ret := make(aeSearch.FieldList, len(doc))
+	for i, f := range doc {
+		ret[i].Name = f.Name
+		switch v := f.Value.(type) {
+		case search.Atom:
+			ret[i].Value = aeSearch.Atom(v)
+		case search.HTML:
+			ret[i].Value = aeSearch.HTML(v)
+		default:
+			ret[i].Value = v
+		}
+	}
+	return ret
+}
+
+func toSearchFields(fl aeSearch.FieldList) []search.Field {
+	ret := make([]search.Field, len(fl))
+	for i, f := range fl {
+		ret[i].Name = f.Name
+		switch v := f.Value.(type) {
+		case aeSearch.Atom:
+			ret[i].Value = search.Atom(v)
+		case aeSearch.HTML:
+			ret[i].Value = search.HTML(v)
+		default:
+			ret[i].Value = v
+		}
+	}
+	return ret
+}
+
+func fixSearchError(err error) error {
+	if err == aeSearch.ErrNoSuchDocument {
+		return search.ErrNoSuchDocument
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prod
+
+import (
+	"net"
+	"time"
+
+	"go.chromium.org/gae/service/socket"
+
+	"golang.org/x/net/context"
+	aeSocket "google.golang.org/appengine/socket"
+)
+
+// useSocket adds a socket service implementation to context, accessible
+// by "go.chromium.org/gae/service/socket".Raw(c) or the exported socket
+// service methods.
+//
+// On classic AppEngine it uses the Sockets API; elsewhere it dials directly.
+func useSocket(c context.Context) context.Context {
+	return socket.SetFactory(c, func(ci context.Context) socket.RawInterface {
+		return socketImpl{getAEContext(ci)}
+	})
+}
+
+type socketImpl struct {
+	aeCtx context.Context
+}
+
+func (s socketImpl) Dial(network, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := aeSocket.DialTimeout(s.aeCtx, network, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (s socketImpl) LookupIP(host string) ([]net.IP, error) {
+	return aeSocket.LookupIP(s.aeCtx, host)
+}
+
+func (s socketImpl) GetTestable() socket.Testable { return nil }
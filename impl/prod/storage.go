@@ -0,0 +1,166 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prod
+
+import (
+	"io"
+
+	"go.chromium.org/gae/service/storage"
+
+	gcs "cloud.google.com/go/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+	"google.golang.org/appengine/file"
+)
+
+// useStorage adds a storage service implementation to context, accessible
+// by "go.chromium.org/gae/service/storage".Raw(c) or the exported storage
+// service methods.
+//
+// It's backed by Google Cloud Storage.
+func useStorage(c context.Context) context.Context {
+	return storage.SetFactory(c, func(ci context.Context) storage.RawInterface {
+		return storageImpl{getAEContext(ci)}
+	})
+}
+
+type storageImpl struct {
+	aeCtx context.Context
+}
+
+// withClient calls cb with a new Cloud Storage client, closing it afterwards.
+func (s storageImpl) withClient(cb func(*gcs.Client) error) error {
+	client, err := gcs.NewClient(s.aeCtx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return cb(client)
+}
+
+func (s storageImpl) NewReader(bucket, name string) (io.ReadCloser, error) {
+	client, err := gcs.NewClient(s.aeCtx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := client.Bucket(bucket).Object(name).NewReader(s.aeCtx)
+	if err != nil {
+		client.Close()
+		return nil, fixStorageError(err)
+	}
+	return &clientReader{r, client}, nil
+}
+
+func (s storageImpl) NewWriter(bucket, name string, opts *storage.WriterOptions) (io.WriteCloser, error) {
+	client, err := gcs.NewClient(s.aeCtx)
+	if err != nil {
+		return nil, err
+	}
+	w := client.Bucket(bucket).Object(name).NewWriter(s.aeCtx)
+	if opts != nil {
+		w.ContentType = opts.ContentType
+		w.Metadata = opts.Metadata
+	}
+	return &clientWriter{w, client}, nil
+}
+
+func (s storageImpl) Stat(bucket, name string) (ret *storage.ObjectAttrs, err error) {
+	err = s.withClient(func(client *gcs.Client) error {
+		attrs, err := client.Bucket(bucket).Object(name).Attrs(s.aeCtx)
+		if err != nil {
+			return fixStorageError(err)
+		}
+		ret = toObjectAttrs(attrs)
+		return nil
+	})
+	return
+}
+
+func (s storageImpl) Delete(bucket, name string) error {
+	return s.withClient(func(client *gcs.Client) error {
+		return fixStorageError(client.Bucket(bucket).Object(name).Delete(s.aeCtx))
+	})
+}
+
+func (s storageImpl) List(bucket, prefix string, cb storage.ListCB) error {
+	return s.withClient(func(client *gcs.Client) error {
+		it := client.Bucket(bucket).Objects(s.aeCtx, &gcs.Query{Prefix: prefix})
+		for {
+			attrs, err := it.Next()
+			switch err {
+			case nil:
+			case iterator.Done:
+				return nil
+			default:
+				return fixStorageError(err)
+			}
+			if err := cb(toObjectAttrs(attrs)); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+func (s storageImpl) DefaultBucket() (string, error) {
+	return file.DefaultBucketName(s.aeCtx)
+}
+
+func (s storageImpl) GetTestable() storage.Testable { return nil }
+
+// clientReader and clientWriter close a Cloud Storage client when the reader
+// or writer that uses it is closed.
+type clientReader struct {
+	io.ReadCloser
+	client *gcs.Client
+}
+
+func (cr *clientReader) Close() error {
+	defer cr.client.Close()
+	return cr.ReadCloser.Close()
+}
+
+type clientWriter struct {
+	io.WriteCloser
+	client *gcs.Client
+}
+
+func (cw *clientWriter) Close() error {
+	defer cw.client.Close()
+	return fixStorageError(cw.WriteCloser.Close())
+}
+
+func toObjectAttrs(a *gcs.ObjectAttrs) *storage.ObjectAttrs {
+	return &storage.ObjectAttrs{
+		Bucket:      a.Bucket,
+		Name:        a.Name,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		MD5:         a.MD5,
+		Created:     a.Created,
+		Updated:     a.Updated,
+		Metadata:    a.Metadata,
+	}
+}
+
+func fixStorageError(err error) error {
+	switch err {
+	case gcs.ErrBucketNotExist:
+		return storage.ErrBucketNotExist
+	case gcs.ErrObjectNotExist:
+		return storage.ErrObjectNotExist
+	default:
+		return err
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remote installs the gae services into a Context, backed by a live
+// app's Remote API endpoint.
+//
+// It's intended for command-line tools running on a workstation (e.g.
+// migrations, backfills and one-off fixes), so that they can use the same
+// package code as the app itself:
+//
+//	c, err := remote.Use(context.Background(), remote.Host("my-project", nil), nil)
+//	if err != nil {
+//	  return err
+//	}
+//	return datastore.Put(c, &MyEntity{...})
+//
+// The app must have the Remote API handler enabled. See:
+// https://cloud.google.com/appengine/docs/go/tools/remoteapi
+//
+// Remote API is not a replacement for running on App Engine: every operation
+// is a round trip from the workstation to the app, and some services (e.g.
+// "user") have no meaningful remote implementation.
+package remote
+
+import (
+	"net/http"
+	"strings"
+
+	"go.chromium.org/gae/impl/prod"
+
+	"golang.org/x/net/context"
+)
+
+// Scopes is the set of OAuth2 scopes that an *http.Client passed to Use must
+// have.
+var Scopes = prod.RemoteAPIScopes
+
+// DefaultDomain is the domain of the apps' hosts when HostOptions doesn't
+// specify one.
+const DefaultDomain = "appspot.com"
+
+// HostOptions configure the Remote API host returned by Host.
+type HostOptions struct {
+	// Domain is the domain of the app's host. If empty, DefaultDomain is used.
+	//
+	// Apps created after 2020 are served from "<region ID>.r.appspot.com"
+	// instead, e.g. "uc.r.appspot.com".
+	Domain string
+
+	// Service and Version, if not empty, route the requests to that service
+	// and version of the app, rather than to the default ones.
+	Service string
+	Version string
+}
+
+// Host returns the Remote API host of the app in the given Cloud project, e.g.
+// "my-project.appspot.com", or "v2-dot-api-dot-my-project.appspot.com" for
+// the version "v2" of the service "api". opts may be nil.
+func Host(projectID string, opts *HostOptions) string {
+	if opts == nil {
+		opts = &HostOptions{}
+	}
+	domain := opts.Domain
+	if domain == "" {
+		domain = DefaultDomain
+	}
+
+	parts := make([]string, 0, 3)
+	for _, p := range []string{opts.Version, opts.Service, projectID} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "-dot-") + "." + domain
+}
+
+// Use returns a Context derived from c which has all of the gae services
+// installed, backed by the Remote API endpoint of the app at host (e.g.
+// "localhost:8080" or Host("my-project", nil)).
+//
+// If client is nil, a default one is created: for "localhost" hosts it logs in
+// to the development server as an admin, and otherwise it uses the
+// Application Default Credentials with Scopes. See prod.UseRemote for
+// details.
+func Use(c context.Context, host string, client *http.Client) (context.Context, error) {
+	if err := prod.UseRemote(&c, host, client); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
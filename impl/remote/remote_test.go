@@ -0,0 +1,43 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHost(t *testing.T) {
+	t.Parallel()
+
+	Convey("Host", t, func() {
+		Convey("defaults to the appspot.com host of the app", func() {
+			So(Host("my-project", nil), ShouldEqual, "my-project.appspot.com")
+			So(Host("my-project", &HostOptions{}), ShouldEqual, "my-project.appspot.com")
+		})
+
+		Convey("uses the supplied domain", func() {
+			So(Host("my-project", &HostOptions{Domain: "uc.r.appspot.com"}), ShouldEqual, "my-project.uc.r.appspot.com")
+		})
+
+		Convey("targets a service and version", func() {
+			So(Host("my-project", &HostOptions{Service: "api"}), ShouldEqual, "api-dot-my-project.appspot.com")
+			So(Host("my-project", &HostOptions{Version: "v2"}), ShouldEqual, "v2-dot-my-project.appspot.com")
+			So(Host("my-project", &HostOptions{Service: "api", Version: "v2", Domain: "uc.r.appspot.com"}),
+				ShouldEqual, "v2-dot-api-dot-my-project.uc.r.appspot.com")
+		})
+	})
+}
@@ -0,0 +1,294 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package smtp provides an implementation of the "service/mail" service which
+// delivers messages through an SMTP server.
+//
+// It's intended for environments where the classic AppEngine mail API is
+// unavailable (e.g. Flex and the second-generation Standard runtimes). Mail
+// providers such as SendGrid and Mailgun offer SMTP relays, which can be
+// used by pointing Config.Addr and Config.Auth at them, e.g.:
+//
+//	c = smtp.Use(c, &smtp.Config{
+//	  Addr: "smtp.sendgrid.net:587",
+//	  Auth: netsmtp.PlainAuth("", "apikey", sendGridKey, "smtp.sendgrid.net"),
+//	  AdminEmails: []string{"admins@example.com"},
+//	})
+package smtp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	net_mail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.chromium.org/gae/service/mail"
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/data/stringset"
+
+	"golang.org/x/net/context"
+)
+
+// allowedHeaders are the extra headers a Message may have. As with the classic
+// AppEngine mail API, other headers are rejected.
+var allowedHeaders = stringset.NewFromSlice(
+	"In-Reply-To",
+	"List-Id",
+	"List-Unsubscribe",
+	"On-Behalf-Of",
+	"References",
+	"Resent-Date",
+	"Resent-From",
+	"Resent-To",
+)
+
+// SendFunc sends a composed message, in the manner of net/smtp.SendMail.
+type SendFunc func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+// Config configures the SMTP mail service.
+type Config struct {
+	// Addr is the "host:port" address of the SMTP server.
+	Addr string
+
+	// Auth, if not nil, is used to authenticate with the SMTP server.
+	Auth smtp.Auth
+
+	// AdminEmails are the recipients of SendToAdmins messages.
+	AdminEmails []string
+
+	// Send, if not nil, is used to send messages instead of
+	// net/smtp.SendMail.
+	Send SendFunc
+}
+
+// Use installs the SMTP mail service configured by cfg into c.
+func Use(c context.Context, cfg *Config) context.Context {
+	return mail.SetFactory(c, func(ic context.Context) mail.RawInterface {
+		return &mailImpl{ic, cfg}
+	})
+}
+
+type mailImpl struct {
+	c   context.Context
+	cfg *Config
+}
+
+var _ mail.RawInterface = (*mailImpl)(nil)
+
+func (m *mailImpl) Send(msg *mail.Message) error {
+	return m.send(msg)
+}
+
+func (m *mailImpl) SendToAdmins(msg *mail.Message) error {
+	if len(m.cfg.AdminEmails) == 0 {
+		return errors.New("smtp: no admin emails configured")
+	}
+	msg = msg.Copy()
+	msg.To = append([]string(nil), m.cfg.AdminEmails...)
+	msg.Cc, msg.Bcc = nil, nil
+	return m.send(msg)
+}
+
+func (m *mailImpl) GetTestable() mail.Testable { return nil }
+
+func (m *mailImpl) send(msg *mail.Message) error {
+	sender, err := net_mail.ParseAddress(msg.Sender)
+	if err != nil {
+		return fmt.Errorf("smtp: unparsable Sender address %q: %s", msg.Sender, err)
+	}
+
+	hdr := textproto.MIMEHeader{}
+	for k, vs := range msg.Headers {
+		canonK := textproto.CanonicalMIMEHeaderKey(k)
+		if !allowedHeaders.Has(canonK) {
+			return fmt.Errorf("smtp: disallowed header: %s", k)
+		}
+		for _, v := range vs {
+			if strings.ContainsAny(v, "\r\n") {
+				return fmt.Errorf("smtp: invalid value of header %s: %q", k, v)
+			}
+		}
+		hdr[canonK] = vs
+	}
+	hdr.Set("From", sender.String())
+	if msg.ReplyTo != "" {
+		replyTo, err := net_mail.ParseAddress(msg.ReplyTo)
+		if err != nil {
+			return fmt.Errorf("smtp: unparsable ReplyTo address %q: %s", msg.ReplyTo, err)
+		}
+		hdr.Set("Reply-To", replyTo.String())
+	}
+
+	var rcpts []string
+	for _, f := range []struct {
+		header string
+		addrs  []string
+	}{{"To", msg.To}, {"Cc", msg.Cc}, {"", msg.Bcc}} {
+		var formatted []string
+		for _, a := range f.addrs {
+			addr, err := net_mail.ParseAddress(a)
+			if err != nil {
+				return fmt.Errorf("smtp: invalid email (%q): %s", a, err)
+			}
+			rcpts = append(rcpts, addr.Address)
+			formatted = append(formatted, addr.String())
+		}
+		// Bcc recipients are omitted from the headers.
+		if f.header != "" && len(formatted) > 0 {
+			hdr.Set(f.header, strings.Join(formatted, ", "))
+		}
+	}
+	if len(rcpts) == 0 {
+		return errors.New("smtp: one of To, Cc or Bcc must be non-empty")
+	}
+	if msg.Body == "" && msg.HTMLBody == "" {
+		return errors.New("smtp: one of Body or HTMLBody must be non-empty")
+	}
+	for _, a := range msg.Attachments {
+		if strings.ContainsAny(a.Name, "\r\n") || strings.ContainsAny(a.ContentID, "\r\n") {
+			return fmt.Errorf("smtp: invalid attachment %q with Content-ID %q", a.Name, a.ContentID)
+		}
+	}
+
+	data, err := m.compose(msg, hdr)
+	if err != nil {
+		return err
+	}
+
+	send := m.cfg.Send
+	if send == nil {
+		send = smtp.SendMail
+	}
+	if err := send(m.cfg.Addr, m.cfg.Auth, sender.Address, rcpts, data); err != nil {
+		return fmt.Errorf("smtp: failed to send message: %s", err)
+	}
+	return nil
+}
+
+// compose renders msg as an RFC 5322 message, with the validated address and
+// extra headers in hdr.
+func (m *mailImpl) compose(msg *mail.Message, hdr textproto.MIMEHeader) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	hdr.Set("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	hdr.Set("Date", clock.Now(m.c).Format("Mon, 02 Jan 2006 15:04:05 -0700"))
+	hdr.Set("MIME-Version", "1.0")
+
+	mw := multipart.NewWriter(buf)
+	hdr.Set("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%s", mw.Boundary()))
+	writeHeader(buf, hdr)
+
+	// The body is a multipart/alternative part containing whichever of the
+	// plain text and HTML bodies are set.
+	alt := &bytes.Buffer{}
+	aw := multipart.NewWriter(alt)
+	for _, b := range []struct{ contentType, body string }{
+		{"text/plain", msg.Body},
+		{"text/html", msg.HTMLBody},
+	} {
+		if b.body == "" {
+			continue
+		}
+		w, err := aw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {b.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(w, []byte(b.body)); err != nil {
+			return nil, err
+		}
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+	w, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {fmt.Sprintf("multipart/alternative; boundary=%s", aw.Boundary())},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(alt.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for _, a := range msg.Attachments {
+		contentType := mime.TypeByExtension(filepath.Ext(a.Name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		ph := textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+		}
+		if a.ContentID != "" {
+			ph.Set("Content-ID", a.ContentID)
+		}
+		w, err := mw.CreatePart(ph)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(w, a.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, hdr textproto.MIMEHeader) {
+	keys := make([]string, 0, len(hdr))
+	for k := range hdr {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range hdr[k] {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+// writeBase64 writes data to w as base64, in lines of at most 76 characters.
+func writeBase64(w io.Writer, data []byte) error {
+	const lineLen = 76
+
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 0 {
+		n := lineLen
+		if n > len(enc) {
+			n = len(enc)
+		}
+		if _, err := fmt.Fprintf(w, "%s\r\n", enc[:n]); err != nil {
+			return err
+		}
+		enc = enc[n:]
+	}
+	return nil
+}
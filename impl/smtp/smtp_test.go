@@ -0,0 +1,190 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smtp
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	net_mail "net/mail"
+	"net/smtp"
+	"testing"
+
+	"go.chromium.org/gae/service/mail"
+	"go.chromium.org/luci/common/clock/testclock"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestSMTP(t *testing.T) {
+	t.Parallel()
+
+	Convey("SMTP mail", t, func() {
+		type sent struct {
+			addr string
+			from string
+			to   []string
+			msg  []byte
+		}
+		var sends []sent
+		var sendErr error
+
+		c, _ := testclock.UseTime(context.Background(), testclock.TestTimeUTC)
+		c = Use(c, &Config{
+			Addr:        "smtp.example.com:587",
+			AdminEmails: []string{"admin@example.com"},
+			Send: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+				sends = append(sends, sent{addr, from, to, msg})
+				return sendErr
+			},
+		})
+
+		Convey("sends a message", func() {
+			So(mail.Send(c, &mail.Message{
+				Sender:      "Sender <from@example.com>",
+				To:          []string{"to@example.com"},
+				Cc:          []string{"Cc <cc@example.com>"},
+				Bcc:         []string{"bcc@example.com"},
+				Subject:     "Hello",
+				Body:        "plain body",
+				HTMLBody:    "<b>html body</b>",
+				Attachments: []mail.Attachment{{Name: "data.txt", Data: []byte("attached")}},
+			}), ShouldBeNil)
+
+			So(len(sends), ShouldEqual, 1)
+			So(sends[0].addr, ShouldEqual, "smtp.example.com:587")
+			So(sends[0].from, ShouldEqual, "from@example.com")
+			So(sends[0].to, ShouldResemble, []string{"to@example.com", "cc@example.com", "bcc@example.com"})
+
+			msg, err := net_mail.ReadMessage(bytes.NewReader(sends[0].msg))
+			So(err, ShouldBeNil)
+			So(msg.Header.Get("From"), ShouldEqual, `"Sender" <from@example.com>`)
+			So(msg.Header.Get("To"), ShouldEqual, "<to@example.com>")
+			So(msg.Header.Get("Cc"), ShouldEqual, `"Cc" <cc@example.com>`)
+			So(msg.Header.Get("Bcc"), ShouldEqual, "")
+			So(msg.Header.Get("Subject"), ShouldEqual, "Hello")
+			date, err := msg.Header.Date()
+			So(err, ShouldBeNil)
+			So(date.Equal(testclock.TestTimeUTC.Truncate(1e9)), ShouldBeTrue)
+
+			mt, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			So(err, ShouldBeNil)
+			So(mt, ShouldEqual, "multipart/mixed")
+			mr := multipart.NewReader(msg.Body, params["boundary"])
+
+			p, err := mr.NextPart()
+			So(err, ShouldBeNil)
+			mt, params, err = mime.ParseMediaType(p.Header.Get("Content-Type"))
+			So(err, ShouldBeNil)
+			So(mt, ShouldEqual, "multipart/alternative")
+			ar := multipart.NewReader(p, params["boundary"])
+			var bodies []string
+			for {
+				ap, err := ar.NextPart()
+				if err != nil {
+					break
+				}
+				data, err := ioutil.ReadAll(ap)
+				So(err, ShouldBeNil)
+				bodies = append(bodies, ap.Header.Get("Content-Type")+": "+string(data))
+			}
+			So(bodies, ShouldResemble, []string{
+				"text/plain; charset=utf-8: cGxhaW4gYm9keQ==\r\n",
+				"text/html; charset=utf-8: PGI+aHRtbCBib2R5PC9iPg==\r\n",
+			})
+
+			p, err = mr.NextPart()
+			So(err, ShouldBeNil)
+			So(p.FileName(), ShouldEqual, "data.txt")
+		})
+
+		Convey("sends to admins", func() {
+			So(mail.SendToAdmins(c, &mail.Message{
+				Sender:  "from@example.com",
+				To:      []string{"ignored@example.com"},
+				Subject: "Hello",
+				Body:    "body",
+			}), ShouldBeNil)
+			So(len(sends), ShouldEqual, 1)
+			So(sends[0].to, ShouldResemble, []string{"admin@example.com"})
+		})
+
+		Convey("rejects bad messages", func() {
+			So(mail.Send(c, &mail.Message{Sender: "nope", To: []string{"to@example.com"}, Body: "b"}),
+				ShouldErrLike, "unparsable Sender")
+			So(mail.Send(c, &mail.Message{Sender: "from@example.com", Body: "b"}),
+				ShouldErrLike, "one of To, Cc or Bcc")
+			So(mail.Send(c, &mail.Message{Sender: "from@example.com", To: []string{"to@example.com"}}),
+				ShouldErrLike, "one of Body or HTMLBody")
+			So(sends, ShouldBeEmpty)
+		})
+
+		Convey("sends allowed headers", func() {
+			So(mail.Send(c, &mail.Message{
+				Sender:  "from@example.com",
+				ReplyTo: "Reply <reply@example.com>",
+				To:      []string{"to@example.com"},
+				Headers: net_mail.Header{"in-reply-to": {"<id@example.com>"}},
+				Body:    "body",
+			}), ShouldBeNil)
+
+			msg, err := net_mail.ReadMessage(bytes.NewReader(sends[0].msg))
+			So(err, ShouldBeNil)
+			So(msg.Header.Get("In-Reply-To"), ShouldEqual, "<id@example.com>")
+			replyTo, err := msg.Header.AddressList("Reply-To")
+			So(err, ShouldBeNil)
+			So(replyTo, ShouldResemble, []*net_mail.Address{{Name: "Reply", Address: "reply@example.com"}})
+		})
+
+		Convey("rejects header injection", func() {
+			base := func() *mail.Message {
+				return &mail.Message{Sender: "from@example.com", To: []string{"to@example.com"}, Body: "b"}
+			}
+
+			msg := base()
+			msg.Headers = net_mail.Header{"Bcc": {"victim@example.com"}}
+			So(mail.Send(c, msg), ShouldErrLike, "disallowed header: Bcc")
+
+			msg = base()
+			msg.Headers = net_mail.Header{"References": {"<id@example.com>\r\nBcc: victim@example.com"}}
+			So(mail.Send(c, msg), ShouldErrLike, "invalid value of header References")
+
+			msg = base()
+			msg.ReplyTo = "reply@example.com\r\nBcc: victim@example.com"
+			So(mail.Send(c, msg), ShouldErrLike, "unparsable ReplyTo")
+
+			msg = base()
+			msg.Attachments = []mail.Attachment{{Name: "a.txt", Data: []byte("a"), ContentID: "<a>\r\nX-Injected: 1"}}
+			So(mail.Send(c, msg), ShouldErrLike, "invalid attachment")
+
+			msg = base()
+			msg.Attachments = []mail.Attachment{{Name: "a.txt\r\nX-Injected: 1", Data: []byte("a")}}
+			So(mail.Send(c, msg), ShouldErrLike, "invalid attachment")
+
+			So(sends, ShouldBeEmpty)
+		})
+
+		Convey("reports send failures", func() {
+			sendErr = errors.New("boom")
+			So(mail.Send(c, &mail.Message{Sender: "from@example.com", To: []string{"to@example.com"}, Body: "b"}),
+				ShouldErrLike, "boom")
+		})
+	})
+}
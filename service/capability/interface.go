@@ -0,0 +1,50 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package capability reports whether the capabilities of backend APIs, such
+// as datastore writes, are currently available, through the Context.
+//
+// Applications can use it to degrade gracefully while an API is unavailable,
+// e.g. while the datastore is read-only for maintenance:
+//
+//	if !capability.Enabled(c, capability.DatastoreAPI, capability.Write) {
+//		// Show a read-only version of the page.
+//	}
+package capability
+
+import (
+	"golang.org/x/net/context"
+)
+
+// RawInterface is the interface for all of the package methods which normally
+// would be in the 'capability' package.
+type RawInterface interface {
+	Enabled(api, capability string) bool
+
+	GetTestable() Testable
+}
+
+// Enabled returns true if capability of api is currently available. The
+// capability All matches every capability of api.
+//
+// If the status can't be determined, Enabled returns false.
+func Enabled(c context.Context, api, capability string) bool {
+	return Raw(c).Enabled(api, capability)
+}
+
+// GetTestable returns a Testable for the current capability implementation, or
+// nil if there is none.
+func GetTestable(c context.Context) Testable {
+	return Raw(c).GetTestable()
+}
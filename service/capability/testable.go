@@ -0,0 +1,22 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capability
+
+// Testable is the testable interface for fake capability implementations.
+type Testable interface {
+	// SetEnabled enables or disables capability of api. Disabling All disables
+	// every capability of api. All capabilities start out enabled.
+	SetEnabled(api, capability string, enabled bool)
+}
@@ -0,0 +1,36 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capability
+
+// Well-known API names.
+const (
+	BlobstoreAPI = "blobstore"
+	DatastoreAPI = "datastore_v3"
+	ImagesAPI    = "images"
+	MailAPI      = "mail"
+	MemcacheAPI  = "memcache"
+	TaskQueueAPI = "taskqueue"
+	URLFetchAPI  = "urlfetch"
+)
+
+// Well-known capability names.
+const (
+	// All matches every capability of an API.
+	All = "*"
+
+	// Write is the capability to modify data, e.g. datastore puts. It's
+	// disabled while an API is read-only.
+	Write = "write"
+)
@@ -0,0 +1,286 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// AggregationOp is the operation of an Aggregation.
+type AggregationOp int
+
+// The aggregation operations.
+const (
+	// AggregationCount counts the entities matching the query.
+	AggregationCount AggregationOp = iota
+	// AggregationSum sums the numeric values of a property.
+	AggregationSum
+	// AggregationAvg averages the numeric values of a property.
+	AggregationAvg
+)
+
+func (o AggregationOp) String() string {
+	switch o {
+	case AggregationCount:
+		return "COUNT"
+	case AggregationSum:
+		return "SUM"
+	case AggregationAvg:
+		return "AVG"
+	default:
+		return fmt.Sprintf("AggregationOp(%d)", int(o))
+	}
+}
+
+// Aggregation is an aggregation computed over the results of a query. It's
+// immutable.
+//
+// The result of the aggregation is reported under its alias by
+// RunAggregation:
+//   - AggregationCount results are int64.
+//   - AggregationSum results are int64 if all of the summed values were
+//     integers (and their sum didn't overflow), and float64 otherwise.
+//   - AggregationAvg results are float64, or nil if there were no values.
+//
+// Sums and averages ignore the non-numeric values of the property. The values
+// of multi-valued properties are each included.
+type Aggregation struct {
+	op    AggregationOp
+	prop  string
+	alias string
+}
+
+// CountAs returns an Aggregation counting the results of the query, reported
+// as alias.
+func CountAs(alias string) *Aggregation {
+	return &Aggregation{op: AggregationCount, alias: alias}
+}
+
+// SumOf returns an Aggregation summing the values of prop, reported as prop
+// unless renamed with As.
+func SumOf(prop string) *Aggregation {
+	return &Aggregation{op: AggregationSum, prop: prop, alias: prop}
+}
+
+// AvgOf returns an Aggregation averaging the values of prop, reported as prop
+// unless renamed with As.
+func AvgOf(prop string) *Aggregation {
+	return &Aggregation{op: AggregationAvg, prop: prop, alias: prop}
+}
+
+// As returns a copy of a which is reported as alias.
+func (a *Aggregation) As(alias string) *Aggregation {
+	ret := *a
+	ret.alias = alias
+	return &ret
+}
+
+// Op returns the operation of a.
+func (a *Aggregation) Op() AggregationOp { return a.op }
+
+// Property returns the property aggregated by a, or "" for
+// AggregationCount.
+func (a *Aggregation) Property() string { return a.prop }
+
+// Alias returns the name a is reported as.
+func (a *Aggregation) Alias() string { return a.alias }
+
+func (a *Aggregation) String() string {
+	if a.op == AggregationCount {
+		return fmt.Sprintf("%s(*) AS %q", a.op, a.alias)
+	}
+	return fmt.Sprintf("%s(%q) AS %q", a.op, a.prop, a.alias)
+}
+
+// AggregationResult maps the aliases of the Aggregations of an
+// AggregationQuery to their results.
+type AggregationResult map[string]interface{}
+
+// AggregationQuery is a Query with Aggregations. Create one with
+// Query.Aggregate.
+type AggregationQuery struct {
+	q    *Query
+	aggs []*Aggregation
+}
+
+// Aggregate returns an AggregationQuery computing aggs over the results of q,
+// e.g.:
+//
+//	aq := datastore.NewQuery("File").Eq("Owner", "me").Aggregate(
+//	    datastore.CountAs("total"), datastore.SumOf("Bytes"))
+//	res, err := datastore.RunAggregation(c, aq)
+//	// res["total"] is the number of files, res["Bytes"] their total size.
+func (q *Query) Aggregate(aggs ...*Aggregation) *AggregationQuery {
+	return &AggregationQuery{q, aggs}
+}
+
+// Query returns the query whose results are aggregated.
+func (aq *AggregationQuery) Query() *Query { return aq.q }
+
+// Aggregations returns the aggregations computed by aq.
+func (aq *AggregationQuery) Aggregations() []*Aggregation {
+	return append([]*Aggregation(nil), aq.aggs...)
+}
+
+// Validate returns an error if the aggregations of aq are invalid.
+func (aq *AggregationQuery) Validate() error {
+	if len(aq.aggs) == 0 {
+		return fmt.Errorf("datastore: aggregation query has no aggregations")
+	}
+	aliases := make(map[string]struct{}, len(aq.aggs))
+	for _, a := range aq.aggs {
+		if a == nil {
+			return fmt.Errorf("datastore: aggregation query has a nil aggregation")
+		}
+		if a.alias == "" {
+			return fmt.Errorf("datastore: aggregation %s has no alias", a)
+		}
+		if _, ok := aliases[a.alias]; ok {
+			return fmt.Errorf("datastore: aggregation alias %q is used more than once", a.alias)
+		}
+		aliases[a.alias] = struct{}{}
+		if a.op != AggregationCount && (a.prop == "" || strings.HasPrefix(a.prop, "__")) {
+			return fmt.Errorf("datastore: aggregation %s has a bad property", a)
+		}
+	}
+	return nil
+}
+
+// RunAggregation runs aq and returns the results of its aggregations.
+//
+// Implementations use native aggregation queries where the backend supports
+// them, and otherwise scan the results of the query (see AggregateByScan).
+// Counts alone are computed like Count.
+func RunAggregation(c context.Context, aq *AggregationQuery) (AggregationResult, error) {
+	if err := aq.Validate(); err != nil {
+		return nil, err
+	}
+	fq, err := aq.q.Finalize()
+	if err != nil {
+		return nil, err
+	}
+	res, err := Raw(c).Aggregate(fq, aq.Aggregations())
+	return res, filterStop(err)
+}
+
+// aggregator accumulates the result of an Aggregation.
+type aggregator struct {
+	*Aggregation
+
+	n        int64
+	intSum   int64
+	floatSum float64
+	isFloat  bool
+}
+
+func (a *aggregator) add(pm PropertyMap) {
+	for _, v := range pm.Slice(a.prop) {
+		switch x := v.Value().(type) {
+		case int64:
+			if !a.isFloat {
+				if s := a.intSum + x; (x > 0 && s < a.intSum) || (x < 0 && s > a.intSum) {
+					// Overflow; continue in floating point.
+					a.isFloat = true
+					a.floatSum = float64(a.intSum)
+				} else {
+					a.intSum = s
+					a.n++
+					continue
+				}
+			}
+			a.floatSum += float64(x)
+		case float64:
+			if !a.isFloat {
+				a.isFloat = true
+				a.floatSum = float64(a.intSum)
+			}
+			a.floatSum += x
+		default:
+			continue
+		}
+		a.n++
+	}
+}
+
+func (a *aggregator) result() interface{} {
+	switch {
+	case a.op == AggregationAvg && a.n == 0:
+		return nil
+	case a.op == AggregationAvg && a.isFloat:
+		return a.floatSum / float64(a.n)
+	case a.op == AggregationAvg:
+		return float64(a.intSum) / float64(a.n)
+	case a.isFloat:
+		return a.floatSum
+	default:
+		return a.intSum
+	}
+}
+
+// AggregateByScan computes aggs over the results of fq by running it against
+// rds. It's intended for RawInterface implementations whose backend doesn't
+// support aggregation queries natively.
+//
+// If all of aggs are counts, the query is counted with rds.Count instead.
+func AggregateByScan(rds RawInterface, fq *FinalizedQuery, aggs []*Aggregation) (AggregationResult, error) {
+	ret := make(AggregationResult, len(aggs))
+	onlyCounts := true
+	for _, a := range aggs {
+		if a.op != AggregationCount {
+			onlyCounts = false
+		}
+	}
+	if onlyCounts {
+		n, err := rds.Count(fq)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range aggs {
+			ret[a.alias] = n
+		}
+		return ret, nil
+	}
+
+	count := int64(0)
+	as := make([]*aggregator, 0, len(aggs))
+	for _, a := range aggs {
+		if a.op != AggregationCount {
+			as = append(as, &aggregator{Aggregation: a})
+		}
+	}
+	err := rds.Run(fq, func(_ *Key, pm PropertyMap, _ CursorCB) error {
+		count++
+		for _, a := range as {
+			a.add(pm)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range aggs {
+		if a.op == AggregationCount {
+			ret[a.alias] = count
+		}
+	}
+	for _, a := range as {
+		ret[a.alias] = a.result()
+	}
+	return ret, nil
+}
@@ -0,0 +1,349 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package backup exports datastore entities, e.g. to Cloud Storage, and
+// imports them from Datastore managed exports.
+//
+// Entities can be exported in two formats:
+//   - JSONLines, which is easy to process with other tools. Each line is
+//     a JSON object with the entity's encoded "key", and its "properties".
+//     Each property is an object with the "type" of the value (the name of its
+//     datastore.PropertyType), the "value", and "noindex" if it isn't indexed,
+//     or a list of such objects if the property has multiple values.
+//   - ManagedExport, the format of the data files ("output-N") of the
+//     Datastore managed export service: each entity is a serialized
+//     EntityProto record of a LevelDB log. The metadata files of managed
+//     exports aren't written.
+//
+// Exporter.Export streams entities to an io.Writer in a single pass, which is
+// fine for tests and small datasets. StorageExport writes them to Cloud
+// Storage in batches, checkpointing its progress in the datastore, so that it
+// can be resumed in a later request (e.g. a task queue task) and scales to
+// large datasets. Both work with any datastore implementation, including
+// impl/memory.
+//
+// ReadManagedExport reads the entities in a managed export data file, whether
+// it was written by the managed export service or by this package, and
+// ImportManagedExport and ImportFromStorage put them in the datastore, e.g. to
+// seed a staging app or a test with a snapshot of production data.
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/storage"
+
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// Format is the format of exported entities.
+type Format int
+
+// The supported formats. See the package documentation.
+const (
+	JSONLines Format = iota
+	ManagedExport
+)
+
+// entityWriter writes entities in a format.
+type entityWriter interface {
+	writeEntity(key *ds.Key, pm ds.PropertyMap) error
+}
+
+func (f Format) newWriter(w io.Writer) (entityWriter, error) {
+	switch f {
+	case JSONLines:
+		return newJSONLWriter(w), nil
+	case ManagedExport:
+		return &managedWriter{log: logWriter{w: w}}, nil
+	default:
+		return nil, fmt.Errorf("backup: unknown format %d", f)
+	}
+}
+
+// objectName returns the name of the Cloud Storage object holding chunk of
+// the entities of kind in namespace ns.
+func (f Format) objectName(prefix, ns, kind string, chunk int) string {
+	name := fmt.Sprintf("%sns=%s/kind=%s/output-%d", prefix, ns, kind, chunk)
+	if f == JSONLines {
+		name += ".jsonl"
+	}
+	return name
+}
+
+func (f Format) contentType() string {
+	if f == JSONLines {
+		return "application/x-ndjson"
+	}
+	return "application/octet-stream"
+}
+
+// DefaultBatchSize is the default Exporter.BatchSize.
+const DefaultBatchSize = 500
+
+// Exporter exports the entities of some kinds.
+type Exporter struct {
+	// Format is the format to export in.
+	Format Format
+
+	// Kinds are the kinds of the entities to export. It must not be empty.
+	Kinds []string
+
+	// Namespaces are the namespaces to export the kinds from (see
+	// meta.Namespaces to list them). If empty, the current namespace is
+	// exported.
+	Namespaces []string
+
+	// BatchSize is the number of entities fetched by each query. For
+	// a StorageExport, it's also the number of entities in each Cloud Storage
+	// object. If zero, DefaultBatchSize is used.
+	BatchSize int
+}
+
+func (e *Exporter) namespaces(c context.Context) []string {
+	if len(e.Namespaces) > 0 {
+		return e.Namespaces
+	}
+	return []string{info.GetNamespace(c)}
+}
+
+func (e *Exporter) batchSize() int {
+	if e.BatchSize > 0 {
+		return e.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+// exportBatch writes up to a batch of the entities of kind in the namespace of
+// c to w, starting at cursor. It returns the number of entities written, and
+// the cursor after them if the batch is full.
+func (e *Exporter) exportBatch(c context.Context, w entityWriter, kind, cursor string) (n int, next string, err error) {
+	q := ds.NewQuery(kind).Limit(int32(e.batchSize()))
+	if cursor != "" {
+		cur, err := ds.DecodeCursor(c, cursor)
+		if err != nil {
+			return 0, "", errors.Annotate(err, "bad cursor").Err()
+		}
+		q = q.Start(cur)
+	}
+
+	err = ds.Run(c, q, func(pm ds.PropertyMap, getCursor ds.CursorCB) error {
+		key := ds.GetMetaDefault(pm, "key", nil).(*ds.Key)
+		if err := w.writeEntity(key, pm); err != nil {
+			return err
+		}
+		n++
+		if n == e.batchSize() {
+			cur, err := getCursor()
+			if err != nil {
+				return err
+			}
+			next = cur.String()
+		}
+		return nil
+	})
+	return n, next, err
+}
+
+// Export writes all of the entities of e.Kinds in e.Namespaces to w, and
+// returns the number of entities written.
+func (e *Exporter) Export(c context.Context, w io.Writer) (n int64, err error) {
+	if len(e.Kinds) == 0 {
+		return 0, errors.New("backup: no kinds to export")
+	}
+	ew, err := e.Format.newWriter(w)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, ns := range e.namespaces(c) {
+		nc, err := info.Namespace(c, ns)
+		if err != nil {
+			return n, err
+		}
+		for _, kind := range e.Kinds {
+			for cursor := ""; ; {
+				count, next, err := e.exportBatch(nc, ew, kind, cursor)
+				n += int64(count)
+				if err != nil {
+					return n, errors.Annotate(err, "failed to export %q in namespace %q", kind, ns).Err()
+				}
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+		}
+	}
+	return n, nil
+}
+
+// StorageExport exports entities to Cloud Storage, resumably.
+//
+// The entities of each kind in each namespace are written in batches, each to
+// an object named "<Prefix>ns=<namespace>/kind=<kind>/output-<N>", with
+// a ".jsonl" extension for JSONLines.
+type StorageExport struct {
+	Exporter
+
+	// ID identifies the export's checkpoint. Runs of a StorageExport with the
+	// same ID continue where the last one stopped, so a new export needs a new
+	// ID.
+	ID string
+
+	// Bucket is the Cloud Storage bucket to write to. If empty, the default
+	// bucket is used.
+	Bucket string
+
+	// Prefix is prepended to the name of each object, e.g. "backups/2017-01-01/".
+	Prefix string
+}
+
+// exportState is the checkpoint of a StorageExport. It's stored in the
+// namespace that the export is run in.
+type exportState struct {
+	_kind string `gae:"$kind,gae.backup.Export"`
+
+	ID string `gae:"$id"`
+
+	// NSIndex and KindIndex are the indexes of the namespace and kind being
+	// exported, and Cursor and Chunk are where their next batch starts, and its
+	// number.
+	NSIndex   int    `gae:",noindex"`
+	KindIndex int    `gae:",noindex"`
+	Cursor    string `gae:",noindex"`
+	Chunk     int    `gae:",noindex"`
+
+	Entities int64     `gae:",noindex"`
+	Objects  int64     `gae:",noindex"`
+	Done     bool      `gae:",noindex"`
+	Updated  time.Time `gae:",noindex"`
+}
+
+// Progress is the progress of a StorageExport.
+type Progress struct {
+	// Entities and Objects are the number of entities and Cloud Storage objects
+	// written so far.
+	Entities int64
+	Objects  int64
+	// Done is true if all of the entities have been exported.
+	Done bool
+	// Updated is when the export last made progress.
+	Updated time.Time
+}
+
+// Progress returns the progress of the export.
+func (e *StorageExport) Progress(c context.Context) (*Progress, error) {
+	st := &exportState{ID: e.ID}
+	switch err := ds.Get(c, st); err {
+	case nil, ds.ErrNoSuchEntity:
+		return &Progress{Entities: st.Entities, Objects: st.Objects, Done: st.Done, Updated: st.Updated}, nil
+	default:
+		return nil, err
+	}
+}
+
+// Run continues the export, writing up to maxObjects objects (or all of them,
+// if maxObjects is zero), and returns whether the export is done.
+//
+// Progress is checkpointed after each object, so if Run fails, or isn't done,
+// a later Run picks up from the last checkpoint. A batch which was written but
+// not checkpointed is rewritten to the same object.
+func (e *StorageExport) Run(c context.Context, maxObjects int) (done bool, err error) {
+	if e.ID == "" {
+		return false, errors.New("backup: the export has no ID")
+	}
+	if len(e.Kinds) == 0 {
+		return false, errors.New("backup: no kinds to export")
+	}
+	bucket := e.Bucket
+	if bucket == "" {
+		if bucket, err = storage.DefaultBucket(c); err != nil {
+			return false, errors.Annotate(err, "failed to get the default bucket").Err()
+		}
+	}
+
+	st := &exportState{ID: e.ID}
+	if err := ds.Get(c, st); err != nil && err != ds.ErrNoSuchEntity {
+		return false, errors.Annotate(err, "failed to get the checkpoint of %q", e.ID).Err()
+	}
+
+	nss := e.namespaces(c)
+	for written := 0; !st.Done && (maxObjects == 0 || written < maxObjects); {
+		ns, kind := nss[st.NSIndex], e.Kinds[st.KindIndex]
+		nc, err := info.Namespace(c, ns)
+		if err != nil {
+			return false, err
+		}
+
+		buf := bytes.Buffer{}
+		ew, err := e.Format.newWriter(&buf)
+		if err != nil {
+			return false, err
+		}
+		n, next, err := e.exportBatch(nc, ew, kind, st.Cursor)
+		if err != nil {
+			return false, errors.Annotate(err, "failed to export %q in namespace %q", kind, ns).Err()
+		}
+
+		if n > 0 {
+			name := e.Format.objectName(e.Prefix, ns, kind, st.Chunk)
+			if err := writeObject(c, bucket, name, e.Format.contentType(), buf.Bytes()); err != nil {
+				return false, errors.Annotate(err, "failed to write %q", name).Err()
+			}
+			st.Entities += int64(n)
+			st.Objects++
+			written++
+		}
+
+		if next != "" {
+			st.Cursor = next
+			st.Chunk++
+		} else {
+			st.Cursor, st.Chunk = "", 0
+			if st.KindIndex++; st.KindIndex == len(e.Kinds) {
+				st.KindIndex = 0
+				if st.NSIndex++; st.NSIndex == len(nss) {
+					st.Done = true
+				}
+			}
+		}
+
+		st.Updated = clock.Now(c).UTC()
+		if err := ds.Put(c, st); err != nil {
+			return false, errors.Annotate(err, "failed to checkpoint %q", e.ID).Err()
+		}
+	}
+	return st.Done, nil
+}
+
+func writeObject(c context.Context, bucket, name, contentType string, data []byte) error {
+	w, err := storage.NewWriter(c, bucket, name, &storage.WriterOptions{ContentType: contentType})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
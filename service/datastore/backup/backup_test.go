@@ -0,0 +1,298 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+	pb "go.chromium.org/gae/service/datastore/internal/protos/datastore"
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/storage"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+type foo struct {
+	ID     int64   `gae:"$id"`
+	Parent *ds.Key `gae:"$parent"`
+
+	Name    string
+	Tags    []string
+	When    time.Time
+	Blob    []byte `gae:",noindex"`
+	Ref     *ds.Key
+	Where   ds.GeoPoint
+	Score   float64
+	Enabled bool
+}
+
+type bar struct {
+	ID  string `gae:"$id"`
+	Val int64
+}
+
+// readLogRecords reads the records of a LevelDB log which only has FULL
+// records.
+func readLogRecords(data []byte) [][]byte {
+	var ret [][]byte
+	for len(data) >= logHeaderSize {
+		n := int(binary.LittleEndian.Uint16(data[4:6]))
+		if n == 0 && data[6] == 0 {
+			break // block padding
+		}
+		So(data[6], ShouldEqual, logFullType)
+		So(binary.LittleEndian.Uint32(data[:4]), ShouldEqual, logChecksum(data[6], data[7:7+n]))
+		ret = append(ret, data[7:7+n])
+		data = data[7+n:]
+	}
+	return ret
+}
+
+func TestBackup(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test backup", t, func() {
+		c := memory.Use(context.Background())
+		ds.GetTestable(c).Consistent(true)
+
+		when := time.Date(2017, 1, 2, 3, 4, 5, 6000, time.UTC)
+		foos := make([]*foo, 5)
+		for i := range foos {
+			foos[i] = &foo{
+				ID:      int64(i + 1),
+				Name:    "foo",
+				Tags:    []string{"a", "b"},
+				When:    when,
+				Blob:    []byte{1, 2, 3},
+				Ref:     ds.NewKey(c, "bar", "x", 0, nil),
+				Where:   ds.GeoPoint{Lat: 1, Lng: 2},
+				Score:   1.5,
+				Enabled: true,
+			}
+		}
+		So(ds.Put(c, foos), ShouldBeNil)
+		So(ds.Put(info.MustNamespace(c, "other"), &bar{ID: "x", Val: 7}), ShouldBeNil)
+
+		Convey("Export requires kinds", func() {
+			_, err := (&Exporter{}).Export(c, ioutil.Discard)
+			So(err, ShouldErrLike, "no kinds")
+		})
+
+		Convey("exports JSON lines", func() {
+			buf := bytes.Buffer{}
+			e := &Exporter{Kinds: []string{"foo", "bar"}, Namespaces: []string{"", "other"}, BatchSize: 2}
+			n, err := e.Export(c, &buf)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 6)
+
+			var lines []*jsonEntity
+			s := bufio.NewScanner(&buf)
+			for s.Scan() {
+				je := &jsonEntity{}
+				So(json.Unmarshal(s.Bytes(), je), ShouldBeNil)
+				lines = append(lines, je)
+			}
+			So(len(lines), ShouldEqual, 6)
+			So(lines[0].Key, ShouldEqual, ds.KeyForObj(c, foos[0]).Encode())
+			So(string(lines[0].Properties["Name"]), ShouldEqual, `{"type":"PTString","value":"foo"}`)
+			So(string(lines[0].Properties["Tags"]), ShouldEqual,
+				`[{"type":"PTString","value":"a"},{"type":"PTString","value":"b"}]`)
+			So(string(lines[0].Properties["When"]), ShouldEqual, `{"type":"PTTime","value":"2017-01-02T03:04:05.000006Z"}`)
+			So(string(lines[0].Properties["Blob"]), ShouldEqual, `{"type":"PTBytes","value":"AQID","noindex":true}`)
+			So(lines[5].Key, ShouldEqual, ds.NewKey(info.MustNamespace(c, "other"), "bar", "x", 0, nil).Encode())
+		})
+
+		Convey("exports managed export records", func() {
+			buf := bytes.Buffer{}
+			n, err := (&Exporter{Format: ManagedExport, Kinds: []string{"foo"}}).Export(c, &buf)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 5)
+
+			recs := readLogRecords(buf.Bytes())
+			So(len(recs), ShouldEqual, 5)
+			e := &pb.EntityProto{}
+			So(proto.Unmarshal(recs[0], e), ShouldBeNil)
+			So(e.Key.Path.Element[0].GetType(), ShouldEqual, "foo")
+			So(e.Key.Path.Element[0].GetId(), ShouldEqual, 1)
+
+			props := map[string]*pb.Property{}
+			for _, p := range e.Property {
+				props[p.GetName()] = p
+			}
+			So(props["When"].GetMeaning(), ShouldEqual, pb.Property_GD_WHEN)
+			So(props["When"].Value.GetInt64Value(), ShouldEqual, ds.TimeToInt(when))
+			So(props["Tags"].GetMultiple(), ShouldBeTrue)
+			So(props["Ref"].Value.Referencevalue.Pathelement[0].GetName(), ShouldEqual, "x")
+			So(len(e.RawProperty), ShouldEqual, 1)
+			So(e.RawProperty[0].GetMeaning(), ShouldEqual, pb.Property_BLOB)
+		})
+
+		Convey("splits long records", func() {
+			buf := bytes.Buffer{}
+			l := logWriter{w: &buf}
+			So(l.writeRecord(make([]byte, logBlockSize)), ShouldBeNil)
+			data := buf.Bytes()
+			So(data[6], ShouldEqual, logFirstType)
+			So(data[logBlockSize+6], ShouldEqual, logLastType)
+			So(len(data), ShouldEqual, logBlockSize+2*logHeaderSize)
+		})
+
+		Convey("StorageExport", func() {
+			storage.GetTestable(c).SetDefaultBucket("bucket")
+			e := &StorageExport{
+				Exporter: Exporter{Kinds: []string{"foo", "bar"}, Namespaces: []string{"", "other"}, BatchSize: 2},
+				ID:       "export",
+				Prefix:   "backup/",
+			}
+
+			Convey("requires an ID", func() {
+				_, err := (&StorageExport{Exporter: e.Exporter}).Run(c, 0)
+				So(err, ShouldErrLike, "no ID")
+			})
+
+			Convey("is resumable", func() {
+				done, err := e.Run(c, 2)
+				So(err, ShouldBeNil)
+				So(done, ShouldBeFalse)
+
+				p, err := e.Progress(c)
+				So(err, ShouldBeNil)
+				So(p, ShouldResemble, &Progress{Entities: 4, Objects: 2, Updated: p.Updated})
+
+				done, err = e.Run(c, 0)
+				So(err, ShouldBeNil)
+				So(done, ShouldBeTrue)
+
+				var names []string
+				So(storage.List(c, "bucket", "backup/", func(a *storage.ObjectAttrs) error {
+					names = append(names, a.Name)
+					return nil
+				}), ShouldBeNil)
+				So(names, ShouldResemble, []string{
+					"backup/ns=/kind=foo/output-0.jsonl",
+					"backup/ns=/kind=foo/output-1.jsonl",
+					"backup/ns=/kind=foo/output-2.jsonl",
+					"backup/ns=other/kind=bar/output-0.jsonl",
+				})
+
+				p, err = e.Progress(c)
+				So(err, ShouldBeNil)
+				So(p.Entities, ShouldEqual, 6)
+				So(p.Done, ShouldBeTrue)
+
+				Convey("and done", func() {
+					done, err := e.Run(c, 0)
+					So(err, ShouldBeNil)
+					So(done, ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("imports managed exports", func() {
+			buf := bytes.Buffer{}
+			e := &Exporter{Format: ManagedExport, Kinds: []string{"foo", "bar"}, Namespaces: []string{"", "other"}}
+			_, err := e.Export(c, &buf)
+			So(err, ShouldBeNil)
+			exported := buf.Bytes()
+
+			// Load into a fresh datastore.
+			ic := memory.UseWithAppID(context.Background(), "dev~other-app")
+			ds.GetTestable(ic).Consistent(true)
+			n, err := ImportManagedExport(ic, bytes.NewReader(exported), 2)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 6)
+
+			got := &foo{ID: 3}
+			So(ds.Get(ic, got), ShouldBeNil)
+			want := *foos[2]
+			want.Ref = ds.NewKey(ic, "bar", "x", 0, nil)
+			So(got, ShouldResemble, &want)
+
+			b := &bar{ID: "x"}
+			So(ds.Get(info.MustNamespace(ic, "other"), b), ShouldBeNil)
+			So(b.Val, ShouldEqual, 7)
+
+			Convey("preserving single and multiple values", func() {
+				pm := ds.PropertyMap{}
+				So(ds.PopulateKey(pm, ds.NewKey(ic, "foo", "", 1, nil)), ShouldBeTrue)
+				So(ds.Get(ic, pm), ShouldBeNil)
+				_, single := pm["Name"].(ds.Property)
+				_, multiple := pm["Tags"].(ds.PropertySlice)
+				So(single, ShouldBeTrue)
+				So(multiple, ShouldBeTrue)
+			})
+
+			Convey("from Cloud Storage", func() {
+				storage.GetTestable(c).SetDefaultBucket("bucket")
+				So(writeObject(c, "bucket", "exp/all_namespaces/kind_foo/output-0", "", exported), ShouldBeNil)
+				So(writeObject(c, "bucket", "exp/all_namespaces/kind_foo/all_namespaces_kind_foo.export_metadata", "", []byte("junk")), ShouldBeNil)
+
+				So(ds.Delete(c, foos), ShouldBeNil)
+				n, err := ImportFromStorage(c, "bucket", "exp/", 0)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 6)
+				So(ds.Get(c, foos), ShouldBeNil)
+			})
+		})
+
+		Convey("reads records spanning blocks", func() {
+			buf := bytes.Buffer{}
+			l := logWriter{w: &buf}
+			recs := [][]byte{
+				bytes.Repeat([]byte{1}, 100),
+				bytes.Repeat([]byte{2}, 3*logBlockSize),
+				bytes.Repeat([]byte{3}, logBlockSize-3*logHeaderSize-100-3),
+				{4},
+			}
+			for _, r := range recs {
+				So(l.writeRecord(r), ShouldBeNil)
+			}
+
+			r := &logReader{r: bufio.NewReader(bytes.NewReader(buf.Bytes()))}
+			for _, want := range recs {
+				got, err := r.readRecord()
+				So(err, ShouldBeNil)
+				So(got, ShouldResemble, want)
+			}
+			_, err := r.readRecord()
+			So(err, ShouldEqual, io.EOF)
+
+			Convey("and detects corruption", func() {
+				data := buf.Bytes()
+				data[logHeaderSize+10]++
+				r := &logReader{r: bufio.NewReader(bytes.NewReader(data))}
+				_, err := r.readRecord()
+				So(err, ShouldEqual, ErrCorruptLog)
+
+				r = &logReader{r: bufio.NewReader(bytes.NewReader(data[:logHeaderSize+10]))}
+				_, err = r.readRecord()
+				So(err, ShouldEqual, ErrCorruptLog)
+			})
+		})
+	})
+}
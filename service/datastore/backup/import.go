@@ -0,0 +1,324 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.chromium.org/gae/service/blobstore"
+	ds "go.chromium.org/gae/service/datastore"
+	pb "go.chromium.org/gae/service/datastore/internal/protos/datastore"
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/storage"
+
+	"go.chromium.org/luci/common/errors"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+)
+
+// ErrCorruptLog is returned when a managed export file isn't a valid LevelDB
+// log.
+var ErrCorruptLog = errors.New("backup: corrupt LevelDB log")
+
+// logReader reads records from a LevelDB log.
+type logReader struct {
+	r *bufio.Reader
+
+	// offset is the offset in the current block.
+	offset int
+}
+
+// readRecord returns the next record, or io.EOF if there are no more.
+func (l *logReader) readRecord() ([]byte, error) {
+	var record []byte
+	inRecord := false
+	for {
+		if logBlockSize-l.offset < logHeaderSize {
+			// Skip the padding at the end of the block.
+			if _, err := l.r.Discard(logBlockSize - l.offset); err != nil {
+				return nil, l.eof(inRecord, err)
+			}
+			l.offset = 0
+		}
+
+		var header [logHeaderSize]byte
+		if _, err := io.ReadFull(l.r, header[:]); err != nil {
+			return nil, l.eof(inRecord, err)
+		}
+		n := int(binary.LittleEndian.Uint16(header[4:6]))
+		typ := header[6]
+		if l.offset+logHeaderSize+n > logBlockSize {
+			return nil, ErrCorruptLog
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(l.r, data); err != nil {
+			return nil, l.eof(true, err)
+		}
+		l.offset += logHeaderSize + n
+
+		if typ == 0 && n == 0 {
+			// Zero-filled space, which some writers leave at the end of a block.
+			if _, err := l.r.Discard(logBlockSize - l.offset); err != nil {
+				return nil, l.eof(inRecord, err)
+			}
+			l.offset = 0
+			continue
+		}
+		if binary.LittleEndian.Uint32(header[:4]) != logChecksum(typ, data) {
+			return nil, ErrCorruptLog
+		}
+
+		switch {
+		case typ == logFullType && !inRecord:
+			return data, nil
+		case typ == logFirstType && !inRecord:
+			record, inRecord = data, true
+		case typ == logMiddleType && inRecord:
+			record = append(record, data...)
+		case typ == logLastType && inRecord:
+			return append(record, data...), nil
+		default:
+			return nil, ErrCorruptLog
+		}
+	}
+}
+
+// eof translates an error reading the underlying reader. Running out of data
+// between records is the end of the log, and anywhere else is corruption.
+func (l *logReader) eof(inRecord bool, err error) error {
+	switch {
+	case err != io.EOF && err != io.ErrUnexpectedEOF:
+		return err
+	case inRecord || err == io.ErrUnexpectedEOF:
+		return ErrCorruptLog
+	default:
+		return io.EOF
+	}
+}
+
+// ReadManagedExport calls cb with the key and properties of each entity in r,
+// a data file ("output-N") of a Datastore managed export.
+//
+// Keys are made in the app of c, so that an export of one app can be loaded
+// into another, but keep the namespaces that they were exported from.
+//
+// If cb returns an error, ReadManagedExport stops and returns it.
+func ReadManagedExport(c context.Context, r io.Reader, cb func(key *ds.Key, pm ds.PropertyMap) error) error {
+	appID := ds.GetKeyContext(c).AppID
+	l := &logReader{r: bufio.NewReader(r)}
+	for {
+		data, err := l.readRecord()
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+
+		e := &pb.EntityProto{}
+		if err := proto.Unmarshal(data, e); err != nil {
+			return errors.Annotate(err, "failed to decode entity").Err()
+		}
+		key, pm, err := fromEntityProto(appID, e)
+		if err != nil {
+			return err
+		}
+		if err := cb(key, pm); err != nil {
+			return err
+		}
+	}
+}
+
+// ImportManagedExport puts all of the entities in r, a data file of
+// a Datastore managed export (see ReadManagedExport), in batches of batchSize
+// (or DefaultBatchSize, if zero). Existing entities with the same keys are
+// overwritten. It returns the number of entities put.
+//
+// This works with any datastore implementation, e.g. to seed impl/memory with
+// a snapshot of production data in tests.
+func ImportManagedExport(c context.Context, r io.Reader, batchSize int) (n int64, err error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	var batch []ds.PropertyMap
+	flush := func() error {
+		if err := putInNamespaces(c, batch); err != nil {
+			return err
+		}
+		n += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	err = ReadManagedExport(c, r, func(key *ds.Key, pm ds.PropertyMap) error {
+		ds.PopulateKey(pm, key)
+		if batch = append(batch, pm); len(batch) == batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+	return n, err
+}
+
+// putInNamespaces puts pms, each in the namespace of its key.
+func putInNamespaces(c context.Context, pms []ds.PropertyMap) error {
+	byNS := map[string][]ds.PropertyMap{}
+	var order []string
+	for _, pm := range pms {
+		ns := ds.GetMetaDefault(pm, "key", nil).(*ds.Key).Namespace()
+		if _, ok := byNS[ns]; !ok {
+			order = append(order, ns)
+		}
+		byNS[ns] = append(byNS[ns], pm)
+	}
+	for _, ns := range order {
+		nc, err := info.Namespace(c, ns)
+		if err != nil {
+			return err
+		}
+		if err := ds.Put(nc, byNS[ns]); err != nil {
+			return errors.Annotate(err, "failed to put entities in namespace %q", ns).Err()
+		}
+	}
+	return nil
+}
+
+// ImportFromStorage imports the data files of the Datastore managed export
+// in bucket whose names begin with prefix (see ImportManagedExport). Data files
+// are the objects whose base name begins with "output-"; other objects, like
+// the export's metadata, are skipped. It returns the number of entities put.
+func ImportFromStorage(c context.Context, bucket, prefix string, batchSize int) (n int64, err error) {
+	var names []string
+	err = storage.List(c, bucket, prefix, func(a *storage.ObjectAttrs) error {
+		if strings.HasPrefix(a.Name[strings.LastIndex(a.Name, "/")+1:], "output-") {
+			names = append(names, a.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Annotate(err, "failed to list %q", prefix).Err()
+	}
+
+	for _, name := range names {
+		r, err := storage.NewReader(c, bucket, name)
+		if err != nil {
+			return n, errors.Annotate(err, "failed to open %q", name).Err()
+		}
+		count, err := ImportManagedExport(c, r, batchSize)
+		r.Close()
+		n += count
+		if err != nil {
+			return n, errors.Annotate(err, "failed to import %q", name).Err()
+		}
+	}
+	return n, nil
+}
+
+func referenceToKey(appID, ns string, elems []*pb.Path_Element) (*ds.Key, error) {
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("backup: empty key")
+	}
+	toks := make([]ds.KeyTok, len(elems))
+	for i, e := range elems {
+		toks[i] = ds.KeyTok{Kind: e.GetType(), StringID: e.GetName(), IntID: e.GetId()}
+	}
+	return ds.MkKeyContext(appID, ns).NewKeyToks(toks), nil
+}
+
+// fromEntityProto converts an EntityProto to a key in appID, and properties.
+func fromEntityProto(appID string, e *pb.EntityProto) (*ds.Key, ds.PropertyMap, error) {
+	ref := e.GetKey()
+	key, err := referenceToKey(appID, ref.GetNameSpace(), ref.GetPath().GetElement())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pm := ds.PropertyMap{}
+	multiple := map[string]bool{}
+	add := func(props []*pb.Property, is ds.IndexSetting) error {
+		for _, p := range props {
+			v, err := fromPropertyValue(appID, p)
+			if err != nil {
+				return fmt.Errorf("backup: property %q of %s: %s", p.GetName(), key, err)
+			}
+			prop := ds.Property{}
+			if err := prop.SetValue(v, is); err != nil {
+				return fmt.Errorf("backup: property %q of %s: %s", p.GetName(), key, err)
+			}
+			name := p.GetName()
+			pm[name] = append(pm.Slice(name), prop)
+			multiple[name] = multiple[name] || p.GetMultiple()
+		}
+		return nil
+	}
+	if err := add(e.Property, ds.ShouldIndex); err != nil {
+		return nil, nil, err
+	}
+	if err := add(e.RawProperty, ds.NoIndex); err != nil {
+		return nil, nil, err
+	}
+
+	for name, data := range pm {
+		if s := data.(ds.PropertySlice); len(s) == 1 && !multiple[name] {
+			pm[name] = s[0]
+		}
+	}
+	return key, pm, nil
+}
+
+func fromPropertyValue(appID string, p *pb.Property) (interface{}, error) {
+	v := p.GetValue()
+	switch {
+	case v.Int64Value != nil:
+		if p.GetMeaning() == pb.Property_GD_WHEN {
+			return ds.IntToTime(v.GetInt64Value()), nil
+		}
+		return v.GetInt64Value(), nil
+	case v.BooleanValue != nil:
+		return v.GetBooleanValue(), nil
+	case v.StringValue != nil:
+		switch p.GetMeaning() {
+		case pb.Property_BLOB, pb.Property_BYTESTRING, pb.Property_ENTITY_PROTO:
+			return []byte(v.GetStringValue()), nil
+		case pb.Property_BLOBKEY:
+			return blobstore.Key(v.GetStringValue()), nil
+		}
+		return v.GetStringValue(), nil
+	case v.DoubleValue != nil:
+		return v.GetDoubleValue(), nil
+	case v.Pointvalue != nil:
+		return ds.GeoPoint{Lat: v.Pointvalue.GetX(), Lng: v.Pointvalue.GetY()}, nil
+	case v.Referencevalue != nil:
+		rv := v.Referencevalue
+		elems := make([]*pb.Path_Element, len(rv.Pathelement))
+		for i, e := range rv.Pathelement {
+			elems[i] = &pb.Path_Element{Type: e.Type, Id: e.Id, Name: e.Name}
+		}
+		return referenceToKey(appID, rv.GetNameSpace(), elems)
+	case v.Uservalue != nil:
+		return nil, fmt.Errorf("user values are not supported")
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.chromium.org/gae/service/blobstore"
+	ds "go.chromium.org/gae/service/datastore"
+)
+
+// jsonEntity is an entity in the JSON-lines format.
+type jsonEntity struct {
+	// Key is the encoded key of the entity.
+	Key string `json:"key"`
+
+	// Properties maps the name of each property to a jsonProperty, or to a list
+	// of them if it has multiple values.
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+// jsonProperty is a property value in the JSON-lines format.
+type jsonProperty struct {
+	// Type is the name of the ds.PropertyType of the value, e.g. "PTInt".
+	Type string `json:"type"`
+	// Value is the value. Its encoding depends on Type.
+	Value interface{} `json:"value"`
+	// NoIndex is true if the value isn't indexed.
+	NoIndex bool `json:"noindex,omitempty"`
+}
+
+// jsonlWriter writes entities in the JSON-lines format.
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	return &jsonlWriter{json.NewEncoder(w)}
+}
+
+func (w *jsonlWriter) writeEntity(key *ds.Key, pm ds.PropertyMap) error {
+	e, err := toJSONEntity(key, pm)
+	if err != nil {
+		return err
+	}
+	return w.enc.Encode(e)
+}
+
+func toJSONEntity(key *ds.Key, pm ds.PropertyMap) (*jsonEntity, error) {
+	ret := &jsonEntity{Key: key.Encode(), Properties: make(map[string]json.RawMessage, len(pm))}
+	for name, data := range pm {
+		if strings.HasPrefix(name, "$") {
+			continue
+		}
+
+		var v interface{}
+		switch data := data.(type) {
+		case ds.Property:
+			v = toJSONProperty(&data)
+		case ds.PropertySlice:
+			props := make([]*jsonProperty, len(data))
+			for i := range data {
+				props[i] = toJSONProperty(&data[i])
+			}
+			v = props
+		default:
+			return nil, fmt.Errorf("backup: property %q of %s is a %T", name, key, data)
+		}
+
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("backup: property %q of %s: %s", name, key, err)
+		}
+		ret.Properties[name] = raw
+	}
+	return ret, nil
+}
+
+func toJSONProperty(p *ds.Property) *jsonProperty {
+	ret := &jsonProperty{Type: p.Type().String(), NoIndex: p.IndexSetting() == ds.NoIndex}
+	switch v := p.Value().(type) {
+	case time.Time:
+		ret.Value = v.UTC().Format(time.RFC3339Nano)
+	case *ds.Key:
+		ret.Value = v.Encode()
+	case blobstore.Key:
+		ret.Value = string(v)
+	default:
+		// ints, floats, bools, strings, []byte (as base64) and GeoPoints encode
+		// as themselves.
+		ret.Value = v
+	}
+	return ret
+}
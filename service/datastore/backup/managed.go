@@ -0,0 +1,246 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"go.chromium.org/gae/service/blobstore"
+	ds "go.chromium.org/gae/service/datastore"
+	pb "go.chromium.org/gae/service/datastore/internal/protos/datastore"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// The managed export format stores each entity as a serialized EntityProto
+// record of a LevelDB log file.
+//
+// See https://github.com/google/leveldb/blob/master/doc/log_format.md.
+const (
+	logBlockSize  = 32 * 1024
+	logHeaderSize = 7 // checksum (4 bytes), length (2 bytes), type (1 byte)
+)
+
+// The types of LevelDB log record fragments.
+const (
+	logFullType   = 1
+	logFirstType  = 2
+	logMiddleType = 3
+	logLastType   = 4
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// logChecksum returns the masked CRC-32C of a fragment's type and data.
+func logChecksum(typ byte, data []byte) uint32 {
+	c := crc32.Update(crc32.Checksum([]byte{typ}, crc32c), crc32c, data)
+	return (c>>15 | c<<17) + 0xa282ead8
+}
+
+// logWriter writes records to a LevelDB log.
+type logWriter struct {
+	w io.Writer
+
+	// offset is the offset in the current block.
+	offset int
+}
+
+func (l *logWriter) writeRecord(data []byte) error {
+	first := true
+	for {
+		left := logBlockSize - l.offset
+		if left < logHeaderSize {
+			// Pad the rest of the block, which is too small for a header.
+			if _, err := l.w.Write(make([]byte, left)); err != nil {
+				return err
+			}
+			l.offset, left = 0, logBlockSize
+		}
+
+		n := len(data)
+		if avail := left - logHeaderSize; n > avail {
+			n = avail
+		}
+		last := n == len(data)
+
+		var typ byte
+		switch {
+		case first && last:
+			typ = logFullType
+		case first:
+			typ = logFirstType
+		case last:
+			typ = logLastType
+		default:
+			typ = logMiddleType
+		}
+
+		var header [logHeaderSize]byte
+		binary.LittleEndian.PutUint32(header[:4], logChecksum(typ, data[:n]))
+		binary.LittleEndian.PutUint16(header[4:6], uint16(n))
+		header[6] = typ
+		if _, err := l.w.Write(header[:]); err != nil {
+			return err
+		}
+		if _, err := l.w.Write(data[:n]); err != nil {
+			return err
+		}
+		l.offset += logHeaderSize + n
+
+		data, first = data[n:], false
+		if last {
+			return nil
+		}
+	}
+}
+
+// managedWriter writes entities in the managed export format.
+type managedWriter struct {
+	log logWriter
+}
+
+func (w *managedWriter) writeEntity(key *ds.Key, pm ds.PropertyMap) error {
+	e, err := toEntityProto(key, pm)
+	if err != nil {
+		return err
+	}
+	data, err := proto.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return w.log.writeRecord(data)
+}
+
+func keyToReference(k *ds.Key) *pb.Reference {
+	appID, ns, toks := k.Split()
+	ret := &pb.Reference{App: proto.String(appID), Path: &pb.Path{}}
+	if ns != "" {
+		ret.NameSpace = proto.String(ns)
+	}
+	if db := k.Database(); db != "" {
+		ret.DatabaseId = proto.String(db)
+	}
+	for _, t := range toks {
+		e := &pb.Path_Element{Type: proto.String(t.Kind)}
+		if t.StringID != "" {
+			e.Name = proto.String(t.StringID)
+		} else {
+			e.Id = proto.Int64(t.IntID)
+		}
+		ret.Path.Element = append(ret.Path.Element, e)
+	}
+	return ret
+}
+
+func keyToReferenceValue(k *ds.Key) *pb.PropertyValue_ReferenceValue {
+	r := keyToReference(k)
+	ret := &pb.PropertyValue_ReferenceValue{App: r.App, NameSpace: r.NameSpace}
+	for _, e := range r.Path.Element {
+		ret.Pathelement = append(ret.Pathelement, &pb.PropertyValue_ReferenceValue_PathElement{
+			Type: e.Type,
+			Id:   e.Id,
+			Name: e.Name,
+		})
+	}
+	return ret
+}
+
+// toEntityProto converts the entity with key and properties pm to an
+// EntityProto. Meta properties are skipped.
+func toEntityProto(key *ds.Key, pm ds.PropertyMap) (*pb.EntityProto, error) {
+	ref := keyToReference(key)
+	ret := &pb.EntityProto{
+		Key:         ref,
+		EntityGroup: &pb.Path{Element: ref.Path.Element[:1]},
+	}
+
+	names := make([]string, 0, len(pm))
+	for name := range pm {
+		if !strings.HasPrefix(name, "$") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		_, multiple := pm[name].(ds.PropertySlice)
+		for _, p := range pm.Slice(name) {
+			v, meaning, err := toPropertyValue(&p)
+			if err != nil {
+				return nil, fmt.Errorf("backup: property %q of %s: %s", name, key, err)
+			}
+			prop := &pb.Property{
+				Name:     proto.String(name),
+				Value:    v,
+				Multiple: proto.Bool(multiple),
+			}
+			if meaning != pb.Property_NO_MEANING {
+				prop.Meaning = meaning.Enum()
+			}
+			if p.IndexSetting() == ds.NoIndex {
+				ret.RawProperty = append(ret.RawProperty, prop)
+			} else {
+				ret.Property = append(ret.Property, prop)
+			}
+		}
+	}
+	return ret, nil
+}
+
+func toPropertyValue(p *ds.Property) (*pb.PropertyValue, pb.Property_Meaning, error) {
+	v := &pb.PropertyValue{}
+	meaning := pb.Property_NO_MEANING
+	switch p.Type() {
+	case ds.PTNull:
+	case ds.PTInt:
+		v.Int64Value = proto.Int64(p.Value().(int64))
+	case ds.PTTime:
+		v.Int64Value = proto.Int64(ds.TimeToInt(p.Value().(time.Time)))
+		meaning = pb.Property_GD_WHEN
+	case ds.PTBool:
+		v.BooleanValue = proto.Bool(p.Value().(bool))
+	case ds.PTString:
+		v.StringValue = proto.String(p.Value().(string))
+		if p.IndexSetting() == ds.NoIndex {
+			meaning = pb.Property_TEXT
+		}
+	case ds.PTBytes:
+		v.StringValue = proto.String(string(p.Value().([]byte)))
+		meaning = pb.Property_BYTESTRING
+		if p.IndexSetting() == ds.NoIndex {
+			meaning = pb.Property_BLOB
+		}
+	case ds.PTFloat:
+		v.DoubleValue = proto.Float64(p.Value().(float64))
+	case ds.PTGeoPoint:
+		gp := p.Value().(ds.GeoPoint)
+		v.Pointvalue = &pb.PropertyValue_PointValue{X: proto.Float64(gp.Lat), Y: proto.Float64(gp.Lng)}
+		meaning = pb.Property_GEORSS_POINT
+	case ds.PTKey:
+		v.Referencevalue = keyToReferenceValue(p.Value().(*ds.Key))
+	case ds.PTBlobKey:
+		v.StringValue = proto.String(string(p.Value().(blobstore.Key)))
+		meaning = pb.Property_BLOBKEY
+	default:
+		return nil, meaning, fmt.Errorf("unsupported type %s", p.Type())
+	}
+	return v, meaning, nil
+}
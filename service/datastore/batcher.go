@@ -25,20 +25,38 @@ import (
 
 func applyBatchFilter(c context.Context, rds RawInterface) RawInterface {
 	batchingEnabled, batchingSpecified := getBatching(c)
+	opts := getBatchOptions(c)
+
+	constraints := rds.Constraints()
+	constraints.MaxGetSize = capBatchSize(constraints.MaxGetSize, opts.MaxGetSize)
+	constraints.MaxPutSize = capBatchSize(constraints.MaxPutSize, opts.MaxPutSize)
+	constraints.MaxDeleteSize = capBatchSize(constraints.MaxDeleteSize, opts.MaxDeleteSize)
+
 	return &batchFilter{
 		RawInterface:      rds,
 		ic:                c,
-		constraints:       rds.Constraints(),
+		constraints:       constraints,
+		concurrency:       opts.Concurrency,
 		batchingSpecified: batchingSpecified,
 		batchingEnabled:   batchingEnabled,
 	}
 }
 
+// capBatchSize returns the smaller of the constraint and the size option. Zero
+// or negative values mean "unlimited".
+func capBatchSize(constraint, size int) int {
+	if size > 0 && (constraint <= 0 || size < constraint) {
+		return size
+	}
+	return constraint
+}
+
 type batchFilter struct {
 	RawInterface
 
 	ic                context.Context
 	constraints       Constraints
+	concurrency       int
 	batchingSpecified bool
 	batchingEnabled   bool
 }
@@ -86,8 +104,15 @@ func (bf *batchFilter) batchParallel(count, batch int, cb func(offset, count int
 		return cb(0, count)
 	}
 
-	// Dispatch our batches in parallel.
-	err := parallel.FanOutIn(func(workC chan<- func() error) {
+	// Dispatch our batches in parallel, limited to bf.concurrency at a time if
+	// it's set.
+	dispatch := parallel.FanOutIn
+	if bf.concurrency > 0 {
+		dispatch = func(gen func(chan<- func() error)) error {
+			return parallel.WorkPool(bf.concurrency, gen)
+		}
+	}
+	err := dispatch(func(workC chan<- func() error) {
 		for i := 0; i < count; {
 			offset := i
 			size := count - i
@@ -238,6 +263,11 @@ func (f *queryBatchingFilter) Run(fq *FinalizedQuery, cb RawRunCB) error {
 				nextCursor = cursor
 			}
 
+			// val is passed to cb after this returns, so it must be copied if it may
+			// be reused.
+			if val != nil && PropertyMapsReusable(f.ic) {
+				val, _ = val.Save(true)
+			}
 			buffer = append(buffer, batchEntry{
 				key:       key,
 				val:       val,
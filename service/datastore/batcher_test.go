@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/tuning"
 
 	"golang.org/x/net/context"
 
@@ -292,5 +293,51 @@ func TestBatchFilter(t *testing.T) {
 				})
 			})
 		}
+
+		Convey("With batch options", func(convey C) {
+			fds.convey = convey
+			fds.constraints.MaxGetSize = 10
+			fds.constraints.MaxPutSize = 10
+			fds.constraints.MaxDeleteSize = 10
+
+			css := make([]*IndexEntity, 10)
+			for i := range css {
+				css[i] = &IndexEntity{Value: int64(i + 1)}
+			}
+
+			Convey("smaller batch sizes split operations further", func() {
+				c := WithBatchOptions(c, BatchOptions{MaxGetSize: 3, MaxPutSize: 2, MaxDeleteSize: 5, Concurrency: 2})
+
+				So(Put(c, css), ShouldBeNil)
+				So(cf.put, ShouldEqual, 5)
+				So(Get(c, css), ShouldBeNil)
+				So(cf.get, ShouldEqual, 4)
+				for i, ent := range css {
+					So(ent.Value, ShouldEqual, i+1)
+				}
+				So(Delete(c, css), ShouldBeNil)
+				So(cf.delete, ShouldEqual, 2)
+			})
+
+			Convey("tuning options are used without batch options", func() {
+				c := tuning.Set(c, &tuning.Options{Datastore: tuning.Datastore{MaxPutSize: 3}})
+
+				So(Put(c, css), ShouldBeNil)
+				So(cf.put, ShouldEqual, 4)
+
+				Convey("but batch options take precedence", func() {
+					c := WithBatchOptions(c, BatchOptions{MaxPutSize: 5})
+					So(Put(c, css), ShouldBeNil)
+					So(cf.put, ShouldEqual, 6)
+				})
+			})
+
+			Convey("larger batch sizes don't exceed the constraints", func() {
+				c := WithBatchOptions(c, BatchOptions{MaxPutSize: 100})
+
+				So(Put(c, append(css, css...)), ShouldBeNil)
+				So(cf.put, ShouldEqual, 2)
+			})
+		})
 	})
 }
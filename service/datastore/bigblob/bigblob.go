@@ -0,0 +1,364 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bigblob stores byte payloads larger than the datastore entity size
+// limit (about 1MiB) by splitting them across several chunk entities.
+//
+// A blob is made of a manifest entity, which records the size of the blob and
+// a checksum of each chunk, and of the chunk entities, which are children of
+// the manifest. All of the entities of a blob are thus in one entity group,
+// which is the group of Blob.Parent if it's set, and Put, Get and Delete are
+// transactional: they use the current transaction if there is one, and
+// otherwise run in their own. Setting Parent to the key of the entity the blob
+// belongs to allows updating both in the same transaction:
+//
+//	b := &bigblob.Blob{Parent: ds.KeyForObj(c, report), Name: "rendered"}
+//	err := ds.RunInTransaction(c, func(c context.Context) error {
+//	  if err := b.Put(c, data); err != nil {
+//	    return err
+//	  }
+//	  return ds.Put(c, report)
+//	}, nil)
+//
+// Transactions are limited in size too (10MiB on Cloud Datastore), which
+// bounds the size of a blob. Blobs with NonTransactional set aren't bounded:
+// their chunks are written outside of any transaction, and only the manifest,
+// which is written last, is written transactionally.
+package bigblob
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	ds "go.chromium.org/gae/service/datastore"
+
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultChunkSize is the chunk size of a Blob with no ChunkSize set. It
+// leaves room for the key and the other overhead of the chunk entities below
+// the entity size limit.
+const DefaultChunkSize = 1000 * 1024
+
+// maxPutSize is the maximum total size of the chunks written by each of the
+// datastore Puts of a NonTransactional Put. It's below the size limit of a
+// datastore commit (10MiB on Cloud Datastore).
+const maxPutSize = 8 * 1024 * 1024
+
+// CorruptError is returned by Get when a chunk of a blob is missing or
+// doesn't match the checksum in the manifest.
+type CorruptError struct {
+	// Key is the key of the manifest of the blob.
+	Key *ds.Key
+	// Chunk is the index of the corrupt chunk.
+	Chunk int
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("bigblob: chunk %d of %s is corrupt", e.Chunk, e.Key)
+}
+
+// manifest is the datastore entity describing a blob.
+type manifest struct {
+	_kind string `gae:"$kind,gae.bigblob.Manifest"`
+
+	Name   string  `gae:"$id"`
+	Parent *ds.Key `gae:"$parent"`
+
+	// Size is the total size of the blob, in bytes.
+	Size int64 `gae:",noindex"`
+	// Sums is the concatenation of the SHA-256 sums of the chunks, in order.
+	Sums []byte `gae:",noindex"`
+	// Generation is the ID of the generation the chunks belong to, or zero if
+	// they're the children of the manifest. See generation.
+	Generation int64 `gae:",noindex"`
+}
+
+func (m *manifest) chunks() int {
+	return len(m.Sums) / sha256.Size
+}
+
+// generation is the parent of the chunks written by a NonTransactional Put,
+// under the manifest. It has no entity: it only keeps the chunks of each Put
+// apart, so that the chunks the manifest refers to are never overwritten.
+type generation struct {
+	_kind string `gae:"$kind,gae.bigblob.Generation"`
+
+	ID     int64   `gae:"$id"`
+	Parent *ds.Key `gae:"$parent"`
+}
+
+// chunk is the datastore entity of a chunk of a blob. Its ID is its index in
+// the blob plus one, and its parent is the manifest, or the generation of the
+// manifest if it has one.
+type chunk struct {
+	_kind string `gae:"$kind,gae.bigblob.Chunk"`
+
+	ID     int64   `gae:"$id"`
+	Parent *ds.Key `gae:"$parent"`
+
+	Data []byte `gae:",noindex"`
+}
+
+// Blob is a large byte payload stored across several entities.
+type Blob struct {
+	// Parent is the optional parent of the blob, whose entity group the blob is
+	// stored in.
+	Parent *ds.Key
+
+	// Name identifies the blob among those with the same Parent. It must not be
+	// empty.
+	Name string
+
+	// ChunkSize is the size of the chunks the blob is split into by Put. If
+	// zero, DefaultChunkSize is used. Changing it doesn't affect blobs which
+	// are already stored.
+	ChunkSize int
+
+	// NonTransactional, if true, makes Put and Delete ignore the current
+	// transaction and write the chunks outside of any transaction, so that the
+	// size of the blob isn't bounded by the size of a transaction.
+	//
+	// Put writes the new chunks first, and then replaces the manifest in a
+	// transaction of its own, so that Get returns either the old or the new
+	// contents. The old chunks are deleted last. If Put fails, the chunks it
+	// wrote may be left behind, unreferenced.
+	NonTransactional bool
+}
+
+func (b *Blob) chunkSize() int {
+	if b.ChunkSize > 0 {
+		return b.ChunkSize
+	}
+	return DefaultChunkSize
+}
+
+func (b *Blob) manifest() *manifest {
+	return &manifest{Name: b.Name, Parent: b.Parent}
+}
+
+// chunksParent returns the parent of the chunks of the given generation.
+func (b *Blob) chunksParent(c context.Context, gen int64) *ds.Key {
+	mkey := ds.KeyForObj(c, b.manifest())
+	if gen == 0 {
+		return mkey
+	}
+	return ds.KeyForObj(c, &generation{ID: gen, Parent: mkey})
+}
+
+func (b *Blob) chunkKeys(c context.Context, gen int64, start, end int) []*ds.Key {
+	parent := b.chunksParent(c, gen)
+	keys := make([]*ds.Key, 0, end-start)
+	for i := start; i < end; i++ {
+		keys = append(keys, ds.KeyForObj(c, &chunk{ID: int64(i + 1), Parent: parent}))
+	}
+	return keys
+}
+
+// split splits data into the chunks of m, whose parent is parent, and sets the
+// Size and Sums of m.
+func (b *Blob) split(m *manifest, parent *ds.Key, data []byte) []*chunk {
+	m.Size = int64(len(data))
+	m.Sums = nil
+	size := b.chunkSize()
+	chunks := make([]*chunk, 0, (len(data)+size-1)/size)
+	for start := 0; start < len(data); start += size {
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha256.Sum256(data[start:end])
+		m.Sums = append(m.Sums, sum[:]...)
+		chunks = append(chunks, &chunk{ID: int64(len(chunks) + 1), Parent: parent, Data: data[start:end]})
+	}
+	return chunks
+}
+
+// runInTransaction runs cb in the current transaction, or in a new one if
+// there is none.
+func (b *Blob) runInTransaction(c context.Context, cb func(context.Context) error) error {
+	if b.Name == "" {
+		return errors.New("bigblob: empty name")
+	}
+	if ds.CurrentTransaction(c) != nil {
+		return cb(c)
+	}
+	return ds.RunInTransaction(c, cb, nil)
+}
+
+// Put stores data in the blob, replacing its previous contents.
+func (b *Blob) Put(c context.Context, data []byte) error {
+	var err error
+	if b.NonTransactional {
+		err = b.putNonTransactional(ds.WithoutTransaction(c), data)
+	} else {
+		err = b.runInTransaction(c, func(c context.Context) error {
+			old := b.manifest()
+			if err := ds.Get(c, old); err != nil && err != ds.ErrNoSuchEntity {
+				return err
+			}
+
+			m := b.manifest()
+			chunks := b.split(m, ds.KeyForObj(c, m), data)
+			if err := ds.Put(c, m, chunks); err != nil {
+				return err
+			}
+			// The new chunks overwrote the old ones with the same keys.
+			switch {
+			case old.Generation != 0:
+				return ds.Delete(c, b.chunkKeys(c, old.Generation, 0, old.chunks()))
+			case old.chunks() > len(chunks):
+				return ds.Delete(c, b.chunkKeys(c, 0, len(chunks), old.chunks()))
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return errors.Annotate(err, "failed to put blob %q", b.Name).Err()
+	}
+	return nil
+}
+
+func (b *Blob) putNonTransactional(c context.Context, data []byte) error {
+	if b.Name == "" {
+		return errors.New("bigblob: empty name")
+	}
+
+	gen := &generation{Parent: ds.KeyForObj(c, b.manifest())}
+	if err := ds.AllocateIDs(c, gen); err != nil {
+		return err
+	}
+	m := b.manifest()
+	m.Generation = gen.ID
+	chunks := b.split(m, ds.KeyForObj(c, gen), data)
+	perPut := maxPutSize / b.chunkSize()
+	if perPut < 1 {
+		perPut = 1
+	}
+	for start := 0; start < len(chunks); start += perPut {
+		end := start + perPut
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		if err := ds.Put(c, chunks[start:end]); err != nil {
+			return err
+		}
+	}
+
+	var old *manifest
+	err := ds.RunInTransaction(c, func(c context.Context) error {
+		old = b.manifest()
+		if err := ds.Get(c, old); err != nil && err != ds.ErrNoSuchEntity {
+			return err
+		}
+		return ds.Put(c, m)
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return ds.Delete(c, b.chunkKeys(c, old.Generation, 0, old.chunks()))
+}
+
+// Get returns the contents of the blob.
+//
+// Returns ds.ErrNoSuchEntity if the blob doesn't exist, and a *CorruptError
+// if one of its chunks is missing or doesn't match its checksum.
+func (b *Blob) Get(c context.Context) ([]byte, error) {
+	var data []byte
+	err := b.runInTransaction(c, func(c context.Context) error {
+		data = nil
+		m := b.manifest()
+		if err := ds.Get(c, m); err != nil {
+			return err
+		}
+
+		chunks := make([]*chunk, m.chunks())
+		keys := b.chunkKeys(c, m.Generation, 0, len(chunks))
+		for i := range chunks {
+			chunks[i] = &chunk{}
+			ds.PopulateKey(chunks[i], keys[i])
+		}
+		if err := ds.Get(c, chunks); err != nil {
+			me, ok := err.(errors.MultiError)
+			if !ok {
+				return err
+			}
+			for i, err := range me {
+				switch err {
+				case nil:
+				case ds.ErrNoSuchEntity:
+					return &CorruptError{Key: ds.KeyForObj(c, m), Chunk: i}
+				default:
+					return err
+				}
+			}
+		}
+
+		data = make([]byte, 0, m.Size)
+		for i, ch := range chunks {
+			sum := sha256.Sum256(ch.Data)
+			if !bytes.Equal(sum[:], m.Sums[i*sha256.Size:(i+1)*sha256.Size]) {
+				return &CorruptError{Key: ds.KeyForObj(c, m), Chunk: i}
+			}
+			data = append(data, ch.Data...)
+		}
+		if int64(len(data)) != m.Size {
+			return fmt.Errorf("bigblob: %s has %d bytes instead of %d", ds.KeyForObj(c, m), len(data), m.Size)
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(*CorruptError); ok || err == ds.ErrNoSuchEntity {
+			return nil, err
+		}
+		return nil, errors.Annotate(err, "failed to get blob %q", b.Name).Err()
+	}
+	return data, nil
+}
+
+// Delete deletes the blob. It does nothing if the blob doesn't exist.
+//
+// If the blob is NonTransactional, the manifest is deleted first, in a
+// transaction of its own, and then the chunks.
+func (b *Blob) Delete(c context.Context) error {
+	if b.NonTransactional {
+		c = ds.WithoutTransaction(c)
+	}
+	var m *manifest
+	err := b.runInTransaction(c, func(c context.Context) error {
+		m = b.manifest()
+		switch err := ds.Get(c, m); err {
+		case nil:
+		case ds.ErrNoSuchEntity:
+			m = nil
+			return nil
+		default:
+			return err
+		}
+		if b.NonTransactional {
+			return ds.Delete(c, m)
+		}
+		return ds.Delete(c, m, b.chunkKeys(c, m.Generation, 0, m.chunks()))
+	})
+	if err == nil && m != nil && b.NonTransactional {
+		err = ds.Delete(c, b.chunkKeys(c, m.Generation, 0, m.chunks()))
+	}
+	if err != nil {
+		return errors.Annotate(err, "failed to delete blob %q", b.Name).Err()
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigblob
+
+import (
+	"bytes"
+	"testing"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+// putRecorder records the number of entities of each PutMulti.
+type putRecorder struct {
+	ds.RawInterface
+
+	sizes *[]int
+}
+
+func (p *putRecorder) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.NewKeyCB) error {
+	*p.sizes = append(*p.sizes, len(keys))
+	return p.RawInterface.PutMulti(keys, vals, cb)
+}
+
+func TestBlob(t *testing.T) {
+	t.Parallel()
+
+	Convey("Blob", t, func() {
+		c := memory.Use(context.Background())
+		ds.GetTestable(c).Consistent(true)
+
+		b := &Blob{Name: "blob", ChunkSize: 10}
+		data := bytes.Repeat([]byte("0123456789abcdef"), 4) // 64 bytes, 7 chunks
+		So(b.Put(c, data), ShouldBeNil)
+
+		countChunks := func() int {
+			n, err := ds.Count(c, ds.NewQuery("gae.bigblob.Chunk"))
+			So(err, ShouldBeNil)
+			return int(n)
+		}
+		So(countChunks(), ShouldEqual, 7)
+
+		Convey("can be read back", func() {
+			got, err := b.Get(c)
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, data)
+
+			// The chunk size doesn't matter for reading.
+			got, err = (&Blob{Name: "blob"}).Get(c)
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, data)
+		})
+
+		Convey("can be overwritten", func() {
+			So(b.Put(c, []byte("short")), ShouldBeNil)
+			So(countChunks(), ShouldEqual, 1)
+			got, err := b.Get(c)
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, []byte("short"))
+
+			So(b.Put(c, nil), ShouldBeNil)
+			So(countChunks(), ShouldEqual, 0)
+			got, err = b.Get(c)
+			So(err, ShouldBeNil)
+			So(got, ShouldHaveLength, 0)
+		})
+
+		Convey("can be deleted", func() {
+			So(b.Delete(c), ShouldBeNil)
+			So(countChunks(), ShouldEqual, 0)
+			_, err := b.Get(c)
+			So(err, ShouldEqual, ds.ErrNoSuchEntity)
+
+			So(b.Delete(c), ShouldBeNil)
+		})
+
+		Convey("detects corruption", func() {
+			mkey := ds.KeyForObj(c, b.manifest())
+			ch := &chunk{ID: 3, Parent: mkey}
+			So(ds.Get(c, ch), ShouldBeNil)
+
+			Convey("of the data", func() {
+				ch.Data[0] = 'X'
+				So(ds.Put(c, ch), ShouldBeNil)
+				_, err := b.Get(c)
+				So(err, ShouldResemble, &CorruptError{Key: mkey, Chunk: 2})
+			})
+
+			Convey("of missing chunks", func() {
+				So(ds.Delete(c, ch), ShouldBeNil)
+				_, err := b.Get(c)
+				So(err, ShouldErrLike, "chunk 2 of")
+			})
+		})
+
+		Convey("can share a transaction with its parent", func() {
+			type report struct {
+				ID      int64 `gae:"$id"`
+				Version int
+			}
+			r := &report{ID: 11, Version: 1}
+			rb := &Blob{Parent: ds.KeyForObj(c, r), Name: "rendered", ChunkSize: 10}
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				if err := rb.Put(c, data); err != nil {
+					return err
+				}
+				return ds.Put(c, r)
+			}, nil), ShouldBeNil)
+
+			got, err := rb.Get(c)
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, data)
+
+			// Blobs with different parents are independent.
+			got, err = b.Get(c)
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, data)
+		})
+
+		Convey("can be written non-transactionally", func() {
+			nb := &Blob{Name: "big", ChunkSize: 10, NonTransactional: true}
+			So(nb.Put(c, data), ShouldBeNil)
+			So(countChunks(), ShouldEqual, 14)
+			got, err := nb.Get(c)
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, data)
+
+			Convey("replacing the old chunks", func() {
+				So(nb.Put(c, []byte("short")), ShouldBeNil)
+				So(countChunks(), ShouldEqual, 8)
+				got, err := nb.Get(c)
+				So(err, ShouldBeNil)
+				So(got, ShouldResemble, []byte("short"))
+
+				// Transactional and non-transactional Puts replace each other's
+				// chunks.
+				tb := &Blob{Name: "big", ChunkSize: 10}
+				So(tb.Put(c, data), ShouldBeNil)
+				So(countChunks(), ShouldEqual, 14)
+				So(nb.Put(c, data), ShouldBeNil)
+				So(countChunks(), ShouldEqual, 14)
+				got, err = tb.Get(c)
+				So(err, ShouldBeNil)
+				So(got, ShouldResemble, data)
+			})
+
+			Convey("outside of the current transaction", func() {
+				So(ds.RunInTransaction(c, func(c context.Context) error {
+					if err := nb.Put(c, []byte("short")); err != nil {
+						return err
+					}
+					return errors.New("fail")
+				}, nil), ShouldErrLike, "fail")
+				got, err := nb.Get(c)
+				So(err, ShouldBeNil)
+				So(got, ShouldResemble, []byte("short"))
+			})
+
+			Convey("in several Puts", func() {
+				var sizes []int
+				c := ds.AddRawFilters(c, func(c context.Context, raw ds.RawInterface) ds.RawInterface {
+					return &putRecorder{raw, &sizes}
+				})
+				big := bytes.Repeat([]byte("x"), 3*maxPutSize/2)
+				nb.ChunkSize = maxPutSize / 4
+				So(nb.Put(c, big), ShouldBeNil)
+				// Four chunks per Put for the six chunks, then the manifest.
+				So(sizes, ShouldResemble, []int{4, 2, 1})
+
+				got, err := nb.Get(c)
+				So(err, ShouldBeNil)
+				So(got, ShouldResemble, big)
+			})
+
+			Convey("and deleted", func() {
+				So(nb.Delete(c), ShouldBeNil)
+				So(countChunks(), ShouldEqual, 7)
+				_, err := nb.Get(c)
+				So(err, ShouldEqual, ds.ErrNoSuchEntity)
+			})
+		})
+
+		Convey("needs a name", func() {
+			So((&Blob{}).Put(c, data), ShouldErrLike, "empty name")
+			So((&Blob{NonTransactional: true}).Put(c, data), ShouldErrLike, "empty name")
+		})
+	})
+}
@@ -16,6 +16,7 @@ package datastore
 
 import (
 	"fmt"
+	"time"
 
 	"go.chromium.org/luci/common/errors"
 
@@ -26,12 +27,26 @@ type checkFilter struct {
 	RawInterface
 
 	kc KeyContext
+	// readTime is the read time of the Context, see WithReadTime.
+	readTime time.Time
+}
+
+// checkReadTime returns an error if reads have a read time inside of
+// a transaction.
+func (tcf *checkFilter) checkReadTime() error {
+	if !tcf.readTime.IsZero() && tcf.CurrentTransaction() != nil {
+		return errors.New("datastore: read times can't be used in transactions, use TransactionOptions.ReadTime")
+	}
+	return nil
 }
 
 func (tcf *checkFilter) RunInTransaction(f func(c context.Context) error, opts *TransactionOptions) error {
 	if f == nil {
 		return fmt.Errorf("datastore: RunInTransaction function is nil")
 	}
+	if opts != nil && !opts.ReadTime.IsZero() && !opts.ReadOnly {
+		return errors.New("datastore: transactions with a ReadTime must be ReadOnly")
+	}
 	return tcf.RawInterface.RunInTransaction(f, opts)
 }
 
@@ -42,9 +57,35 @@ func (tcf *checkFilter) Run(fq *FinalizedQuery, cb RawRunCB) error {
 	if cb == nil {
 		return fmt.Errorf("datastore: Run callback is nil")
 	}
+	if err := tcf.checkReadTime(); err != nil {
+		return err
+	}
 	return tcf.RawInterface.Run(fq, cb)
 }
 
+func (tcf *checkFilter) Count(fq *FinalizedQuery) (int64, error) {
+	if fq == nil {
+		return 0, fmt.Errorf("datastore: Count query is nil")
+	}
+	if err := tcf.checkReadTime(); err != nil {
+		return 0, err
+	}
+	return tcf.RawInterface.Count(fq)
+}
+
+func (tcf *checkFilter) Aggregate(fq *FinalizedQuery, aggs []*Aggregation) (AggregationResult, error) {
+	if fq == nil {
+		return nil, fmt.Errorf("datastore: Aggregate query is nil")
+	}
+	if err := (&AggregationQuery{aggs: aggs}).Validate(); err != nil {
+		return nil, err
+	}
+	if err := tcf.checkReadTime(); err != nil {
+		return nil, err
+	}
+	return tcf.RawInterface.Aggregate(fq, aggs)
+}
+
 func (tcf *checkFilter) GetMulti(keys []*Key, meta MultiMetaGetter, cb GetMultiCB) error {
 	if len(keys) == 0 {
 		return nil
@@ -52,6 +93,9 @@ func (tcf *checkFilter) GetMulti(keys []*Key, meta MultiMetaGetter, cb GetMultiC
 	if cb == nil {
 		return fmt.Errorf("datastore: GetMulti callback is nil")
 	}
+	if err := tcf.checkReadTime(); err != nil {
+		return err
+	}
 	lme := errors.NewLazyMultiError(len(keys))
 	for i, k := range keys {
 		var err error
@@ -134,9 +178,61 @@ func (tcf *checkFilter) DeleteMulti(keys []*Key, cb DeleteMultiCB) error {
 	return tcf.RawInterface.DeleteMulti(keys, cb)
 }
 
+func (tcf *checkFilter) Mutate(muts []RawMutation, cb NewKeyCB) error {
+	if len(muts) == 0 {
+		return nil
+	}
+	if cb == nil {
+		return fmt.Errorf("datastore: Mutate callback is nil")
+	}
+	lme := errors.NewLazyMultiError(len(muts))
+	for i, m := range muts {
+		var err error
+		switch m.Op {
+		case MutationUpsert, MutationInsert:
+			if !m.Key.PartialValid(tcf.kc) {
+				err = MakeErrInvalidKey("key [%s] is not partially valid in context %s", m.Key, tcf.kc).Err()
+			}
+		case MutationUpdate, MutationDelete:
+			switch {
+			case m.Key.IsIncomplete():
+				err = MakeErrInvalidKey("key [%s] is incomplete", m.Key).Err()
+			case !m.Key.Valid(false, tcf.kc):
+				err = MakeErrInvalidKey("key [%s] is not valid in context %s", m.Key, tcf.kc).Err()
+			}
+		default:
+			err = fmt.Errorf("datastore: Mutate got unknown op %s", m.Op)
+		}
+		if err == nil && m.Op != MutationDelete && m.Value == nil {
+			err = errors.New("datastore: Mutate got nil Value entry")
+		}
+		if err != nil {
+			lme.Assign(i, err)
+		}
+	}
+	if me := lme.Get(); me != nil {
+		for idx, err := range me.(errors.MultiError) {
+			cb(idx, nil, err)
+		}
+		return nil
+	}
+	return tcf.RawInterface.Mutate(muts, cb)
+}
+
+func (tcf *checkFilter) ReserveIDRange(key *Key, start, end int64) error {
+	if !key.PartialValid(tcf.kc) {
+		return MakeErrInvalidKey("key [%s] is not partially valid in context %s", key, tcf.kc).Err()
+	}
+	if start <= 0 || end < start {
+		return fmt.Errorf("datastore: invalid ID range [%d, %d]", start, end)
+	}
+	return tcf.RawInterface.ReserveIDRange(key, start, end)
+}
+
 func applyCheckFilter(c context.Context, i RawInterface) RawInterface {
 	return &checkFilter{
 		RawInterface: i,
 		kc:           GetKeyContext(c),
+		readTime:     GetReadTime(c),
 	}
 }
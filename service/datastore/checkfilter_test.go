@@ -18,11 +18,13 @@ package datastore
 
 import (
 	"testing"
+	"time"
 
 	"go.chromium.org/gae/service/info"
 	"golang.org/x/net/context"
 
 	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
 )
 
 type fakeRDS struct{ RawInterface }
@@ -53,6 +55,10 @@ func TestCheckFilter(t *testing.T) {
 				}, nil), ShouldBeNil)
 			}, ShouldPanic)
 			So(hit, ShouldBeFalse)
+
+			opts := &TransactionOptions{ReadTime: time.Now()}
+			So(rds.RunInTransaction(func(context.Context) error { return nil }, opts),
+				ShouldErrLike, "must be ReadOnly")
 		})
 
 		Convey("Run", func() {
@@ -15,7 +15,10 @@
 package datastore
 
 import (
+	"time"
+
 	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/tuning"
 
 	"golang.org/x/net/context"
 )
@@ -26,6 +29,12 @@ const (
 	rawDatastoreKey key = iota
 	rawDatastoreFilterKey
 	rawDatastoreBatchKey
+	rawDatastoreBatchOptionsKey
+	databaseKey
+	appIDKey
+	readTimeKey
+	keysThenValuesKey
+	propertyMapReuseKey
 )
 
 // RawFactory is the function signature for factory methods compatible with
@@ -40,12 +49,18 @@ type RawFilter func(context.Context, RawInterface) RawInterface
 // rawUnfiltered gets gets the RawInterface implementation from context without
 // any of the filters applied.
 func rawUnfiltered(c context.Context) RawInterface {
-	if f, ok := c.Value(rawDatastoreKey).(RawFactory); ok && f != nil {
+	if f := rawFactory(c); f != nil {
 		return f(c)
 	}
 	return nil
 }
 
+// rawFactory returns the RawFactory installed in c, or nil if there is none.
+func rawFactory(c context.Context) RawFactory {
+	f, _ := c.Value(rawDatastoreKey).(RawFactory)
+	return f
+}
+
 // rawWithFilters gets the datastore (transactional or not), and applies all of
 // the currently installed filters to it.
 //
@@ -69,7 +84,13 @@ func rawWithFilters(c context.Context, filter ...RawFilter) RawInterface {
 }
 
 // Raw gets the RawInterface implementation from context.
+//
+// If a tuning.Datastore Deadline is installed in c, each call made with the
+// returned RawInterface has that deadline.
 func Raw(c context.Context) RawInterface {
+	if d := tuning.Get(c).Datastore.Deadline; d > 0 && rawFactory(c) != nil {
+		return &deadlineRaw{c, d}
+	}
 	return rawWithFilters(c)
 }
 
@@ -110,7 +131,114 @@ func AddRawFilters(c context.Context, filts ...RawFilter) context.Context {
 // installed in the supplied Context.
 func GetKeyContext(c context.Context) KeyContext {
 	ri := info.Raw(c)
-	return MkKeyContext(ri.FullyQualifiedAppID(), ri.GetNamespace())
+	kc := MkKeyContext(ri.FullyQualifiedAppID(), ri.GetNamespace())
+	if appID := GetAppID(c); appID != "" {
+		kc.AppID = appID
+	}
+	kc.Database = GetDatabase(c)
+	return kc
+}
+
+// WithAppID returns a Context whose datastore operations, and the keys made
+// with it, target the datastore of the app (or Cloud project) appID instead of
+// the one reported by the "info" service. The empty string restores the
+// ambient app ID.
+//
+// Transactions run in the app of the Context they're started with.
+//
+// Only backends which can reach other apps' datastores (currently
+// "impl/cloud", when configured with a client for appID) honor this; the
+// operations of others fail with an error when used with a foreign app ID.
+func WithAppID(c context.Context, appID string) context.Context {
+	return context.WithValue(c, appIDKey, appID)
+}
+
+// GetAppID returns the app ID set with WithAppID, or the empty string if the
+// ambient app ID is used.
+func GetAppID(c context.Context) string {
+	appID, _ := c.Value(appIDKey).(string)
+	return appID
+}
+
+// WithDatabase returns a Context whose datastore operations, and the keys made
+// with it, target the named Firestore in Datastore mode database. The empty
+// string is the project's "(default)" database.
+//
+// Transactions run in the database of the Context they're started with.
+//
+// Only backends which support named databases (currently "impl/cloud") honor
+// this; the operations of others fail with an error.
+func WithDatabase(c context.Context, database string) context.Context {
+	if database == DefaultDatabase {
+		database = ""
+	}
+	return context.WithValue(c, databaseKey, database)
+}
+
+// GetDatabase returns the database ID set with WithDatabase, or the empty string
+// for the "(default)" database.
+func GetDatabase(c context.Context) string {
+	db, _ := c.Value(databaseKey).(string)
+	return db
+}
+
+// WithReadTime returns a Context whose reads (Get, queries and their variants)
+// see the datastore as it was at readTime, which must be in the recent past.
+// The zero time restores reads of the current state.
+//
+// Read times can't be used inside of transactions; use
+// TransactionOptions.ReadTime instead. Only backends which support point in
+// time reads (currently "impl/cloud", and "impl/memory" once history is
+// enabled with Testable.KeepHistory) honor this; others return an error.
+func WithReadTime(c context.Context, readTime time.Time) context.Context {
+	return context.WithValue(c, readTimeKey, readTime)
+}
+
+// GetReadTime returns the read time set with WithReadTime, or the zero time if
+// reads see the current state.
+func GetReadTime(c context.Context) time.Time {
+	t, _ := c.Value(readTimeKey).(time.Time)
+	return t
+}
+
+// WithKeysThenValues enables or disables keys-then-values GetAll, which is
+// disabled by default.
+//
+// When enabled, GetAll runs full queries keys-only, and then fetches their
+// results with GetMulti. This costs an extra round trip, but lets filters like
+// "filter/dscache" serve the entities from their cache, while full queries
+// always read them from the datastore. It's worth it for queries whose results
+// are large and frequently read.
+func WithKeysThenValues(c context.Context, enabled bool) context.Context {
+	return context.WithValue(c, keysThenValuesKey, enabled)
+}
+
+func getKeysThenValues(c context.Context) bool {
+	enabled, _ := c.Value(keysThenValuesKey).(bool)
+	return enabled
+}
+
+// withPropertyMapReuse returns a Context in which the caller of the
+// RawInterface promises not to use the PropertyMaps passed to its callbacks
+// after they return (see PropertyMapsReusable).
+func withPropertyMapReuse(c context.Context) context.Context {
+	return context.WithValue(c, propertyMapReuseKey, true)
+}
+
+// PropertyMapsReusable returns true if the caller of the RawInterface of c
+// doesn't use the PropertyMaps passed to GetMultiCB and RawRunCB after the
+// callback returns. This is the case when Get, GetAll and Run load them into
+// structs or PropertyMaps, which copy them.
+//
+// RawInterface implementations may then reuse a single PropertyMap for all of
+// the results of a GetMulti or Run, instead of allocating one per entity. The
+// memory implementation does for both, and the prod and cloud ones for Run
+// (their SDKs load all of the entities of a GetMulti at once).
+// Filters which hold on to the PropertyMaps they get from the RawInterface
+// they wrap beyond the callback must copy them when this returns true.
+func PropertyMapsReusable(c context.Context) bool {
+	reusable, _ := c.Value(propertyMapReuseKey).(bool)
+	return reusable
 }
 
 // WithBatching enables or disables automatic operation batching. Batching is
@@ -137,3 +265,43 @@ func getBatching(c context.Context) (is, ok bool) {
 	is, ok = c.Value(rawDatastoreBatchKey).(bool)
 	return
 }
+
+// BatchOptions tunes automatic operation batching. See WithBatchOptions.
+type BatchOptions struct {
+	// MaxGetSize, MaxPutSize and MaxDeleteSize, if positive, are the maximum
+	// number of elements in each Get, Put and Delete batch respectively. They
+	// can only lower the datastore's Constraints, not raise them.
+	//
+	// Smaller batches executed concurrently often complete sooner than a single
+	// large RPC.
+	MaxGetSize    int
+	MaxPutSize    int
+	MaxDeleteSize int
+
+	// Concurrency, if positive, is the maximum number of batches of a single
+	// operation that will be executed at once. If zero, all batches are
+	// executed at once.
+	Concurrency int
+}
+
+// WithBatchOptions returns a Context whose operations are batched according
+// to opts. The options only take effect while batching is enabled (see
+// WithBatching).
+func WithBatchOptions(c context.Context, opts BatchOptions) context.Context {
+	return context.WithValue(c, rawDatastoreBatchOptionsKey, opts)
+}
+
+// getBatchOptions returns the BatchOptions installed in c, or the ones from
+// the installed tuning.Options if there are none.
+func getBatchOptions(c context.Context) BatchOptions {
+	if opts, ok := c.Value(rawDatastoreBatchOptionsKey).(BatchOptions); ok {
+		return opts
+	}
+	t := &tuning.Get(c).Datastore
+	return BatchOptions{
+		MaxGetSize:    t.MaxGetSize,
+		MaxPutSize:    t.MaxPutSize,
+		MaxDeleteSize: t.MaxDeleteSize,
+		Concurrency:   t.Concurrency,
+	}
+}
@@ -79,3 +79,37 @@ func TestServices(t *testing.T) {
 		})
 	})
 }
+
+func TestWithDatabase(t *testing.T) {
+	t.Parallel()
+
+	Convey("WithDatabase", t, func() {
+		c := info.Set(context.Background(), fakeInfo{})
+		So(GetKeyContext(c), ShouldResemble, MkKeyContext("s~aid", "ns"))
+
+		c = WithDatabase(c, "db")
+		So(GetDatabase(c), ShouldEqual, "db")
+		So(GetKeyContext(c), ShouldResemble, KeyContext{AppID: "s~aid", Namespace: "ns", Database: "db"})
+
+		Convey("treats the default database as empty", func() {
+			So(GetDatabase(WithDatabase(c, DefaultDatabase)), ShouldEqual, "")
+			So(GetDatabase(WithDatabase(c, "")), ShouldEqual, "")
+		})
+	})
+}
+
+func TestWithAppID(t *testing.T) {
+	t.Parallel()
+
+	Convey("WithAppID", t, func() {
+		c := info.Set(context.Background(), fakeInfo{})
+		So(GetAppID(c), ShouldEqual, "")
+
+		c = WithDatabase(WithAppID(c, "other"), "db")
+		So(GetAppID(c), ShouldEqual, "other")
+		So(GetKeyContext(c), ShouldResemble, KeyContext{AppID: "other", Namespace: "ns", Database: "db"})
+		So(NewKey(c, "Kind", "", 1, nil).AppID(), ShouldEqual, "other")
+
+		So(GetKeyContext(WithAppID(c, "")).AppID, ShouldEqual, "s~aid")
+	})
+}
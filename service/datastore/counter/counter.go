@@ -0,0 +1,280 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package counter implements sharded counters: counters which support a high
+// rate of increments by spreading them over several datastore entities.
+//
+// A single datastore entity (or entity group) can only sustain about one write
+// per second. A sharded counter stores its value as the sum of the counts of
+// several shard entities, and each increment transactionally updates
+// a randomly chosen shard, so the counter sustains roughly one write per
+// second per shard. The number of shards can be increased at any time (but
+// never decreased) with SetShards.
+//
+// Reading the value requires reading every shard, so the value is also cached
+// in memcache, and increments update the cached value when it's present.
+// Since memcache is not transactional, the cached value can be stale for up
+// to Counter.CacheExpiration.
+//
+// Example:
+//
+//	views := &counter.Counter{Name: "page-views"}
+//	if err := views.Increment(c, 1); err != nil {
+//	  return err
+//	}
+//	total, err := views.Count(c)
+package counter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+	mc "go.chromium.org/gae/service/memcache"
+
+	"go.chromium.org/luci/common/data/rand/mathrand"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// DefaultShards is the number of shards of a new Counter with no Shards
+	// set.
+	DefaultShards = 20
+
+	// DefaultCacheExpiration is the expiration of the cached value of
+	// a Counter with no CacheExpiration set.
+	DefaultCacheExpiration = time.Minute
+)
+
+// config is the datastore entity holding the number of shards of a counter.
+// It only exists once SetShards has been called.
+type config struct {
+	_kind string `gae:"$kind,gae.counter.Config"`
+
+	Name   string `gae:"$id"`
+	Shards int    `gae:",noindex"`
+}
+
+// shard is the datastore entity of a shard of a counter.
+type shard struct {
+	_kind string `gae:"$kind,gae.counter.Shard"`
+
+	// ID is the counter name and the index of the shard, see shardID.
+	ID    string `gae:"$id"`
+	Count int64  `gae:",noindex"`
+}
+
+func shardID(name string, i int) string {
+	return fmt.Sprintf("%s:%d", name, i)
+}
+
+// Counter is a sharded counter.
+type Counter struct {
+	// Name identifies the counter. It must not be empty.
+	Name string
+
+	// Shards is the number of shards of the counter until SetShards is called.
+	// If zero, DefaultShards is used.
+	//
+	// Changing Shards after the counter was incremented loses the counts of the
+	// shards beyond the new value if it's lower, so use SetShards instead.
+	Shards int
+
+	// CacheExpiration is the expiration of the cached value. If zero,
+	// DefaultCacheExpiration is used.
+	CacheExpiration time.Duration
+}
+
+func (ctr *Counter) cacheKey() string {
+	return "gae:counter:" + ctr.Name
+}
+
+func (ctr *Counter) cacheExpiration() time.Duration {
+	if ctr.CacheExpiration > 0 {
+		return ctr.CacheExpiration
+	}
+	return DefaultCacheExpiration
+}
+
+func (ctr *Counter) check(c context.Context) error {
+	if ctr.Name == "" {
+		return errors.New("counter: empty name")
+	}
+	if ds.CurrentTransaction(c) != nil {
+		return errors.New("counter: can't be used in a transaction")
+	}
+	return nil
+}
+
+// shards returns the current number of shards of the counter.
+func (ctr *Counter) shards(c context.Context) (int, error) {
+	cfg := &config{Name: ctr.Name}
+	switch err := ds.Get(c, cfg); err {
+	case nil:
+		return cfg.Shards, nil
+	case ds.ErrNoSuchEntity:
+		if ctr.Shards > 0 {
+			return ctr.Shards, nil
+		}
+		return DefaultShards, nil
+	default:
+		return 0, errors.Annotate(err, "failed to get the config of %q", ctr.Name).Err()
+	}
+}
+
+// Increment adds delta, which may be negative, to the counter.
+//
+// The increment is applied transactionally to one of the shards. It can't be
+// called in a transaction.
+func (ctr *Counter) Increment(c context.Context, delta int64) error {
+	if err := ctr.check(c); err != nil {
+		return err
+	}
+	n, err := ctr.shards(c)
+	if err != nil {
+		return err
+	}
+
+	s := &shard{ID: shardID(ctr.Name, mathrand.Intn(c, n))}
+	err = ds.RunInTransaction(c, func(c context.Context) error {
+		s.Count = 0
+		if err := ds.Get(c, s); err != nil && err != ds.ErrNoSuchEntity {
+			return err
+		}
+		s.Count += delta
+		return ds.Put(c, s)
+	}, nil)
+	if err != nil {
+		return errors.Annotate(err, "failed to increment %q", ctr.Name).Err()
+	}
+
+	// Keep the cached value up to date, if there is one. memcache values can't
+	// go below zero, so decrements drop the cached value instead.
+	if delta >= 0 {
+		if _, err := mc.IncrementExisting(c, ctr.cacheKey(), delta); err != nil && err != mc.ErrCacheMiss {
+			mc.Delete(c, ctr.cacheKey())
+		}
+	} else {
+		mc.Delete(c, ctr.cacheKey())
+	}
+	return nil
+}
+
+// Count returns the value of the counter, from memcache if it's cached, and
+// otherwise by summing its shards.
+func (ctr *Counter) Count(c context.Context) (int64, error) {
+	if err := ctr.check(c); err != nil {
+		return 0, err
+	}
+	if itm, err := mc.GetKey(c, ctr.cacheKey()); err == nil && len(itm.Value()) == 8 {
+		// The format used by memcache.Increment.
+		return int64(binary.LittleEndian.Uint64(itm.Value())), nil
+	}
+
+	total, err := ctr.sum(c)
+	if err != nil {
+		return 0, err
+	}
+	// memcache can only increment non-negative values. Use Add so as not to
+	// overwrite a value which was incremented in the meantime.
+	if total >= 0 {
+		v := make([]byte, 8)
+		binary.LittleEndian.PutUint64(v, uint64(total))
+		mc.Add(c, mc.NewItem(c, ctr.cacheKey()).SetValue(v).SetExpiration(ctr.cacheExpiration()))
+	}
+	return total, nil
+}
+
+// sum reads all of the shards of the counter and returns the sum of their
+// counts.
+func (ctr *Counter) sum(c context.Context) (int64, error) {
+	n, err := ctr.shards(c)
+	if err != nil {
+		return 0, err
+	}
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{ID: shardID(ctr.Name, i)}
+	}
+
+	total := int64(0)
+	if err := ds.Get(c, shards); err != nil {
+		me, ok := err.(errors.MultiError)
+		if !ok {
+			return 0, errors.Annotate(err, "failed to get the shards of %q", ctr.Name).Err()
+		}
+		for i, err := range me {
+			switch err {
+			case nil:
+			case ds.ErrNoSuchEntity:
+				shards[i] = nil
+			default:
+				return 0, errors.Annotate(err, "failed to get the shards of %q", ctr.Name).Err()
+			}
+		}
+	}
+	for _, s := range shards {
+		if s != nil {
+			total += s.Count
+		}
+	}
+	return total, nil
+}
+
+// SetShards increases the number of shards of the counter to n. It does
+// nothing if the counter already has n or more shards, since removing shards
+// would lose their counts.
+func (ctr *Counter) SetShards(c context.Context, n int) error {
+	if err := ctr.check(c); err != nil {
+		return err
+	}
+	if n <= 0 {
+		return fmt.Errorf("counter: bad number of shards %d", n)
+	}
+	err := ds.RunInTransaction(c, func(c context.Context) error {
+		cfg := &config{Name: ctr.Name}
+		switch err := ds.Get(c, cfg); err {
+		case nil:
+		case ds.ErrNoSuchEntity:
+			cfg.Shards = DefaultShards
+			if ctr.Shards > 0 {
+				cfg.Shards = ctr.Shards
+			}
+		default:
+			return err
+		}
+		if cfg.Shards >= n {
+			return nil
+		}
+		cfg.Shards = n
+		return ds.Put(c, cfg)
+	}, nil)
+	if err != nil {
+		return errors.Annotate(err, "failed to set the shards of %q", ctr.Name).Err()
+	}
+	return nil
+}
+
+// Increment adds delta to the counter name, with the default options.
+func Increment(c context.Context, name string, delta int64) error {
+	return (&Counter{Name: name}).Increment(c, delta)
+}
+
+// Count returns the value of the counter name, with the default options.
+func Count(c context.Context, name string) (int64, error) {
+	return (&Counter{Name: name}).Count(c)
+}
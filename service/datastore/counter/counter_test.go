@@ -0,0 +1,122 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package counter
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+	mc "go.chromium.org/gae/service/memcache"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestCounter(t *testing.T) {
+	t.Parallel()
+
+	Convey("Counter", t, func() {
+		c := memory.Use(context.Background())
+		ds.GetTestable(c).Consistent(true)
+		ctr := &Counter{Name: "views", Shards: 3}
+
+		cached := func() interface{} {
+			itm, err := mc.GetKey(c, ctr.cacheKey())
+			if err == mc.ErrCacheMiss {
+				return nil
+			}
+			So(err, ShouldBeNil)
+			return binary.LittleEndian.Uint64(itm.Value())
+		}
+
+		Convey("starts at zero", func() {
+			n, err := ctr.Count(c)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+		})
+
+		Convey("sums increments across shards", func() {
+			for i := 0; i < 20; i++ {
+				So(ctr.Increment(c, 2), ShouldBeNil)
+			}
+			n, err := ctr.Count(c)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 40)
+
+			var shards []*shard
+			So(ds.GetAll(c, ds.NewQuery("gae.counter.Shard"), &shards), ShouldBeNil)
+			So(len(shards), ShouldBeBetweenOrEqual, 1, 3)
+
+			Convey("caches the count", func() {
+				So(cached(), ShouldEqual, 40)
+
+				So(ctr.Increment(c, 1), ShouldBeNil)
+				So(cached(), ShouldEqual, 41)
+				n, err := ctr.Count(c)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 41)
+
+				So(ctr.Increment(c, -5), ShouldBeNil)
+				So(cached(), ShouldBeNil)
+				n, err = ctr.Count(c)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 36)
+			})
+
+			Convey("keeps counts when adding shards", func() {
+				So(ctr.SetShards(c, 10), ShouldBeNil)
+				So(ctr.SetShards(c, 2), ShouldBeNil) // never shrinks
+				n, err := ctr.shards(c)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 10)
+
+				for i := 0; i < 20; i++ {
+					So(ctr.Increment(c, 1), ShouldBeNil)
+				}
+				So(mc.Flush(c), ShouldBeNil)
+				n2, err := ctr.Count(c)
+				So(err, ShouldBeNil)
+				So(n2, ShouldEqual, 60)
+			})
+		})
+
+		Convey("supports negative totals", func() {
+			So(ctr.Increment(c, -3), ShouldBeNil)
+			n, err := ctr.Count(c)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, -3)
+			So(cached(), ShouldBeNil)
+		})
+
+		Convey("package functions use the defaults", func() {
+			So(Increment(c, "other", 5), ShouldBeNil)
+			n, err := Count(c, "other")
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 5)
+		})
+
+		Convey("bad uses", func() {
+			So((&Counter{}).Increment(c, 1), ShouldErrLike, "empty name")
+			So(ctr.SetShards(c, 0), ShouldErrLike, "bad number of shards")
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				return ctr.Increment(c, 1)
+			}, nil), ShouldErrLike, "can't be used in a transaction")
+		})
+	})
+}
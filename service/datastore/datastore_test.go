@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -197,6 +198,14 @@ func (f *fakeDatastore) DeleteMulti(keys []*Key, cb DeleteMultiCB) error {
 	return nil
 }
 
+func (f *fakeDatastore) Mutate(muts []RawMutation, cb NewKeyCB) error {
+	return ApplyMutations(f, muts, cb)
+}
+
+func (f *fakeDatastore) Aggregate(fq *FinalizedQuery, aggs []*Aggregation) (AggregationResult, error) {
+	return AggregateByScan(f, fq, aggs)
+}
+
 func (f *fakeDatastore) Constraints() Constraints {
 	return f.constraints
 }
@@ -982,6 +991,99 @@ func TestDelete(t *testing.T) {
 	})
 }
 
+func TestMutate(t *testing.T) {
+	t.Parallel()
+
+	Convey("A testing environment", t, func() {
+		c := info.Set(context.Background(), fakeInfo{})
+		fds := fakeDatastore{}
+		c = SetRawFactory(c, fds.factory())
+
+		Convey("Testing Mutate", func() {
+			Convey("bad", func() {
+				Convey("static bad type", func() {
+					So(func() { Mutate(c, NewUpsert(100)) }, ShouldPanicLike,
+						"invalid input type (int): not a PLS, pointer-to-struct, or slice thereof")
+				})
+
+				Convey("get single error for RPC failure", func() {
+					err := Mutate(c, NewDelete(MakeKey(c, "FailAll", 1)), NewDelete(MakeKey(c, "Ok", 1)))
+					So(err, ShouldEqual, errFailAll)
+				})
+
+				Convey("get multi error for failed preconditions", func() {
+					err := Mutate(c,
+						NewInsert(&CommonStruct{ID: 1}),
+						NewUpdate([]*CommonStruct{{ID: 2}, {ID: noSuchEntityID}}),
+						NewDelete(MakeKey(c, "CommonStruct", 3)))
+					So(err, ShouldResemble, errors.MultiError{
+						ErrEntityExists,
+						errors.MultiError{nil, ErrNoSuchEntity},
+						nil,
+					})
+				})
+
+				Convey("get single error for a single mutation", func() {
+					So(Mutate(c, NewUpdate(&CommonStruct{ID: noSuchEntityID})), ShouldEqual, ErrNoSuchEntity)
+				})
+
+				Convey("doesn't write back keys on failure", func() {
+					cs := &CommonStruct{}
+					err := Mutate(c, NewUpsert(cs), NewInsert(&CommonStruct{ID: 1}))
+					So(err, ShouldResemble, errors.MultiError{nil, ErrEntityExists})
+					So(cs.ID, ShouldEqual, 0)
+				})
+			})
+
+			Convey("good", func() {
+				cs := &CommonStruct{Value: 0}
+				So(Mutate(c,
+					NewUpsert(cs),
+					NewInsert(&CommonStruct{ID: noSuchEntityID, Value: 1}),
+					NewDelete(MakeKey(c, "CommonStruct", 3)),
+					NewUpdate([]*CommonStruct{{ID: 4, Value: 0}}),
+				), ShouldBeNil)
+				So(cs.ID, ShouldEqual, 1)
+
+				// Later mutations see the effects of earlier ones.
+				So(Mutate(c,
+					NewDelete(MakeKey(c, "CommonStruct", 5)),
+					NewInsert(&CommonStruct{ID: 5, Value: 0}),
+				), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestInsertUpdate(t *testing.T) {
+	t.Parallel()
+
+	Convey("A testing environment", t, func() {
+		c := info.Set(context.Background(), fakeInfo{})
+		fds := fakeDatastore{}
+		c = SetRawFactory(c, fds.factory())
+
+		Convey("Insert", func() {
+			cs := &CommonStruct{Value: 0}
+			So(Insert(c, cs), ShouldBeNil)
+			So(cs.ID, ShouldEqual, 1)
+
+			So(Insert(c, &CommonStruct{ID: 1}), ShouldEqual, ErrEntityExists)
+			So(Insert(c, []*CommonStruct{{ID: noSuchEntityID}, {ID: 2}}), ShouldResemble,
+				errors.MultiError{nil, ErrEntityExists})
+		})
+
+		Convey("Update", func() {
+			So(Update(c, &CommonStruct{ID: 1, Value: 0}), ShouldBeNil)
+
+			So(Update(c, &CommonStruct{ID: noSuchEntityID}), ShouldEqual, ErrNoSuchEntity)
+			So(Update(c, &CommonStruct{ID: 1}, &CommonStruct{ID: noSuchEntityID}), ShouldResemble,
+				errors.MultiError{nil, ErrNoSuchEntity})
+			So(Update(c, &CommonStruct{}), ShouldErrLike, "is incomplete")
+		})
+	})
+}
+
 func TestGet(t *testing.T) {
 	t.Parallel()
 
@@ -1094,6 +1196,102 @@ func TestGet(t *testing.T) {
 	})
 }
 
+func TestGetFound(t *testing.T) {
+	t.Parallel()
+
+	Convey("GetFound", t, func() {
+		c := info.Set(context.Background(), fakeInfo{})
+		fds := fakeDatastore{}
+		c = SetRawFactory(c, fds.factory())
+
+		Convey("splits found and missing entities", func() {
+			ents := []*CommonStruct{{ID: 1}, {ID: noSuchEntityID}, {ID: 3}}
+			missing, err := GetFound(c, &ents)
+			So(err, ShouldBeNil)
+			So(ents, ShouldResemble, []*CommonStruct{{ID: 1, Value: 1}, {ID: 3, Value: 3}})
+			So(missing, ShouldResemble, []*Key{MakeKey(c, "CommonStruct", noSuchEntityID)})
+		})
+
+		Convey("leaves dst alone if everything was found", func() {
+			ents := []CommonStruct{{ID: 1}, {ID: 2}}
+			missing, err := GetFound(c, &ents)
+			So(err, ShouldBeNil)
+			So(missing, ShouldBeNil)
+			So(ents, ShouldResemble, []CommonStruct{{ID: 1, Value: 1}, {ID: 2, Value: 2}})
+		})
+
+		Convey("returns other errors", func() {
+			ents := []FakePLS{{IntID: noSuchEntityID}, {IntID: 2, Kind: "Fail"}}
+			missing, err := GetFound(c, &ents)
+			So(err, ShouldResemble, errors.MultiError{ErrNoSuchEntity, errFail})
+			So(missing, ShouldBeNil)
+			So(ents, ShouldHaveLength, 2)
+		})
+
+		Convey("needs a pointer to a slice", func() {
+			So(func() { GetFound(c, []CommonStruct{}) }, ShouldPanicLike, "must have a ptr-to-slice")
+		})
+	})
+}
+
+func TestRunAggregation(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test RunAggregation", t, func() {
+		c := info.Set(context.Background(), fakeInfo{})
+		fds := fakeDatastore{entities: 5}
+		c = SetRawFactory(c, fds.factory())
+		q := NewQuery("Kind")
+
+		Convey("computes aggregations by scanning", func() {
+			res, err := RunAggregation(c, q.Aggregate(
+				CountAs("total"), SumOf("Value"), AvgOf("Value").As("avg"), SumOf("Missing")))
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, AggregationResult{
+				"total":   int64(5),
+				"Value":   int64(10),
+				"avg":     float64(2),
+				"Missing": int64(0),
+			})
+		})
+
+		Convey("respects the query limit", func() {
+			res, err := RunAggregation(c, q.Limit(2).Aggregate(CountAs("n"), AvgOf("Value")))
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, AggregationResult{"n": int64(2), "Value": 0.5})
+		})
+
+		Convey("averages of nothing are nil", func() {
+			res, err := RunAggregation(c, q.Aggregate(AvgOf("Missing")))
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, AggregationResult{"Missing": nil})
+		})
+
+		Convey("sums switch to floating point", func() {
+			a := &aggregator{Aggregation: SumOf("V")}
+			a.add(PropertyMap{"V": MkProperty(int64(math.MaxInt64))})
+			So(a.result(), ShouldEqual, int64(math.MaxInt64))
+			a.add(PropertyMap{"V": PropertySlice{MkProperty(int64(1)), MkProperty("ignored")}})
+			So(a.result(), ShouldEqual, float64(math.MaxInt64)+1)
+
+			a = &aggregator{Aggregation: SumOf("V")}
+			a.add(PropertyMap{"V": PropertySlice{MkProperty(int64(1)), MkProperty(1.5)}})
+			So(a.result(), ShouldEqual, 2.5)
+		})
+
+		Convey("bad aggregations", func() {
+			_, err := RunAggregation(c, q.Aggregate())
+			So(err, ShouldErrLike, "no aggregations")
+			_, err = RunAggregation(c, q.Aggregate(CountAs("")))
+			So(err, ShouldErrLike, "has no alias")
+			_, err = RunAggregation(c, q.Aggregate(CountAs("a"), SumOf("x").As("a")))
+			So(err, ShouldErrLike, `alias "a" is used more than once`)
+			_, err = RunAggregation(c, q.Aggregate(SumOf("").As("s")))
+			So(err, ShouldErrLike, "bad property")
+		})
+	})
+}
+
 func TestGetAll(t *testing.T) {
 	t.Parallel()
 
@@ -1224,6 +1422,34 @@ func TestGetAll(t *testing.T) {
 				}
 			})
 
+			Convey("keys then values", func() {
+				// Entities fetched with GetMulti have their ID as their Value, while
+				// query results have their index.
+				fds.keyForResult = func(i int32, kctx KeyContext) *Key {
+					if i == 2 {
+						return kctx.MakeKey("Index", noSuchEntityID)
+					}
+					return kctx.MakeKey("Index", i+1)
+				}
+				c := WithKeysThenValues(c, true)
+
+				output := []CommonStruct{{ID: 100}}
+				So(GetAll(c, q, &output), ShouldBeNil)
+				So(output, ShouldResemble, []CommonStruct{
+					{ID: 100},
+					{ID: 1, Value: 1},
+					{ID: 2, Value: 2},
+					{ID: 4, Value: 4},
+					{ID: 5, Value: 5},
+				})
+
+				Convey("but not for projection queries", func() {
+					output := []PropertyMap(nil)
+					So(GetAll(c, q.Project("Value"), &output), ShouldBeNil)
+					So(len(output), ShouldEqual, 5)
+					So(output[1].Slice("Value")[0].Value(), ShouldEqual, 1)
+				})
+			})
 		})
 	})
 }
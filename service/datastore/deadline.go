@@ -0,0 +1,113 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// deadlineRaw is the RawInterface returned by Raw when a tuning.Datastore
+// Deadline is installed.
+//
+// Since a RawInterface is bound to the Context it's built with, deadlineRaw
+// builds the filter chain once per call: on a Context with the deadline for the
+// datastore operations, and on the Context of Raw for the rest.
+type deadlineRaw struct {
+	c        context.Context
+	deadline time.Duration
+}
+
+func (d *deadlineRaw) withDeadline() (RawInterface, context.CancelFunc) {
+	c, cancel := context.WithTimeout(d.c, d.deadline)
+	return rawWithFilters(c), cancel
+}
+
+func (d *deadlineRaw) AllocateIDs(keys []*Key, cb NewKeyCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.AllocateIDs(keys, cb)
+}
+
+func (d *deadlineRaw) ReserveIDRange(key *Key, start, end int64) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.ReserveIDRange(key, start, end)
+}
+
+// RunInTransaction doesn't have the deadline as a whole. The calls made within
+// the transaction each do, since the tuning.Options are in its Context.
+func (d *deadlineRaw) RunInTransaction(f func(c context.Context) error, opts *TransactionOptions) error {
+	return rawWithFilters(d.c).RunInTransaction(f, opts)
+}
+
+func (d *deadlineRaw) DecodeCursor(s string) (Cursor, error) {
+	return rawWithFilters(d.c).DecodeCursor(s)
+}
+
+func (d *deadlineRaw) Run(q *FinalizedQuery, cb RawRunCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.Run(q, cb)
+}
+
+func (d *deadlineRaw) Count(q *FinalizedQuery) (int64, error) {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.Count(q)
+}
+
+func (d *deadlineRaw) Aggregate(q *FinalizedQuery, aggs []*Aggregation) (AggregationResult, error) {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.Aggregate(q, aggs)
+}
+
+func (d *deadlineRaw) GetMulti(keys []*Key, meta MultiMetaGetter, cb GetMultiCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.GetMulti(keys, meta, cb)
+}
+
+func (d *deadlineRaw) PutMulti(keys []*Key, vals []PropertyMap, cb NewKeyCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.PutMulti(keys, vals, cb)
+}
+
+func (d *deadlineRaw) DeleteMulti(keys []*Key, cb DeleteMultiCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.DeleteMulti(keys, cb)
+}
+
+func (d *deadlineRaw) Mutate(muts []RawMutation, cb NewKeyCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.Mutate(muts, cb)
+}
+
+func (d *deadlineRaw) WithoutTransaction() context.Context {
+	return rawWithFilters(d.c).WithoutTransaction()
+}
+
+func (d *deadlineRaw) CurrentTransaction() Transaction {
+	return rawWithFilters(d.c).CurrentTransaction()
+}
+
+func (d *deadlineRaw) Constraints() Constraints { return rawWithFilters(d.c).Constraints() }
+
+func (d *deadlineRaw) GetTestable() Testable { return rawWithFilters(d.c).GetTestable() }
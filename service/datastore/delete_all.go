@@ -0,0 +1,158 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"fmt"
+	"sync"
+
+	"go.chromium.org/luci/common/errors"
+	"go.chromium.org/luci/common/sync/parallel"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultDeleteAllBatchSize is the default DeleteAllOptions.BatchSize.
+const DefaultDeleteAllBatchSize = 500
+
+// DeleteAllOptions are options for DeleteAll.
+type DeleteAllOptions struct {
+	// BatchSize is the number of keys deleted by each DeleteMulti call. If zero,
+	// DefaultDeleteAllBatchSize is used. Batches are further split to fit the
+	// implementation's Constraints.
+	BatchSize int
+
+	// Concurrency is the maximum number of batches deleted at once. If zero or
+	// one, batches are deleted one at a time, as the query runs.
+	Concurrency int
+
+	// Progress, if not nil, is called after each batch with the total number
+	// of entities deleted so far. Calls are serialized.
+	Progress func(deleted int)
+}
+
+// DeleteAllError is returned by DeleteAll when some of the entities couldn't
+// be deleted.
+type DeleteAllError struct {
+	// Deleted is the number of entities which were deleted.
+	Deleted int
+
+	// Failed are the keys of the entities which couldn't be deleted, and Errors
+	// are the corresponding errors.
+	Failed []*Key
+	Errors errors.MultiError
+}
+
+func (e *DeleteAllError) Error() string {
+	return fmt.Sprintf("datastore: failed to delete %d entities (deleted %d): %s", len(e.Failed), e.Deleted, e.Errors.First())
+}
+
+// DeleteAll deletes all of the entities matched by q, and returns the number
+// deleted. See DeleteAll.
+func (o *DeleteAllOptions) DeleteAll(c context.Context, q *Query) (int, error) {
+	batchSize := o.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultDeleteAllBatchSize
+	}
+	concurrency := o.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		lock    sync.Mutex
+		deleted int
+		failed  *DeleteAllError
+	)
+	deleteBatch := func(keys []*Key) error {
+		var errs []error
+		err := filterStop(Raw(c).DeleteMulti(keys, func(idx int, err error) error {
+			if err != nil {
+				// The callback is called concurrently for the parts of a batch
+				// which is split to fit the Constraints.
+				lock.Lock()
+				defer lock.Unlock()
+				if errs == nil {
+					errs = make([]error, len(keys))
+				}
+				errs[idx] = err
+			}
+			return nil
+		}))
+
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			// The whole batch failed.
+			errs = make([]error, len(keys))
+			for i := range errs {
+				errs[i] = err
+			}
+		}
+		for i, k := range keys {
+			if errs != nil && errs[i] != nil {
+				if failed == nil {
+					failed = &DeleteAllError{}
+				}
+				failed.Failed = append(failed.Failed, k)
+				failed.Errors = append(failed.Errors, errs[i])
+			} else {
+				deleted++
+			}
+		}
+		if o.Progress != nil {
+			o.Progress(deleted)
+		}
+		return nil
+	}
+
+	var queryErr error
+	err := parallel.WorkPool(concurrency, func(workC chan<- func() error) {
+		batch := make([]*Key, 0, batchSize)
+		queryErr = Run(c, q.KeysOnly(true), func(k *Key) error {
+			if batch = append(batch, k); len(batch) == batchSize {
+				keys := batch
+				workC <- func() error { return deleteBatch(keys) }
+				batch = make([]*Key, 0, batchSize)
+			}
+			return c.Err()
+		})
+		if len(batch) > 0 {
+			workC <- func() error { return deleteBatch(batch) }
+		}
+	})
+	if err == nil {
+		err = queryErr
+	}
+	if err != nil {
+		return deleted, err
+	}
+	if failed != nil {
+		failed.Deleted = deleted
+		return deleted, failed
+	}
+	return deleted, nil
+}
+
+// DeleteAll deletes all of the entities matched by q with the default
+// DeleteAllOptions, and returns the number deleted.
+//
+// The query is run keys-only, and its results are deleted in batches as they
+// arrive. Entities which fail to be deleted don't stop the rest from being
+// deleted: they're reported in a *DeleteAllError. If the query fails, DeleteAll
+// stops and returns its error.
+func DeleteAll(c context.Context, q *Query) (int, error) {
+	return (&DeleteAllOptions{}).DeleteAll(c, q)
+}
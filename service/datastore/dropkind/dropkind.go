@@ -0,0 +1,239 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dropkind deletes all of the entities of a kind, carefully.
+//
+// Dropping a kind by hand usually means running a keys-only query and deleting
+// its results, which is easy to get wrong for large kinds: the job outlives its
+// request deadline, starves the rest of the app of datastore throughput, or
+// fails half way and must start over. Drop deletes the entities in batches,
+// limits its throughput, and reports a Checkpoint after each batch from which
+// it can be resumed:
+//
+//	n, err := dropkind.Drop(c, "OldThing", &dropkind.Options{
+//	  MaxPerSecond: 500,
+//	  Resume:       saved,
+//	  Progress: func(p *dropkind.Progress) error {
+//	    saved = &p.Checkpoint
+//	    return nil
+//	  },
+//	})
+//
+// Use Options.DryRun first to count the entities which would be deleted.
+package dropkind
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/datastore/meta"
+	"go.chromium.org/gae/service/info"
+
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultBatchSize is the default Options.BatchSize.
+const DefaultBatchSize = 500
+
+// Checkpoint is a position in a Drop, from which it can be resumed.
+type Checkpoint struct {
+	// Namespace is the namespace being processed.
+	Namespace string
+	// Cursor is the encoded cursor of the next batch in Namespace, or empty to
+	// start at the beginning of Namespace.
+	Cursor string
+}
+
+// Progress is reported to Options.Progress after each batch.
+type Progress struct {
+	// Namespace is the namespace of the batch.
+	Namespace string
+	// Count is the number of entities deleted so far, in all namespaces, or the
+	// number counted in a dry run.
+	Count int64
+	// Checkpoint is where to resume from to process the rest of the kind.
+	Checkpoint Checkpoint
+}
+
+// Options are options for Drop.
+type Options struct {
+	// Namespaces, if not nil, selects the namespaces the kind is dropped in,
+	// among all of the namespaces of the datastore. If nil, the kind is only
+	// dropped in the namespace of the Context.
+	Namespaces func(ns string) bool
+
+	// BatchSize is the number of entities queried and deleted at once. If zero,
+	// DefaultBatchSize is used.
+	BatchSize int
+
+	// MaxPerSecond, if positive, limits the number of entities processed per
+	// second, by sleeping between batches.
+	MaxPerSecond int
+
+	// Resume, if not nil, resumes a previous Drop from its last reported
+	// Checkpoint. The namespaces before Resume.Namespace are skipped.
+	Resume *Checkpoint
+
+	// DryRun, if true, makes Drop count the entities without deleting them.
+	DryRun bool
+
+	// Progress, if not nil, is called after each batch. If it returns an error,
+	// Drop stops and returns it.
+	Progress func(*Progress) error
+}
+
+// Drop deletes all of the entities of kind, as configured by o, which may be
+// nil for the default Options. It returns the number of entities deleted, or
+// counted in a dry run, even if it fails.
+//
+// Namespaces are processed in order, and each of them in key order. Entities
+// put while Drop is running may not be deleted.
+func Drop(c context.Context, kind string, o *Options) (int64, error) {
+	if kind == "" || strings.HasPrefix(kind, "__") {
+		return 0, fmt.Errorf("dropkind: can't drop kind %q", kind)
+	}
+	if o == nil {
+		o = &Options{}
+	}
+	d := dropper{Options: o, kind: kind, start: clock.Now(c)}
+	if d.BatchSize <= 0 {
+		d.BatchSize = DefaultBatchSize
+	}
+
+	namespaces := []string{info.GetNamespace(c)}
+	if o.Namespaces != nil {
+		namespaces = nil
+		err := meta.Namespaces(c, func(ns string) error {
+			if o.Namespaces(ns) {
+				namespaces = append(namespaces, ns)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, errors.Annotate(err, "dropkind: failed to list namespaces").Err()
+		}
+	}
+
+	for _, ns := range namespaces {
+		cursor := ""
+		if r := o.Resume; r != nil {
+			if ns < r.Namespace {
+				continue
+			}
+			if ns == r.Namespace {
+				cursor = r.Cursor
+			}
+		}
+		nc, err := info.Namespace(c, ns)
+		if err != nil {
+			return d.count, err
+		}
+		if err := d.dropNamespace(nc, ns, cursor); err != nil {
+			return d.count, err
+		}
+	}
+	return d.count, nil
+}
+
+type dropper struct {
+	*Options
+
+	kind  string
+	start time.Time
+	count int64
+}
+
+func (d *dropper) dropNamespace(c context.Context, ns, cursor string) error {
+	q := ds.NewQuery(d.kind).KeysOnly(true).Limit(int32(d.BatchSize))
+	for {
+		if err := c.Err(); err != nil {
+			return err
+		}
+
+		bq := q
+		if cursor != "" {
+			cur, err := ds.DecodeCursor(c, cursor)
+			if err != nil {
+				return errors.Annotate(err, "dropkind: bad cursor %q", cursor).Err()
+			}
+			bq = q.Start(cur)
+		}
+
+		var (
+			keys []*ds.Key
+			next ds.Cursor
+		)
+		err := ds.Run(c, bq, func(k *ds.Key, getCursor ds.CursorCB) (err error) {
+			if keys = append(keys, k); len(keys) == d.BatchSize {
+				next, err = getCursor()
+			}
+			return
+		})
+		if err != nil {
+			return errors.Annotate(err, "dropkind: failed to query %q in namespace %q", d.kind, ns).Err()
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		if !d.DryRun {
+			if err := ds.Delete(c, keys); err != nil {
+				return errors.Annotate(err, "dropkind: failed to delete %q in namespace %q", d.kind, ns).Err()
+			}
+		}
+		d.count += int64(len(keys))
+
+		cursor = ""
+		if next != nil {
+			cursor = next.String()
+		}
+		if d.Progress != nil {
+			err := d.Progress(&Progress{
+				Namespace:  ns,
+				Count:      d.count,
+				Checkpoint: Checkpoint{ns, cursor},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if next == nil {
+			return nil
+		}
+
+		if err := d.throttle(c); err != nil {
+			return err
+		}
+	}
+}
+
+// throttle sleeps until processing the entities counted so far took at least
+// as long as MaxPerSecond allows.
+func (d *dropper) throttle(c context.Context) error {
+	if d.MaxPerSecond <= 0 {
+		return nil
+	}
+	due := d.start.Add(time.Duration(d.count) * time.Second / time.Duration(d.MaxPerSecond))
+	if wait := due.Sub(clock.Now(c)); wait > 0 {
+		if tr := clock.Sleep(c, wait); tr.Incomplete() {
+			return tr.Err
+		}
+	}
+	return nil
+}
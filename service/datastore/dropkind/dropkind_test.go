@@ -0,0 +1,139 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dropkind
+
+import (
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/info"
+
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/clock/testclock"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+type thing struct {
+	ID int64 `gae:"$id"`
+}
+
+func TestDrop(t *testing.T) {
+	t.Parallel()
+
+	Convey("Drop", t, func() {
+		c, tc := testclock.UseTime(context.Background(), testclock.TestTimeUTC)
+		tc.SetTimerCallback(func(d time.Duration, _ clock.Timer) { tc.Add(d) })
+		c = memory.Use(c)
+		ds.GetTestable(c).Consistent(true)
+
+		put := func(c context.Context, kind string, n int) {
+			pms := make([]ds.PropertyMap, n)
+			for i := range pms {
+				pms[i] = ds.PropertyMap{"$key": ds.MkPropertyNI(ds.NewKey(c, kind, "", int64(11+i), nil))}
+			}
+			So(ds.Put(c, pms), ShouldBeNil)
+		}
+		count := func(c context.Context, kind string) int64 {
+			n, err := ds.Count(c, ds.NewQuery(kind))
+			So(err, ShouldBeNil)
+			return n
+		}
+
+		other := info.MustNamespace(c, "other")
+		put(c, "Thing", 7)
+		put(c, "Keep", 2)
+		put(other, "Thing", 3)
+
+		Convey("counts in a dry run", func() {
+			n, err := Drop(c, "Thing", &Options{DryRun: true, Namespaces: func(string) bool { return true }})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 10)
+			So(count(c, "Thing"), ShouldEqual, 7)
+		})
+
+		Convey("drops in the current namespace", func() {
+			var progress []int64
+			n, err := Drop(c, "Thing", &Options{
+				BatchSize: 3,
+				Progress: func(p *Progress) error {
+					progress = append(progress, p.Count)
+					return nil
+				},
+			})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 7)
+			So(progress, ShouldResemble, []int64{3, 6, 7})
+			So(count(c, "Thing"), ShouldEqual, 0)
+			So(count(c, "Keep"), ShouldEqual, 2)
+			So(count(other, "Thing"), ShouldEqual, 3)
+		})
+
+		Convey("drops in the selected namespaces", func() {
+			n, err := Drop(c, "Thing", &Options{Namespaces: func(ns string) bool { return ns == "other" }})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+			So(count(c, "Thing"), ShouldEqual, 7)
+			So(count(other, "Thing"), ShouldEqual, 0)
+		})
+
+		Convey("resumes from a checkpoint", func() {
+			stop := errors.New("stop")
+			var cp Checkpoint
+			opts := &Options{
+				BatchSize:  2,
+				Namespaces: func(string) bool { return true },
+				Progress: func(p *Progress) error {
+					cp = p.Checkpoint
+					if p.Count == 4 {
+						return stop
+					}
+					return nil
+				},
+			}
+			n, err := Drop(c, "Thing", opts)
+			So(err, ShouldEqual, stop)
+			So(n, ShouldEqual, 4)
+			So(cp.Namespace, ShouldEqual, "")
+			So(count(c, "Thing"), ShouldEqual, 3)
+
+			opts.Resume = &cp
+			opts.Progress = nil
+			n, err = Drop(c, "Thing", opts)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 6)
+			So(count(c, "Thing"), ShouldEqual, 0)
+			So(count(other, "Thing"), ShouldEqual, 0)
+		})
+
+		Convey("limits its throughput", func() {
+			n, err := Drop(c, "Thing", &Options{BatchSize: 2, MaxPerSecond: 2})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 7)
+			So(clock.Now(c).Sub(testclock.TestTimeUTC), ShouldEqual, 3*time.Second)
+		})
+
+		Convey("refuses special kinds", func() {
+			_, err := Drop(c, "__namespace__", nil)
+			So(err, ShouldErrLike, "can't drop kind")
+		})
+	})
+}
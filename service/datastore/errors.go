@@ -32,6 +32,10 @@ var (
 	ErrNoSuchEntity          = datastore.ErrNoSuchEntity
 	ErrConcurrentTransaction = datastore.ErrConcurrentTransaction
 
+	// ErrEntityExists is returned by Mutate for an insert of an entity which
+	// already exists.
+	ErrEntityExists = errors.New("datastore: entity already exists")
+
 	// Stop is understood by various services to stop iterative processes. Examples
 	// include datastore.Interface.Run's callback.
 	Stop = stopErr{}
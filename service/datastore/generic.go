@@ -0,0 +1,144 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package datastore
+
+import (
+	"reflect"
+
+	"golang.org/x/net/context"
+)
+
+// typedArg returns the multiArgType of *T, panicking if T is not a struct or
+// a type whose pointer implements PropertyLoadSaver.
+func typedArg[T any]() *multiArgType {
+	return mustParseArg(reflect.TypeOf((*T)(nil)), false)
+}
+
+// GetT gets the entity of type T with key.
+//
+// T must be a struct, or a type whose pointer implements PropertyLoadSaver.
+//
+// If the entity couldn't be retrieved, GetT returns a nil entity and the error,
+// e.g. ErrNoSuchEntity. If it couldn't be loaded into T, like with an
+// ErrFieldMismatch, GetT returns the partially loaded entity and the error, as
+// Get would.
+func GetT[T any](c context.Context, key *Key) (*T, error) {
+	mat := typedArg[T]()
+	v := mat.newElem()
+	ent := v.Interface().(*T)
+	mat.setKey(v, key)
+
+	if mat.loadCopies {
+		c = withPropertyMapReuse(c)
+	}
+	var getErr, loadErr error
+	meta := NewMultiMetaGetter([]PropertyMap{mat.getMetaPM(v)})
+	err := filterStop(Raw(c).GetMulti([]*Key{key}, meta, func(_ int, pm PropertyMap, err error) error {
+		if getErr = err; err == nil {
+			loadErr = mat.setPM(v, pm)
+		}
+		return nil
+	}))
+	switch {
+	case err != nil:
+		return nil, err
+	case getErr != nil:
+		return nil, getErr
+	case loadErr != nil:
+		return ent, loadErr
+	}
+	return ent, nil
+}
+
+// PutT puts ent, and returns its key. Like Put, if ent has an incomplete key,
+// its ID fields are populated with the allocated one.
+//
+// T must be a struct, or a type whose pointer implements PropertyLoadSaver.
+func PutT[T any](c context.Context, ent *T) (*Key, error) {
+	mat := typedArg[T]()
+	v := reflect.ValueOf(ent)
+	if pls, ok := mat.getPLS(v).(*structPLS); ok {
+		pls.setAutoNow(autoNow(c))
+	}
+
+	key, err := mat.getKey(GetKeyContext(c), v)
+	if err != nil {
+		return nil, err
+	}
+	pm, err := mat.getPM(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret *Key
+	var putErr error
+	err = filterStop(Raw(c).PutMulti([]*Key{key}, []PropertyMap{pm}, func(_ int, k *Key, err error) error {
+		ret, putErr = k, err
+		return nil
+	}))
+	if err == nil {
+		err = putErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ret.Equal(key) {
+		mat.setKey(v, ret)
+	}
+	mat.setVersion(v, pm)
+	return ret, nil
+}
+
+// RunT executes q, calling cb with each of its results loaded into a T. The
+// query stops when cb returns false.
+//
+// T must be a struct, or a type whose pointer implements PropertyLoadSaver.
+// Unlike Run, RunT calls cb directly instead of through reflection.
+func RunT[T any](c context.Context, q *Query, cb func(*T, CursorCB) bool) error {
+	mat := typedArg[T]()
+	fq, err := q.Finalize()
+	if err != nil {
+		return err
+	}
+
+	if mat.loadCopies {
+		c = withPropertyMapReuse(c)
+	}
+	err = Raw(c).Run(fq, func(k *Key, pm PropertyMap, gc CursorCB) error {
+		v := mat.newElem()
+		ent := v.Interface().(*T)
+		if err := mat.setPM(v, pm); err != nil {
+			return err
+		}
+		mat.setKey(v, k)
+		if !cb(ent, gc) {
+			return Stop
+		}
+		return nil
+	})
+	return filterStop(err)
+}
+
+// GetAllT retrieves all of the results of q. It's GetAll for a *[]*T.
+//
+// T must be a struct, or a type whose pointer implements PropertyLoadSaver.
+func GetAllT[T any](c context.Context, q *Query) ([]*T, error) {
+	var ret []*T
+	err := GetAll(c, q, &ret)
+	return ret, err
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/service/info"
+
+	"go.chromium.org/luci/common/clock/testclock"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGenerics(t *testing.T) {
+	t.Parallel()
+
+	Convey("Typed datastore API", t, func() {
+		c := info.Set(context.Background(), fakeInfo{})
+		fds := fakeDatastore{entities: 5}
+		c = SetRawFactory(c, fds.factory())
+
+		Convey("GetT", func() {
+			cs, err := GetT[CommonStruct](c, MakeKey(c, "CommonStruct", 1))
+			So(err, ShouldBeNil)
+			So(cs, ShouldResemble, &CommonStruct{ID: 1, Value: 1})
+
+			cs, err = GetT[CommonStruct](c, MakeKey(c, "DNE", 1))
+			So(err, ShouldEqual, ErrNoSuchEntity)
+			So(cs, ShouldBeNil)
+
+			fpls, err := GetT[FakePLS](c, MakeKey(c, "FakePLS", 2))
+			So(err, ShouldBeNil)
+			So(fpls.IntID, ShouldEqual, 2)
+			So(fpls.Value, ShouldEqual, 1)
+			So(fpls.gotLoaded, ShouldBeTrue)
+
+			pm, err := GetT[PropertyMap](c, MakeKey(c, "Kind", 3))
+			So(err, ShouldBeNil)
+			So((*pm)["Value"], ShouldResemble, MkProperty(1))
+			So((*pm)["$key"], ShouldResemble, MkPropertyNI(MakeKey(c, "Kind", 3)))
+		})
+
+		Convey("PutT", func() {
+			cs := &CommonStruct{}
+			key, err := PutT(c, cs)
+			So(err, ShouldBeNil)
+			So(key, ShouldResemble, MakeKey(c, "CommonStruct", 1))
+			So(cs.ID, ShouldEqual, 1)
+
+			_, err = PutT(c, &FakePLS{Kind: "Fail"})
+			So(err, ShouldEqual, errFail)
+
+			type Model struct {
+				ID      int64 `gae:"$id"`
+				Value   int64
+				Created time.Time `gae:",created"`
+				Updated time.Time `gae:",updated"`
+			}
+			now := testclock.TestTimeUTC.Round(time.Microsecond)
+			tc, _ := testclock.UseTime(c, now)
+			m := &Model{ID: 1}
+			_, err = PutT(tc, m)
+			So(err, ShouldBeNil)
+			So(m.Created, ShouldResemble, now)
+			So(m.Updated, ShouldResemble, now)
+		})
+
+		Convey("RunT", func() {
+			var vals []int64
+			err := RunT(c, NewQuery("Kind"), func(cs *CommonStruct, _ CursorCB) bool {
+				So(cs.ID, ShouldEqual, len(vals)+1)
+				vals = append(vals, cs.Value)
+				return len(vals) < 3
+			})
+			So(err, ShouldBeNil)
+			So(vals, ShouldResemble, []int64{0, 1, 2})
+
+			var pms []PropertyMap
+			err = RunT(c, NewQuery("Kind"), func(pm *PropertyMap, _ CursorCB) bool {
+				pms = append(pms, *pm)
+				return len(pms) < 2
+			})
+			So(err, ShouldBeNil)
+			So(pms, ShouldHaveLength, 2)
+			So(pms[1]["Value"], ShouldResemble, MkProperty(1))
+			So(pms[1]["$key"], ShouldResemble, MkPropertyNI(MakeKey(c, "Kind", 2)))
+
+			So(func() {
+				RunT(c, NewQuery("Kind"), func(*int, CursorCB) bool { return true })
+			}, ShouldPanic)
+		})
+
+		Convey("GetAllT", func() {
+			all, err := GetAllT[CommonStruct](c, NewQuery("Kind"))
+			So(err, ShouldBeNil)
+			So(len(all), ShouldEqual, 5)
+			So(all[4], ShouldResemble, &CommonStruct{ID: 5, Value: 4})
+		})
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// GetOrInsert gets obj, a single entity with a complete key, and if it
+// doesn't exist calls init to fill it in and then Puts it. It returns true if
+// the entity was created.
+//
+// The Get and Put happen in a single transaction, so if the entity is created
+// concurrently, GetOrInsert loads it instead of overwriting it. If c is
+// already in a transaction, that transaction is used. Otherwise a new one is
+// started, in which case init may be called more than once if the transaction
+// is retried.
+//
+// Errors returned by init are returned as-is, and nothing is Put. Errors from
+// the datastore are returned as-is too: in particular, ErrNoSuchEntity is
+// never returned.
+func GetOrInsert(c context.Context, obj interface{}, init func() error) (created bool, err error) {
+	if key := KeyForObj(c, obj); key.IsIncomplete() {
+		return false, fmt.Errorf("datastore: GetOrInsert requires a complete key, got %s", key)
+	}
+
+	getOrInsert := func(c context.Context) error {
+		created = false
+		switch err := Get(c, obj); err {
+		case nil:
+			return nil
+		case ErrNoSuchEntity:
+		default:
+			return err
+		}
+
+		if err := init(); err != nil {
+			return err
+		}
+		if err := Put(c, obj); err != nil {
+			return err
+		}
+		created = true
+		return nil
+	}
+
+	if CurrentTransaction(c) != nil {
+		err = getOrInsert(c)
+	} else {
+		err = RunInTransaction(c, getOrInsert, nil)
+	}
+	if err != nil {
+		created = false
+	}
+	return
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// gobVersion is the version of the PropertyMap gob encoding. It's the first
+// byte of every encoding, so that the format can evolve while still decoding
+// what older versions wrote.
+const gobVersion = 1
+
+// GobEncode implements gob.GobEncoder.
+//
+// The encoding is a version byte followed by the JSON encoding of pm (see
+// JSONOptions), including its meta properties. It doesn't depend on the
+// layout of PropertyMap or Property, and later versions of this package will
+// keep decoding it, so it's safe to store, e.g. in memcache or in files.
+func (pm PropertyMap) GobEncode() ([]byte, error) {
+	data, err := pm.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{gobVersion}, data...), nil
+}
+
+// GobDecode implements gob.GobDecoder. Any existing properties of pm are kept,
+// unless they're overwritten.
+func (pm *PropertyMap) GobDecode(buf []byte) error {
+	if len(buf) == 0 {
+		return fmt.Errorf("datastore: empty PropertyMap gob")
+	}
+	switch v := buf[0]; v {
+	case 1:
+		return json.Unmarshal(buf[1:], pm)
+	default:
+		return fmt.Errorf("datastore: unknown PropertyMap gob version %d", v)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestGob(t *testing.T) {
+	t.Parallel()
+
+	Convey("Gob", t, func() {
+		k := MkKeyContext("app", "ns").MakeKey("Foo", 1)
+		pm := PropertyMap{
+			"$key": MkPropertyNI(k),
+			"Val":  PropertySlice{MkProperty(1), MkPropertyNI("two")},
+			"When": MkProperty(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)),
+		}
+
+		Convey("round trips", func() {
+			type cached struct {
+				Key *Key
+				PM  PropertyMap
+			}
+			buf := bytes.Buffer{}
+			So(gob.NewEncoder(&buf).Encode(&cached{k, pm}), ShouldBeNil)
+
+			var dec cached
+			So(gob.NewDecoder(&buf).Decode(&dec), ShouldBeNil)
+			So(dec.Key, ShouldResemble, k)
+			So(dec.PM, ShouldResemble, pm)
+		})
+
+		Convey("is stable", func() {
+			data, err := PropertyMap{"Val": MkProperty(1)}.GobEncode()
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "\x01"+`{"Val":{"type":"PTInt","value":1}}`)
+		})
+
+		Convey("rejects unknown versions", func() {
+			var dec PropertyMap
+			So(dec.GobDecode([]byte("\x02{}")), ShouldErrLike, "unknown PropertyMap gob version 2")
+			So(dec.GobDecode(nil), ShouldErrLike, "empty PropertyMap gob")
+		})
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// IndexBuilder builds compound IndexDefinitions.
+//
+// IndexBuilder is immutable: every method returns a new IndexBuilder, so
+// a partially built index can be safely reused as the base for several
+// others. The first error encountered is retained and returned by Build.
+//
+// Example:
+//
+//	idx, err := NewIndex("Post").Ancestor().Asc("Tag").Desc("Created").Build()
+type IndexBuilder struct {
+	def IndexDefinition
+	err error
+}
+
+// NewIndex returns a new IndexBuilder for a compound index on kind.
+func NewIndex(kind string) *IndexBuilder {
+	return &IndexBuilder{def: IndexDefinition{Kind: kind}}
+}
+
+func (b *IndexBuilder) mod(cb func(*IndexBuilder)) *IndexBuilder {
+	if b.err != nil {
+		return b
+	}
+	ret := &IndexBuilder{def: b.def}
+	ret.def.SortBy = make([]IndexColumn, len(b.def.SortBy))
+	copy(ret.def.SortBy, b.def.SortBy)
+	cb(ret)
+	return ret
+}
+
+// Ancestor makes the index an ancestor index, which can be used by queries
+// with an Ancestor filter.
+func (b *IndexBuilder) Ancestor() *IndexBuilder {
+	return b.mod(func(b *IndexBuilder) {
+		b.def.Ancestor = true
+	})
+}
+
+// Asc adds an ascending column for each of props, in order.
+func (b *IndexBuilder) Asc(props ...string) *IndexBuilder {
+	return b.addColumns(false, props)
+}
+
+// Desc adds a descending column for each of props, in order.
+func (b *IndexBuilder) Desc(props ...string) *IndexBuilder {
+	return b.addColumns(true, props)
+}
+
+func (b *IndexBuilder) addColumns(descending bool, props []string) *IndexBuilder {
+	if len(props) == 0 {
+		return b
+	}
+	return b.mod(func(b *IndexBuilder) {
+		for _, p := range props {
+			b.def.SortBy = append(b.def.SortBy, IndexColumn{Property: p, Descending: descending})
+		}
+	})
+}
+
+// Columns adds a column for each of specs, in order. Each spec is parsed with
+// ParseIndexColumn, so "-Prop" is a descending column and "Prop" is an
+// ascending one.
+func (b *IndexBuilder) Columns(specs ...string) *IndexBuilder {
+	if len(specs) == 0 {
+		return b
+	}
+	return b.mod(func(b *IndexBuilder) {
+		for _, spec := range specs {
+			col, err := ParseIndexColumn(spec)
+			if err != nil {
+				b.err = err
+				return
+			}
+			b.def.SortBy = append(b.def.SortBy, col)
+		}
+	})
+}
+
+// Build returns the built IndexDefinition, or an error if any of the builder
+// calls failed or the resulting index does not pass Validate.
+func (b *IndexBuilder) Build() (*IndexDefinition, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	ret := b.def
+	ret.SortBy = make([]IndexColumn, len(b.def.SortBy))
+	copy(ret.SortBy, b.def.SortBy)
+	if err := ret.Validate(); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// MustBuild is like Build, but panics on error. It's intended for
+// initializing package-level variables and for tests.
+func (b *IndexBuilder) MustBuild() *IndexDefinition {
+	ret, err := b.Build()
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Validate returns an error if this IndexDefinition is not a valid compound
+// index definition, as it would be accepted in an index YAML file.
+func (id *IndexDefinition) Validate() error {
+	if id.Kind == "" {
+		return fmt.Errorf("datastore: index has no kind")
+	}
+	if isReservedName(id.Kind) {
+		return fmt.Errorf("datastore: index kind %q is reserved", id.Kind)
+	}
+
+	for i, col := range id.SortBy {
+		switch {
+		case col.Property == "":
+			return fmt.Errorf("datastore: index %s has an empty property", id)
+		case col.Property == "__key__":
+			if i != len(id.SortBy)-1 {
+				return fmt.Errorf("datastore: index %s: __key__ may only be the last column", id)
+			}
+		case isReservedName(col.Property):
+			return fmt.Errorf("datastore: index %s: property %q is reserved", id, col.Property)
+		}
+	}
+
+	if !id.Compound() {
+		return fmt.Errorf("datastore: index %s is not a compound index", id)
+	}
+	return nil
+}
+
+// WriteIndexYAML writes idxs to w as an index YAML file, suitable for
+// deployment or for ParseIndexYAML.
+//
+// Returns an error if any of the indexes fail Validate.
+func WriteIndexYAML(w io.Writer, idxs ...*IndexDefinition) error {
+	if _, err := io.WriteString(w, "indexes:\n"); err != nil {
+		return err
+	}
+	for _, idx := range idxs {
+		if err := idx.Validate(); err != nil {
+			return err
+		}
+		yaml, err := idx.YAMLString()
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "\n%s\n", yaml); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isReservedName(name string) bool {
+	return strings.HasPrefix(name, "__") && strings.HasSuffix(name, "__")
+}
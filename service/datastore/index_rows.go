@@ -0,0 +1,157 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"sort"
+)
+
+// IndexRow is one of the rows which the datastore writes to an index for an
+// entity.
+type IndexRow struct {
+	// Index is the normalized index which the row belongs to.
+	Index *IndexDefinition
+
+	// Values has a value for each column of Index.GetFullSortOrder(), in order.
+	// The value of an "__ancestor__" column is the key of the entity or of one
+	// of its ancestors, and the value of the "__key__" column is the key of the
+	// entity.
+	Values []Property
+}
+
+// BuiltinIndexes returns the built-in indexes which the datastore maintains
+// for an entity of kind with the properties in pm: the kind index, and an
+// ascending and a descending index on each property which has an indexed
+// value. They're sorted with IndexDefinition.Less.
+func BuiltinIndexes(kind string, pm PropertyMap) []*IndexDefinition {
+	ret := make([]*IndexDefinition, 0, 2*len(pm)+1)
+	ret = append(ret, &IndexDefinition{Kind: kind})
+	for name := range pm {
+		if isMetaKey(name) || len(indexedValues(pm.Slice(name))) == 0 {
+			continue
+		}
+		ret = append(ret,
+			&IndexDefinition{Kind: kind, SortBy: []IndexColumn{{Property: name}}},
+			&IndexDefinition{Kind: kind, SortBy: []IndexColumn{{Property: name, Descending: true}}})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Less(ret[j]) })
+	return ret
+}
+
+// IndexRows returns the rows which the datastore would write to idxs for the
+// entity with key and properties pm. This is useful to maintain custom
+// indexes, to estimate the cost of writes, or to find out why a query does or
+// doesn't return an entity.
+//
+// The rows are returned in the order of idxs, and in the order of each index.
+// An index gets no rows if it's on another kind, or if the entity has no
+// indexed value for one of its properties. An index gets a row for each
+// combination of the distinct indexed values of its properties, so
+// multi-valued properties may produce many rows.
+//
+// Meta properties in pm are ignored. Only the indexes in idxs are considered;
+// use BuiltinIndexes to include the built-in ones.
+func IndexRows(key *Key, pm PropertyMap, idxs []*IndexDefinition) []*IndexRow {
+	var ret []*IndexRow
+	for _, idx := range idxs {
+		idx = idx.Normalize()
+		if idx.Kind != "" && idx.Kind != key.Kind() {
+			continue
+		}
+
+		cols := idx.GetFullSortOrder()
+		vals := make([]PropertySlice, len(cols))
+		for i, col := range cols {
+			vals[i] = columnValues(key, pm, col)
+			if len(vals[i]) == 0 {
+				vals = nil
+				break
+			}
+		}
+		if vals == nil {
+			continue
+		}
+
+		// Walk the cartesian product of vals, like an odometer. Since each of
+		// vals is in index order, so are the rows.
+		pos := make([]int, len(vals))
+		for {
+			row := &IndexRow{Index: idx, Values: make([]Property, len(vals))}
+			for i, p := range pos {
+				row.Values[i] = vals[i][p]
+			}
+			ret = append(ret, row)
+
+			i := len(pos) - 1
+			for ; i >= 0; i-- {
+				if pos[i]++; pos[i] < len(vals[i]) {
+					break
+				}
+				pos[i] = 0
+			}
+			if i < 0 {
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// columnValues returns the distinct values of col for the entity, in the
+// order of col.
+func columnValues(key *Key, pm PropertyMap, col IndexColumn) PropertySlice {
+	var ret PropertySlice
+	switch col.Property {
+	case "__key__":
+		ret = PropertySlice{MkProperty(key)}
+
+	case "__ancestor__":
+		for k := key; k != nil; k = k.Parent() {
+			ret = append(ret, MkProperty(k))
+		}
+
+	default:
+		if isMetaKey(col.Property) {
+			return nil
+		}
+		ret = indexedValues(pm.Slice(col.Property))
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if col.Descending {
+			return ret[j].Less(&ret[i])
+		}
+		return ret[i].Less(&ret[j])
+	})
+	return ret
+}
+
+// indexedValues returns the distinct indexed values in vals.
+func indexedValues(vals PropertySlice) PropertySlice {
+	var ret PropertySlice
+outer:
+	for _, v := range vals {
+		if v.IndexSetting() == NoIndex {
+			continue
+		}
+		for i := range ret {
+			if ret[i].Equal(&v) {
+				continue outer
+			}
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
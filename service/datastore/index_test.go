@@ -17,12 +17,15 @@
 package datastore
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 	"testing"
 
-	. "github.com/smartystreets/goconvey/convey"
 	"gopkg.in/yaml.v2"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
 )
 
 var indexDefinitionTests = []struct {
@@ -110,3 +113,115 @@ func TestIndexDefinition(t *testing.T) {
 		}
 	})
 }
+
+func TestIndexBuilder(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test IndexBuilder", t, func() {
+		Convey("builds compound indexes", func() {
+			base := NewIndex("Foo").Ancestor().Asc("A")
+			idx, err := base.Desc("B").Build()
+			So(err, ShouldBeNil)
+			So(idx.String(), ShouldEqual, "C:Foo|A/A/-B")
+
+			Convey("without modifying the base builder", func() {
+				other := base.Columns("-C", "__key__").MustBuild()
+				So(other.String(), ShouldEqual, "C:Foo|A/A/-C/__key__")
+				So(base.Asc("B").MustBuild().String(), ShouldEqual, "C:Foo|A/A/B")
+			})
+		})
+
+		Convey("rejects invalid indexes", func() {
+			_, err := NewIndex("").Asc("A", "B").Build()
+			So(err, ShouldErrLike, "has no kind")
+
+			_, err = NewIndex("Foo").Asc("A").Build()
+			So(err, ShouldErrLike, "not a compound index")
+
+			_, err = NewIndex("Foo").Asc("A").Desc("A").Build()
+			So(err, ShouldBeNil)
+
+			_, err = NewIndex("Foo").Asc("__key__", "A").Build()
+			So(err, ShouldErrLike, "may only be the last column")
+
+			_, err = NewIndex("Foo").Asc("__scatter__", "A").Build()
+			So(err, ShouldErrLike, "is reserved")
+
+			_, err = NewIndex("Foo").Columns("-").Asc("A").Build()
+			So(err, ShouldErrLike, "empty order")
+
+			So(func() { NewIndex("Foo").MustBuild() }, ShouldPanic)
+		})
+
+		Convey("WriteIndexYAML round-trips through ParseIndexYAML", func() {
+			idxs := []*IndexDefinition{
+				NewIndex("Foo").Asc("A").Desc("B").MustBuild(),
+				NewIndex("Bar").Ancestor().Desc("C").MustBuild(),
+			}
+			buf := bytes.Buffer{}
+			So(WriteIndexYAML(&buf, idxs...), ShouldBeNil)
+
+			parsed, err := ParseIndexYAML(&buf)
+			So(err, ShouldBeNil)
+			So(parsed, ShouldResemble, idxs)
+
+			So(WriteIndexYAML(&buf, &IndexDefinition{Kind: "Foo"}), ShouldErrLike, "not a compound index")
+		})
+	})
+}
+
+func TestIndexRows(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test IndexRows", t, func() {
+		kc := MkKeyContext("app", "ns")
+		parent := kc.MakeKey("Parent", 1)
+		key := kc.NewKey("Foo", "", 2, parent)
+		pm := PropertyMap{
+			"$kind": MkPropertyNI("Foo"),
+			"Tags":  PropertySlice{MkProperty("b"), MkProperty("a"), MkProperty("b"), MkPropertyNI("z")},
+			"Val":   MkProperty(10),
+			"Blob":  MkPropertyNI([]byte("nope")),
+		}
+
+		rowStrings := func(rows []*IndexRow) []string {
+			ret := make([]string, len(rows))
+			for i, r := range rows {
+				vals := make([]string, len(r.Values))
+				for j, v := range r.Values {
+					vals[j] = fmt.Sprint(v.Value())
+				}
+				ret[i] = r.Index.String() + " " + strings.Join(vals, ",")
+			}
+			return ret
+		}
+
+		Convey("builtin indexes", func() {
+			idxs := BuiltinIndexes("Foo", pm)
+			So(len(idxs), ShouldEqual, 5)
+			So(rowStrings(IndexRows(key, pm, idxs)), ShouldResemble, []string{
+				"B:Foo/__key__ " + key.String(),
+				"C:Foo/Tags/__key__ a," + key.String(),
+				"C:Foo/Tags/__key__ b," + key.String(),
+				"C:Foo/Val/__key__ 10," + key.String(),
+				"C:Foo/-Tags/__key__ b," + key.String(),
+				"C:Foo/-Tags/__key__ a," + key.String(),
+				"C:Foo/-Val/__key__ 10," + key.String(),
+			})
+		})
+
+		Convey("compound indexes", func() {
+			idxs := []*IndexDefinition{
+				NewIndex("Foo").Ancestor().Desc("Tags").MustBuild(),
+				NewIndex("Foo").Asc("Val", "Blob").MustBuild(),
+				NewIndex("Bar").Asc("Val", "Tags").MustBuild(),
+			}
+			So(rowStrings(IndexRows(key, pm, idxs)), ShouldResemble, []string{
+				"C:Foo|A/-Tags/__key__ " + parent.String() + ",b," + key.String(),
+				"C:Foo|A/-Tags/__key__ " + parent.String() + ",a," + key.String(),
+				"C:Foo|A/-Tags/__key__ " + key.String() + ",b," + key.String(),
+				"C:Foo|A/-Tags/__key__ " + key.String() + ",a," + key.String(),
+			})
+		})
+	})
+}
@@ -17,7 +17,10 @@ package datastore
 import (
 	"fmt"
 	"reflect"
+	"time"
 
+	"go.chromium.org/gae/service/tuning"
+	"go.chromium.org/luci/common/clock"
 	"go.chromium.org/luci/common/errors"
 	"golang.org/x/net/context"
 )
@@ -35,8 +38,8 @@ func parseRunCallback(cbIface interface{}) (rcb resolvedRunCallback, isKey bool,
 		badSig()
 	}
 
-	// TODO(riannucci): Profile and determine if any of this is causing a real
-	// slowdown. Could potentially cache reflection stuff by cbTyp?
+	// The signature checks below are cheap; the expensive reflection on the
+	// callback's argument type is cached by parseArg.
 	cbVal := reflect.ValueOf(cbIface)
 	cbTyp := cbVal.Type()
 
@@ -256,6 +259,18 @@ func NewKey(c context.Context, kind, stringID string, intID int64, parent *Key)
 	return kc.NewKey(kind, stringID, intID, parent)
 }
 
+// ReserveIDRange reserves the integer IDs in the inclusive range [start, end]
+// for entities with the kind, parent and namespace of key, so that they will
+// never be assigned by AllocateIDs or by a Put with an incomplete key. Any ID
+// in key is ignored.
+//
+// This is intended for importing entities with fixed integer IDs: reserving
+// the range spanned by the imported IDs (before or after writing them)
+// guarantees that later automatic allocation won't collide with them.
+func ReserveIDRange(c context.Context, key *Key, start, end int64) error {
+	return Raw(c).ReserveIDRange(key.Incomplete(), start, end)
+}
+
 // NewIncompleteKeys allocates count incomplete keys sharing the same kind and
 // parent. It is useful as input to AllocateIDs.
 func NewIncompleteKeys(c context.Context, count int, kind string, parent *Key) (keys []*Key) {
@@ -319,7 +334,18 @@ func populateKeyMGS(mgs MetaGetterSetter, key *Key) bool {
 // Note that the behavior of transactions may change depending on what filters
 // have been installed. It's possible that we'll end up implementing things
 // like nested/buffered transactions as filters.
+//
+// If opts doesn't specify the number of Attempts, the TransactionAttempts of
+// the tuning.Options installed in c, if any, is used.
 func RunInTransaction(c context.Context, f func(c context.Context) error, opts *TransactionOptions) error {
+	if attempts := tuning.Get(c).Datastore.TransactionAttempts; attempts > 0 && (opts == nil || opts.Attempts == 0) {
+		cpy := TransactionOptions{}
+		if opts != nil {
+			cpy = *opts
+		}
+		cpy.Attempts = attempts
+		opts = &cpy
+	}
 	return Raw(c).RunInTransaction(f, opts)
 }
 
@@ -360,6 +386,9 @@ func Run(c context.Context, q *Query, cb interface{}) error {
 		return err
 	}
 
+	if !isKey && mat.loadCopies {
+		c = withPropertyMapReuse(c)
+	}
 	raw := Raw(c)
 
 	if isKey {
@@ -412,11 +441,11 @@ func DecodeCursor(c context.Context, s string) (Cursor, error) {
 //   - *[]P or *[]*P, where *P is a concrete type implementing
 //     PropertyLoadSaver
 //   - *[]*Key implies a keys-only query.
+//
+// If keys-then-values is enabled with WithKeysThenValues, full (not keys-only
+// or projection) queries are run keys-only, and their results fetched with
+// GetMulti.
 func GetAll(c context.Context, q *Query, dst interface{}) error {
-	return getAllRaw(Raw(c), q, dst)
-}
-
-func getAllRaw(raw RawInterface, q *Query, dst interface{}) error {
 	v := reflect.ValueOf(dst)
 	if v.Kind() != reflect.Ptr {
 		panic(fmt.Errorf("invalid GetAll dst: must have a ptr-to-slice: %T", dst))
@@ -431,7 +460,7 @@ func getAllRaw(raw RawInterface, q *Query, dst interface{}) error {
 			return err
 		}
 
-		return raw.Run(fq, func(k *Key, _ PropertyMap, _ CursorCB) error {
+		return Raw(c).Run(fq, func(k *Key, _ PropertyMap, _ CursorCB) error {
 			*keys = append(*keys, k)
 			return nil
 		})
@@ -447,6 +476,14 @@ func getAllRaw(raw RawInterface, q *Query, dst interface{}) error {
 		panic(fmt.Errorf("invalid GetAll dst (non-concrete element type): %T", dst))
 	}
 
+	if mat.loadCopies {
+		c = withPropertyMapReuse(c)
+	}
+	raw := Raw(c)
+	if getKeysThenValues(c) && !fq.KeysOnly() && len(fq.Project()) == 0 {
+		return getAllKeysThenValues(raw, fq, slice, mat)
+	}
+
 	errs := map[int]error{}
 	i := 0
 	err = filterStop(raw.Run(fq, func(k *Key, pm PropertyMap, _ CursorCB) error {
@@ -472,6 +509,75 @@ func getAllRaw(raw RawInterface, q *Query, dst interface{}) error {
 	return err
 }
 
+// getAllKeysThenValues implements GetAll by running fq keys-only and then
+// getting its results into slice with GetMulti, which filters like
+// "filter/dscache" can serve from their cache.
+//
+// Entities which were deleted between the query and the GetMulti are left out
+// of the results, as if the query had run after their deletion.
+func getAllKeysThenValues(raw RawInterface, fq *FinalizedQuery, slice reflect.Value, mat *multiArgType) error {
+	kfq, err := fq.Original().KeysOnly(true).Finalize()
+	if err != nil {
+		return err
+	}
+	var keys []*Key
+	err = filterStop(raw.Run(kfq, func(k *Key, _ PropertyMap, _ CursorCB) error {
+		keys = append(keys, k)
+		return nil
+	}))
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	start := slice.Len()
+	metas := make([]PropertyMap, len(keys))
+	for i, k := range keys {
+		slice.Set(reflect.Append(slice, mat.newElem()))
+		itm := slice.Index(start + i)
+		mat.setKey(itm, k)
+		metas[i] = mat.getMetaPM(itm)
+	}
+
+	getErrs := make([]error, len(keys))
+	err = filterStop(raw.GetMulti(keys, NewMultiMetaGetter(metas), func(idx int, pm PropertyMap, err error) error {
+		if err == nil {
+			err = mat.setPM(slice.Index(start+idx), pm)
+		}
+		getErrs[idx] = err
+		return nil
+	}))
+	if err != nil {
+		slice.Set(slice.Slice(0, start))
+		return err
+	}
+
+	// Drop the deleted entities, keeping the order of the others.
+	errs := map[int]error{}
+	n := 0
+	for i, err := range getErrs {
+		if err == ErrNoSuchEntity {
+			continue
+		}
+		if n != i {
+			slice.Index(start + n).Set(slice.Index(start + i))
+		}
+		if err != nil {
+			errs[n] = err
+		}
+		n++
+	}
+	slice.Set(slice.Slice(0, start+n))
+
+	if len(errs) > 0 {
+		me := make(errors.MultiError, slice.Len())
+		for i, e := range errs {
+			me[i] = e
+		}
+		return me
+	}
+	return nil
+}
+
 // Exists tests if the supplied objects are present in the datastore.
 //
 // ent must be one of:
@@ -564,6 +670,9 @@ func Get(c context.Context, dst ...interface{}) error {
 		return nil
 	}
 
+	if mma.loadCopies() {
+		c = withPropertyMapReuse(c)
+	}
 	et := newErrorTracker(mma)
 	meta := NewMultiMetaGetter(pms)
 	err = filterStop(Raw(c).GetMulti(keys, meta, func(idx int, pm PropertyMap, err error) error {
@@ -589,6 +698,58 @@ func Get(c context.Context, dst ...interface{}) error {
 	return maybeSingleError(err, dst)
 }
 
+// GetFound gets the entities in the slice pointed to by dst, like Get, and
+// then removes the ones which don't exist from the slice, returning their keys.
+// This saves callers from picking ErrNoSuchEntity out of a MultiError.
+//
+// dst must be a pointer to one of the slice types accepted by Get. The found
+// entities keep their order.
+//
+// If some entities fail for another reason, GetFound returns the error of Get
+// and leaves *dst unchanged.
+func GetFound(c context.Context, dst interface{}) (missing []*Key, err error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		panic(fmt.Errorf("invalid GetFound dst: must have a ptr-to-slice: %T", dst))
+	}
+	slice := v.Elem()
+	if slice.Len() == 0 {
+		return nil, nil
+	}
+
+	err = Get(c, slice.Interface())
+	if err == nil {
+		return nil, nil
+	}
+	me, ok := err.(errors.MultiError)
+	if !ok {
+		return nil, err
+	}
+	for _, e := range me {
+		if e != nil && e != ErrNoSuchEntity {
+			return nil, err
+		}
+	}
+
+	kc := GetKeyContext(c)
+	mat := mustParseMultiArg(slice.Type())
+	found := reflect.MakeSlice(slice.Type(), 0, slice.Len())
+	for i, e := range me {
+		itm := slice.Index(i)
+		if e == nil {
+			found = reflect.Append(found, itm)
+			continue
+		}
+		key, err := mat.getKey(kc, itm)
+		if err != nil {
+			return nil, err
+		}
+		missing = append(missing, key)
+	}
+	slice.Set(found)
+	return missing, nil
+}
+
 // Put writes objects into the datastore.
 //
 // src must be one of:
@@ -619,10 +780,16 @@ func Get(c context.Context, dst ...interface{}) error {
 // that in the scenario where multiple slices are provided, this will return a
 // MultiError containing a nested MultiError for each slice argument.
 func Put(c context.Context, src ...interface{}) error {
-	return putRaw(Raw(c), GetKeyContext(c), src)
+	return putRaw(Raw(c), GetKeyContext(c), autoNow(c), src)
+}
+
+// autoNow returns the time to set the 'created' and 'updated' fields of
+// structs written in c to.
+func autoNow(c context.Context) time.Time {
+	return RoundTime(clock.Now(c).UTC())
 }
 
-func putRaw(raw RawInterface, kctx KeyContext, src []interface{}) error {
+func putRaw(raw RawInterface, kctx KeyContext, now time.Time, src []interface{}) error {
 	if len(src) == 0 {
 		return nil
 	}
@@ -631,6 +798,7 @@ func putRaw(raw RawInterface, kctx KeyContext, src []interface{}) error {
 	if err != nil {
 		panic(err)
 	}
+	mma.setAutoNow(now)
 
 	keys, vals, err := mma.getKeysPMs(kctx, false)
 	if err != nil {
@@ -649,10 +817,11 @@ func putRaw(raw RawInterface, kctx KeyContext, src []interface{}) error {
 			return nil
 		}
 
+		mat, v := mma.get(index)
 		if !key.Equal(keys[idx]) {
-			mat, v := mma.get(index)
 			mat.setKey(v, key)
 		}
+		mat.setVersion(v, vals[idx])
 
 		return nil
 	}))
@@ -716,6 +885,14 @@ func Delete(c context.Context, ent ...interface{}) error {
 	return maybeSingleError(err, ent)
 }
 
+// GetConstraints returns the constraints of the datastore implementation in c.
+//
+// Generic code can use them to adapt to the implementation, e.g. by avoiding
+// cross-group transactions where they're not supported.
+func GetConstraints(c context.Context) Constraints {
+	return Raw(c).Constraints()
+}
+
 // GetTestable returns the Testable interface for the implementation, or nil if
 // there is none.
 func GetTestable(c context.Context) Testable {
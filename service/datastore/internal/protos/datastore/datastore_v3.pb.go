@@ -866,6 +866,7 @@ type Reference struct {
 	App              *string `protobuf:"bytes,13,req,name=app" json:"app,omitempty"`
 	NameSpace        *string `protobuf:"bytes,20,opt,name=name_space" json:"name_space,omitempty"`
 	Path             *Path   `protobuf:"bytes,14,req,name=path" json:"path,omitempty"`
+	DatabaseId       *string `protobuf:"bytes,23,opt,name=database_id" json:"database_id,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -894,6 +895,13 @@ func (m *Reference) GetPath() *Path {
 	return nil
 }
 
+func (m *Reference) GetDatabaseId() string {
+	if m != nil && m.DatabaseId != nil {
+		return *m.DatabaseId
+	}
+	return ""
+}
+
 type User struct {
 	Email             *string `protobuf:"bytes,1,req,name=email" json:"email,omitempty"`
 	AuthDomain        *string `protobuf:"bytes,2,req,name=auth_domain" json:"auth_domain,omitempty"`
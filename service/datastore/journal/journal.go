@@ -0,0 +1,280 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package journal reliably applies datastore mutations asynchronously.
+//
+// A Mutation is journaled in the datastore by AddToJournal, typically inside
+// the caller's transaction, so that it's recorded if and only if the
+// transaction commits:
+//
+//	err := datastore.RunInTransaction(c, func(c context.Context) error {
+//	  ...
+//	  return journal.AddToJournal(c, &IncrementCounter{Name: "foo"})
+//	}, nil)
+//
+// Journaled mutations are later rolled forward by task queue tasks, each in
+// a transaction on the mutation's root entity group which also deletes it
+// from the journal, so each mutation's datastore changes are applied exactly
+// once. Its other side effects (e.g. non-transactional task queue adds or
+// urlfetch) may happen more than once if the transaction is retried.
+// Mutations in the same entity group are rolled forward in the order that
+// they were journaled. RollForward may return further mutations, which are
+// journaled in the same transaction.
+//
+// Mutations must be registered with Register, usually at init time, and must
+// survive a round trip through encoding/json.
+//
+// The journal is split into shards, each processed by its own tasks. Shard
+// tasks are added when AddToJournal is called outside of a transaction, when
+// mutations produce more mutations, and by FireAllTasks, which must be called
+// periodically (e.g. by mounting Handler and adding a cron job requesting
+// CronPath). Mutations journaled inside a transaction are therefore applied
+// within one cron interval.
+//
+// The journal lives in the current namespace: mutations must be rooted in the
+// namespace that they're journaled in, and FireAllTasks only processes the
+// journal of the namespace that it's called in.
+//
+// In tests, RunPending rolls forward all of the journaled mutations directly,
+// without going through the task queue.
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sync"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// Mutation is a change to the datastore which is journaled and applied later.
+type Mutation interface {
+	// Root returns the root key of the entity group that RollForward changes.
+	// The mutation is journaled in this entity group, so if AddToJournal is
+	// called in a transaction on another entity group, it must be an XG
+	// transaction.
+	Root(c context.Context) *ds.Key
+
+	// RollForward applies the mutation. It's called in a transaction on the
+	// entity group of Root, which it must not leave.
+	//
+	// It may return more mutations, which are journaled in the same
+	// transaction. If it returns an error, the transaction is rolled back, and
+	// the mutation is retried later.
+	RollForward(c context.Context) ([]Mutation, error)
+}
+
+var registry = struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{byName: map[string]reflect.Type{}, byType: map[reflect.Type]string{}}
+
+// Register registers the type of m, which is journaled with name.
+//
+// Register panics if name or the type of m is already registered.
+func Register(name string, m Mutation) {
+	t := reflect.TypeOf(m)
+
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.byName[name]; ok {
+		panic(fmt.Errorf("journal: %q is already registered", name))
+	}
+	if other, ok := registry.byType[t]; ok {
+		panic(fmt.Errorf("journal: %s is already registered as %q", t, other))
+	}
+	registry.byName[name] = t
+	registry.byType[t] = name
+}
+
+// Config tunes the journal. See WithConfig.
+type Config struct {
+	// NumShards is the number of journal shards that new mutations are spread
+	// over. Changing it is safe: FireAllTasks keeps processing the shards that
+	// still have mutations, even those beyond the new number. If zero,
+	// DefaultNumShards is used.
+	NumShards int
+
+	// Queue is the task queue that the shard tasks are added to. If empty, the
+	// default queue is used.
+	Queue string
+
+	// BatchSize is the number of journal entries that each shard task looks at.
+	// If the shard has more, the task adds another. If zero, DefaultBatchSize
+	// is used.
+	BatchSize int
+
+	// TemporalRounding is the length of the windows in which shard tasks are
+	// deduplicated: at most one task is added for each shard in each window. If
+	// zero, DefaultTemporalRounding is used.
+	TemporalRounding time.Duration
+}
+
+// Defaults for Config.
+const (
+	DefaultNumShards        = 16
+	DefaultBatchSize        = 50
+	DefaultTemporalRounding = 10 * time.Second
+)
+
+type key int
+
+var configKey key
+
+// WithConfig returns a Context which uses cfg for the journal.
+func WithConfig(c context.Context, cfg Config) context.Context {
+	return context.WithValue(c, configKey, cfg)
+}
+
+func getConfig(c context.Context) Config {
+	cfg, _ := c.Value(configKey).(Config)
+	if cfg.NumShards <= 0 {
+		cfg.NumShards = DefaultNumShards
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.TemporalRounding <= 0 {
+		cfg.TemporalRounding = DefaultTemporalRounding
+	}
+	return cfg
+}
+
+// entryKind is the kind of journal entries.
+const entryKind = "gae.journal.Entry"
+
+// entry is the datastore entity of a journaled mutation.
+type entry struct {
+	_kind string `gae:"$kind,gae.journal.Entry"`
+
+	ID     int64   `gae:"$id"`
+	Parent *ds.Key `gae:"$parent"`
+
+	// Shard is the shard that the entry is processed by.
+	Shard int64
+
+	// Created is when the mutation was journaled. Entries in an entity group
+	// are rolled forward in this order.
+	Created time.Time `gae:",noindex"`
+
+	// Type is the name that the mutation's type is registered with, and Data is
+	// the mutation encoded as JSON.
+	Type string `gae:",noindex"`
+	Data []byte `gae:",noindex"`
+}
+
+// shardOf returns the shard of mutations rooted at root.
+func shardOf(root *ds.Key, numShards int) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(root.Encode()))
+	return int64(h.Sum64() % uint64(numShards))
+}
+
+func newEntry(c context.Context, cfg *Config, m Mutation) (*entry, error) {
+	t := reflect.TypeOf(m)
+	registry.RLock()
+	name, ok := registry.byType[t]
+	registry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("journal: %s is not registered", t)
+	}
+
+	root := m.Root(c)
+	if root == nil {
+		return nil, fmt.Errorf("journal: %q has no root", name)
+	}
+	root = root.Root()
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to encode %q", name).Err()
+	}
+	return &entry{
+		Parent:  root,
+		Shard:   shardOf(root, cfg.NumShards),
+		Created: clock.Now(c).UTC(),
+		Type:    name,
+		Data:    data,
+	}, nil
+}
+
+func (e *entry) mutation() (Mutation, error) {
+	registry.RLock()
+	t, ok := registry.byName[e.Type]
+	registry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("journal: no mutation is registered as %q", e.Type)
+	}
+
+	var v reflect.Value
+	if t.Kind() == reflect.Ptr {
+		v = reflect.New(t.Elem())
+		if err := json.Unmarshal(e.Data, v.Interface()); err != nil {
+			return nil, errors.Annotate(err, "failed to decode %q", e.Type).Err()
+		}
+	} else {
+		p := reflect.New(t)
+		if err := json.Unmarshal(e.Data, p.Interface()); err != nil {
+			return nil, errors.Annotate(err, "failed to decode %q", e.Type).Err()
+		}
+		v = p.Elem()
+	}
+	return v.Interface().(Mutation), nil
+}
+
+// AddToJournal journals muts, to be rolled forward later.
+//
+// If c is in a transaction, muts are journaled in it. Otherwise, they're
+// journaled immediately, and tasks are added to roll them forward.
+func AddToJournal(c context.Context, muts ...Mutation) error {
+	if len(muts) == 0 {
+		return nil
+	}
+	cfg := getConfig(c)
+	shards, err := addToJournal(c, &cfg, muts)
+	if err != nil {
+		return err
+	}
+	if ds.CurrentTransaction(c) == nil {
+		return fireTasks(c, &cfg, shards)
+	}
+	return nil
+}
+
+// addToJournal puts entries for muts, and returns the shards that they're in.
+func addToJournal(c context.Context, cfg *Config, muts []Mutation) (map[int64]struct{}, error) {
+	ents := make([]*entry, len(muts))
+	shards := map[int64]struct{}{}
+	for i, m := range muts {
+		e, err := newEntry(c, cfg, m)
+		if err != nil {
+			return nil, err
+		}
+		ents[i] = e
+		shards[e.Shard] = struct{}{}
+	}
+	if err := ds.Put(c, ents); err != nil {
+		return nil, errors.Annotate(err, "failed to journal mutations").Err()
+	}
+	return shards, nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+	tq "go.chromium.org/gae/service/taskqueue"
+
+	"go.chromium.org/luci/common/clock/testclock"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+type counter struct {
+	ID    string `gae:"$id"`
+	Value int
+}
+
+// increment adds By to the counter Name.
+type increment struct {
+	Name string
+	By   int
+}
+
+func (m *increment) Root(c context.Context) *ds.Key {
+	return ds.NewKey(c, "counter", m.Name, 0, nil)
+}
+
+func (m *increment) RollForward(c context.Context) ([]Mutation, error) {
+	if m.By < 0 {
+		return nil, errors.New("negative increment")
+	}
+	ctr := &counter{ID: m.Name}
+	if err := ds.Get(c, ctr); err != nil && err != ds.ErrNoSuchEntity {
+		return nil, err
+	}
+	ctr.Value += m.By
+	return nil, ds.Put(c, ctr)
+}
+
+// fanOut increments each of Names, via more mutations.
+type fanOut struct {
+	Names []string
+}
+
+func (m fanOut) Root(c context.Context) *ds.Key {
+	return ds.NewKey(c, "fanOut", "root", 0, nil)
+}
+
+func (m fanOut) RollForward(c context.Context) ([]Mutation, error) {
+	ret := make([]Mutation, len(m.Names))
+	for i, name := range m.Names {
+		ret[i] = &increment{Name: name, By: 1}
+	}
+	return ret, nil
+}
+
+type unregistered struct{ increment }
+
+func init() {
+	Register("journal_test.increment", &increment{})
+	Register("journal_test.fanOut", fanOut{})
+}
+
+func TestJournal(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test journal", t, func() {
+		c, _ := testclock.UseTime(context.Background(), testclock.TestRecentTimeUTC)
+		c = memory.Use(c)
+		tqt := tq.GetTestable(c)
+
+		value := func(name string) int {
+			ctr := &counter{ID: name}
+			if err := ds.Get(c, ctr); err != nil {
+				So(err, ShouldEqual, ds.ErrNoSuchEntity)
+			}
+			return ctr.Value
+		}
+		journalTasks := func() []*tq.Task {
+			return tqt.FindScheduledTasks(tq.TaskQuery{PathPrefix: ProcessPath})
+		}
+
+		Convey("Register rejects duplicates", func() {
+			So(func() { Register("journal_test.increment", fanOut{}) }, ShouldPanicLike, "already registered")
+			So(func() { Register("other", &increment{}) }, ShouldPanicLike, "already registered as")
+		})
+
+		Convey("AddToJournal rejects unregistered mutations", func() {
+			So(AddToJournal(c, &unregistered{}), ShouldErrLike, "is not registered")
+		})
+
+		Convey("mutations journaled in a transaction", func() {
+			err := ds.RunInTransaction(c, func(c context.Context) error {
+				return AddToJournal(c, &increment{Name: "a", By: 2}, &increment{Name: "a", By: 3})
+			}, nil)
+			So(err, ShouldBeNil)
+
+			Convey("are applied later", func() {
+				So(value("a"), ShouldEqual, 0)
+				So(journalTasks(), ShouldBeEmpty)
+
+				So(RunPending(c), ShouldBeNil)
+				So(value("a"), ShouldEqual, 5)
+
+				Convey("exactly once", func() {
+					So(RunPending(c), ShouldBeNil)
+					So(value("a"), ShouldEqual, 5)
+				})
+			})
+
+			Convey("unless the transaction fails", func() {
+				err := ds.RunInTransaction(c, func(c context.Context) error {
+					So(AddToJournal(c, &increment{Name: "a", By: 10}), ShouldBeNil)
+					return errors.New("nope")
+				}, nil)
+				So(err, ShouldErrLike, "nope")
+
+				So(RunPending(c), ShouldBeNil)
+				So(value("a"), ShouldEqual, 5)
+			})
+
+			Convey("are found by FireAllTasks", func() {
+				ds.GetTestable(c).CatchupIndexes()
+				So(FireAllTasks(c), ShouldBeNil)
+				So(len(journalTasks()), ShouldEqual, 1)
+
+				Convey("once per window", func() {
+					So(FireAllTasks(c), ShouldBeNil)
+					So(len(journalTasks()), ShouldEqual, 1)
+				})
+			})
+
+			Convey("are processed after NumShards is lowered", func() {
+				ds.GetTestable(c).CatchupIndexes()
+				var ents []*entry
+				So(ds.GetAll(c, ds.NewQuery(entryKind), &ents), ShouldBeNil)
+				So(ents[0].Shard, ShouldBeGreaterThan, 0)
+
+				c := WithConfig(c, Config{NumShards: 1})
+				So(FireAllTasks(c), ShouldBeNil)
+				tasks := journalTasks()
+				So(len(tasks), ShouldEqual, 1)
+				So(tasks[0].Path, ShouldContainSubstring, fmt.Sprintf("shard=%d", ents[0].Shard))
+
+				So(RunPending(c), ShouldBeNil)
+				So(value("a"), ShouldEqual, 5)
+			})
+		})
+
+		Convey("mutations journaled outside of a transaction add a task", func() {
+			So(AddToJournal(c, &increment{Name: "a", By: 1}), ShouldBeNil)
+			tasks := journalTasks()
+			So(len(tasks), ShouldEqual, 1)
+			So(tasks[0].ETA, ShouldResemble, testclock.TestRecentTimeUTC.Truncate(DefaultTemporalRounding).Add(DefaultTemporalRounding))
+
+			Convey("which is served by Handler", func() {
+				ds.GetTestable(c).CatchupIndexes()
+				h := Handler(func(*http.Request) context.Context { return c })
+
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest("POST", tasks[0].Path, strings.NewReader("")))
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				So(value("a"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("mutations can journal more mutations", func() {
+			So(AddToJournal(c, fanOut{Names: []string{"a", "b", "c"}}), ShouldBeNil)
+			So(RunPending(c), ShouldBeNil)
+			So(value("a"), ShouldEqual, 1)
+			So(value("b"), ShouldEqual, 1)
+			So(value("c"), ShouldEqual, 1)
+		})
+
+		Convey("a failed mutation is retried", func() {
+			So(AddToJournal(c, &increment{Name: "a", By: -1}), ShouldBeNil)
+			So(RunPending(c), ShouldErrLike, "negative increment")
+
+			ds.GetTestable(c).CatchupIndexes()
+			var ents []*entry
+			So(ds.GetAll(c, ds.NewQuery(entryKind), &ents), ShouldBeNil)
+			So(len(ents), ShouldEqual, 1)
+		})
+
+		Convey("batches are limited by BatchSize", func() {
+			c := WithConfig(c, Config{NumShards: 1, BatchSize: 2, TemporalRounding: time.Minute})
+			for _, name := range []string{"a", "b", "c"} {
+				So(AddToJournal(c, &increment{Name: name, By: 1}), ShouldBeNil)
+			}
+			tqt.ResetTasks()
+			ds.GetTestable(c).CatchupIndexes()
+
+			So(ProcessShard(c, 0), ShouldBeNil)
+			So(value("a")+value("b")+value("c"), ShouldEqual, 2)
+			tasks := journalTasks()
+			So(len(tasks), ShouldEqual, 1)
+			So(tasks[0].Name, ShouldNotStartWith, "gae-journal-")
+
+			ds.GetTestable(c).CatchupIndexes()
+			So(ProcessShard(c, 0), ShouldBeNil)
+			So(value("a")+value("b")+value("c"), ShouldEqual, 3)
+		})
+	})
+}
@@ -0,0 +1,313 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/info"
+	tq "go.chromium.org/gae/service/taskqueue"
+
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/errors"
+	"go.chromium.org/luci/common/logging"
+
+	"golang.org/x/net/context"
+)
+
+// URL paths served by Handler.
+const (
+	// ProcessPath is the URL path of shard tasks.
+	ProcessPath = "/internal/gae/journal/process"
+	// CronPath is the URL path which calls FireAllTasks, for a cron job.
+	CronPath = "/internal/gae/journal/fire_all_tasks"
+)
+
+// shardTask returns a task which processes shard in the namespace of c.
+func shardTask(c context.Context, shard int64) *tq.Task {
+	v := url.Values{}
+	v.Set("shard", strconv.FormatInt(shard, 10))
+	if ns := info.GetNamespace(c); ns != "" {
+		v.Set("ns", ns)
+	}
+	return &tq.Task{Path: ProcessPath + "?" + v.Encode(), Method: "POST"}
+}
+
+// fireTasks adds a shard task for each of shards, unless one has already been
+// added for it in the current temporal window. The tasks run at the end of the
+// window.
+func fireTasks(c context.Context, cfg *Config, shards map[int64]struct{}) error {
+	if len(shards) == 0 {
+		return nil
+	}
+
+	now := clock.Now(c).UTC()
+	window := now.Truncate(cfg.TemporalRounding)
+	h := fnv.New32a()
+	h.Write([]byte(info.GetNamespace(c)))
+	nsHash := h.Sum32()
+
+	lme := errors.NewLazyMultiError(len(shards))
+	i := 0
+	for shard := range shards {
+		t := shardTask(c, shard)
+		t.Name = fmt.Sprintf("gae-journal-%08x-%d-%d", nsHash, shard, window.Unix())
+		t.ETA = window.Add(cfg.TemporalRounding)
+		switch err := tq.Add(c, cfg.Queue, t); err {
+		case nil, tq.ErrTaskAlreadyAdded:
+		default:
+			lme.Assign(i, errors.Annotate(err, "failed to add task for shard %d", shard).Err())
+		}
+		i++
+	}
+	return lme.Get()
+}
+
+// FireAllTasks adds a shard task for each shard of the journal in the current
+// namespace which has mutations. It should be called periodically, e.g. by
+// a cron job requesting CronPath.
+func FireAllTasks(c context.Context) error {
+	cfg := getConfig(c)
+	shards, err := shardsWithEntries(c)
+	if err != nil {
+		return err
+	}
+	return fireTasks(c, &cfg, shards)
+}
+
+// shardsWithEntries returns the shards of the journal in the current namespace
+// which have entries. It skips from one shard to the next rather than looking
+// at each shard below Config.NumShards, so that the shards left over from
+// a higher NumShards are still processed.
+func shardsWithEntries(c context.Context) (map[int64]struct{}, error) {
+	shards := map[int64]struct{}{}
+	for next := int64(0); ; {
+		q := ds.NewQuery(entryKind).Gte("Shard", next).Order("Shard").Project("Shard").Limit(1)
+		var ents []*entry
+		if err := ds.GetAll(c, q, &ents); err != nil {
+			return nil, errors.Annotate(err, "failed to query shards from %d", next).Err()
+		}
+		if len(ents) == 0 {
+			return shards, nil
+		}
+		shards[ents[0].Shard] = struct{}{}
+		next = ents[0].Shard + 1
+	}
+}
+
+// ProcessShard rolls forward the mutations journaled in shard in the current
+// namespace. If there are more than fit in a batch, it adds another task for
+// the shard.
+func ProcessShard(c context.Context, shard int64) error {
+	cfg := getConfig(c)
+	n, newShards, err := processBatch(c, &cfg, shard)
+	if ferr := fireTasks(c, &cfg, newShards); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return err
+	}
+	if n == cfg.BatchSize {
+		return tq.Add(c, cfg.Queue, shardTask(c, shard))
+	}
+	return nil
+}
+
+// processBatch rolls forward the mutations of a batch of the entries in
+// shard. It returns the number of entries in the batch (if it's
+// cfg.BatchSize, the shard may have more), and the shards of the mutations
+// that the rolled forward mutations journaled.
+func processBatch(c context.Context, cfg *Config, shard int64) (n int, newShards map[int64]struct{}, err error) {
+	q := ds.NewQuery(entryKind).Eq("Shard", shard).KeysOnly(true).Limit(int32(cfg.BatchSize))
+	var keys []*ds.Key
+	if err := ds.GetAll(c, q, &keys); err != nil {
+		return 0, nil, errors.Annotate(err, "failed to query shard %d", shard).Err()
+	}
+
+	// Roll forward each entity group once, in the order they were found.
+	var roots []*ds.Key
+	seen := map[string]struct{}{}
+	for _, k := range keys {
+		root := k.Root()
+		if _, ok := seen[root.Encode()]; !ok {
+			seen[root.Encode()] = struct{}{}
+			roots = append(roots, root)
+		}
+	}
+
+	newShards = map[int64]struct{}{}
+	var merr errors.MultiError
+	for _, root := range roots {
+		shards, err := processRoot(c, cfg, root)
+		if err != nil {
+			merr = append(merr, errors.Annotate(err, "failed to roll forward %s", root).Err())
+			continue
+		}
+		for s := range shards {
+			newShards[s] = struct{}{}
+		}
+	}
+	if len(merr) > 0 {
+		return len(keys), newShards, merr
+	}
+	return len(keys), newShards, nil
+}
+
+// processRoot rolls forward all of the mutations journaled in the entity group
+// of root, in the order they were journaled. It returns the shards of the
+// mutations that they journaled.
+func processRoot(c context.Context, cfg *Config, root *ds.Key) (map[int64]struct{}, error) {
+	var ents []*entry
+	if err := ds.GetAll(c, ds.NewQuery(entryKind).Ancestor(root), &ents); err != nil {
+		return nil, err
+	}
+	sort.Slice(ents, func(i, j int) bool {
+		if !ents[i].Created.Equal(ents[j].Created) {
+			return ents[i].Created.Before(ents[j].Created)
+		}
+		return ents[i].ID < ents[j].ID
+	})
+
+	newShards := map[int64]struct{}{}
+	for _, e := range ents {
+		shards, err := rollForward(c, cfg, e)
+		if err != nil {
+			return newShards, err
+		}
+		for s := range shards {
+			newShards[s] = struct{}{}
+		}
+	}
+	return newShards, nil
+}
+
+// rollForward rolls forward the mutation of e and deletes e, in a transaction.
+// Each mutation gets its own transaction, so that it sees the changes of the
+// ones before it. It returns the shards of the mutations that it journaled.
+func rollForward(c context.Context, cfg *Config, e *entry) (newShards map[int64]struct{}, err error) {
+	err = ds.RunInTransaction(c, func(c context.Context) error {
+		newShards = nil
+
+		// The entry may have been rolled forward since it was queried.
+		cur := &entry{ID: e.ID, Parent: e.Parent}
+		switch err := ds.Get(c, cur); err {
+		case nil:
+		case ds.ErrNoSuchEntity:
+			return nil
+		default:
+			return err
+		}
+
+		m, err := cur.mutation()
+		if err != nil {
+			// Retrying won't fix this, so drop the entry rather than block the
+			// entity group forever.
+			logging.WithError(err).Errorf(c, "journal: dropping entry %d of %s", cur.ID, cur.Parent)
+			return ds.Delete(c, cur)
+		}
+		next, err := m.RollForward(c)
+		if err != nil {
+			return errors.Annotate(err, "%q failed", cur.Type).Err()
+		}
+
+		if err := ds.Delete(c, cur); err != nil {
+			return err
+		}
+		if len(next) > 0 {
+			newShards, err = addToJournal(c, cfg, next)
+		}
+		return err
+	}, &ds.TransactionOptions{XG: true})
+	return
+}
+
+// Handler returns an http.Handler which serves ProcessPath and CronPath.
+//
+// getContext returns the context to process the request with, e.g. by calling
+// prod.Use. Shard tasks switch to the namespace that they were added in, and
+// the cron job processes the namespace of the returned context.
+func Handler(getContext func(r *http.Request) context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ProcessPath, func(w http.ResponseWriter, r *http.Request) {
+		c := getContext(r)
+		shard, err := strconv.ParseInt(r.FormValue("shard"), 10, 64)
+		if err != nil {
+			// Retrying won't fix this.
+			logging.WithError(err).Errorf(c, "journal: bad shard %q", r.FormValue("shard"))
+			return
+		}
+		if c, err = info.Namespace(c, r.FormValue("ns")); err != nil {
+			logging.WithError(err).Errorf(c, "journal: bad namespace %q", r.FormValue("ns"))
+			return
+		}
+		if err := ProcessShard(c, shard); err != nil {
+			logging.WithError(err).Errorf(c, "journal: failed to process shard %d", shard)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc(CronPath, func(w http.ResponseWriter, r *http.Request) {
+		c := getContext(r)
+		if err := FireAllTasks(c); err != nil {
+			logging.WithError(err).Errorf(c, "journal: failed to fire shard tasks")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	return mux
+}
+
+// RunPending rolls forward all of the mutations journaled in the current
+// namespace, including the ones that they journal, without going through the
+// task queue. It's intended for tests, e.g. with impl/memory, whose indexes it
+// catches up before each query.
+//
+// It stops at the first error.
+func RunPending(c context.Context) error {
+	cfg := getConfig(c)
+	dst := ds.GetTestable(c)
+	for {
+		if dst != nil {
+			dst.CatchupIndexes()
+		}
+		shards, err := shardsWithEntries(c)
+		if err != nil {
+			return err
+		}
+		processed := false
+		for shard := range shards {
+			for {
+				if dst != nil {
+					dst.CatchupIndexes()
+				}
+				n, _, err := processBatch(c, &cfg, shard)
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					break
+				}
+				processed = true
+			}
+		}
+		if !processed {
+			return nil
+		}
+	}
+}
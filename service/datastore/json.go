@@ -0,0 +1,304 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.chromium.org/gae/service/blobstore"
+)
+
+// JSONOptions controls the JSON encoding of Keys and PropertyMaps.
+//
+// The MarshalJSON methods of Key and PropertyMap use the default options. The
+// UnmarshalJSON methods accept the output of every option.
+type JSONOptions struct {
+	// StructuredKeys encodes Keys as objects with their full path, e.g.:
+	//
+	//	{"app": "a", "namespace": "ns", "path": [{"kind": "Foo", "id": 1}]}
+	//
+	// instead of as the websafe string returned by Key.Encode.
+	StructuredKeys bool
+}
+
+// jsonKey is the structured JSON form of a Key.
+type jsonKey struct {
+	AppID     string           `json:"app"`
+	Namespace string           `json:"namespace,omitempty"`
+	Database  string           `json:"database,omitempty"`
+	Path      []jsonKeyElement `json:"path"`
+}
+
+type jsonKeyElement struct {
+	Kind string `json:"kind"`
+	ID   int64  `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// jsonProperty is the JSON form of a Property. Value is encoded depending on
+// Type:
+//
+//	PTNull:     null
+//	PTInt:      a number
+//	PTTime:     an RFC 3339 string, in UTC
+//	PTBool:     a boolean
+//	PTBytes:    a standard base64 string
+//	PTString:   a string
+//	PTFloat:    a number
+//	PTGeoPoint: {"lat": number, "lng": number}
+//	PTKey:      a Key (see JSONOptions)
+//	PTBlobKey:  a string
+type jsonProperty struct {
+	Type    string          `json:"type"`
+	Value   json.RawMessage `json:"value"`
+	NoIndex bool            `json:"noindex,omitempty"`
+}
+
+type jsonGeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// MarshalKey returns the JSON encoding of k.
+func (o *JSONOptions) MarshalKey(k *Key) ([]byte, error) {
+	if !o.StructuredKeys {
+		return json.Marshal(k.Encode())
+	}
+	jk := jsonKey{
+		AppID:     k.AppID(),
+		Namespace: k.Namespace(),
+		Database:  k.Database(),
+		Path:      make([]jsonKeyElement, len(k.toks)),
+	}
+	for i, t := range k.toks {
+		jk.Path[i] = jsonKeyElement{Kind: t.Kind, ID: t.IntID, Name: t.StringID}
+	}
+	return json.Marshal(&jk)
+}
+
+// MarshalProperty returns the JSON encoding of p, an object with its type, its
+// value and whether it's unindexed, e.g.:
+//
+//	{"type": "PTString", "value": "hello", "noindex": true}
+func (o *JSONOptions) MarshalProperty(p Property) ([]byte, error) {
+	var v interface{}
+	switch t := p.Value().(type) {
+	case time.Time:
+		v = t.UTC().Format(time.RFC3339Nano)
+	case GeoPoint:
+		v = jsonGeoPoint{t.Lat, t.Lng}
+	case *Key:
+		data, err := o.MarshalKey(t)
+		if err != nil {
+			return nil, err
+		}
+		v = json.RawMessage(data)
+	default:
+		v = t
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&jsonProperty{
+		Type:    p.Type().String(),
+		Value:   data,
+		NoIndex: p.IndexSetting() == NoIndex,
+	})
+}
+
+// MarshalPropertyMap returns the JSON encoding of pm, an object which maps
+// each property name to its MarshalProperty encoding, or to an array of them
+// for a PropertySlice.
+func (o *JSONOptions) MarshalPropertyMap(pm PropertyMap) ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, name := range pm.Names() {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		data, _ := json.Marshal(name)
+		buf.Write(data)
+		buf.WriteByte(':')
+
+		switch t := pm[name].(type) {
+		case Property:
+			data, err := o.MarshalProperty(t)
+			if err != nil {
+				return nil, fmt.Errorf("datastore: property %q: %s", name, err)
+			}
+			buf.Write(data)
+
+		case PropertySlice:
+			buf.WriteByte('[')
+			for j, p := range t {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+				data, err := o.MarshalProperty(p)
+				if err != nil {
+					return nil, fmt.Errorf("datastore: property %q: %s", name, err)
+				}
+				buf.Write(data)
+			}
+			buf.WriteByte(']')
+
+		default:
+			return nil, fmt.Errorf("datastore: property %q has unknown type %T", name, t)
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON implements json.Marshaler, using the default JSONOptions.
+func (pm PropertyMap) MarshalJSON() ([]byte, error) {
+	return (&JSONOptions{}).MarshalPropertyMap(pm)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Any existing properties of pm
+// are kept, unless they're overwritten.
+func (pm *PropertyMap) UnmarshalJSON(buf []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return err
+	}
+	if *pm == nil {
+		*pm = make(PropertyMap, len(raw))
+	}
+	for name, data := range raw {
+		data = bytes.TrimSpace(data)
+		if len(data) > 0 && data[0] == '[' {
+			var raws []json.RawMessage
+			if err := json.Unmarshal(data, &raws); err != nil {
+				return err
+			}
+			ps := make(PropertySlice, len(raws))
+			for i, data := range raws {
+				if err := ps[i].UnmarshalJSON(data); err != nil {
+					return fmt.Errorf("datastore: property %q: %s", name, err)
+				}
+			}
+			(*pm)[name] = ps
+			continue
+		}
+
+		var p Property
+		if err := p.UnmarshalJSON(data); err != nil {
+			return fmt.Errorf("datastore: property %q: %s", name, err)
+		}
+		(*pm)[name] = p
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, using the default JSONOptions.
+func (p Property) MarshalJSON() ([]byte, error) {
+	return (&JSONOptions{}).MarshalProperty(p)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *Property) UnmarshalJSON(buf []byte) error {
+	var jp jsonProperty
+	if err := json.Unmarshal(buf, &jp); err != nil {
+		return err
+	}
+
+	pt, ok := parsePropertyType(jp.Type)
+	if !ok {
+		return fmt.Errorf("datastore: bad property type %q", jp.Type)
+	}
+
+	v, err := unmarshalPropertyValue(pt, jp.Value)
+	if err != nil {
+		return fmt.Errorf("datastore: bad %s value: %s", pt, err)
+	}
+
+	is := ShouldIndex
+	if jp.NoIndex {
+		is = NoIndex
+	}
+	return p.SetValue(v, is)
+}
+
+func unmarshalPropertyValue(pt PropertyType, data json.RawMessage) (interface{}, error) {
+	switch pt {
+	case PTInt:
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return nil, err
+		}
+		return strconv.ParseInt(string(n), 10, 64)
+
+	case PTTime:
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, err
+		}
+		return time.Parse(time.RFC3339Nano, s)
+
+	case PTBool:
+		var b bool
+		err := json.Unmarshal(data, &b)
+		return b, err
+
+	case PTBytes:
+		b := []byte{}
+		err := json.Unmarshal(data, &b)
+		return b, err
+
+	case PTString:
+		var s string
+		err := json.Unmarshal(data, &s)
+		return s, err
+
+	case PTFloat:
+		var f float64
+		err := json.Unmarshal(data, &f)
+		return f, err
+
+	case PTGeoPoint:
+		var gp jsonGeoPoint
+		err := json.Unmarshal(data, &gp)
+		return GeoPoint{gp.Lat, gp.Lng}, err
+
+	case PTKey:
+		k := &Key{}
+		err := k.UnmarshalJSON(data)
+		return k, err
+
+	case PTBlobKey:
+		var s string
+		err := json.Unmarshal(data, &s)
+		return blobstore.Key(s), err
+
+	default:
+		return nil, nil
+	}
+}
+
+func parsePropertyType(s string) (PropertyType, bool) {
+	for pt := PTNull; pt < PTUnknown; pt++ {
+		if pt.String() == s {
+			return pt, true
+		}
+	}
+	return PTUnknown, false
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/service/blobstore"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestJSON(t *testing.T) {
+	t.Parallel()
+
+	Convey("JSON", t, func() {
+		kc := MkKeyContext("app", "ns")
+		k := kc.MakeKey("Parent", "p", "Foo", 1)
+
+		Convey("keys", func() {
+			Convey("websafe", func() {
+				data, err := json.Marshal(k)
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, `"`+k.Encode()+`"`)
+			})
+
+			Convey("structured", func() {
+				data, err := (&JSONOptions{StructuredKeys: true}).MarshalKey(k)
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual,
+					`{"app":"app","namespace":"ns","path":[{"kind":"Parent","name":"p"},{"kind":"Foo","id":1}]}`)
+
+				dec := &Key{}
+				So(json.Unmarshal(data, dec), ShouldBeNil)
+				So(dec, ShouldResemble, k)
+			})
+
+			Convey("bad structured", func() {
+				So(json.Unmarshal([]byte(`{"app":"app"}`), &Key{}), ShouldErrLike, "empty path")
+			})
+		})
+
+		Convey("property maps", func() {
+			pm := PropertyMap{
+				"$key":  MkPropertyNI(k),
+				"Null":  MkProperty(nil),
+				"Int":   MkProperty(1 << 60),
+				"Time":  MkProperty(time.Date(2017, 1, 2, 3, 4, 5, 6000, time.UTC)),
+				"Bool":  MkProperty(true),
+				"Bytes": MkPropertyNI([]byte("hi")),
+				"Str":   PropertySlice{MkProperty("a"), MkProperty("b")},
+				"Float": MkProperty(1.5),
+				"Geo":   MkProperty(GeoPoint{Lat: 1, Lng: 2}),
+				"Key":   MkProperty(k),
+				"Blob":  MkProperty(blobstore.Key("blob")),
+			}
+
+			for _, opts := range []*JSONOptions{{}, {StructuredKeys: true}} {
+				data, err := opts.MarshalPropertyMap(pm)
+				So(err, ShouldBeNil)
+
+				var dec PropertyMap
+				So(json.Unmarshal(data, &dec), ShouldBeNil)
+				So(dec, ShouldResemble, pm)
+			}
+
+			Convey("format", func() {
+				data, err := json.Marshal(PropertyMap{
+					"Str":  PropertySlice{MkProperty("a")},
+					"Int":  MkPropertyNI(1),
+					"Geo":  MkProperty(GeoPoint{Lat: 1, Lng: 2}),
+					"Time": MkProperty(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)),
+				})
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, `{`+
+					`"Geo":{"type":"PTGeoPoint","value":{"lat":1,"lng":2}},`+
+					`"Int":{"type":"PTInt","value":1,"noindex":true},`+
+					`"Str":[{"type":"PTString","value":"a"}],`+
+					`"Time":{"type":"PTTime","value":"2017-01-02T03:04:05Z"}}`)
+			})
+
+			Convey("bad type", func() {
+				var dec PropertyMap
+				err := json.Unmarshal([]byte(`{"A":{"type":"PTNope","value":1}}`), &dec)
+				So(err, ShouldErrLike, `property "A": datastore: bad property type "PTNope"`)
+			})
+
+			Convey("bad value", func() {
+				var dec PropertyMap
+				err := json.Unmarshal([]byte(`{"A":{"type":"PTInt","value":"x"}}`), &dec)
+				So(err, ShouldErrLike, "bad PTInt value")
+			})
+		})
+	})
+}
@@ -63,10 +63,18 @@ func (k KeyTok) Less(other KeyTok) bool {
 	return a.Less(&b)
 }
 
+// DefaultDatabase is the ID of a project's default Firestore in Datastore mode
+// database. KeyContext represents it with the empty string.
+const DefaultDatabase = "(default)"
+
 // KeyContext is the context in which a key is generated.
 type KeyContext struct {
 	AppID     string
 	Namespace string
+
+	// Database is the ID of the Firestore in Datastore mode database that keys
+	// belong to. The empty string is the "(default)" database.
+	Database string
 }
 
 // MkKeyContext is a helper function to create a new KeyContext.
@@ -77,10 +85,10 @@ func MkKeyContext(appID, namespace string) KeyContext {
 	return KeyContext{AppID: appID, Namespace: namespace}
 }
 
-// Matches returns true iff the AppID and Namespace parameters are the same for
-// the two KeyContext instances.
+// Matches returns true iff the AppID, Namespace and Database parameters are the
+// same for the two KeyContext instances.
 func (kc KeyContext) Matches(o KeyContext) bool {
-	return (kc.AppID == o.AppID && kc.Namespace == o.Namespace)
+	return (kc.AppID == o.AppID && kc.Namespace == o.Namespace && kc.Database == o.Database)
 }
 
 // NewKeyToks creates a new Key. It is the Key implementation returned from
@@ -197,6 +205,7 @@ func NewKeyEncoded(encoded string) (ret *Key, err error) {
 	}
 
 	ret.kc = MkKeyContext(r.GetApp(), r.GetNameSpace())
+	ret.kc.Database = r.GetDatabaseId()
 	ret.toks = make([]KeyTok, len(r.Path.Element))
 	for i, e := range r.Path.Element {
 		ret.toks[i] = KeyTok{
@@ -220,6 +229,10 @@ func (k *Key) AppID() string { return k.kc.AppID }
 // Namespace returns the namespace that this Key is for.
 func (k *Key) Namespace() string { return k.kc.Namespace }
 
+// Database returns the ID of the database that this Key is for, or the empty
+// string for the "(default)" database.
+func (k *Key) Database() string { return k.kc.Database }
+
 // KeyContext returns the KeyContext that this Key is using.
 func (k *Key) KeyContext() *KeyContext {
 	kc := k.kc
@@ -237,9 +250,16 @@ func (k *Key) IntID() int64 { return k.toks[len(k.toks)-1].IntID }
 
 // String returns a human-readable representation of the key in the form of
 //   AID:NS:/Kind,id/Kind,id/...
+//
+// Keys in a non-default database are in the form of
+//   AID[DB]:NS:/Kind,id/Kind,id/...
 func (k *Key) String() string {
 	b := bytes.NewBuffer(make([]byte, 0, 512))
-	fmt.Fprintf(b, "%s:%s:", k.kc.AppID, k.kc.Namespace)
+	if db := k.kc.Database; db != "" {
+		fmt.Fprintf(b, "%s[%s]:%s:", k.kc.AppID, db, k.kc.Namespace)
+	} else {
+		fmt.Fprintf(b, "%s:%s:", k.kc.AppID, k.kc.Namespace)
+	}
 	for _, t := range k.toks {
 		if t.StringID != "" {
 			fmt.Fprintf(b, "/%s,%q", t.Kind, t.StringID)
@@ -306,7 +326,8 @@ func (k *Key) Parent() *Key {
 	return k.kc.NewKeyToks(k.toks[:len(k.toks)-1])
 }
 
-// MarshalJSON allows this key to be automatically marshaled by encoding/json.
+// MarshalJSON allows this key to be automatically marshaled by encoding/json,
+// as a websafe string. Use JSONOptions for the structured form.
 func (k *Key) MarshalJSON() ([]byte, error) {
 	return []byte(`"` + k.Encode() + `"`), nil
 }
@@ -334,9 +355,14 @@ func (k *Key) Encode() string {
 	if ns := k.kc.Namespace; ns != "" {
 		namespace = &ns
 	}
+	var database *string
+	if db := k.kc.Database; db != "" {
+		database = &db
+	}
 	r, err := proto.Marshal(&pb.Reference{
-		App:       &k.kc.AppID,
-		NameSpace: namespace,
+		App:        &k.kc.AppID,
+		NameSpace:  namespace,
+		DatabaseId: database,
 		Path: &pb.Path{
 			Element: e,
 		},
@@ -350,7 +376,27 @@ func (k *Key) Encode() string {
 }
 
 // UnmarshalJSON allows this key to be automatically unmarshaled by encoding/json.
+//
+// It accepts both the websafe string form and the structured form of keys
+// (see JSONOptions).
 func (k *Key) UnmarshalJSON(buf []byte) error {
+	if len(buf) > 0 && buf[0] == '{' {
+		var jk jsonKey
+		if err := json.Unmarshal(buf, &jk); err != nil {
+			return err
+		}
+		if len(jk.Path) == 0 {
+			return errors.New("datastore: bad JSON key: empty path")
+		}
+		toks := make([]KeyTok, len(jk.Path))
+		for i, e := range jk.Path {
+			toks[i] = KeyTok{Kind: e.Kind, IntID: e.ID, StringID: e.Name}
+		}
+		kc := KeyContext{AppID: jk.AppID, Namespace: jk.Namespace, Database: jk.Database}
+		*k = *kc.NewKeyToks(toks)
+		return nil
+	}
+
 	if len(buf) < 2 || buf[0] != '"' || buf[len(buf)-1] != '"' {
 		return errors.New("datastore: bad JSON key")
 	}
@@ -363,6 +409,9 @@ func (k *Key) UnmarshalJSON(buf []byte) error {
 }
 
 // GobEncode allows the Key to be encoded in a Gob struct.
+//
+// The encoding is the same as Encode's, so it's stable across versions of
+// this package and safe to store.
 func (k *Key) GobEncode() ([]byte, error) {
 	return []byte(k.Encode()), nil
 }
@@ -395,6 +444,12 @@ func (k *Key) Less(other *Key) bool {
 		return false
 	}
 
+	if k.kc.Database < other.kc.Database {
+		return true
+	} else if k.kc.Database > other.kc.Database {
+		return false
+	}
+
 	if k.kc.Namespace < other.kc.Namespace {
 		return true
 	} else if k.kc.Namespace > other.kc.Namespace {
@@ -507,6 +562,17 @@ func (k *Key) WithID(stringID string, intID int64) *Key {
 	return k.kc.NewKey(k.Kind(), stringID, intID, k.Parent())
 }
 
+// WithAppID returns a copy of this key in the app appID, keeping its
+// namespace, database and path.
+func (k *Key) WithAppID(appID string) *Key {
+	if k.kc.AppID == appID {
+		return k
+	}
+	kc := k.kc
+	kc.AppID = appID
+	return kc.NewKeyToks(k.toks)
+}
+
 // Split componentizes the key into pieces (AppID, Namespace and tokens)
 //
 // Each token represents one piece of they key's 'path'.
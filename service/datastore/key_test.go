@@ -165,6 +165,12 @@ func TestMiscKey(t *testing.T) {
 		So(k1.Equal(k2), ShouldBeTrue)
 		k3 := kc.MakeKey("knd", 2)
 		So(k1.Equal(k3), ShouldBeFalse)
+
+		dbkc := kc
+		dbkc.Database = "db"
+		k4 := dbkc.MakeKey("knd", 1)
+		So(k1.Equal(k4), ShouldBeFalse)
+		So(k4.Database(), ShouldEqual, "db")
 	})
 
 	Convey("KeyString", t, func() {
@@ -172,6 +178,35 @@ func TestMiscKey(t *testing.T) {
 
 		k1 := kc.MakeKey("knd", 1, "other", "wat")
 		So(k1.String(), ShouldEqual, "a:n:/knd,1/other,\"wat\"")
+
+		kc.Database = "db"
+		k2 := kc.MakeKey("knd", 1)
+		So(k2.String(), ShouldEqual, "a[db]:n:/knd,1")
+	})
+
+	Convey("Key encoding preserves the database", t, func() {
+		kc := KeyContext{AppID: "a", Namespace: "n", Database: "db"}
+		k := kc.MakeKey("knd", 1, "other", "wat")
+
+		dec, err := NewKeyEncoded(k.Encode())
+		So(err, ShouldBeNil)
+		So(dec, ShouldEqualKey, k)
+		So(dec.Database(), ShouldEqual, "db")
+
+		dec, err = NewKeyEncoded(MkKeyContext("a", "n").MakeKey("knd", 1).Encode())
+		So(err, ShouldBeNil)
+		So(dec.Database(), ShouldEqual, "")
+	})
+
+	Convey("WithAppID", t, func() {
+		kc := KeyContext{AppID: "a", Namespace: "n", Database: "db"}
+		k := kc.MakeKey("knd", 1, "other", "wat")
+		So(k.WithAppID("a"), ShouldEqual, k)
+
+		other := k.WithAppID("b")
+		So(other, ShouldEqualKey, KeyContext{AppID: "b", Namespace: "n", Database: "db"}.MakeKey("knd", 1, "other", "wat"))
+		So(other.Parent().AppID(), ShouldEqual, "b")
+		So(k.AppID(), ShouldEqual, "a")
 	})
 
 	Convey("HasAncestor", t, func() {
@@ -253,6 +288,7 @@ func TestKeySort(t *testing.T) {
 			MkKeyContext("A", "n").MakeKey("kind", "1"),
 			MkKeyContext("A", "n").MakeKey("kind", "1", "something", "else"),
 			MkKeyContext("A", "n").MakeKey("other", 1, "something", "else"),
+			KeyContext{AppID: "A", Database: "db"}.MakeKey("kind", 1),
 			MkKeyContext("a", "").MakeKey("kind", 1),
 			MkKeyContext("a", "n").MakeKey("kind", 1),
 			MkKeyContext("a", "n").MakeKey("kind", 2),
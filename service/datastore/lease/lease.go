@@ -0,0 +1,208 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lease implements named leases on top of the datastore: locks which
+// expire if their holder doesn't renew them, e.g. because it crashed.
+//
+// Leases are useful for making sure that only one instance of a cron job or of
+// a singleton worker runs at a time:
+//
+//	l, err := lease.Acquire(c, "nightly-report", "worker-1", time.Minute)
+//	switch err.(type) {
+//	case nil:
+//	  defer l.Release(c)
+//	case *lease.HeldError:
+//	  return nil // another instance is already running
+//	default:
+//	  return err
+//	}
+//
+// A holder which runs for longer than the TTL of its lease must Renew it in
+// time. Renew fails with ErrLost if the lease expired and was acquired by
+// someone else in the meantime, in which case the holder should stop.
+//
+// Expiration is based on the clock of the context, so the clocks of the
+// instances sharing a lease should be reasonably synchronized, and TTLs
+// should be much longer than their skew.
+package lease
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// ErrLost is returned when renewing or releasing a lease which has expired and
+// was acquired by someone else.
+var ErrLost = errors.New("lease: the lease was lost")
+
+// HeldError is returned by Acquire when the lease is held by someone else.
+type HeldError struct {
+	// Name is the name of the lease.
+	Name string
+	// Holder is the holder of the lease.
+	Holder string
+	// Expires is when the lease expires, unless it's renewed.
+	Expires time.Time
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("lease: %q is held by %q until %s", e.Name, e.Holder, e.Expires)
+}
+
+// entity is the datastore entity of a lease.
+type entity struct {
+	_kind string `gae:"$kind,gae.lease.Lease"`
+
+	Name    string    `gae:"$id"`
+	Holder  string    `gae:",noindex"`
+	Token   string    `gae:",noindex"`
+	Expires time.Time `gae:",noindex"`
+}
+
+// Lease is an acquired lease.
+type Lease struct {
+	// Name is the name of the lease.
+	Name string
+	// Holder is the holder passed to Acquire.
+	Holder string
+	// Expires is when the lease expires, unless it's renewed.
+	Expires time.Time
+
+	// token identifies this acquisition of the lease, so that several
+	// instances using the same holder can't steal each other's leases.
+	token string
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Acquire acquires the lease name for holder, which describes the caller (it's
+// reported in HeldError), for ttl.
+//
+// Returns a *HeldError if the lease is held by someone else, including another
+// instance with the same holder.
+func Acquire(c context.Context, name, holder string, ttl time.Duration) (*Lease, error) {
+	if name == "" {
+		return nil, errors.New("lease: empty name")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("lease: bad TTL %s", ttl)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to generate a token").Err()
+	}
+
+	var l *Lease
+	err = ds.RunInTransaction(c, func(c context.Context) error {
+		l = nil
+		now := clock.Now(c).UTC()
+		e := &entity{Name: name}
+		switch err := ds.Get(c, e); {
+		case err == ds.ErrNoSuchEntity:
+		case err != nil:
+			return err
+		case e.Expires.After(now):
+			return &HeldError{Name: name, Holder: e.Holder, Expires: e.Expires}
+		}
+
+		e.Holder = holder
+		e.Token = token
+		e.Expires = ds.RoundTime(now.Add(ttl))
+		if err := ds.Put(c, e); err != nil {
+			return err
+		}
+		l = &Lease{Name: name, Holder: holder, Expires: e.Expires, token: e.Token}
+		return nil
+	}, nil)
+	if err != nil {
+		if _, ok := err.(*HeldError); ok {
+			return nil, err
+		}
+		return nil, errors.Annotate(err, "failed to acquire %q", name).Err()
+	}
+	return l, nil
+}
+
+// update transactionally applies cb to the entity of l, if l still holds it.
+func (l *Lease) update(c context.Context, cb func(c context.Context, e *entity) error) error {
+	return ds.RunInTransaction(c, func(c context.Context) error {
+		e := &entity{Name: l.Name}
+		switch err := ds.Get(c, e); {
+		case err == ds.ErrNoSuchEntity:
+			return ErrLost
+		case err != nil:
+			return err
+		case e.Token != l.token:
+			return ErrLost
+		}
+		return cb(c, e)
+	}, nil)
+}
+
+// Renew extends the lease to ttl from now.
+//
+// A lease which has expired can still be renewed, unless someone else has
+// acquired it since, in which case ErrLost is returned.
+func (l *Lease) Renew(c context.Context, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("lease: bad TTL %s", ttl)
+	}
+	var expires time.Time
+	err := l.update(c, func(c context.Context, e *entity) error {
+		expires = ds.RoundTime(clock.Now(c).UTC().Add(ttl))
+		e.Expires = expires
+		return ds.Put(c, e)
+	})
+	switch err {
+	case nil:
+		l.Expires = expires
+		return nil
+	case ErrLost:
+		return err
+	default:
+		return errors.Annotate(err, "failed to renew %q", l.Name).Err()
+	}
+}
+
+// Release releases the lease, so that it can be acquired again right away.
+//
+// Returns ErrLost if the lease has been acquired by someone else since it
+// expired.
+func (l *Lease) Release(c context.Context) error {
+	err := l.update(c, func(c context.Context, e *entity) error {
+		return ds.Delete(c, e)
+	})
+	switch err {
+	case nil, ErrLost:
+		return err
+	default:
+		return errors.Annotate(err, "failed to release %q", l.Name).Err()
+	}
+}
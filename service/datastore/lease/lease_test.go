@@ -0,0 +1,106 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lease
+
+import (
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+
+	"go.chromium.org/luci/common/clock/testclock"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestLease(t *testing.T) {
+	t.Parallel()
+
+	Convey("Lease", t, func() {
+		c, tc := testclock.UseTime(context.Background(), testclock.TestTimeUTC)
+		c = memory.Use(c)
+
+		l, err := Acquire(c, "job", "worker-1", time.Minute)
+		So(err, ShouldBeNil)
+		So(l.Holder, ShouldEqual, "worker-1")
+		So(l.Expires, ShouldResemble, ds.RoundTime(testclock.TestTimeUTC.Add(time.Minute)))
+
+		Convey("can't be acquired while held", func() {
+			_, err := Acquire(c, "job", "worker-2", time.Minute)
+			So(err, ShouldResemble, &HeldError{
+				Name:    "job",
+				Holder:  "worker-1",
+				Expires: ds.RoundTime(testclock.TestTimeUTC.Add(time.Minute)),
+			})
+			So(err, ShouldErrLike, `"job" is held by "worker-1"`)
+
+			// Not even by the same holder.
+			_, err = Acquire(c, "job", "worker-1", time.Minute)
+			So(err, ShouldHaveSameTypeAs, &HeldError{})
+
+			// Other leases are independent.
+			_, err = Acquire(c, "other", "worker-2", time.Minute)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("can be renewed", func() {
+			tc.Add(50 * time.Second)
+			So(l.Renew(c, time.Minute), ShouldBeNil)
+			So(l.Expires, ShouldResemble, ds.RoundTime(testclock.TestTimeUTC.Add(110*time.Second)))
+
+			tc.Add(30 * time.Second)
+			_, err := Acquire(c, "job", "worker-2", time.Minute)
+			So(err, ShouldHaveSameTypeAs, &HeldError{})
+		})
+
+		Convey("expires", func() {
+			tc.Add(time.Minute)
+			l2, err := Acquire(c, "job", "worker-2", time.Minute)
+			So(err, ShouldBeNil)
+			So(l2.Holder, ShouldEqual, "worker-2")
+
+			So(l.Renew(c, time.Minute), ShouldEqual, ErrLost)
+			So(l.Release(c), ShouldEqual, ErrLost)
+			So(l2.Renew(c, time.Minute), ShouldBeNil)
+		})
+
+		Convey("can be renewed after expiring if nobody took it", func() {
+			tc.Add(2 * time.Minute)
+			So(l.Renew(c, time.Minute), ShouldBeNil)
+			_, err := Acquire(c, "job", "worker-2", time.Minute)
+			So(err, ShouldHaveSameTypeAs, &HeldError{})
+		})
+
+		Convey("can be released", func() {
+			So(l.Release(c), ShouldBeNil)
+			So(l.Release(c), ShouldEqual, ErrLost)
+
+			_, err := Acquire(c, "job", "worker-2", time.Minute)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("bad arguments", func() {
+			_, err := Acquire(c, "", "worker", time.Minute)
+			So(err, ShouldErrLike, "empty name")
+			_, err = Acquire(c, "job", "worker", 0)
+			So(err, ShouldErrLike, "bad TTL")
+			So(l.Renew(c, -time.Second), ShouldErrLike, "bad TTL")
+		})
+	})
+}
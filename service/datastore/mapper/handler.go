@@ -0,0 +1,141 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mapper
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/info"
+	tq "go.chromium.org/gae/service/taskqueue"
+
+	"go.chromium.org/luci/common/errors"
+	"go.chromium.org/luci/common/logging"
+
+	"golang.org/x/net/context"
+)
+
+// ShardPath is the URL path of shard tasks, at which Handler must be mounted.
+const ShardPath = "/internal/gae/mapper/shard"
+
+// shardTask returns a task which processes step of a shard, in the namespace
+// of c.
+func shardTask(c context.Context, runID, shardID, step int64) *tq.Task {
+	v := url.Values{}
+	v.Set("run", strconv.FormatInt(runID, 10))
+	v.Set("shard", strconv.FormatInt(shardID, 10))
+	v.Set("step", strconv.FormatInt(step, 10))
+	if ns := info.GetNamespace(c); ns != "" {
+		v.Set("ns", ns)
+	}
+	return &tq.Task{Path: ShardPath + "?" + v.Encode(), Method: "POST"}
+}
+
+// taskParams are the parameters of a shard task.
+type taskParams struct {
+	run, shard, step int64
+	ns               string
+}
+
+func parseTaskParams(v url.Values) (*taskParams, error) {
+	p := &taskParams{ns: v.Get("ns")}
+	for name, dst := range map[string]*int64{"run": &p.run, "shard": &p.shard, "step": &p.step} {
+		var err error
+		if *dst, err = strconv.ParseInt(v.Get(name), 10, 64); err != nil {
+			return nil, fmt.Errorf("mapper: bad %s %q", name, v.Get(name))
+		}
+	}
+	return p, nil
+}
+
+func (p *taskParams) process(c context.Context) error {
+	c, err := info.Namespace(c, p.ns)
+	if err != nil {
+		return err
+	}
+	return processShard(c, p.run, p.shard, p.step)
+}
+
+// Handler returns an http.Handler which processes shard tasks. It must be
+// mounted at ShardPath.
+//
+// getContext returns the context to process a request with, e.g. by calling
+// prod.Use. Tasks switch to the namespace that the run was launched in.
+//
+// Tasks with malformed parameters are logged and dropped. If a batch fails, the
+// handler responds with an error, so the task is retried.
+func Handler(getContext func(r *http.Request) context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := getContext(r)
+		p, err := parseTaskParams(r.URL.Query())
+		if err != nil {
+			logging.WithError(err).Errorf(c, "mapper: dropping task %q", r.Header.Get("X-AppEngine-TaskName"))
+			return
+		}
+		if err := p.process(c); err != nil {
+			logging.WithError(err).Errorf(c, "mapper: shard %d of run %d failed", p.shard, p.run)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// RunPending processes the shard tasks scheduled in the Testable task queue in
+// c (e.g. from impl/memory), and deletes them, until there are none left. It's
+// intended for tests. It catches up the Testable datastore's indexes before
+// each task.
+//
+// It stops at the first error, leaving the failed task scheduled.
+func RunPending(c context.Context) error {
+	tqt := tq.GetTestable(c)
+	if tqt == nil {
+		return errors.New("mapper: the task queue is not testable")
+	}
+	for {
+		var task *tq.Task
+		queue := ""
+		for qn, tasks := range tqt.GetScheduledTasks() {
+			for _, t := range tasks {
+				if strings.HasPrefix(t.Path, ShardPath+"?") && (task == nil || t.ETA.Before(task.ETA)) {
+					task, queue = t, qn
+				}
+			}
+		}
+		if task == nil {
+			return nil
+		}
+
+		u, err := url.Parse(task.Path)
+		if err != nil {
+			return err
+		}
+		p, err := parseTaskParams(u.Query())
+		if err != nil {
+			return err
+		}
+		if dst := ds.GetTestable(c); dst != nil {
+			dst.CatchupIndexes()
+		}
+		if err := p.process(c); err != nil {
+			return err
+		}
+		if err := tq.Delete(c, queue, task); err != nil {
+			return err
+		}
+	}
+}
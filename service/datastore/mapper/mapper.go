@@ -0,0 +1,173 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mapper runs a function over all of the entities matched by
+// a datastore query, e.g. for backfills and migrations.
+//
+// A Job is registered with Register, usually at init time:
+//
+//	func init() {
+//	  mapper.Register(&mapper.Job{
+//	    Name:  "addDefaults",
+//	    Query: datastore.NewQuery("Foo"),
+//	    Mapper: func(c context.Context, keys []*datastore.Key) error {
+//	      ...
+//	    },
+//	  })
+//	}
+//
+// and is then started with Launch, which returns the ID of the job's run:
+//
+//	id, err := mapper.Launch(c, "addDefaults")
+//
+// Launch splits the key space of the query's kind into shards using the
+// __scatter__ property, and adds a task for each shard. Each shard task calls
+// the Mapper with a batch of keys, then checkpoints its cursor in the datastore
+// and adds a task for the next batch, in one transaction. A batch may be
+// mapped more than once (e.g. if the checkpoint fails), so the Mapper must be
+// idempotent. Shard tasks are handled by Handler, which must be mounted at
+// ShardPath.
+//
+// GetStatus reports the progress of a run, and Abort stops it.
+//
+// In tests, RunPending runs the shard tasks scheduled in the fake task queue
+// (e.g. from impl/memory) directly, without going through HTTP.
+package mapper
+
+import (
+	"fmt"
+	"sync"
+
+	ds "go.chromium.org/gae/service/datastore"
+
+	"golang.org/x/net/context"
+)
+
+// Mapper is called with each batch of the keys of the entities matched by a
+// job's query. If it returns an error, the batch is retried.
+type Mapper func(c context.Context, keys []*ds.Key) error
+
+// Job is a registered mapper job.
+type Job struct {
+	// Name identifies the job. It must be unique.
+	Name string
+
+	// Query selects the entities to map. It must have a kind, and must not have
+	// inequality filters, orders, limits, offsets, cursors or projections.
+	//
+	// Queries with an ancestor or equality filters can't be split by key range
+	// without composite indexes, so they're always processed by a single shard.
+	Query *ds.Query
+
+	// Mapper is called with the keys of the entities matched by Query.
+	Mapper Mapper
+
+	// Shards is the maximum number of shards that the job is split into. There
+	// may be fewer if there aren't enough __scatter__ entities to split at. If
+	// zero, DefaultShards is used.
+	Shards int
+
+	// BatchSize is the number of keys passed to each call of Mapper. If zero,
+	// DefaultBatchSize is used.
+	BatchSize int
+
+	// Queue is the task queue that the shard tasks are added to. If empty, the
+	// default queue is used.
+	Queue string
+}
+
+// Defaults for Job.
+const (
+	DefaultShards    = 8
+	DefaultBatchSize = 100
+)
+
+var registry = struct {
+	sync.RWMutex
+	jobs map[string]*Job
+}{jobs: map[string]*Job{}}
+
+// Register registers job.
+//
+// Register panics if job is invalid, or if a job with the same name is
+// already registered.
+func Register(job *Job) {
+	if job.Name == "" {
+		panic(fmt.Errorf("mapper: job has no name"))
+	}
+	if job.Mapper == nil {
+		panic(fmt.Errorf("mapper: job %q has no Mapper", job.Name))
+	}
+	if job.Query == nil {
+		panic(fmt.Errorf("mapper: job %q has no Query", job.Name))
+	}
+	if err := checkQuery(job.Query); err != nil {
+		panic(fmt.Errorf("mapper: job %q: %s", job.Name, err))
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.jobs[job.Name]; ok {
+		panic(fmt.Errorf("mapper: job %q is already registered", job.Name))
+	}
+	j := *job
+	if j.Shards <= 0 {
+		j.Shards = DefaultShards
+	}
+	if j.BatchSize <= 0 {
+		j.BatchSize = DefaultBatchSize
+	}
+	registry.jobs[job.Name] = &j
+}
+
+func lookup(name string) (*Job, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	if job, ok := registry.jobs[name]; ok {
+		return job, nil
+	}
+	return nil, fmt.Errorf("mapper: no job is registered as %q", name)
+}
+
+func checkQuery(q *ds.Query) error {
+	fq, err := q.Finalize()
+	if err != nil {
+		return err
+	}
+	start, end := fq.Bounds()
+	_, hasLimit := fq.Limit()
+	_, hasOffset := fq.Offset()
+	switch {
+	case fq.Kind() == "":
+		return fmt.Errorf("the query has no kind")
+	case fq.IneqFilterProp() != "":
+		return fmt.Errorf("the query has an inequality filter")
+	case len(fq.Orders()) != 1 || fq.Orders()[0].Property != "__key__" || fq.Orders()[0].Descending:
+		return fmt.Errorf("the query has an order")
+	case hasLimit || hasOffset:
+		return fmt.Errorf("the query has a limit or offset")
+	case start != nil || end != nil:
+		return fmt.Errorf("the query has a cursor")
+	case len(fq.Project()) > 0 || fq.Distinct():
+		return fmt.Errorf("the query has a projection")
+	}
+	return nil
+}
+
+// splittable returns true if the job's query can be split into key ranges,
+// i.e. it has no equality filters (including an ancestor).
+func (j *Job) splittable() bool {
+	fq, _ := j.Query.Finalize()
+	return len(fq.EqFilters()) == 0
+}
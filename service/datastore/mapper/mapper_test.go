@@ -0,0 +1,218 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+	tq "go.chromium.org/gae/service/taskqueue"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+type item struct {
+	ID      int64   `gae:"$id"`
+	Parent  *ds.Key `gae:"$parent"`
+	Visited int
+	Color   string
+}
+
+// visit increments Visited of each of the items, and fails if failKey is set
+// and in the batch.
+func visit(c context.Context, keys []*ds.Key) error {
+	if failKey, _ := c.Value(&failKeyKey).(*ds.Key); failKey != nil {
+		for _, k := range keys {
+			if k.Equal(failKey) {
+				return errors.New("boom")
+			}
+		}
+	}
+	items := make([]*item, len(keys))
+	for i, k := range keys {
+		items[i] = &item{}
+		ds.PopulateKey(items[i], k)
+	}
+	if err := ds.Get(c, items); err != nil {
+		return err
+	}
+	for _, it := range items {
+		it.Visited++
+	}
+	return ds.Put(c, items)
+}
+
+var failKeyKey = "failKey"
+
+func init() {
+	Register(&Job{Name: "mapper_test.all", Query: ds.NewQuery("item"), Mapper: visit, BatchSize: 7})
+	Register(&Job{Name: "mapper_test.red", Query: ds.NewQuery("item").Eq("Color", "red"), Mapper: visit, BatchSize: 7})
+}
+
+func TestMapper(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test mapper", t, func() {
+		c := memory.Use(context.Background())
+		ds.GetTestable(c).Consistent(true)
+		tqt := tq.GetTestable(c)
+
+		items := make([]*item, 1000)
+		for i := range items {
+			items[i] = &item{ID: int64(i + 1), Color: "blue"}
+			if i%10 == 0 {
+				items[i].Color = "red"
+			}
+		}
+		So(ds.Put(c, items), ShouldBeNil)
+
+		visited := func() (total int, twice bool) {
+			So(ds.Get(c, items), ShouldBeNil)
+			for _, it := range items {
+				total += it.Visited
+				twice = twice || it.Visited > 1
+			}
+			return
+		}
+
+		Convey("Register rejects bad jobs", func() {
+			So(func() { Register(&Job{Query: ds.NewQuery("item"), Mapper: visit}) }, ShouldPanicLike, "no name")
+			So(func() { Register(&Job{Name: "x", Query: ds.NewQuery("item")}) }, ShouldPanicLike, "no Mapper")
+			So(func() { Register(&Job{Name: "x", Mapper: visit}) }, ShouldPanicLike, "no Query")
+			So(func() { Register(&Job{Name: "x", Query: ds.NewQuery(""), Mapper: visit}) }, ShouldPanicLike, "no kind")
+			So(func() { Register(&Job{Name: "x", Query: ds.NewQuery("item").Gt("Visited", 1), Mapper: visit}) }, ShouldPanicLike, "inequality")
+			So(func() { Register(&Job{Name: "x", Query: ds.NewQuery("item").Order("Color"), Mapper: visit}) }, ShouldPanicLike, "has an order")
+			So(func() { Register(&Job{Name: "x", Query: ds.NewQuery("item").Limit(1), Mapper: visit}) }, ShouldPanicLike, "limit")
+			So(func() { Register(&Job{Name: "mapper_test.all", Query: ds.NewQuery("item"), Mapper: visit}) }, ShouldPanicLike, "already registered")
+		})
+
+		Convey("Launch fails for unknown jobs", func() {
+			_, err := Launch(c, "nope")
+			So(err, ShouldErrLike, "no job is registered")
+		})
+
+		Convey("maps every entity once", func() {
+			id, err := Launch(c, "mapper_test.all")
+			So(err, ShouldBeNil)
+
+			st, err := GetStatus(c, id)
+			So(err, ShouldBeNil)
+			So(st.State, ShouldEqual, StateRunning)
+			So(len(st.Shards), ShouldBeGreaterThan, 1)
+			So(len(st.Shards), ShouldBeLessThanOrEqualTo, DefaultShards)
+
+			So(RunPending(c), ShouldBeNil)
+			total, twice := visited()
+			So(total, ShouldEqual, len(items))
+			So(twice, ShouldBeFalse)
+
+			st, err = GetStatus(c, id)
+			So(err, ShouldBeNil)
+			So(st.State, ShouldEqual, StateDone)
+			So(st.Processed, ShouldEqual, len(items))
+		})
+
+		Convey("maps filtered queries in one shard", func() {
+			id, err := Launch(c, "mapper_test.red")
+			So(err, ShouldBeNil)
+			So(RunPending(c), ShouldBeNil)
+
+			total, _ := visited()
+			So(total, ShouldEqual, len(items)/10)
+			st, err := GetStatus(c, id)
+			So(err, ShouldBeNil)
+			So(len(st.Shards), ShouldEqual, 1)
+			So(st.State, ShouldEqual, StateDone)
+		})
+
+		Convey("ignores duplicate tasks", func() {
+			id, err := Launch(c, "mapper_test.all")
+			So(err, ShouldBeNil)
+			p := &taskParams{run: id, shard: 1, step: 0}
+			So(p.process(c), ShouldBeNil)
+			So(p.process(c), ShouldBeNil)
+
+			total, twice := visited()
+			So(total, ShouldEqual, 7)
+			So(twice, ShouldBeFalse)
+		})
+
+		Convey("records and retries failures", func() {
+			fc := context.WithValue(c, &failKeyKey, ds.KeyForObj(c, items[500]))
+			id, err := Launch(fc, "mapper_test.all")
+			So(err, ShouldBeNil)
+			So(RunPending(fc), ShouldErrLike, "boom")
+
+			st, err := GetStatus(c, id)
+			So(err, ShouldBeNil)
+			So(st.State, ShouldEqual, StateRunning)
+			failed := 0
+			for _, s := range st.Shards {
+				if s.Error != "" {
+					failed++
+				}
+			}
+			So(failed, ShouldEqual, 1)
+
+			So(RunPending(c), ShouldBeNil)
+			total, twice := visited()
+			So(total, ShouldEqual, len(items))
+			So(twice, ShouldBeFalse)
+		})
+
+		Convey("can be aborted", func() {
+			id, err := Launch(c, "mapper_test.all")
+			So(err, ShouldBeNil)
+			So(Abort(c, id), ShouldBeNil)
+			So(RunPending(c), ShouldBeNil)
+
+			total, _ := visited()
+			So(total, ShouldEqual, 0)
+			st, err := GetStatus(c, id)
+			So(err, ShouldBeNil)
+			So(st.State, ShouldEqual, StateAborted)
+		})
+
+		Convey("Handler processes tasks", func() {
+			_, err := Launch(c, "mapper_test.red")
+			So(err, ShouldBeNil)
+			tasks := tqt.FindScheduledTasks(tq.TaskQuery{PathPrefix: ShardPath})
+			So(len(tasks), ShouldEqual, 1)
+
+			h := Handler(func(*http.Request) context.Context { return c })
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("POST", tasks[0].Path, strings.NewReader("")))
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			total, _ := visited()
+			So(total, ShouldEqual, 7)
+
+			Convey("and drops bad ones", func() {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest("POST", fmt.Sprintf("%s?run=x", ShardPath), nil))
+				So(rec.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+	})
+}
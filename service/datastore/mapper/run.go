@@ -0,0 +1,300 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mapper
+
+import (
+	"sort"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+	tq "go.chromium.org/gae/service/taskqueue"
+
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// splitOversampling is the number of __scatter__ keys sampled per shard when
+// splitting the key space. More samples give more evenly sized shards.
+const splitOversampling = 32
+
+// run is the datastore entity of a run of a job.
+type run struct {
+	_kind string `gae:"$kind,gae.mapper.Run"`
+
+	ID int64 `gae:"$id"`
+
+	Job     string    `gae:",noindex"`
+	Created time.Time `gae:",noindex"`
+	Shards  int       `gae:",noindex"`
+	Aborted bool      `gae:",noindex"`
+}
+
+// shard is the datastore entity of a shard of a run, whose parent is the run.
+type shard struct {
+	_kind string `gae:"$kind,gae.mapper.Shard"`
+
+	// ID is the 1-based index of the shard.
+	ID     int64   `gae:"$id"`
+	Parent *ds.Key `gae:"$parent"`
+
+	// Lo and Hi are the inclusive lower and exclusive upper bounds of the keys
+	// of the shard. nil means unbounded.
+	Lo *ds.Key `gae:",noindex"`
+	Hi *ds.Key `gae:",noindex"`
+
+	// Cursor is where the next batch starts, and Step is the number of batches
+	// processed so far. Only the task for the current Step does anything.
+	Cursor string `gae:",noindex"`
+	Step   int64  `gae:",noindex"`
+
+	Processed int64     `gae:",noindex"`
+	Done      bool      `gae:",noindex"`
+	Error     string    `gae:",noindex"`
+	Updated   time.Time `gae:",noindex"`
+}
+
+// splitPoints returns the keys at which to split job into shards.
+func splitPoints(c context.Context, job *Job) ([]*ds.Key, error) {
+	if job.Shards <= 1 || !job.splittable() {
+		return nil, nil
+	}
+
+	fq, _ := job.Query.Finalize()
+	q := ds.NewQuery(fq.Kind()).Order("__scatter__").KeysOnly(true).Limit(int32(job.Shards * splitOversampling))
+	var keys []*ds.Key
+	if err := ds.GetAll(c, q, &keys); err != nil {
+		return nil, errors.Annotate(err, "failed to sample keys of %q", fq.Kind()).Err()
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Less(keys[j]) })
+
+	var splits []*ds.Key
+	for i := 1; i < job.Shards && len(keys) > 0; i++ {
+		k := keys[i*len(keys)/job.Shards]
+		if len(splits) == 0 || !splits[len(splits)-1].Equal(k) {
+			splits = append(splits, k)
+		}
+	}
+	return splits, nil
+}
+
+// Launch starts a run of the job registered as name, and returns its ID.
+func Launch(c context.Context, name string) (int64, error) {
+	job, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	splits, err := splitPoints(c, job)
+	if err != nil {
+		return 0, err
+	}
+
+	now := clock.Now(c).UTC()
+	r := &run{Job: name, Created: now, Shards: len(splits) + 1}
+	if err := ds.Put(c, r); err != nil {
+		return 0, errors.Annotate(err, "failed to create run").Err()
+	}
+
+	runKey := ds.KeyForObj(c, r)
+	shards := make([]*shard, r.Shards)
+	tasks := make([]*tq.Task, r.Shards)
+	for i := range shards {
+		s := &shard{ID: int64(i + 1), Parent: runKey, Updated: now}
+		if i > 0 {
+			s.Lo = splits[i-1]
+		}
+		if i < len(splits) {
+			s.Hi = splits[i]
+		}
+		shards[i] = s
+		tasks[i] = shardTask(c, r.ID, s.ID, 0)
+	}
+	if err := ds.Put(c, shards); err != nil {
+		return 0, errors.Annotate(err, "failed to create shards of run %d", r.ID).Err()
+	}
+	if err := tq.Add(c, job.Queue, tasks...); err != nil {
+		return 0, errors.Annotate(err, "failed to add tasks of run %d", r.ID).Err()
+	}
+	return r.ID, nil
+}
+
+// processShard processes the next batch of a shard, if it's at step.
+func processShard(c context.Context, runID, shardID, step int64) error {
+	r := &run{ID: runID}
+	s := &shard{ID: shardID, Parent: ds.KeyForObj(c, r)}
+	if err := ds.Get(c, r, s); err != nil {
+		return errors.Annotate(err, "failed to get shard %d of run %d", shardID, runID).Err()
+	}
+	if r.Aborted || s.Done || s.Step != step {
+		return nil // a stale or duplicate task
+	}
+	job, err := lookup(r.Job)
+	if err != nil {
+		return err
+	}
+
+	q := job.Query.KeysOnly(true).Limit(int32(job.BatchSize))
+	if s.Lo != nil {
+		q = q.Gte("__key__", s.Lo)
+	}
+	if s.Hi != nil {
+		q = q.Lt("__key__", s.Hi)
+	}
+	if s.Cursor != "" {
+		cursor, err := ds.DecodeCursor(c, s.Cursor)
+		if err != nil {
+			return errors.Annotate(err, "bad cursor in shard %d of run %d", shardID, runID).Err()
+		}
+		q = q.Start(cursor)
+	}
+
+	keys := make([]*ds.Key, 0, job.BatchSize)
+	next := ""
+	err = ds.Run(c, q, func(k *ds.Key, getCursor ds.CursorCB) error {
+		keys = append(keys, k)
+		if len(keys) == job.BatchSize {
+			cursor, err := getCursor()
+			if err != nil {
+				return err
+			}
+			next = cursor.String()
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Annotate(err, "failed to query shard %d of run %d", shardID, runID).Err()
+	}
+
+	if len(keys) > 0 {
+		if err := job.Mapper(c, keys); err != nil {
+			// Record the error for GetStatus. The batch is retried by the task queue.
+			uerr := updateShard(c, s, step, func(c context.Context, s *shard) error {
+				s.Error = err.Error()
+				return nil
+			})
+			if uerr != nil {
+				return errors.NewMultiError(err, uerr)
+			}
+			return err
+		}
+	}
+
+	// Checkpoint, and continue with the next batch.
+	return updateShard(c, s, step, func(c context.Context, s *shard) error {
+		s.Cursor = next
+		s.Step++
+		s.Processed += int64(len(keys))
+		s.Error = ""
+		s.Done = len(keys) < job.BatchSize
+		if s.Done {
+			return nil
+		}
+		return tq.Add(c, job.Queue, shardTask(c, runID, shardID, s.Step))
+	})
+}
+
+// updateShard calls cb with the current state of s in a transaction, if it's
+// still at step, and saves it.
+func updateShard(c context.Context, s *shard, step int64, cb func(c context.Context, s *shard) error) error {
+	return ds.RunInTransaction(c, func(c context.Context) error {
+		cur := &shard{ID: s.ID, Parent: s.Parent}
+		if err := ds.Get(c, cur); err != nil {
+			return err
+		}
+		if cur.Step != step {
+			return nil
+		}
+		if err := cb(c, cur); err != nil {
+			return err
+		}
+		cur.Updated = clock.Now(c).UTC()
+		return ds.Put(c, cur)
+	}, nil)
+}
+
+// State is the state of a run.
+type State string
+
+// The states of a run.
+const (
+	StateRunning State = "RUNNING"
+	StateDone    State = "DONE"
+	StateAborted State = "ABORTED"
+)
+
+// ShardStatus is the progress of a shard of a run.
+type ShardStatus struct {
+	// Index is the 1-based index of the shard.
+	Index int64
+	// Done is true if the shard has processed all of its keys.
+	Done bool
+	// Processed is the number of keys processed by the shard.
+	Processed int64
+	// Error is the error of the last failed batch, if it hasn't succeeded since.
+	Error string
+	// Updated is when the shard last made progress or failed.
+	Updated time.Time
+}
+
+// Status is the progress of a run.
+type Status struct {
+	ID      int64
+	Job     string
+	Created time.Time
+	State   State
+
+	// Processed is the number of keys processed by all shards.
+	Processed int64
+	Shards    []ShardStatus
+}
+
+// GetStatus returns the progress of the run with id.
+func GetStatus(c context.Context, id int64) (*Status, error) {
+	r := &run{ID: id}
+	if err := ds.Get(c, r); err != nil {
+		return nil, errors.Annotate(err, "failed to get run %d", id).Err()
+	}
+	var shards []*shard
+	if err := ds.GetAll(c, ds.NewQuery("gae.mapper.Shard").Ancestor(ds.KeyForObj(c, r)), &shards); err != nil {
+		return nil, errors.Annotate(err, "failed to get shards of run %d", id).Err()
+	}
+
+	ret := &Status{ID: id, Job: r.Job, Created: r.Created, State: StateDone, Shards: make([]ShardStatus, len(shards))}
+	for i, s := range shards {
+		ret.Shards[i] = ShardStatus{Index: s.ID, Done: s.Done, Processed: s.Processed, Error: s.Error, Updated: s.Updated}
+		ret.Processed += s.Processed
+		if !s.Done {
+			ret.State = StateRunning
+		}
+	}
+	if r.Aborted {
+		ret.State = StateAborted
+	}
+	return ret, nil
+}
+
+// Abort stops the run with id. Batches which are being processed are
+// finished, but no more are started.
+func Abort(c context.Context, id int64) error {
+	return ds.RunInTransaction(c, func(c context.Context) error {
+		r := &run{ID: id}
+		if err := ds.Get(c, r); err != nil {
+			return err
+		}
+		r.Aborted = true
+		return ds.Put(c, r)
+	}, nil)
+}
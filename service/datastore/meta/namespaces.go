@@ -19,6 +19,10 @@ import (
 	"strings"
 
 	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/info"
+
+	"go.chromium.org/luci/common/errors"
+	"go.chromium.org/luci/common/sync/parallel"
 
 	"golang.org/x/net/context"
 )
@@ -82,3 +86,62 @@ func (c *NamespacesCollector) Callback(v string) error {
 	*c = append(*c, v)
 	return nil
 }
+
+// RunInNamespacesOptions are options for RunInNamespaces.
+type RunInNamespacesOptions struct {
+	// Concurrency is the maximum number of namespaces processed at once. If zero
+	// or one, namespaces are processed one at a time, in order.
+	Concurrency int
+}
+
+// RunInNamespaces runs fn once for each namespace accepted by pred, with the
+// Context switched to that namespace. See RunInNamespaces.
+func (o *RunInNamespacesOptions) RunInNamespaces(c context.Context, pred func(ns string) bool, fn func(context.Context) error) error {
+	// Collect the namespaces first, so the query isn't held open while fn runs.
+	var namespaces NamespacesCollector
+	err := Namespaces(c, func(ns string) error {
+		if pred == nil || pred(ns) {
+			namespaces = append(namespaces, ns)
+		}
+		return c.Err()
+	})
+	if err != nil {
+		return errors.Annotate(err, "failed to list namespaces").Err()
+	}
+
+	concurrency := o.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return parallel.WorkPool(concurrency, func(workC chan<- func() error) {
+		for _, ns := range namespaces {
+			ns := ns
+			workC <- func() error {
+				if err := c.Err(); err != nil {
+					return err
+				}
+				nc, err := info.Namespace(c, ns)
+				if err == nil {
+					err = fn(nc)
+				}
+				if err != nil {
+					return errors.Annotate(err, "in namespace %q", ns).Err()
+				}
+				return nil
+			}
+		}
+	})
+}
+
+// RunInNamespaces runs fn once for each namespace accepted by pred, or for
+// every namespace if pred is nil, with the Context switched to that namespace.
+// Namespaces are processed one at a time; use RunInNamespacesOptions to
+// process several at once.
+//
+// The namespaces are listed with Namespaces before fn is first called. An
+// error returned by fn doesn't stop the other namespaces from being processed:
+// RunInNamespaces returns an errors.MultiError with the errors of all of the
+// namespaces which failed.
+func RunInNamespaces(c context.Context, pred func(ns string) bool, fn func(context.Context) error) error {
+	return (&RunInNamespacesOptions{}).RunInNamespaces(c, pred, fn)
+}
@@ -15,15 +15,21 @@
 package meta
 
 import (
+	"strings"
+	"sync"
 	"testing"
 
 	"go.chromium.org/gae/impl/memory"
 	ds "go.chromium.org/gae/service/datastore"
 	"go.chromium.org/gae/service/info"
 
+	"go.chromium.org/luci/common/data/stringset"
+	"go.chromium.org/luci/common/errors"
+
 	"golang.org/x/net/context"
 
 	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
 )
 
 func TestNamespaces(t *testing.T) {
@@ -77,6 +83,32 @@ func TestNamespaces(t *testing.T) {
 				So(NamespacesWithPrefix(ctx, "baz-", coll.Callback), ShouldBeNil)
 				So(coll, ShouldResemble, NamespacesCollector{"baz-a", "baz-b"})
 			})
+
+			Convey(`Can run in namespaces.`, func() {
+				isBaz := func(ns string) bool { return strings.HasPrefix(ns, "baz-") }
+
+				var coll NamespacesCollector
+				So(RunInNamespaces(ctx, isBaz, func(c context.Context) error {
+					return coll.Callback(info.GetNamespace(c))
+				}), ShouldBeNil)
+				So(coll, ShouldResemble, NamespacesCollector{"baz-a", "baz-b"})
+
+				var lock sync.Mutex
+				seen := stringset.New(0)
+				opts := RunInNamespacesOptions{Concurrency: 3}
+				err := opts.RunInNamespaces(ctx, nil, func(c context.Context) error {
+					lock.Lock()
+					defer lock.Unlock()
+					seen.Add(info.GetNamespace(c))
+					if info.GetNamespace(c) == "foo" {
+						return errors.New("boom")
+					}
+					return nil
+				})
+				So(err, ShouldErrLike, `in namespace "foo": boom`)
+				So(err.(errors.MultiError), ShouldHaveLength, 1)
+				So(seen.Len(), ShouldEqual, 5)
+			})
 		})
 	})
 }
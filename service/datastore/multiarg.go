@@ -19,6 +19,7 @@ import (
 	"reflect"
 	"sort"
 	"sync"
+	"time"
 
 	"go.chromium.org/luci/common/errors"
 )
@@ -51,6 +52,10 @@ type multiArgType struct {
 	getMGS  func(slot reflect.Value) MetaGetterSetter
 	getPLS  func(slot reflect.Value) PropertyLoadSaver
 	newElem func() reflect.Value
+
+	// loadCopies is true if the PropertyMaps passed to setPM aren't retained,
+	// which is known for structs and PropertyMaps. See PropertyMapsReusable.
+	loadCopies bool
 }
 
 func (mat *multiArgType) getKey(kc KeyContext, slot reflect.Value) (*Key, error) {
@@ -65,14 +70,68 @@ func (mat *multiArgType) getMetaPM(slot reflect.Value) PropertyMap {
 	return mat.getMGS(slot).GetAllMeta()
 }
 
+// setPM loads pm into slot. Any metadata in pm, such as a "$version"
+// reported by the implementation, is set with SetMeta instead of being loaded,
+// and is dropped if slot has nowhere to put it.
 func (mat *multiArgType) setPM(slot reflect.Value, pm PropertyMap) error {
-	return mat.getPLS(slot).Load(pm)
+	var meta PropertyMap
+	for k := range pm {
+		if isMetaKey(k) && k != "" {
+			if meta == nil {
+				meta = PropertyMap{}
+			}
+			meta[k] = pm[k]
+		}
+	}
+	if meta == nil {
+		return mat.getPLS(slot).Load(pm)
+	}
+
+	data := make(PropertyMap, len(pm)-len(meta))
+	for k, v := range pm {
+		if _, ok := meta[k]; !ok {
+			data[k] = v
+		}
+	}
+	if err := mat.getPLS(slot).Load(data); err != nil {
+		return err
+	}
+	mgs := mat.getMGS(slot)
+	for k, v := range meta {
+		if vals := v.Slice(); len(vals) > 0 {
+			mgs.SetMeta(k[1:], vals[0].Value())
+		}
+	}
+	return nil
+}
+
+// setVersion sets the "$version" meta of slot from pm, the PropertyMap which
+// was written for it, if the implementation reported one and slot has a
+// version.
+func (mat *multiArgType) setVersion(slot reflect.Value, pm PropertyMap) {
+	if v, ok := pm.GetMeta(VersionMeta); ok {
+		mgs := mat.getMGS(slot)
+		if _, ok := mgs.GetMeta(VersionMeta); ok {
+			mgs.SetMeta(VersionMeta, v)
+		}
+	}
 }
 
 func (mat *multiArgType) setKey(slot reflect.Value, k *Key) bool {
 	return populateKeyMGS(mat.getMGS(slot), k)
 }
 
+// multiArgTypeKey is the key of multiArgTypes.
+type multiArgTypeKey struct {
+	et        reflect.Type
+	allowKeys bool
+}
+
+// multiArgTypes caches the results of parseArg, which is called for the
+// arguments of every Get, Put, Delete and Run. A multiArgType is immutable, so
+// it can be shared. A nil *multiArgType is cached for unsupported types.
+var multiArgTypes sync.Map // multiArgTypeKey -> *multiArgType
+
 // parseArg checks that et is of type S, *S, I, P or *P, for some
 // struct type S, for some interface type I, or some non-interface non-pointer
 // type P such that P or *P implements PropertyLoadSaver.
@@ -82,7 +141,18 @@ func (mat *multiArgType) setKey(slot reflect.Value, k *Key) bool {
 //
 // If allowKey is true, et may additional be type *Key. Only MetaGetterSetter
 // fields will be populated in the result (see keyMGS).
+//
+// The result is cached in multiArgTypes.
 func parseArg(et reflect.Type, allowKeys bool) *multiArgType {
+	key := multiArgTypeKey{et, allowKeys}
+	if mat, ok := multiArgTypes.Load(key); ok {
+		return mat.(*multiArgType)
+	}
+	mat, _ := multiArgTypes.LoadOrStore(key, parseArgUncached(et, allowKeys))
+	return mat.(*multiArgType)
+}
+
+func parseArgUncached(et reflect.Type, allowKeys bool) *multiArgType {
 	var mat multiArgType
 
 	if et == typeOfKey {
@@ -146,6 +216,7 @@ func parseArg(et reflect.Type, allowKeys bool) *multiArgType {
 	case et.Implements(typeOfPropertyLoadSaver):
 		// PLS
 		mat.getPLS = func(slot reflect.Value) PropertyLoadSaver { return slot.Interface().(PropertyLoadSaver) }
+		mat.loadCopies = et == typeOfPropertyMap
 
 	case reflect.PtrTo(et).Implements(typeOfPropertyLoadSaver):
 		// *PLS
@@ -171,11 +242,13 @@ func parseArg(et reflect.Type, allowKeys bool) *multiArgType {
 			}
 			initCodec(et.Elem())
 			mat.getPLS = func(slot reflect.Value) PropertyLoadSaver { return &structPLS{slot.Elem(), codec, nil} }
+			mat.loadCopies = true
 
 		case reflect.Struct:
 			// S
 			initCodec(et)
 			mat.getPLS = func(slot reflect.Value) PropertyLoadSaver { return &structPLS{slot, codec, nil} }
+			mat.loadCopies = true
 
 		default:
 			// Don't know how to get PLS for this type.
@@ -460,6 +533,22 @@ func makeMetaMultiArg(args []interface{}, c metaMultiArgConstraints) (*metaMulti
 }
 
 // get returns the element type and value at flattened index idx.
+// setAutoNow sets the 'created' and 'updated' fields of all of the struct
+// arguments in mma to now. See structPLS.setAutoNow.
+func (mma *metaMultiArg) setAutoNow(now time.Time) {
+	if mma.keysOnly {
+		return
+	}
+	for i := range mma.elems {
+		e := &mma.elems[i]
+		for j := 0; j < e.length(); j++ {
+			if pls, ok := e.mat.getPLS(e.slot(j)).(*structPLS); ok {
+				pls.setAutoNow(now)
+			}
+		}
+	}
+}
+
 func (mma *metaMultiArg) index(idx int) (mmaIdx metaMultiArgIndex) {
 	if mma.flat {
 		mmaIdx.elem = idx
@@ -485,6 +574,17 @@ func (mma *metaMultiArg) get(idx metaMultiArgIndex) (*multiArgType, reflect.Valu
 	return elem.mat, slot
 }
 
+// loadCopies returns true if all of the elements of mma copy the PropertyMaps
+// they load (see multiArgType.loadCopies).
+func (mma *metaMultiArg) loadCopies() bool {
+	for i := range mma.elems {
+		if !mma.elems[i].mat.loadCopies {
+			return false
+		}
+	}
+	return true
+}
+
 // getKeysPMs returns the keys and PropertyMap for the supplied argument items.
 func (mma *metaMultiArg) getKeysPMs(kc KeyContext, meta bool) ([]*Key, []PropertyMap, error) {
 	et := newErrorTracker(mma)
@@ -0,0 +1,121 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"reflect"
+	"testing"
+
+	"go.chromium.org/gae/service/info"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseArgCache(t *testing.T) {
+	t.Parallel()
+
+	Convey("parseArg caches its results", t, func() {
+		et := reflect.TypeOf(&CommonStruct{})
+		mat := parseArg(et, false)
+		So(mat, ShouldNotBeNil)
+		So(parseArg(et, false), ShouldEqual, mat)
+
+		So(parseArg(typeOfKey, false), ShouldBeNil)
+		So(parseArg(typeOfKey, false), ShouldBeNil)
+		So(parseArg(typeOfKey, true), ShouldNotBeNil)
+	})
+}
+
+func TestPropertyMapReuse(t *testing.T) {
+	t.Parallel()
+
+	Convey("PropertyMaps may be reused only when they are copied", t, func() {
+		c := info.Set(context.Background(), fakeInfo{})
+		fds := fakeDatastore{entities: 2}
+		var reusable []bool
+		c = SetRawFactory(c, func(ic context.Context) RawInterface {
+			reusable = append(reusable, PropertyMapsReusable(ic))
+			return fds.factory()(ic)
+		})
+		q := NewQuery("Kind")
+
+		So(Run(c, q, func(*CommonStruct) {}), ShouldBeNil)
+		So(Run(c, q, func(PropertyMap) {}), ShouldBeNil)
+		So(Run(c, q, func(*FakePLS) {}), ShouldBeNil)
+		So(Run(c, q, func(*Key) {}), ShouldBeNil)
+		So(reusable, ShouldResemble, []bool{true, true, false, false})
+
+		reusable = nil
+		So(GetAll(c, q, &[]CommonStruct{}), ShouldBeNil)
+		So(GetAll(c, q, &[]*FakePLS{}), ShouldBeNil)
+		So(reusable, ShouldResemble, []bool{true, false})
+
+		reusable = nil
+		So(Get(c, &CommonStruct{ID: 1}), ShouldBeNil)
+		So(Get(c, &CommonStruct{ID: 1}, &FakePLS{IntID: 1}), ShouldBeNil)
+		So(reusable, ShouldResemble, []bool{true, false})
+		So(PropertyMapsReusable(c), ShouldBeFalse)
+	})
+}
+
+func BenchmarkParseArg(b *testing.B) {
+	et := reflect.TypeOf(&CommonStruct{})
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			parseArg(et, false)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			parseArgUncached(et, false)
+		}
+	})
+}
+
+func BenchmarkGetMulti(b *testing.B) {
+	c := info.Set(context.Background(), fakeInfo{})
+	c = SetRawFactory(c, (&fakeDatastore{}).factory())
+
+	objs := make([]*CommonStruct, 10)
+	for i := range objs {
+		objs[i] = &CommonStruct{ID: int64(i + 1)}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Get(c, objs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	c := info.Set(context.Background(), fakeInfo{})
+	c = SetRawFactory(c, (&fakeDatastore{entities: 10}).factory())
+	q := NewQuery("Kind")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := Run(c, q, func(cs *CommonStruct) {})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
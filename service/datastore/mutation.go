@@ -0,0 +1,359 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"fmt"
+
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// MutationOp is the kind of write made by a Mutation.
+type MutationOp int
+
+const (
+	// MutationUpsert writes an entity, whether or not it already exists. This
+	// is what Put does.
+	MutationUpsert MutationOp = iota
+	// MutationInsert writes an entity, failing with ErrEntityExists if it
+	// already exists.
+	MutationInsert
+	// MutationUpdate writes an entity, failing with ErrNoSuchEntity if it
+	// doesn't already exist.
+	MutationUpdate
+	// MutationDelete deletes an entity. Like Delete, deleting an entity which
+	// doesn't exist is not an error.
+	MutationDelete
+)
+
+func (op MutationOp) String() string {
+	switch op {
+	case MutationUpsert:
+		return "upsert"
+	case MutationInsert:
+		return "insert"
+	case MutationUpdate:
+		return "update"
+	case MutationDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("MutationOp(%d)", int(op))
+	}
+}
+
+// RawMutation is a single write made by RawInterface.Mutate.
+type RawMutation struct {
+	Op  MutationOp
+	Key *Key
+
+	// Value is the entity to write. It's nil for MutationDelete.
+	Value PropertyMap
+}
+
+// Mutation is a single write made by Mutate. Use NewUpsert, NewInsert,
+// NewUpdate or NewDelete to make one.
+type Mutation struct {
+	op  MutationOp
+	arg interface{}
+}
+
+// NewUpsert returns a Mutation which writes src, as Put would. src may be any
+// of the types accepted by Put.
+func NewUpsert(src interface{}) *Mutation { return &Mutation{MutationUpsert, src} }
+
+// NewInsert returns a Mutation which writes src if it doesn't already exist.
+// src may be any of the types accepted by Put.
+func NewInsert(src interface{}) *Mutation { return &Mutation{MutationInsert, src} }
+
+// NewUpdate returns a Mutation which writes src if it already exists. src may
+// be any of the types accepted by Put, but its keys must be complete.
+func NewUpdate(src interface{}) *Mutation { return &Mutation{MutationUpdate, src} }
+
+// NewDelete returns a Mutation which deletes ent. ent may be any of the types
+// accepted by Delete.
+func NewDelete(ent interface{}) *Mutation { return &Mutation{MutationDelete, ent} }
+
+// Op returns the kind of write m makes.
+func (m *Mutation) Op() MutationOp { return m.op }
+
+// Mutate applies muts in a single commit: either all of them are applied, or
+// none are. This takes fewer RPCs than separate Put and Delete calls, and
+// allows the "must not exist" and "must exist" checks of NewInsert and
+// NewUpdate without a transaction.
+//
+// Since the commit is atomic, it's subject to the limits of a cross-group
+// transaction: the mutated keys may span at most
+// Constraints.MaxEntityGroupsPerTransaction entity groups (25 on App Engine),
+// and Mutate fails otherwise. Mutate doesn't split muts to fit, as that would
+// lose the atomicity; use separate calls for larger sets of writes.
+//
+// Mutations are applied in order, so a later mutation of a key takes
+// precedence over an earlier one. As with Put, if a written object has an
+// incomplete key, the allocated key is written back to it if Mutate succeeds.
+//
+// If any mutation fails, nothing is applied and Mutate returns a MultiError
+// with the error of each mutation. A mutation whose argument is a slice has a
+// nested MultiError, as with Put. If only one mutation is supplied, its error
+// is returned directly.
+func Mutate(c context.Context, muts ...*Mutation) error {
+	if len(muts) == 0 {
+		return nil
+	}
+
+	// Split the arguments into puts and deletes, so they can be parsed by the
+	// same machinery as Put and Delete. argIdx is the index of each mutation's
+	// argument in puts or dels.
+	var puts, dels []interface{}
+	argIdx := make([]int, len(muts))
+	for i, m := range muts {
+		if m.op == MutationDelete {
+			argIdx[i] = len(dels)
+			dels = append(dels, m.arg)
+		} else {
+			argIdx[i] = len(puts)
+			puts = append(puts, m.arg)
+		}
+	}
+
+	putMMA, err := makeMetaMultiArg(puts, mmaReadWrite)
+	if err != nil {
+		panic(err)
+	}
+	delMMA, err := makeMetaMultiArg(dels, mmaKeysOnly)
+	if err != nil {
+		panic(err)
+	}
+	putMMA.setAutoNow(autoNow(c))
+
+	// mutErrors returns the error of each mutation, given the per-argument
+	// errors of puts and dels.
+	mutErrors := func(putErr, delErr error) error {
+		if putErr == nil && delErr == nil {
+			return nil
+		}
+		lme := errors.NewLazyMultiError(len(muts))
+		for i, m := range muts {
+			err := putErr
+			if m.op == MutationDelete {
+				err = delErr
+			}
+			if err != nil {
+				lme.Assign(i, err.(errors.MultiError)[argIdx[i]])
+			}
+		}
+		err := lme.Get()
+		if err != nil && len(muts) == 1 {
+			err = errors.SingleError(err)
+		}
+		return err
+	}
+
+	kctx := GetKeyContext(c)
+	putKeys, putVals, putErr := putMMA.getKeysPMs(kctx, false)
+	delKeys, _, delErr := delMMA.getKeysPMs(kctx, false)
+	if err := mutErrors(putErr, delErr); err != nil {
+		return err
+	}
+
+	// Flatten the mutations, remembering where each came from.
+	type rawSource struct {
+		mma  *metaMultiArg
+		flat int
+	}
+	raw := make([]RawMutation, 0, len(putKeys)+len(delKeys))
+	sources := make([]rawSource, 0, cap(raw))
+	for i, m := range muts {
+		mma := putMMA
+		if m.op == MutationDelete {
+			mma = delMMA
+		}
+		elem := &mma.elems[argIdx[i]]
+		for j := 0; j < elem.length(); j++ {
+			flat := elem.offset + j
+			rm := RawMutation{Op: m.op}
+			if m.op == MutationDelete {
+				rm.Key = delKeys[flat]
+			} else {
+				rm.Key, rm.Value = putKeys[flat], putVals[flat]
+			}
+			raw = append(raw, rm)
+			sources = append(sources, rawSource{mma, flat})
+		}
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+
+	putET, delET := newErrorTracker(putMMA), newErrorTracker(delMMA)
+	var writeBack []func()
+	err = filterStop(Raw(c).Mutate(raw, func(idx int, key *Key, err error) error {
+		src := sources[idx]
+		index := src.mma.index(src.flat)
+		if err != nil {
+			if src.mma == putMMA {
+				putET.trackError(index, err)
+			} else {
+				delET.trackError(index, err)
+			}
+			return nil
+		}
+
+		if raw[idx].Op != MutationDelete {
+			writeBack = append(writeBack, func() {
+				mat, v := src.mma.get(index)
+				if !key.Equal(raw[idx].Key) {
+					mat.setKey(v, key)
+				}
+				mat.setVersion(v, raw[idx].Value)
+			})
+		}
+		return nil
+	}))
+	if err != nil {
+		return err
+	}
+	if err := mutErrors(putET.error(), delET.error()); err != nil {
+		return err
+	}
+
+	// Nothing is applied if any mutation fails, so only write back allocated
+	// keys and versions once we know they're real.
+	for _, f := range writeBack {
+		f()
+	}
+	return nil
+}
+
+// Insert is like Put, but fails with ErrEntityExists for each entity in src
+// which already exists. src may be any of the types accepted by Put.
+//
+// Insert is all-or-nothing: if any entity fails, none are written. If src has
+// an entity with an incomplete key, it's always inserted with a newly
+// allocated key.
+func Insert(c context.Context, src ...interface{}) error {
+	return mutateAll(c, MutationInsert, src)
+}
+
+// Update is like Put, but fails with ErrNoSuchEntity for each entity in src
+// which doesn't already exist. src may be any of the types accepted by Put,
+// but its keys must be complete.
+//
+// Update is all-or-nothing: if any entity fails, none are written.
+func Update(c context.Context, src ...interface{}) error {
+	return mutateAll(c, MutationUpdate, src)
+}
+
+// mutateAll calls Mutate with an op mutation for each of args.
+func mutateAll(c context.Context, op MutationOp, args []interface{}) error {
+	muts := make([]*Mutation, len(args))
+	for i, arg := range args {
+		muts[i] = &Mutation{op, arg}
+	}
+	return Mutate(c, muts...)
+}
+
+// ApplyMutations implements RawInterface.Mutate in terms of rds's GetMulti,
+// PutMulti and DeleteMulti, checking the preconditions of MutationInsert and
+// MutationUpdate mutations with a GetMulti before writing anything.
+//
+// It's intended for implementations and filters which have no native
+// equivalent of Mutate. The mutations are only applied atomically if rds is
+// in a transaction.
+func ApplyMutations(rds RawInterface, muts []RawMutation, cb NewKeyCB) error {
+	// Look up the existence of everything which needs to be checked.
+	var checkKeys []*Key
+	for _, m := range muts {
+		if (m.Op == MutationInsert || m.Op == MutationUpdate) && !m.Key.IsIncomplete() {
+			checkKeys = append(checkKeys, m.Key)
+		}
+	}
+	exists := make(map[string]bool, len(checkKeys))
+	if len(checkKeys) > 0 {
+		err := rds.GetMulti(checkKeys, nil, func(idx int, _ PropertyMap, err error) error {
+			switch err {
+			case nil:
+				exists[checkKeys[idx].Encode()] = true
+			case ErrNoSuchEntity:
+				exists[checkKeys[idx].Encode()] = false
+			default:
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Check each mutation against the state left by the ones before it.
+	errs := errors.NewLazyMultiError(len(muts))
+	for i, m := range muts {
+		if m.Key.IsIncomplete() {
+			continue
+		}
+		enc := m.Key.Encode()
+		switch {
+		case m.Op == MutationInsert && exists[enc]:
+			errs.Assign(i, ErrEntityExists)
+		case m.Op == MutationUpdate && !exists[enc]:
+			errs.Assign(i, ErrNoSuchEntity)
+		}
+		exists[enc] = m.Op != MutationDelete
+	}
+	if err := errs.Get(); err != nil {
+		for i, err := range err.(errors.MultiError) {
+			if err := cb(i, muts[i].Key, err); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Apply each run of puts or deletes with a single call.
+	for start := 0; start < len(muts); {
+		offset, isDel := start, muts[start].Op == MutationDelete
+		end := start + 1
+		for end < len(muts) && (muts[end].Op == MutationDelete) == isDel {
+			end++
+		}
+		run := muts[start:end]
+		start = end
+
+		keys := make([]*Key, len(run))
+		for i, m := range run {
+			keys[i] = m.Key
+		}
+		var err error
+		if isDel {
+			err = rds.DeleteMulti(keys, func(idx int, err error) error {
+				return cb(offset+idx, keys[idx], err)
+			})
+		} else {
+			vals := make([]PropertyMap, len(run))
+			for i, m := range run {
+				vals[i] = m.Value
+			}
+			err = rds.PutMulti(keys, vals, func(idx int, key *Key, err error) error {
+				return cb(offset+idx, key, err)
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ordered provides stable, ordered encodings of datastore keys and
+// properties, and stable encodings of whole PropertyMaps.
+//
+// The encoding of a key or property sorts (with bytes.Compare) the same way
+// as the datastore sorts the values themselves, and encodings may be
+// concatenated, so they can be used to build the rows of an application-level
+// secondary index, or composite cursor tokens:
+//
+//	enc := ordered.NewEncoder()
+//	enc.Property(ds.MkProperty(post.Score), true) // descending
+//	enc.Key(ds.KeyForObj(c, post), false)
+//	row := enc.Bytes()
+//
+//	dec := ordered.NewDecoder(row)
+//	score, err := dec.Property(true)
+//	...
+//	key, err := dec.Key(false)
+//
+// Every key is encoded with its app ID, namespace and database, so decoding
+// doesn't need a KeyContext. Keys in the default database sort before keys in
+// any other database; otherwise keys sort in datastore order among the keys of
+// the same database.
+//
+// These encodings are stable: data encoded by this package will continue to
+// decode, and to sort the same way, in future versions of it.
+package ordered
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/datastore/serialize"
+)
+
+// Encoder builds an ordered encoding out of a sequence of keys and
+// properties. Each value may be encoded in ascending or descending order.
+//
+// The zero value is not usable; use NewEncoder.
+type Encoder struct {
+	buf serialize.InvertibleBuffer
+}
+
+// NewEncoder returns a new empty Encoder.
+func NewEncoder() *Encoder {
+	return &Encoder{serialize.Invertible(&bytes.Buffer{})}
+}
+
+// Key appends the encoding of k.
+//
+// If descending is true, the encoding sorts in the reverse order of the key.
+func (e *Encoder) Key(k *ds.Key, descending bool) error {
+	e.buf.SetInvert(descending)
+	defer e.buf.SetInvert(false)
+	return serialize.WriteKey(e.buf, serialize.WithContext, k)
+}
+
+// Property appends the encoding of p's value.
+//
+// The encoding sorts in the order of the values in the datastore, which is
+// only defined for the indexed form of values, so p is always encoded as if it
+// were indexed. In particular, time values decode as PTInt, and PTBytes and
+// PTBlobKey values decode as PTString (see Property.IndexTypeAndValue), and
+// they may be converted back with Property.Project.
+//
+// If descending is true, the encoding sorts in the reverse order of the value.
+func (e *Encoder) Property(p ds.Property, descending bool) error {
+	var ip ds.Property
+	if err := ip.SetValue(p.Value(), ds.ShouldIndex); err != nil {
+		return err
+	}
+	e.buf.SetInvert(descending)
+	defer e.buf.SetInvert(false)
+	return serialize.WriteIndexProperty(e.buf, serialize.WithContext, ip)
+}
+
+// Bytes returns the encoding so far.
+func (e *Encoder) Bytes() []byte {
+	return e.buf.Bytes()
+}
+
+// Decoder reads the keys and properties written by an Encoder, in the order
+// in which they were written and with the same descending flags.
+//
+// The zero value is not usable; use NewDecoder.
+type Decoder struct {
+	buf serialize.InvertibleBuffer
+}
+
+// NewDecoder returns a Decoder which reads data.
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{serialize.Invertible(bytes.NewBuffer(data))}
+}
+
+// Key reads a key.
+func (d *Decoder) Key(descending bool) (*ds.Key, error) {
+	d.buf.SetInvert(descending)
+	defer d.buf.SetInvert(false)
+	return serialize.ReadKey(d.buf, serialize.WithContext, ds.KeyContext{})
+}
+
+// Property reads a property, which is always indexed.
+func (d *Decoder) Property(descending bool) (ds.Property, error) {
+	d.buf.SetInvert(descending)
+	defer d.buf.SetInvert(false)
+	return serialize.ReadProperty(d.buf, serialize.WithContext, ds.KeyContext{})
+}
+
+// Len returns the number of bytes which haven't been read yet.
+func (d *Decoder) Len() int {
+	return d.buf.Len()
+}
+
+// EncodeKey returns the ascending encoding of k.
+func EncodeKey(k *ds.Key) []byte {
+	e := NewEncoder()
+	if err := e.Key(k, false); err != nil {
+		panic(err) // writes to a bytes.Buffer can't fail
+	}
+	return e.Bytes()
+}
+
+// DecodeKey decodes a key encoded with EncodeKey.
+func DecodeKey(data []byte) (*ds.Key, error) {
+	d := NewDecoder(data)
+	k, err := d.Key(false)
+	if err == nil && d.Len() > 0 {
+		err = fmt.Errorf("ordered: %d trailing bytes after key", d.Len())
+	}
+	return k, err
+}
+
+// EncodePropertyMap returns a stable encoding of pm. Meta properties (like
+// "$key") are not encoded. Equal PropertyMaps have equal encodings, but the
+// encodings of different PropertyMaps are not ordered in any meaningful way.
+func EncodePropertyMap(pm ds.PropertyMap) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := serialize.WriteSortedPropertyMap(buf, serialize.WithContext, pm); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodePropertyMap decodes a PropertyMap encoded with EncodePropertyMap.
+func DecodePropertyMap(data []byte) (ds.PropertyMap, error) {
+	buf := bytes.NewBuffer(data)
+	pm, err := serialize.ReadPropertyMap(buf, serialize.WithContext, ds.KeyContext{})
+	if err == nil && buf.Len() > 0 {
+		err = fmt.Errorf("ordered: %d trailing bytes after property map", buf.Len())
+	}
+	return pm, err
+}
+
+// HashPropertyMap returns the SHA-256 hash of the EncodePropertyMap encoding
+// of pm. It can be used to fingerprint entities, e.g. to detect changes.
+func HashPropertyMap(pm ds.PropertyMap) ([]byte, error) {
+	data, err := EncodePropertyMap(pm)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(data)
+	return h[:], nil
+}
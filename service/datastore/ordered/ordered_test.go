@@ -0,0 +1,160 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ordered
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestOrdered(t *testing.T) {
+	t.Parallel()
+
+	Convey("ordered", t, func() {
+		kc := ds.MkKeyContext("app", "ns")
+
+		// In datastore order.
+		props := []ds.Property{
+			ds.MkProperty(nil),
+			ds.MkProperty(-10),
+			ds.MkProperty(1),
+			ds.MkProperty(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)),
+			ds.MkProperty(false),
+			ds.MkProperty(true),
+			ds.MkProperty(""),
+			ds.MkProperty("a"),
+			ds.MkProperty([]byte("b")),
+			ds.MkProperty(-1.5),
+			ds.MkProperty(2.5),
+			ds.MkProperty(ds.GeoPoint{Lat: 1, Lng: 2}),
+			ds.MkProperty(kc.MakeKey("A", 1)),
+			ds.MkProperty(kc.MakeKey("A", 1, "B", "x")),
+			ds.MkProperty(kc.MakeKey("A", 2)),
+		}
+
+		encode := func(p ds.Property, desc bool) []byte {
+			e := NewEncoder()
+			So(e.Property(p, desc), ShouldBeNil)
+			return e.Bytes()
+		}
+
+		Convey("properties sort in datastore order", func() {
+			for i := 1; i < len(props); i++ {
+				a, b := encode(props[i-1], false), encode(props[i], false)
+				So(bytes.Compare(a, b), ShouldBeLessThan, 0)
+
+				a, b = encode(props[i-1], true), encode(props[i], true)
+				So(bytes.Compare(a, b), ShouldBeGreaterThan, 0)
+			}
+		})
+
+		Convey("properties round trip in index form", func() {
+			for _, desc := range []bool{false, true} {
+				for _, p := range props {
+					got, err := NewDecoder(encode(p, desc)).Property(desc)
+					So(err, ShouldBeNil)
+					So(got.Equal(&p), ShouldBeTrue)
+				}
+			}
+
+			got, err := NewDecoder(encode(ds.MkPropertyNI([]byte("b")), false)).Property(false)
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, ds.MkProperty("b"))
+		})
+
+		Convey("tuples", func() {
+			k1, k2 := kc.MakeKey("Post", 1), kc.MakeKey("Post", 2)
+			row := func(score int, k *ds.Key) []byte {
+				e := NewEncoder()
+				So(e.Property(ds.MkProperty(score), true), ShouldBeNil)
+				So(e.Key(k, false), ShouldBeNil)
+				return e.Bytes()
+			}
+
+			// Higher scores first, then ascending keys.
+			So(bytes.Compare(row(10, k2), row(5, k1)), ShouldBeLessThan, 0)
+			So(bytes.Compare(row(5, k1), row(5, k2)), ShouldBeLessThan, 0)
+
+			d := NewDecoder(row(10, k2))
+			p, err := d.Property(true)
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, ds.MkProperty(10))
+			k, err := d.Key(false)
+			So(err, ShouldBeNil)
+			So(k, ShouldResemble, k2)
+			So(d.Len(), ShouldEqual, 0)
+		})
+
+		Convey("keys", func() {
+			k := kc.MakeKey("A", "x", "B", 2)
+			So(bytes.Compare(EncodeKey(kc.MakeKey("A", "x")), EncodeKey(k)), ShouldBeLessThan, 0)
+
+			got, err := DecodeKey(EncodeKey(k))
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, k)
+
+			_, err = DecodeKey(append(EncodeKey(k), 0))
+			So(err, ShouldErrLike, "1 trailing bytes")
+		})
+
+		Convey("keys in other databases", func() {
+			dbkc := kc
+			dbkc.Database = "db"
+			k := dbkc.MakeKey("A", "x", "B", 2)
+			So(EncodeKey(k), ShouldNotResemble, EncodeKey(kc.MakeKey("A", "x", "B", 2)))
+
+			got, err := DecodeKey(EncodeKey(k))
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, k)
+			So(got.Database(), ShouldEqual, "db")
+		})
+
+		Convey("property maps", func() {
+			pm := ds.PropertyMap{
+				"$key":  ds.MkPropertyNI(kc.MakeKey("A", 1)),
+				"Name":  ds.MkProperty("x"),
+				"Multi": ds.PropertySlice{ds.MkProperty(1), ds.MkPropertyNI(2)},
+				"When":  ds.MkProperty(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)),
+			}
+			data, err := EncodePropertyMap(pm)
+			So(err, ShouldBeNil)
+
+			for i := 0; i < 10; i++ {
+				again, err := EncodePropertyMap(pm)
+				So(err, ShouldBeNil)
+				So(again, ShouldResemble, data)
+			}
+
+			got, err := DecodePropertyMap(data)
+			So(err, ShouldBeNil)
+			delete(pm, "$key")
+			So(got, ShouldResemble, pm)
+
+			h, err := HashPropertyMap(pm)
+			So(err, ShouldBeNil)
+			So(len(h), ShouldEqual, 32)
+			pm["Name"] = ds.MkProperty("y")
+			h2, err := HashPropertyMap(pm)
+			So(err, ShouldBeNil)
+			So(h2, ShouldNotResemble, h)
+		})
+	})
+}
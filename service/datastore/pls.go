@@ -65,6 +65,22 @@ import (
 //      field's actual name. Note that by default, all fields (with indexable
 //      types) are indexed.
 //
+//   `gae:"fieldName[,noindex],ttl"` -- marks a top-level time.Time field as the
+//      expiration time of the entity. The TTL policy of the struct's kind is
+//      registered (see RegisterTTLPolicy) once, when the struct is first used,
+//      if the kind is either the struct's name or a '$kind' default. Structs
+//      with a dynamic '$kind' must call RegisterTTLPolicy themselves. At most
+//      one field may be tagged as 'ttl'. Note that the zero
+//      time.Time is in the past, so an entity with a zero 'ttl' field expires
+//      right away.
+//
+//   `gae:"fieldName[,noindex],created"` and `gae:"fieldName[,noindex],updated"`
+//      -- mark top-level time.Time fields which Put and Mutate set from the
+//      clock in the context (see go.chromium.org/luci/common/clock). The
+//      'updated' field is set on every write, and the 'created' field only if
+//      it's zero. The fields are set before the write is attempted. At most
+//      one field may be tagged with each.
+//
 //   `gae:"$metaKey[,<value>]` -- indicates a field is metadata. Metadata
 //      can be used to control filter behavior, or to store key data when using
 //      the Interface.KeyForObj* methods. The supported field types are:
@@ -22,6 +22,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 
 	"go.chromium.org/luci/common/errors"
@@ -258,6 +259,22 @@ func (p *structPLS) Save(withMeta bool) (PropertyMap, error) {
 	return ret, nil
 }
 
+// setAutoNow sets the field tagged as 'updated' to now, and the field tagged
+// as 'created' to now if it's zero. It does nothing if p isn't settable.
+func (p *structPLS) setAutoNow(now time.Time) {
+	if !p.o.CanSet() {
+		return
+	}
+	if i, ok := p.c.bySpecial["created"]; ok {
+		if f := p.o.Field(i); f.Interface().(time.Time).IsZero() {
+			f.Set(reflect.ValueOf(now))
+		}
+	}
+	if i, ok := p.c.bySpecial["updated"]; ok {
+		p.o.Field(i).Set(reflect.ValueOf(now))
+	}
+}
+
 func (p *structPLS) getDefaultKind() string {
 	if !p.o.IsValid() {
 		return ""
@@ -665,13 +682,49 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 			c.byName[name] = i
 		}
 		st.name = name
-		if opts == "noindex" {
-			st.idxSetting = NoIndex
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "noindex":
+				st.idxSetting = NoIndex
+			case "ttl":
+				if ft != typeOfTime {
+					c.problem = me("field %q tagged as 'ttl' has type %s, expecting time.Time", f.Name, ft)
+					return
+				}
+				if _, ok := c.bySpecial["ttl"]; ok {
+					c.problem = me("struct has multiple fields tagged as 'ttl'")
+					return
+				}
+				c.bySpecial["ttl"] = i
+			case "created", "updated":
+				if ft != typeOfTime {
+					c.problem = me("field %q tagged as '%s' has type %s, expecting time.Time", f.Name, opt, ft)
+					return
+				}
+				if _, ok := c.bySpecial[opt]; ok {
+					c.problem = me("struct has multiple fields tagged as '%s'", opt)
+					return
+				}
+				c.bySpecial[opt] = i
+			}
 		}
 	}
 	if c.problem == errRecursiveStruct {
 		c.problem = nil
 	}
+	if i, ok := c.bySpecial["ttl"]; ok {
+		// The kind is known statically unless it's a '$kind' field without a
+		// default, in which case the policy must be registered explicitly.
+		kind := t.Name()
+		if idx, ok := c.byMeta["kind"]; ok {
+			kind, _ = c.byIndex[idx].metaVal.(string)
+		}
+		if kind != "" {
+			if err := registerTTLPolicy(kind, c.byIndex[i].name); err != nil {
+				c.problem = err
+			}
+		}
+	}
 	return
 }
 
@@ -0,0 +1,170 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"sync"
+
+	"go.chromium.org/luci/common/errors"
+	"go.chromium.org/luci/common/sync/parallel"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultProcessAllBatchSize is the default ProcessAllOptions.BatchSize.
+const DefaultProcessAllBatchSize = 100
+
+// DefaultProcessAllWorkers is the default ProcessAllOptions.Workers.
+const DefaultProcessAllWorkers = 8
+
+// ProcessCB is the callback used by ProcessAll. It's called with each entity
+// matched by the query, from several goroutines at once.
+//
+// Returning an error stops ProcessAll, unless
+// ProcessAllOptions.ContinueOnError is set.
+type ProcessCB func(c context.Context, pm PropertyMap) error
+
+// ProcessAllOptions are options for ProcessAll.
+type ProcessAllOptions struct {
+	// BatchSize is the number of entities fetched by each GetMulti call, and
+	// handed to a worker at once. If zero, DefaultProcessAllBatchSize is used.
+	BatchSize int
+
+	// Workers is the maximum number of batches processed at once. If zero,
+	// DefaultProcessAllWorkers is used.
+	Workers int
+
+	// KeysOnly, if true, skips fetching the entities: the callback is called
+	// with PropertyMaps holding only their keys.
+	KeysOnly bool
+
+	// ContinueOnError, if true, makes ProcessAll process all of the entities
+	// even if some of them fail, and return all of their errors.
+	ContinueOnError bool
+}
+
+// ProcessAll runs q keys-only, fetches its results in batches, and passes them
+// to cb concurrently. It returns the number of entities processed
+// successfully. See ProcessAll.
+func (o *ProcessAllOptions) ProcessAll(c context.Context, q *Query, cb ProcessCB) (int, error) {
+	batchSize := o.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultProcessAllBatchSize
+	}
+	workers := o.Workers
+	if workers <= 0 {
+		workers = DefaultProcessAllWorkers
+	}
+
+	// Canceling the work context stops the query and the workers after the
+	// first error.
+	wc, cancel := context.WithCancel(c)
+	defer cancel()
+
+	var (
+		lock      sync.Mutex
+		processed int
+		errs      errors.MultiError
+	)
+	fail := func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		errs = append(errs, err)
+		if !o.ContinueOnError {
+			cancel()
+		}
+	}
+
+	processBatch := func(keys []*Key) error {
+		pms := make([]PropertyMap, len(keys))
+		for i, k := range keys {
+			pms[i] = PropertyMap{}
+			PopulateKey(pms[i], k)
+		}
+
+		var getErrs errors.MultiError
+		if !o.KeysOnly {
+			switch err := Get(wc, pms).(type) {
+			case nil:
+			case errors.MultiError:
+				getErrs = err
+			default:
+				if wc.Err() == nil {
+					fail(errors.Annotate(err, "failed to get %d entities", len(keys)).Err())
+				}
+				return nil
+			}
+		}
+
+		for i, pm := range pms {
+			if wc.Err() != nil {
+				return nil
+			}
+			if getErrs != nil && getErrs[i] != nil {
+				if getErrs[i] != ErrNoSuchEntity { // otherwise deleted since the query ran
+					fail(errors.Annotate(getErrs[i], "failed to get %s", keys[i]).Err())
+				}
+				continue
+			}
+			if err := cb(wc, pm); err != nil {
+				fail(errors.Annotate(err, "failed to process %s", keys[i]).Err())
+				continue
+			}
+			lock.Lock()
+			processed++
+			lock.Unlock()
+		}
+		return nil
+	}
+
+	var queryErr error
+	parallel.WorkPool(workers, func(workC chan<- func() error) {
+		batch := make([]*Key, 0, batchSize)
+		queryErr = Run(wc, q.KeysOnly(true), func(k *Key) error {
+			if batch = append(batch, k); len(batch) == batchSize {
+				keys := batch
+				workC <- func() error { return processBatch(keys) }
+				batch = make([]*Key, 0, batchSize)
+			}
+			return wc.Err()
+		})
+		if len(batch) > 0 && queryErr == nil {
+			workC <- func() error { return processBatch(batch) }
+		}
+	})
+
+	switch {
+	case len(errs) > 0:
+		return processed, errs
+	case c.Err() != nil:
+		return processed, c.Err()
+	}
+	return processed, queryErr
+}
+
+// ProcessAll runs q keys-only, fetches its results in batches with GetMulti,
+// and passes them to cb concurrently, with the default ProcessAllOptions. It
+// returns the number of entities processed successfully.
+//
+// Unlike Run, whose callback processes one entity at a time, ProcessAll keeps
+// several GetMulti calls and callbacks in flight, which suits scan-and-process
+// jobs. Entities deleted between the query and their GetMulti are skipped.
+//
+// The first error returned by cb stops ProcessAll: the query and the other
+// callbacks are canceled through their Context, and ProcessAll returns an
+// errors.MultiError with the errors of all of the entities which failed.
+func ProcessAll(c context.Context, q *Query, cb ProcessCB) (int, error) {
+	return (&ProcessAllOptions{}).ProcessAll(c, q, cb)
+}
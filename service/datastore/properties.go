@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"go.chromium.org/gae/service/blobstore"
@@ -966,6 +968,62 @@ func (pm PropertyMap) EstimateSize() int64 {
 	return ret
 }
 
+// Names returns the names of the properties in pm, including meta properties,
+// in sorted order. Visiting pm in this order gives a canonical order of its
+// properties.
+func (pm PropertyMap) Names() []string {
+	ret := make([]string, 0, len(pm))
+	for name := range pm {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// String returns a canonical, human-readable form of pm, with its properties
+// in sorted order, e.g.:
+//
+//	{$key: PTKey(app:ns:/Foo,1), Tags: [PTString("a"), PTString("b")], Val: PTInt(1) noindex}
+//
+// Equal PropertyMaps have equal strings, so it's suitable for diffs and golden
+// tests. A Property and a PropertySlice holding just that Property have
+// different strings.
+func (pm PropertyMap) String() string {
+	buf := strings.Builder{}
+	buf.WriteByte('{')
+	for i, name := range pm.Names() {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(name)
+		buf.WriteString(": ")
+		switch t := pm[name].(type) {
+		case Property:
+			writePropertyString(&buf, t)
+		case PropertySlice:
+			buf.WriteByte('[')
+			for j, p := range t {
+				if j > 0 {
+					buf.WriteString(", ")
+				}
+				writePropertyString(&buf, p)
+			}
+			buf.WriteByte(']')
+		default:
+			fmt.Fprintf(&buf, "%v", t)
+		}
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+func writePropertyString(buf *strings.Builder, p Property) {
+	buf.WriteString(p.String())
+	if p.IndexSetting() == NoIndex {
+		buf.WriteString(" noindex")
+	}
+}
+
 func isMetaKey(k string) bool {
 	// empty counts as a metakey since it's not a valid data key, but it's
 	// not really a valid metakey either.
@@ -250,6 +250,19 @@ func TestDSPropertyMapImpl(t *testing.T) {
 			})
 		})
 	})
+
+	Convey("PropertyMap canonical form", t, func() {
+		pm := PropertyMap{
+			"Val":  MkPropertyNI(1),
+			"$id":  MkProperty("x"),
+			"Tags": PropertySlice{MkProperty("b"), MkProperty([]byte{1})},
+			"Bool": MkProperty(true),
+		}
+		So(pm.Names(), ShouldResemble, []string{"$id", "Bool", "Tags", "Val"})
+		So(pm.String(), ShouldEqual,
+			`{$id: PTString("x"), Bool: PTBool(true), Tags: [PTString("b"), PTBytes(0x01)], Val: PTInt(1) noindex}`)
+		So(PropertyMap{}.String(), ShouldEqual, "{}")
+	})
 }
 
 func TestByteSequences(t *testing.T) {
@@ -229,7 +229,7 @@ func (q *Query) Project(fieldNames ...string) *Query {
 			if q.reserved(f) {
 				return
 			}
-			if f == "__key__" {
+			if f == "__key__" || f == "__scatter__" {
 				q.err = fmt.Errorf("cannot project on %q", f)
 				return
 			}
@@ -315,7 +315,10 @@ func (q *Query) Eq(field string, values ...interface{}) *Query {
 }
 
 func (q *Query) reserved(field string) bool {
-	if field == "__key__" {
+	// __scatter__ is a hidden property which the datastore sets on a random
+	// subset of entities. It may be used to filter and order, which is useful
+	// for splitting a kind into key ranges, but it can't be projected.
+	if field == "__key__" || field == "__scatter__" {
 		return false
 	}
 	if field == "" {
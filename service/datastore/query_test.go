@@ -149,6 +149,16 @@ var queryTests = []queryTest{
 		"",
 		errString("cannot project on \"__key__\""), nil},
 
+	{"projecting __scatter__",
+		nq().Project("hello", "__scatter__"),
+		"",
+		errString("cannot project on \"__scatter__\""), nil},
+
+	{"ordering by __scatter__",
+		nq().KeysOnly(true).Order("__scatter__"),
+		"SELECT __key__ FROM `Foo` ORDER BY `__scatter__`, `__key__`",
+		nil, nil},
+
 	{"getting all the keys",
 		nq("").KeysOnly(true),
 		"SELECT __key__ ORDER BY `__key__`",
@@ -300,6 +310,11 @@ var queryTests = []queryTest{
 		errString("cannot filter/project on reserved property: \"__special__\""),
 		nil},
 
+	{"Filtering on __scatter__ is OK",
+		nq().Gte("__scatter__", []byte("\x80")).Order("__scatter__"),
+		"SELECT * FROM `Foo` WHERE `__scatter__` >= BLOB(\"gA==\") ORDER BY `__scatter__`, `__key__`",
+		nil, nil},
+
 	{"in-bound key filters with ancestor OK",
 		nq().Ancestor(mkKey("Hello", 10)).Lte("__key__", mkKey("Hello", 10, "Something", "hi")),
 		("SELECT * FROM `Foo` " +
@@ -25,6 +25,15 @@ type Cursor interface {
 	fmt.Stringer
 }
 
+// VersionMeta is the name of the metadata in which implementations which track
+// entity versions report them. An entity's version is an int64 which increases
+// every time the entity is written, allowing cheap change detection.
+//
+// Get and Put set it on objects which have a `gae:"$version"` field, or which
+// are PropertyMaps with a "$version" entry. Implementations which don't track
+// versions, and filters which cache entities, leave it unset.
+const VersionMeta = "version"
+
 // CursorCB is used to obtain a Cursor while Run'ing a query on either
 // Interface or RawInterface.
 //
@@ -105,6 +114,30 @@ type Constraints struct {
 	// MaxDeleteSize is the maximum number of entities that can be referenced in a
 	// single DeleteMulti call. If <= 0, no constraint is applied.
 	MaxDeleteSize int
+
+	// MaxEntityGroupsPerTransaction is the maximum number of entity groups that
+	// a cross-group transaction can operate on. If <= 0, no constraint is
+	// applied. It's unused if NoCrossGroupTransactions is true.
+	MaxEntityGroupsPerTransaction int
+	// NoCrossGroupTransactions is true if transactions can only operate on a
+	// single entity group, i.e. TransactionOptions.XG is not supported.
+	NoCrossGroupTransactions bool
+	// NoReserveIDRange is true if ReserveIDRange is not supported.
+	NoReserveIDRange bool
+	// NoReadTime is true if reading the datastore as of a past time (see
+	// WithReadTime and TransactionOptions.ReadTime) is not supported.
+	NoReadTime bool
+
+	// NoInQueries is true if a query can't filter a property by a set of
+	// values (an IN filter), so one query has to be run per value. Query has no
+	// such filter, so this is true for all of the implementations in this
+	// module.
+	NoInQueries bool
+	// NoDistinctCursors is true if a query can't be resumed from the cursor of a
+	// distinct projection query (see Query.Distinct) without returning some of
+	// the projections again. Cursors of other projection queries are always
+	// supported.
+	NoDistinctCursors bool
 }
 
 type nullMetaGetterType struct{}
@@ -158,6 +191,14 @@ type RawInterface interface {
 	// containing integer IDs assigned to them.
 	AllocateIDs(keys []*Key, cb NewKeyCB) error
 
+	// ReserveIDRange reserves the integer IDs in the inclusive range
+	// [start, end] for entities with the same kind, parent and namespace as
+	// the supplied incomplete key, so that they will never be assigned by
+	// AllocateIDs or by PutMulti with an incomplete key.
+	//
+	// It is not an error for entities with IDs in the range to already exist.
+	ReserveIDRange(key *Key, start, end int64) error
+
 	// RunInTransaction runs f in a transaction.
 	//
 	// opts may be nil.
@@ -182,6 +223,17 @@ type RawInterface interface {
 	// match it.
 	Count(q *FinalizedQuery) (int64, error)
 
+	// Aggregate computes aggs over the results of the given query. See
+	// Aggregation for the types of the results.
+	//
+	// Implementations whose backend doesn't support aggregation queries can use
+	// AggregateByScan.
+	//
+	// NOTE: Implementations and filters are guaranteed that:
+	//   - q is not nil
+	//   - aggs is not empty and valid (see AggregationQuery.Validate)
+	Aggregate(q *FinalizedQuery, aggs []*Aggregation) (AggregationResult, error)
+
 	// GetMulti retrieves items from the datastore.
 	//
 	// If there was a server error, it will be returned directly. Otherwise,
@@ -192,6 +244,10 @@ type RawInterface interface {
 	//
 	// meta is used to propagate metadata from higher levels.
 	//
+	// Implementations which track entity versions report them as the "$version"
+	// metadata (an int64) of each returned PropertyMap whose meta has a
+	// "version" entry. See VersionMeta.
+	//
 	// NOTE: Implementations and filters are guaranteed that:
 	//   - len(keys) > 0
 	//   - all keys are Valid, !Incomplete, and in the current namespace
@@ -206,6 +262,10 @@ type RawInterface interface {
 	// receives an error, it will immediately forward that error and stop
 	// subsequent callbacks.
 	//
+	// Implementations which track entity versions may set the "$version"
+	// metadata of each written entry of vals to its new version before calling
+	// cb. See VersionMeta.
+	//
 	// NOTE: Implementations and filters are guaranteed that:
 	//   - len(keys) > 0
 	//   - len(keys) == len(vals)
@@ -228,6 +288,26 @@ type RawInterface interface {
 	//   - cb is not nil
 	DeleteMulti(keys []*Key, cb DeleteMultiCB) error
 
+	// Mutate applies muts to the datastore in a single commit: either all of
+	// them are applied, or none are.
+	//
+	// If there was a server error, it will be returned directly. Otherwise,
+	// callback will execute once per mutation, in order, with the mutation's
+	// (possibly newly allocated) key and its individual error, if any. If any
+	// mutation has an error, none of them were applied. If the callback
+	// receives an error, it will immediately forward that error and stop
+	// subsequent callbacks.
+	//
+	// NOTE: Implementations and filters are guaranteed that:
+	//   - len(muts) > 0
+	//   - all keys are PartialValid and in the current namespace
+	//   - the keys of MutationUpdate and MutationDelete mutations are Valid
+	//     and !Incomplete
+	//   - none of the keys are 'special' (use a kind prefixed with '__')
+	//   - Value is not nil, except for MutationDelete mutations
+	//   - cb is not nil
+	Mutate(muts []RawMutation, cb NewKeyCB) error
+
 	// WithoutTransaction returns a derived Context without a transaction applied.
 	// This may be called even when outside of a transaction, in which case the
 	// input Context is a valid return value.
@@ -244,3 +324,39 @@ type RawInterface interface {
 	// if there is none.
 	GetTestable() Testable
 }
+
+// NewErrorRaw returns a RawInterface for c whose operations all fail with err.
+//
+// It's meant for RawFactory implementations which can't serve c, e.g. because
+// it targets an app or a database that the backend can't reach, so that the
+// error surfaces from the operations instead of a panic.
+func NewErrorRaw(c context.Context, err error) RawInterface {
+	return &errorRaw{c, err}
+}
+
+type errorRaw struct {
+	c   context.Context
+	err error
+}
+
+func (e *errorRaw) AllocateIDs([]*Key, NewKeyCB) error                 { return e.err }
+func (e *errorRaw) ReserveIDRange(*Key, int64, int64) error            { return e.err }
+func (e *errorRaw) DecodeCursor(string) (Cursor, error)                { return nil, e.err }
+func (e *errorRaw) Run(*FinalizedQuery, RawRunCB) error                { return e.err }
+func (e *errorRaw) Count(*FinalizedQuery) (int64, error)               { return 0, e.err }
+func (e *errorRaw) GetMulti([]*Key, MultiMetaGetter, GetMultiCB) error { return e.err }
+func (e *errorRaw) PutMulti([]*Key, []PropertyMap, NewKeyCB) error     { return e.err }
+func (e *errorRaw) DeleteMulti([]*Key, DeleteMultiCB) error            { return e.err }
+func (e *errorRaw) Mutate([]RawMutation, NewKeyCB) error               { return e.err }
+func (e *errorRaw) WithoutTransaction() context.Context                { return e.c }
+func (e *errorRaw) CurrentTransaction() Transaction                    { return nil }
+func (e *errorRaw) Constraints() Constraints                           { return Constraints{} }
+func (e *errorRaw) GetTestable() Testable                              { return nil }
+
+func (e *errorRaw) RunInTransaction(func(context.Context) error, *TransactionOptions) error {
+	return e.err
+}
+
+func (e *errorRaw) Aggregate(*FinalizedQuery, []*Aggregation) (AggregationResult, error) {
+	return nil, e.err
+}
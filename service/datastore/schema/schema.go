@@ -0,0 +1,221 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schema infers the schema of a datastore kind by sampling its
+// entities.
+//
+// The datastore is schemaless, so the entities of a kind can drift from the Go
+// structs used to read and write them: properties are renamed or dropped,
+// change type, or stop being indexed. Infer reports the properties which are
+// actually stored, which can be compared against the expected struct, e.g.:
+//
+//	s, err := schema.Infer(c, "Post", nil)
+//	if err != nil {
+//	  return err
+//	}
+//	s.Write(os.Stdout)
+package schema
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	ds "go.chromium.org/gae/service/datastore"
+
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultSample is the default Options.Sample.
+const DefaultSample = 100
+
+// Options are the options for Infer.
+type Options struct {
+	// Sample is the maximum number of entities to examine. If zero,
+	// DefaultSample is used.
+	Sample int
+}
+
+// Schema is the inferred schema of a kind.
+type Schema struct {
+	// Kind is the kind that was sampled.
+	Kind string
+	// Sampled is the number of entities which were examined.
+	Sampled int
+	// Properties are the properties seen in the sampled entities, ordered by
+	// name.
+	Properties []*Property
+}
+
+// Property describes a property seen in the sampled entities of a kind.
+type Property struct {
+	// Name is the name of the property.
+	Name string
+	// Types are the types of the non-null values seen for the property, in
+	// PropertyType order.
+	Types []ds.PropertyType
+	// Entities is the number of sampled entities which have the property.
+	Entities int
+	// Nulls is the number of sampled entities in which the property had a null
+	// value.
+	Nulls int
+	// Indexed and Unindexed are the number of values seen for the property
+	// which were, respectively, indexed and not indexed.
+	Indexed, Unindexed int
+	// Repeated is true if any of the sampled entities had more than one value
+	// for the property.
+	Repeated bool
+	// Nullable is true if the property was missing from, or null in, any of
+	// the sampled entities.
+	Nullable bool
+}
+
+// Infer infers the schema of kind, in the namespace of c, by examining up to
+// opts.Sample of its entities. opts may be nil.
+//
+// The keys of the entities are found with a keys-only query, and the entities
+// are then fetched with Get, so that the properties are seen exactly as they
+// are stored. Entities which are deleted in between are skipped.
+func Infer(c context.Context, kind string, opts *Options) (*Schema, error) {
+	sample := DefaultSample
+	if opts != nil && opts.Sample > 0 {
+		sample = opts.Sample
+	}
+
+	var keys []*ds.Key
+	q := ds.NewQuery(kind).KeysOnly(true).Limit(int32(sample))
+	if err := ds.GetAll(c, q, &keys); err != nil {
+		return nil, errors.Annotate(err, "failed to query the keys of %q", kind).Err()
+	}
+
+	pms := make([]ds.PropertyMap, len(keys))
+	for i, k := range keys {
+		pms[i] = ds.PropertyMap{}
+		ds.PopulateKey(pms[i], k)
+	}
+	if err := ds.Get(c, pms); err != nil {
+		me, ok := err.(errors.MultiError)
+		if !ok {
+			return nil, errors.Annotate(err, "failed to get the entities").Err()
+		}
+		for i, err := range me {
+			switch err {
+			case nil:
+			case ds.ErrNoSuchEntity:
+				pms[i] = nil
+			default:
+				return nil, errors.Annotate(err, "failed to get %s", keys[i]).Err()
+			}
+		}
+	}
+
+	s := &Schema{Kind: kind}
+	props := map[string]*Property{}
+	types := map[string]map[ds.PropertyType]struct{}{}
+	for _, pm := range pms {
+		if pm == nil {
+			continue
+		}
+		s.Sampled++
+
+		for name := range pm {
+			if strings.HasPrefix(name, "$") {
+				continue
+			}
+			p := props[name]
+			if p == nil {
+				p = &Property{Name: name}
+				props[name] = p
+				types[name] = map[ds.PropertyType]struct{}{}
+			}
+			p.Entities++
+
+			vals := pm.Slice(name)
+			if len(vals) > 1 {
+				p.Repeated = true
+			}
+			null := false
+			for _, v := range vals {
+				if v.Type() == ds.PTNull {
+					null = true
+				} else {
+					types[name][v.Type()] = struct{}{}
+				}
+				if v.IndexSetting() == ds.NoIndex {
+					p.Unindexed++
+				} else {
+					p.Indexed++
+				}
+			}
+			if null {
+				p.Nulls++
+			}
+		}
+	}
+
+	s.Properties = make([]*Property, 0, len(props))
+	for name, p := range props {
+		for t := range types[name] {
+			p.Types = append(p.Types, t)
+		}
+		sort.Slice(p.Types, func(i, j int) bool { return p.Types[i] < p.Types[j] })
+		p.Nullable = p.Nulls > 0 || p.Entities < s.Sampled
+		s.Properties = append(s.Properties, p)
+	}
+	sort.Slice(s.Properties, func(i, j int) bool {
+		return s.Properties[i].Name < s.Properties[j].Name
+	})
+	return s, nil
+}
+
+// Property returns the Property named name, or nil if it wasn't seen.
+func (s *Schema) Property(name string) *Property {
+	i := sort.Search(len(s.Properties), func(i int) bool { return s.Properties[i].Name >= name })
+	if i < len(s.Properties) && s.Properties[i].Name == name {
+		return s.Properties[i]
+	}
+	return nil
+}
+
+// Write writes s to w as a human-readable table.
+func (s *Schema) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Kind %q (%d entities sampled)\n", s.Kind, s.Sampled)
+	fmt.Fprintln(tw, "PROPERTY\tTYPES\tENTITIES\tINDEXED\tNULLABLE\tREPEATED")
+	for _, p := range s.Properties {
+		types := make([]string, len(p.Types))
+		for i, t := range p.Types {
+			types[i] = t.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%t\t%t\n",
+			p.Name, strings.Join(types, ","), p.Entities, p.indexed(), p.Nullable, p.Repeated)
+	}
+	return tw.Flush()
+}
+
+// indexed returns a summary of the indexed-ness of p's values.
+func (p *Property) indexed() string {
+	switch {
+	case p.Unindexed == 0:
+		return "yes"
+	case p.Indexed == 0:
+		return "no"
+	default:
+		return "mixed"
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"bytes"
+	"testing"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInfer(t *testing.T) {
+	t.Parallel()
+
+	Convey("Infer", t, func() {
+		c := memory.Use(context.Background())
+		ds.GetTestable(c).Consistent(true)
+
+		put := func(id int64, pm ds.PropertyMap) {
+			ds.PopulateKey(pm, ds.MakeKey(c, "Foo", id))
+			So(ds.Put(c, pm), ShouldBeNil)
+		}
+		put(1, ds.PropertyMap{
+			"Name": ds.MkProperty("a"),
+			"Tags": ds.PropertySlice{ds.MkProperty("x"), ds.MkProperty("y")},
+			"Blob": ds.MkPropertyNI([]byte("data")),
+			"Ref":  ds.MkProperty(nil),
+		})
+		put(2, ds.PropertyMap{
+			"Name": ds.MkProperty(int64(2)),
+			"Tags": ds.MkProperty("z"),
+			"Blob": ds.MkProperty([]byte("indexed")),
+			"Ref":  ds.MkProperty(ds.MakeKey(c, "Bar", 1)),
+		})
+		put(3, ds.PropertyMap{
+			"Name": ds.MkProperty("c"),
+		})
+
+		Convey("reports the stored properties", func() {
+			s, err := Infer(c, "Foo", nil)
+			So(err, ShouldBeNil)
+			So(s.Kind, ShouldEqual, "Foo")
+			So(s.Sampled, ShouldEqual, 3)
+			So(s.Properties, ShouldResemble, []*Property{
+				{Name: "Blob", Types: []ds.PropertyType{ds.PTBytes}, Entities: 2,
+					Indexed: 1, Unindexed: 1, Nullable: true},
+				{Name: "Name", Types: []ds.PropertyType{ds.PTInt, ds.PTString}, Entities: 3,
+					Indexed: 3},
+				{Name: "Ref", Types: []ds.PropertyType{ds.PTKey}, Entities: 2, Nulls: 1,
+					Indexed: 2, Nullable: true},
+				{Name: "Tags", Types: []ds.PropertyType{ds.PTString}, Entities: 2,
+					Indexed: 3, Repeated: true, Nullable: true},
+			})
+			So(s.Property("Name").Entities, ShouldEqual, 3)
+			So(s.Property("Nope"), ShouldBeNil)
+
+			buf := &bytes.Buffer{}
+			So(s.Write(buf), ShouldBeNil)
+			So(buf.String(), ShouldEqual, `Kind "Foo" (3 entities sampled)
+PROPERTY  TYPES           ENTITIES  INDEXED  NULLABLE  REPEATED
+Blob      PTBytes         2         mixed    true      false
+Name      PTInt,PTString  3         yes      false     false
+Ref       PTKey           2         yes      true      false
+Tags      PTString        2         yes      true      true
+`)
+		})
+
+		Convey("samples at most Sample entities", func() {
+			s, err := Infer(c, "Foo", &Options{Sample: 1})
+			So(err, ShouldBeNil)
+			So(s.Sampled, ShouldEqual, 1)
+			So(len(s.Properties), ShouldEqual, 4)
+		})
+
+		Convey("handles kinds with no entities", func() {
+			s, err := Infer(c, "Empty", nil)
+			So(err, ShouldBeNil)
+			So(s.Sampled, ShouldEqual, 0)
+			So(s.Properties, ShouldBeEmpty)
+		})
+	})
+}
@@ -50,6 +50,9 @@ const ReadKeyNumToksReasonableLimit = 50
 // routines should encode the context of Keys (read: the appid and namespace).
 // Frequently the appid and namespace of keys are known in advance and so there's
 // no reason to redundantly encode them.
+//
+// The database of keys outside of the default database is always encoded,
+// since it's part of the identity of the entity they refer to.
 type KeyContext bool
 
 // With- and WithoutContext indicate if the serialization method should include
@@ -59,20 +62,38 @@ const (
 	WithoutContext            = false
 )
 
+// Bits of the first byte of an encoded key, indicating what follows.
+const (
+	keyHasContext  byte = 1 << 0
+	keyHasDatabase byte = 1 << 1
+)
+
 // WriteKey encodes a key to the buffer. If context is WithContext, then this
-// encoded value will include the appid and namespace of the key.
+// encoded value will include the appid and namespace of the key. If the key
+// isn't in the default database, its database is included either way.
 func WriteKey(buf WriteBuffer, context KeyContext, k *ds.Key) (err error) {
-	// [appid ++ namespace]? ++ [1 ++ token]* ++ NULL
+	// flags ++ [appid ++ namespace]? ++ [database]? ++ [1 ++ token]* ++ NULL
 	defer recoverTo(&err)
 	appid, namespace, toks := k.Split()
+	database := k.Database()
+
+	flags := byte(0)
 	if context == WithContext {
-		panicIf(buf.WriteByte(1))
+		flags |= keyHasContext
+	}
+	if database != "" {
+		flags |= keyHasDatabase
+	}
+	panicIf(buf.WriteByte(flags))
+	if flags&keyHasContext != 0 {
 		_, e := cmpbin.WriteString(buf, appid)
 		panicIf(e)
 		_, e = cmpbin.WriteString(buf, namespace)
 		panicIf(e)
-	} else {
-		panicIf(buf.WriteByte(0))
+	}
+	if flags&keyHasDatabase != 0 {
+		_, e := cmpbin.WriteString(buf, database)
+		panicIf(e)
 	}
 	for _, tok := range toks {
 		panicIf(buf.WriteByte(1))
@@ -84,21 +105,23 @@ func WriteKey(buf WriteBuffer, context KeyContext, k *ds.Key) (err error) {
 // ReadKey deserializes a key from the buffer. The value of context must match
 // the value of context that was passed to WriteKey when the key was encoded.
 // If context == WithoutContext, then the appid and namespace parameters are
-// used in the decoded Key. Otherwise they're ignored.
+// used in the decoded Key. Otherwise they're ignored. The database of inKC is
+// used only if the key was encoded without one.
 func ReadKey(buf ReadBuffer, context KeyContext, inKC ds.KeyContext) (ret *ds.Key, err error) {
 	defer recoverTo(&err)
-	actualCtx, e := buf.ReadByte()
+	flags, e := buf.ReadByte()
 	panicIf(e)
+	if flags&^(keyHasContext|keyHasDatabase) != 0 {
+		err = fmt.Errorf("helper: expected actualCtx to be between 0 and 3, got %d", flags)
+		return
+	}
 
 	var kc ds.KeyContext
-	if actualCtx == 1 {
+	if flags&keyHasContext != 0 {
 		kc.AppID, _, e = cmpbin.ReadString(buf)
 		panicIf(e)
 		kc.Namespace, _, e = cmpbin.ReadString(buf)
 		panicIf(e)
-	} else if actualCtx != 0 {
-		err = fmt.Errorf("helper: expected actualCtx to be 0 or 1, got %d", actualCtx)
-		return
 	}
 
 	if context == WithoutContext {
@@ -106,6 +129,11 @@ func ReadKey(buf ReadBuffer, context KeyContext, inKC ds.KeyContext) (ret *ds.Ke
 		kc = inKC
 	}
 
+	if flags&keyHasDatabase != 0 {
+		kc.Database, _, e = cmpbin.ReadString(buf)
+		panicIf(e)
+	}
+
 	toks := []ds.KeyTok{}
 	for {
 		ctrlByte, e := buf.ReadByte()
@@ -341,7 +369,17 @@ func ReadProperty(buf ReadBuffer, context KeyContext, kc ds.KeyContext) (p ds.Pr
 // but also potentially useful if you need to make a hash of the property data).
 //
 // Write skips metadata keys.
-func WritePropertyMap(buf WriteBuffer, context KeyContext, pm ds.PropertyMap) (err error) {
+func WritePropertyMap(buf WriteBuffer, context KeyContext, pm ds.PropertyMap) error {
+	return writePropertyMap(buf, context, pm, WritePropertyMapDeterministic)
+}
+
+// WriteSortedPropertyMap is like WritePropertyMap, but always sorts the rows
+// by property name, so that equal PropertyMaps have equal encodings.
+func WriteSortedPropertyMap(buf WriteBuffer, context KeyContext, pm ds.PropertyMap) error {
+	return writePropertyMap(buf, context, pm, true)
+}
+
+func writePropertyMap(buf WriteBuffer, context KeyContext, pm ds.PropertyMap, deterministic bool) (err error) {
 	defer recoverTo(&err)
 	rows := make(sort.StringSlice, 0, len(pm))
 	tmpBuf := &bytes.Buffer{}
@@ -370,7 +408,7 @@ func WritePropertyMap(buf WriteBuffer, context KeyContext, pm ds.PropertyMap) (e
 		rows = append(rows, tmpBuf.String())
 	}
 
-	if WritePropertyMapDeterministic {
+	if deterministic {
 		rows.Sort()
 	}
 
@@ -386,6 +424,21 @@ func WritePropertyMap(buf WriteBuffer, context KeyContext, pm ds.PropertyMap) (e
 // ReadPropertyMap reads a PropertyMap from the buffer. `context` and
 // friends behave the same way that they do for ReadKey.
 func ReadPropertyMap(buf ReadBuffer, context KeyContext, kc ds.KeyContext) (pm ds.PropertyMap, err error) {
+	return readPropertyMap(buf, context, kc, nil)
+}
+
+// ReadPropertyMapInto is like ReadPropertyMap, but reads into pm, which is
+// cleared first, instead of allocating a new PropertyMap. It's used to reuse
+// a PropertyMap for many entities (see datastore.PropertyMapsReusable).
+func ReadPropertyMapInto(buf ReadBuffer, context KeyContext, kc ds.KeyContext, pm ds.PropertyMap) error {
+	for k := range pm {
+		delete(pm, k)
+	}
+	_, err := readPropertyMap(buf, context, kc, pm)
+	return err
+}
+
+func readPropertyMap(buf ReadBuffer, context KeyContext, kc ds.KeyContext, pm ds.PropertyMap) (_ ds.PropertyMap, err error) {
 	defer recoverTo(&err)
 
 	numRows := uint64(0)
@@ -396,7 +449,9 @@ func ReadPropertyMap(buf ReadBuffer, context KeyContext, kc ds.KeyContext) (pm d
 		return
 	}
 
-	pm = make(ds.PropertyMap, numRows)
+	if pm == nil {
+		pm = make(ds.PropertyMap, numRows)
+	}
 
 	name, prop := "", ds.Property{}
 	for i := uint64(0); i < numRows; i++ {
@@ -426,7 +481,7 @@ func ReadPropertyMap(buf ReadBuffer, context KeyContext, kc ds.KeyContext) (pm d
 			pm[name] = props
 		}
 	}
-	return
+	return pm, nil
 }
 
 // WriteIndexColumn writes an IndexColumn to the buffer.
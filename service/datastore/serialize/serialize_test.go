@@ -130,6 +130,15 @@ func TestPropertyMapSerialization(t *testing.T) {
 				})
 			}
 		})
+
+		Convey("round trip into a reused map", func() {
+			pm := ds.PropertyMap{"Stale": mp(1)}
+			for _, tc := range tests {
+				data := ToBytesWithContext(tc.props)
+				So(ReadPropertyMapInto(mkBuf(data), WithContext, ds.MkKeyContext("", ""), pm), ShouldBeNil)
+				So(pm, ShouldResemble, tc.props)
+			}
+		})
 	})
 }
 
@@ -242,6 +251,33 @@ func TestSerializationReadMisc(t *testing.T) {
 					So(err, ShouldBeNil)
 					So(dk, ShouldEqualKey, mkKey("spam", "nerd", "knd", "yo", "other", 10))
 				})
+				Convey("w/ database", func() {
+					kc := ds.KeyContext{AppID: "aid", Namespace: "ns", Database: "a"}
+					k := kc.MakeKey("knd", "yo", "other", 10)
+
+					Convey("encodes the database w/ ctx", func() {
+						dk, err := ReadKey(mkBuf(ToBytesWithContext(k)), WithContext, ds.MkKeyContext("", ""))
+						So(err, ShouldBeNil)
+						So(dk, ShouldEqualKey, k)
+					})
+					Convey("encodes the database w/o ctx", func() {
+						dk, err := ReadKey(mkBuf(ToBytes(k)), WithoutContext, ds.MkKeyContext("aid", "ns"))
+						So(err, ShouldBeNil)
+						So(dk, ShouldEqualKey, k)
+					})
+					Convey("distinguishes keys in different databases", func() {
+						kc.Database = "b"
+						other := kc.MakeKey("knd", "yo", "other", 10)
+						def := mkKey("aid", "ns", "knd", "yo", "other", 10)
+						So(ToBytes(k), ShouldNotResemble, ToBytes(other))
+						So(ToBytes(k), ShouldNotResemble, ToBytes(def))
+						So(ToBytesWithContext(k), ShouldNotResemble, ToBytesWithContext(other))
+					})
+					Convey("doesn't change the default database's encoding", func() {
+						So(ToBytes(mkKey("aid", "ns", "knd", 1))[0], ShouldEqual, 0)
+						So(ToBytesWithContext(mkKey("aid", "ns", "knd", 1))[0], ShouldEqual, 1)
+					})
+				})
 				Convey("IntIDs always sort before StringIDs", func() {
 					// -1 writes as almost all 1's in the first byte under cmpbin, even
 					// though it's technically not a valid key.
@@ -0,0 +1,333 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stats has typed accessors for the datastore statistics entities.
+//
+// The datastore periodically writes entities describing how much data it
+// holds, broken down by namespace, kind, property name and property type. See
+// https://cloud.google.com/appengine/docs/standard/go/datastore/stats.
+//
+// Each statistic exists in two scopes: the statistics of AllNamespaces are
+// stored in the default namespace, and have kinds like "__Stat_Kind__", while
+// those of the CurrentNamespace are stored in each namespace and have kinds
+// like "__Stat_Ns_Kind__". The Get functions query the entities of the scope
+// they're given.
+//
+// The statistics are only updated about once a day, so they may not reflect
+// recent writes, and they don't exist at all in a new app. The
+// impl/memory.GenerateStats function synthesizes them from the contents of the
+// fake datastore, so that code using them can be tested.
+package stats
+
+import (
+	"fmt"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/info"
+
+	"golang.org/x/net/context"
+)
+
+// Scope is the scope of a statistic.
+type Scope int
+
+const (
+	// AllNamespaces is the scope of statistics about the whole datastore.
+	AllNamespaces Scope = iota
+	// CurrentNamespace is the scope of statistics about the namespace of the
+	// context.
+	CurrentNamespace
+)
+
+// The base names of the statistics kinds. Use KindName to get the kind of the
+// entities of a Scope.
+const (
+	TotalStat                        = "Total"
+	NamespaceStat                    = "Namespace"
+	KindStat                         = "Kind"
+	PropertyTypeStat                 = "PropertyType"
+	PropertyTypeKindStat             = "PropertyType_Kind"
+	PropertyNameKindStat             = "PropertyName_Kind"
+	PropertyTypePropertyNameKindStat = "PropertyType_PropertyName_Kind"
+)
+
+// KindName returns the kind of the entities of the statistic stat, one of the
+// *Stat constants, in scope s.
+//
+// NamespaceStat only exists in the AllNamespaces scope.
+func KindName(s Scope, stat string) string {
+	if s == CurrentNamespace {
+		return "__Stat_Ns_" + stat + "__"
+	}
+	return "__Stat_" + stat + "__"
+}
+
+// TotalKey is the string ID of the Total entity.
+const TotalKey = "total_entity_usage"
+
+// Usage is the usage data common to all statistics.
+type Usage struct {
+	// Bytes is the total number of bytes used, including indexes.
+	Bytes int64 `gae:"bytes"`
+	// Count is the number of entities (or, for property statistics, of
+	// properties).
+	Count int64 `gae:"count"`
+	// Timestamp is the time the statistic was computed.
+	Timestamp time.Time `gae:"timestamp"`
+	// EntityBytes is the number of bytes used by entities.
+	EntityBytes int64 `gae:"entity_bytes"`
+	// BuiltinIndexBytes is the number of bytes used by built-in index rows.
+	BuiltinIndexBytes int64 `gae:"builtin_index_bytes"`
+	// BuiltinIndexCount is the number of built-in index rows.
+	BuiltinIndexCount int64 `gae:"builtin_index_count"`
+}
+
+// CompositeUsage is the usage of composite indexes, which is only reported by
+// the Total, Namespace and Kind statistics.
+type CompositeUsage struct {
+	// CompositeIndexBytes is the number of bytes used by composite index rows.
+	CompositeIndexBytes int64 `gae:"composite_index_bytes"`
+	// CompositeIndexCount is the number of composite index rows.
+	CompositeIndexCount int64 `gae:"composite_index_count"`
+}
+
+// Total is the TotalStat statistic: the usage of all entities.
+type Total struct {
+	Key *ds.Key `gae:"$key"`
+
+	Usage
+	CompositeUsage
+}
+
+// Namespace is the NamespaceStat statistic: the usage of the entities in
+// a namespace.
+type Namespace struct {
+	Key *ds.Key `gae:"$key"`
+
+	Usage
+	CompositeUsage
+
+	// SubjectNamespace is the namespace, or "" for the default namespace.
+	SubjectNamespace string `gae:"subject_namespace"`
+}
+
+// Kind is the KindStat statistic: the usage of the entities of a kind.
+type Kind struct {
+	Key *ds.Key `gae:"$key"`
+
+	Usage
+	CompositeUsage
+
+	KindName string `gae:"kind_name"`
+}
+
+// PropertyType is the PropertyTypeStat statistic: the usage of the
+// properties with values of a type.
+type PropertyType struct {
+	Key *ds.Key `gae:"$key"`
+
+	Usage
+
+	// PropertyType is the name of the type, as returned by TypeName.
+	PropertyType string `gae:"property_type"`
+}
+
+// PropertyTypeKind is the PropertyTypeKindStat statistic: the usage of the
+// properties with values of a type, in the entities of a kind.
+type PropertyTypeKind struct {
+	Key *ds.Key `gae:"$key"`
+
+	Usage
+
+	PropertyType string `gae:"property_type"`
+	KindName     string `gae:"kind_name"`
+}
+
+// PropertyNameKind is the PropertyNameKindStat statistic: the usage of
+// a property of the entities of a kind.
+type PropertyNameKind struct {
+	Key *ds.Key `gae:"$key"`
+
+	Usage
+
+	PropertyName string `gae:"property_name"`
+	KindName     string `gae:"kind_name"`
+}
+
+// PropertyTypePropertyNameKind is the PropertyTypePropertyNameKindStat
+// statistic: the usage of the values of a type of a property of the entities
+// of a kind.
+type PropertyTypePropertyNameKind struct {
+	Key *ds.Key `gae:"$key"`
+
+	Usage
+
+	PropertyType string `gae:"property_type"`
+	PropertyName string `gae:"property_name"`
+	KindName     string `gae:"kind_name"`
+}
+
+// TypeName returns the name the statistics use for the type of p, e.g.
+// "String" or "Date/Time".
+func TypeName(p *ds.Property) string {
+	indexed := p.IndexSetting() == ds.ShouldIndex
+	switch p.Type() {
+	case ds.PTNull:
+		return "NULL"
+	case ds.PTInt:
+		return "Integer"
+	case ds.PTTime:
+		return "Date/Time"
+	case ds.PTBool:
+		return "Boolean"
+	case ds.PTBytes:
+		if indexed {
+			return "ShortBlob"
+		}
+		return "Blob"
+	case ds.PTString:
+		if indexed {
+			return "String"
+		}
+		return "Text"
+	case ds.PTFloat:
+		return "Float"
+	case ds.PTGeoPoint:
+		return "GeoPt"
+	case ds.PTKey:
+		return "Key"
+	case ds.PTBlobKey:
+		return "BlobKey"
+	default:
+		return p.Type().String()
+	}
+}
+
+// query returns the context and query for the entities of stat in scope s.
+func query(c context.Context, s Scope, stat string) (context.Context, *ds.Query, error) {
+	if s == AllNamespaces {
+		var err error
+		if c, err = info.Namespace(c, ""); err != nil {
+			return nil, nil, err
+		}
+	}
+	return c, ds.NewQuery(KindName(s, stat)), nil
+}
+
+// getAll runs the query for stat in scope s, filtered by the kind_name kind
+// if it's not empty, and puts the results in dst.
+func getAll(c context.Context, s Scope, stat, kind string, dst interface{}) error {
+	c, q, err := query(c, s, stat)
+	if err != nil {
+		return err
+	}
+	if kind != "" {
+		q = q.Eq("kind_name", kind)
+	}
+	return ds.GetAll(c, q, dst)
+}
+
+// GetTotal returns the Total statistic of scope s.
+//
+// Returns datastore.ErrNoSuchEntity if it hasn't been computed.
+func GetTotal(c context.Context, s Scope) (*Total, error) {
+	c, q, err := query(c, s, TotalStat)
+	if err != nil {
+		return nil, err
+	}
+	var ret []*Total
+	if err := ds.GetAll(c, q.Limit(1), &ret); err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return nil, ds.ErrNoSuchEntity
+	}
+	return ret[0], nil
+}
+
+// GetNamespaces returns the Namespace statistics.
+func GetNamespaces(c context.Context) ([]*Namespace, error) {
+	var ret []*Namespace
+	if err := getAll(c, AllNamespaces, NamespaceStat, "", &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetKinds returns the Kind statistics of scope s.
+func GetKinds(c context.Context, s Scope) ([]*Kind, error) {
+	var ret []*Kind
+	if err := getAll(c, s, KindStat, "", &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetKind returns the Kind statistic of kind in scope s.
+//
+// Returns datastore.ErrNoSuchEntity if it hasn't been computed.
+func GetKind(c context.Context, s Scope, kind string) (*Kind, error) {
+	if kind == "" {
+		return nil, fmt.Errorf("stats: empty kind")
+	}
+	var ret []*Kind
+	if err := getAll(c, s, KindStat, kind, &ret); err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return nil, ds.ErrNoSuchEntity
+	}
+	return ret[0], nil
+}
+
+// GetPropertyTypes returns the PropertyType statistics of scope s.
+func GetPropertyTypes(c context.Context, s Scope) ([]*PropertyType, error) {
+	var ret []*PropertyType
+	if err := getAll(c, s, PropertyTypeStat, "", &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetPropertyTypesOfKind returns the PropertyTypeKind statistics of scope s.
+// If kind is not empty, only the statistics of that kind are returned.
+func GetPropertyTypesOfKind(c context.Context, s Scope, kind string) ([]*PropertyTypeKind, error) {
+	var ret []*PropertyTypeKind
+	if err := getAll(c, s, PropertyTypeKindStat, kind, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetPropertyNamesOfKind returns the PropertyNameKind statistics of scope s.
+// If kind is not empty, only the statistics of that kind are returned.
+func GetPropertyNamesOfKind(c context.Context, s Scope, kind string) ([]*PropertyNameKind, error) {
+	var ret []*PropertyNameKind
+	if err := getAll(c, s, PropertyNameKindStat, kind, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetPropertiesOfKind returns the PropertyTypePropertyNameKind statistics of
+// scope s. If kind is not empty, only the statistics of that kind are
+// returned.
+func GetPropertiesOfKind(c context.Context, s Scope, kind string) ([]*PropertyTypePropertyNameKind, error) {
+	var ret []*PropertyTypePropertyNameKind
+	if err := getAll(c, s, PropertyTypePropertyNameKindStat, kind, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats_test
+
+import (
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/datastore/stats"
+	"go.chromium.org/gae/service/info"
+
+	"go.chromium.org/luci/common/clock/testclock"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type foo struct {
+	ID   int64 `gae:"$id"`
+	Name string
+	Blob []byte `gae:",noindex"`
+	When time.Time
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	Convey("Stats", t, func() {
+		c, _ := testclock.UseTime(context.Background(), testclock.TestTimeUTC)
+		c = memory.Use(c)
+		ds.GetTestable(c).Consistent(true)
+
+		nsC := info.MustNamespace(c, "ns")
+		So(ds.Put(c, &foo{ID: 1, Name: "a", Blob: []byte("blob")}), ShouldBeNil)
+		So(ds.Put(c, &foo{ID: 2, Name: "b"}), ShouldBeNil)
+		So(ds.Put(nsC, &foo{ID: 1, Name: "c"}), ShouldBeNil)
+
+		Convey("are missing until computed", func() {
+			_, err := stats.GetTotal(c, stats.AllNamespaces)
+			So(err, ShouldEqual, ds.ErrNoSuchEntity)
+			kinds, err := stats.GetKinds(c, stats.AllNamespaces)
+			So(err, ShouldBeNil)
+			So(kinds, ShouldBeEmpty)
+		})
+
+		Convey("generated from the memory datastore", func() {
+			So(memory.GenerateStats(c), ShouldBeNil)
+
+			total, err := stats.GetTotal(c, stats.AllNamespaces)
+			So(err, ShouldBeNil)
+			So(total.Count, ShouldEqual, 3)
+			So(total.Timestamp, ShouldResemble, ds.RoundTime(testclock.TestTimeUTC))
+			So(total.Bytes, ShouldEqual, total.EntityBytes+total.BuiltinIndexBytes)
+			So(total.Key.StringID(), ShouldEqual, stats.TotalKey)
+
+			nsTotal, err := stats.GetTotal(nsC, stats.CurrentNamespace)
+			So(err, ShouldBeNil)
+			So(nsTotal.Count, ShouldEqual, 1)
+
+			nss, err := stats.GetNamespaces(c)
+			So(err, ShouldBeNil)
+			So(len(nss), ShouldEqual, 2)
+			counts := map[string]int64{}
+			for _, ns := range nss {
+				counts[ns.SubjectNamespace] = ns.Count
+			}
+			So(counts, ShouldResemble, map[string]int64{"": 2, "ns": 1})
+
+			kind, err := stats.GetKind(c, stats.CurrentNamespace, "foo")
+			So(err, ShouldBeNil)
+			So(kind.Count, ShouldEqual, 2)
+			So(kind.KindName, ShouldEqual, "foo")
+			_, err = stats.GetKind(c, stats.CurrentNamespace, "bar")
+			So(err, ShouldEqual, ds.ErrNoSuchEntity)
+
+			types, err := stats.GetPropertyTypesOfKind(c, stats.AllNamespaces, "foo")
+			So(err, ShouldBeNil)
+			byType := map[string]int64{}
+			for _, t := range types {
+				byType[t.PropertyType] = t.Count
+			}
+			So(byType, ShouldResemble, map[string]int64{
+				"String": 3, "Blob": 3, "Date/Time": 3,
+			})
+
+			props, err := stats.GetPropertiesOfKind(c, stats.CurrentNamespace, "foo")
+			So(err, ShouldBeNil)
+			for _, p := range props {
+				if p.PropertyName == "Blob" {
+					So(p.PropertyType, ShouldEqual, "Blob")
+					So(p.BuiltinIndexCount, ShouldEqual, 0)
+				}
+			}
+
+			names, err := stats.GetPropertyNamesOfKind(nsC, stats.CurrentNamespace, "")
+			So(err, ShouldBeNil)
+			So(len(names), ShouldEqual, 3)
+
+			Convey("and regenerated", func() {
+				So(ds.Delete(nsC, ds.MakeKey(nsC, "foo", 1)), ShouldBeNil)
+				So(memory.GenerateStats(c), ShouldBeNil)
+
+				total, err := stats.GetTotal(c, stats.AllNamespaces)
+				So(err, ShouldBeNil)
+				So(total.Count, ShouldEqual, 2)
+
+				kinds, err := stats.GetKinds(nsC, stats.CurrentNamespace)
+				So(err, ShouldBeNil)
+				So(kinds, ShouldBeEmpty)
+			})
+		})
+	})
+}
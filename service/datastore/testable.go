@@ -19,13 +19,71 @@ type TestingSnapshot interface {
 	ImATestingSnapshot()
 }
 
+// IDGenerator generates integer IDs for incomplete keys in a fake datastore
+// implementation. It is installed with Testable.SetIDGenerator.
+//
+// incomplete is the incomplete Key which needs an ID. Its Kind and Parent may
+// be used to partition the ID space. The returned ID must be > 0.
+type IDGenerator func(incomplete *Key) int64
+
+// Costs is a tally of the simulated billable operations performed against a
+// fake datastore implementation. See Testable.Costs.
+type Costs struct {
+	// EntityReads is the number of entity reads. Each key looked up by GetMulti
+	// is one read, each query is one read, and each entity returned by
+	// a non-keys-only, non-projection query is one read.
+	EntityReads int64
+	// EntityWrites is the number of entity writes. Each entity Put or Deleted is
+	// one write.
+	EntityWrites int64
+	// IndexWrites is the number of index rows written or deleted as a result of
+	// entity writes, including rows in built-in indexes.
+	IndexWrites int64
+	// SmallOps is the number of small operations. Each key or projected entity
+	// returned by a keys-only or projection query is one small operation.
+	SmallOps int64
+}
+
+// QueryLogEntry describes a single query executed by a fake datastore
+// implementation. See Testable.QueryLog.
+type QueryLogEntry struct {
+	// Query is the query which was executed.
+	Query *FinalizedQuery
+
+	// Indexes are the indexes which were used to satisfy Query, in no particular
+	// order. Built-in indexes have Builtin() == true. Kindless queries are
+	// satisfied by the IndexDefinition with no Kind.
+	//
+	// This is empty if the query was trivially empty (for example, because it
+	// contains contradictory filters), or is a __namespace__ metadata query.
+	Indexes []*IndexDefinition
+}
+
 // Testable is the testable interface for fake datastore implementations.
 type Testable interface {
 	// AddIndex adds the provided index.
 	// Blocks all datastore access while the index is built.
-	// Panics if any of the IndexDefinition objects are not Compound()
+	// Panics if any of the IndexDefinition objects are not Compound(), or do not
+	// pass IndexDefinition.Validate. See NewIndex for building them.
 	AddIndexes(...*IndexDefinition)
 
+	// HideIndexes makes queries behave as if the provided compound indexes did
+	// not exist, even though they've been added with AddIndexes. Queries which
+	// would need one of these indexes fail with a missing index error, the same
+	// way that they would in production while an index is still being built or
+	// is being deleted.
+	//
+	// The indexes continue to be maintained while hidden, so UnhideIndexes
+	// makes them immediately usable again.
+	//
+	// Panics if any of the IndexDefinition objects are not Compound()
+	HideIndexes(...*IndexDefinition)
+
+	// UnhideIndexes undoes HideIndexes for the provided indexes.
+	//
+	// Panics if any of the IndexDefinition objects are not Compound()
+	UnhideIndexes(...*IndexDefinition)
+
 	// TakeIndexSnapshot allows you to take a snapshot of the current index
 	// tables, which can be used later with SetIndexSnapshot.
 	TakeIndexSnapshot() TestingSnapshot
@@ -60,6 +118,14 @@ type Testable interface {
 	// CatchupIndexes or use Take/SetIndexSnapshot to manipulate the index state.
 	Consistent(always bool)
 
+	// KeepHistory controls whether the testing implementation records the past
+	// states of the datastore, which reads at a read time (see WithReadTime and
+	// TransactionOptions.ReadTime) require. Like the real datastore, it keeps
+	// them for an hour.
+	//
+	// By default this is false, and reads at a read time return an error.
+	KeepHistory(bool)
+
 	// AutoIndex controls the index creation behavior. If it is set to true, then
 	// any time the datastore encounters a missing index, it will silently create
 	// one and allow the query to succeed. If it's false, then the query will
@@ -89,4 +155,48 @@ type Testable interface {
 	//
 	// If c is nil, default constraints will be set.
 	SetConstraints(c *Constraints) error
+
+	// SetIDGenerator sets the generator used to assign integer IDs to incomplete
+	// keys, both by Put and by AllocateIDs.
+	//
+	// If gen is nil, the default behavior is restored: IDs are allocated
+	// sequentially starting at 1, independently for each root Kind and for each
+	// entity group.
+	//
+	// IDs returned by gen which collide with an existing entity, or with an ID
+	// that gen has already produced, are skipped.
+	SetIDGenerator(gen IDGenerator)
+
+	// Freeze puts the datastore into read-only mode. While frozen, any operation
+	// which would mutate the datastore (Put, Delete, AllocateIDs, and mutations
+	// inside of transactions) returns an error instead of succeeding. Reads and
+	// queries continue to work normally.
+	//
+	// This allows a test to assert that a code path performs no writes at all.
+	Freeze()
+
+	// Thaw undoes a previous call to Freeze, allowing writes again.
+	Thaw()
+
+	// Costs returns the tally of operations performed against this datastore
+	// since it was created, or since the last call to ResetCosts.
+	//
+	// Writes made inside of a transaction are counted when the transaction
+	// commits.
+	Costs() Costs
+
+	// ResetCosts resets the tally returned by Costs to zero.
+	ResetCosts()
+
+	// QueryLog returns an entry for every query executed against this datastore
+	// since it was created, or since the last call to ResetQueryLog, in the
+	// order that they were executed. Queries which failed (e.g. due to a missing
+	// index) are not included.
+	//
+	// This can be used to find out which compound indexes are actually used by
+	// a body of code.
+	QueryLog() []QueryLogEntry
+
+	// ResetQueryLog clears the log returned by QueryLog.
+	ResetQueryLog()
 }
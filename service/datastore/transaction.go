@@ -15,6 +15,8 @@
 package datastore
 
 import (
+	"time"
+
 	"golang.org/x/net/context"
 )
 
@@ -28,6 +30,29 @@ import (
 //	  this Transaction so far.
 type Transaction interface{}
 
+// CommitInfo describes a committed transaction.
+type CommitInfo struct {
+	// ID is an identifier for the transaction, unique within the datastore. Its
+	// format depends on the implementation.
+	ID string
+	// Time is the time at which the transaction was committed, or the zero Time
+	// if the implementation does not provide it.
+	Time time.Time
+}
+
+// CommitInfoTransaction is a Transaction which can report information about
+// its commit.
+//
+// Implementations whose backend exposes commit details should have their
+// Transaction implement this interface.
+type CommitInfoTransaction interface {
+	Transaction
+
+	// CommitInfo returns information about the commit of this transaction, or
+	// nil if it has not (yet) been committed.
+	CommitInfo() *CommitInfo
+}
+
 // WithoutTransaction returns a Context that isn't bound to a transaction.
 // This may be called even when outside of a transaction, in which case the
 // input Context is a valid return value.
@@ -48,3 +73,26 @@ func WithoutTransaction(c context.Context) context.Context {
 func CurrentTransaction(c context.Context) Transaction {
 	return Raw(c).CurrentTransaction()
 }
+
+// RunInTransactionWithInfo is like RunInTransaction, but additionally returns
+// information about the commit of the transaction.
+//
+// The returned CommitInfo is nil if the transaction was not committed, or if
+// the datastore implementation does not report commit information (see
+// CommitInfoTransaction). If this is called inside of an enclosing transaction
+// (e.g. with the txnBuf filter), the returned CommitInfo is nil until the
+// outermost transaction commits.
+func RunInTransactionWithInfo(c context.Context, f func(c context.Context) error, opts *TransactionOptions) (*CommitInfo, error) {
+	var txn Transaction
+	err := RunInTransaction(c, func(c context.Context) error {
+		txn = CurrentTransaction(c)
+		return f(c)
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := txn.(CommitInfoTransaction); ok {
+		return t.CommitInfo(), nil
+	}
+	return nil, nil
+}
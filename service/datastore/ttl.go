@@ -0,0 +1,107 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TTLPolicy is a time-to-live policy of a kind: entities of Kind expire, and
+// are eventually deleted by the datastore, once the time in their Property
+// has passed.
+//
+// Entities whose Property is missing or isn't a single time.Time value never
+// expire.
+//
+// On Cloud Datastore, TTL policies are configured out of band, e.g. with the
+// command returned by GcloudCommand. Registering them in the application
+// makes them known to impl/memory, which deletes the expired entities as its
+// clock advances, so expiry-dependent logic can be tested.
+type TTLPolicy struct {
+	Kind     string
+	Property string
+}
+
+// GcloudCommand returns the gcloud command which enables this TTL policy on
+// Cloud Datastore.
+func (p TTLPolicy) GcloudCommand() string {
+	return fmt.Sprintf("gcloud firestore fields ttls update %s --collection-group=%s --enable-ttl", p.Property, p.Kind)
+}
+
+// Expired returns true if the entity pm has expired as of now according to
+// this policy.
+func (p TTLPolicy) Expired(pm PropertyMap, now time.Time) bool {
+	vals := pm.Slice(p.Property)
+	if len(vals) != 1 || vals[0].Type() != PTTime {
+		return false
+	}
+	return !now.Before(vals[0].Value().(time.Time))
+}
+
+var ttlPolicies = struct {
+	sync.RWMutex
+	byKind map[string]string
+}{byKind: map[string]string{}}
+
+func registerTTLPolicy(kind, property string) error {
+	if kind == "" || property == "" {
+		return fmt.Errorf("datastore: bad TTL policy %q/%q", kind, property)
+	}
+
+	ttlPolicies.Lock()
+	defer ttlPolicies.Unlock()
+	if cur, ok := ttlPolicies.byKind[kind]; ok && cur != property {
+		return fmt.Errorf("datastore: kind %q already has a TTL policy on %q, not %q", kind, cur, property)
+	}
+	ttlPolicies.byKind[kind] = property
+	return nil
+}
+
+// RegisterTTLPolicy registers a TTL policy on property for kind. Policies are
+// also registered for structs with a field tagged as 'ttl' and a static kind,
+// when their codec is first built (see GetPLS).
+//
+// It's meant to be called from init. It panics if kind already has a policy on
+// a different property.
+func RegisterTTLPolicy(kind, property string) {
+	if err := registerTTLPolicy(kind, property); err != nil {
+		panic(err)
+	}
+}
+
+// GetTTLPolicy returns the registered TTL policy of kind, if any.
+func GetTTLPolicy(kind string) (TTLPolicy, bool) {
+	ttlPolicies.RLock()
+	defer ttlPolicies.RUnlock()
+	if prop, ok := ttlPolicies.byKind[kind]; ok {
+		return TTLPolicy{kind, prop}, true
+	}
+	return TTLPolicy{}, false
+}
+
+// TTLPolicies returns all of the registered TTL policies, sorted by kind.
+func TTLPolicies() []TTLPolicy {
+	ttlPolicies.RLock()
+	defer ttlPolicies.RUnlock()
+	ret := make([]TTLPolicy, 0, len(ttlPolicies.byKind))
+	for kind, prop := range ttlPolicies.byKind {
+		ret = append(ret, TTLPolicy{kind, prop})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Kind < ret[j].Kind })
+	return ret
+}
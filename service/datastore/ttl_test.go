@@ -0,0 +1,126 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+type ttlTagged struct {
+	_kind   string    `gae:"$kind,ttlTagged"`
+	ID      int64     `gae:"$id"`
+	Expires time.Time `gae:",noindex,ttl"`
+}
+
+func TestTTLPolicy(t *testing.T) {
+	t.Parallel()
+
+	Convey("TTL policies", t, func() {
+		Convey("can be registered", func() {
+			RegisterTTLPolicy("ttlRegistered", "ExpireAt")
+			RegisterTTLPolicy("ttlRegistered", "ExpireAt")
+			So(func() { RegisterTTLPolicy("ttlRegistered", "Other") }, ShouldPanicLike, `already has a TTL policy on "ExpireAt"`)
+
+			p, ok := GetTTLPolicy("ttlRegistered")
+			So(ok, ShouldBeTrue)
+			So(p, ShouldResemble, TTLPolicy{"ttlRegistered", "ExpireAt"})
+			So(TTLPolicies(), ShouldContain, p)
+
+			_, ok = GetTTLPolicy("ttlUnknown")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("are registered for tagged structs", func() {
+			pls := GetPLS(&ttlTagged{ID: 1})
+			p, ok := GetTTLPolicy("ttlTagged")
+			So(ok, ShouldBeTrue)
+			So(p.Property, ShouldEqual, "Expires")
+
+			pm, err := pls.Save(false)
+			So(err, ShouldBeNil)
+			So(pm.Slice("Expires")[0].IndexSetting(), ShouldEqual, NoIndex)
+
+			type ttlStructName struct {
+				Expires time.Time `gae:",ttl"`
+			}
+			GetPLS(&ttlStructName{})
+			p, ok = GetTTLPolicy("ttlStructName")
+			So(ok, ShouldBeTrue)
+			So(p.Property, ShouldEqual, "Expires")
+		})
+
+		Convey("aren't registered for structs with a dynamic kind", func() {
+			type ttlDynamic struct {
+				Kind    string    `gae:"$kind"`
+				Expires time.Time `gae:",ttl"`
+			}
+			_, err := GetPLS(&ttlDynamic{Kind: "ttlDynamicKind"}).Save(true)
+			So(err, ShouldBeNil)
+			_, ok := GetTTLPolicy("ttlDynamicKind")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("conflicting tags are a codec problem", func() {
+			RegisterTTLPolicy("ttlConflict", "Other")
+			type ttlConflict struct {
+				Expires time.Time `gae:",ttl"`
+			}
+			So(func() { GetPLS(&ttlConflict{}) }, ShouldPanicLike, `already has a TTL policy on "Other"`)
+		})
+
+		Convey("bad tags", func() {
+			type badType struct {
+				Expires int64 `gae:",ttl"`
+			}
+			So(func() { GetPLS(&badType{}) }, ShouldPanicLike, "expecting time.Time")
+
+			type twice struct {
+				A time.Time `gae:",ttl"`
+				B time.Time `gae:",ttl"`
+			}
+			So(func() { GetPLS(&twice{}) }, ShouldPanicLike, "multiple fields tagged as 'ttl'")
+
+			type badCreated struct {
+				Created string `gae:",created"`
+			}
+			So(func() { GetPLS(&badCreated{}) }, ShouldPanicLike, "tagged as 'created' has type string")
+
+			type twiceUpdated struct {
+				A time.Time `gae:",updated"`
+				B time.Time `gae:",noindex,updated"`
+			}
+			So(func() { GetPLS(&twiceUpdated{}) }, ShouldPanicLike, "multiple fields tagged as 'updated'")
+		})
+
+		Convey("Expired", func() {
+			p := TTLPolicy{"Kind", "Expires"}
+			now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+			So(p.Expired(PropertyMap{"Expires": MkProperty(now)}, now), ShouldBeTrue)
+			So(p.Expired(PropertyMap{"Expires": MkProperty(now.Add(time.Second))}, now), ShouldBeFalse)
+			So(p.Expired(PropertyMap{}, now), ShouldBeFalse)
+			So(p.Expired(PropertyMap{"Expires": MkProperty("yesterday")}, now), ShouldBeFalse)
+			So(p.Expired(PropertyMap{"Expires": PropertySlice{MkProperty(now), MkProperty(now)}}, now), ShouldBeFalse)
+		})
+
+		Convey("GcloudCommand", func() {
+			So(TTLPolicy{"Session", "Expires"}.GcloudCommand(), ShouldEqual,
+				"gcloud firestore fields ttls update Expires --collection-group=Session --enable-ttl")
+		})
+	})
+}
@@ -16,6 +16,10 @@
 
 package datastore
 
+import (
+	"time"
+)
+
 // GeoPoint represents a location as latitude/longitude in degrees.
 //
 // You probably shouldn't use these, but their inclusion here is so that the
@@ -48,6 +52,10 @@ type TransactionOptions struct {
 	// ReadOnly controls whether the transaction is a read only transaction.
 	// Read only transactions are potentially more efficient.
 	ReadOnly bool
+	// ReadTime, if not zero, makes the reads of the transaction see the
+	// datastore as it was at that time. It requires ReadOnly. See WithReadTime
+	// for reads outside of transactions.
+	ReadTime time.Time
 }
 
 // Toggle is a tri-state boolean (Auto/True/False), which allows structs
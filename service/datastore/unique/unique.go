@@ -0,0 +1,188 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package unique enforces the uniqueness of values, like user names or email
+// addresses, across the entities of a kind.
+//
+// The datastore has no unique constraints, and checking for an existing entity
+// with a query isn't enough, since queries can't run in transactions (and may
+// be eventually consistent). Instead, each value is claimed with a marker
+// entity whose key is derived from the value, written in the same transaction
+// as the entity which owns it:
+//
+//	err := ds.RunInTransaction(c, func(c context.Context) error {
+//	  if err := unique.Claim(c, "User.Email", u.Email, ds.KeyForObj(c, u)); err != nil {
+//	    return err // a *unique.TakenError if another user has this email
+//	  }
+//	  return ds.Put(c, u)
+//	}, &ds.TransactionOptions{XG: true})
+//
+// Markers are root entities, so the transaction must be cross-group. When the
+// value of an entity changes use Change, and when the entity is deleted use
+// Release, in the same transaction as the write, so that the value can be
+// claimed again.
+//
+// A scope names the set of values which must be unique, e.g. "User.Email".
+// Empty values are never claimed, so optional properties can be left empty
+// by any number of entities.
+package unique
+
+import (
+	"fmt"
+	"strings"
+
+	ds "go.chromium.org/gae/service/datastore"
+
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// TakenError is returned by Claim and Change when the value is already claimed
+// by another owner.
+type TakenError struct {
+	// Scope is the scope of the value.
+	Scope string
+	// Value is the value.
+	Value string
+	// Owner is the key of the entity which has claimed the value.
+	Owner *ds.Key
+}
+
+func (e *TakenError) Error() string {
+	return fmt.Sprintf("unique: %s %q is taken by %s", e.Scope, e.Value, e.Owner)
+}
+
+// marker is the datastore entity claiming a value.
+type marker struct {
+	_kind string `gae:"$kind,gae.unique.Marker"`
+
+	// ID is the scope and the value, see markerID.
+	ID    string  `gae:"$id"`
+	Owner *ds.Key `gae:",noindex"`
+}
+
+func markerID(scope, value string) string {
+	return scope + ":" + value
+}
+
+func check(c context.Context, scope string, owner *ds.Key) error {
+	switch {
+	case scope == "":
+		return errors.New("unique: empty scope")
+	case strings.Contains(scope, ":"):
+		return fmt.Errorf("unique: scope %q contains ':'", scope)
+	case owner == nil || owner.IsIncomplete():
+		return fmt.Errorf("unique: owner must be a complete key, not %s", owner)
+	case ds.CurrentTransaction(c) == nil:
+		return errors.New("unique: must be called in a transaction")
+	}
+	return nil
+}
+
+// Claim claims value in scope for the entity owner, which must be a complete
+// key.
+//
+// It must be called in the cross-group transaction which writes owner. It does
+// nothing if value is empty or already claimed by owner, and returns
+// a *TakenError if it's claimed by another entity.
+func Claim(c context.Context, scope, value string, owner *ds.Key) error {
+	if err := check(c, scope, owner); err != nil {
+		return err
+	}
+	if value == "" {
+		return nil
+	}
+
+	m := &marker{ID: markerID(scope, value)}
+	switch err := ds.Get(c, m); {
+	case err == ds.ErrNoSuchEntity:
+	case err != nil:
+		return errors.Annotate(err, "failed to get the marker of %s %q", scope, value).Err()
+	case m.Owner.Equal(owner):
+		return nil
+	default:
+		return &TakenError{Scope: scope, Value: value, Owner: m.Owner}
+	}
+
+	m.Owner = owner
+	if err := ds.Put(c, m); err != nil {
+		return errors.Annotate(err, "failed to claim %s %q", scope, value).Err()
+	}
+	return nil
+}
+
+// Release releases value in scope, so that it can be claimed by another
+// entity.
+//
+// It must be called in the cross-group transaction which deletes or changes
+// owner. It does nothing if value is empty or isn't claimed by owner.
+func Release(c context.Context, scope, value string, owner *ds.Key) error {
+	if err := check(c, scope, owner); err != nil {
+		return err
+	}
+	if value == "" {
+		return nil
+	}
+
+	m := &marker{ID: markerID(scope, value)}
+	switch err := ds.Get(c, m); {
+	case err == ds.ErrNoSuchEntity:
+		return nil
+	case err != nil:
+		return errors.Annotate(err, "failed to get the marker of %s %q", scope, value).Err()
+	case !m.Owner.Equal(owner):
+		return nil
+	}
+
+	if err := ds.Delete(c, m); err != nil {
+		return errors.Annotate(err, "failed to release %s %q", scope, value).Err()
+	}
+	return nil
+}
+
+// Change claims newValue and releases oldValue in scope for owner, as when
+// the unique property of owner is changed.
+//
+// It must be called in the cross-group transaction which writes owner. It
+// returns a *TakenError if newValue is claimed by another entity, in which
+// case oldValue remains claimed.
+func Change(c context.Context, scope, oldValue, newValue string, owner *ds.Key) error {
+	if oldValue == newValue {
+		return check(c, scope, owner)
+	}
+	if err := Claim(c, scope, newValue, owner); err != nil {
+		return err
+	}
+	return Release(c, scope, oldValue, owner)
+}
+
+// Lookup returns the key of the entity which has claimed value in scope, or
+// nil if the value isn't claimed.
+//
+// Unlike the other functions, it can be called outside of a transaction.
+func Lookup(c context.Context, scope, value string) (*ds.Key, error) {
+	if value == "" {
+		return nil, nil
+	}
+	m := &marker{ID: markerID(scope, value)}
+	switch err := ds.Get(c, m); err {
+	case nil:
+		return m.Owner, nil
+	case ds.ErrNoSuchEntity:
+		return nil, nil
+	default:
+		return nil, errors.Annotate(err, "failed to get the marker of %s %q", scope, value).Err()
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unique
+
+import (
+	"testing"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+type user struct {
+	ID    int64 `gae:"$id"`
+	Email string
+}
+
+func TestUnique(t *testing.T) {
+	t.Parallel()
+
+	Convey("Unique", t, func() {
+		c := memory.Use(context.Background())
+		xg := &ds.TransactionOptions{XG: true}
+
+		// put writes u and updates the claim of its email, like an application
+		// would.
+		put := func(u *user) error {
+			return ds.RunInTransaction(c, func(c context.Context) error {
+				old := &user{ID: u.ID}
+				if err := ds.Get(c, old); err != nil && err != ds.ErrNoSuchEntity {
+					return err
+				}
+				if err := Change(c, "User.Email", old.Email, u.Email, ds.KeyForObj(c, u)); err != nil {
+					return err
+				}
+				return ds.Put(c, u)
+			}, xg)
+		}
+		del := func(u *user) error {
+			return ds.RunInTransaction(c, func(c context.Context) error {
+				if err := Release(c, "User.Email", u.Email, ds.KeyForObj(c, u)); err != nil {
+					return err
+				}
+				return ds.Delete(c, u)
+			}, xg)
+		}
+
+		alice := &user{ID: 11, Email: "a@example.com"}
+		So(put(alice), ShouldBeNil)
+
+		owner, err := Lookup(c, "User.Email", "a@example.com")
+		So(err, ShouldBeNil)
+		So(owner, ShouldResemble, ds.KeyForObj(c, alice))
+
+		Convey("values can't be claimed twice", func() {
+			bob := &user{ID: 12, Email: "a@example.com"}
+			err := put(bob)
+			So(err, ShouldResemble, &TakenError{
+				Scope: "User.Email",
+				Value: "a@example.com",
+				Owner: ds.KeyForObj(c, alice),
+			})
+			So(err, ShouldErrLike, `User.Email "a@example.com" is taken`)
+			So(ds.Get(c, &user{ID: 12}), ShouldEqual, ds.ErrNoSuchEntity)
+
+			// Other scopes are independent.
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				return Claim(c, "Other", "a@example.com", ds.KeyForObj(c, bob))
+			}, nil), ShouldBeNil)
+		})
+
+		Convey("claiming again is a no-op", func() {
+			So(put(alice), ShouldBeNil)
+		})
+
+		Convey("empty values are never claimed", func() {
+			So(put(&user{ID: 12}), ShouldBeNil)
+			So(put(&user{ID: 13}), ShouldBeNil)
+			owner, err := Lookup(c, "User.Email", "")
+			So(err, ShouldBeNil)
+			So(owner, ShouldBeNil)
+		})
+
+		Convey("changing releases the old value", func() {
+			alice.Email = "alice@example.com"
+			So(put(alice), ShouldBeNil)
+
+			owner, err := Lookup(c, "User.Email", "a@example.com")
+			So(err, ShouldBeNil)
+			So(owner, ShouldBeNil)
+
+			So(put(&user{ID: 12, Email: "a@example.com"}), ShouldBeNil)
+			So(put(&user{ID: 13, Email: "alice@example.com"}), ShouldHaveSameTypeAs, &TakenError{})
+		})
+
+		Convey("failed changes keep the old value", func() {
+			So(put(&user{ID: 12, Email: "b@example.com"}), ShouldBeNil)
+			alice.Email = "b@example.com"
+			So(put(alice), ShouldHaveSameTypeAs, &TakenError{})
+
+			owner, err := Lookup(c, "User.Email", "a@example.com")
+			So(err, ShouldBeNil)
+			So(owner, ShouldResemble, ds.KeyForObj(c, alice))
+		})
+
+		Convey("deleting releases the value", func() {
+			So(del(alice), ShouldBeNil)
+			So(put(&user{ID: 12, Email: "a@example.com"}), ShouldBeNil)
+
+			Convey("and releasing a value of someone else does nothing", func() {
+				So(del(alice), ShouldBeNil)
+				owner, err := Lookup(c, "User.Email", "a@example.com")
+				So(err, ShouldBeNil)
+				So(owner, ShouldResemble, ds.NewKey(c, "user", "", 12, nil))
+			})
+		})
+
+		Convey("bad calls", func() {
+			key := ds.KeyForObj(c, alice)
+			So(Claim(c, "User.Email", "x", key), ShouldErrLike, "must be called in a transaction")
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				return Claim(c, "", "x", key)
+			}, nil), ShouldErrLike, "empty scope")
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				return Claim(c, "a:b", "x", key)
+			}, nil), ShouldErrLike, "contains ':'")
+			So(ds.RunInTransaction(c, func(c context.Context) error {
+				return Claim(c, "User.Email", "x", ds.NewKey(c, "user", "", 0, nil))
+			}, nil), ShouldErrLike, "must be a complete key")
+		})
+	})
+}
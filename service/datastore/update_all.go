@@ -0,0 +1,208 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultUpdateAllBatchSize is the default UpdateAllOptions.BatchSize.
+const DefaultUpdateAllBatchSize = 100
+
+// UpdateCB is the callback used by UpdateAll. It's called with each entity
+// matched by the query, and may modify pm in place. It returns true if pm
+// was modified and should be written back.
+//
+// To work with a struct type, use GetPLS(obj).Load(pm) and then copy the
+// result of GetPLS(obj).Save(true) back into pm.
+//
+// When UpdateAll runs with Transactional set, c is the transaction's context.
+// Returning an error stops UpdateAll.
+type UpdateCB func(c context.Context, pm PropertyMap) (changed bool, err error)
+
+// UpdateAllOptions are options for UpdateAll.
+type UpdateAllOptions struct {
+	// BatchSize is the number of entities processed between checkpoints.
+	// Without Transactional, it's also the largest number of entities written
+	// by each PutMulti call. If zero, DefaultUpdateAllBatchSize is used.
+	BatchSize int
+
+	// Transactional, if true, makes UpdateAll run a keys-only query, and
+	// re-get, update and put each entity in its own transaction. This is
+	// slower, but guarantees that concurrent writes to the entity aren't
+	// overwritten.
+	Transactional bool
+
+	// Checkpoint, if not nil, is called after each batch has been written with
+	// a cursor pointing past the last processed entity. The query can be
+	// resumed from this cursor with Query.Start. When the query has been fully
+	// processed, Checkpoint is called with a nil cursor.
+	//
+	// Returning an error stops UpdateAll.
+	Checkpoint func(c context.Context, cursor Cursor) error
+
+	// DryRun, if true, runs the callback on every entity but doesn't write
+	// anything back. The returned count is the number of entities which would
+	// have been updated.
+	DryRun bool
+}
+
+// UpdateAll runs q, passes each entity to cb, and writes back the entities
+// that cb changed. It returns the number of entities updated. See UpdateAll.
+func (o *UpdateAllOptions) UpdateAll(c context.Context, q *Query, cb UpdateCB) (int, error) {
+	batchSize := o.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultUpdateAllBatchSize
+	}
+
+	// Writing back a partial entity would delete the properties it lacks.
+	fq, err := q.Finalize()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case len(fq.Project()) > 0 || fq.Distinct():
+		return 0, fmt.Errorf("datastore: UpdateAll can't run a projection query")
+	case fq.KeysOnly() && !o.Transactional:
+		return 0, fmt.Errorf("datastore: UpdateAll can only run a keys-only query with Transactional")
+	}
+
+	u := updater{o, c, cb, 0}
+	if o.Transactional {
+		err = u.runTransactional(q, batchSize)
+	} else {
+		err = u.run(q, batchSize)
+	}
+	if err == nil && o.Checkpoint != nil {
+		err = o.Checkpoint(c, nil)
+	}
+	return u.updated, err
+}
+
+// UpdateAll runs q, passes each entity to cb, and writes back the entities
+// that cb changed with the default UpdateAllOptions. It returns the number
+// of entities updated.
+//
+// Entities are written in batches, without transactions, so a concurrent
+// write to an entity between the query and the batch Put may be lost. Use
+// UpdateAllOptions.Transactional where this matters.
+//
+// Since whole entities are written back, q must not be a projection query, nor
+// a keys-only one unless UpdateAllOptions.Transactional is set.
+func UpdateAll(c context.Context, q *Query, cb UpdateCB) (int, error) {
+	return (&UpdateAllOptions{}).UpdateAll(c, q, cb)
+}
+
+type updater struct {
+	*UpdateAllOptions
+
+	c       context.Context
+	cb      UpdateCB
+	updated int
+}
+
+func (u *updater) checkpoint(getCursor CursorCB) error {
+	if u.Checkpoint == nil {
+		return nil
+	}
+	cursor, err := getCursor()
+	if err != nil {
+		return err
+	}
+	return u.Checkpoint(u.c, cursor)
+}
+
+func (u *updater) run(q *Query, batchSize int) error {
+	var changed []PropertyMap
+	flush := func() error {
+		if len(changed) > 0 && !u.DryRun {
+			if err := Put(u.c, changed); err != nil {
+				return err
+			}
+		}
+		u.updated += len(changed)
+		changed = changed[:0]
+		return nil
+	}
+
+	seen := 0
+	err := Run(u.c, q, func(pm PropertyMap, getCursor CursorCB) error {
+		ok, err := u.cb(u.c, pm)
+		if err != nil {
+			return err
+		}
+		if ok {
+			changed = append(changed, pm)
+		}
+		if seen++; seen%batchSize == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := u.checkpoint(getCursor); err != nil {
+				return err
+			}
+		}
+		return u.c.Err()
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+func (u *updater) runTransactional(q *Query, batchSize int) error {
+	seen := 0
+	return Run(u.c, q.KeysOnly(true), func(k *Key, getCursor CursorCB) error {
+		updated := false
+		err := RunInTransaction(u.c, func(c context.Context) error {
+			updated = false // in case the transaction is retried
+			pm := PropertyMap{}
+			PopulateKey(pm, k)
+			switch err := Get(c, pm); err {
+			case nil:
+			case ErrNoSuchEntity:
+				// Deleted since the query ran.
+				return nil
+			default:
+				return err
+			}
+
+			ok, err := u.cb(c, pm)
+			if err != nil || !ok {
+				return err
+			}
+			updated = true
+			if u.DryRun {
+				return nil
+			}
+			return Put(c, pm)
+		}, nil)
+		if err != nil {
+			return err
+		}
+		if updated {
+			u.updated++
+		}
+
+		if seen++; seen%batchSize == 0 {
+			if err := u.checkpoint(getCursor); err != nil {
+				return err
+			}
+		}
+		return u.c.Err()
+	})
+}
@@ -0,0 +1,294 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultWriterFlushSize is the default WriterOptions.FlushSize.
+const DefaultWriterFlushSize = 500
+
+// WriterOptions are options for NewWriter.
+type WriterOptions struct {
+	// FlushSize is the number of buffered writes at which the Writer flushes.
+	// If zero, DefaultWriterFlushSize is used. Flushes are further split to fit
+	// the implementation's Constraints.
+	FlushSize int
+
+	// FlushInterval, if positive, makes the Writer also flush in the background
+	// at this interval, so writes don't stay buffered for long.
+	FlushInterval time.Duration
+}
+
+// WriteError is the error of a single write made by a Writer.
+type WriteError struct {
+	// Op is MutationUpsert for Puts, and MutationDelete for Deletes.
+	Op  MutationOp
+	Key *Key
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("datastore: failed to %s %s: %s", e.Op, e.Key, e.Err)
+}
+
+// ErrWriterClosed is returned when writing to a closed Writer.
+var ErrWriterClosed = errors.New("datastore: the Writer is closed")
+
+// Writer buffers Puts and Deletes, and writes them in batches. This suits bulk
+// loaders, which would otherwise have to chunk their writes themselves.
+//
+// Buffered writes are flushed when there are FlushSize of them, every
+// FlushInterval if set, and when Flush or Close is called. Writes to the same
+// complete key replace each other in the buffer, so only the last one is
+// made. Entities with incomplete keys are written with new keys, which are
+// not written back to them.
+//
+// Flush and Close return an errors.MultiError with a *WriteError for each
+// write which failed since the last time errors were returned, including in
+// background flushes. A Writer is safe for concurrent use, and must be closed
+// when it's no longer needed.
+type Writer struct {
+	c         context.Context
+	flushSize int
+
+	// flushLock serializes flushes, so writes are made in order.
+	flushLock sync.Mutex
+
+	lock    sync.Mutex
+	pending []RawMutation
+	index   map[string]int // encoded complete key => index in pending
+	errs    errors.MultiError
+	closed  bool
+
+	stop func()
+	done chan struct{}
+}
+
+// NewWriter returns a Writer which writes to the datastore of c with options
+// opts, which may be nil for the defaults.
+func NewWriter(c context.Context, opts *WriterOptions) *Writer {
+	if opts == nil {
+		opts = &WriterOptions{}
+	}
+	w := &Writer{
+		c:         c,
+		flushSize: opts.FlushSize,
+		index:     map[string]int{},
+		stop:      func() {},
+	}
+	if w.flushSize <= 0 {
+		w.flushSize = DefaultWriterFlushSize
+	}
+
+	if opts.FlushInterval > 0 {
+		tc, cancel := context.WithCancel(c)
+		w.stop = cancel
+		w.done = make(chan struct{})
+		go func() {
+			defer close(w.done)
+			for {
+				select {
+				case <-tc.Done():
+					return
+				case <-clock.After(tc, opts.FlushInterval):
+					w.flushAndKeepErrors()
+				}
+			}
+		}()
+	}
+	return w
+}
+
+// Put buffers writes of src, which may be any of the types accepted by Put.
+//
+// It returns an error if the keys of src can't be determined, in the same
+// form as Put. If the buffer is full, Put flushes it and returns the errors of
+// the Writer, like Flush.
+func (w *Writer) Put(src ...interface{}) error {
+	if len(src) == 0 {
+		return nil
+	}
+	mma, err := makeMetaMultiArg(src, mmaReadWrite)
+	if err != nil {
+		panic(err)
+	}
+	mma.setAutoNow(autoNow(w.c))
+	keys, vals, err := mma.getKeysPMs(GetKeyContext(w.c), false)
+	if err != nil {
+		return maybeSingleError(err, src)
+	}
+	return w.add(MutationUpsert, keys, vals)
+}
+
+// Delete buffers deletions of ent, which may be any of the types accepted by
+// Delete.
+//
+// It returns an error if the keys of ent can't be determined, in the same form
+// as Delete. If the buffer is full, Delete flushes it and returns the errors
+// of the Writer, like Flush.
+func (w *Writer) Delete(ent ...interface{}) error {
+	if len(ent) == 0 {
+		return nil
+	}
+	mma, err := makeMetaMultiArg(ent, mmaKeysOnly)
+	if err != nil {
+		panic(err)
+	}
+	keys, _, err := mma.getKeysPMs(GetKeyContext(w.c), false)
+	if err != nil {
+		return maybeSingleError(err, ent)
+	}
+	return w.add(MutationDelete, keys, nil)
+}
+
+func (w *Writer) add(op MutationOp, keys []*Key, vals []PropertyMap) error {
+	full := false
+	err := func() error {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		if w.closed {
+			return ErrWriterClosed
+		}
+		for i, k := range keys {
+			m := RawMutation{Op: op, Key: k}
+			if vals != nil {
+				m.Value = vals[i]
+			}
+			if k.IsIncomplete() {
+				w.pending = append(w.pending, m)
+				continue
+			}
+			enc := k.Encode()
+			if idx, ok := w.index[enc]; ok {
+				w.pending[idx] = m
+				continue
+			}
+			w.index[enc] = len(w.pending)
+			w.pending = append(w.pending, m)
+		}
+		full = len(w.pending) >= w.flushSize
+		return nil
+	}()
+	if err != nil || !full {
+		return err
+	}
+	return w.Flush()
+}
+
+// Pending returns the number of buffered writes.
+func (w *Writer) Pending() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return len(w.pending)
+}
+
+// Flush writes all of the buffered writes. It returns an errors.MultiError
+// with a *WriteError for each write which failed since errors were last
+// returned, or nil if none did.
+func (w *Writer) Flush() error {
+	w.flushAndKeepErrors()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	errs := w.errs
+	w.errs = nil
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Close stops the background flushes, flushes the buffered writes and closes
+// the Writer. It returns the errors of the Writer, like Flush.
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	w.closed = true
+	w.lock.Unlock()
+
+	w.stop()
+	if w.done != nil {
+		<-w.done
+	}
+	return w.Flush()
+}
+
+// flushAndKeepErrors writes all of the buffered writes, and keeps the errors
+// for the next Flush.
+func (w *Writer) flushAndKeepErrors() {
+	w.flushLock.Lock()
+	defer w.flushLock.Unlock()
+
+	w.lock.Lock()
+	pending := w.pending
+	w.pending, w.index = nil, map[string]int{}
+	w.lock.Unlock()
+
+	var (
+		putKeys, delKeys []*Key
+		putVals          []PropertyMap
+	)
+	for _, m := range pending {
+		if m.Op == MutationDelete {
+			delKeys = append(delKeys, m.Key)
+		} else {
+			putKeys = append(putKeys, m.Key)
+			putVals = append(putVals, m.Value)
+		}
+	}
+
+	var errs errors.MultiError
+	record := func(op MutationOp, keys []*Key, failed []error, err error) {
+		for i, k := range keys {
+			if failed[i] == nil {
+				// Only the whole call failed.
+				failed[i] = err
+			}
+			if failed[i] != nil {
+				errs = append(errs, &WriteError{op, k, failed[i]})
+			}
+		}
+	}
+	if len(putKeys) > 0 {
+		failed := make([]error, len(putKeys))
+		err := filterStop(Raw(w.c).PutMulti(putKeys, putVals, func(idx int, _ *Key, err error) error {
+			failed[idx] = err
+			return nil
+		}))
+		record(MutationUpsert, putKeys, failed, err)
+	}
+	if len(delKeys) > 0 {
+		failed := make([]error, len(delKeys))
+		err := filterStop(Raw(w.c).DeleteMulti(delKeys, func(idx int, err error) error {
+			failed[idx] = err
+			return nil
+		}))
+		record(MutationDelete, delKeys, failed, err)
+	}
+
+	if len(errs) > 0 {
+		w.lock.Lock()
+		w.errs = append(w.errs, errs...)
+		w.lock.Unlock()
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package errclass classifies the errors returned by the services of this
+// library, so callers don't have to compare against the sentinel errors of
+// each service:
+//
+//	switch err := ds.Get(c, ent); {
+//	case errclass.IsNotFound(err):
+//	  ...
+//	case errclass.IsTransient(err):
+//	  return err // retry later
+//	}
+//
+// The predicates look through errors annotated with the luci errors package,
+// and accept an errors.MultiError when all of its non-nil errors match, as
+// datastore.IsErrNoSuchEntity does.
+//
+// ForEach, Count and Split help to interpret the error of a batch operation
+// item by item.
+package errclass
+
+import (
+	ds "go.chromium.org/gae/service/datastore"
+	mc "go.chromium.org/gae/service/memcache"
+	tq "go.chromium.org/gae/service/taskqueue"
+
+	"go.chromium.org/luci/common/errors"
+	"go.chromium.org/luci/common/retry/transient"
+
+	"google.golang.org/appengine"
+)
+
+// IsNotFound returns true if err means that the requested object doesn't
+// exist: datastore.ErrNoSuchEntity and memcache.ErrCacheMiss.
+func IsNotFound(err error) bool {
+	return classify(err, func(err error) bool {
+		return err == ds.ErrNoSuchEntity || err == mc.ErrCacheMiss
+	})
+}
+
+// IsConflict returns true if err means that a write conflicted with another
+// write or with the existing state: datastore.ErrConcurrentTransaction,
+// datastore.ErrEntityExists, memcache.ErrCASConflict, memcache.ErrNotStored
+// and taskqueue.ErrTaskAlreadyAdded.
+func IsConflict(err error) bool {
+	return classify(err, func(err error) bool {
+		switch err {
+		case ds.ErrConcurrentTransaction, ds.ErrEntityExists,
+			mc.ErrCASConflict, mc.ErrNotStored,
+			tq.ErrTaskAlreadyAdded:
+			return true
+		}
+		return false
+	})
+}
+
+// IsTransient returns true if retrying the operation which failed with err
+// may succeed: errors tagged with transient.Tag, datastore.ErrConcurrentTransaction,
+// memcache.ErrServerError and App Engine API timeouts.
+func IsTransient(err error) bool {
+	return classify(err, func(err error) bool {
+		switch {
+		case transient.Tag.In(err):
+			return true
+		case err == ds.ErrConcurrentTransaction, err == mc.ErrServerError:
+			return true
+		}
+		return appengine.IsTimeoutError(err)
+	})
+}
+
+// IsOverQuota returns true if err means that the application ran out of a
+// quota of an App Engine API.
+func IsOverQuota(err error) bool {
+	return classify(err, appengine.IsOverQuota)
+}
+
+// classify returns true if err or, if it's annotated, one of the errors it
+// wraps matches. A MultiError must have at least one non-nil error, and all
+// of them must match.
+func classify(err error, match func(error) bool) bool {
+	for err != nil {
+		if match(err) {
+			return true
+		}
+		if me, ok := err.(errors.MultiError); ok {
+			return classifyMulti(me, match)
+		}
+		inner := errors.Unwrap(err)
+		if inner == err {
+			return false
+		}
+		err = inner
+	}
+	return false
+}
+
+func classifyMulti(me errors.MultiError, match func(error) bool) bool {
+	any := false
+	for _, err := range me {
+		if err == nil {
+			continue
+		}
+		if !classify(err, match) {
+			return false
+		}
+		any = true
+	}
+	return any
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errclass
+
+import (
+	"testing"
+
+	ds "go.chromium.org/gae/service/datastore"
+	mc "go.chromium.org/gae/service/memcache"
+	tq "go.chromium.org/gae/service/taskqueue"
+
+	"go.chromium.org/luci/common/errors"
+	"go.chromium.org/luci/common/retry/transient"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestErrClass(t *testing.T) {
+	t.Parallel()
+
+	Convey("Error classification", t, func() {
+		other := errors.New("other")
+
+		Convey("IsNotFound", func() {
+			So(IsNotFound(ds.ErrNoSuchEntity), ShouldBeTrue)
+			So(IsNotFound(mc.ErrCacheMiss), ShouldBeTrue)
+			So(IsNotFound(errors.Annotate(ds.ErrNoSuchEntity, "getting foo").Err()), ShouldBeTrue)
+			So(IsNotFound(other), ShouldBeFalse)
+			So(IsNotFound(nil), ShouldBeFalse)
+		})
+
+		Convey("IsConflict", func() {
+			for _, err := range []error{
+				ds.ErrConcurrentTransaction, ds.ErrEntityExists,
+				mc.ErrCASConflict, mc.ErrNotStored, tq.ErrTaskAlreadyAdded,
+			} {
+				So(IsConflict(err), ShouldBeTrue)
+			}
+			So(IsConflict(ds.ErrNoSuchEntity), ShouldBeFalse)
+		})
+
+		Convey("IsTransient", func() {
+			So(IsTransient(ds.ErrConcurrentTransaction), ShouldBeTrue)
+			So(IsTransient(mc.ErrServerError), ShouldBeTrue)
+			So(IsTransient(errors.Annotate(other, "flaky").Tag(transient.Tag).Err()), ShouldBeTrue)
+			So(IsTransient(other), ShouldBeFalse)
+		})
+
+		Convey("IsOverQuota", func() {
+			So(IsOverQuota(other), ShouldBeFalse)
+		})
+
+		Convey("MultiErrors match when all of their errors do", func() {
+			So(IsNotFound(errors.MultiError{nil, ds.ErrNoSuchEntity, nil}), ShouldBeTrue)
+			So(IsNotFound(errors.MultiError{ds.ErrNoSuchEntity, other}), ShouldBeFalse)
+			So(IsNotFound(errors.MultiError{nil, nil}), ShouldBeFalse)
+
+			nested := errors.MultiError{errors.MultiError{mc.ErrCacheMiss}, ds.ErrNoSuchEntity}
+			So(IsNotFound(nested), ShouldBeTrue)
+			So(IsNotFound(errors.Annotate(nested, "getting").Err()), ShouldBeTrue)
+		})
+	})
+}
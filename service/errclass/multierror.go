@@ -0,0 +1,69 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errclass
+
+import (
+	"go.chromium.org/luci/common/errors"
+)
+
+// ForEach calls cb once for each of the n items of a batch operation, like
+// datastore.GetMulti, which returned err, with that item's error.
+//
+// If err is nil, every item succeeded and cb gets a nil error for each of
+// them. If err is (possibly an annotated) errors.MultiError with n errors, it
+// is aligned with the items. Any other error, including a MultiError of a
+// different length, is the error of the whole operation and is passed for
+// every item.
+func ForEach(err error, n int, cb func(i int, err error)) {
+	me, ok := errors.Unwrap(err).(errors.MultiError)
+	if ok && len(me) != n {
+		ok = false
+	}
+	for i := 0; i < n; i++ {
+		if ok {
+			cb(i, me[i])
+		} else {
+			cb(i, err)
+		}
+	}
+}
+
+// Count returns the number of the n items of a batch operation which failed,
+// as interpreted by ForEach.
+func Count(err error, n int) (count int) {
+	ForEach(err, n, func(_ int, err error) {
+		if err != nil {
+			count++
+		}
+	})
+	return
+}
+
+// Split splits the indices of the n items of a batch operation which failed,
+// as interpreted by ForEach, into those whose error matches class (e.g.
+// IsNotFound) and the others. The indices of successful items are in neither.
+// Both slices are in increasing order, and nil if empty.
+func Split(err error, n int, class func(error) bool) (matched, other []int) {
+	ForEach(err, n, func(i int, err error) {
+		switch {
+		case err == nil:
+		case class(err):
+			matched = append(matched, i)
+		default:
+			other = append(other, i)
+		}
+	})
+	return
+}
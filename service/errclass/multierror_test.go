@@ -0,0 +1,73 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errclass
+
+import (
+	"testing"
+
+	ds "go.chromium.org/gae/service/datastore"
+
+	"go.chromium.org/luci/common/errors"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMultiError(t *testing.T) {
+	t.Parallel()
+
+	Convey("MultiError helpers", t, func() {
+		other := errors.New("other")
+		collect := func(err error, n int) []error {
+			var ret []error
+			ForEach(err, n, func(i int, err error) {
+				So(i, ShouldEqual, len(ret))
+				ret = append(ret, err)
+			})
+			return ret
+		}
+
+		Convey("nil means every item succeeded", func() {
+			So(collect(nil, 2), ShouldResemble, []error{nil, nil})
+			So(Count(nil, 2), ShouldEqual, 0)
+
+			matched, others := Split(nil, 2, IsNotFound)
+			So(matched, ShouldBeNil)
+			So(others, ShouldBeNil)
+		})
+
+		Convey("a MultiError is aligned with the items", func() {
+			err := errors.MultiError{nil, ds.ErrNoSuchEntity, other, ds.ErrNoSuchEntity}
+			So(collect(err, 4), ShouldResemble, []error(err))
+			So(collect(errors.Annotate(err, "getting").Err(), 4), ShouldResemble, []error(err))
+			So(Count(err, 4), ShouldEqual, 3)
+
+			matched, others := Split(err, 4, IsNotFound)
+			So(matched, ShouldResemble, []int{1, 3})
+			So(others, ShouldResemble, []int{2})
+		})
+
+		Convey("any other error applies to every item", func() {
+			So(collect(other, 2), ShouldResemble, []error{other, other})
+			So(Count(other, 2), ShouldEqual, 2)
+
+			me := errors.MultiError{other}
+			So(collect(me, 2), ShouldResemble, []error{me, me})
+
+			matched, others := Split(other, 2, IsNotFound)
+			So(matched, ShouldBeNil)
+			So(others, ShouldResemble, []int{0, 1})
+		})
+	})
+}
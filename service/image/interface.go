@@ -0,0 +1,54 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package image provides access to an image serving service, such as the
+// AppEngine Images API, through the Context.
+//
+// Images are identified by the Cloud Storage bucket and object which hold
+// them (see go.chromium.org/gae/service/storage).
+package image
+
+import (
+	"net/url"
+
+	"golang.org/x/net/context"
+)
+
+// RawInterface is the interface for all of the package methods which normally
+// would be in the 'image' package.
+type RawInterface interface {
+	GetServingURL(bucket, name string, opts *ServingURLOptions) (*url.URL, error)
+	DeleteServingURL(bucket, name string) error
+}
+
+// GetServingURL returns a URL which serves the image stored in object name of
+// bucket, resized and cropped according to opts (which may be nil).
+//
+// The URL remains valid until DeleteServingURL is called for the image. Other
+// sizes of the image can be derived from it with SizedURL.
+//
+// Returns an error if opts fails Validate.
+func GetServingURL(c context.Context, bucket, name string, opts *ServingURLOptions) (*url.URL, error) {
+	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return Raw(c).GetServingURL(bucket, name, opts)
+}
+
+// DeleteServingURL stops serving the image stored in object name of bucket.
+func DeleteServingURL(c context.Context, bucket, name string) error {
+	return Raw(c).DeleteServingURL(bucket, name)
+}
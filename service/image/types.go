@@ -0,0 +1,76 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// MaxSize is the largest size that an image may be served at.
+const MaxSize = 1600
+
+// ServingURLOptions are the optional parameters of GetServingURL.
+type ServingURLOptions struct {
+	// Secure, if true, returns an https URL.
+	Secure bool
+
+	// Size, if positive, resizes the image so that its longest dimension is Size
+	// pixels, preserving its aspect ratio. It must be no more than MaxSize.
+	Size int
+
+	// Crop, if true, crops the image to a square of Size pixels. It requires
+	// Size to be set.
+	Crop bool
+}
+
+// Validate returns an error if the options are invalid.
+func (o *ServingURLOptions) Validate() error {
+	switch {
+	case o.Size < 0 || o.Size > MaxSize:
+		return fmt.Errorf("image: size %d is not in [0, %d]", o.Size, MaxSize)
+	case o.Crop && o.Size == 0:
+		return fmt.Errorf("image: crop requires a size")
+	}
+	return nil
+}
+
+// SizedURL returns a copy of the serving URL u, which serves the image at size
+// pixels (cropped to a square if crop is true) instead. Any size or crop
+// option already in u is replaced.
+//
+// A size of 0 serves the image at its original size (up to a serving limit).
+func SizedURL(u *url.URL, size int, crop bool) *url.URL {
+	ret := *u
+	if i := strings.LastIndex(ret.Path, "="); i >= 0 && i > strings.LastIndex(ret.Path, "/") {
+		ret.Path = ret.Path[:i]
+	}
+	ret.RawPath = ""
+	if suffix := sizeSuffix(size, crop); suffix != "" {
+		ret.Path += "=" + suffix
+	}
+	return &ret
+}
+
+func sizeSuffix(size int, crop bool) string {
+	switch {
+	case size <= 0:
+		return ""
+	case crop:
+		return fmt.Sprintf("s%d-c", size)
+	}
+	return fmt.Sprintf("s%d", size)
+}
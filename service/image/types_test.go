@@ -0,0 +1,51 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestServingURLOptions(t *testing.T) {
+	t.Parallel()
+
+	Convey("ServingURLOptions", t, func() {
+		So((&ServingURLOptions{}).Validate(), ShouldBeNil)
+		So((&ServingURLOptions{Size: 32, Crop: true}).Validate(), ShouldBeNil)
+		So((&ServingURLOptions{Size: MaxSize + 1}).Validate(), ShouldErrLike, "is not in")
+		So((&ServingURLOptions{Crop: true}).Validate(), ShouldErrLike, "crop requires a size")
+	})
+}
+
+func TestSizedURL(t *testing.T) {
+	t.Parallel()
+
+	Convey("SizedURL", t, func() {
+		sized := func(u string, size int, crop bool) string {
+			pu, err := url.Parse(u)
+			So(err, ShouldBeNil)
+			return SizedURL(pu, size, crop).String()
+		}
+
+		So(sized("https://example.com/img/abc", 32, false), ShouldEqual, "https://example.com/img/abc=s32")
+		So(sized("https://example.com/img/abc=s32", 64, true), ShouldEqual, "https://example.com/img/abc=s64-c")
+		So(sized("https://example.com/img/abc=s64-c", 0, true), ShouldEqual, "https://example.com/img/abc")
+		So(sized("https://example.com/a=b/abc", 10, false), ShouldEqual, "https://example.com/a=b/abc=s10")
+	})
+}
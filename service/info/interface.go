@@ -56,6 +56,22 @@ type RawInterface interface {
 type Testable interface {
 	SetVersionID(string) context.Context
 	SetRequestID(string) context.Context
+
+	// SetModuleName sets the name of the current module, returned by ModuleName.
+	SetModuleName(string) context.Context
+	// SetInstanceID sets the ID of the current instance, returned by InstanceID.
+	SetInstanceID(string) context.Context
+	// SetDefaultVersionHostname sets the hostname returned by
+	// DefaultVersionHostname. ModuleHostname builds its hostnames on top of it.
+	SetDefaultVersionHostname(string) context.Context
+	// SetServiceAccount sets the service account name returned by
+	// ServiceAccount.
+	SetServiceAccount(string) context.Context
+
+	// SetModules defines the modules of the application, and their versions and
+	// instances. Once set, ModuleHostname returns an error for any module,
+	// version or instance not listed.
+	SetModules(...Module) context.Context
 }
 
 // AppID returns the current App ID.
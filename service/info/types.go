@@ -19,3 +19,19 @@ type Certificate struct {
 	KeyName string
 	Data    []byte // PEM-encoded X.509 certificate
 }
+
+// Module describes a module (a.k.a. service) of an application, and the
+// versions and instances of it which are deployed. It's used to describe the
+// application's topology to Testable implementations.
+type Module struct {
+	// Name is the name of the module, e.g. "default".
+	Name string
+
+	// Versions are the IDs of the deployed versions of the module. The first
+	// version is the module's default version.
+	Versions []string
+
+	// Instances are the IDs of the running instances of the module. If empty,
+	// any instance ID is accepted.
+	Instances []string
+}
@@ -18,4 +18,7 @@
 // "go.chromium.org/luci/common/logging" package. Both
 // "go.chromium.org/gae/impl/prod" and "go.chromium.org/gae/impl/memory"
 // implement that service appropriately.
+//
+// To query the application's request logs, use
+// "go.chromium.org/gae/service/logs".
 package logging
@@ -0,0 +1,52 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logs provides access to a service which queries the application's
+// request logs, such as the AppEngine Logs API, through the Context.
+//
+// To write logs, use "go.chromium.org/luci/common/logging".
+package logs
+
+import (
+	"golang.org/x/net/context"
+)
+
+// RawInterface is the interface for all of the package methods which normally
+// would be in the 'log' package.
+type RawInterface interface {
+	Run(q *Query, cb RunCB) error
+
+	GetTestable() Testable
+}
+
+// Run calls cb with each request log record which matches q, most recently
+// completed first. q may be nil, which matches all records.
+//
+// If cb returns Stop, Run stops and returns nil. If it returns any other error,
+// Run stops and returns that error.
+func Run(c context.Context, q *Query, cb RunCB) error {
+	if q == nil {
+		q = &Query{}
+	}
+	if err := Raw(c).Run(q, cb); err != Stop {
+		return err
+	}
+	return nil
+}
+
+// GetTestable returns a Testable for the current logs implementation, or nil if
+// there is none.
+func GetTestable(c context.Context) Testable {
+	return Raw(c).GetTestable()
+}
@@ -0,0 +1,32 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logs
+
+import (
+	"golang.org/x/net/context"
+)
+
+// Testable is the testable interface for fake logs implementations.
+type Testable interface {
+	// AddRecords adds request log records. Application logs written in
+	// a request with the same ID are added to the existing record.
+	AddRecords(recs ...*Record)
+
+	// CaptureLogs returns a derivative of c whose logger adds every message to
+	// the record of the current request (per info.RequestID) as an application
+	// log, creating the record if necessary. Messages are also passed on to c's
+	// logger.
+	CaptureLogs(c context.Context) context.Context
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logs
+
+import (
+	"errors"
+	"time"
+
+	"go.chromium.org/luci/common/logging"
+)
+
+// Stop may be returned by a RunCB to stop iterating without an error.
+var Stop = errors.New("logs: stop iteration")
+
+// RunCB is the callback for Run.
+type RunCB func(*Record) error
+
+// Query selects request log records.
+type Query struct {
+	// StartTime and EndTime, if set, select records for requests which completed
+	// in [StartTime, EndTime).
+	StartTime time.Time
+	EndTime   time.Time
+
+	// AppLogs, if true, includes the application logs in each record.
+	AppLogs bool
+
+	// ApplyMinLevel, if true, only selects records with at least one
+	// application log at or above MinLevel.
+	ApplyMinLevel bool
+	MinLevel      logging.Level
+
+	// Versions, if set, only selects records from these versions. Versions of
+	// non-default modules are written as "module:version".
+	Versions []string
+
+	// RequestIDs, if set, only selects records for these requests.
+	RequestIDs []string
+}
+
+// AppLog is a single application log line.
+type AppLog struct {
+	Time    time.Time
+	Level   logging.Level
+	Message string
+}
+
+// Record is the log record of a single request.
+type Record struct {
+	AppID     string
+	ModuleID  string
+	VersionID string
+	RequestID string
+
+	StartTime time.Time
+	EndTime   time.Time
+	Latency   time.Duration
+
+	Method   string
+	Resource string
+	Status   int32
+
+	IP        string
+	UserAgent string
+	Host      string
+
+	// AppLogs are the application logs of the request. They are only populated
+	// if Query.AppLogs was set.
+	AppLogs []AppLog
+}
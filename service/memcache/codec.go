@@ -0,0 +1,158 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memcache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Codec encodes and decodes Go values to and from memcache item values.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// Gob is a Codec which uses encoding/gob.
+	Gob Codec = gobCodec{}
+
+	// JSON is a Codec which uses encoding/json.
+	JSON Codec = jsonCodec{}
+)
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// ObjectCache stores Go values in memcache, encoded with a Codec.
+//
+// The memcache key of an object is derived from its key, Prefix and Version,
+// so that several ObjectCaches (e.g. for different types) can share memcache
+// without colliding, and so that bumping Version when the encoded type changes
+// makes all of the previously cached objects miss, rather than fail to decode.
+//
+// The zero value is a valid ObjectCache, which uses the Gob codec.
+type ObjectCache struct {
+	// Codec is used to encode and decode objects. If nil, Gob is used.
+	Codec Codec
+
+	// Prefix is prepended to every key.
+	Prefix string
+
+	// Version is the version of the encoded objects. If not zero, it's included
+	// in every key.
+	Version int
+}
+
+func (oc *ObjectCache) codec() Codec {
+	if oc.Codec != nil {
+		return oc.Codec
+	}
+	return Gob
+}
+
+// Key returns the memcache key used to store the object with key.
+func (oc *ObjectCache) Key(key string) string {
+	if oc.Version != 0 {
+		return fmt.Sprintf("%sv%d:%s", oc.Prefix, oc.Version, key)
+	}
+	return oc.Prefix + key
+}
+
+func (oc *ObjectCache) mkItem(c context.Context, key string, v interface{}, exp time.Duration) (Item, error) {
+	data, err := oc.codec().Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return NewItem(c, oc.Key(key)).SetValue(data).SetExpiration(exp), nil
+}
+
+// SetObject encodes v and writes it to memcache unconditionally. An exp of 0
+// means that the object has no expiration time.
+func (oc *ObjectCache) SetObject(c context.Context, key string, v interface{}, exp time.Duration) error {
+	itm, err := oc.mkItem(c, key, v, exp)
+	if err != nil {
+		return err
+	}
+	return Set(c, itm)
+}
+
+// AddObject is like SetObject, but only writes v if there's no object with key
+// in memcache, and returns ErrNotStored otherwise.
+func (oc *ObjectCache) AddObject(c context.Context, key string, v interface{}, exp time.Duration) error {
+	itm, err := oc.mkItem(c, key, v, exp)
+	if err != nil {
+		return err
+	}
+	return Add(c, itm)
+}
+
+// GetObject reads the object with key from memcache, and decodes it into v,
+// which must be a pointer.
+//
+// Returns ErrCacheMiss if there is no such object.
+func (oc *ObjectCache) GetObject(c context.Context, key string, v interface{}) error {
+	itm, err := GetKey(c, oc.Key(key))
+	if err != nil {
+		return err
+	}
+	return oc.codec().Unmarshal(itm.Value(), v)
+}
+
+// DeleteObject deletes the object with key from memcache.
+//
+// Returns ErrCacheMiss if there is no such object.
+func (oc *ObjectCache) DeleteObject(c context.Context, key string) error {
+	return Delete(c, oc.Key(key))
+}
+
+// SetObject is ObjectCache.SetObject on a zero ObjectCache, using the Gob codec with
+// no key prefix or version.
+func SetObject(c context.Context, key string, v interface{}, exp time.Duration) error {
+	return (&ObjectCache{}).SetObject(c, key, v, exp)
+}
+
+// AddObject is ObjectCache.AddObject on a zero ObjectCache, using the Gob codec with
+// no key prefix or version.
+func AddObject(c context.Context, key string, v interface{}, exp time.Duration) error {
+	return (&ObjectCache{}).AddObject(c, key, v, exp)
+}
+
+// GetObject is ObjectCache.GetObject on a zero ObjectCache, using the Gob codec with
+// no key prefix or version.
+func GetObject(c context.Context, key string, v interface{}) error {
+	return (&ObjectCache{}).GetObject(c, key, v)
+}
@@ -15,6 +15,8 @@
 package memcache
 
 import (
+	"go.chromium.org/gae/service/tuning"
+
 	"golang.org/x/net/context"
 )
 
@@ -37,14 +39,21 @@ type RawFilter func(context.Context, RawInterface) RawInterface
 // getUnfiltered gets gets the RawInterface implementation from context without
 // any of the filters applied.
 func getUnfiltered(c context.Context) RawInterface {
-	if f, ok := c.Value(memcacheKey).(RawFactory); ok && f != nil {
+	if f := getFactory(c); f != nil {
 		return f(c)
 	}
 	return nil
 }
 
-// Raw gets the current memcache implementation from the context.
-func Raw(c context.Context) RawInterface {
+// getFactory returns the RawFactory installed in c, or nil if there is none.
+func getFactory(c context.Context) RawFactory {
+	f, _ := c.Value(memcacheKey).(RawFactory)
+	return f
+}
+
+// rawWithFilters gets the memcache implementation, and applies all of the
+// currently installed filters to it.
+func rawWithFilters(c context.Context) RawInterface {
 	ret := getUnfiltered(c)
 	if ret == nil {
 		return nil
@@ -55,6 +64,17 @@ func Raw(c context.Context) RawInterface {
 	return ret
 }
 
+// Raw gets the current memcache implementation from the context.
+//
+// If a tuning.Memcache Deadline is installed in c, each call made with the
+// returned RawInterface has that deadline.
+func Raw(c context.Context) RawInterface {
+	if d := tuning.Get(c).Memcache.Deadline; d > 0 && getFactory(c) != nil {
+		return &deadlineRaw{c, d}
+	}
+	return rawWithFilters(c)
+}
+
 // SetRawFactory sets the function to produce RawInterface instances, as returned by
 // the Get method.
 func SetRawFactory(c context.Context, mcf RawFactory) context.Context {
@@ -0,0 +1,91 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memcache
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// deadlineRaw is the RawInterface returned by Raw when a tuning.Memcache
+// Deadline is installed. Each memcache call builds the filter chain on a
+// Context with the deadline, since a RawInterface is bound to its Context.
+type deadlineRaw struct {
+	c        context.Context
+	deadline time.Duration
+}
+
+func (d *deadlineRaw) withDeadline() (RawInterface, context.CancelFunc) {
+	c, cancel := context.WithTimeout(d.c, d.deadline)
+	return rawWithFilters(c), cancel
+}
+
+// NewItem makes no call, so it doesn't need the deadline.
+func (d *deadlineRaw) NewItem(key string) Item { return rawWithFilters(d.c).NewItem(key) }
+
+func (d *deadlineRaw) AddMulti(items []Item, cb RawCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.AddMulti(items, cb)
+}
+
+func (d *deadlineRaw) SetMulti(items []Item, cb RawCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.SetMulti(items, cb)
+}
+
+func (d *deadlineRaw) GetMulti(keys []string, cb RawItemCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.GetMulti(keys, cb)
+}
+
+func (d *deadlineRaw) DeleteMulti(keys []string, cb RawCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.DeleteMulti(keys, cb)
+}
+
+func (d *deadlineRaw) CompareAndSwapMulti(items []Item, cb RawCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.CompareAndSwapMulti(items, cb)
+}
+
+func (d *deadlineRaw) Increment(key string, delta int64, initialValue *uint64) (uint64, error) {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.Increment(key, delta, initialValue)
+}
+
+func (d *deadlineRaw) Touch(key string, expiration time.Duration) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.Touch(key, expiration)
+}
+
+func (d *deadlineRaw) Flush() error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.Flush()
+}
+
+func (d *deadlineRaw) Stats() (*Statistics, error) {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.Stats()
+}
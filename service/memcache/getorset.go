@@ -0,0 +1,149 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memcache
+
+import (
+	"time"
+
+	"go.chromium.org/luci/common/clock"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// DefaultLockExpiration is the expiration of the lock taken by a Loader
+	// with no LockExpiration set.
+	DefaultLockExpiration = 10 * time.Second
+
+	// DefaultLoaderWait is how long a Loader with no Wait set waits for
+	// another instance to compute a value.
+	DefaultLoaderWait = 2 * time.Second
+
+	// DefaultLoaderPollInterval is how often a Loader with no PollInterval set
+	// checks whether another instance has computed a value.
+	DefaultLoaderPollInterval = 100 * time.Millisecond
+)
+
+// GetOrSetCB computes the value to cache on a cache miss.
+type GetOrSetCB func() ([]byte, error)
+
+// Loader reads values from memcache, computing them on a miss while
+// protecting against cache stampedes: when many requests miss the same key at
+// once, only the one which takes a short-lived lock (with Add) computes the
+// value, while the others serve a stale copy of it or wait for it to appear.
+//
+// The zero value is a valid Loader, which caches values with no expiration
+// time and doesn't keep stale copies.
+type Loader struct {
+	// Expiration is the expiration of the cached values. If zero, values never
+	// expire.
+	Expiration time.Duration
+
+	// StaleExpiration, if not zero, makes the Loader also store a copy of every
+	// value it computes with this expiration, which should be longer than
+	// Expiration. While another instance is computing a new value, the stale
+	// copy is returned instead of waiting.
+	StaleExpiration time.Duration
+
+	// LockExpiration is the expiration of the lock, which bounds how long
+	// other instances wait for a computation which died. If zero,
+	// DefaultLockExpiration is used.
+	LockExpiration time.Duration
+
+	// Wait is how long to wait for another instance to compute the value,
+	// before giving up and computing it anyway. If zero, DefaultLoaderWait is
+	// used.
+	Wait time.Duration
+
+	// PollInterval is how often to check for the value while waiting. If zero,
+	// DefaultLoaderPollInterval is used.
+	PollInterval time.Duration
+}
+
+func lockKey(key string) string  { return "gae:lock:" + key }
+func staleKey(key string) string { return "gae:stale:" + key }
+
+func orDefault(v, def time.Duration) time.Duration {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+// GetOrSet returns the value of key from memcache. On a cache miss, it either
+// computes the value with cb and caches it, or waits for another instance
+// which is already doing so (see Loader).
+//
+// Errors from memcache are not returned: if memcache fails, the value is
+// computed with cb. Errors from cb are returned as-is, and nothing is cached.
+func (l *Loader) GetOrSet(c context.Context, key string, cb GetOrSetCB) ([]byte, error) {
+	switch itm, err := GetKey(c, key); err {
+	case nil:
+		return itm.Value(), nil
+	case ErrCacheMiss:
+	default:
+		return cb()
+	}
+
+	lock := NewItem(c, lockKey(key)).SetExpiration(orDefault(l.LockExpiration, DefaultLockExpiration))
+	switch err := Add(c, lock); err {
+	case nil:
+		defer Delete(c, lock.Key())
+		return l.compute(c, key, cb)
+	case ErrNotStored:
+	default:
+		return cb()
+	}
+
+	// Someone else is computing the value.
+	if l.StaleExpiration > 0 {
+		if itm, err := GetKey(c, staleKey(key)); err == nil {
+			return itm.Value(), nil
+		}
+	}
+
+	deadline := clock.Now(c).Add(orDefault(l.Wait, DefaultLoaderWait))
+	for clock.Now(c).Before(deadline) {
+		if tr := clock.Sleep(c, orDefault(l.PollInterval, DefaultLoaderPollInterval)); tr.Incomplete() {
+			return nil, tr.Err
+		}
+		if itm, err := GetKey(c, key); err == nil {
+			return itm.Value(), nil
+		}
+	}
+	return l.compute(c, key, cb)
+}
+
+// compute computes the value of key with cb, and caches it.
+func (l *Loader) compute(c context.Context, key string, cb GetOrSetCB) ([]byte, error) {
+	val, err := cb()
+	if err != nil {
+		return nil, err
+	}
+
+	items := []Item{NewItem(c, key).SetValue(val).SetExpiration(l.Expiration)}
+	if l.StaleExpiration > 0 {
+		items = append(items, NewItem(c, staleKey(key)).SetValue(val).SetExpiration(l.StaleExpiration))
+	}
+	// Caching is best-effort.
+	_ = Set(c, items...)
+	return val, nil
+}
+
+// GetOrSet is Loader.GetOrSet on a Loader with the given Expiration and
+// default settings otherwise.
+func GetOrSet(c context.Context, key string, exp time.Duration, cb GetOrSetCB) ([]byte, error) {
+	return (&Loader{Expiration: exp}).GetOrSet(c, key, cb)
+}
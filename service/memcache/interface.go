@@ -15,6 +15,8 @@
 package memcache
 
 import (
+	"time"
+
 	"go.chromium.org/luci/common/errors"
 	"golang.org/x/net/context"
 )
@@ -181,6 +183,18 @@ func IncrementExisting(c context.Context, key string, delta int64) (uint64, erro
 	return Raw(c).Increment(key, delta, nil)
 }
 
+// Touch changes the expiration of the item at key to expiration from now,
+// without rewriting its value. An expiration of 0 means that the item never
+// expires.
+//
+// This allows sliding expiration (e.g. of sessions) without having to Get and
+// Set the item again.
+//
+// Returns ErrCacheMiss if the item is not in memcache.
+func Touch(c context.Context, key string, expiration time.Duration) error {
+	return Raw(c).Touch(key, expiration)
+}
+
 // Flush dumps the entire memcache state.
 func Flush(c context.Context) error {
 	return Raw(c).Flush()
@@ -0,0 +1,122 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memcache
+
+import (
+	"fmt"
+	"time"
+
+	"go.chromium.org/gae/service/tuning"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultMutateAttempts is the number of attempts used by a Mutator with no
+// Attempts set.
+const DefaultMutateAttempts = 10
+
+// MutateCB is called by Mutate with the current value of the memcache key, and
+// returns its new value. current is nil if the key is not in memcache.
+//
+// MutateCB may be called several times, and must not have side effects which
+// would make that unsafe. If it returns an error, Mutate stops and returns it
+// without writing anything.
+type MutateCB func(current []byte) ([]byte, error)
+
+// ErrMutateConflict is returned by Mutate when the value of the key kept being
+// changed by someone else, and all of the attempts to change it failed.
+type ErrMutateConflict struct {
+	Key      string
+	Attempts int
+}
+
+func (e *ErrMutateConflict) Error() string {
+	return fmt.Sprintf("memcache: failed to mutate %q in %d attempt(s) due to conflicts", e.Key, e.Attempts)
+}
+
+// Mutator changes memcache values with a Get / modify / CompareAndSwap loop.
+//
+// The zero value is a valid Mutator.
+type Mutator struct {
+	// Attempts is the maximum number of times the value is read and written
+	// before giving up. If zero, the MutateAttempts of the tuning.Options
+	// installed in the Context is used, or DefaultMutateAttempts if there is
+	// none.
+	Attempts int
+
+	// Expiration is the expiration of the written value. If zero, the value has
+	// no expiration time.
+	Expiration time.Duration
+}
+
+// Mutate changes the value of key to the value returned by cb.
+//
+// If the key is not in memcache, its new value is added with Add. Otherwise
+// it's written with CompareAndSwap. If either fails because the key was
+// changed, added or evicted since it was read, cb is called again with the
+// new current value.
+//
+// Returns an *ErrMutateConflict if none of the attempts succeeded, or the
+// first error from cb or memcache.
+func (m *Mutator) Mutate(c context.Context, key string, cb MutateCB) error {
+	attempts := m.Attempts
+	if attempts <= 0 {
+		attempts = tuning.Get(c).Memcache.MutateAttempts
+	}
+	if attempts <= 0 {
+		attempts = DefaultMutateAttempts
+	}
+
+	for i := 0; i < attempts; i++ {
+		itm, err := GetKey(c, key)
+		exists := true
+		switch err {
+		case nil:
+		case ErrCacheMiss:
+			exists = false
+		default:
+			return err
+		}
+
+		var cur []byte
+		if exists {
+			cur = itm.Value()
+		}
+		val, err := cb(cur)
+		if err != nil {
+			return err
+		}
+		itm.SetValue(val).SetExpiration(m.Expiration)
+
+		if exists {
+			err = CompareAndSwap(c, itm)
+		} else {
+			err = Add(c, itm)
+		}
+		switch err {
+		case ErrCASConflict, ErrNotStored:
+			continue
+		default:
+			return err
+		}
+	}
+	return &ErrMutateConflict{key, attempts}
+}
+
+// Mutate is Mutator.Mutate on a zero Mutator, which makes DefaultMutateAttempts
+// attempts and writes values with no expiration time.
+func Mutate(c context.Context, key string, cb MutateCB) error {
+	return (&Mutator{}).Mutate(c, key, cb)
+}
@@ -14,6 +14,10 @@
 
 package memcache
 
+import (
+	"time"
+)
+
 // RawCB is a simple error callback for most of the methods in RawInterface.
 type RawCB func(error)
 
@@ -34,6 +38,8 @@ type RawInterface interface {
 
 	Increment(key string, delta int64, initialValue *uint64) (newValue uint64, err error)
 
+	Touch(key string, expiration time.Duration) error
+
 	Flush() error
 
 	Stats() (*Statistics, error)
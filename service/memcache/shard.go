@@ -0,0 +1,213 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultShardSize is the shard size of a ShardedCache with no ShardSize set.
+// It leaves room below the 1MB memcache item limit for the key and the item
+// overhead.
+const DefaultShardSize = 1000 * 1000
+
+// ErrCorruptShards is returned when reading a sharded value whose shards don't
+// match its manifest, e.g. because some shards were overwritten.
+var ErrCorruptShards = errors.New("memcache: sharded value is corrupt")
+
+const (
+	shardInline   byte = 0
+	shardManifest byte = 1
+
+	// shardManifestSize is the size of an encoded manifest: the header byte,
+	// the shard count, the total size and the SHA256 of the value.
+	shardManifestSize = 1 + 4 + 8 + sha256.Size
+)
+
+// ShardedCache stores values which may be larger than the memcache item limit,
+// by splitting them across several memcache items.
+//
+// Values which fit in a single shard are stored inline at their key. Larger
+// values are stored as a manifest at their key, holding the number of shards,
+// the size and the SHA256 of the value, and as shards at keys derived from
+// the key and the hash. Since shards are keyed by the hash, concurrent writes
+// of different values can't mix their shards, and the hash is checked when
+// the value is read back.
+//
+// Values written with a ShardedCache must be read with one, since they are
+// stored with a header.
+//
+// The zero value is a valid ShardedCache.
+type ShardedCache struct {
+	// ShardSize is the maximum size of a shard. If zero, DefaultShardSize is
+	// used.
+	ShardSize int
+}
+
+func (s *ShardedCache) shardSize() int {
+	if s.ShardSize > 0 {
+		return s.ShardSize
+	}
+	return DefaultShardSize
+}
+
+func shardKey(key string, hash []byte, i int) string {
+	return fmt.Sprintf("gae:shard:%s:%x:%d", key, hash[:8], i)
+}
+
+type shardManifestData struct {
+	count int
+	size  int64
+	hash  []byte
+}
+
+func (m *shardManifestData) encode() []byte {
+	ret := make([]byte, shardManifestSize)
+	ret[0] = shardManifest
+	binary.BigEndian.PutUint32(ret[1:], uint32(m.count))
+	binary.BigEndian.PutUint64(ret[5:], uint64(m.size))
+	copy(ret[13:], m.hash)
+	return ret
+}
+
+func decodeShardManifest(data []byte) (*shardManifestData, error) {
+	if len(data) != shardManifestSize || data[0] != shardManifest {
+		return nil, ErrCorruptShards
+	}
+	return &shardManifestData{
+		count: int(binary.BigEndian.Uint32(data[1:])),
+		size:  int64(binary.BigEndian.Uint64(data[5:])),
+		hash:  data[13:],
+	}, nil
+}
+
+// SetLarge writes value to memcache at key unconditionally, splitting it into
+// shards if it's too large for a single item. An exp of 0 means that the value
+// has no expiration time.
+func (s *ShardedCache) SetLarge(c context.Context, key string, value []byte, exp time.Duration) error {
+	size := s.shardSize()
+	if len(value) < size {
+		buf := make([]byte, 1+len(value))
+		buf[0] = shardInline
+		copy(buf[1:], value)
+		return Set(c, NewItem(c, key).SetValue(buf).SetExpiration(exp))
+	}
+
+	hash := sha256.Sum256(value)
+	m := &shardManifestData{
+		count: (len(value) + size - 1) / size,
+		size:  int64(len(value)),
+		hash:  hash[:],
+	}
+	shards := make([]Item, 0, m.count)
+	for i := 0; i < m.count; i++ {
+		end := (i + 1) * size
+		if end > len(value) {
+			end = len(value)
+		}
+		shards = append(shards, NewItem(c, shardKey(key, m.hash, i)).SetValue(value[i*size:end]).SetExpiration(exp))
+	}
+
+	// Write the shards first, so that the manifest never refers to missing
+	// shards (unless they are evicted).
+	if err := Set(c, shards...); err != nil {
+		return err
+	}
+	return Set(c, NewItem(c, key).SetValue(m.encode()).SetExpiration(exp))
+}
+
+// GetLarge reads the value at key written by SetLarge, reassembling it from its
+// shards if necessary.
+//
+// Returns ErrCacheMiss if the value or any of its shards are not in memcache,
+// and ErrCorruptShards if the shards don't match the manifest.
+func (s *ShardedCache) GetLarge(c context.Context, key string) ([]byte, error) {
+	itm, err := GetKey(c, key)
+	if err != nil {
+		return nil, err
+	}
+	data := itm.Value()
+	if len(data) > 0 && data[0] == shardInline {
+		return data[1:], nil
+	}
+
+	m, err := decodeShardManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]Item, m.count)
+	for i := range shards {
+		shards[i] = NewItem(c, shardKey(key, m.hash, i))
+	}
+	if err := Get(c, shards...); err != nil {
+		if errors.Contains(err, ErrCacheMiss) {
+			return nil, ErrCacheMiss
+		}
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, m.size))
+	for _, shard := range shards {
+		buf.Write(shard.Value())
+	}
+	ret := buf.Bytes()
+	if hash := sha256.Sum256(ret); int64(len(ret)) != m.size || !bytes.Equal(hash[:], m.hash) {
+		return nil, ErrCorruptShards
+	}
+	return ret, nil
+}
+
+// DeleteLarge deletes the value at key written by SetLarge, along with its
+// shards.
+//
+// Returns ErrCacheMiss if the value is not in memcache.
+func (s *ShardedCache) DeleteLarge(c context.Context, key string) error {
+	itm, err := GetKey(c, key)
+	if err != nil {
+		return err
+	}
+	if m, err := decodeShardManifest(itm.Value()); err == nil {
+		keys := make([]string, m.count)
+		for i := range keys {
+			keys[i] = shardKey(key, m.hash, i)
+		}
+		// Shards may have been evicted already, which is fine.
+		_ = Delete(c, keys...)
+	}
+	return Delete(c, key)
+}
+
+// SetLarge is ShardedCache.SetLarge on a zero ShardedCache.
+func SetLarge(c context.Context, key string, value []byte, exp time.Duration) error {
+	return (&ShardedCache{}).SetLarge(c, key, value, exp)
+}
+
+// GetLarge is ShardedCache.GetLarge on a zero ShardedCache.
+func GetLarge(c context.Context, key string) ([]byte, error) {
+	return (&ShardedCache{}).GetLarge(c, key)
+}
+
+// DeleteLarge is ShardedCache.DeleteLarge on a zero ShardedCache.
+func DeleteLarge(c context.Context, key string) error {
+	return (&ShardedCache{}).DeleteLarge(c, key)
+}
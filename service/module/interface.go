@@ -26,8 +26,11 @@ type RawInterface interface {
 	SetNumInstances(module, version string, instances int) error
 	Versions(module string) ([]string, error)
 	DefaultVersion(module string) (string, error)
+	Hostname(module, version, instance string) (string, error)
 	Start(module, version string) error
 	Stop(module, version string) error
+
+	GetTestable() Testable
 }
 
 // List lists the names of modules belonging to this application.
@@ -65,6 +68,15 @@ func DefaultVersion(c context.Context, module string) (string, error) {
 	return Raw(c).DefaultVersion(module)
 }
 
+// Hostname returns the hostname of the specified module/version/instance, which
+// can be used to send requests to it.
+//
+// If module or version is the empty string, it means the default. If instance
+// is the empty string, the hostname addresses any instance.
+func Hostname(c context.Context, module, version, instance string) (string, error) {
+	return Raw(c).Hostname(module, version, instance)
+}
+
 // Start starts the specified module/version.
 //
 // If module or version is the empty string, it means the default.
@@ -78,3 +90,9 @@ func Start(c context.Context, module, version string) error {
 func Stop(c context.Context, module, version string) error {
 	return Raw(c).Stop(module, version)
 }
+
+// GetTestable returns a testable extension interface, or nil if one is not
+// available.
+func GetTestable(c context.Context) Testable {
+	return Raw(c).GetTestable()
+}
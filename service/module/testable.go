@@ -0,0 +1,43 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package module
+
+// Call is a call to one of the lifecycle methods (Start, Stop or
+// SetNumInstances) of a module service, as recorded by a Testable.
+type Call struct {
+	// Method is the name of the method: "Start", "Stop" or "SetNumInstances".
+	Method string
+
+	Module  string
+	Version string
+
+	// Instances is the number of instances passed to SetNumInstances.
+	Instances int
+}
+
+// Testable is the interface for module service implementations which are able
+// to be tested (like impl/memory).
+type Testable interface {
+	// Calls returns the calls made to the lifecycle methods, in order. Calls
+	// which returned an error are included.
+	Calls() []Call
+
+	// ResetCalls clears the recorded calls.
+	ResetCalls()
+
+	// SetError makes the lifecycle method named method return err, without
+	// any effect. A nil err restores the method's normal behavior.
+	SetError(method string, err error)
+}
@@ -0,0 +1,47 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package runtime provides access to the resource usage of the current
+// instance, such as that reported by the AppEngine runtime package, through
+// the Context.
+//
+// It's intended for load-shedding logic, e.g.:
+//
+//	if st, err := runtime.GetStats(c); err == nil && st.RAM.Current > limitMB {
+//		// Reject the request.
+//	}
+package runtime
+
+import (
+	"golang.org/x/net/context"
+)
+
+// RawInterface is the interface for all of the package methods which normally
+// would be in the 'runtime' package.
+type RawInterface interface {
+	Stats() (*Stats, error)
+
+	GetTestable() Testable
+}
+
+// GetStats returns the current resource usage of the instance.
+func GetStats(c context.Context) (*Stats, error) {
+	return Raw(c).Stats()
+}
+
+// GetTestable returns a Testable for the current runtime implementation, or nil
+// if there is none.
+func GetTestable(c context.Context) Testable {
+	return Raw(c).GetTestable()
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"time"
+)
+
+// Stats is the resource usage of an instance.
+type Stats struct {
+	// CPU is the CPU consumed by the instance, in megacycles.
+	CPU CPUStats
+
+	// RAM is the memory used by the instance, in megabytes.
+	RAM RAMStats
+
+	// Uptime is how long the instance has been running.
+	Uptime time.Duration
+}
+
+// CPUStats is the CPU consumption of an instance, in megacycles.
+type CPUStats struct {
+	Total   float64
+	Rate1M  float64 // consumption rate over one minute
+	Rate10M float64 // consumption rate over ten minutes
+}
+
+// RAMStats is the memory usage of an instance, in megabytes.
+type RAMStats struct {
+	Current    float64
+	Average1M  float64 // average usage over one minute
+	Average10M float64 // average usage over ten minutes
+}
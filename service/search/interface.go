@@ -0,0 +1,78 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package search provides access to a full-text search service, such as the
+// AppEngine Search API, through the Context.
+//
+// Documents are lists of Fields, stored in named indexes under string IDs.
+// Indexes are scoped to the Context's namespace.
+//
+// Queries are made up of space-separated terms, all of which must match
+// a document for it to be returned:
+//
+//	word          any text field contains the word
+//	field:word    the field contains the word (or, for atoms, equals it)
+//	field=N       the number (or date) field equals N
+//	field<N       also field<=N, field>N and field>=N
+//	NOT term      the term doesn't match
+//
+// Words are matched case-insensitively. Dates are written as "2006-01-02".
+// Implementations may support richer query languages (the AppEngine Search API
+// does), but portable code should stick to the above.
+package search
+
+import (
+	"golang.org/x/net/context"
+)
+
+// RawInterface is the interface for all of the package methods which normally
+// would be in the 'search' package.
+type RawInterface interface {
+	Put(index, id string, doc []Field) (string, error)
+	Get(index, id string) ([]Field, error)
+	Delete(index, id string) error
+	Search(index, query string, opts *SearchOptions, cb SearchCB) error
+}
+
+// Put stores doc in index under id, replacing any existing document with that
+// ID. If id is empty, a new unique ID is generated. Returns the document's ID.
+func Put(c context.Context, index, id string, doc []Field) (string, error) {
+	return Raw(c).Put(index, id, doc)
+}
+
+// Get returns the document stored in index under id.
+//
+// Returns ErrNoSuchDocument if there is no such document.
+func Get(c context.Context, index, id string) ([]Field, error) {
+	return Raw(c).Get(index, id)
+}
+
+// Delete deletes the document stored in index under id.
+//
+// Returns ErrNoSuchDocument if there is no such document.
+func Delete(c context.Context, index, id string) error {
+	return Raw(c).Delete(index, id)
+}
+
+// Search calls cb with each document in index which matches query. See the
+// package documentation for the query syntax.
+//
+// opts may be nil. If cb returns Stop, Search stops and returns nil. If it
+// returns any other error, Search stops and returns that error.
+func Search(c context.Context, index, query string, opts *SearchOptions, cb SearchCB) error {
+	if err := Raw(c).Search(index, query, opts, cb); err != Stop {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNoSuchDocument is returned when a document doesn't exist.
+	ErrNoSuchDocument = errors.New("search: no such document")
+
+	// Stop may be returned by a SearchCB to stop searching without an error.
+	Stop = errors.New("search: stop iteration")
+)
+
+// Atom is a field value which is matched as a whole, rather than being
+// tokenized into words.
+type Atom string
+
+// HTML is a text field value containing HTML markup. Only its text content is
+// searchable.
+type HTML string
+
+// Field is a named field of a document.
+//
+// Value must be one of string (text), Atom, HTML, float64 or time.Time.
+// A document may have several fields with the same name.
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// SearchCB is the callback for Search. It receives the ID of each matching
+// document, along with the document itself unless SearchOptions.IDsOnly was
+// set.
+type SearchCB func(id string, doc []Field) error
+
+// SortExpression sorts search results by a field.
+type SortExpression struct {
+	// Field is the name of the field to sort by.
+	Field string
+
+	// Reverse sorts in descending, rather than ascending, order.
+	Reverse bool
+}
+
+// SearchOptions are the optional parameters of Search.
+type SearchOptions struct {
+	// Limit, if positive, is the maximum number of documents to return.
+	Limit int
+
+	// IDsOnly, if true, only returns document IDs.
+	IDsOnly bool
+
+	// Sort is the order to return documents in. Documents which compare equal
+	// (and all documents, if Sort is empty) are returned most recently put
+	// first.
+	Sort []SortExpression
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package socket provides outbound network connections, such as those of the
+// AppEngine Sockets API, through the Context.
+package socket
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// RawInterface is the interface for all of the package methods which normally
+// would be in the 'socket' package.
+type RawInterface interface {
+	Dial(network, addr string, timeout time.Duration) (net.Conn, error)
+	LookupIP(host string) ([]net.IP, error)
+
+	GetTestable() Testable
+}
+
+// Dial connects to addr on network, which is "tcp" or "udp". The address is of
+// the form "host:port", where host may be a hostname or an IP address.
+//
+// The connection is only guaranteed to be usable while c is valid.
+func Dial(c context.Context, network, addr string) (net.Conn, error) {
+	return Raw(c).Dial(network, addr, 0)
+}
+
+// DialTimeout is like Dial, but fails if the connection isn't established
+// within timeout. The timeout includes name resolution.
+func DialTimeout(c context.Context, network, addr string, timeout time.Duration) (net.Conn, error) {
+	return Raw(c).Dial(network, addr, timeout)
+}
+
+// LookupIP returns the IP addresses of host.
+func LookupIP(c context.Context, host string) ([]net.IP, error) {
+	return Raw(c).LookupIP(host)
+}
+
+// GetTestable returns a Testable for the current socket implementation, or nil
+// if there is none.
+func GetTestable(c context.Context) Testable {
+	return Raw(c).GetTestable()
+}
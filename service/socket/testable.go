@@ -0,0 +1,44 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socket
+
+import (
+	"net"
+)
+
+// PeerFunc scripts the behavior of a fake peer. It's called in its own
+// goroutine with the peer's end of each new connection, and should close conn
+// when it's done.
+type PeerFunc func(conn net.Conn)
+
+// Testable is the testable interface for fake socket implementations.
+type Testable interface {
+	// AddPeer makes addr on network ("tcp" or "udp") accept connections, which
+	// are handled by cb. It replaces any existing peer at addr. Dialing an
+	// address without a peer fails.
+	//
+	// The host part of addr may be a hostname or an IP address. Dial first looks
+	// for a peer at the address exactly as dialed, and then at each of the
+	// host's IP addresses (see SetHost).
+	AddPeer(network, addr string, cb PeerFunc)
+
+	// RemovePeer removes the peer at addr on network, if any. Existing
+	// connections are unaffected.
+	RemovePeer(network, addr string)
+
+	// SetHost makes host resolve to ips, both in LookupIP and when dialing
+	// "host:port". Passing no ips removes host.
+	SetHost(host string, ips ...net.IP)
+}
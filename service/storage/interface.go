@@ -0,0 +1,97 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage provides access to file (object) storage, such as Google
+// Cloud Storage, through the Context.
+//
+// Objects are identified by a bucket and a name, and are immutable: an object
+// is written in its entirety with NewWriter, and becomes visible when the
+// writer is closed successfully.
+package storage
+
+import (
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// RawInterface is the interface for all of the package methods which normally
+// would be in the 'storage' package.
+type RawInterface interface {
+	NewReader(bucket, name string) (io.ReadCloser, error)
+	NewWriter(bucket, name string, opts *WriterOptions) (io.WriteCloser, error)
+	Stat(bucket, name string) (*ObjectAttrs, error)
+	Delete(bucket, name string) error
+	List(bucket, prefix string, cb ListCB) error
+
+	DefaultBucket() (string, error)
+
+	// If this implementation supports it, this will return an instance of the
+	// Testable object for this service. If the implementation doesn't support
+	// it, it will return nil.
+	GetTestable() Testable
+}
+
+// NewReader returns a reader for the contents of the named object. The caller
+// must close it.
+//
+// Returns ErrObjectNotExist if the object doesn't exist.
+func NewReader(c context.Context, bucket, name string) (io.ReadCloser, error) {
+	return Raw(c).NewReader(bucket, name)
+}
+
+// NewWriter returns a writer which replaces the contents of the named object.
+// The object is only written once the writer is closed successfully.
+//
+// opts may be nil.
+func NewWriter(c context.Context, bucket, name string, opts *WriterOptions) (io.WriteCloser, error) {
+	return Raw(c).NewWriter(bucket, name, opts)
+}
+
+// Stat returns the attributes of the named object.
+//
+// Returns ErrObjectNotExist if the object doesn't exist.
+func Stat(c context.Context, bucket, name string) (*ObjectAttrs, error) {
+	return Raw(c).Stat(bucket, name)
+}
+
+// Delete deletes the named object.
+//
+// Returns ErrObjectNotExist if the object doesn't exist.
+func Delete(c context.Context, bucket, name string) error {
+	return Raw(c).Delete(bucket, name)
+}
+
+// List calls cb with the attributes of each object in bucket whose name
+// begins with prefix, in lexicographic order of name.
+//
+// If cb returns Stop, List stops and returns nil. If it returns any other
+// error, List stops and returns that error.
+func List(c context.Context, bucket, prefix string, cb ListCB) error {
+	if err := Raw(c).List(bucket, prefix, cb); err != Stop {
+		return err
+	}
+	return nil
+}
+
+// DefaultBucket returns the name of the application's default bucket.
+func DefaultBucket(c context.Context) (string, error) {
+	return Raw(c).DefaultBucket()
+}
+
+// GetTestable returns a Testable for the current storage service in c, or
+// nil if it does not offer one.
+func GetTestable(c context.Context) Testable {
+	return Raw(c).GetTestable()
+}
@@ -0,0 +1,26 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+// Testable is the testable interface for fake storage implementations.
+type Testable interface {
+	// CreateBucket creates an empty bucket with the given name. It's a no-op if
+	// the bucket already exists.
+	CreateBucket(name string)
+
+	// SetDefaultBucket sets the name returned by DefaultBucket, creating the
+	// bucket if necessary.
+	SetDefaultBucket(name string)
+}
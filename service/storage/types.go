@@ -0,0 +1,67 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrBucketNotExist is returned when a bucket doesn't exist.
+	ErrBucketNotExist = errors.New("storage: bucket doesn't exist")
+
+	// ErrObjectNotExist is returned when an object doesn't exist.
+	ErrObjectNotExist = errors.New("storage: object doesn't exist")
+
+	// Stop may be returned by a ListCB to stop listing without an error.
+	Stop = errors.New("storage: stop listing")
+)
+
+// ListCB is the callback for List. It receives the attributes of each listed
+// object.
+type ListCB func(*ObjectAttrs) error
+
+// ObjectAttrs are the attributes of a stored object.
+type ObjectAttrs struct {
+	Bucket string
+	Name   string
+
+	// ContentType is the MIME type of the object's content.
+	ContentType string
+	// Size is the size of the object's content, in bytes.
+	Size int64
+	// MD5 is the MD5 hash of the object's content.
+	MD5 []byte
+
+	// Created is when the object was first written.
+	Created time.Time
+	// Updated is when the object was last written.
+	Updated time.Time
+
+	// Metadata is user-supplied metadata.
+	Metadata map[string]string
+}
+
+// WriterOptions are the optional attributes of an object written with
+// NewWriter.
+type WriterOptions struct {
+	// ContentType is the MIME type of the object's content. If empty, it's
+	// inferred from the content by the implementation.
+	ContentType string
+
+	// Metadata is user-supplied metadata to store with the object.
+	Metadata map[string]string
+}
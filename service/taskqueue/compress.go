@@ -0,0 +1,113 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskqueue
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// PayloadEncodingHeader is the task header which marks a compressed payload.
+// Its value is "gzip" for payloads compressed by CompressPayload.
+//
+// Content-Encoding isn't used, so that nothing between the task queue and the
+// handler decompresses the payload on its own.
+const PayloadEncodingHeader = "X-Gae-Payload-Encoding"
+
+const gzipEncoding = "gzip"
+
+// CompressPayload gzips the payload of t, and marks it with
+// PayloadEncodingHeader, if it's at least threshold bytes long and compressing
+// makes it smaller. It does nothing for pull tasks, since they have no
+// headers, or if the payload is already compressed.
+//
+// Handlers can decompress the payload with ReadPayload (which DecodeJSONPayload
+// and DecodeProtoPayload use).
+func (t *Task) CompressPayload(threshold int) {
+	if t.Method == "PULL" || len(t.Payload) < threshold || t.Header.Get(PayloadEncodingHeader) != "" {
+		return
+	}
+
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	// errs can't happen, since we're using a byte buffer.
+	_, _ = w.Write(t.Payload)
+	_ = w.Close()
+	if buf.Len() >= len(t.Payload) {
+		return
+	}
+
+	if t.Header == nil {
+		t.Header = http.Header{}
+	}
+	t.Header.Set(PayloadEncodingHeader, gzipEncoding)
+	t.Payload = buf.Bytes()
+}
+
+// decompressPayload returns payload decompressed according to its
+// PayloadEncodingHeader in h.
+func decompressPayload(h http.Header, payload []byte) ([]byte, error) {
+	switch enc := h.Get(PayloadEncodingHeader); enc {
+	case "":
+		return payload, nil
+	case gzipEncoding:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("taskqueue: unknown payload encoding %q", enc)
+	}
+}
+
+// DecodedPayload returns the payload of t, decompressed if it was compressed
+// with CompressPayload.
+func (t *Task) DecodedPayload() ([]byte, error) {
+	return decompressPayload(t.Header, t.Payload)
+}
+
+// ReadPayload reads the payload of the task which r delivers to a push
+// handler, decompressing it if it was compressed with CompressPayload.
+func ReadPayload(r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decompressPayload(r.Header, payload)
+}
+
+// WithPayloadCompression returns a Context in which Add compresses the
+// payloads of the push tasks that it adds (in place) with CompressPayload, if
+// they are at least threshold bytes long. A threshold of 0 or less disables
+// compression.
+//
+// To compress the tasks of some queues only, use this Context just for adding
+// tasks to them.
+func WithPayloadCompression(c context.Context, threshold int) context.Context {
+	return context.WithValue(c, taskQueueCompressionKey, threshold)
+}
+
+func getPayloadCompression(c context.Context) int {
+	threshold, _ := c.Value(taskQueueCompressionKey).(int)
+	return threshold
+}
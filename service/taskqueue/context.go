@@ -15,14 +15,18 @@
 package taskqueue
 
 import (
+	"go.chromium.org/gae/service/tuning"
+
 	"golang.org/x/net/context"
 )
 
 type key int
 
 var (
-	taskQueueKey       key
-	taskQueueFilterKey key = 1
+	taskQueueKey             key
+	taskQueueFilterKey       key = 1
+	taskQueueBatchOptionsKey key = 2
+	taskQueueCompressionKey  key = 3
 )
 
 // RawFactory is the function signature for RawFactory methods compatible with
@@ -37,12 +41,18 @@ type RawFilter func(context.Context, RawInterface) RawInterface
 // rawUnfiltered gets gets the RawInterface implementation from context without
 // any of the filters applied.
 func rawUnfiltered(c context.Context) RawInterface {
-	if f, ok := c.Value(taskQueueKey).(RawFactory); ok && f != nil {
+	if f := rawFactory(c); f != nil {
 		return f(c)
 	}
 	return nil
 }
 
+// rawFactory returns the RawFactory installed in c, or nil if there is none.
+func rawFactory(c context.Context) RawFactory {
+	f, _ := c.Value(taskQueueKey).(RawFactory)
+	return f
+}
+
 // rawWithFilters gets the taskqueue (transactional or not), and applies all of
 // the currently installed filters to it.
 func rawWithFilters(c context.Context, filters ...RawFilter) RawInterface {
@@ -60,7 +70,13 @@ func rawWithFilters(c context.Context, filters ...RawFilter) RawInterface {
 }
 
 // Raw gets the RawInterface implementation from context.
+//
+// If a tuning.TaskQueue Deadline is installed in c, each call made with the
+// returned RawInterface has that deadline.
 func Raw(c context.Context) RawInterface {
+	if d := tuning.Get(c).TaskQueue.Deadline; d > 0 && rawFactory(c) != nil {
+		return &deadlineRaw{c, d}
+	}
 	return rawWithFilters(c)
 }
 
@@ -96,3 +112,38 @@ func AddRawFilters(c context.Context, filts ...RawFilter) context.Context {
 	newFilts = append(newFilts, filts...)
 	return context.WithValue(c, taskQueueFilterKey, newFilts)
 }
+
+// BatchOptions tunes the splitting of large Add and Delete calls into batches.
+// See WithBatchOptions.
+type BatchOptions struct {
+	// MaxAddSize and MaxDeleteSize, if positive, are the maximum number of tasks
+	// in each Add and Delete batch respectively. They can only lower the task
+	// queue's Constraints, not raise them.
+	MaxAddSize    int
+	MaxDeleteSize int
+
+	// Concurrency, if positive, is the maximum number of batches of a single
+	// operation that will be executed at once. If zero, all batches are
+	// executed at once.
+	Concurrency int
+}
+
+// WithBatchOptions returns a Context whose Add and Delete calls are batched
+// according to opts.
+func WithBatchOptions(c context.Context, opts BatchOptions) context.Context {
+	return context.WithValue(c, taskQueueBatchOptionsKey, opts)
+}
+
+// getBatchOptions returns the BatchOptions installed in c, or the ones from
+// the installed tuning.Options if there are none.
+func getBatchOptions(c context.Context) BatchOptions {
+	if opts, ok := c.Value(taskQueueBatchOptionsKey).(BatchOptions); ok {
+		return opts
+	}
+	t := &tuning.Get(c).TaskQueue
+	return BatchOptions{
+		MaxAddSize:    t.MaxAddSize,
+		MaxDeleteSize: t.MaxDeleteSize,
+		Concurrency:   t.Concurrency,
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskqueue
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// deadlineRaw is the RawInterface returned by Raw when a tuning.TaskQueue
+// Deadline is installed. A RawInterface is bound to its Context, so each task
+// queue call builds the filter chain on a Context with the deadline.
+type deadlineRaw struct {
+	c        context.Context
+	deadline time.Duration
+}
+
+func (d *deadlineRaw) withDeadline() (RawInterface, context.CancelFunc) {
+	c, cancel := context.WithTimeout(d.c, d.deadline)
+	return rawWithFilters(c), cancel
+}
+
+func (d *deadlineRaw) AddMulti(tasks []*Task, queueName string, cb RawTaskCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.AddMulti(tasks, queueName, cb)
+}
+
+func (d *deadlineRaw) DeleteMulti(tasks []*Task, queueName string, cb RawCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.DeleteMulti(tasks, queueName, cb)
+}
+
+func (d *deadlineRaw) Lease(maxTasks int, queueName string, leaseTime time.Duration) ([]*Task, error) {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.Lease(maxTasks, queueName, leaseTime)
+}
+
+func (d *deadlineRaw) LeaseByTag(maxTasks int, queueName string, leaseTime time.Duration, tag string) ([]*Task, error) {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.LeaseByTag(maxTasks, queueName, leaseTime, tag)
+}
+
+func (d *deadlineRaw) ModifyLease(task *Task, queueName string, leaseTime time.Duration) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.ModifyLease(task, queueName, leaseTime)
+}
+
+func (d *deadlineRaw) Purge(queueName string) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.Purge(queueName)
+}
+
+func (d *deadlineRaw) Stats(queueNames []string, cb RawStatsCB) error {
+	raw, cancel := d.withDeadline()
+	defer cancel()
+	return raw.Stats(queueNames, cb)
+}
+
+func (d *deadlineRaw) Constraints() Constraints { return rawWithFilters(d.c).Constraints() }
+
+func (d *deadlineRaw) GetTestable() Testable { return rawWithFilters(d.c).GetTestable() }
@@ -0,0 +1,103 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskqueue
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+
+	"go.chromium.org/luci/common/clock"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+// DedupName returns a deterministic task name derived from dedupKey, which can
+// be any string. Task names have a restricted alphabet and length, so the name
+// is derived by hashing dedupKey.
+func DedupName(dedupKey string) string {
+	h := sha256.Sum256([]byte(dedupKey))
+	return "dedup-" + hex.EncodeToString(h[:])
+}
+
+// DedupOptions are options for AddDeduped.
+type DedupOptions struct {
+	// Tombstones, if true, makes AddDeduped record each added task in the
+	// datastore, and skip adding tasks which have been recorded.
+	//
+	// The task queue only remembers the names of tasks for a limited time (days)
+	// after they are executed. Tombstones extend this window, e.g. for tasks
+	// which must run at most once ever.
+	Tombstones bool
+
+	// TombstoneExpiration, if positive, is how long tombstones are honored
+	// after they are recorded. If zero, they are honored forever. Note that
+	// the task queue itself still rejects the task while it remembers its name.
+	TombstoneExpiration time.Duration
+}
+
+// dedupTombstone is the datastore entity recording a deduplicated task.
+type dedupTombstone struct {
+	_kind string `gae:"$kind,gae.taskqueue.DedupTombstone"`
+
+	// ID is the name of the task.
+	ID string `gae:"$id"`
+
+	// Created is when the task was added.
+	Created time.Time `gae:",noindex"`
+}
+
+// AddDeduped adds task to queueName with a name derived from dedupKey (see
+// DedupName), so that a task with the same dedupKey is added at most once.
+// The task's Name is overwritten.
+//
+// Unlike Add, adding a task which already exists is not an error: AddDeduped
+// returns nil if the task was added now or before. opts may be nil.
+func AddDeduped(c context.Context, queueName, dedupKey string, task *Task, opts *DedupOptions) error {
+	if opts == nil {
+		opts = &DedupOptions{}
+	}
+	task.Name = DedupName(dedupKey)
+
+	if opts.Tombstones {
+		tomb := &dedupTombstone{ID: task.Name}
+		switch err := ds.Get(c, tomb); err {
+		case nil:
+			if opts.TombstoneExpiration <= 0 || clock.Since(c, tomb.Created) < opts.TombstoneExpiration {
+				return nil
+			}
+		case ds.ErrNoSuchEntity:
+		default:
+			return errors.Annotate(err, "failed to get the tombstone of task %q", task.Name).Err()
+		}
+	}
+
+	switch err := Add(c, queueName, task); err {
+	case nil, ErrTaskAlreadyAdded:
+	default:
+		return err
+	}
+
+	if opts.Tombstones {
+		tomb := &dedupTombstone{ID: task.Name, Created: clock.Now(c).UTC()}
+		if err := ds.Put(c, tomb); err != nil {
+			return errors.Annotate(err, "failed to record the tombstone of task %q", task.Name).Err()
+		}
+	}
+	return nil
+}
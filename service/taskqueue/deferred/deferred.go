@@ -0,0 +1,296 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package deferred runs functions later, in task queue tasks.
+//
+// Functions are registered with a name, usually at init time:
+//
+//	func sendMail(c context.Context, to string, count int) error { ... }
+//
+//	func init() {
+//	  deferred.Register("sendMail", sendMail)
+//	}
+//
+// and can then be called later with Defer:
+//
+//	err := deferred.Defer(c, "sendMail", "someone@example.com", 3)
+//
+// The arguments are encoded as JSON in the task payload, so they must survive
+// a round trip through encoding/json. The task is dispatched by Handler, which
+// must be mounted at Path, restricted to admins.
+//
+// In tests, RunPending runs the deferred calls scheduled in the fake task
+// queue (e.g. from impl/memory) directly, without going through HTTP.
+package deferred
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+
+	tq "go.chromium.org/gae/service/taskqueue"
+
+	"go.chromium.org/luci/common/errors"
+	"go.chromium.org/luci/common/logging"
+
+	"golang.org/x/net/context"
+)
+
+// Path is the URL path of deferred tasks, at which Handler must be mounted.
+const Path = "/_ah/queue/deferred"
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+var registry = struct {
+	sync.RWMutex
+	funcs map[string]reflect.Value
+}{funcs: map[string]reflect.Value{}}
+
+// Register registers fn to be called by deferred tasks with name.
+//
+// fn must be a function whose first argument is a context.Context, and which
+// returns nothing or an error. Its other arguments must be JSON serializable.
+// It may be variadic.
+//
+// Register panics if fn is not such a function, or if name is already
+// registered.
+func Register(name string, fn interface{}) {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	switch {
+	case t.Kind() != reflect.Func:
+		panic(fmt.Errorf("deferred: %q is a %s, not a function", name, t))
+	case t.NumIn() == 0 || t.In(0) != contextType:
+		panic(fmt.Errorf("deferred: the first argument of %q must be a context.Context", name))
+	case t.NumOut() > 1 || (t.NumOut() == 1 && t.Out(0) != errorType):
+		panic(fmt.Errorf("deferred: %q must return nothing or an error", name))
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.funcs[name]; ok {
+		panic(fmt.Errorf("deferred: %q is already registered", name))
+	}
+	registry.funcs[name] = v
+}
+
+func lookup(name string) (reflect.Value, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	v, ok := registry.funcs[name]
+	return v, ok
+}
+
+// payload is the JSON payload of a deferred task.
+type payload struct {
+	Name string            `json:"name"`
+	Args []json.RawMessage `json:"args"`
+}
+
+// Task returns a task which calls the function registered with name with
+// args, e.g. to add it to a specific queue, or with a delay.
+//
+// Returns an error if there's no such function, or args don't match its
+// arguments or can't be encoded.
+func Task(name string, args ...interface{}) (*tq.Task, error) {
+	fn, ok := lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("deferred: no function is registered as %q", name)
+	}
+
+	t := fn.Type()
+	if err := checkArgs(name, t, args); err != nil {
+		return nil, err
+	}
+
+	p := payload{Name: name, Args: make([]json.RawMessage, len(args))}
+	for i, arg := range args {
+		raw, err := json.Marshal(arg)
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to encode argument %d of %q", i, name).Err()
+		}
+		p.Args[i] = raw
+	}
+
+	return tq.NewJSONTask(Path, &p)
+}
+
+// argType returns the type of the i'th (non-context) argument of fn, of type
+// t.
+func argType(t reflect.Type, i int) reflect.Type {
+	i++ // skip the context
+	if t.IsVariadic() && i >= t.NumIn()-1 {
+		return t.In(t.NumIn() - 1).Elem()
+	}
+	return t.In(i)
+}
+
+// argCountOK returns true if a function of type t can be called with n
+// (non-context) arguments.
+func argCountOK(t reflect.Type, n int) bool {
+	if t.IsVariadic() {
+		return n >= t.NumIn()-2
+	}
+	return n == t.NumIn()-1
+}
+
+func checkArgs(name string, t reflect.Type, args []interface{}) error {
+	if !argCountOK(t, len(args)) {
+		return fmt.Errorf("deferred: %q can't be called with %d argument(s)", name, len(args))
+	}
+
+	for i, arg := range args {
+		at := argType(t, i)
+		if arg == nil {
+			switch at.Kind() {
+			case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+				continue
+			}
+		} else if reflect.TypeOf(arg).AssignableTo(at) {
+			continue
+		}
+		return fmt.Errorf("deferred: argument %d of %q must be a %s, got %T", i, name, at, arg)
+	}
+	return nil
+}
+
+// Defer adds a task to the default queue, which calls the function registered
+// with name with args. See Task.
+func Defer(c context.Context, name string, args ...interface{}) error {
+	task, err := Task(name, args...)
+	if err != nil {
+		return err
+	}
+	return tq.Add(c, "", task)
+}
+
+// ErrBadPayload is returned by Call when the payload of a deferred task is
+// malformed, which retrying won't fix.
+var ErrBadPayload = errors.New("deferred: bad task payload")
+
+// Call decodes the payload of a deferred task and calls the function that it
+// refers to.
+//
+// Returns ErrBadPayload if the payload is malformed, or the error returned by
+// the function.
+func Call(c context.Context, data []byte) error {
+	p := payload{}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return ErrBadPayload
+	}
+	return call(c, &p)
+}
+
+func call(c context.Context, p *payload) error {
+	fn, ok := lookup(p.Name)
+	if !ok {
+		return fmt.Errorf("deferred: no function is registered as %q", p.Name)
+	}
+
+	t := fn.Type()
+	if !argCountOK(t, len(p.Args)) {
+		return ErrBadPayload
+	}
+	in := make([]reflect.Value, len(p.Args)+1)
+	in[0] = reflect.ValueOf(c)
+	for i, raw := range p.Args {
+		arg := reflect.New(argType(t, i))
+		if err := json.Unmarshal(raw, arg.Interface()); err != nil {
+			return ErrBadPayload
+		}
+		in[i+1] = arg.Elem()
+	}
+
+	out := fn.Call(in)
+	if len(out) == 1 && !out[0].IsNil() {
+		return out[0].Interface().(error)
+	}
+	return nil
+}
+
+// Handler returns an http.Handler which dispatches deferred tasks. It must be
+// mounted at Path.
+//
+// getContext returns the context to call the functions with for a request,
+// e.g. by calling prod.Use.
+//
+// Tasks with a malformed payload are logged and dropped. If the function
+// fails, the handler responds with an error, so the task is retried.
+//
+// Requests without the X-AppEngine-QueueName and X-AppEngine-TaskName headers,
+// which App Engine strips from external requests, are rejected, since they
+// don't come from the task queue. Path should also be restricted to admins
+// (e.g. with "login: admin" in app.yaml), as a second line of defense.
+func Handler(getContext func(r *http.Request) context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := getContext(r)
+		if r.Header.Get("X-AppEngine-QueueName") == "" || r.Header.Get("X-AppEngine-TaskName") == "" {
+			logging.Errorf(c, "deferred: rejecting a request which isn't from the task queue")
+			http.Error(w, "deferred: not a task queue request", http.StatusForbidden)
+			return
+		}
+		p := payload{}
+		err := ErrBadPayload
+		if tq.DecodeJSONPayload(r, &p) == nil {
+			err = call(c, &p)
+		}
+		switch err {
+		case nil:
+		case ErrBadPayload:
+			logging.WithError(err).Errorf(c, "deferred: dropping task %q", r.Header.Get("X-AppEngine-TaskName"))
+		default:
+			logging.WithError(err).Errorf(c, "deferred: task %q failed", r.Header.Get("X-AppEngine-TaskName"))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// RunPending calls the functions of the deferred tasks scheduled in the
+// default queue of the Testable task queue in c (e.g. from impl/memory), and
+// deletes the tasks. Deferred tasks added by these functions are run too.
+//
+// It's intended for tests. It stops at the first error, leaving the failed
+// task scheduled.
+func RunPending(c context.Context) error {
+	tqt := tq.GetTestable(c)
+	if tqt == nil {
+		return errors.New("deferred: the task queue is not testable")
+	}
+	for {
+		var task *tq.Task
+		for _, t := range tqt.GetScheduledTasks()["default"] {
+			if t.Path == Path && (task == nil || t.ETA.Before(task.ETA)) {
+				task = t
+			}
+		}
+		if task == nil {
+			return nil
+		}
+		data, err := task.DecodedPayload()
+		if err != nil {
+			return err
+		}
+		if err := Call(c, data); err != nil {
+			return err
+		}
+		if err := tq.Delete(c, "", task); err != nil {
+			return err
+		}
+	}
+}
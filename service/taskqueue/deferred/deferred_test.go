@@ -0,0 +1,152 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deferred
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.chromium.org/gae/impl/memory"
+	tq "go.chromium.org/gae/service/taskqueue"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+type testData struct {
+	Name  string
+	Count int
+}
+
+type callsKey struct{}
+
+func recordCall(c context.Context, call string) {
+	calls := c.Value(callsKey{}).(*[]string)
+	*calls = append(*calls, call)
+}
+
+func init() {
+	Register("deferred_test.simple", func(c context.Context, s string, d *testData) {
+		recordCall(c, "simple "+s+" "+d.Name)
+	})
+	Register("deferred_test.variadic", func(c context.Context, prefix string, ns ...int) error {
+		if len(ns) == 0 {
+			return errors.New("no numbers")
+		}
+		recordCall(c, prefix+strings.Repeat("!", len(ns)))
+		return nil
+	})
+	Register("deferred_test.chain", func(c context.Context, n int) error {
+		recordCall(c, "chain")
+		if n > 0 {
+			return Defer(c, "deferred_test.chain", n-1)
+		}
+		return nil
+	})
+}
+
+func TestDeferred(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test deferred", t, func() {
+		calls := []string{}
+		c := memory.Use(context.WithValue(context.Background(), callsKey{}, &calls))
+		tqt := tq.GetTestable(c)
+
+		Convey("Register rejects bad functions", func() {
+			So(func() { Register("bad", 1) }, ShouldPanicLike, "not a function")
+			So(func() { Register("bad", func(int) {}) }, ShouldPanicLike, "must be a context.Context")
+			So(func() { Register("bad", func(context.Context) int { return 0 }) }, ShouldPanicLike, "must return nothing or an error")
+			So(func() { Register("deferred_test.simple", func(context.Context) {}) }, ShouldPanicLike, "already registered")
+		})
+
+		Convey("Task checks the arguments", func() {
+			_, err := Task("nope")
+			So(err, ShouldErrLike, "no function is registered")
+			_, err = Task("deferred_test.simple", "a")
+			So(err, ShouldErrLike, "can't be called with 1 argument(s)")
+			_, err = Task("deferred_test.simple", 1, &testData{})
+			So(err, ShouldErrLike, "argument 0 of \"deferred_test.simple\" must be a string, got int")
+			_, err = Task("deferred_test.simple", "a", nil)
+			So(err, ShouldBeNil)
+			_, err = Task("deferred_test.variadic", "a", 1, 2, "3")
+			So(err, ShouldErrLike, "argument 3")
+		})
+
+		Convey("Defer and RunPending", func() {
+			So(Defer(c, "deferred_test.simple", "hello", &testData{Name: "world"}), ShouldBeNil)
+			So(Defer(c, "deferred_test.variadic", "wow", 1, 2, 3), ShouldBeNil)
+			So(tqt.GetScheduledTasks()["default"], ShouldHaveLength, 2)
+
+			So(RunPending(c), ShouldBeNil)
+			So(calls, ShouldHaveLength, 2)
+			So(calls, ShouldContain, "simple hello world")
+			So(calls, ShouldContain, "wow!!!")
+			So(tqt.GetScheduledTasks()["default"], ShouldHaveLength, 0)
+
+			Convey("including tasks deferred by deferred functions", func() {
+				So(Defer(c, "deferred_test.chain", 2), ShouldBeNil)
+				So(RunPending(c), ShouldBeNil)
+				So(calls[2:], ShouldResemble, []string{"chain", "chain", "chain"})
+			})
+
+			Convey("stopping at errors", func() {
+				So(Defer(c, "deferred_test.variadic", "none"), ShouldBeNil)
+				So(RunPending(c), ShouldErrLike, "no numbers")
+				So(tqt.GetScheduledTasks()["default"], ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("Handler", func() {
+			h := Handler(func(*http.Request) context.Context { return c })
+			serveAs := func(task *tq.Task, fromQueue bool) int {
+				r := httptest.NewRequest("POST", Path, bytes.NewReader(task.Payload))
+				r.Header = http.Header{}
+				for k, v := range task.Header {
+					r.Header[k] = v
+				}
+				if fromQueue {
+					r.Header.Set("X-AppEngine-QueueName", "default")
+					r.Header.Set("X-AppEngine-TaskName", "task")
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, r)
+				return rec.Code
+			}
+			serve := func(task *tq.Task) int { return serveAs(task, true) }
+
+			task, err := Task("deferred_test.simple", "via", &testData{Name: "http"})
+			So(err, ShouldBeNil)
+			So(serveAs(task, false), ShouldEqual, http.StatusForbidden)
+			So(calls, ShouldBeEmpty)
+			So(serve(task), ShouldEqual, http.StatusOK)
+			So(calls, ShouldResemble, []string{"simple via http"})
+
+			task, err = Task("deferred_test.variadic", "none")
+			So(err, ShouldBeNil)
+			So(serve(task), ShouldEqual, http.StatusInternalServerError)
+
+			task.Payload = []byte("garbage")
+			So(serve(task), ShouldEqual, http.StatusOK)
+			So(calls, ShouldHaveLength, 1)
+		})
+	})
+}
@@ -31,9 +31,29 @@ import (
 // encountered when processing the task at that index.
 //
 // If the number of tasks is beyond the limits of the underlying implementation,
-// splits the batch into multiple ones.
+// splits the batch into multiple ones, which are added in parallel. The batch
+// size and the parallelism can be tuned with WithBatchOptions.
+//
+// If c has payload compression enabled (see WithPayloadCompression), the
+// payloads of the tasks are compressed first.
 func Add(c context.Context, queueName string, tasks ...*Task) error {
-	return addRaw(Raw(c), queueName, tasks)
+	if threshold := getPayloadCompression(c); threshold > 0 {
+		for _, t := range tasks {
+			if t != nil {
+				t.CompressPayload(threshold)
+			}
+		}
+	}
+	return addRaw(Raw(c), getBatchOptions(c), queueName, tasks)
+}
+
+// capBatchSize returns the smaller of the constraint and the size option. Zero
+// or negative values mean "unlimited".
+func capBatchSize(constraint, size int) int {
+	if size > 0 && (constraint <= 0 || size < constraint) {
+		return size
+	}
+	return constraint
 }
 
 func makeBatches(tasks []*Task, limit int) [][]*Task {
@@ -52,24 +72,40 @@ func makeBatches(tasks []*Task, limit int) [][]*Task {
 	return batches
 }
 
-func addRaw(raw RawInterface, queueName string, tasks []*Task) error {
-	lme := errors.NewLazyMultiError(len(tasks))
-	err := parallel.FanOutIn(func(work chan<- func() error) {
+// runBatches splits tasks into batches of at most limit tasks, and calls cb
+// for each of them with the batch's offset in tasks, at most concurrency at a
+// time (or all at once, if concurrency is not positive).
+func runBatches(tasks []*Task, limit, concurrency int, cb func(offset int, batch []*Task) error) error {
+	dispatch := parallel.FanOutIn
+	if concurrency > 0 {
+		dispatch = func(gen func(chan<- func() error)) error {
+			return parallel.WorkPool(concurrency, gen)
+		}
+	}
+	return dispatch(func(work chan<- func() error) {
 		offset := 0
-		for _, batch := range makeBatches(tasks, raw.Constraints().MaxAddSize) {
+		for _, batch := range makeBatches(tasks, limit) {
 			batch := batch
-			i := offset
+			localOffset := offset
 			offset += len(batch)
 			work <- func() error {
-				return raw.AddMulti(batch, queueName, func(t *Task, err error) {
-					if !lme.Assign(i, err) {
-						*tasks[i] = *t
-					}
-					i++
-				})
+				return cb(localOffset, batch)
 			}
 		}
 	})
+}
+
+func addRaw(raw RawInterface, opts BatchOptions, queueName string, tasks []*Task) error {
+	lme := errors.NewLazyMultiError(len(tasks))
+	limit := capBatchSize(raw.Constraints().MaxAddSize, opts.MaxAddSize)
+	err := runBatches(tasks, limit, opts.Concurrency, func(i int, batch []*Task) error {
+		return raw.AddMulti(batch, queueName, func(t *Task, err error) {
+			if !lme.Assign(i, err) {
+				*tasks[i] = *t
+			}
+			i++
+		})
+	})
 	if err != nil {
 		return err
 	}
@@ -88,22 +124,16 @@ func addRaw(raw RawInterface, queueName string, tasks []*Task) error {
 // encountered when processing the task at that index.
 //
 // If the number of tasks is beyond the limits of the underlying implementation,
-// splits the batch into multiple ones.
+// splits the batch into multiple ones, like Add.
 func Delete(c context.Context, queueName string, tasks ...*Task) error {
 	raw := Raw(c)
+	opts := getBatchOptions(c)
 	lme := errors.NewLazyMultiError(len(tasks))
-	err := parallel.FanOutIn(func(work chan<- func() error) {
-		offset := 0
-		for _, batch := range makeBatches(tasks, raw.Constraints().MaxDeleteSize) {
-			batch := batch
-			localOffset := offset
-			offset += len(batch)
-			work <- func() error {
-				return raw.DeleteMulti(batch, queueName, func(i int, err error) {
-					lme.Assign(localOffset+i, err)
-				})
-			}
-		}
+	limit := capBatchSize(raw.Constraints().MaxDeleteSize, opts.MaxDeleteSize)
+	err := runBatches(tasks, limit, opts.Concurrency, func(offset int, batch []*Task) error {
+		return raw.DeleteMulti(batch, queueName, func(i int, err error) {
+			lme.Assign(offset+i, err)
+		})
 	})
 	if err != nil {
 		return err
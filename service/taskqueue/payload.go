@@ -0,0 +1,127 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskqueue
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// MaxPushPayloadSize is the maximum size of the payload of a push task.
+	MaxPushPayloadSize = 100 * 1024
+
+	// MaxPullPayloadSize is the maximum size of the payload of a pull task.
+	MaxPullPayloadSize = 1024 * 1024
+)
+
+const (
+	// JSONContentType is the Content-Type of tasks created with NewJSONTask.
+	JSONContentType = "application/json"
+
+	// ProtoContentType is the Content-Type of tasks created with NewProtoTask.
+	ProtoContentType = "application/x-protobuf"
+)
+
+// NewJSONTask creates a Task that will POST v, encoded as JSON, to path.
+//
+// If the payload is too large for a push task, it's compressed with
+// CompressPayload. Returns an error if v can't be encoded, or is still too
+// large.
+func NewJSONTask(path string, v interface{}) (*Task, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return newPayloadTask(path, JSONContentType, payload)
+}
+
+// NewProtoTask creates a Task that will POST msg, encoded in the protobuf
+// binary format, to path.
+//
+// Like NewJSONTask, the payload is compressed if it's too large for a push
+// task. Returns an error if msg can't be encoded, or is still too large.
+func NewProtoTask(path string, msg proto.Message) (*Task, error) {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return newPayloadTask(path, ProtoContentType, payload)
+}
+
+func newPayloadTask(path, contentType string, payload []byte) (*Task, error) {
+	t := &Task{
+		Path:    path,
+		Payload: payload,
+		Header:  http.Header{"Content-Type": []string{contentType}},
+		Method:  "POST",
+	}
+	if len(payload) > MaxPushPayloadSize {
+		t.CompressPayload(0)
+	}
+	if err := t.ValidatePayloadSize(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ValidatePayloadSize returns an error if the payload of t is larger than
+// the maximum for its kind of task (see MaxPushPayloadSize and
+// MaxPullPayloadSize).
+func (t *Task) ValidatePayloadSize() error {
+	limit := MaxPushPayloadSize
+	if t.Method == "PULL" {
+		limit = MaxPullPayloadSize
+	}
+	if len(t.Payload) > limit {
+		return fmt.Errorf("taskqueue: payload of %d bytes is larger than the limit of %d bytes", len(t.Payload), limit)
+	}
+	return nil
+}
+
+// DecodeJSONPayload decodes the JSON payload of a task created with
+// NewJSONTask, as received by its push handler, into v.
+//
+// Returns an error if the request doesn't have a JSON Content-Type.
+func DecodeJSONPayload(r *http.Request, v interface{}) error {
+	payload, err := readPayload(r, JSONContentType)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
+
+// DecodeProtoPayload decodes the protobuf payload of a task created with
+// NewProtoTask, as received by its push handler, into msg.
+//
+// Returns an error if the request doesn't have a protobuf Content-Type.
+func DecodeProtoPayload(r *http.Request, msg proto.Message) error {
+	payload, err := readPayload(r, ProtoContentType)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(payload, msg)
+}
+
+func readPayload(r *http.Request, contentType string) ([]byte, error) {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != contentType {
+		return nil, fmt.Errorf("taskqueue: expected Content-Type %q, got %q", contentType, r.Header.Get("Content-Type"))
+	}
+	return ReadPayload(r)
+}
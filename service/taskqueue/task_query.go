@@ -0,0 +1,118 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskqueue
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// TaskQuery selects tasks from a Testable's scheduled tasks. Each non-zero
+// field narrows the selection; the zero TaskQuery matches every task.
+type TaskQuery struct {
+	// Queue, if set, restricts the query to the named queue.
+	Queue string
+
+	// PathPrefix, if set, only matches tasks whose Path starts with it.
+	PathPrefix string
+
+	// Method, if set, only matches tasks with this Method. Note that the memory
+	// implementation fills in the default "POST" when a task is added.
+	Method string
+
+	// Header only matches tasks which have each of the given headers set to the
+	// given value.
+	Header map[string]string
+
+	// ETAFrom and ETATo, if set, only match tasks whose ETA is in
+	// [ETAFrom, ETATo).
+	ETAFrom time.Time
+	ETATo   time.Time
+
+	// Payload, if set, only matches tasks for which it returns true. It is
+	// passed the task's decoded payload (see Task.DecodedPayload). Tasks whose
+	// payload can't be decoded don't match.
+	Payload func(payload []byte) bool
+}
+
+// Matches returns true if task, which is in the queue named queueName,
+// is selected by the query.
+func (q TaskQuery) Matches(queueName string, task *Task) bool {
+	switch {
+	case q.Queue != "" && q.Queue != queueName:
+		return false
+	case !strings.HasPrefix(task.Path, q.PathPrefix):
+		return false
+	case q.Method != "" && q.Method != task.Method:
+		return false
+	case !q.ETAFrom.IsZero() && task.ETA.Before(q.ETAFrom):
+		return false
+	case !q.ETATo.IsZero() && !task.ETA.Before(q.ETATo):
+		return false
+	}
+
+	for k, v := range q.Header {
+		if task.Header.Get(k) != v {
+			return false
+		}
+	}
+
+	if q.Payload != nil {
+		payload, err := task.DecodedPayload()
+		if err != nil || !q.Payload(payload) {
+			return false
+		}
+	}
+	return true
+}
+
+// Find returns all of the tasks in data which match the query, ordered by
+// ETA, then queue name, then task name.
+func (q TaskQuery) Find(data QueueData) []*Task {
+	type match struct {
+		queue string
+		task  *Task
+	}
+	var matches []match
+	for queueName, tasks := range data {
+		if q.Queue != "" && q.Queue != queueName {
+			continue
+		}
+		for _, t := range tasks {
+			if q.Matches(queueName, t) {
+				matches = append(matches, match{queueName, t})
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		switch {
+		case !a.task.ETA.Equal(b.task.ETA):
+			return a.task.ETA.Before(b.task.ETA)
+		case a.queue != b.queue:
+			return a.queue < b.queue
+		default:
+			return a.task.Name < b.task.Name
+		}
+	})
+
+	ret := make([]*Task, len(matches))
+	for i, m := range matches {
+		ret[i] = m.task
+	}
+	return ret
+}
@@ -26,6 +26,19 @@ type Testable interface {
 	CreatePullQueue(queueName string)
 	GetScheduledTasks() QueueData
 	GetTombstonedTasks() QueueData
+
+	// FindScheduledTasks returns the scheduled tasks which match q, as
+	// TaskQuery.Find.
+	FindScheduledTasks(q TaskQuery) []*Task
+
+	// GetTransactionTasks returns the tasks which have been added in the
+	// current transaction, and will be scheduled if it commits. It returns
+	// nothing outside of a transaction.
 	GetTransactionTasks() AnonymousQueueData
+	// GetCommittedTransactionTasks returns all of the tasks which have been
+	// added by committed transactions, in commit order, since the last
+	// ResetTasks.
+	GetCommittedTransactionTasks() AnonymousQueueData
+
 	ResetTasks()
 }
@@ -0,0 +1,115 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tuning holds operational defaults for the service packages, such
+// as batch sizes, parallelism, retries and deadlines.
+//
+// The defaults are installed in a Context with Set, and are consulted by the
+// "datastore", "taskqueue" and "memcache" service packages for every
+// operation made with that Context. This lets an application tune its use of
+// the services in one place, e.g. when setting up its request handlers,
+// rather than at every call site:
+//
+//	c = tuning.Set(c, &tuning.Options{
+//	  Datastore: tuning.Datastore{MaxGetSize: 100, Deadline: 10 * time.Second},
+//	  Memcache:  tuning.Memcache{Deadline: time.Second},
+//	})
+//
+// Zero values mean "use the service's own default". Options set more
+// specifically, like datastore.WithBatchOptions or the Attempts of
+// datastore.TransactionOptions, take precedence over these.
+package tuning
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Options are the operational defaults for the service packages.
+type Options struct {
+	Datastore Datastore
+	TaskQueue TaskQueue
+	Memcache  Memcache
+}
+
+// Datastore are the defaults of the "datastore" service package.
+type Datastore struct {
+	// MaxGetSize, MaxPutSize and MaxDeleteSize, if positive, are the maximum
+	// number of elements in each Get, Put and Delete batch respectively. See
+	// datastore.BatchOptions.
+	MaxGetSize    int
+	MaxPutSize    int
+	MaxDeleteSize int
+
+	// Concurrency, if positive, is the maximum number of batches of a single
+	// operation that will be executed at once.
+	Concurrency int
+
+	// TransactionAttempts, if positive, is the number of attempts made by
+	// RunInTransaction when its TransactionOptions don't specify one.
+	TransactionAttempts int
+
+	// Deadline, if positive, is the deadline of each datastore call. It doesn't
+	// apply to RunInTransaction as a whole, only to the calls made within it.
+	Deadline time.Duration
+}
+
+// TaskQueue are the defaults of the "taskqueue" service package.
+type TaskQueue struct {
+	// MaxAddSize and MaxDeleteSize, if positive, are the maximum number of tasks
+	// in each Add and Delete batch respectively. See taskqueue.BatchOptions.
+	MaxAddSize    int
+	MaxDeleteSize int
+
+	// Concurrency, if positive, is the maximum number of batches of a single
+	// operation that will be executed at once.
+	Concurrency int
+
+	// Deadline, if positive, is the deadline of each task queue call.
+	Deadline time.Duration
+}
+
+// Memcache are the defaults of the "memcache" service package.
+type Memcache struct {
+	// MutateAttempts, if positive, is the number of attempts made by a
+	// memcache.Mutator with no Attempts set.
+	MutateAttempts int
+
+	// Deadline, if positive, is the deadline of each memcache call.
+	Deadline time.Duration
+}
+
+var optionsKey = "gae:tuning:options"
+
+// Set returns a Context with the supplied Options installed. A nil o removes
+// any Options installed in c.
+func Set(c context.Context, o *Options) context.Context {
+	if o != nil {
+		cpy := *o
+		o = &cpy
+	}
+	return context.WithValue(c, &optionsKey, o)
+}
+
+// Get returns the Options installed in c. If there are none, it returns the
+// zero Options.
+//
+// The returned Options must not be modified.
+func Get(c context.Context) *Options {
+	if o, _ := c.Value(&optionsKey).(*Options); o != nil {
+		return o
+	}
+	return &Options{}
+}
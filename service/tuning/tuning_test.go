@@ -0,0 +1,51 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tuning
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTuning(t *testing.T) {
+	t.Parallel()
+
+	Convey("Tuning options", t, func() {
+		c := context.Background()
+
+		Convey("are zero by default", func() {
+			So(Get(c), ShouldResemble, &Options{})
+		})
+
+		Convey("can be installed", func() {
+			o := &Options{Datastore: Datastore{MaxGetSize: 10, Deadline: time.Second}}
+			c = Set(c, o)
+			So(Get(c), ShouldResemble, o)
+
+			Convey("as a copy", func() {
+				o.Datastore.MaxGetSize = 20
+				So(Get(c).Datastore.MaxGetSize, ShouldEqual, 10)
+			})
+
+			Convey("and removed", func() {
+				So(Get(Set(c, nil)), ShouldResemble, &Options{})
+			})
+		})
+	})
+}
@@ -35,7 +35,12 @@ type Factory func(context.Context) http.RoundTripper
 // Get pulls http.RoundTripper implementation from context or panics if it
 // wasn't set. Use SetFactory(...) or Set(...) in unit tests to mock
 // the round tripper.
+//
+// If a transport was selected with WithStdTransport, it is returned instead.
 func Get(c context.Context) http.RoundTripper {
+	if rt, ok := c.Value(&stdTransportKey).(http.RoundTripper); ok && rt != nil {
+		return rt
+	}
 	if f, ok := c.Value(serviceKey).(Factory); ok && f != nil {
 		return f(c)
 	}
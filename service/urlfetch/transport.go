@@ -0,0 +1,92 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package urlfetch
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var stdTransportKey = "holds the standard http.RoundTripper override"
+
+// StdTransportOptions configures a transport made by NewStdTransport.
+//
+// The zero value is a reasonable default.
+type StdTransportOptions struct {
+	// TLSClientConfig, if not nil, is the TLS configuration to use.
+	TLSClientConfig *tls.Config
+
+	// MaxIdleConnsPerHost is the maximum number of idle connections to keep
+	// pooled per host. If zero, http.DefaultMaxIdleConnsPerHost is used.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection is kept in the pool. If
+	// zero, 90 seconds is used.
+	IdleConnTimeout time.Duration
+
+	// DisableHTTP2, if true, restricts the transport to HTTP/1.1.
+	DisableHTTP2 bool
+}
+
+// NewStdTransport returns a new standard library http.Transport, which pools
+// connections and speaks HTTP/2 where the server supports it.
+//
+// It's intended for environments where outbound connections aren't
+// restricted to the classic urlfetch API (e.g. Flex and the second-generation
+// Standard runtimes). Transports are safe for concurrent use and should be
+// shared, so that their connection pools are effective.
+func NewStdTransport(opts *StdTransportOptions) *http.Transport {
+	if opts == nil {
+		opts = &StdTransportOptions{}
+	}
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       opts.TLSClientConfig,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	if t.IdleConnTimeout == 0 {
+		t.IdleConnTimeout = 90 * time.Second
+	}
+	if opts.DisableHTTP2 {
+		// A non-nil, empty TLSNextProto disables HTTP/2.
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	} else {
+		t.ForceAttemptHTTP2 = true
+	}
+	return t
+}
+
+// WithStdTransport returns a Context in which Get returns rt, regardless of
+// the installed Factory. rt is typically made once with NewStdTransport and
+// shared.
+//
+// This selects the transport per Context, so e.g. a request handler can use
+// a pooled transport while the rest of the app keeps using the backend's
+// default (such as the classic urlfetch transport). Passing a nil rt restores
+// the installed Factory.
+func WithStdTransport(c context.Context, rt http.RoundTripper) context.Context {
+	return context.WithValue(c, &stdTransportKey, rt)
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package urlfetch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeRT records the URLs of the requests that it gets.
+type fakeRT struct {
+	urls []string
+}
+
+func (f *fakeRT) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.urls = append(f.urls, r.URL.String())
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader("fake")),
+		Request:    r,
+	}, nil
+}
+
+func TestStdTransport(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test std transport", t, func() {
+		installed := &fakeRT{}
+		c := Set(context.Background(), installed)
+
+		Convey("WithStdTransport overrides the installed Factory", func() {
+			std := &fakeRT{}
+			c := WithStdTransport(c, std)
+			So(Get(c), ShouldEqual, std)
+
+			resp, err := (&http.Client{Transport: Get(c)}).Get("http://example.com/path")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "fake")
+			So(std.urls, ShouldResemble, []string{"http://example.com/path"})
+			So(installed.urls, ShouldBeEmpty)
+
+			Convey("unless it's nil", func() {
+				So(Get(WithStdTransport(c, nil)), ShouldEqual, installed)
+			})
+		})
+
+		Convey("NewStdTransport", func() {
+			Convey("uses the defaults", func() {
+				tr := NewStdTransport(nil)
+				So(tr.IdleConnTimeout, ShouldEqual, 90*time.Second)
+				So(tr.ForceAttemptHTTP2, ShouldBeTrue)
+				So(tr.TLSNextProto, ShouldBeNil)
+			})
+
+			Convey("applies the options", func() {
+				tr := NewStdTransport(&StdTransportOptions{
+					MaxIdleConnsPerHost: 7,
+					IdleConnTimeout:     time.Minute,
+					DisableHTTP2:        true,
+				})
+				So(tr.MaxIdleConnsPerHost, ShouldEqual, 7)
+				So(tr.IdleConnTimeout, ShouldEqual, time.Minute)
+				So(tr.ForceAttemptHTTP2, ShouldBeFalse)
+				So(tr.TLSNextProto, ShouldNotBeNil)
+				So(tr.TLSNextProto, ShouldBeEmpty)
+			})
+
+			Convey("makes requests", func() {
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte("hello " + r.URL.Path))
+				}))
+				defer srv.Close()
+
+				tr := NewStdTransport(nil)
+				defer tr.CloseIdleConnections()
+				c := WithStdTransport(c, tr)
+
+				resp, err := (&http.Client{Transport: Get(c)}).Get(srv.URL + "/world")
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				body, err := ioutil.ReadAll(resp.Body)
+				So(err, ShouldBeNil)
+				So(string(body), ShouldEqual, "hello /world")
+				So(installed.urls, ShouldBeEmpty)
+			})
+		})
+	})
+}
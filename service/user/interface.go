@@ -50,6 +50,9 @@ func Current(c context.Context) *User {
 // If the OAuth consumer did not make a valid OAuth request, or the scopes is
 // non-empty and the current user does not have at least one of the scopes, this
 // method will return an error.
+//
+// Implementations which can tell these cases apart return ErrOAuthInvalidToken
+// and ErrOAuthInvalidScope respectively.
 func CurrentOAuth(c context.Context, scopes ...string) (*User, error) {
 	return Raw(c).CurrentOAuth(scopes...)
 }
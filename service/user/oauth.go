@@ -0,0 +1,53 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"errors"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrOAuthInvalidToken is returned by CurrentOAuth when the request's OAuth
+	// token is not valid.
+	ErrOAuthInvalidToken = errors.New("user: invalid OAuth token")
+
+	// ErrOAuthInvalidScope is returned by CurrentOAuth when the request's OAuth
+	// token has none of the requested scopes.
+	ErrOAuthInvalidScope = errors.New("user: OAuth token has none of the requested scopes")
+
+	// ErrOAuthClientNotAllowed is returned by CurrentOAuthFromClients when the
+	// request's OAuth token was issued to a client which is not allowed.
+	ErrOAuthClientNotAllowed = errors.New("user: OAuth client is not allowed")
+)
+
+// CurrentOAuthFromClients is like CurrentOAuth, but also requires the OAuth
+// token to have been issued to one of clientIDs. API endpoints should use it
+// to reject tokens minted for other applications.
+//
+// If there is no OAuth user, it returns (nil, nil), like CurrentOAuth.
+func CurrentOAuthFromClients(c context.Context, clientIDs []string, scopes ...string) (*User, error) {
+	u, err := CurrentOAuth(c, scopes...)
+	if err != nil || u == nil {
+		return u, err
+	}
+	for _, id := range clientIDs {
+		if u.ClientID == id {
+			return u, nil
+		}
+	}
+	return nil, ErrOAuthClientNotAllowed
+}
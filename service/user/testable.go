@@ -14,6 +14,10 @@
 
 package user
 
+import (
+	"golang.org/x/net/context"
+)
+
 // Testable is the interface that test implimentations will provide.
 type Testable interface {
 	// SetUser sets the user to a pre-populated User object.
@@ -25,6 +29,42 @@ type Testable interface {
 	// like they logged in via the cookie auth method.
 	Login(email, clientID string, admin bool)
 
+	// LoginFederated is like Login, but the User will look like they logged in
+	// with the federated identity from provider.
+	LoginFederated(email, identity, provider string, admin bool)
+
 	// Equivalent to SetUser(nil), but a bit more obvious to read in the code :).
 	Logout()
+
+	// SetAdmin changes the admin flag of the logged in user. It does nothing if
+	// no user is logged in.
+	SetAdmin(admin bool)
+
+	// CompleteLogin simulates the user visiting loginURL, as returned by
+	// LoginURL: it logs in email with the cookie auth method and returns the
+	// destination the user would be redirected to.
+	CompleteLogin(loginURL, email string, admin bool) (dest string, err error)
+
+	// CompleteLogout simulates the user visiting logoutURL, as returned by
+	// LogoutURL: it logs the user out and returns the destination the user
+	// would be redirected to.
+	CompleteLogout(logoutURL string) (dest string, err error)
+
+	// WithUser returns a derivative of c in which u is the logged in user,
+	// regardless of the user set with SetUser, Login or Logout. A nil u makes
+	// the user anonymous in the returned context.
+	//
+	// This lets a single test act as different users in different requests
+	// without changing the user seen by other contexts.
+	WithUser(c context.Context, u *User) context.Context
+
+	// AddOAuthToken installs a fake OAuth bearer token, which authenticates u
+	// with the given scopes. u should have its ClientID set.
+	AddOAuthToken(token string, u *User, scopes ...string)
+
+	// SetOAuthToken sets the OAuth bearer token of the current request, which
+	// must have been added with AddOAuthToken to be valid. While it's set,
+	// CurrentOAuth authenticates with the token instead of using the logged in
+	// user. An empty token clears it.
+	SetOAuthToken(token string)
 }
@@ -0,0 +1,151 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gae
+
+import (
+	"fmt"
+	"strings"
+
+	"go.chromium.org/gae/service/capability"
+	"go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/image"
+	"go.chromium.org/gae/service/info"
+	"go.chromium.org/gae/service/logs"
+	"go.chromium.org/gae/service/mail"
+	"go.chromium.org/gae/service/memcache"
+	"go.chromium.org/gae/service/module"
+	"go.chromium.org/gae/service/runtime"
+	"go.chromium.org/gae/service/search"
+	"go.chromium.org/gae/service/socket"
+	"go.chromium.org/gae/service/storage"
+	"go.chromium.org/gae/service/taskqueue"
+	"go.chromium.org/gae/service/urlfetch"
+	"go.chromium.org/gae/service/user"
+
+	"golang.org/x/net/context"
+)
+
+// Service is the name of a service which can be installed in a Context.
+type Service string
+
+// The services which can be installed in a Context, named after their
+// packages under "go.chromium.org/gae/service".
+const (
+	Capability Service = "capability"
+	Datastore  Service = "datastore"
+	Image      Service = "image"
+	Info       Service = "info"
+	Logs       Service = "logs"
+	Mail       Service = "mail"
+	Memcache   Service = "memcache"
+	Module     Service = "module"
+	Runtime    Service = "runtime"
+	Search     Service = "search"
+	Socket     Service = "socket"
+	Storage    Service = "storage"
+	TaskQueue  Service = "taskqueue"
+	URLFetch   Service = "urlfetch"
+	User       Service = "user"
+)
+
+// AllServices is every Service, in alphabetical order.
+var AllServices = []Service{
+	Capability, Datastore, Image, Info, Logs, Mail, Memcache, Module, Runtime,
+	Search, Socket, Storage, TaskQueue, URLFetch, User,
+}
+
+// serviceInstalled maps each Service to a function which returns true if it's
+// installed in a Context.
+var serviceInstalled = map[Service]func(context.Context) bool{
+	Capability: func(c context.Context) bool { return capability.Raw(c) != nil },
+	Datastore:  func(c context.Context) bool { return datastore.Raw(c) != nil },
+	Image:      func(c context.Context) bool { return image.Raw(c) != nil },
+	Info:       func(c context.Context) bool { return info.Raw(c) != nil },
+	Logs:       func(c context.Context) bool { return logs.Raw(c) != nil },
+	Mail:       func(c context.Context) bool { return mail.Raw(c) != nil },
+	Memcache:   func(c context.Context) bool { return memcache.Raw(c) != nil },
+	Module:     func(c context.Context) bool { return module.Raw(c) != nil },
+	Runtime:    func(c context.Context) bool { return runtime.Raw(c) != nil },
+	Search:     func(c context.Context) bool { return search.Raw(c) != nil },
+	Socket:     func(c context.Context) bool { return socket.Raw(c) != nil },
+	Storage:    func(c context.Context) bool { return storage.Raw(c) != nil },
+	TaskQueue:  func(c context.Context) bool { return taskqueue.Raw(c) != nil },
+	URLFetch: func(c context.Context) (ok bool) {
+		// urlfetch.Get panics if no RoundTripper is installed.
+		defer func() {
+			if recover() != nil {
+				ok = false
+			}
+		}()
+		return urlfetch.Get(c) != nil
+	},
+	User: func(c context.Context) bool { return user.Raw(c) != nil },
+}
+
+// Installed returns the services which are installed in c, in the order of
+// AllServices.
+//
+// A service is installed if its factory is set in c, e.g. by one of the Use
+// functions of the packages under "go.chromium.org/gae/impl". Checking
+// instantiates the service, with all of its filters.
+func Installed(c context.Context) []Service {
+	var ret []Service
+	for _, s := range AllServices {
+		if serviceInstalled[s](c) {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// Missing returns those of services which aren't installed in c, in the order
+// given.
+//
+// Panics if any of services isn't one of AllServices.
+func Missing(c context.Context, services ...Service) []Service {
+	var ret []Service
+	for _, s := range services {
+		installed, ok := serviceInstalled[s]
+		if !ok {
+			panic(fmt.Errorf("gae: unknown service %q", s))
+		}
+		if !installed(c) {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// MustHave panics with a descriptive message if any of services isn't
+// installed in c.
+//
+// Using a service which isn't installed otherwise fails with a nil pointer
+// dereference deep inside the service package, so library code can call this
+// up front to make misconfigured Contexts easy to diagnose.
+func MustHave(c context.Context, services ...Service) {
+	missing := Missing(c, services...)
+	if len(missing) == 0 {
+		return
+	}
+
+	names := make([]string, len(missing))
+	for i, s := range missing {
+		names[i] = string(s)
+	}
+	panic(fmt.Errorf(
+		"gae: services not installed in the context: %s. Install an implementation "+
+			"first, e.g. with memory.Use (in tests), prod.Use or cloud.Config.Use "+
+			"from go.chromium.org/gae/impl", strings.Join(names, ", ")))
+}
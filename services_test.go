@@ -0,0 +1,62 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gae
+
+import (
+	"net/http"
+	"testing"
+
+	"go.chromium.org/gae/impl/memory"
+	"go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/urlfetch"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+func TestServices(t *testing.T) {
+	t.Parallel()
+
+	Convey("Service registry", t, func() {
+		c := context.Background()
+
+		Convey("nothing is installed in a bare context", func() {
+			So(Installed(c), ShouldBeEmpty)
+			So(Missing(c, Datastore, Info), ShouldResemble, []Service{Datastore, Info})
+			So(func() { MustHave(c, Datastore, Memcache) }, ShouldPanicLike,
+				"services not installed in the context: datastore, memcache.")
+		})
+
+		Convey("lists the services installed by memory.Use", func() {
+			c = memory.Use(c)
+			So(Installed(c), ShouldResemble, []Service{
+				Capability, Datastore, Image, Info, Logs, Mail, Memcache, Module,
+				Runtime, Search, Socket, Storage, TaskQueue, User,
+			})
+			So(Missing(c, Datastore, URLFetch), ShouldResemble, []Service{URLFetch})
+			So(func() { MustHave(c, Datastore, TaskQueue) }, ShouldNotPanic)
+			So(Missing(datastore.WithAppID(c, "other"), Datastore), ShouldBeEmpty)
+
+			c = urlfetch.Set(c, http.DefaultTransport)
+			So(Installed(c), ShouldResemble, AllServices)
+		})
+
+		Convey("rejects unknown services", func() {
+			So(func() { Missing(c, "nope") }, ShouldPanicLike, `unknown service "nope"`)
+		})
+	})
+}
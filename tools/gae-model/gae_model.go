@@ -0,0 +1,377 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"go.chromium.org/luci/common/errors"
+	"go.chromium.org/luci/common/flag/stringsetflag"
+)
+
+type app struct {
+	out io.Writer
+
+	packageName string
+	typeNames   stringsetflag.Flag
+	dir         string
+	outFile     string
+	header      string
+}
+
+const help = `Usage of %s:
+
+%s is a go-generator program that generates typed accessors for datastore
+model structs. It can be used in a go generation file like:
+
+  //go:generate gae-model -type Post -type Comment
+
+For each named struct type, it generates constants for its kind and property
+names, and helpers to make its keys, get, put and query its entities, and build
+its compound indexes. Code which uses these instead of strings fails to compile
+when the model changes and the file is regenerated.
+
+Options:
+`
+
+const copyright = `// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+`
+
+func (a *app) parseArgs(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(a.out)
+	fs.Usage = func() {
+		fmt.Fprintf(a.out, help, args[0], args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.Var(&a.typeNames, "type",
+		"A model struct type to generate accessors for (required, repeatable)")
+	fs.StringVar(&a.dir, "dir", ".",
+		"The directory of the package which defines the types")
+	fs.StringVar(&a.outFile, "out", "gae_model.gen.go",
+		"The name of the output file")
+	fs.StringVar(&a.header, "header", copyright, "Header text to put at the top of "+
+		"the generated file. Defaults to the LUCI Authors copyright.")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	fail := errors.MultiError(nil)
+	if a.typeNames.Data == nil || a.typeNames.Data.Len() == 0 {
+		fail = append(fail, errors.New("must specify one or more -type"))
+	}
+	if !strings.HasSuffix(a.outFile, ".go") {
+		fail = append(fail, errors.New("-out must end with '.go'"))
+	}
+	if len(fail) > 0 {
+		for _, e := range fail {
+			fmt.Fprintln(a.out, "error:", e)
+		}
+		fmt.Fprintln(a.out)
+		fs.Usage()
+		return fail
+	}
+	return nil
+}
+
+// property is a datastore property of a model.
+type property struct {
+	Const   string // the name of the generated constant
+	Name    string // the datastore property name
+	NoIndex bool
+}
+
+// model is a struct type to generate accessors for.
+type model struct {
+	Type      string
+	Kind      string
+	IDType    string // "int64" or "string", or "" if the model has no $id
+	HasParent bool
+	Fields    []string // exported Go fields, for the schema check
+	Props     []property
+}
+
+// parseModels finds the struct types named by typeNames in files, and parses
+// their fields.
+func parseModels(files []*ast.File, typeNames []string) ([]*model, error) {
+	specs := map[string]*ast.TypeSpec{}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.TYPE {
+				for _, spec := range gd.Specs {
+					ts := spec.(*ast.TypeSpec)
+					specs[ts.Name.Name] = ts
+				}
+			}
+		}
+	}
+
+	ret := make([]*model, 0, len(typeNames))
+	for _, name := range typeNames {
+		ts, ok := specs[name]
+		if !ok {
+			return nil, fmt.Errorf("type %q not found", name)
+		}
+		st, ok := ts.Type.(*ast.StructType)
+		if !ok {
+			return nil, fmt.Errorf("type %q is not a struct", name)
+		}
+		m, err := parseModel(name, st)
+		if err != nil {
+			return nil, errors.Annotate(err, "type %q", name).Err()
+		}
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+func parseModel(name string, st *ast.StructType) (*model, error) {
+	m := &model{Type: name, Kind: name}
+	consts := map[string]string{name + "Kind": "the kind constant"}
+	for _, field := range st.Fields.List {
+		if len(field.Names) == 0 {
+			return nil, fmt.Errorf("embedded field %s is not supported", types.ExprString(field.Type))
+		}
+
+		tag := ""
+		if field.Tag != nil {
+			lit, err := strconv.Unquote(field.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			tag = reflect.StructTag(lit).Get("gae")
+		}
+		tagName, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			tagName, opts = tag[:i], tag[i+1:]
+		}
+
+		for _, fieldName := range field.Names {
+			switch {
+			case strings.HasPrefix(tagName, "$"):
+				// Metadata, which may be unexported.
+				switch tagName {
+				case "$id":
+					m.IDType = "int64"
+					if types.ExprString(field.Type) == "string" {
+						m.IDType = "string"
+					}
+				case "$parent":
+					m.HasParent = true
+				case "$kind":
+					if opts != "" {
+						m.Kind = opts
+					}
+				}
+				continue
+
+			case !fieldName.IsExported():
+				continue
+			}
+			m.Fields = append(m.Fields, fieldName.Name)
+
+			if tagName == "-" || strings.HasSuffix(tag, ",extra") {
+				continue
+			}
+			p := property{Const: name + fieldName.Name, Name: tagName}
+			if p.Name == "" {
+				p.Name = fieldName.Name
+			}
+			for _, opt := range strings.Split(opts, ",") {
+				if opt == "noindex" {
+					p.NoIndex = true
+				}
+			}
+			if other, ok := consts[p.Const]; ok {
+				return nil, fmt.Errorf("field %s collides with %s %s", fieldName.Name, other, p.Const)
+			}
+			consts[p.Const] = "the property constant of field " + fieldName.Name
+			m.Props = append(m.Props, p)
+		}
+	}
+	return m, nil
+}
+
+var tmpl = template.Must(
+	template.New("main").Parse(`{{if .header}}{{.header}}
+{{end}}// AUTOGENERATED: Do not edit
+
+package {{.package}}
+
+import (
+	"go.chromium.org/gae/service/datastore"
+
+	"golang.org/x/net/context"
+){{range .models}}{{$t := .Type}}
+
+// {{$t}}Kind is the datastore kind of {{$t}}.
+const {{$t}}Kind = {{printf "%q" .Kind}}
+
+{{if .Props}}// Datastore property names of {{$t}}.
+const ({{range .Props}}
+	{{.Const}} = {{printf "%q" .Name}}{{if .NoIndex}} // unindexed{{end}}{{end}}
+)
+
+{{end}}{{if .IDType}}// New{{$t}}Key returns the key of the {{$t}} with id{{if .HasParent}} and parent{{end}}.
+func New{{$t}}Key(c context.Context, id {{.IDType}}{{if .HasParent}}, parent *datastore.Key{{end}}) *datastore.Key {
+	return datastore.NewKey(c, {{$t}}Kind, {{if eq .IDType "string"}}id, 0{{else}}"", id{{end}}, {{if .HasParent}}parent{{else}}nil{{end}})
+}
+
+{{end}}// Get{{$t}} gets the {{$t}} with key.
+func Get{{$t}}(c context.Context, key *datastore.Key) (*{{$t}}, error) {
+	ent := &{{$t}}{}
+	datastore.PopulateKey(ent, key)
+	if err := datastore.Get(c, ent); err != nil {
+		return nil, err
+	}
+	return ent, nil
+}
+
+// Put{{$t}} puts ents, populating their IDs if they were incomplete.
+func Put{{$t}}(c context.Context, ents ...*{{$t}}) error {
+	return datastore.Put(c, ents)
+}
+
+// New{{$t}}Query returns a query for {{$t}} entities.
+func New{{$t}}Query() *datastore.Query {
+	return datastore.NewQuery({{$t}}Kind)
+}
+
+// New{{$t}}Index returns an IndexBuilder for a compound index on {{$t}}.
+func New{{$t}}Index() *datastore.IndexBuilder {
+	return datastore.NewIndex({{$t}}Kind)
+}{{if .Fields}}
+
+// This fails to compile if the fields of {{$t}} change without regenerating
+// this file.
+func _() {
+	var x {{$t}}{{range .Fields}}
+	_ = x.{{.}}{{end}}
+}{{end}}{{end}}
+`))
+
+func (a *app) writeTo(w io.Writer) error {
+	typeNames := a.typeNames.Data.ToSlice()
+	sort.Strings(typeNames)
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, a.dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != filepath.Base(a.outFile)
+	}, 0)
+	if err != nil {
+		return err
+	}
+	pkg, ok := pkgs[a.packageName]
+	if !ok {
+		return fmt.Errorf("package %q not found in %s", a.packageName, a.dir)
+	}
+	files := make([]*ast.File, 0, len(pkg.Files))
+	for _, f := range pkg.Files {
+		files = append(files, f)
+	}
+
+	models, err := parseModels(files, typeNames)
+	if err != nil {
+		return err
+	}
+	return a.generate(w, models)
+}
+
+func (a *app) generate(w io.Writer, models []*model) error {
+	buf := bytes.Buffer{}
+	err := tmpl.Execute(&buf, map[string]interface{}{
+		"package": a.packageName,
+		"models":  models,
+		"header":  a.header,
+	})
+	if err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return errors.Annotate(err, "generated invalid code").Err()
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func (a *app) main() {
+	if err := a.parseArgs(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), os.Args); err != nil {
+		os.Exit(1)
+	}
+	ofile, err := os.Create(a.outFile)
+	if err != nil {
+		fmt.Fprintf(a.out, "error: %s", err)
+		os.Exit(2)
+	}
+	closeFn := func(delete bool) {
+		if ofile != nil {
+			if err := ofile.Close(); err != nil {
+				fmt.Fprintf(a.out, "error while closing file: %s", err)
+			}
+			if delete {
+				if err := os.Remove(a.outFile); err != nil {
+					fmt.Fprintf(a.out, "failed to remove file!")
+				}
+			}
+		}
+		ofile = nil
+	}
+	defer closeFn(false)
+	buf := bufio.NewWriter(ofile)
+	err = a.writeTo(buf)
+	if err != nil {
+		fmt.Fprintf(a.out, "error while writing: %s", err)
+		closeFn(true)
+		os.Exit(3)
+	}
+	if err := buf.Flush(); err != nil {
+		fmt.Fprintf(a.out, "error while writing: %s", err)
+		closeFn(true)
+		os.Exit(4)
+	}
+}
+
+func main() {
+	(&app{out: os.Stderr, packageName: os.Getenv("GOPACKAGE")}).main()
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+const modelSrc = `package models
+
+type Post struct {
+	_kind  string         ` + "`gae:\"$kind,BlogPost\"`" + `
+	ID     int64          ` + "`gae:\"$id\"`" + `
+	Parent *datastore.Key ` + "`gae:\"$parent\"`" + `
+
+	Title   string
+	Body    string ` + "`gae:\"body,noindex\"`" + `
+	Ignored string ` + "`gae:\"-\"`" + `
+	private string
+}
+
+type Tag struct {
+	Name string ` + "`gae:\"$id\"`" + `
+}
+
+type Bad struct {
+	Kind string
+}
+
+type NotAStruct int
+`
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	Convey("gae-model", t, func() {
+		f, err := parser.ParseFile(token.NewFileSet(), "models.go", modelSrc, 0)
+		So(err, ShouldBeNil)
+		files := []*ast.File{f}
+
+		Convey("parses models", func() {
+			models, err := parseModels(files, []string{"Post", "Tag"})
+			So(err, ShouldBeNil)
+			So(models, ShouldResemble, []*model{
+				{
+					Type:      "Post",
+					Kind:      "BlogPost",
+					IDType:    "int64",
+					HasParent: true,
+					Fields:    []string{"Title", "Body", "Ignored"},
+					Props: []property{
+						{Const: "PostTitle", Name: "Title"},
+						{Const: "PostBody", Name: "body", NoIndex: true},
+					},
+				},
+				{Type: "Tag", Kind: "Tag", IDType: "string"},
+			})
+
+			Convey("and generates valid code", func() {
+				a := &app{packageName: "models"}
+				buf := bytes.Buffer{}
+				So(a.generate(&buf, models), ShouldBeNil)
+
+				out := buf.String()
+				So(out, ShouldContainSubstring, `const PostKind = "BlogPost"`)
+				So(out, ShouldContainSubstring, `PostBody  = "body" // unindexed`)
+				So(out, ShouldContainSubstring,
+					"func NewPostKey(c context.Context, id int64, parent *datastore.Key) *datastore.Key {\n"+
+						"\treturn datastore.NewKey(c, PostKind, \"\", id, parent)")
+				So(out, ShouldContainSubstring,
+					"func NewTagKey(c context.Context, id string) *datastore.Key {\n"+
+						"\treturn datastore.NewKey(c, TagKind, id, 0, nil)")
+				So(out, ShouldContainSubstring, "func GetTag(c context.Context, key *datastore.Key) (*Tag, error) {")
+				So(out, ShouldContainSubstring, "\t_ = x.Ignored\n")
+
+				_, err := parser.ParseFile(token.NewFileSet(), "gae_model.gen.go", out, 0)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("rejects bad types", func() {
+			_, err := parseModels(files, []string{"Nope"})
+			So(err, ShouldErrLike, `type "Nope" not found`)
+
+			_, err = parseModels(files, []string{"NotAStruct"})
+			So(err, ShouldErrLike, "not a struct")
+
+			_, err = parseModels(files, []string{"Bad"})
+			So(err, ShouldErrLike, "field Kind collides with the kind constant BadKind")
+		})
+	})
+}
@@ -0,0 +1,345 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/datastore/backup"
+	"go.chromium.org/gae/service/datastore/meta"
+	"go.chromium.org/gae/service/info"
+
+	"go.chromium.org/luci/common/data/stringset"
+	"go.chromium.org/luci/common/errors"
+
+	"golang.org/x/net/context"
+)
+
+type app struct {
+	out io.Writer
+
+	appID     string
+	namespace string
+	command   string
+	files     []string
+
+	c context.Context
+}
+
+const help = `Usage of %s:
+
+  %s [options] [export-data-file...]
+
+%s is a shell to explore datastore snapshots. It loads the data files
+("output-N") of Datastore managed exports (e.g. from Cloud Storage, or written
+by the "backup" package) into an in-memory datastore, and then runs the
+commands read from stdin, or the one given with -e. Type "help" for the list
+of commands.
+
+Options:
+`
+
+const commandHelp = `Commands:
+  get KEY           print the entity with the key KEY, either a websafe
+                    encoded key or KEY(kind, id, ...)
+  query GQL         print the entities returned by the GQL query
+  count GQL         print the number of entities returned by the GQL query
+  indexes GQL       print the compound indexes which the GQL query needs
+  kinds             print the kinds in the current namespace
+  namespaces        print the namespaces
+  ns [NAMESPACE]    change the current namespace
+  help              print this help
+  quit              exit
+
+GQL queries are of the form:
+  SELECT [DISTINCT] * | __key__ | prop, ... [FROM kind]
+    [WHERE prop op value [AND ...]] [ORDER BY prop [ASC|DESC], ...]
+    [LIMIT n] [OFFSET n]
+`
+
+// errQuit is returned by exec to stop the shell.
+var errQuit = errors.New("quit")
+
+func (a *app) parseArgs(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(a.out)
+	fs.Usage = func() {
+		fmt.Fprintf(a.out, help, args[0], args[0], args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&a.appID, "app", "dev~app", "The app ID of the datastore")
+	fs.StringVar(&a.namespace, "namespace", "", "The initial namespace")
+	fs.StringVar(&a.command, "e", "", "A command to run instead of reading them from stdin")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	a.files = fs.Args()
+	return nil
+}
+
+// setUp installs a memory datastore in a.c, loaded with a.files. It adds the
+// compound indexes which queries need automatically.
+func (a *app) setUp() error {
+	c := memory.UseWithAppID(context.Background(), a.appID)
+	t := ds.GetTestable(c)
+	t.Consistent(true)
+	t.AutoIndex(true)
+
+	for _, name := range a.files {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		n, err := backup.ImportManagedExport(c, f, 0)
+		f.Close()
+		if err != nil {
+			return errors.Annotate(err, "failed to load %q", name).Err()
+		}
+		fmt.Fprintf(a.out, "loaded %d entities from %s\n", n, name)
+	}
+
+	return a.setNamespace(c, a.namespace)
+}
+
+func (a *app) setNamespace(c context.Context, ns string) error {
+	c, err := info.Namespace(c, ns)
+	if err != nil {
+		return err
+	}
+	a.c, a.namespace = c, ns
+	return nil
+}
+
+// exec runs a single command line.
+func (a *app) exec(line string) error {
+	line = strings.TrimSpace(line)
+	cmd, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	switch strings.ToLower(cmd) {
+	case "":
+		return nil
+
+	case "help":
+		fmt.Fprint(a.out, commandHelp)
+		return nil
+
+	case "quit", "exit":
+		return errQuit
+
+	case "get":
+		return a.get(arg)
+
+	case "query", "count", "indexes":
+		q, err := parseGQL(ds.GetKeyContext(a.c), arg)
+		if err != nil {
+			return errors.Annotate(err, "bad query").Err()
+		}
+		switch strings.ToLower(cmd) {
+		case "query":
+			return ds.Run(a.c, q, func(pm ds.PropertyMap) error {
+				return a.printEntity(pm)
+			})
+		case "count":
+			n, err := ds.Count(a.c, q)
+			if err == nil {
+				fmt.Fprintln(a.out, n)
+			}
+			return err
+		default:
+			return a.indexes(q)
+		}
+
+	case "kinds":
+		kinds := stringset.New(0)
+		err := ds.Run(a.c, ds.NewQuery(""), func(k *ds.Key) {
+			if kind := k.Kind(); !strings.HasPrefix(kind, "__") {
+				kinds.Add(kind)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return a.printSorted(kinds.ToSlice())
+
+	case "namespaces":
+		var nss []string
+		err := meta.Namespaces(a.c, func(ns string) error {
+			nss = append(nss, fmt.Sprintf("%q", ns))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return a.printSorted(nss)
+
+	case "ns":
+		return a.setNamespace(a.c, arg)
+
+	default:
+		return fmt.Errorf("unknown command %q (try \"help\")", cmd)
+	}
+}
+
+func (a *app) get(arg string) error {
+	var key *ds.Key
+	if strings.HasPrefix(strings.ToUpper(arg), "KEY") {
+		toks, err := tokenize(arg)
+		if err != nil {
+			return err
+		}
+		p := &gqlParser{kc: ds.GetKeyContext(a.c), toks: toks, pos: 1}
+		if key, err = p.key(); err != nil {
+			return errors.Annotate(err, "bad key").Err()
+		}
+	} else {
+		var err error
+		if key, err = ds.NewKeyEncoded(arg); err != nil {
+			return errors.Annotate(err, "bad key").Err()
+		}
+	}
+
+	c := a.c
+	if ns := key.Namespace(); ns != a.namespace {
+		var err error
+		if c, err = info.Namespace(c, ns); err != nil {
+			return err
+		}
+	}
+	pm := ds.PropertyMap{}
+	ds.PopulateKey(pm, key)
+	if err := ds.Get(c, pm); err != nil {
+		return err
+	}
+	return a.printEntity(pm)
+}
+
+// indexes prints the compound indexes which q needs. It runs q against an
+// empty datastore, adding the missing indexes it reports one by one.
+func (a *app) indexes(q *ds.Query) error {
+	c := memory.UseWithAppID(context.Background(), a.appID)
+	// Indexes added to an eventually consistent datastore aren't seen by
+	// queries until the next write.
+	ds.GetTestable(c).Consistent(true)
+	c, err := info.Namespace(c, a.namespace)
+	if err != nil {
+		return err
+	}
+
+	var idxs []*ds.IndexDefinition
+	for {
+		err := ds.Run(c, q, func(ds.PropertyMap) {})
+		switch e := err.(type) {
+		case nil:
+			if len(idxs) == 0 {
+				fmt.Fprintln(a.out, "# The built-in indexes are enough.")
+				return nil
+			}
+			return ds.WriteIndexYAML(a.out, idxs...)
+
+		case *memory.ErrMissingIndex:
+			idxs = append(idxs, e.Missing)
+			ds.GetTestable(c).AddIndexes(e.Missing)
+
+		default:
+			return err
+		}
+	}
+}
+
+func (a *app) printEntity(pm ds.PropertyMap) error {
+	props, err := pm.Save(false)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(struct {
+		Key        string         `json:"key"`
+		Properties ds.PropertyMap `json:"properties"`
+	}{ds.GetMetaDefault(pm, "key", nil).(*ds.Key).String(), props}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(a.out, "%s\n", data)
+	return err
+}
+
+func (a *app) printSorted(lines []string) error {
+	sort.Strings(lines)
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(a.out, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run runs the commands read from in, until it's exhausted or one of them is
+// "quit". Errors of the commands are printed, and don't stop the shell.
+func (a *app) run(in io.Reader, prompt bool) error {
+	s := bufio.NewScanner(in)
+	for {
+		if prompt {
+			fmt.Fprintf(a.out, "%s> ", a.namespace)
+		}
+		if !s.Scan() {
+			return s.Err()
+		}
+		switch err := a.exec(s.Text()); err {
+		case nil:
+		case errQuit:
+			return nil
+		default:
+			fmt.Fprintln(a.out, "error:", err)
+		}
+	}
+}
+
+func (a *app) main() {
+	if err := a.parseArgs(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), os.Args); err != nil {
+		os.Exit(1)
+	}
+	if err := a.setUp(); err != nil {
+		fmt.Fprintln(a.out, "error:", err)
+		os.Exit(2)
+	}
+
+	if a.command != "" {
+		if err := a.exec(a.command); err != nil && err != errQuit {
+			fmt.Fprintln(a.out, "error:", err)
+			os.Exit(3)
+		}
+		return
+	}
+	if err := a.run(os.Stdin, true); err != nil {
+		fmt.Fprintln(a.out, "error:", err)
+		os.Exit(4)
+	}
+}
+
+func main() {
+	(&app{out: os.Stdout}).main()
+}
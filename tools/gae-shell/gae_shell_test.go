@@ -0,0 +1,166 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.chromium.org/gae/impl/memory"
+	ds "go.chromium.org/gae/service/datastore"
+	"go.chromium.org/gae/service/datastore/backup"
+
+	"golang.org/x/net/context"
+
+	. "github.com/smartystreets/goconvey/convey"
+	. "go.chromium.org/luci/common/testing/assertions"
+)
+
+type Post struct {
+	ID     int64   `gae:"$id"`
+	Parent *ds.Key `gae:"$parent"`
+	Title  string
+	Score  int64
+}
+
+func TestParseGQL(t *testing.T) {
+	t.Parallel()
+
+	Convey("parseGQL", t, func() {
+		kc := ds.MkKeyContext("dev~app", "")
+		parse := func(s string) string {
+			q, err := parseGQL(kc, s)
+			So(err, ShouldBeNil)
+			return q.String()
+		}
+
+		So(parse("SELECT * FROM Post"), ShouldEqual, ds.NewQuery("Post").String())
+		So(parse("select __key__ from `Post`"), ShouldEqual, ds.NewQuery("Post").KeysOnly(true).String())
+		So(parse("SELECT DISTINCT Title, Score FROM Post"), ShouldEqual,
+			ds.NewQuery("Post").Distinct(true).Project("Title", "Score").String())
+		So(parse(`SELECT * FROM Post WHERE Title = 'it''s' AND Score >= 1.5 AND Score < 10 `+
+			`AND __key__ HAS ANCESTOR KEY(Blog, 'b', "Year", 2017) ORDER BY Score DESC, Title ASC LIMIT 5 OFFSET 2`),
+			ShouldEqual,
+			ds.NewQuery("Post").Eq("Title", "it's").Gte("Score", 1.5).Lt("Score", int64(10)).
+				Ancestor(kc.MakeKey("Blog", "b", "Year", 2017)).Order("-Score", "Title").Limit(5).Offset(2).String())
+		So(parse("SELECT * WHERE Gone = null AND When > DATETIME('2017-01-02T03:04:05Z')"), ShouldEqual,
+			ds.NewQuery("").Eq("Gone", nil).Gt("When", time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)).String())
+		So(parse("SELECT * WHERE Flag = TRUE AND Flag = false"), ShouldEqual,
+			ds.NewQuery("").Eq("Flag", true, false).String())
+
+		bad := func(s string) error {
+			_, err := parseGQL(kc, s)
+			return err
+		}
+		So(bad("SELECT"), ShouldErrLike, "unexpected end of query")
+		So(bad("DELETE * FROM Post"), ShouldErrLike, "expected SELECT")
+		So(bad("SELECT * FROM Post WHERE A ! 1"), ShouldErrLike, `unexpected '!'`)
+		So(bad("SELECT * FROM Post WHERE A = 'x"), ShouldErrLike, "unterminated")
+		So(bad("SELECT * FROM Post LIMIT x"), ShouldErrLike, "bad count x")
+		So(bad("SELECT * FROM Post junk"), ShouldErrLike, "expected end of query, got junk")
+		So(bad("SELECT * FROM Post WHERE A HAS ANCESTOR 1"), ShouldErrLike, "HAS ANCESTOR needs __key__ and a KEY")
+	})
+}
+
+func TestShell(t *testing.T) {
+	t.Parallel()
+
+	Convey("gae-shell", t, func() {
+		dir, err := ioutil.TempDir("", "gae-shell")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		// Export some entities to load in the shell.
+		c := memory.UseWithAppID(context.Background(), "dev~app")
+		ds.GetTestable(c).Consistent(true)
+		blog := ds.MakeKey(c, "Blog", "b")
+		So(ds.Put(c, []*Post{
+			{ID: 1, Parent: blog, Title: "one", Score: 10},
+			{ID: 2, Parent: blog, Title: "two", Score: 20},
+			{ID: 3, Title: "three", Score: 30},
+		}), ShouldBeNil)
+		buf := bytes.Buffer{}
+		_, err = (&backup.Exporter{Format: backup.ManagedExport, Kinds: []string{"Post"}}).Export(c, &buf)
+		So(err, ShouldBeNil)
+		path := filepath.Join(dir, "output-0")
+		So(ioutil.WriteFile(path, buf.Bytes(), 0644), ShouldBeNil)
+
+		out := bytes.Buffer{}
+		a := &app{out: &out, appID: "dev~app", files: []string{path}}
+		So(a.setUp(), ShouldBeNil)
+		So(out.String(), ShouldEqual, "loaded 3 entities from "+path+"\n")
+		out.Reset()
+
+		run := func(cmds ...string) string {
+			out.Reset()
+			So(a.run(strings.NewReader(strings.Join(cmds, "\n")), false), ShouldBeNil)
+			return out.String()
+		}
+
+		Convey("get", func() {
+			So(run("get KEY(Blog, 'b', Post, 1)"), ShouldEqual, `{
+  "key": "dev~app::/Blog,\"b\"/Post,1",
+  "properties": {
+    "Score": {
+      "type": "PTInt",
+      "value": 10
+    },
+    "Title": {
+      "type": "PTString",
+      "value": "one"
+    }
+  }
+}
+`)
+			So(run("get "+ds.MakeKey(c, "Post", 3).Encode()), ShouldContainSubstring, `"value": "three"`)
+			So(run("get KEY(Post, 4)"), ShouldEqual, "error: datastore: no such entity\n")
+		})
+
+		Convey("query and count", func() {
+			So(run("query SELECT __key__ FROM Post WHERE Score > 10 ORDER BY Score DESC"), ShouldEqual,
+				"{\n  \"key\": \"dev~app::/Post,3\",\n  \"properties\": {}\n}\n"+
+					"{\n  \"key\": \"dev~app::/Blog,\\\"b\\\"/Post,2\",\n  \"properties\": {}\n}\n")
+			So(run("count SELECT * FROM Post WHERE __key__ HAS ANCESTOR KEY(Blog, 'b')"), ShouldEqual, "2\n")
+			So(run("count SELECT * FROM Post WHERE"), ShouldEqual, "error: bad query: unexpected end of query\n")
+		})
+
+		Convey("indexes", func() {
+			So(run("indexes SELECT * FROM Post WHERE Score > 1"), ShouldEqual, "# The built-in indexes are enough.\n")
+			So(run("indexes SELECT * FROM Post WHERE Title = 'x' ORDER BY Score DESC"), ShouldEqual, `indexes:
+
+- kind: Post
+  properties:
+  - name: Title
+  - name: Score
+    direction: desc
+`)
+		})
+
+		Convey("kinds, namespaces and ns", func() {
+			So(run("kinds"), ShouldEqual, "Post\n")
+			So(run("ns other", "kinds", "namespaces", "ns", "count SELECT * FROM Post"), ShouldEqual, "\"\"\n3\n")
+		})
+
+		Convey("help, unknown commands and quit", func() {
+			So(run("help"), ShouldEqual, commandHelp)
+			So(run("nope", "quit", "help"), ShouldEqual, "error: unknown command \"nope\" (try \"help\")\n")
+		})
+	})
+}
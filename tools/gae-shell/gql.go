@@ -0,0 +1,450 @@
+// Copyright 2017 The LUCI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ds "go.chromium.org/gae/service/datastore"
+)
+
+// token is a lexical token of a GQL statement.
+type token struct {
+	typ  byte // 'i'dentifier, 's'tring, 'n'umber or 'p'unctuation
+	text string
+}
+
+func (t token) String() string {
+	if t.typ == 's' {
+		return strconv.Quote(t.text)
+	}
+	return t.text
+}
+
+func isIdentByte(c byte, first bool) bool {
+	switch {
+	case c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9' || c == '.':
+		return !first
+	}
+	return false
+}
+
+func tokenize(s string) ([]token, error) {
+	var ret []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '\'' || c == '"' || c == '`':
+			// A doubled quote is an escaped quote.
+			buf := strings.Builder{}
+			j := i + 1
+			for ; j < len(s); j++ {
+				if s[j] == c {
+					if j+1 < len(s) && s[j+1] == c {
+						buf.WriteByte(c)
+						j++
+						continue
+					}
+					break
+				}
+				buf.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return nil, fmt.Errorf("unterminated %c at %d", c, i)
+			}
+			typ := byte('s')
+			if c == '`' {
+				typ = 'i'
+			}
+			ret = append(ret, token{typ, buf.String()})
+			i = j + 1
+
+		case isIdentByte(c, true):
+			j := i + 1
+			for j < len(s) && isIdentByte(s[j], false) {
+				j++
+			}
+			ret = append(ret, token{'i', s[i:j]})
+			i = j
+
+		case c == '-' || c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(s) && strings.IndexByte("0123456789.eE", s[j]) >= 0 {
+				j++
+			}
+			ret = append(ret, token{'n', s[i:j]})
+			i = j
+
+		case c == '<' || c == '>':
+			if i+1 < len(s) && s[i+1] == '=' {
+				ret = append(ret, token{'p', s[i : i+2]})
+				i += 2
+			} else {
+				ret = append(ret, token{'p', s[i : i+1]})
+				i++
+			}
+
+		case strings.IndexByte("=(),*", c) >= 0:
+			ret = append(ret, token{'p', s[i : i+1]})
+			i++
+
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	return ret, nil
+}
+
+// gqlParser parses a GQL statement.
+type gqlParser struct {
+	kc   ds.KeyContext
+	toks []token
+	pos  int
+}
+
+func (p *gqlParser) peek() (token, bool) {
+	if p.pos == len(p.toks) {
+		return token{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+func (p *gqlParser) next() (token, error) {
+	t, ok := p.peek()
+	if !ok {
+		return t, fmt.Errorf("unexpected end of query")
+	}
+	p.pos++
+	return t, nil
+}
+
+// keyword consumes the next token if it's the (case-insensitive) keyword kw.
+func (p *gqlParser) keyword(kw string) bool {
+	if t, ok := p.peek(); ok && t.typ == 'i' && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expectKeyword(kw string) error {
+	if !p.keyword(kw) {
+		return p.unexpected(kw)
+	}
+	return nil
+}
+
+// punct consumes the next token if it's the punctuation s.
+func (p *gqlParser) punct(s string) bool {
+	if t, ok := p.peek(); ok && t.typ == 'p' && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expectPunct(s string) error {
+	if !p.punct(s) {
+		return p.unexpected(fmt.Sprintf("%q", s))
+	}
+	return nil
+}
+
+func (p *gqlParser) ident() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if t.typ != 'i' {
+		p.pos--
+		return "", p.unexpected("a name")
+	}
+	return t.text, nil
+}
+
+func (p *gqlParser) unexpected(want string) error {
+	t, ok := p.peek()
+	if !ok {
+		return fmt.Errorf("expected %s, got end of query", want)
+	}
+	return fmt.Errorf("expected %s, got %s", want, t)
+}
+
+// parseGQL parses a GQL SELECT statement into a query. It supports the
+// subset of GQL which maps onto ds.Query:
+//
+//	SELECT [DISTINCT] * | __key__ | prop, ...
+//	  [FROM kind]
+//	  [WHERE cond [AND cond ...]]
+//	  [ORDER BY prop [ASC | DESC], ...]
+//	  [LIMIT n] [OFFSET n]
+//
+// where cond is "prop op value", with op one of =, <, <=, > and >=, or
+// "__key__ HAS ANCESTOR value". Values are integers, floats, quoted strings,
+// true, false, null, KEY(kind, id, ...) and DATETIME('RFC 3339 time'). Names
+// may be quoted with backquotes. Keys are made in kc.
+func parseGQL(kc ds.KeyContext, s string) (*ds.Query, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &gqlParser{kc: kc, toks: toks}
+	q, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.peek(); ok {
+		return nil, p.unexpected("end of query")
+	}
+	return q, nil
+}
+
+func (p *gqlParser) parseSelect() (*ds.Query, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	distinct := p.keyword("DISTINCT")
+
+	var project []string
+	keysOnly := false
+	if !p.punct("*") {
+		for {
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			project = append(project, name)
+			if !p.punct(",") {
+				break
+			}
+		}
+		if len(project) == 1 && project[0] == "__key__" {
+			project, keysOnly = nil, true
+		}
+	}
+
+	kind := ""
+	if p.keyword("FROM") {
+		var err error
+		if kind, err = p.ident(); err != nil {
+			return nil, err
+		}
+	}
+
+	q := ds.NewQuery(kind).KeysOnly(keysOnly).Distinct(distinct)
+	if len(project) > 0 {
+		q = q.Project(project...)
+	}
+
+	if p.keyword("WHERE") {
+		for {
+			var err error
+			if q, err = p.parseCond(q); err != nil {
+				return nil, err
+			}
+			if !p.keyword("AND") {
+				break
+			}
+		}
+	}
+
+	if p.keyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			if p.keyword("DESC") {
+				name = "-" + name
+			} else {
+				p.keyword("ASC")
+			}
+			q = q.Order(name)
+			if !p.punct(",") {
+				break
+			}
+		}
+	}
+
+	if p.keyword("LIMIT") {
+		n, err := p.count()
+		if err != nil {
+			return nil, err
+		}
+		q = q.Limit(n)
+	}
+	if p.keyword("OFFSET") {
+		n, err := p.count()
+		if err != nil {
+			return nil, err
+		}
+		q = q.Offset(n)
+	}
+	return q, nil
+}
+
+func (p *gqlParser) count() (int32, error) {
+	t, err := p.next()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(t.text, 10, 32)
+	if t.typ != 'n' || err != nil || n < 0 {
+		return 0, fmt.Errorf("bad count %s", t)
+	}
+	return int32(n), nil
+}
+
+func (p *gqlParser) parseCond(q *ds.Query) (*ds.Query, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.keyword("HAS") {
+		if err := p.expectKeyword("ANCESTOR"); err != nil {
+			return nil, err
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		k, ok := v.(*ds.Key)
+		if !ok || name != "__key__" {
+			return nil, fmt.Errorf("HAS ANCESTOR needs __key__ and a KEY")
+		}
+		return q.Ancestor(k), nil
+	}
+
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	switch t.text {
+	case "=":
+		return q.Eq(name, v), nil
+	case "<":
+		return q.Lt(name, v), nil
+	case "<=":
+		return q.Lte(name, v), nil
+	case ">":
+		return q.Gt(name, v), nil
+	case ">=":
+		return q.Gte(name, v), nil
+	}
+	return nil, fmt.Errorf("unsupported operator %s", t)
+}
+
+func (p *gqlParser) value() (interface{}, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch t.typ {
+	case 's':
+		return t.text, nil
+
+	case 'n':
+		if strings.ContainsAny(t.text, ".eE") {
+			return strconv.ParseFloat(t.text, 64)
+		}
+		return strconv.ParseInt(t.text, 10, 64)
+
+	case 'i':
+		switch strings.ToUpper(t.text) {
+		case "TRUE":
+			return true, nil
+		case "FALSE":
+			return false, nil
+		case "NULL":
+			return nil, nil
+		case "KEY":
+			return p.key()
+		case "DATETIME":
+			if err := p.expectPunct("("); err != nil {
+				return nil, err
+			}
+			s, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			return time.Parse(time.RFC3339Nano, s.text)
+		}
+	}
+	p.pos--
+	return nil, p.unexpected("a value")
+}
+
+// key parses the arguments of KEY(kind, id, ...).
+func (p *gqlParser) key() (*ds.Key, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	var toks []ds.KeyTok
+	for {
+		kind, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if kind.typ != 'i' && kind.typ != 's' {
+			return nil, fmt.Errorf("bad kind %s", kind)
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+		id, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		tok := ds.KeyTok{Kind: kind.text}
+		switch id.typ {
+		case 's':
+			tok.StringID = id.text
+		case 'n':
+			if tok.IntID, err = strconv.ParseInt(id.text, 10, 64); err != nil {
+				return nil, fmt.Errorf("bad id %s", id)
+			}
+		default:
+			return nil, fmt.Errorf("bad id %s", id)
+		}
+		toks = append(toks, tok)
+
+		if p.punct(")") {
+			return p.kc.NewKeyToks(toks), nil
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+	}
+}